@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/observer"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// capturedMessage is one message from a capture file
+type capturedMessage struct {
+	command string
+	payload []byte
+}
+
+// benchResult is the throughput report, also emitted as JSON with -json so
+// CI can track it between commits
+type benchResult struct {
+	File           string         `json:"file"`
+	Loops          int            `json:"loops"`
+	Messages       int            `json:"messages"`
+	Bytes          int64          `json:"bytes"`
+	ElapsedSec     float64        `json:"elapsed_sec"`
+	MessagesPerSec float64        `json:"messages_per_sec"`
+	MBPerSec       float64        `json:"mb_per_sec"`
+	TxPerSec       float64        `json:"tx_per_sec"`
+	ParseErrors    int            `json:"parse_errors"`
+	DBErrors       int            `json:"db_errors"`
+	DBWrites       bool           `json:"db_writes"`
+	AllocBytes     uint64         `json:"alloc_bytes"`
+	ByCommand      map[string]int `json:"by_command"`
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	loops := fs.Int("loops", 1, "number of times to replay the capture")
+	configPath := fs.String("db", "", "observer config file; when set, parsed txs and blocks are also written to its database")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens bench [flags] <capture-file>")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "The capture is either raw wire messages back to back (24-byte header + payload),")
+		fmt.Fprintln(os.Stderr, "or a per-peer debug log written by the observer (JSON lines with command and payload).")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *loops < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	msgs, err := loadCapture(path)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("%s: no messages", path)
+	}
+
+	var db *database.DB
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		db, err = database.NewFromConfig(&cfg.Config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	res := benchResult{File: path, Loops: *loops, DBWrites: db != nil, ByCommand: make(map[string]int)}
+	txs := 0
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for l := 0; l < *loops; l++ {
+		for _, m := range msgs {
+			res.Messages++
+			res.Bytes += int64(len(m.payload))
+			res.ByCommand[m.command]++
+			n, parseErr, dbErr := replay(m, db)
+			txs += n
+			if parseErr {
+				res.ParseErrors++
+			}
+			if dbErr {
+				res.DBErrors++
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	res.ElapsedSec = elapsed.Seconds()
+	res.MessagesPerSec = float64(res.Messages) / elapsed.Seconds()
+	res.MBPerSec = float64(res.Bytes) / 1e6 / elapsed.Seconds()
+	res.TxPerSec = float64(txs) / elapsed.Seconds()
+	res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	printResult(res)
+	return nil
+}
+
+// replay runs one message through the same parse/dedup/store steps the
+// observer's message loop uses. It returns the number of txs processed.
+func replay(m capturedMessage, db *database.DB) (txs int, parseErr, dbErr bool) {
+	switch m.command {
+	case "inv":
+		inv := protocol.ParseInvMessage(m.payload)
+		for _, v := range inv.TxVectors {
+			observer.MarkSeenTx(v.Hash)
+		}
+		for _, v := range inv.BlockVectors {
+			observer.MarkSeenBlock(v.Hash)
+		}
+
+	case "tx":
+		tx, err := protocol.ParseTxMessage(m.payload)
+		if err != nil {
+			return 0, true, false
+		}
+		if db != nil && db.RecordTransaction(tx) != nil {
+			dbErr = true
+		}
+		return 1, false, dbErr
+
+	case "block":
+		block, err := protocol.ParseBlockMessage(m.payload)
+		if err != nil {
+			return 0, true, false
+		}
+		if db != nil {
+			if db.RecordBlock(block, "bench") != nil {
+				dbErr = true
+			}
+			for _, tx := range block.Transactions {
+				if db.RecordTransaction(tx) != nil {
+					dbErr = true
+				}
+			}
+		}
+		return len(block.Transactions), false, dbErr
+
+	case "version":
+		if _, err := protocol.ParseVersionMessage(m.payload); err != nil {
+			return 0, true, false
+		}
+
+	case "addr":
+		protocol.ParseAddrMessage(m.payload)
+	}
+	return 0, false, false
+}
+
+func printResult(r benchResult) {
+	fmt.Printf("file:        %s (x%d)\n", r.File, r.Loops)
+	fmt.Printf("messages:    %d (%.1f MB)\n", r.Messages, float64(r.Bytes)/1e6)
+	fmt.Printf("elapsed:     %.3fs\n", r.ElapsedSec)
+	fmt.Printf("throughput:  %.0f msg/s, %.1f MB/s, %.0f tx/s\n", r.MessagesPerSec, r.MBPerSec, r.TxPerSec)
+	fmt.Printf("allocated:   %.1f MB\n", float64(r.AllocBytes)/1e6)
+	fmt.Printf("errors:      %d parse, %d db (db writes: %v)\n", r.ParseErrors, r.DBErrors, r.DBWrites)
+
+	commands := make([]string, 0, len(r.ByCommand))
+	for c := range r.ByCommand {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return r.ByCommand[commands[i]] > r.ByCommand[commands[j]] })
+	for _, c := range commands {
+		fmt.Printf("  %-12s %d\n", c, r.ByCommand[c])
+	}
+}
+
+// loadCapture reads the whole capture into memory so replay speed isn't
+// bounded by disk
+func loadCapture(path string) ([]capturedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if first[0] == '{' {
+		return loadTraceLog(r)
+	}
+	return loadRawCapture(r)
+}
+
+func loadRawCapture(r io.Reader) ([]capturedMessage, error) {
+	var msgs []capturedMessage
+	for {
+		msg, err := protocol.ReadMessage(r)
+		if errors.Is(err, io.EOF) {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", len(msgs), err)
+		}
+		msgs = append(msgs, capturedMessage{command: protocol.CommandString(msg), payload: msg.Payload})
+	}
+}
+
+// loadTraceLog reads a per-peer debug log, skipping lines that aren't
+// message traces
+func loadTraceLog(r io.Reader) ([]capturedMessage, error) {
+	var msgs []capturedMessage
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry struct {
+			Command string `json:"command"`
+			Payload string `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Command == "" {
+			continue
+		}
+		payload, err := hex.DecodeString(strings.TrimSpace(entry.Payload))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msgs = append(msgs, capturedMessage{command: entry.Command, payload: payload})
+	}
+	return msgs, scanner.Err()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/keato/btc-observer/internal/census"
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// crawlGossipSeeds caps the stored gossiped addresses used as seeds, per
+// address family
+const crawlGossipSeeds = 20000
+
+func runCrawl(args []string) error {
+	opts := census.DefaultOptions
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	configPath := fs.String("db", "config.json", "observer config file with the database and network")
+	seedList := fs.String("seeds", "", "extra comma-separated host:port addresses to start from")
+	interval := fs.Duration("interval", 0, "repeat the crawl this often (0 crawls once)")
+	noGeo := fs.Bool("no-geo", false, "skip geolocating reachable nodes")
+	fs.IntVar(&opts.Concurrency, "concurrency", opts.Concurrency, "nodes contacted at once")
+	fs.DurationVar(&opts.Timeout, "timeout", opts.Timeout, "time allowed per node for connect, handshake and getaddr reply")
+	fs.IntVar(&opts.MaxNodes, "max-nodes", opts.MaxNodes, "stop queuing new addresses after this many")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens crawl [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Handshakes with every reachable node it can discover, starting from the DNS")
+		fmt.Fprintln(os.Stderr, "seeds and stored gossiped addresses, and stores a census snapshot of their")
+		fmt.Fprintln(os.Stderr, "versions, services, heights and locations.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || opts.Concurrency <= 0 || opts.Timeout <= 0 || opts.MaxNodes <= 0 || *interval < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	network, err := cfg.NetworkParams()
+	if err != nil {
+		return err
+	}
+	protocol.SetNetwork(network)
+	db, err := database.NewFromConfig(&cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		if err := crawlOnce(ctx, db, network, opts, *seedList, !*noGeo); err != nil {
+			return err
+		}
+		if *interval == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func crawlOnce(ctx context.Context, db *database.DB, network *protocol.NetworkParams, opts census.Options, seedList string, geolocate bool) error {
+	seeds := crawlSeeds(db, network, seedList)
+	if len(seeds) == 0 {
+		return fmt.Errorf("no seed addresses for %s; pass -seeds", network.Name)
+	}
+	fmt.Fprintf(os.Stderr, "crawling %s from %d seeds\n", network.Name, len(seeds))
+
+	c := census.New(opts)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p := c.Progress()
+				fmt.Fprintf(os.Stderr, "attempted %d, reachable %d, queued %d\n", p.Attempted, p.Reachable, p.Queued)
+			}
+		}
+	}()
+	snap, nodes := c.Run(ctx, seeds)
+	close(done)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "crawl interrupted; not storing a partial snapshot")
+		return nil
+	}
+
+	if geolocate {
+		fmt.Fprintf(os.Stderr, "geolocating %d nodes\n", len(nodes))
+		census.Geolocate(ctx, nodes)
+	}
+	id, err := db.RecordCensus(snap, nodes)
+	if err != nil {
+		return fmt.Errorf("storing snapshot: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "snapshot %d: %d of %d nodes reachable in %s\n",
+		id, snap.Reachable, snap.Attempted, snap.FinishedAt.Sub(snap.StartedAt).Round(time.Second))
+	return nil
+}
+
+// crawlSeeds starts from the DNS seeds, the IPv4 and IPv6 addresses peers
+// have gossiped to the observer, and any given on the command line
+func crawlSeeds(db *database.DB, network *protocol.NetworkParams, seedList string) []string {
+	var seeds []string
+	port := strconv.Itoa(network.DefaultPort)
+	for _, seed := range network.DNSSeeds {
+		ips, err := net.LookupIP(seed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "DNS seed %s: %v\n", seed, err)
+			continue
+		}
+		for _, ip := range ips {
+			seeds = append(seeds, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	for _, family := range []string{protocol.NetIPv4, protocol.NetIPv6} {
+		addrs, err := db.GossipedAddresses(family, crawlGossipSeeds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loading gossiped %s addresses: %v\n", family, err)
+			continue
+		}
+		seeds = append(seeds, addrs...)
+	}
+	for _, addr := range strings.Split(seedList, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			seeds = append(seeds, addr)
+		}
+	}
+	return seeds
+}
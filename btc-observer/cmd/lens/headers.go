@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/headerexport"
+)
+
+func runHeaders(args []string) error {
+	fs := flag.NewFlagSet("headers", flag.ExitOnError)
+	configPath := fs.String("db", "config.json", "observer config file with the database to read")
+	format := fs.String("format", "raw", "output format: raw (80-byte headers back to back) or json")
+	from := fs.Int("from", 0, "lowest height to export")
+	to := fs.Int("to", -1, "highest height to export (-1 for the tip)")
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens headers [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exports the stored header chain as concatenated 80-byte headers or JSON. The")
+		fmt.Fprintln(os.Stderr, "range must have no gaps; every header is checked against its hash and parent.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *from < 0 || (*format != "raw" && *format != "json") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	db, err := database.NewFromConfig(&cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	chain, err := headerexport.Load(db, int32(*from), int32(*to))
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := chain.Write(w, *format); err != nil {
+		return err
+	}
+	if *out != "" && len(chain.Headers) > 0 {
+		first, last := chain.Headers[0].Height, chain.Headers[len(chain.Headers)-1].Height
+		fmt.Fprintf(os.Stderr, "wrote %d headers (heights %d-%d) to %s\n", len(chain.Headers), first, last, *out)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lens is the operator tool for btc-observer: offline utilities that share the
+// observer's parsing and storage code but don't join the P2P network.
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"bench", "replay a capture file at full speed and report throughput", runBench},
+	{"crawl", "handshake with every reachable node and store a network census", runCrawl},
+	{"headers", "export the stored header chain as raw 80-byte headers or JSON", runHeaders},
+	{"migrate", "apply pending database schema migrations", runMigrate},
+	{"schemas", "print the Avro schemas of the records published to Kafka", runSchemas},
+	{"simulate", "run mock peers that generate tx/block traffic for an observer", runSimulate},
+	{"top", "show live peer stats, queue depths and events from a running observer", runTop},
+	{"topology", "export peer connections and inferred gossip links as DOT or GEXF", runTopology},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lens <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run 'lens <command> -h' for command flags")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "lens %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	if name != "-h" && name != "--help" && name != "help" {
+		fmt.Fprintf(os.Stderr, "lens: unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
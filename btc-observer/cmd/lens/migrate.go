@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/database"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("db", "config.json", "observer config file with the database to migrate")
+	status := fs.Bool("status", false, "list pending migrations without applying them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens migrate [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Applies the schema migrations compiled into this build that the database")
+		fmt.Fprintln(os.Stderr, "hasn't had yet. Databases created from the old schema.sql are adopted as")
+		fmt.Fprintln(os.Stderr, "version 1, since it only creates what is missing.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	db, err := database.NewFromConfig(&cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *status {
+		migrations, err := database.Migrations()
+		if err != nil {
+			return err
+		}
+		current, err := db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest %d\n", current, len(migrations))
+		if current < len(migrations) {
+			for _, m := range migrations[current:] {
+				fmt.Printf("pending  %04d_%s\n", m.Version, m.Name)
+			}
+		}
+		if err := db.CheckSchema(); errors.Is(err, database.ErrSchemaMismatch) {
+			fmt.Printf("mismatch %v\n", err)
+		} else if err != nil && !errors.Is(err, database.ErrSchemaOutdated) {
+			return err
+		}
+		return nil
+	}
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("schema is up to date")
+	}
+	return nil
+}
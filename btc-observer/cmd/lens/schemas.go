@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keato/btc-observer/internal/publish/kafka"
+)
+
+func runSchemas(args []string) error {
+	fs := flag.NewFlagSet("schemas", flag.ExitOnError)
+	name := fs.String("name", "", "print only this schema, e.g. tx_received")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens schemas [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Prints the Avro schemas of the records the Kafka publisher sends, keyed by")
+		fmt.Fprintln(os.Stderr, "the name carried in each message's schema header.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	schemas, err := kafka.AvroSchemas()
+	if err != nil {
+		return err
+	}
+	out := make(map[string]json.RawMessage)
+	for n, s := range schemas {
+		if *name == "" || n == *name {
+			out[n] = json.RawMessage(s)
+		}
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("no schema named %q", *name)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if *name != "" {
+		return enc.Encode(out[*name])
+	}
+	return enc.Encode(out)
+}
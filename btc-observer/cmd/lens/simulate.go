@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+const (
+	simTxRetention    = 100000 // txs kept for serving getdata
+	simBlockRetention = 100
+	simTrickle        = 100 * time.Millisecond // how often peers flush queued invs
+	simPeerQueue      = 10000
+	simBits           = 0x17034219 // a mainnet-era target so difficulty looks realistic
+)
+
+// simStats counts simulator activity, reported periodically
+type simStats struct {
+	connections    atomic.Int64
+	txsCreated     atomic.Int64
+	blocksMined    atomic.Int64
+	invSent        atomic.Int64
+	invDropped     atomic.Int64
+	txRequested    atomic.Int64
+	blockRequested atomic.Int64
+	notFound       atomic.Int64
+}
+
+type outpoint struct {
+	txid  [32]byte
+	index uint32
+	value int64
+}
+
+// simNetwork is the shared state behind all mock peers: generated txs and
+// blocks that any peer can announce and serve
+type simNetwork struct {
+	sync.Mutex
+	txs      map[[32]byte][]byte
+	txOrder  [][32]byte
+	blocks   map[[32]byte][]byte
+	blkOrder [][32]byte
+	mempool  []*protocol.Transaction
+	utxos    []outpoint
+	height   int32
+	tip      [32]byte
+	peers    map[*mockPeer]struct{}
+
+	jitter time.Duration
+	stats  simStats
+}
+
+// mockPeer is one simulated node the observer connects to
+type mockPeer struct {
+	addr  string
+	conn  net.Conn
+	wmu   sync.Mutex
+	queue chan protocol.InvVector
+}
+
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	peers := fs.Int("peers", 8, "number of mock peers")
+	listen := fs.String("listen", "127.0.0.1", "address the mock peers listen on")
+	basePort := fs.Int("base-port", 18400, "port of the first mock peer; others follow sequentially")
+	txRate := fs.Float64("tx-rate", 10, "new transactions per second across the network")
+	blockInterval := fs.Duration("block-interval", 10*time.Minute, "time between generated blocks (0 disables blocks)")
+	blockTxs := fs.Int("block-txs", 2000, "maximum transactions per generated block")
+	startHeight := fs.Int("start-height", 900000, "height of the first generated block")
+	jitter := fs.Duration("jitter", 2*time.Second, "maximum per-peer delay before announcing a tx, to mimic propagation")
+	duration := fs.Duration("duration", 0, "stop after this long (0 runs until interrupted)")
+	configPath := fs.String("config", "", "observer config file whose network or coin the mock peers use (default mainnet)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens simulate [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Starts mock peers that announce and serve generated txs and blocks. Point an")
+		fmt.Fprintln(os.Stderr, "observer at them with static_peers and disable_discovery, using a scratch database.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *peers < 1 || *txRate < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		network, err := cfg.NetworkParams()
+		if err != nil {
+			return err
+		}
+		protocol.SetNetwork(network)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	sim := &simNetwork{
+		txs:    make(map[[32]byte][]byte),
+		blocks: make(map[[32]byte][]byte),
+		peers:  make(map[*mockPeer]struct{}),
+		height: int32(*startHeight) - 1,
+		jitter: *jitter,
+	}
+	rand.Read(sim.tip[:])
+
+	var addrs []string
+	for i := 0; i < *peers; i++ {
+		addr := net.JoinHostPort(*listen, fmt.Sprint(*basePort+i))
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+		go sim.accept(ctx, ln)
+		addrs = append(addrs, `"`+addr+`"`)
+	}
+	fmt.Printf("mock peers listening; add to the observer config:\n  \"static_peers\": [%s],\n  \"disable_discovery\": true\n\n", strings.Join(addrs, ", "))
+
+	go sim.generateTxs(ctx, *txRate)
+	if *blockInterval > 0 {
+		go sim.generateBlocks(ctx, *blockInterval, *blockTxs)
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			sim.closePeers()
+			fmt.Println()
+			sim.report(time.Since(start))
+			return nil
+		case <-ticker.C:
+			sim.report(time.Since(start))
+		}
+	}
+}
+
+func (s *simNetwork) report(elapsed time.Duration) {
+	st := &s.stats
+	secs := elapsed.Seconds()
+	fmt.Printf("[%s] peers=%d txs=%d (%.1f/s) blocks=%d inv_sent=%d inv_dropped=%d getdata_tx=%d (%.1f/s) getdata_block=%d notfound=%d\n",
+		elapsed.Truncate(time.Second),
+		st.connections.Load(),
+		st.txsCreated.Load(), float64(st.txsCreated.Load())/secs,
+		st.blocksMined.Load(),
+		st.invSent.Load(), st.invDropped.Load(),
+		st.txRequested.Load(), float64(st.txRequested.Load())/secs,
+		st.blockRequested.Load(),
+		st.notFound.Load(),
+	)
+}
+
+func (s *simNetwork) accept(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		p := &mockPeer{addr: ln.Addr().String(), conn: conn, queue: make(chan protocol.InvVector, simPeerQueue)}
+		go s.servePeer(ctx, p)
+	}
+}
+
+func (s *simNetwork) closePeers() {
+	s.Lock()
+	defer s.Unlock()
+	for p := range s.peers {
+		p.conn.Close()
+	}
+}
+
+func (p *mockPeer) send(command string, payload []byte) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err := p.conn.Write(protocol.CreateMessagePacket(command, payload))
+	return err
+}
+
+func (s *simNetwork) servePeer(ctx context.Context, p *mockPeer) {
+	defer p.conn.Close()
+
+	// The observer speaks first: version, then verack once it has ours
+	p.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := protocol.ReadMessage(p.conn); err != nil {
+		return
+	}
+	v := protocol.CreateVersionMessage(p.conn.RemoteAddr().String())
+	v.Services = protocol.ServicesNodeNetwork
+	v.UserAgent = "/lens-simulate:0.1.0/"
+	s.Lock()
+	v.StartHeight = s.height
+	s.Unlock()
+	payload, _ := protocol.EncodeVersionMessage(v)
+	if p.send("version", payload) != nil || p.send("verack", nil) != nil {
+		return
+	}
+	p.conn.SetDeadline(time.Time{})
+
+	s.Lock()
+	s.peers[p] = struct{}{}
+	s.Unlock()
+	s.stats.connections.Add(1)
+	defer func() {
+		s.Lock()
+		delete(s.peers, p)
+		s.Unlock()
+		s.stats.connections.Add(-1)
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go p.trickle(done, &s.stats)
+
+	for {
+		msg, err := protocol.ReadMessage(p.conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", p.addr, err)
+			}
+			return
+		}
+		switch protocol.CommandString(msg) {
+		case "getdata":
+			s.serveGetData(p, msg.Payload)
+		case "ping":
+			p.send("pong", msg.Payload)
+		}
+	}
+}
+
+// trickle batches queued announcements into inv messages like a real node
+func (p *mockPeer) trickle(done <-chan struct{}, stats *simStats) {
+	ticker := time.NewTicker(simTrickle)
+	defer ticker.Stop()
+	var batch []protocol.InvVector
+	for {
+		select {
+		case <-done:
+			return
+		case v := <-p.queue:
+			batch = append(batch, v)
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), 1000)
+				if p.send("inv", protocol.CreateInvPayload(batch[:n])) != nil {
+					return
+				}
+				stats.invSent.Add(int64(n))
+				batch = batch[n:]
+			}
+			batch = nil
+		}
+	}
+}
+
+func (s *simNetwork) serveGetData(p *mockPeer, payload []byte) {
+	req := protocol.ParseInvMessage(payload)
+	var missing []protocol.InvVector
+
+	s.Lock()
+	var txs, blocks [][]byte
+	for _, v := range req.TxVectors {
+		if raw, ok := s.txs[v.Hash]; ok {
+			txs = append(txs, raw)
+		} else {
+			missing = append(missing, v)
+		}
+	}
+	for _, v := range req.BlockVectors {
+		if raw, ok := s.blocks[v.Hash]; ok {
+			blocks = append(blocks, raw)
+		} else {
+			missing = append(missing, v)
+		}
+	}
+	s.Unlock()
+
+	s.stats.txRequested.Add(int64(len(req.TxVectors)))
+	s.stats.blockRequested.Add(int64(len(req.BlockVectors)))
+	for _, raw := range txs {
+		p.send("tx", raw)
+	}
+	for _, raw := range blocks {
+		p.send("block", raw)
+	}
+	if len(missing) > 0 {
+		s.stats.notFound.Add(int64(len(missing)))
+		p.send("notfound", protocol.CreateInvPayload(missing))
+	}
+}
+
+// announce queues an inventory item on every connected peer, each after its
+// own random delay so first-seen times differ between peers
+func (s *simNetwork) announce(v protocol.InvVector) {
+	s.Lock()
+	peers := make([]*mockPeer, 0, len(s.peers))
+	for p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.Unlock()
+
+	for _, p := range peers {
+		delay := time.Duration(0)
+		if s.jitter > 0 {
+			delay = time.Duration(mrand.Int63n(int64(s.jitter)))
+		}
+		time.AfterFunc(delay, func() {
+			select {
+			case p.queue <- v:
+			default:
+				s.stats.invDropped.Add(1)
+			}
+		})
+	}
+}
+
+func (s *simNetwork) generateTxs(ctx context.Context, rate float64) {
+	if rate == 0 {
+		return
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	last := time.Now()
+	owed := 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			owed += rate * now.Sub(last).Seconds()
+			last = now
+			for ; owed >= 1; owed-- {
+				tx := s.newTx()
+				s.announce(protocol.InvVector{Type: 1, Hash: tx.TxID})
+			}
+		}
+	}
+}
+
+// newTx builds a legacy tx spending earlier simulated outputs where possible,
+// so the observer's UTXO lookups and fee calculation get exercised
+func (s *simNetwork) newTx() *protocol.Transaction {
+	s.Lock()
+	defer s.Unlock()
+
+	tx := &protocol.Transaction{Version: 2}
+	var inputValue int64
+	nIn := 1 + mrand.Intn(2)
+	for i := 0; i < nIn; i++ {
+		var prev outpoint
+		if len(s.utxos) > 0 {
+			j := mrand.Intn(len(s.utxos))
+			prev = s.utxos[j]
+			s.utxos[j] = s.utxos[len(s.utxos)-1]
+			s.utxos = s.utxos[:len(s.utxos)-1]
+		} else {
+			rand.Read(prev.txid[:])
+			prev.value = 100000 + mrand.Int63n(10000000)
+		}
+		inputValue += prev.value
+		tx.Inputs = append(tx.Inputs, protocol.TxInput{
+			PrevTxHash: prev.txid,
+			PrevIndex:  prev.index,
+			ScriptSig:  randomBytes(107),
+			Sequence:   0xfffffffd,
+		})
+	}
+
+	fee := int64(200 + mrand.Intn(5000))
+	change := (inputValue - fee) / 2
+	for i := 0; i < 2; i++ {
+		script := append([]byte{0x00, 0x14}, randomBytes(20)...) // P2WPKH
+		tx.Outputs = append(tx.Outputs, protocol.TxOutput{Value: change, ScriptPubKey: script})
+	}
+
+	raw := protocol.EncodeTxMessage(tx)
+	for i, out := range tx.Outputs {
+		if out.Value > 1000 {
+			s.utxos = append(s.utxos, outpoint{txid: tx.TxID, index: uint32(i), value: out.Value})
+		}
+	}
+	if len(s.utxos) > simTxRetention {
+		s.utxos = s.utxos[len(s.utxos)-simTxRetention:]
+	}
+
+	s.txs[tx.TxID] = raw
+	s.txOrder = append(s.txOrder, tx.TxID)
+	if len(s.txOrder) > simTxRetention {
+		delete(s.txs, s.txOrder[0])
+		s.txOrder = s.txOrder[1:]
+	}
+	s.mempool = append(s.mempool, tx)
+	s.stats.txsCreated.Add(1)
+	return tx
+}
+
+func (s *simNetwork) generateBlocks(ctx context.Context, interval time.Duration, maxTxs int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hash := s.mineBlock(maxTxs)
+			s.announce(protocol.InvVector{Type: 2, Hash: hash})
+		}
+	}
+}
+
+// mineBlock packs mempool txs into a block on the simulated tip. There is no
+// proof of work; the observer doesn't check it.
+func (s *simNetwork) mineBlock(maxTxs int) [32]byte {
+	s.Lock()
+	defer s.Unlock()
+
+	s.height++
+	n := min(len(s.mempool), maxTxs)
+	txs := append([]*protocol.Transaction{coinbaseTx(s.height)}, s.mempool[:n]...)
+	s.mempool = s.mempool[n:]
+
+	block := &protocol.Block{
+		Header: protocol.BlockHeader{
+			Version:       0x20000000,
+			PrevBlockHash: s.tip,
+			Timestamp:     uint32(time.Now().Unix()),
+			Bits:          simBits,
+			Nonce:         mrand.Uint32(),
+		},
+		Transactions: txs,
+	}
+	raw := protocol.EncodeBlockMessage(block)
+	s.tip = block.BlockHash
+
+	s.blocks[block.BlockHash] = raw
+	s.blkOrder = append(s.blkOrder, block.BlockHash)
+	if len(s.blkOrder) > simBlockRetention {
+		delete(s.blocks, s.blkOrder[0])
+		s.blkOrder = s.blkOrder[1:]
+	}
+	s.stats.blocksMined.Add(1)
+	return block.BlockHash
+}
+
+// coinbaseTx builds a coinbase with the BIP34 height push the observer reads
+func coinbaseTx(height int32) *protocol.Transaction {
+	var h [4]byte
+	binary.LittleEndian.PutUint32(h[:], uint32(height))
+	script := append([]byte{0x03}, h[:3]...)
+	script = append(script, randomBytes(8)...) // extranonce
+	return &protocol.Transaction{
+		Version: 1,
+		Inputs: []protocol.TxInput{{
+			PrevIndex: 0xffffffff,
+			ScriptSig: script,
+			Sequence:  0xffffffff,
+		}},
+		Outputs: []protocol.TxOutput{{
+			Value:        protocol.ActiveNetwork().Subsidy(height),
+			ScriptPubKey: append([]byte{0x00, 0x14}, randomBytes(20)...),
+		}},
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
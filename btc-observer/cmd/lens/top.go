@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/observer"
+)
+
+// statusEvent is an event from /admin/status, with its data left undecoded
+// since its shape depends on the type
+type statusEvent struct {
+	Type events.Type                `json:"type"`
+	Time time.Time                  `json:"time"`
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// status is the /admin/status response
+type status struct {
+	Time             time.Time             `json:"time"`
+	Peers            []observer.PeerStatus `json:"peers"`
+	Queues           []events.Queue        `json:"queues"`
+	Pressure         string                `json:"pressure"`
+	DBWriteLatencyMs int64                 `json:"db_write_latency_ms"`
+	Events           []statusEvent         `json:"events"`
+}
+
+// eventFields are the event data fields shown in the recent events list, in order
+var eventFields = []string{"peer", "region", "height", "hash", "txid", "reason", "level", "fork_height", "new_tip_height"}
+
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	addr := fs.String("addr", "http://127.0.0.1:9091", "observer admin API address")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin API token (default $ADMIN_TOKEN)")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	sortBy := fs.String("sort", "region", "peer order: region, addr, latency or rate")
+	limit := fs.Int("n", 0, "show at most this many peers (0 for all)")
+	once := fs.Bool("once", false, "print one snapshot and exit instead of refreshing")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens top [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Shows a running observer's connected peers, pipeline queue depths and recent")
+		fmt.Fprintln(os.Stderr, "events, refreshed from its admin API. Message rates are measured between")
+		fmt.Fprintln(os.Stderr, "refreshes, so the first screen shows each peer's average since it connected.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *interval <= 0 || *limit < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch *sortBy {
+	case "region", "addr", "latency", "rate":
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		return fmt.Errorf("admin API token required: set -token or ADMIN_TOKEN")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	url := strings.TrimRight(*addr, "/") + "/admin/status"
+	var prev *status
+	for {
+		st, err := fetchStatus(client, url, *token)
+		if err != nil {
+			return err
+		}
+		rates := messageRates(prev, st)
+		if !*once {
+			fmt.Print("\033[H\033[2J")
+		}
+		renderTop(os.Stdout, st, rates, *sortBy, *limit)
+		if *once {
+			return nil
+		}
+		prev = st
+		time.Sleep(*interval)
+	}
+}
+
+func fetchStatus(client *http.Client, url, token string) (*status, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var st status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("decode status: %w", err)
+	}
+	return &st, nil
+}
+
+// messageRates returns each peer's messages per second since the previous
+// snapshot, or since it connected when it wasn't in that snapshot
+func messageRates(prev, cur *status) map[string]float64 {
+	before := make(map[string]observer.PeerStatus)
+	if prev != nil {
+		for _, p := range prev.Peers {
+			before[p.Addr] = p
+		}
+	}
+	rates := make(map[string]float64, len(cur.Peers))
+	for _, p := range cur.Peers {
+		messages, since := p.Messages, p.ConnectedAt
+		if b, ok := before[p.Addr]; ok && b.ConnectedAt.Equal(p.ConnectedAt) {
+			messages -= b.Messages
+			since = prev.Time
+		}
+		if elapsed := cur.Time.Sub(since).Seconds(); elapsed > 0 {
+			rates[p.Addr] = float64(messages) / elapsed
+		}
+	}
+	return rates
+}
+
+func renderTop(w io.Writer, st *status, rates map[string]float64, sortBy string, limit int) {
+	peers := st.Peers
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		switch sortBy {
+		case "latency":
+			return a.LatencyMs > b.LatencyMs
+		case "rate":
+			return rates[a.Addr] > rates[b.Addr]
+		case "region":
+			if a.Region != b.Region {
+				return a.Region < b.Region
+			}
+		}
+		return a.Addr < b.Addr
+	})
+	regions := make(map[string]bool)
+	for _, p := range peers {
+		regions[p.Region] = true
+	}
+
+	fmt.Fprintf(w, "lens top  %s  peers %d in %d regions  pressure %s  db write %dms\n\n",
+		st.Time.Local().Format("15:04:05"), len(peers), len(regions), st.Pressure, st.DBWriteLatencyMs)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tREGION\tCOUNTRY\tPING\tMSG/S\tMSGS\tINVS\tLAST BLOCK\tUP")
+	shown := peers
+	if limit > 0 && len(shown) > limit {
+		shown = shown[:limit]
+	}
+	for _, p := range shown {
+		ping := "-"
+		if p.LatencyMs > 0 {
+			ping = fmt.Sprintf("%dms", p.LatencyMs)
+		}
+		block := "-"
+		if p.LastBlockAt != nil {
+			block = fmt.Sprintf("%d (%s ago)", p.LastBlockHeight, ago(st.Time.Sub(*p.LastBlockAt)))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f\t%d\t%d\t%s\t%s\n",
+			p.Addr, p.Region, p.Country, ping, rates[p.Addr], p.Messages, p.InvItems, block, ago(st.Time.Sub(p.ConnectedAt)))
+	}
+	tw.Flush()
+	if len(shown) < len(peers) {
+		fmt.Fprintf(w, "... %d more\n", len(peers)-len(shown))
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tDEPTH\tCAPACITY")
+	for _, q := range st.Queues {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", q.Name, q.Depth, q.Capacity)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "RECENT EVENTS")
+	for i := len(st.Events) - 1; i >= 0; i-- {
+		e := st.Events[i]
+		fmt.Fprintf(w, "%s  %-22s %s\n", e.Time.Local().Format("15:04:05"), e.Type, eventSummary(e))
+	}
+}
+
+// eventSummary formats the interesting fields of an event's data
+func eventSummary(e statusEvent) string {
+	var parts []string
+	for _, field := range eventFields {
+		if v, ok := e.Data[field]; ok {
+			parts = append(parts, field+"="+strings.Trim(string(v), `"`))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ago formats a duration to the second, or the minute past an hour
+func ago(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Truncate(time.Minute).String()
+	}
+	return d.Truncate(time.Second).String()
+}
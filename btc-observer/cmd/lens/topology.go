@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/keato/btc-observer/internal/config"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/topology"
+)
+
+func runTopology(args []string) error {
+	opts := topology.DefaultOptions
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	configPath := fs.String("db", "config.json", "observer config file with the database to read")
+	format := fs.String("format", "gexf", "output format: dot or gexf")
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.DurationVar(&opts.Window, "window", opts.Window, "how far back peers and announcements are considered")
+	fs.IntVar(&opts.MaxGapMs, "max-gap-ms", opts.MaxGapMs, "longest gap between consecutive announcements counted as a relay")
+	fs.IntVar(&opts.MinGossipCount, "min-count", opts.MinGossipCount, "drop inferred gossip links seen for fewer txs than this")
+	fs.StringVar(&opts.Project, "project", "", "only include this project's transactions and peers")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lens topology [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exports the observer's peer connections and the gossip topology inferred from")
+		fmt.Fprintln(os.Stderr, "announcement order as a Graphviz DOT or Gephi GEXF file.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || opts.Window <= 0 || (*format != "dot" && *format != "gexf") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	db, err := database.NewFromConfig(&cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	g, err := topology.Build(db, opts)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := g.Write(w, *format); err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "wrote %d nodes, %d edges to %s\n", len(g.Nodes), len(g.Edges), *out)
+	}
+	return nil
+}
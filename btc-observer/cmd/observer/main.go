@@ -2,90 +2,477 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/keato/btc-observer/internal/admin"
+	"github.com/keato/btc-observer/internal/alerts"
+	"github.com/keato/btc-observer/internal/audit"
+	"github.com/keato/btc-observer/internal/chain"
+	"github.com/keato/btc-observer/internal/config"
 	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/diskwatch"
+	"github.com/keato/btc-observer/internal/doh"
+	"github.com/keato/btc-observer/internal/experiment"
+	"github.com/keato/btc-observer/internal/features"
+	"github.com/keato/btc-observer/internal/health"
 	"github.com/keato/btc-observer/internal/logger"
 	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/models"
+	"github.com/keato/btc-observer/internal/msgstore"
 	"github.com/keato/btc-observer/internal/observer"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/keato/btc-observer/internal/publish/clickhouse"
+	"github.com/keato/btc-observer/internal/publish/kafka"
+	"github.com/keato/btc-observer/internal/retention"
+	"github.com/keato/btc-observer/internal/rollup"
+	"github.com/keato/btc-observer/internal/scripts"
+	"github.com/keato/btc-observer/internal/shutdown"
+	"github.com/keato/btc-observer/internal/stream"
+	"github.com/keato/btc-observer/internal/supervisor"
+	"github.com/keato/btc-observer/internal/triangulate"
 )
 
 func main() {
+	modesFlag := flag.String("modes", "", "comma-separated modes to run (observe, record, analyze); overrides config")
+	durationFlag := flag.Duration("duration", 0, "run for this long (e.g. 6h), then drain and exit")
+	untilFlag := flag.String("until", "", "run until this RFC 3339 time, then drain and exit")
+	flag.Parse()
+
+	started := time.Now()
+	deadline, err := runDeadline(*durationFlag, *untilFlag, started)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid run window")
+	}
+
 	logger.Log.Info().Msg("=== Bitcoin P2P Observer ===")
-	logger.Log.Info().Msg("Network: MAINNET")
-	logger.Log.Info().Msg("Regional peer selection enabled")
 
-	// Load DB config and connect
-	cfg, err := database.LoadConfig("config.json")
+	// Load config and connect
+	cfg, err := config.Load("config.json")
 	if err != nil {
 		logger.Log.Fatal().Err(err).Msg("Failed to load config")
 	}
-	db, err := database.NewFromConfig(cfg)
+
+	network, err := cfg.NetworkParams()
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid network")
+	}
+	protocol.SetNetwork(network)
+	chain.SetParams(network.Chain, !network.SkipPoWCheck)
+	logger.Log.Info().Str("network", network.Name).Msg("Network selected")
+
+	modeNames := cfg.Modes
+	if *modesFlag != "" {
+		modeNames = strings.Split(*modesFlag, ",")
+	}
+	modes, err := parseModes(modeNames)
 	if err != nil {
-		logger.Log.Fatal().Err(err).Msg("Failed to connect to database")
+		logger.Log.Fatal().Err(err).Msg("Invalid modes")
+	}
+	logger.Log.Info().Str("modes", modes.String()).Msg("Startup modes selected")
+
+	// db serves the subsystems that query with SQL; storage is what the peer
+	// pipeline records to, the same database unless storage is memory
+	var db *database.DB
+	var storage database.Storage
+	switch {
+	case cfg.Storage != "" && cfg.Storage != "postgres" && cfg.Storage != "memory":
+		logger.Log.Fatal().Str("storage", cfg.Storage).Msg("Invalid storage, want postgres or memory")
+	case cfg.OnSchemaMismatch != "" && cfg.OnSchemaMismatch != "fail" && cfg.OnSchemaMismatch != "observe":
+		logger.Log.Fatal().Str("on_schema_mismatch", cfg.OnSchemaMismatch).Msg("Invalid on_schema_mismatch, want fail or observe")
+	case !modes.needsDB():
+		// Observation only: everything the observer records is dropped
+		db = database.NewDiscard()
+		logger.Log.Info().Msg("Running without a database")
+	case cfg.Storage == "memory":
+		db = database.NewDiscard()
+		storage = database.NewMemory()
+		logger.Log.Warn().Msg("Recording to memory; nothing is persisted and SQL-backed subsystems see no data")
+	default:
+		db, err = database.NewFromConfig(&cfg.Config)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to connect to database")
+		}
+		logger.Log.Info().Msg("Connected to database")
+		if err := checkSchema(db, cfg.ManualMigrations); err != nil {
+			if cfg.OnSchemaMismatch != "observe" || !modes[modeObserve] {
+				logger.Log.Fatal().Err(err).Msg("Database schema doesn't match this build")
+			}
+			// Observation only, as if no mode needed the database, rather
+			// than failing mid-stream on SQL errors
+			logger.Log.Error().Err(err).Msg("Database schema doesn't match this build; observing without the database")
+			db.Close()
+			db = database.NewDiscard()
+			modes = modeSet{modeObserve: true}
+		}
+	}
+	if storage == nil {
+		storage = db
+	}
+
+	observerID := cfg.ObserverID
+	if observerID == "" {
+		observerID, _ = os.Hostname()
+	}
+	if modes[modeRecord] {
+		db.SetObserverID(observerID)
+		if cfg.ClickHouse != nil && cfg.ClickHouse.SkipPostgres && modes[modeObserve] {
+			db.SkipPropagationEvents()
+		}
+		if cfg.ObserverLocation != nil {
+			if err := db.RegisterObserver(observerID, *cfg.ObserverLocation); err != nil {
+				logger.Log.Fatal().Err(err).Msg("Failed to register observer location")
+			}
+			logger.Log.Info().Str("observer_id", observerID).Msg("Registered as triangulation vantage point")
+		}
+	}
+
+	if cfg.Checkpoint != nil {
+		guard, err := chain.NewCheckpointGuard(cfg.Checkpoint)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid checkpoint")
+		}
+		observer.SetCheckpoint(guard)
+		logger.Log.Info().
+			Int("height", int(cfg.Checkpoint.Height)).
+			Str("hash", cfg.Checkpoint.Hash).
+			Msg("Checkpoint validation enabled")
+	}
+
+	if cfg.RegionOverridesFile != "" {
+		overrides, err := observer.LoadRegionOverrides(cfg.RegionOverridesFile)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid region overrides")
+		}
+		observer.SetRegionOverrides(overrides)
+		logger.Log.Info().
+			Int("ips", len(overrides.IPs)).
+			Int("prefixes", len(overrides.Prefixes)).
+			Int("asns", len(overrides.ASNs)).
+			Msg("Region overrides loaded")
+	}
+
+	if cfg.DoH != nil {
+		resolver, err := doh.New(*cfg.DoH)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid DoH config")
+		}
+		observer.SetResolver(resolver)
+		logger.Log.Info().Msg("DNS-over-HTTPS resolution enabled")
+	}
+
+	if err := features.Configure(cfg.Features); err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid feature flags")
+	}
+
+	if cfg.Bandwidth != nil {
+		observer.SetBandwidth(*cfg.Bandwidth)
+	}
+
+	if cfg.ParseErrors != nil {
+		observer.SetParseErrors(*cfg.ParseErrors)
+	}
+
+	if cfg.InvLimits != nil {
+		observer.SetInvLimits(*cfg.InvLimits)
+	}
+
+	if cfg.CompactBlocks != nil {
+		observer.SetCompactBlocks(*cfg.CompactBlocks)
+		logger.Log.Info().
+			Bool("high_bandwidth", cfg.CompactBlocks.HighBandwidth).
+			Bool("auto_high_bandwidth", cfg.CompactBlocks.AutoHighBandwidth).
+			Msg("Compact block relay enabled")
+	}
+
+	// Fees are only known for transactions whose inputs were recorded
+	if cfg.FeeAlerts != nil && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Fee alerts need record mode, skipping")
+	} else if cfg.FeeAlerts != nil {
+		observer.SetFeeAlerts(*cfg.FeeAlerts)
+		logger.Log.Info().Msg("Fee outlier alerts enabled")
+	}
+	if cfg.LowFee != nil && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Low-fee tracking needs record mode, skipping")
+	} else if cfg.LowFee != nil {
+		observer.SetLowFeeTracking(*cfg.LowFee)
+		logger.Log.Info().Msg("Low-fee transaction tracking enabled")
+	}
+
+	if cfg.LockTimes != nil && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Locktime tracking needs record mode, skipping")
+	} else if cfg.LockTimes != nil {
+		if err := observer.SetLockTimeTracking(*cfg.LockTimes, storage); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start locktime tracking")
+		}
+		logger.Log.Info().Msg("Future-locktime tracking enabled")
+	}
+
+	// The fork monitor compares peers' chains with the one we've recorded
+	if cfg.ForkMonitor != nil && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Fork monitoring needs record mode, skipping")
+	} else if cfg.ForkMonitor != nil {
+		observer.SetForkMonitor(*cfg.ForkMonitor)
+		logger.Log.Info().Msg("Fork monitoring enabled")
+	}
+
+	// A checkpoint below the stored chain is reached through the header
+	// chain, synced from genesis
+	if cfg.Checkpoint != nil && cfg.HeaderChain == nil {
+		cfg.HeaderChain = &observer.HeaderChainConfig{}
+	}
+	if cfg.HeaderChain != nil {
+		tip, err := observer.SetHeaderChain(*cfg.HeaderChain, storage)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start header chain")
+		}
+		logger.Log.Info().Int32("height", tip.Height).Msg("Header chain tracking enabled")
+	}
+
+	if cfg.HeaderAnnouncements {
+		observer.SetHeaderAnnouncements(true)
+		logger.Log.Info().Msg("Header announcements requested from peers")
+	}
+
+	templates, err := scripts.NewRegistry(cfg.ScriptTemplates)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid script template")
+	}
+	observer.SetScriptTemplates(templates)
+	logger.Log.Info().Int("count", len(templates.Names())).Msg("Script templates loaded")
+
+	if len(cfg.Projects) > 0 && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Projects need record mode, skipping")
+	} else if len(cfg.Projects) > 0 {
+		if err := observer.SetProjects(cfg.Projects, templates.Names(), storage); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid project config")
+		}
+	}
+
+	// Remember the handshake nonces sent before a restart, so peers replaying
+	// them are caught
+	if modes[modeRecord] {
+		n, err := observer.LoadNonces(storage)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to load version nonces")
+		}
+		logger.Log.Info().Int("count", n).Msg("Version nonces loaded")
 	}
-	logger.Log.Info().Msg("Connected to database")
 
 	// Seed Prometheus counters from historical DB totals
-	metrics.SeedFromDB(db.Conn())
+	if modes[modeRecord] && !db.Discards() {
+		metrics.SeedFromDB(db.Conn())
+	}
+	baseline := metrics.ReadHeadline()
+
+	if cfg.Stream != nil {
+		stream.Register(*cfg.Stream)
+		logger.Log.Info().Str("path", "/ws").Msg("Live event stream enabled")
+	}
 
 	// Start Prometheus metrics server
 	metrics.StartMetricsServer(":9090")
 	logger.Log.Info().Str("addr", ":9090").Msg("Prometheus metrics server started")
 
-	// Create context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
+	if cfg.PeerLogDir != "" {
+		if err := observer.SetPeerLogDir(cfg.PeerLogDir); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to create peer log directory")
+		}
+	}
 
-	// WaitGroup to track active connections
-	var wg sync.WaitGroup
+	// Plan the teardown. Everything that takes in data runs under the intake
+	// phase's context; restartable subsystems run under the supervisor, each
+	// with its own context derived from it. Queue writers and peer state
+	// flushers run under the later phases, so they finish after intake stops.
+	var shutdownCfg shutdown.Config
+	if cfg.Shutdown != nil {
+		if err := cfg.Shutdown.Validate(); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid shutdown config")
+		}
+		shutdownCfg = *cfg.Shutdown
+	}
+	teardown := shutdown.New(shutdownCfg)
+	ctx := teardown.Phase(shutdown.Intake).Context()
+	flush := teardown.Phase(shutdown.Flush)
+	sup := supervisor.New(ctx)
 
-	// Initialize peer manager
-	pm := observer.NewPeerManager()
+	// Initialize peer manager with the peer state saved at the last shutdown
+	pm, err := observer.NewPeerManager(storage)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Failed to load peer scores")
+	}
+	if banned := len(pm.Blacklisted()); banned > 0 {
+		logger.Log.Info().Int("banned", banned).Msg("Restored banned peers")
+	}
 
-	// Start background routines
-	observer.StartCleanupRoutine(ctx)
+	// Start admin API if configured
+	if cfg.Admin != nil {
+		adminServer, err := admin.NewServer(*cfg.Admin, db, pm, sup)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to configure admin API")
+		}
+		adminServer.Start()
+		logger.Log.Info().Str("addr", cfg.Admin.Addr).Msg("Admin API server started")
+	}
 
-	// Initial peer discovery
-	observer.RefreshPeerPool(pm)
+	// Start operator-defined SQL metrics
+	if len(cfg.CustomMetrics) > 0 && modes[modeAnalyze] {
+		if err := metrics.StartCustomMetrics(ctx, db.Conn(), cfg.CustomMetrics); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start custom metrics")
+		}
+		logger.Log.Info().Int("count", len(cfg.CustomMetrics)).Msg("Custom metrics started")
+	}
 
-	// Start periodic discovery (every 30 min)
-	observer.StartDiscoveryRoutine(ctx, pm, 30*time.Minute)
+	// Start hourly/daily observation rollups
+	if cfg.Rollups != nil && modes[modeAnalyze] {
+		err := sup.Start("rollups", func(ctx context.Context) error {
+			return rollup.Start(ctx, db, *cfg.Rollups)
+		})
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start rollups")
+		}
+		logger.Log.Info().Int("prune_after_days", cfg.Rollups.PruneAfterDays).Msg("Observation rollups started")
+	}
 
-	// Start peer manager (maintains connections)
-	observer.StartPeerManager(ctx, pm, db, &wg)
+	// Prune raw observations past their retention
+	if cfg.Retention != nil && modes[modeAnalyze] {
+		err := sup.Start("retention", func(ctx context.Context) error {
+			return retention.Start(ctx, db, *cfg.Retention)
+		})
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid retention config")
+		}
+		logger.Log.Info().
+			Int("propagation_events_days", cfg.Retention.PropagationEventsDays).
+			Int("unconfirmed_tx_days", cfg.Retention.UnconfirmedTxDays).
+			Int("script_days", cfg.Retention.ScriptDays).
+			Msg("Retention pruning started")
+	}
 
-	// Start status reporter
-	observer.StartStatusReporter(ctx, pm, 60*time.Second)
+	// Cross-check recorded data for internal consistency
+	if cfg.Audit != nil && modes[modeAnalyze] {
+		err := sup.Start("audit", func(ctx context.Context) error {
+			return audit.Start(ctx, db, *cfg.Audit)
+		})
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid audit config")
+		}
+		logger.Log.Info().Strs("checks", database.AuditChecks()).Msg("Integrity audit started")
+	}
 
-	// Wait for shutdown signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Estimate tx origins from multiple vantage points (opt-in research feature)
+	if cfg.Triangulation != nil && modes[modeAnalyze] {
+		err := sup.Start("triangulation", func(ctx context.Context) error {
+			return triangulate.Start(ctx, db, *cfg.Triangulation)
+		})
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid triangulation config")
+		}
+		logger.Log.Info().Msg("Origin triangulation started")
+	}
+
+	if cfg.MetricsSnapshot != nil {
+		if err := cfg.MetricsSnapshot.Validate(); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid metrics snapshot config")
+		}
+	}
+
+	// Mirror metrics to StatsD if configured
+	if cfg.StatsD != nil {
+		if err := metrics.StartStatsD(ctx, *cfg.StatsD); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start StatsD emitter")
+		}
+		logger.Log.Info().Str("addr", cfg.StatsD.Addr).Bool("dogstatsd", cfg.StatsD.DogStatsD).Msg("StatsD emitter started")
+	}
+
+	// Publish observation events to Kafka if configured
+	if cfg.Kafka != nil && modes[modeObserve] {
+		if err := kafka.Start(flush.Context(), *cfg.Kafka, observerID, flush.WaitGroup()); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid Kafka config")
+		}
+		logger.Log.Info().Strs("brokers", cfg.Kafka.Brokers).Msg("Kafka publisher started")
+	}
 
-	sig := <-sigChan
-	logger.Log.Info().Str("signal", sig.String()).Msg("Received signal, initiating graceful shutdown")
+	// Write propagation events to ClickHouse if configured
+	if cfg.ClickHouse != nil && modes[modeObserve] {
+		if err := clickhouse.Start(flush.Context(), *cfg.ClickHouse, observerID, flush.WaitGroup()); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start ClickHouse writer")
+		}
+		logger.Log.Info().Str("url", cfg.ClickHouse.URL).Bool("skip_postgres", cfg.ClickHouse.SkipPostgres).Msg("ClickHouse writer started")
+	}
 
-	// Cancel context to stop all goroutines
-	cancel()
+	// Send alerts to webhooks if configured
+	if cfg.Alerts != nil && modes[modeObserve] {
+		if err := alerts.Start(flush.Context(), *cfg.Alerts, observerID, flush.WaitGroup()); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid alerts config")
+		}
+		logger.Log.Info().Int("webhooks", len(cfg.Alerts.Webhooks)).Msg("Webhook alerts started")
+	}
 
-	// Close all active connections to unblock reads
-	observer.CloseAllConnections()
+	// Serve /healthz and /readyz next to /metrics. Peers and discovery are
+	// only checked when the observer is finding its own peers.
+	var healthCfg health.Config
+	if cfg.Health != nil {
+		healthCfg = *cfg.Health
+	}
+	if modes[modeObserve] {
+		health.Register(healthCfg, db, pm, !cfg.DisableDiscovery)
+	} else {
+		health.Register(healthCfg, db, nil, false)
+	}
 
-	// Wait for all observer goroutines to finish (with timeout)
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+	// Start background routines
+	logger.StartErrorSummary(ctx)
+	if modes[modeObserve] {
+		startObserver(ctx, sup, cfg, modes, pm, db, storage, teardown)
+	}
 
+	// Wait for shutdown signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// A time-bounded run stops itself once its window is over
+	var windowEnd <-chan time.Time
+	if !deadline.IsZero() {
+		windowEnd = time.After(time.Until(deadline))
+		logger.Log.Info().Time("until", deadline).Msg("Time-bounded run, will stop at deadline")
+	}
+
+	var stoppedBy string
 	select {
-	case <-done:
-		logger.Log.Info().Msg("All connections closed gracefully")
-	case <-time.After(10 * time.Second):
-		logger.Log.Warn().Msg("Shutdown timeout - forcing exit")
+	case sig := <-sigChan:
+		stoppedBy = sig.String()
+		logger.Log.Info().Str("signal", stoppedBy).Msg("Received signal, initiating graceful shutdown")
+	case <-windowEnd:
+		stoppedBy = "deadline"
+		logger.Log.Info().Msg("Run window over, initiating graceful shutdown")
+	}
+
+	// Stop intake, drain the event queues, close peer connections to
+	// unblock their reads, then save peer state, each within the budget
+	if modes[modeObserve] {
+		teardown.Phase(shutdown.Peers).OnStart(observer.CloseAllConnections)
+	}
+	timings := teardown.Run()
+	logger.FlushRepeatedErrors()
+	headline := metrics.ReadHeadline().Since(baseline)
+	logFinalReport(started, stoppedBy, headline)
+	if cfg.MetricsSnapshot != nil {
+		run := metrics.RunInfo{Observer: observerID, Started: started, Ended: time.Now(), StoppedBy: stoppedBy, Headline: headline, Shutdown: make(map[string]float64)}
+		for _, t := range timings {
+			run.Shutdown[t.Phase] = t.Took.Seconds()
+		}
+		if err := metrics.WriteSnapshot(*cfg.MetricsSnapshot, run); err != nil {
+			logger.Log.Error().Err(err).Str("path", cfg.MetricsSnapshot.Path).Msg("Failed to write metrics snapshot")
+		} else {
+			logger.Log.Info().Str("path", cfg.MetricsSnapshot.Path).Msg("Metrics snapshot written")
+		}
 	}
 
 	// Close database connection
@@ -97,3 +484,183 @@ func main() {
 
 	logger.Log.Info().Msg("Shutdown complete")
 }
+
+// startObserver joins the P2P network: peer discovery and connections plus
+// the routines that keep them within their resource limits
+func startObserver(ctx context.Context, sup *supervisor.Supervisor, cfg *config.Config, modes modeSet, pm *observer.PeerManager, db *database.DB, storage database.Storage, teardown *shutdown.Plan) {
+	wg := teardown.Phase(shutdown.Peers).WaitGroup()
+	flush := teardown.Phase(shutdown.Flush)
+	final := teardown.Phase(shutdown.Sync)
+	logger.Log.Info().Msg("Regional peer selection enabled")
+	observer.StartCleanupRoutine(ctx)
+	if cfg.MemoryBudgetMB > 0 {
+		observer.StartMemoryWatchdog(ctx, uint64(cfg.MemoryBudgetMB)*1024*1024)
+	}
+	if cfg.Backpressure != nil {
+		observer.StartBackpressure(ctx, *cfg.Backpressure)
+	}
+	if cfg.BlockDownload != nil {
+		observer.StartBlockStrategy(ctx, *cfg.BlockDownload)
+		logger.Log.Info().Strs("full_regions", cfg.BlockDownload.FullRegions).Msg("Block download limited to regions")
+	}
+	if len(cfg.Models) > 0 {
+		if err := models.Start(ctx, cfg.Models); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to start models")
+		}
+		logger.Log.Info().Strs("models", models.Running()).Msg("Propagation models started")
+	}
+	if cfg.MessageStore != nil {
+		store, err := msgstore.Open(*cfg.MessageStore)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to open message store")
+		}
+		store.Start(flush.Context(), flush.WaitGroup())
+		observer.SetMessageStore(store)
+		logger.Log.Info().Str("dir", cfg.MessageStore.Dir).Msg("Raw message store enabled")
+	}
+	if cfg.DiskWatch != nil {
+		watch := *cfg.DiskWatch
+		if cfg.PeerLogDir != "" {
+			watch.Paths = append(watch.Paths, cfg.PeerLogDir)
+		}
+		if cfg.MessageStore != nil {
+			watch.Paths = append(watch.Paths, cfg.MessageStore.Dir)
+		}
+		diskwatch.Start(ctx, watch)
+	}
+
+	if cfg.BlockOrdering != nil {
+		observer.SetBlockOrdering(*cfg.BlockOrdering)
+	}
+	if cfg.GeoCheck != nil {
+		if err := observer.SetGeoCheck(*cfg.GeoCheck); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid geo check config")
+		}
+	}
+	if cfg.DialSources != nil {
+		if err := observer.SetDialSources(*cfg.DialSources); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid dial sources")
+		}
+		observer.StartEgressCheck(ctx, *cfg.DialSources)
+		logger.Log.Info().Int("groups", len(cfg.DialSources.Groups)).Msg("Peer dial sources configured")
+	}
+	if cfg.Prevouts != nil {
+		if err := observer.SetPrevoutSource(*cfg.Prevouts); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid prevouts config")
+		}
+	}
+	var scores observer.PeerScoreConfig
+	if cfg.PeerScores != nil {
+		scores = *cfg.PeerScores
+	}
+	observer.StartPeerScores(final.Context(), scores, storage, final.WaitGroup())
+	if cfg.LatencyProfiles != nil {
+		n, err := observer.StartLatencyProfiles(final.Context(), *cfg.LatencyProfiles, pm, storage, final.WaitGroup())
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to load peer latency profiles")
+		}
+		logger.Log.Info().Int("profiles", n).Int("rotate_minutes", cfg.LatencyProfiles.RotateMinutes).Msg("Peer latency profiles enabled")
+	}
+	if cfg.ClaimChecks != nil {
+		n, err := observer.StartClaimChecks(ctx, *cfg.ClaimChecks, storage)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to load peer claim checks")
+		}
+		logger.Log.Info().Int("excluded", n).Int("interval_minutes", cfg.ClaimChecks.IntervalMinutes).Msg("Peer claim checks enabled")
+	}
+	if cfg.Traffic != nil {
+		observer.StartTraffic(final.Context(), *cfg.Traffic, storage, final.WaitGroup())
+		logger.Log.Info().Msg("Peer traffic recording enabled")
+	}
+	if cfg.GeoCache != nil {
+		observer.SetGeoProvider(observer.NewCachedGeo(observer.IPAPI{}, *cfg.GeoCache, storage))
+		logger.Log.Info().Int("max_age_hours", cfg.GeoCache.MaxAgeHours).Msg("Geo cache enabled")
+	}
+	if cfg.GeoBackfill != nil {
+		observer.StartGeoBackfill(ctx, *cfg.GeoBackfill, storage)
+		logger.Log.Info().Int("interval_hours", cfg.GeoBackfill.IntervalHours).Msg("Geo backfill enabled")
+	}
+	if cfg.Crawl != nil {
+		observer.StartCrawler(ctx, *cfg.Crawl, pm)
+	}
+	if cfg.Expansion != nil {
+		observer.StartExpansion(ctx, *cfg.Expansion, pm, storage)
+		logger.Log.Info().Bool("auto_add", cfg.Expansion.AutoAdd).Msg("Region expansion suggestions enabled")
+	}
+	if cfg.Tor != nil {
+		observer.StartTorPeers(ctx, *cfg.Tor, pm, storage, wg)
+	}
+	if len(cfg.StaticPeers) > 0 {
+		if err := observer.StartStaticPeers(ctx, cfg.StaticPeers, pm, storage, wg); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid static peers")
+		}
+	}
+
+	// Scheduled peer-set experiments (before discovery so it keeps candidates
+	// for the experiments' countries and ASNs)
+	if len(cfg.Experiments) > 0 && !modes[modeRecord] {
+		logger.Log.Warn().Msg("Experiments need record mode, skipping")
+	} else if len(cfg.Experiments) > 0 {
+		if err := experiment.Start(ctx, db, cfg.Experiments); err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid experiment config")
+		}
+	}
+
+	// Relay probes are measured from the per-peer sightings recorded
+	if cfg.RelayProbes != nil && (!modes[modeRecord] || cfg.Storage == "memory") {
+		logger.Log.Warn().Msg("Relay probes need record mode with postgres storage, skipping")
+	} else if cfg.RelayProbes != nil {
+		err := sup.Start("relay_probes", func(ctx context.Context) error {
+			return observer.StartRelayProbes(ctx, db, *cfg.RelayProbes)
+		})
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid relay probe config")
+		}
+		logger.Log.Info().Str("network", protocol.ActiveNetwork().Name).Msg("Relay probes started")
+	}
+
+	if !cfg.DisableDiscovery {
+		// Initial peer discovery, then every 30 min. Restarting it refreshes
+		// the peer pool at once.
+		sup.Start("discovery", func(ctx context.Context) error {
+			observer.RefreshPeerPool(pm)
+			observer.StartDiscoveryRoutine(ctx, pm, 30*time.Minute)
+			return nil
+		})
+
+		// Start peer manager (maintains connections)
+		observer.StartPeerManager(ctx, pm, storage, wg)
+	}
+
+	// Start status reporter
+	observer.StartStatusReporter(ctx, pm, 60*time.Second)
+	observer.StartThroughputGauges(ctx)
+}
+
+// checkSchema brings the database schema up to this build's version. It
+// returns an error when it can't: the schema is behind with manual
+// migrations, a migration failed, or the database was migrated by a newer or
+// diverging build.
+func checkSchema(db *database.DB, manual bool) error {
+	err := db.CheckSchema()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, database.ErrSchemaMismatch) {
+		return err
+	}
+	if !errors.Is(err, database.ErrSchemaOutdated) {
+		logger.Log.Fatal().Err(err).Msg("Database schema check failed")
+	}
+	if manual {
+		return fmt.Errorf("%w; run lens migrate", err)
+	}
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		logger.Log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Applied schema migration")
+	}
+	if err != nil {
+		return fmt.Errorf("schema migration: %w", err)
+	}
+	return db.CheckSchema()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Startup modes. observe joins the P2P network, record stores what it sees,
+// and analyze runs the aggregation jobs over the stored data.
+const (
+	modeObserve = "observe"
+	modeRecord  = "record"
+	modeAnalyze = "analyze"
+)
+
+var allModes = []string{modeObserve, modeRecord, modeAnalyze}
+
+// modeSet is the modes this process runs
+type modeSet map[string]bool
+
+// parseModes validates the configured modes; none means all of them
+func parseModes(names []string) (modeSet, error) {
+	if len(names) == 0 {
+		names = allModes
+	}
+	modes := make(modeSet)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch name {
+		case modeObserve, modeRecord, modeAnalyze:
+			modes[name] = true
+		default:
+			return nil, fmt.Errorf("unknown mode %q (want %s)", name, strings.Join(allModes, ", "))
+		}
+	}
+	if modes[modeRecord] && !modes[modeObserve] {
+		return nil, fmt.Errorf("%s needs %s", modeRecord, modeObserve)
+	}
+	return modes, nil
+}
+
+// needsDB reports whether a database connection is needed
+func (m modeSet) needsDB() bool {
+	return m[modeRecord] || m[modeAnalyze]
+}
+
+func (m modeSet) String() string {
+	var names []string
+	for _, name := range allModes {
+		if m[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// runDeadline is when a time-bounded run stops, from -duration or -until;
+// the zero time means run until signalled
+func runDeadline(duration time.Duration, until string, now time.Time) (time.Time, error) {
+	switch {
+	case duration != 0 && until != "":
+		return time.Time{}, fmt.Errorf("set either -duration or -until, not both")
+	case duration < 0:
+		return time.Time{}, fmt.Errorf("-duration must be positive")
+	case duration > 0:
+		return now.Add(duration), nil
+	case until != "":
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("-until: %w", err)
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("-until %s is already past", until)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+// logFinalReport logs what this run observed, so a scripted campaign's log
+// ends with its summary
+func logFinalReport(started time.Time, reason string, h metrics.Headline) {
+	logger.Log.Info().
+		Str("stopped_by", reason).
+		Time("started", started).
+		Dur("ran", time.Since(started)).
+		Float64("tx_received", h.TxReceived).
+		Float64("tx_recorded", h.TxRecorded).
+		Float64("tx_conflicts", h.TxConflicts).
+		Float64("blocks_received", h.BlocksReceived).
+		Float64("inv_tx_announcements", h.InvTxAnnouncements).
+		Float64("inv_block_announcements", h.InvBlockAnnounces).
+		Float64("peer_connections", h.PeerConnections).
+		Float64("peer_disconnections", h.PeerDisconnections).
+		Float64("handshake_failures", h.HandshakeFailures).
+		Float64("block_height", h.BlockHeight).
+		Msg("Final report")
+}
@@ -0,0 +1,518 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/features"
+	"github.com/keato/btc-observer/internal/headerexport"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/models"
+	"github.com/keato/btc-observer/internal/msgstore"
+	"github.com/keato/btc-observer/internal/observer"
+	"github.com/keato/btc-observer/internal/supervisor"
+	"github.com/keato/btc-observer/internal/topology"
+)
+
+const maxCaptureDuration = 24 * time.Hour
+
+// recentEvents is how many notable events /admin/status keeps
+const recentEvents = 50
+
+// maxPeersPerCountry bounds the per-country peer target the API accepts
+const maxPeersPerCountry = 50
+
+// Config configures the admin HTTP API
+type Config struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token"`
+}
+
+// Server is the authenticated admin API for runtime control
+type Server struct {
+	cfg Config
+	db  *database.DB
+	pm  *observer.PeerManager
+	sup *supervisor.Supervisor
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	recent []events.Event // newest last
+}
+
+// NewServer creates the admin API, controlling peers through pm and
+// restarting subsystems through sup. ADMIN_TOKEN overrides the configured
+// token.
+func NewServer(cfg Config, db *database.DB, pm *observer.PeerManager, sup *supervisor.Supervisor) (*Server, error) {
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("admin API requires a token")
+	}
+
+	s := &Server{cfg: cfg, db: db, pm: pm, sup: sup, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /admin/peers", s.handleListPeers)
+	s.mux.HandleFunc("POST /admin/peers/{addr}/disconnect", s.handleDisconnectPeer)
+	s.mux.HandleFunc("POST /admin/peers/{addr}/ban", s.handleBanPeer)
+	s.mux.HandleFunc("GET /admin/blacklist", s.handleListBlacklist)
+	s.mux.HandleFunc("DELETE /admin/blacklist", s.handleClearBlacklist)
+	s.mux.HandleFunc("GET /admin/peer-scores", s.handleListPeerScores)
+	s.mux.HandleFunc("POST /admin/discovery/refresh", s.handleRefreshPeers)
+	s.mux.HandleFunc("PUT /admin/policy/peers-per-country", s.handleSetPeersPerCountry)
+	s.mux.HandleFunc("POST /admin/peers/{addr}/capture", s.handleEnableCapture)
+	s.mux.HandleFunc("DELETE /admin/peers/{addr}/capture", s.handleDisableCapture)
+	s.mux.HandleFunc("GET /admin/status", s.handleStatus)
+	s.mux.HandleFunc("GET /admin/models", s.handleListModels)
+	s.mux.HandleFunc("GET /admin/models/{name}/scores", s.handleModelScores)
+	s.mux.HandleFunc("GET /admin/topology", s.handleTopology)
+	s.mux.HandleFunc("GET /admin/headers", s.handleHeaders)
+	s.mux.HandleFunc("GET /admin/chain-tips", s.handleChainTips)
+	s.mux.HandleFunc("GET /admin/messages", s.handleMessages)
+	s.mux.HandleFunc("GET /admin/features", s.handleListFeatures)
+	s.mux.HandleFunc("POST /admin/features/{name}", s.handleEnableFeature)
+	s.mux.HandleFunc("DELETE /admin/features/{name}", s.handleDisableFeature)
+	s.mux.HandleFunc("GET /admin/subsystems", s.handleListSubsystems)
+	s.mux.HandleFunc("POST /admin/subsystems/{name}/restart", s.handleRestartSubsystem)
+	s.mux.HandleFunc("POST /admin/regions/{region}/restart", s.handleRestartRegion)
+	return s, nil
+}
+
+// Start serves the admin API in the background
+func (s *Server) Start() {
+	go s.watchEvents()
+	go func() {
+		if err := http.ListenAndServe(s.cfg.Addr, s.authenticate(s.mux)); err != nil {
+			logger.Log.Error().Err(err).Msg("Admin API server stopped")
+		}
+	}()
+}
+
+// authenticate requires a matching bearer token on every request
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchEvents keeps the most recent events for /admin/status, skipping the
+// per-transaction ones that would crowd everything else out
+func (s *Server) watchEvents() {
+	ch, _ := events.Subscribe("admin", 256)
+	for e := range ch {
+		if e.Type == events.TxAnnounced || e.Type == events.TxReceived {
+			continue
+		}
+		s.mu.Lock()
+		if len(s.recent) == recentEvents {
+			s.recent = append(s.recent[:0], s.recent[1:]...)
+		}
+		s.recent = append(s.recent, e)
+		s.mu.Unlock()
+	}
+}
+
+// handleStatus returns live per-peer statistics, pipeline queue depths and
+// recent events, for lens top
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	recent := make([]events.Event, len(s.recent))
+	copy(recent, s.recent)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"time":                time.Now().UTC(),
+		"peers":               observer.ActivePeers(),
+		"queues":              observer.Queues(),
+		"pressure":            observer.CurrentPressure().String(),
+		"db_write_latency_ms": observer.DBWriteLatency().Milliseconds(),
+		"events":              recent,
+	})
+}
+
+// handleListPeers returns every connected peer with its live statistics,
+// and how many of the peer target's slots they fill
+func (s *Server) handleListPeers(w http.ResponseWriter, r *http.Request) {
+	peers := observer.ActivePeers()
+	filled, target := s.pm.TargetFill()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":             len(peers),
+		"slots_filled":      filled,
+		"slots":             target,
+		"peers_per_country": peersPerCountry(),
+		"peers":             peers,
+	})
+}
+
+// handleDisconnectPeer closes a peer's connection; the peer manager may
+// reconnect to it
+func (s *Server) handleDisconnectPeer(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	if !observer.DisconnectPeer(addr) {
+		writeError(w, http.StatusNotFound, "peer not connected")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"peer": addr, "disconnected": true})
+}
+
+// handleBanPeer blacklists a peer with ?reason= (default "admin") and
+// disconnects it if connected
+func (s *Server) handleBanPeer(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "admin"
+	}
+	s.pm.Ban(addr, reason)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"peer":         addr,
+		"banned":       true,
+		"disconnected": observer.DisconnectPeer(addr),
+	})
+}
+
+func (s *Server) handleListBlacklist(w http.ResponseWriter, r *http.Request) {
+	peers := s.pm.Blacklisted()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(peers), "peers": peers})
+}
+
+// handleClearBlacklist unbans every peer and resets their penalties and
+// failure backoff
+func (s *Server) handleClearBlacklist(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"cleared": s.pm.ClearBlacklist()})
+}
+
+// handleListPeerScores returns every scored peer's current penalty,
+// highest first
+func (s *Server) handleListPeerScores(w http.ResponseWriter, r *http.Request) {
+	scores := observer.PeerScores()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(scores), "peers": scores})
+}
+
+// handleRefreshPeers fetches new candidate peers in the background, without
+// waiting for the next discovery interval
+func (s *Server) handleRefreshPeers(w http.ResponseWriter, r *http.Request) {
+	go observer.RefreshPeerPool(s.pm)
+	logger.Log.Info().Msg("Peer pool refresh requested")
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"refreshing": true})
+}
+
+// handleSetPeersPerCountry changes the per-country peer target to ?n=, 0
+// restoring the default. Countries over the new target lose their
+// newest peers.
+func (s *Server) handleSetPeersPerCountry(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 0 || n > maxPeersPerCountry {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("n must be an integer from 0 to %d", maxPeersPerCountry))
+		return
+	}
+	observer.SetPeersPerCountry(n)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"peers_per_country": peersPerCountry()})
+}
+
+// peersPerCountry is the per-country target in effect
+func peersPerCountry() int {
+	if n := observer.CurrentPeerPolicy().PeersPerCountry; n > 0 {
+		return n
+	}
+	return observer.PeersPerCountry
+}
+
+// handleEnableCapture enables payload-level tracing for a peer for ?minutes=N (default 10)
+func (s *Server) handleEnableCapture(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	minutes := 10
+	if v := r.URL.Query().Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "minutes must be a positive integer")
+			return
+		}
+		minutes = n
+	}
+	d := time.Duration(minutes) * time.Minute
+	if d > maxCaptureDuration {
+		d = maxCaptureDuration
+	}
+
+	observer.EnableCapture(addr, d)
+	logger.Log.Info().Str("peer", addr).Dur("duration", d).Msg("Debug capture enabled")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"peer":    addr,
+		"capture": true,
+		"until":   time.Now().Add(d).UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *Server) handleDisableCapture(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	observer.DisableCapture(addr)
+	logger.Log.Info().Str("peer", addr).Msg("Debug capture disabled")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"peer": addr, "capture": false})
+}
+
+// handleListModels lists compiled-in models and which of them are running
+func (s *Server) handleListModels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"registered": models.Names(),
+		"running":    models.Running(),
+	})
+}
+
+// handleModelScores returns the scores a running model last exported
+func (s *Server) handleModelScores(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	scores, ok := models.LatestScores(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "model not running")
+		return
+	}
+	if scores == nil {
+		scores = []models.Score{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"model": name, "scores": scores})
+}
+
+// handleTopology exports the peer connection and inferred gossip graph as
+// ?format=gexf (default) or dot. ?window=, ?max_gap_ms= and ?min_count=
+// override the defaults, and ?project= limits it to one project.
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "gexf"
+	}
+	if format != "dot" && format != "gexf" {
+		writeError(w, http.StatusBadRequest, "format must be dot or gexf")
+		return
+	}
+
+	opts := topology.DefaultOptions
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "window must be a positive duration such as 1h")
+			return
+		}
+		opts.Window = d
+	}
+	for name, dst := range map[string]*int{"max_gap_ms": &opts.MaxGapMs, "min_count": &opts.MinGossipCount} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, name+" must be a non-negative integer")
+				return
+			}
+			*dst = n
+		}
+	}
+
+	opts.Project = q.Get("project")
+
+	g, err := topology.Build(s.db, opts)
+	if errors.Is(err, topology.ErrUnknownProject) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Topology export failed")
+		writeError(w, http.StatusInternalServerError, "topology export failed")
+		return
+	}
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	} else {
+		w.Header().Set("Content-Type", "application/gexf+xml")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"topology.%s\"", format))
+	g.Write(w, format)
+}
+
+// handleHeaders exports the stored header chain as ?format=raw (80-byte
+// headers back to back, the default) or json, from ?from= (default 0) to
+// ?to= (default the tip)
+func (s *Server) handleHeaders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "raw"
+	}
+	if format != "raw" && format != "json" {
+		writeError(w, http.StatusBadRequest, "format must be raw or json")
+		return
+	}
+	from, to := int64(0), int64(-1)
+	for name, dst := range map[string]*int64{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, name+" must be a non-negative height")
+				return
+			}
+			*dst = n
+		}
+	}
+	if to >= 0 && to < from {
+		writeError(w, http.StatusBadRequest, "to must not be below from")
+		return
+	}
+
+	chain, err := headerexport.Load(s.db, int32(from), int32(to))
+	if errors.Is(err, headerexport.ErrNotContiguous) || errors.Is(err, headerexport.ErrTooMany) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Header export failed")
+		writeError(w, http.StatusInternalServerError, "header export failed")
+		return
+	}
+	if format == "raw" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"headers.bin\"")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	chain.Write(w, format)
+}
+
+// handleChainTips lists the header chain's branches with their cumulative
+// work, the best chain first
+func (s *Server) handleChainTips(w http.ResponseWriter, r *http.Request) {
+	tips := observer.ChainTips()
+	if tips == nil {
+		writeError(w, http.StatusNotFound, "header chain not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(tips), "tips": tips})
+}
+
+// handleMessages returns raw messages from the message store, oldest first,
+// filtered by ?peer=, ?command=, ?since= and ?until= (RFC 3339) and capped
+// by ?limit= (default 100, at most 1000)
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	store := observer.MessageStore()
+	if store == nil {
+		writeError(w, http.StatusNotFound, "message store not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	q := msgstore.Query{Peer: params.Get("peer"), Command: params.Get("command")}
+	for name, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		q.Limit = n
+	}
+
+	messages, err := store.Query(q)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Message store query failed")
+		writeError(w, http.StatusInternalServerError, "message store query failed")
+		return
+	}
+	type message struct {
+		msgstore.Message
+		Size    int    `json:"size"`
+		Payload string `json:"payload"`
+	}
+	out := make([]message, len(messages))
+	for i, m := range messages {
+		out[i] = message{Message: m, Size: len(m.Payload), Payload: hex.EncodeToString(m.Payload)}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(out), "messages": out})
+}
+
+// handleListFeatures returns every feature flag's current setting
+func (s *Server) handleListFeatures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"features": features.States()})
+}
+
+func (s *Server) handleEnableFeature(w http.ResponseWriter, r *http.Request) {
+	s.setFeature(w, r.PathValue("name"), true)
+}
+
+func (s *Server) handleDisableFeature(w http.ResponseWriter, r *http.Request) {
+	s.setFeature(w, r.PathValue("name"), false)
+}
+
+func (s *Server) setFeature(w http.ResponseWriter, name string, on bool) {
+	if err := features.Set(name, on); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"feature": name, "enabled": on})
+}
+
+// handleListSubsystems lists the restartable subsystems and their restarts
+func (s *Server) handleListSubsystems(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"subsystems": s.sup.List()})
+}
+
+// handleRestartSubsystem stops and restarts one subsystem, leaving the rest
+// and all peer connections running
+func (s *Server) handleRestartSubsystem(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := s.sup.Restart(name)
+	if errors.Is(err, supervisor.ErrUnknownSubsystem) {
+		writeError(w, http.StatusNotFound, "unknown subsystem")
+		return
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Str("subsystem", name).Msg("Subsystem restart failed")
+		writeError(w, http.StatusInternalServerError, "subsystem restart failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"subsystem": name, "restarted": true})
+}
+
+// handleRestartRegion disconnects one region's peers so they reconnect,
+// keeping every other region's connections
+func (s *Server) handleRestartRegion(w http.ResponseWriter, r *http.Request) {
+	region := r.PathValue("region")
+	closed := observer.RestartRegion(region)
+	if closed == 0 {
+		writeError(w, http.StatusNotFound, "no connected peers in region")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"region": region, "disconnected": closed})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
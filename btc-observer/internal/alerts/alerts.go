@@ -0,0 +1,300 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Alert kinds a webhook can subscribe to, each raised by one event type
+const (
+	AlertNewBlock       = "new_block"
+	AlertDoubleSpend    = "double_spend"
+	AlertWatchedAddress = "watched_address"
+	AlertPeerBanned     = "peer_banned"
+	AlertFeeSpike       = "fee_spike"
+)
+
+var alertEvents = map[string]events.Type{
+	AlertNewBlock:       events.BlockReceived,
+	AlertDoubleSpend:    events.DoubleSpendDetected,
+	AlertWatchedAddress: events.AddressActivity,
+	AlertPeerBanned:     events.PeerBanned,
+	AlertFeeSpike:       events.FeeOutlier,
+}
+
+// Config configures webhook alerting
+type Config struct {
+	Webhooks []Webhook `json:"webhooks"`
+	Buffer   int       `json:"buffer"` // events queued before some are dropped
+}
+
+// Webhook is one destination. Each alert is POSTed to URL as JSON; network
+// errors, 429 and 5xx responses are retried with exponential backoff.
+type Webhook struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Alerts  []string          `json:"alerts"` // alert kinds to send (default all)
+	Headers map[string]string `json:"headers,omitempty"`
+
+	TimeoutMs      int `json:"timeout_ms"`         // per attempt (default 5000)
+	MaxAttempts    int `json:"max_attempts"`       // default 5
+	InitialBackoff int `json:"initial_backoff_ms"` // doubled after each failed attempt (default 1000)
+	MaxBackoff     int `json:"max_backoff_ms"`     // default 60000
+	Queue          int `json:"queue"`              // alerts waiting for delivery (default 100)
+}
+
+func (c *Config) applyDefaults() {
+	if c.Buffer <= 0 {
+		c.Buffer = 1000
+	}
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		if len(w.Alerts) == 0 {
+			w.Alerts = []string{AlertNewBlock, AlertDoubleSpend, AlertWatchedAddress, AlertPeerBanned, AlertFeeSpike}
+		}
+		if w.TimeoutMs <= 0 {
+			w.TimeoutMs = 5000
+		}
+		if w.MaxAttempts <= 0 {
+			w.MaxAttempts = 5
+		}
+		if w.InitialBackoff <= 0 {
+			w.InitialBackoff = 1000
+		}
+		if w.MaxBackoff <= 0 {
+			w.MaxBackoff = 60000
+		}
+		if w.Queue <= 0 {
+			w.Queue = 100
+		}
+	}
+}
+
+// Alert is the JSON body POSTed to a webhook
+type Alert struct {
+	Alert    string      `json:"alert"`
+	Observer string      `json:"observer"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+// hook is a webhook with its delivery queue
+type hook struct {
+	Webhook
+	kinds  map[events.Type]string
+	queue  chan Alert
+	client *http.Client
+}
+
+// Start sends alerts to the configured webhooks until ctx is done, then
+// delivers the alerts still queued before marking wg done. Each webhook
+// delivers from its own queue, so a slow or failing one doesn't hold up the
+// others; alerts that don't fit in its queue are dropped.
+func Start(ctx context.Context, cfg Config, observerID string, wg *sync.WaitGroup) error {
+	cfg.applyDefaults()
+	if len(cfg.Webhooks) == 0 {
+		return fmt.Errorf("alerts require at least one webhook")
+	}
+	var hooks []*hook
+	names := make(map[string]bool)
+	for _, w := range cfg.Webhooks {
+		if w.Name == "" || names[w.Name] {
+			return fmt.Errorf("webhook names must be unique and not empty (got %q)", w.Name)
+		}
+		names[w.Name] = true
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q: invalid url %q", w.Name, w.URL)
+		}
+		h := &hook{
+			Webhook: w,
+			kinds:   make(map[events.Type]string),
+			queue:   make(chan Alert, w.Queue),
+			client:  &http.Client{Timeout: time.Duration(w.TimeoutMs) * time.Millisecond},
+		}
+		for _, kind := range w.Alerts {
+			t, ok := alertEvents[kind]
+			if !ok {
+				return fmt.Errorf("webhook %q: unknown alert %q", w.Name, kind)
+			}
+			h.kinds[t] = kind
+		}
+		hooks = append(hooks, h)
+	}
+
+	wg.Add(len(hooks) + 1)
+	for _, h := range hooks {
+		go h.deliver(ctx, wg)
+	}
+
+	ch, unsubscribe := events.Subscribe("alerts", cfg.Buffer)
+	blocks := newRecentBlocks()
+	dispatch := func(e events.Event) {
+		// Every peer that delivers a block publishes it; alert once
+		if b, ok := e.Data.(events.BlockArrival); ok && !blocks.add(b.Hash) {
+			return
+		}
+		for _, h := range hooks {
+			kind, ok := h.kinds[e.Type]
+			if !ok {
+				continue
+			}
+			select {
+			case h.queue <- Alert{Alert: kind, Observer: observerID, Time: e.Time, Data: e.Data}:
+			default:
+				metrics.AlertDeliveries.WithLabelValues(h.Name, "dropped").Inc()
+			}
+		}
+	}
+	go func() {
+		defer wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				for drained := false; !drained; {
+					select {
+					case e := <-ch:
+						dispatch(e)
+					default:
+						drained = true
+					}
+				}
+				for _, h := range hooks {
+					close(h.queue)
+				}
+				return
+			case e := <-ch:
+				dispatch(e)
+			}
+		}
+	}()
+	return nil
+}
+
+// deliver sends queued alerts one at a time until ctx is done, then the
+// ones left until the queue is closed
+func (h *hook) deliver(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			for a := range h.queue {
+				h.deliverAlert(context.Background(), a)
+			}
+			return
+		case a, ok := <-h.queue:
+			if !ok {
+				return
+			}
+			h.deliverAlert(ctx, a)
+		}
+	}
+}
+
+// deliverAlert sends one alert. One cut off by ctx ending is tried once
+// more, since ctx ends at shutdown.
+func (h *hook) deliverAlert(ctx context.Context, a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		logger.Log.Warn().Err(err).Str("webhook", h.Name).Str("alert", a.Alert).Msg("Failed to encode alert")
+		return
+	}
+	result := "ok"
+	err = h.send(ctx, body)
+	if err != nil && ctx.Err() != nil {
+		err = h.post(context.Background(), body)
+	}
+	if err != nil {
+		result = "failed"
+		logger.Log.Warn().Err(err).Str("webhook", h.Name).Str("alert", a.Alert).Msg("Alert delivery failed")
+	}
+	metrics.AlertDeliveries.WithLabelValues(h.Name, result).Inc()
+}
+
+// errPermanent marks a response that retrying won't fix
+var errPermanent = errors.New("permanent failure")
+
+// send POSTs body, retrying with exponential backoff up to MaxAttempts
+func (h *hook) send(ctx context.Context, body []byte) error {
+	backoff := time.Duration(h.InitialBackoff) * time.Millisecond
+	maxBackoff := time.Duration(h.MaxBackoff) * time.Millisecond
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = h.post(ctx, body); err == nil || errors.Is(err, errPermanent) || attempt >= h.MaxAttempts {
+			return err
+		}
+		metrics.AlertRetries.WithLabelValues(h.Name).Inc()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (h *hook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	return err
+}
+
+// recentBlocksSize is how many block hashes are remembered for deduplication
+const recentBlocksSize = 64
+
+// recentBlocks remembers the last block hashes alerted on
+type recentBlocks struct {
+	seen  map[events.Hash]bool
+	order []events.Hash
+}
+
+func newRecentBlocks() *recentBlocks {
+	return &recentBlocks{seen: make(map[events.Hash]bool)}
+}
+
+// add reports whether hash is new, remembering it
+func (r *recentBlocks) add(hash events.Hash) bool {
+	if r.seen[hash] {
+		return false
+	}
+	r.seen[hash] = true
+	r.order = append(r.order, hash)
+	if len(r.order) > recentBlocksSize {
+		delete(r.seen, r.order[0])
+		r.order = r.order[1:]
+	}
+	return true
+}
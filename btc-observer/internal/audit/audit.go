@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	defaultInterval = 6 * time.Hour
+	defaultWindow   = 24 * time.Hour
+)
+
+// Config schedules the dataset integrity audit
+type Config struct {
+	IntervalSeconds int `json:"interval_seconds"` // default 6 hours
+
+	// WindowHours is how far back each run checks (default 24); data older
+	// than that was checked by earlier runs
+	WindowHours int `json:"window_hours"`
+
+	// KeepReportsDays deletes audit reports older than this (0 keeps them)
+	KeepReportsDays int `json:"keep_reports_days"`
+}
+
+// Start cross-checks recently recorded data on an interval, exporting each
+// check's discrepancy count and storing it in the audit_reports table
+func Start(ctx context.Context, db *database.DB, cfg Config) error {
+	if cfg.WindowHours < 0 || cfg.KeepReportsDays < 0 {
+		return fmt.Errorf("audit window and report retention must not be negative")
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	window := time.Duration(cfg.WindowHours) * time.Hour
+	if window <= 0 {
+		window = defaultWindow
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			run(db, window, cfg.KeepReportsDays)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func run(db *database.DB, window time.Duration, keepDays int) {
+	start := time.Now()
+	since := start.UTC().Add(-window)
+	findings, err := db.RunAudit(since)
+	took := time.Since(start)
+	for _, f := range findings {
+		metrics.AuditDiscrepancies.WithLabelValues(f.Check).Set(float64(f.Discrepancies))
+		if f.Discrepancies > 0 {
+			logger.Log.Warn().
+				Str("check", f.Check).
+				Int64("discrepancies", f.Discrepancies).
+				Strs("samples", f.Samples).
+				Time("since", since).
+				Msg("Audit found inconsistent data")
+		}
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Audit failed")
+	}
+	if err := db.RecordAuditReport(since, findings, took); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to record audit report")
+	}
+	metrics.AuditLastRun.SetToCurrentTime()
+	logger.Log.Info().Int("checks", len(findings)).Dur("took", took).Msg("Audit complete")
+
+	if keepDays > 0 {
+		if _, err := db.PruneAuditReports(time.Now().UTC().AddDate(0, 0, -keepDays)); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to prune audit reports")
+		}
+	}
+}
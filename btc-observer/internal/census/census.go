@@ -0,0 +1,193 @@
+package census
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Options controls a crawl
+type Options struct {
+	// Concurrency is how many nodes are contacted at once
+	Concurrency int
+
+	// Timeout bounds each visit: connect, handshake and the getaddr reply
+	Timeout time.Duration
+
+	// MaxNodes stops queuing newly learned addresses after this many
+	MaxNodes int
+}
+
+// DefaultOptions crawls 256 nodes at a time with a 10 second budget each
+var DefaultOptions = Options{Concurrency: 256, Timeout: 10 * time.Second, MaxNodes: 100000}
+
+// maxUserAgent is the user agent column width
+const maxUserAgent = 200
+
+// Progress reports a crawl in flight
+type Progress struct {
+	Attempted int64
+	Reachable int64
+	Queued    int64
+}
+
+// Crawler handshakes with every address it learns, starting from seeds, and
+// asks each node for more addresses, like bitnodes does. It never stays
+// connected or relays anything.
+type Crawler struct {
+	opts      Options
+	attempted atomic.Int64
+	reachable atomic.Int64
+	queued    atomic.Int64
+}
+
+// New creates a crawler
+func New(opts Options) *Crawler {
+	return &Crawler{opts: opts}
+}
+
+// Progress returns the counts so far
+func (c *Crawler) Progress() Progress {
+	return Progress{Attempted: c.attempted.Load(), Reachable: c.reachable.Load(), Queued: c.queued.Load()}
+}
+
+// Run crawls until no unvisited addresses remain (or ctx is cancelled) and
+// returns the snapshot summary and the nodes that completed a handshake
+func (c *Crawler) Run(ctx context.Context, seeds []string) (database.CensusSnapshot, []database.CensusNode) {
+	snap := database.CensusSnapshot{Network: protocol.ActiveNetwork().Name, StartedAt: time.Now()}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var nodes []database.CensusNode
+
+	// Buffered to MaxNodes so queuing never blocks a worker
+	work := make(chan string, c.opts.MaxNodes)
+	var pending sync.WaitGroup
+	enqueue := func(addr string) {
+		mu.Lock()
+		if seen[addr] || len(seen) >= c.opts.MaxNodes {
+			mu.Unlock()
+			return
+		}
+		seen[addr] = true
+		mu.Unlock()
+		pending.Add(1)
+		c.queued.Add(1)
+		work <- addr
+	}
+	for _, addr := range seeds {
+		enqueue(addr)
+	}
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for addr := range work {
+				c.queued.Add(-1)
+				if ctx.Err() == nil {
+					node, learned, ok := c.visit(ctx, addr)
+					c.attempted.Add(1)
+					if ok {
+						c.reachable.Add(1)
+						mu.Lock()
+						nodes = append(nodes, node)
+						mu.Unlock()
+					}
+					for _, a := range learned {
+						enqueue(a)
+					}
+				}
+				pending.Done()
+			}
+		}()
+	}
+	pending.Wait()
+	close(work)
+	workers.Wait()
+
+	snap.FinishedAt = time.Now()
+	snap.Attempted = int(c.attempted.Load())
+	snap.Reachable = len(nodes)
+	return snap, nodes
+}
+
+// visit handshakes with one node and waits for its reply to getaddr. It
+// returns the node's details, the IP addresses it gossiped, and whether the
+// handshake completed.
+func (c *Crawler) visit(ctx context.Context, addr string) (database.CensusNode, []string, bool) {
+	node := database.CensusNode{Address: addr, Network: protocol.AddressNetwork(addr)}
+	start := time.Now()
+	dialer := net.Dialer{Timeout: c.opts.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return node, nil, false
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(c.opts.Timeout))
+
+	versionBytes, err := protocol.EncodeVersionMessage(protocol.CreateVersionMessage(addr))
+	if err != nil {
+		return node, nil, false
+	}
+	if _, err := conn.Write(protocol.CreateMessagePacket("version", versionBytes)); err != nil {
+		return node, nil, false
+	}
+
+	var handshaked bool
+	var learned []string
+	for {
+		msg, err := protocol.ReadMessage(conn)
+		if err != nil {
+			// Timed out waiting for addresses, or the node hung up
+			return node, learned, handshaked
+		}
+		switch protocol.CommandString(msg) {
+		case "version":
+			v, err := protocol.ParseVersionMessage(msg.Payload)
+			if err != nil {
+				return node, nil, false
+			}
+			node.ProtocolVersion = v.Version
+			node.Services = v.Services
+			node.UserAgent = v.UserAgent
+			if len(node.UserAgent) > maxUserAgent {
+				node.UserAgent = node.UserAgent[:maxUserAgent]
+			}
+			node.StartHeight = v.StartHeight
+			node.Relay = v.Relay
+			node.HandshakeMs = int(time.Since(start).Milliseconds())
+			handshaked = true
+			// sendaddrv2 (BIP155) must precede verack
+			conn.Write(protocol.CreateMessagePacket("sendaddrv2", []byte{}))
+			conn.Write(protocol.CreateMessagePacket("verack", []byte{}))
+			conn.Write(protocol.CreateMessagePacket("getaddr", []byte{}))
+		case "ping":
+			conn.Write(protocol.CreateMessagePacket("pong", msg.Payload))
+		case "addr", "addrv2":
+			var addrs []protocol.NetAddress
+			if protocol.CommandString(msg) == "addrv2" {
+				addrs, _ = protocol.ParseAddrV2Message(msg.Payload)
+			} else {
+				addrs, _ = protocol.ParseAddrEntries(msg.Payload)
+			}
+			for _, a := range addrs {
+				if (a.Network == protocol.NetIPv4 || a.Network == protocol.NetIPv6) && a.Port != 0 {
+					learned = append(learned, a.String())
+				}
+			}
+			node.AddrsReturned += len(addrs)
+			// A single address is the node announcing itself; the getaddr
+			// reply is a large batch
+			if len(addrs) > 1 {
+				return node, learned, handshaked
+			}
+		}
+	}
+}
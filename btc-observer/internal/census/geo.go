@@ -0,0 +1,62 @@
+package census
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/observer"
+)
+
+const (
+	geoBatchSize = 100
+	// geoBatchPause keeps batch lookups under the geolocation API's limit of
+	// 15 requests a minute
+	geoBatchPause = 4500 * time.Millisecond
+)
+
+// Geolocate fills in the location of each node. Batches that fail are left
+// without a location. It returns how many nodes were located.
+func Geolocate(ctx context.Context, nodes []database.CensusNode) int {
+	byHost := make(map[string][]int)
+	var hosts []string
+	for i, n := range nodes {
+		host, _, err := net.SplitHostPort(n.Address)
+		if err != nil {
+			continue
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], i)
+	}
+
+	located := 0
+	for start := 0; start < len(hosts); start += geoBatchSize {
+		if start > 0 {
+			select {
+			case <-ctx.Done():
+				return located
+			case <-time.After(geoBatchPause):
+			}
+		}
+		geoMap, err := observer.LookupGeoBatch(hosts[start:min(start+geoBatchSize, len(hosts))])
+		if err != nil {
+			continue
+		}
+		for host, geo := range geoMap {
+			for _, i := range byHost[host] {
+				n := &nodes[i]
+				n.CountryCode = geo.CountryCode
+				n.City = geo.City
+				n.Latitude = geo.Lat
+				n.Longitude = geo.Lon
+				n.ASN = geo.AS
+				n.OrgName = geo.Org
+				located++
+			}
+		}
+	}
+	return located
+}
@@ -0,0 +1,92 @@
+package chain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Checkpoint modes
+const (
+	CheckpointModeReject     = "reject"
+	CheckpointModeQuarantine = "quarantine"
+)
+
+// Reasons a block fails the checkpoint check
+const (
+	CheckpointBelow       = "below_checkpoint"
+	CheckpointConflict    = "conflicts_checkpoint"
+	CheckpointUnconnected = "unconnected"
+)
+
+// Checkpoint is a trusted (height, hash) pair that all accepted blocks must descend from
+type Checkpoint struct {
+	Height int32  `json:"height"`
+	Hash   string `json:"hash"`
+	Mode   string `json:"mode"`
+}
+
+// CheckpointGuard tracks which block hashes are known to descend from a checkpoint
+type CheckpointGuard struct {
+	sync.Mutex
+	height   int32
+	hash     [32]byte
+	mode     string
+	verified map[[32]byte]bool
+}
+
+// NewCheckpointGuard validates a checkpoint and creates a guard for it.
+// The hash is given in the usual display (reversed) byte order.
+func NewCheckpointGuard(cp *Checkpoint) (*CheckpointGuard, error) {
+	raw, err := hex.DecodeString(cp.Hash)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("invalid checkpoint hash %q", cp.Hash)
+	}
+	mode := cp.Mode
+	if mode == "" {
+		mode = CheckpointModeQuarantine
+	}
+	if mode != CheckpointModeReject && mode != CheckpointModeQuarantine {
+		return nil, fmt.Errorf("invalid checkpoint mode %q", cp.Mode)
+	}
+
+	g := &CheckpointGuard{
+		height:   cp.Height,
+		mode:     mode,
+		verified: make(map[[32]byte]bool),
+	}
+	for i := range raw {
+		g.hash[i] = raw[len(raw)-1-i]
+	}
+	g.verified[g.hash] = true
+	return g, nil
+}
+
+// Height returns the checkpoint height
+func (g *CheckpointGuard) Height() int32 {
+	return g.height
+}
+
+// Hash returns the checkpoint hash in internal byte order
+func (g *CheckpointGuard) Hash() [32]byte {
+	return g.hash
+}
+
+// Quarantine reports whether failing blocks should be stored for inspection
+func (g *CheckpointGuard) Quarantine() bool {
+	return g.mode == CheckpointModeQuarantine
+}
+
+// IsVerified reports whether a hash is known to descend from the checkpoint
+func (g *CheckpointGuard) IsVerified(hash [32]byte) bool {
+	g.Lock()
+	defer g.Unlock()
+	return g.verified[hash]
+}
+
+// MarkVerified records a hash as descending from the checkpoint
+func (g *CheckpointGuard) MarkVerified(hash [32]byte) {
+	g.Lock()
+	defer g.Unlock()
+	g.verified[hash] = true
+}
@@ -0,0 +1,141 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+const (
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval = 2016
+	// retargetAdjustmentFactor bounds how far a single retarget may move the target
+	retargetAdjustmentFactor = 4
+)
+
+// AnomalyBadDifficulty is recorded when a block's bits don't match the expected retarget
+const AnomalyBadDifficulty = "bad_difficulty"
+
+// powLimit is the highest allowed proof-of-work target (mainnet 0x1d00ffff)
+var powLimit = CompactToBig(0x1d00ffff)
+
+// targetTimespan is the desired duration of one retarget interval
+var targetTimespan = 14 * 24 * time.Hour
+
+// skipDifficulty disables difficulty checks on networks whose rules they
+// don't model: min-difficulty blocks on testnet, no retargeting on regtest,
+// and retarget intervals other than 2016 blocks
+var skipDifficulty bool
+
+// skipPoW accepts headers without comparing their hash to the target, for
+// coins whose proof-of-work hash isn't the block hash
+var skipPoW bool
+
+// SetParams selects the network whose difficulty rules blocks are checked
+// against. With checkPoW false header hashes aren't checked against their
+// target. Call it before validating any block.
+func SetParams(p *chaincfg.Params, checkPoW bool) {
+	powLimit = CompactToBig(p.PowLimitBits)
+	targetTimespan = p.TargetTimespan
+	skipDifficulty = p.ReduceMinDifficulty || p.PoWNoRetargeting ||
+		p.TargetTimePerBlock <= 0 || p.TargetTimespan/p.TargetTimePerBlock != RetargetInterval
+	skipPoW = !checkPoW
+}
+
+// CompactToBig decodes the compact "bits" representation into a target.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if negative {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact encodes a target into the compact "bits" representation.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tmp := new(big.Int).Set(target)
+		mantissa = uint32(tmp.Rsh(tmp, 8*(exponent-3)).Bits()[0])
+	}
+
+	// The sign bit is reserved, so shift the mantissa down if it would be set
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// NextRequiredBits computes the bits expected for the first block of a new
+// retarget interval, given the bits in effect and the timestamps of the first
+// and last blocks of the interval that just ended.
+func NextRequiredBits(prevBits uint32, firstTime, lastTime time.Time) uint32 {
+	actual := lastTime.Sub(firstTime)
+	minTimespan := targetTimespan / retargetAdjustmentFactor
+	maxTimespan := targetTimespan * retargetAdjustmentFactor
+	if actual < minTimespan {
+		actual = minTimespan
+	} else if actual > maxTimespan {
+		actual = maxTimespan
+	}
+
+	newTarget := CompactToBig(prevBits)
+	newTarget.Mul(newTarget, big.NewInt(int64(actual/time.Second)))
+	newTarget.Div(newTarget, big.NewInt(int64(targetTimespan/time.Second)))
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
+	}
+	return BigToCompact(newTarget)
+}
+
+// IsRetargetHeight reports whether a block at this height starts a new retarget interval
+func IsRetargetHeight(height int32) bool {
+	return height > 0 && height%RetargetInterval == 0
+}
+
+// CheckDifficulty compares a block's bits to the value expected from its parent.
+// intervalStart is only consulted at retarget heights and may be zero otherwise.
+func CheckDifficulty(height int32, bits, prevBits uint32, intervalStart, prevTime time.Time) *Anomaly {
+	if skipDifficulty {
+		return nil
+	}
+	expected := prevBits
+	if IsRetargetHeight(height) {
+		expected = NextRequiredBits(prevBits, intervalStart, prevTime)
+	}
+	if bits == expected {
+		return nil
+	}
+	return &Anomaly{
+		Kind:   AnomalyBadDifficulty,
+		Detail: fmt.Sprintf("bits 0x%08x, expected 0x%08x", bits, expected),
+	}
+}
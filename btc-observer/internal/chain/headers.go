@@ -0,0 +1,344 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons a header is rejected by the header chain
+const (
+	HeaderUnconnected   = "unconnected"
+	HeaderBadTarget     = "bad_target"
+	HeaderBadPoW        = "bad_pow"
+	HeaderBadDifficulty = "bad_difficulty"
+)
+
+// Header is the part of a block header the header chain tracks
+type Header struct {
+	Hash      [32]byte
+	PrevHash  [32]byte
+	Timestamp uint32
+	Bits      uint32
+}
+
+// Tip is a block on the header chain
+type Tip struct {
+	Height int32
+	Hash   [32]byte
+}
+
+// Reorg is a change of best chain that didn't just extend the old tip
+type Reorg struct {
+	ForkPoint    Tip
+	OldTip       Tip
+	NewTip       Tip
+	Disconnected []Tip // old best chain above the fork point, highest first
+	Connected    []Tip // new best chain above the fork point, lowest first
+}
+
+// HeaderError is returned for a header that can't join the chain
+type HeaderError struct {
+	Hash   [32]byte
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	var rev [32]byte
+	for i := range e.Hash {
+		rev[i] = e.Hash[len(e.Hash)-1-i]
+	}
+	return fmt.Sprintf("header %x: %s", rev, e.Reason)
+}
+
+type headerNode struct {
+	Header
+	height int32
+	work   *big.Int // cumulative from the root
+	parent *headerNode
+}
+
+// HeaderChain is an in-memory tree of block headers rooted at a trusted
+// header. Headers must link to one already in the tree and carry valid
+// proof of work; the branch with the most cumulative work is the best chain.
+// Only the keep blocks below the best tip are held, so deeper reorgs go
+// unnoticed.
+type HeaderChain struct {
+	sync.Mutex
+	nodes map[[32]byte]*headerNode
+	best  *headerNode
+	keep  int32
+}
+
+// NewHeaderChain starts a header chain at a trusted root header
+func NewHeaderChain(root Header, height int32, keep int) *HeaderChain {
+	n := &headerNode{Header: root, height: height, work: headerWork(root.Bits)}
+	return &HeaderChain{
+		nodes: map[[32]byte]*headerNode{root.Hash: n},
+		best:  n,
+		keep:  int32(keep),
+	}
+}
+
+// Tip returns the best chain tip
+func (c *HeaderChain) Tip() Tip {
+	c.Lock()
+	defer c.Unlock()
+	return Tip{Height: c.best.height, Hash: c.best.Hash}
+}
+
+// Work returns the best chain's cumulative work from the root
+func (c *HeaderChain) Work() *big.Int {
+	c.Lock()
+	defer c.Unlock()
+	return new(big.Int).Set(c.best.work)
+}
+
+// OnBest reports whether a header is on the best chain
+func (c *HeaderChain) OnBest(hash [32]byte) bool {
+	c.Lock()
+	defer c.Unlock()
+	n := c.nodes[hash]
+	if n == nil {
+		return false
+	}
+	b := c.best
+	for b != nil && b.height > n.height {
+		b = b.parent
+	}
+	return b == n
+}
+
+// Branch is a leaf of the header tree: the best tip or a competing one
+type Branch struct {
+	Tip
+	Work      *big.Int // cumulative from the root
+	ForkPoint Tip      // highest block shared with the best chain; the tip itself for the best chain
+	Length    int32    // blocks above the fork point
+	Best      bool
+}
+
+// Branches returns every tip in the tree, most work first, so the first is
+// always the best chain. A branch forking below the oldest held header is
+// reported with the oldest header on its own side as the fork point.
+func (c *HeaderChain) Branches() []Branch {
+	c.Lock()
+	defer c.Unlock()
+	hasChild := make(map[*headerNode]bool, len(c.nodes))
+	for _, n := range c.nodes {
+		if n.parent != nil {
+			hasChild[n.parent] = true
+		}
+	}
+	onBest := make(map[*headerNode]bool)
+	for n := c.best; n != nil; n = n.parent {
+		onBest[n] = true
+	}
+
+	var branches []Branch
+	for _, n := range c.nodes {
+		if hasChild[n] {
+			continue
+		}
+		b := Branch{
+			Tip:  Tip{Height: n.height, Hash: n.Hash},
+			Work: new(big.Int).Set(n.work),
+			Best: n == c.best,
+		}
+		fork := n
+		for !onBest[fork] && fork.parent != nil {
+			fork = fork.parent
+		}
+		b.ForkPoint = Tip{Height: fork.height, Hash: fork.Hash}
+		b.Length = n.height - fork.height
+		branches = append(branches, b)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].Best != branches[j].Best {
+			return branches[i].Best
+		}
+		if cmp := branches[i].Work.Cmp(branches[j].Work); cmp != 0 {
+			return cmp > 0
+		}
+		return branches[i].Height > branches[j].Height
+	})
+	return branches
+}
+
+// Contains reports whether a header is in the tree
+func (c *HeaderChain) Contains(hash [32]byte) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.nodes[hash] != nil
+}
+
+// Locator returns a block locator over the best chain, newest first: the tip
+// and the nine blocks below it, then doubling steps back, ending at the oldest
+// header held
+func (c *HeaderChain) Locator() [][32]byte {
+	c.Lock()
+	defer c.Unlock()
+	var path []*headerNode
+	for n := c.best; n != nil; n = n.parent {
+		path = append(path, n)
+	}
+
+	var hashes [][32]byte
+	step := 1
+	for i := 0; i < len(path); i += step {
+		hashes = append(hashes, path[i].Hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	if oldest := path[len(path)-1]; hashes[len(hashes)-1] != oldest.Hash {
+		hashes = append(hashes, oldest.Hash)
+	}
+	return hashes
+}
+
+// Add connects headers, given in chain order, to the tree. Known headers are
+// skipped. It stops at the first header that fails validation, keeping the
+// ones before it. A reorg is returned when the best chain switched branches.
+func (c *HeaderChain) Add(headers []Header) (added int, reorg *Reorg, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	oldBest := c.best
+	for _, h := range headers {
+		if c.nodes[h.Hash] != nil {
+			continue
+		}
+		parent := c.nodes[h.PrevHash]
+		if parent == nil {
+			err = &HeaderError{Hash: h.Hash, Reason: HeaderUnconnected}
+			break
+		}
+		if reason := c.checkHeader(h, parent); reason != "" {
+			err = &HeaderError{Hash: h.Hash, Reason: reason}
+			break
+		}
+
+		n := &headerNode{Header: h, height: parent.height + 1, parent: parent}
+		n.work = new(big.Int).Add(parent.work, headerWork(h.Bits))
+		c.nodes[h.Hash] = n
+		added++
+		if n.work.Cmp(c.best.work) > 0 {
+			c.best = n
+		}
+	}
+
+	if c.best != oldBest {
+		reorg = c.reorgFrom(oldBest)
+		c.prune()
+	}
+	return added, reorg, err
+}
+
+// checkHeader validates a header's proof of work and its bits against its
+// parent's. Bits at a retarget height are only checked when the interval
+// start is still held.
+func (c *HeaderChain) checkHeader(h Header, parent *headerNode) string {
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return HeaderBadTarget
+	}
+	if !skipPoW && hashToBig(h.Hash).Cmp(target) > 0 {
+		return HeaderBadPoW
+	}
+
+	height := parent.height + 1
+	var intervalStart time.Time
+	if IsRetargetHeight(height) {
+		first := parent
+		for first != nil && first.height > height-RetargetInterval {
+			first = first.parent
+		}
+		if first == nil {
+			return ""
+		}
+		intervalStart = time.Unix(int64(first.Timestamp), 0)
+	}
+	if CheckDifficulty(height, h.Bits, parent.Bits, intervalStart, time.Unix(int64(parent.Timestamp), 0)) != nil {
+		return HeaderBadDifficulty
+	}
+	return ""
+}
+
+// reorgFrom describes the switch from old to the current best tip, or
+// returns nil if the new tip extends old. A fork below the oldest held
+// header also returns nil, as there is nothing left to disconnect.
+func (c *HeaderChain) reorgFrom(old *headerNode) *Reorg {
+	a, b := old, c.best
+	var disconnected, connected []Tip
+	for b != nil && b.height > a.height {
+		connected = append(connected, Tip{Height: b.height, Hash: b.Hash})
+		b = b.parent
+	}
+	if b == nil || b == a {
+		return nil
+	}
+	for a != nil && a.height > b.height {
+		disconnected = append(disconnected, Tip{Height: a.height, Hash: a.Hash})
+		a = a.parent
+	}
+	for a != nil && b != nil && a != b {
+		disconnected = append(disconnected, Tip{Height: a.height, Hash: a.Hash})
+		connected = append(connected, Tip{Height: b.height, Hash: b.Hash})
+		a, b = a.parent, b.parent
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+
+	for i, j := 0, len(connected)-1; i < j; i, j = i+1, j-1 {
+		connected[i], connected[j] = connected[j], connected[i]
+	}
+	return &Reorg{
+		ForkPoint:    Tip{Height: a.height, Hash: a.Hash},
+		OldTip:       Tip{Height: old.height, Hash: old.Hash},
+		NewTip:       Tip{Height: c.best.height, Hash: c.best.Hash},
+		Disconnected: disconnected,
+		Connected:    connected,
+	}
+}
+
+// prune drops headers more than keep blocks below the best tip, on any branch
+func (c *HeaderChain) prune() {
+	floor := c.best.height - c.keep
+	if floor <= 0 {
+		return
+	}
+	for hash, n := range c.nodes {
+		if n.height < floor {
+			delete(c.nodes, hash)
+		}
+	}
+	for _, n := range c.nodes {
+		if n.parent != nil && n.parent.height < floor {
+			n.parent = nil
+		}
+	}
+}
+
+// headerWork is the expected number of hashes for a block at these bits,
+// 2^256 / (target+1)
+func headerWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	target.Add(target, big.NewInt(1))
+	return target.Div(new(big.Int).Lsh(big.NewInt(1), 256), target)
+}
+
+// hashToBig reads a block hash as the little-endian number compared with the target
+func hashToBig(hash [32]byte) *big.Int {
+	var rev [32]byte
+	for i := range hash {
+		rev[i] = hash[len(hash)-1-i]
+	}
+	return new(big.Int).SetBytes(rev[:])
+}
@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// MedianTimeBlocks is the number of previous blocks used for median time past
+	MedianTimeBlocks = 11
+	// MaxFutureBlockTime is how far ahead of local time a block timestamp may be
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+// Anomaly kinds recorded for blocks that fail sanity checks
+const (
+	AnomalyTimeBeforeMTP = "time_before_mtp"
+	AnomalyTimeTooFuture = "time_too_far_future"
+	AnomalyBadMerkleRoot = "bad_merkle_root"
+)
+
+// Anomaly describes a single validation failure for a block
+type Anomaly struct {
+	Kind   string
+	Detail string
+}
+
+// MedianTimePast returns the median of up to the last 11 block timestamps.
+// Returns the zero time if no timestamps are given.
+func MedianTimePast(timestamps []time.Time) time.Time {
+	if len(timestamps) == 0 {
+		return time.Time{}
+	}
+	if len(timestamps) > MedianTimeBlocks {
+		timestamps = timestamps[:MedianTimeBlocks]
+	}
+	sorted := make([]time.Time, len(timestamps))
+	copy(sorted, timestamps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	return sorted[len(sorted)/2]
+}
+
+// CheckTimestamp validates a block timestamp against the median time past of its
+// ancestors (newest first) and the local clock. MTP is only enforced when a full
+// window of ancestors is available.
+func CheckTimestamp(blockTime time.Time, ancestorTimes []time.Time, now time.Time) []Anomaly {
+	var anomalies []Anomaly
+
+	if len(ancestorTimes) >= MedianTimeBlocks {
+		mtp := MedianTimePast(ancestorTimes)
+		if !blockTime.After(mtp) {
+			anomalies = append(anomalies, Anomaly{
+				Kind:   AnomalyTimeBeforeMTP,
+				Detail: fmt.Sprintf("timestamp %s not after median time past %s", blockTime.UTC().Format(time.RFC3339), mtp.UTC().Format(time.RFC3339)),
+			})
+		}
+	}
+
+	if blockTime.After(now.Add(MaxFutureBlockTime)) {
+		anomalies = append(anomalies, Anomaly{
+			Kind:   AnomalyTimeTooFuture,
+			Detail: fmt.Sprintf("timestamp %s is %s ahead of local time", blockTime.UTC().Format(time.RFC3339), blockTime.Sub(now).Round(time.Second)),
+		})
+	}
+
+	return anomalies
+}
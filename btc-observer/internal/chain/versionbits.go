@@ -0,0 +1,7 @@
+package chain
+
+// PeriodStart returns the first height of the retarget (and BIP9 signaling)
+// period containing height
+func PeriodStart(height int32) int32 {
+	return height - height%RetargetInterval
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/keato/btc-observer/internal/admin"
+	"github.com/keato/btc-observer/internal/alerts"
+	"github.com/keato/btc-observer/internal/audit"
+	"github.com/keato/btc-observer/internal/chain"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/diskwatch"
+	"github.com/keato/btc-observer/internal/doh"
+	"github.com/keato/btc-observer/internal/experiment"
+	"github.com/keato/btc-observer/internal/health"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/models"
+	"github.com/keato/btc-observer/internal/msgstore"
+	"github.com/keato/btc-observer/internal/observer"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/keato/btc-observer/internal/publish/clickhouse"
+	"github.com/keato/btc-observer/internal/publish/kafka"
+	"github.com/keato/btc-observer/internal/retention"
+	"github.com/keato/btc-observer/internal/rollup"
+	"github.com/keato/btc-observer/internal/scripts"
+	"github.com/keato/btc-observer/internal/shutdown"
+	"github.com/keato/btc-observer/internal/stream"
+	"github.com/keato/btc-observer/internal/triangulate"
+)
+
+// Config is the observer configuration file. Database settings sit at the top
+// level for compatibility with existing config files; optional subsystems get
+// their own sections.
+type Config struct {
+	database.Config
+
+	// ManualMigrations stops the observer at startup when schema migrations
+	// are pending instead of applying them; run lens migrate first
+	ManualMigrations bool `json:"manual_migrations,omitempty"`
+
+	// OnSchemaMismatch is what the observer does when the database schema
+	// can't be brought to this build's version: fail (default) or observe,
+	// which keeps observing without the database
+	OnSchemaMismatch string `json:"on_schema_mismatch,omitempty"`
+
+	// Storage is what the peer pipeline records to: postgres (default) or
+	// memory, which persists nothing and leaves SQL-backed subsystems empty
+	Storage string `json:"storage,omitempty"`
+
+	// Network is mainnet (default), testnet3, testnet4, signet or regtest
+	Network string `json:"network,omitempty"`
+
+	// Coin defines a Bitcoin-like network by its parameters instead
+	Coin *protocol.CoinConfig `json:"coin,omitempty"`
+
+	// Modes selects what this process runs: observe, record and/or analyze (default all)
+	Modes []string `json:"modes,omitempty"`
+
+	// Checkpoint pins block validation to a trusted (height, hash)
+	Checkpoint *chain.Checkpoint `json:"checkpoint,omitempty"`
+
+	// CustomMetrics are operator-defined gauges backed by SQL queries
+	CustomMetrics []metrics.CustomMetric `json:"custom_metrics,omitempty"`
+
+	// StatsD mirrors core metrics to a StatsD/DogStatsD agent
+	StatsD *metrics.StatsDConfig `json:"statsd,omitempty"`
+
+	// MetricsSnapshot writes every metric and the run's headline numbers to
+	// a file at graceful shutdown
+	MetricsSnapshot *metrics.SnapshotConfig `json:"metrics_snapshot,omitempty"`
+
+	// Shutdown budgets the phased teardown on exit (default 10 seconds)
+	Shutdown *shutdown.Config `json:"shutdown,omitempty"`
+
+	// PeerLogDir writes debug-captured peers' message logs to per-peer files
+	PeerLogDir string `json:"peer_log_dir,omitempty"`
+
+	// MessageStore keeps the last hours of raw wire messages, queryable via the admin API
+	MessageStore *msgstore.Config `json:"message_store,omitempty"`
+
+	// Stream serves a WebSocket live feed of observed events at /ws on the metrics port
+	Stream *stream.Config `json:"stream,omitempty"`
+
+	// Health tunes when /healthz and /readyz on the metrics port report the
+	// observer degraded
+	Health *health.Config `json:"health,omitempty"`
+
+	// Kafka publishes tx, block and propagation events to Kafka topics
+	Kafka *kafka.Config `json:"kafka,omitempty"`
+
+	// ClickHouse writes propagation events to ClickHouse, alongside or instead of Postgres
+	ClickHouse *clickhouse.Config `json:"clickhouse,omitempty"`
+
+	// Alerts POSTs blocks, double-spends, watched address activity, banned
+	// peers and fee spikes to webhooks
+	Alerts *alerts.Config `json:"alerts,omitempty"`
+
+	// Admin enables the authenticated admin HTTP API
+	Admin *admin.Config `json:"admin,omitempty"`
+
+	// Features turns runtime feature flags on or off at startup (all default
+	// on); the admin API toggles them later
+	Features map[string]bool `json:"features,omitempty"`
+
+	// MemoryBudgetMB sheds load when heap usage exceeds this many megabytes (0 disables)
+	MemoryBudgetMB int `json:"memory_budget_mb,omitempty"`
+
+	// DiskWatch monitors free space where log and capture files are written
+	DiskWatch *diskwatch.Config `json:"disk_watch,omitempty"`
+
+	// StaticPeers are always connected to, in addition to discovered peers
+	StaticPeers []string `json:"static_peers,omitempty"`
+
+	// BlockOrdering adds miner coinbase tags and tunes the comparison of
+	// block transaction order with fee-rate order
+	BlockOrdering *observer.BlockOrderingConfig `json:"block_ordering,omitempty"`
+
+	// GeoCheck flags peers whose ping RTT is impossible for their GeoIP location
+	GeoCheck *observer.GeoCheckConfig `json:"geo_check,omitempty"`
+
+	// PeerScores weights peer misbehavior into the penalty scores that rank
+	// and ban candidates (defaults apply without it)
+	PeerScores *observer.PeerScoreConfig `json:"peer_scores,omitempty"`
+
+	// ClaimChecks reports peers whose behavior contradicts their claimed
+	// services, start height and fee filter, excluding persistent ones from
+	// measurements
+	ClaimChecks *observer.ClaimCheckConfig `json:"claim_checks,omitempty"`
+
+	// LatencyProfiles keeps and persists each peer's announcement delay
+	// behind the first announcer, optionally rotating out slow peers
+	LatencyProfiles *observer.LatencyProfileConfig `json:"latency_profiles,omitempty"`
+
+	// Expansion suggests countries and ASes to add vantage points in, from
+	// the nodes discovery and addr gossip show there
+	Expansion *observer.ExpansionConfig `json:"expansion,omitempty"`
+
+	// GeoCache keeps resolved peer locations in the database, so discovery
+	// and the crawler don't look the same IPs up again
+	GeoCache *observer.GeoCacheConfig `json:"geo_cache,omitempty"`
+
+	// GeoBackfill geolocates peers recorded in peer_connections without a
+	// location
+	GeoBackfill *observer.GeoBackfillConfig `json:"geo_backfill,omitempty"`
+
+	// Crawl adds peers discovered from addr gossip to the candidate pool
+	Crawl *observer.CrawlConfig `json:"crawl,omitempty"`
+
+	// DialSources binds outbound peer connections to local addresses or
+	// interfaces per region or peer group
+	DialSources *observer.DialSourceConfig `json:"dial_sources,omitempty"`
+
+	// Tor connects to .onion peers through a SOCKS5 proxy
+	Tor *observer.TorConfig `json:"tor,omitempty"`
+
+	// DisableDiscovery skips bitnodes discovery so only static peers are used
+	DisableDiscovery bool `json:"disable_discovery,omitempty"`
+
+	// DoH resolves DNS seeds and hostnames over HTTPS instead of system DNS
+	DoH *doh.Config `json:"doh,omitempty"`
+
+	// ScriptTemplates add to (or override by name) the built-in script templates
+	ScriptTemplates []scripts.Definition `json:"script_templates,omitempty"`
+
+	// Projects tag transactions for named studies recorded side by side,
+	// each with its own watchlist, templates, fee floor and peer filter
+	Projects []observer.ProjectConfig `json:"projects,omitempty"`
+
+	// Backpressure throttles peers when the ingest pipeline falls behind
+	Backpressure *observer.BackpressureConfig `json:"backpressure,omitempty"`
+
+	// Bandwidth caps per-peer and total peer traffic
+	Bandwidth *observer.BandwidthConfig `json:"bandwidth,omitempty"`
+
+	// Traffic records each peer's traffic by message type
+	Traffic *observer.TrafficConfig `json:"traffic,omitempty"`
+
+	// BlockDownload limits which regions download block bodies
+	BlockDownload *observer.BlockDownloadConfig `json:"block_download,omitempty"`
+
+	// ParseErrors controls how messages that fail to parse are recorded
+	ParseErrors *observer.ParseErrorConfig `json:"parse_errors,omitempty"`
+
+	// InvLimits bounds per-peer announcement tracking and getdata batch size
+	InvLimits *observer.InvLimitsConfig `json:"inv_limits,omitempty"`
+
+	// CompactBlocks enables BIP152 compact block relay
+	CompactBlocks *observer.CompactBlockConfig `json:"compact_blocks,omitempty"`
+
+	// Prevouts resolves block inputs the database doesn't know over a
+	// Bitcoin Core node's RPC, so block fee totals are complete
+	Prevouts *observer.PrevoutConfig `json:"prevouts,omitempty"`
+
+	// FeeAlerts alerts on transactions paying extreme fee rates or fees
+	FeeAlerts *observer.FeeAlertConfig `json:"fee_alerts,omitempty"`
+
+	// LowFee tracks transactions relayed below the minimum relay fee rate
+	// and the peers that relayed them
+	LowFee *observer.LowFeeConfig `json:"low_fee,omitempty"`
+
+	// LockTimes tracks transactions seen before their locktime expired
+	// until they become valid and confirm
+	LockTimes *observer.LockTimeConfig `json:"lock_times,omitempty"`
+
+	// RelayProbes sends crafted transactions on a test network to measure
+	// which peers relay them and how fast
+	RelayProbes *observer.RelayProbeConfig `json:"relay_probes,omitempty"`
+
+	// ForkMonitor periodically compares each peer's chain with ours
+	ForkMonitor *observer.ForkMonitorConfig `json:"fork_monitor,omitempty"`
+
+	// HeaderChain tracks the best header chain from peers' headers and
+	// orphans stored blocks on reorgs
+	HeaderChain *observer.HeaderChainConfig `json:"header_chain,omitempty"`
+
+	// HeaderAnnouncements asks peers to announce new blocks with headers
+	// (sendheaders) and records those announcements alongside inv ones
+	HeaderAnnouncements bool `json:"header_announcements,omitempty"`
+
+	// Models enables compiled-in peer-scoring and propagation models by name
+	Models []models.Config `json:"models,omitempty"`
+
+	// RegionOverridesFile maps IPs, prefixes or ASNs to custom region labels
+	RegionOverridesFile string `json:"region_overrides_file,omitempty"`
+
+	// ObserverID identifies this instance when several share a database
+	// (default: hostname)
+	ObserverID string `json:"observer_id,omitempty"`
+
+	// ObserverLocation is where this instance runs; set it to contribute
+	// observations to origin triangulation
+	ObserverLocation *database.Location `json:"observer_location,omitempty"`
+
+	// Triangulation estimates tx origins from several instances' first-seen times
+	Triangulation *triangulate.Config `json:"triangulation,omitempty"`
+
+	// Experiments switch the peer selection policy on a schedule and tag the
+	// data recorded while they run
+	Experiments []experiment.Config `json:"experiments,omitempty"`
+
+	// Rollups aggregate raw observations into hourly and daily tables
+	Rollups *rollup.Config `json:"rollups,omitempty"`
+
+	// Retention prunes old raw observations so the database doesn't grow
+	// without bound
+	Retention *retention.Config `json:"retention,omitempty"`
+
+	// Audit periodically cross-checks recorded data for internal consistency
+	Audit *audit.Config `json:"audit,omitempty"`
+}
+
+// NetworkParams returns the network selected by network or defined by coin
+func (c *Config) NetworkParams() (*protocol.NetworkParams, error) {
+	if c.Coin == nil {
+		return protocol.LookupNetwork(c.Network)
+	}
+	if c.Network != "" {
+		return nil, fmt.Errorf("set either network or coin, not both")
+	}
+	return c.Coin.Network()
+}
+
+// Load reads the config file and applies environment variable overrides
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// RecordPeerAddresses upserts addresses gossiped to us by peerAddr and
+// records peerAddr as one of their sources
+func (db *DB) RecordPeerAddresses(peerAddr string, addrs []protocol.NetAddress) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	stmt, err := dbTx.Prepare(
+		`INSERT INTO peer_addresses (address, network, services, advertised_at, first_heard_at, last_heard_at, first_heard_from, heard_count)
+		 VALUES ($1, $2, $3, $4, $5, $5, $6, 1)
+		 ON CONFLICT (address) DO UPDATE SET
+		     services = $3,
+		     advertised_at = GREATEST(peer_addresses.advertised_at, $4),
+		     last_heard_at = $5,
+		     heard_count = peer_addresses.heard_count + 1`)
+	if err != nil {
+		return fmt.Errorf("prepare: %w", err)
+	}
+	defer stmt.Close()
+
+	sourceStmt, err := dbTx.Prepare(
+		`INSERT INTO peer_address_sources (address, source_peer, first_heard_at, last_heard_at, heard_count)
+		 VALUES ($1, $2, $3, $3, 1)
+		 ON CONFLICT (address, source_peer) DO UPDATE SET
+		     last_heard_at = $3,
+		     heard_count = peer_address_sources.heard_count + 1`)
+	if err != nil {
+		return fmt.Errorf("prepare sources: %w", err)
+	}
+	defer sourceStmt.Close()
+
+	now := time.Now().UTC()
+	for _, a := range addrs {
+		advertised := time.Unix(int64(a.Timestamp), 0).UTC()
+		if _, err := stmt.Exec(a.String(), a.Network, int64(a.Services), advertised, now, peerAddr); err != nil {
+			return fmt.Errorf("upsert %s: %w", a.String(), err)
+		}
+		if _, err := sourceStmt.Exec(a.String(), peerAddr, now); err != nil {
+			return fmt.Errorf("upsert source of %s: %w", a.String(), err)
+		}
+	}
+	return dbTx.Commit()
+}
+
+// GossipedAddresses returns up to limit addresses on a network (e.g.
+// torv3), most recently heard first
+func (db *DB) GossipedAddresses(network string, limit int) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT address FROM peer_addresses
+		 WHERE network = $1
+		 ORDER BY last_heard_at DESC
+		 LIMIT $2`,
+		network, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addrs []string
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, rows.Err()
+}
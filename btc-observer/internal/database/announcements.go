@@ -0,0 +1,37 @@
+package database
+
+// RecordBlockAnnouncement records a peer announcing a block, via inv or
+// headers, with the delay from the block's first announcement
+func (db *DB) RecordBlockAnnouncement(blockHash []byte, peerAddr, via string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO block_announcements (block_hash, peer_addr, via, announcement_time, delay_from_first_ms, observer_id)
+		 VALUES ($1, $2, $3, NOW(),
+		     COALESCE(
+		         EXTRACT(EPOCH FROM (NOW() - (SELECT MIN(announcement_time) FROM block_announcements WHERE block_hash = $1))) * 1000,
+		         0
+		     )::INT,
+		     $4
+		 )`,
+		blockHash, peerAddr, via, db.observerID,
+	)
+	return err
+}
+
+// RecordBlockArrival records the first time a peer made a block known to
+// us, by inv, headers or a compact block pushed unasked, with the delay from
+// the block's first arrival from any peer. Later arrivals from the same peer
+// are ignored.
+func (db *DB) RecordBlockArrival(blockHash []byte, peerAddr, via string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO block_propagation (block_hash, peer_addr, observer_id, via, announcement_time, delay_from_first_ms)
+		 VALUES ($1, $2, $3, $4, NOW(),
+		     COALESCE(
+		         EXTRACT(EPOCH FROM (NOW() - (SELECT MIN(announcement_time) FROM block_propagation WHERE block_hash = $1))) * 1000,
+		         0
+		     )::INT
+		 )
+		 ON CONFLICT DO NOTHING`,
+		blockHash, peerAddr, db.observerID, via,
+	)
+	return err
+}
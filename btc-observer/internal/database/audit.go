@@ -0,0 +1,146 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/lib/pq"
+)
+
+// auditSampleSize is how many offending keys each audit finding keeps
+const auditSampleSize = 5
+
+// AuditFinding is the result of one integrity check
+type AuditFinding struct {
+	Check         string
+	Discrepancies int64
+	Samples       []string // a few offending block/tx hashes or peer addresses
+}
+
+// auditCheck counts rows that break one consistency rule. Its query takes the
+// window start as $1 and returns each offending key with the total count.
+type auditCheck struct {
+	name  string
+	hash  bool // keys are hashes, shown in display order
+	query string
+}
+
+var auditChecks = []auditCheck{
+	{
+		// Every transaction of a fully parsed block is recorded with it
+		name: "blocks_missing_transactions",
+		hash: true,
+		query: `SELECT b.block_hash, COUNT(*) OVER ()
+			FROM blocks b
+			WHERE b.first_seen_at >= $1 AND b.parse_error IS NULL AND b.tx_count >
+			      (SELECT COUNT(*) FROM transactions t WHERE t.block_hash = b.block_hash)
+			ORDER BY b.first_seen_at DESC`,
+	},
+	{
+		// Confirmed transactions other than coinbases were relayed to us first
+		name: "confirmed_transactions_unobserved",
+		hash: true,
+		query: `SELECT t.tx_hash, COUNT(*) OVER ()
+			FROM transactions t JOIN blocks b ON b.block_hash = t.block_hash
+			WHERE b.first_seen_at >= $1
+			  AND NOT EXISTS (SELECT 1 FROM transaction_observations o WHERE o.tx_hash = t.tx_hash)
+			  AND NOT EXISTS (SELECT 1 FROM transaction_inputs i
+			                  WHERE i.tx_hash = t.tx_hash AND i.prev_output_idx = 4294967295)
+			ORDER BY b.first_seen_at DESC`,
+	},
+	{
+		// Observations of confirmed transactions name the block they confirmed in
+		name: "observations_unconfirmed",
+		hash: true,
+		query: `SELECT t.tx_hash, COUNT(*) OVER ()
+			FROM transactions t
+			JOIN blocks b ON b.block_hash = t.block_hash
+			JOIN transaction_observations o ON o.tx_hash = t.tx_hash
+			WHERE b.first_seen_at >= $1 AND o.in_block_hash IS NULL
+			ORDER BY b.first_seen_at DESC`,
+	},
+	{
+		// Propagation events come from peers we recorded a connection to
+		name: "propagation_unknown_peers",
+		query: `SELECT convert_to(e.peer_addr, 'UTF8'), COUNT(*) OVER ()
+			FROM (SELECT DISTINCT peer_addr FROM propagation_events
+			      WHERE announcement_time >= $1) e
+			WHERE NOT EXISTS (SELECT 1 FROM peer_connections pc WHERE pc.peer_addr = e.peer_addr)
+			ORDER BY e.peer_addr`,
+	},
+}
+
+// AuditChecks returns the names of the integrity checks RunAudit runs
+func AuditChecks() []string {
+	names := make([]string, len(auditChecks))
+	for i, c := range auditChecks {
+		names[i] = c.name
+	}
+	return names
+}
+
+// RunAudit cross-checks data recorded since the window start, returning one
+// finding per check
+func (db *DB) RunAudit(since time.Time) ([]AuditFinding, error) {
+	findings := make([]AuditFinding, 0, len(auditChecks))
+	for _, c := range auditChecks {
+		f, err := db.runAuditCheck(c, since)
+		if err != nil {
+			return findings, fmt.Errorf("audit check %s: %w", c.name, err)
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+func (db *DB) runAuditCheck(c auditCheck, since time.Time) (AuditFinding, error) {
+	f := AuditFinding{Check: c.name}
+	rows, err := db.conn.Query(fmt.Sprintf("%s LIMIT %d", c.query, auditSampleSize), since)
+	if err != nil {
+		return f, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key, &f.Discrepancies); err != nil {
+			return f, err
+		}
+		if c.hash {
+			f.Samples = append(f.Samples, fmt.Sprintf("%x", protocol.ReverseBytes(key)))
+		} else {
+			f.Samples = append(f.Samples, string(key))
+		}
+	}
+	return f, rows.Err()
+}
+
+// RecordAuditReport stores the findings of one audit run
+func (db *DB) RecordAuditReport(since time.Time, findings []AuditFinding, took time.Duration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, f := range findings {
+		_, err := tx.Exec(
+			`INSERT INTO audit_reports (check_name, discrepancies, samples, window_start, duration_ms, ran_at)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			f.Check, f.Discrepancies, pq.Array(nonNil(f.Samples)), since, took.Milliseconds(), now)
+		if err != nil {
+			return fmt.Errorf("insert audit report: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// PruneAuditReports deletes audit reports older than the cutoff
+func (db *DB) PruneAuditReports(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM audit_reports WHERE ran_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
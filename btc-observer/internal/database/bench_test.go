@@ -0,0 +1,108 @@
+package database
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// The write-path benchmarks need a scratch PostgreSQL database migrated with
+// lens migrate. They insert rows, so never point them at production:
+//
+//	BENCH_DB=1 DB_HOST=localhost DB_USER=... DB_NAME=bench go test ./internal/database -run XXX -bench .
+
+func benchDB(b *testing.B) *DB {
+	b.Helper()
+	if os.Getenv("BENCH_DB") == "" {
+		b.Skip("set BENCH_DB=1 and DB_* to run database benchmarks")
+	}
+	cfg := Config{DBHost: "localhost", DBPort: 5432, DBUser: "postgres", DBName: "bitcoin_intel"}
+	if err := cfg.ApplyEnv(); err != nil {
+		b.Fatal(err)
+	}
+	db, err := NewFromConfig(&cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// txFixtures loads the well-formed transactions from the protocol corpus
+func txFixtures(b *testing.B) []*protocol.Transaction {
+	b.Helper()
+	paths, err := filepath.Glob("../protocol/testdata/corpus/tx/*.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var txs []*protocol.Transaction
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if tx, err := protocol.ParseTxMessage(data); err == nil {
+			txs = append(txs, tx)
+		}
+	}
+	if len(txs) == 0 {
+		b.Fatal("no tx fixtures found")
+	}
+	return txs
+}
+
+// freshHash gives each iteration a new key so inserts don't hit ON CONFLICT
+func freshHash() [32]byte {
+	var h [32]byte
+	rand.Read(h[:])
+	return h
+}
+
+func BenchmarkRecordTransaction(b *testing.B) {
+	db := benchDB(b)
+	txs := txFixtures(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx := *txs[i%len(txs)]
+		tx.TxID = freshHash()
+		if err := db.RecordTransaction(&tx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRecordObservation(b *testing.B) {
+	db := benchDB(b)
+	b.Run("first_seen", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			h := freshHash()
+			if err := db.RecordObservation(h[:], "203.0.113.7:8333"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("repeat", func(b *testing.B) {
+		h := freshHash()
+		for i := 0; i < b.N; i++ {
+			if err := db.RecordObservation(h[:], "203.0.113.7:8333"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRecordObservationParallel(b *testing.B) {
+	db := benchDB(b)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h := freshHash()
+			if err := db.RecordObservation(h[:], "203.0.113.7:8333"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
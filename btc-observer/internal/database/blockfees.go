@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// BlockFees is the fee and subsidy accounting of a block
+type BlockFees struct {
+	Subsidy       int64 // new coins the block may create at its height
+	CoinbaseValue int64 // what the coinbase actually paid out
+	// TotalFees sums the fees of the non-coinbase transactions. It is only
+	// known when every input's prevout was resolved (UnresolvedTxs is 0).
+	TotalFees     int64
+	UnresolvedTxs int
+}
+
+// RecordBlockFees stores a block's fee and subsidy accounting
+func (db *DB) RecordBlockFees(blockHash []byte, f BlockFees) error {
+	_, err := db.conn.Exec(
+		`UPDATE blocks SET subsidy = $2, coinbase_value = $3, total_fees = $4, unresolved_fee_txs = $5
+		 WHERE block_hash = $1`,
+		blockHash, f.Subsidy, f.CoinbaseValue,
+		sql.NullInt64{Int64: f.TotalFees, Valid: f.UnresolvedTxs == 0}, f.UnresolvedTxs,
+	)
+	return err
+}
+
+// RecordInputValues fills in the values of a stored transaction's inputs
+// from prevouts resolved outside the database, in input order, and records
+// its fee
+func (db *DB) RecordInputValues(tx *protocol.Transaction, values []int64) (*Fee, error) {
+	if len(values) != len(tx.Inputs) {
+		return nil, fmt.Errorf("%d input values for %d inputs", len(values), len(tx.Inputs))
+	}
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	var totalInput, totalOutput int64
+	for i, v := range values {
+		totalInput += v
+		_, err := dbTx.Exec(
+			`UPDATE transaction_inputs SET value_satoshis = $3
+			 WHERE tx_hash = $1 AND input_index = $2 AND value_satoshis IS NULL`,
+			tx.TxID[:], i, v)
+		if err != nil {
+			return nil, fmt.Errorf("update input %d: %w", i, err)
+		}
+	}
+	for _, out := range tx.Outputs {
+		totalOutput += out.Value
+	}
+	fee := &Fee{Satoshis: totalInput - totalOutput, Weight: tx.Weight()}
+	_, err = dbTx.Exec(
+		`UPDATE transactions SET total_input = $2, fee_satoshis = $3, fee_rate = $4 WHERE tx_hash = $1`,
+		tx.TxID[:], totalInput, fee.Satoshis, fee.Rate(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update fee: %w", err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		return nil, err
+	}
+	return fee, nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CensusSnapshot summarizes one crawl of the reachable network
+type CensusSnapshot struct {
+	Network    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Attempted  int
+	Reachable  int
+}
+
+// CensusNode is a node that completed a handshake during a crawl
+type CensusNode struct {
+	Address         string
+	Network         string
+	ProtocolVersion int32
+	UserAgent       string
+	Services        uint64
+	StartHeight     int32
+	Relay           bool
+	HandshakeMs     int
+	AddrsReturned   int
+	CountryCode     string
+	City            string
+	Latitude        float64
+	Longitude       float64
+	ASN             string
+	OrgName         string
+}
+
+// RecordCensus stores a crawl snapshot with its reachable nodes and returns
+// the snapshot id
+func (db *DB) RecordCensus(snap CensusSnapshot, nodes []CensusNode) (int64, error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	var id int64
+	err = dbTx.QueryRow(
+		`INSERT INTO census_snapshots (network, started_at, finished_at, attempted, reachable)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id`,
+		snap.Network, snap.StartedAt.UTC(), snap.FinishedAt.UTC(), snap.Attempted, snap.Reachable,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert snapshot: %w", err)
+	}
+
+	stmt, err := dbTx.Prepare(
+		`INSERT INTO census_nodes (snapshot_id, address, network, protocol_version, user_agent, services,
+		     start_height, relay, handshake_ms, addrs_returned, country_code, city, latitude, longitude, asn, org_name)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		 ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("prepare: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, n := range nodes {
+		located := n.CountryCode != ""
+		_, err := stmt.Exec(id, n.Address, n.Network, n.ProtocolVersion, n.UserAgent, int64(n.Services),
+			n.StartHeight, n.Relay, n.HandshakeMs, n.AddrsReturned,
+			sql.NullString{String: n.CountryCode, Valid: located},
+			sql.NullString{String: n.City, Valid: located},
+			sql.NullFloat64{Float64: n.Latitude, Valid: located},
+			sql.NullFloat64{Float64: n.Longitude, Valid: located},
+			sql.NullString{String: n.ASN, Valid: located},
+			sql.NullString{String: n.OrgName, Valid: located},
+		)
+		if err != nil {
+			return 0, fmt.Errorf("insert node %s: %w", n.Address, err)
+		}
+	}
+	return id, dbTx.Commit()
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ChainPoint is a block on the stored chain
+type ChainPoint struct {
+	Height int32
+	Hash   [32]byte
+}
+
+// PeerChainStatus is the result of comparing a peer's chain with ours
+type PeerChainStatus struct {
+	Status       string
+	OurTipHeight int32
+	PeerTip      *ChainPoint
+	ForkPoint    *ChainPoint
+}
+
+// ChainLocator returns a block locator over the stored chain, newest first:
+// the tip and the nine blocks below it, then doubling steps back. Heights
+// missing from the store are skipped.
+func (db *DB) ChainLocator() ([]ChainPoint, error) {
+	var tip sql.NullInt64
+	if err := db.conn.QueryRow(`SELECT MAX(height) FROM blocks`).Scan(&tip); err != nil {
+		return nil, err
+	}
+	if !tip.Valid {
+		return nil, nil
+	}
+
+	var heights []int64
+	step := int64(1)
+	for h := tip.Int64; h >= 0; h -= step {
+		heights = append(heights, h)
+		if len(heights) >= 10 {
+			step *= 2
+		}
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT height, block_hash FROM blocks WHERE height = ANY($1) ORDER BY height DESC`,
+		pq.Array(heights),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanChainPoints(rows)
+}
+
+// ChainHashes returns the stored block hashes for heights in [from, to]
+func (db *DB) ChainHashes(from, to int32) (map[int32][32]byte, error) {
+	rows, err := db.conn.Query(
+		`SELECT height, block_hash FROM blocks WHERE height BETWEEN $1 AND $2`,
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points, err := scanChainPoints(rows)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[int32][32]byte, len(points))
+	for _, p := range points {
+		hashes[p.Height] = p.Hash
+	}
+	return hashes, nil
+}
+
+// ChainHeader is a stored block's header, as needed to rebuild the header chain
+type ChainHeader struct {
+	ChainPoint
+	PrevHash  [32]byte
+	Timestamp time.Time
+	Bits      uint32
+}
+
+// RecentChain walks the stored chain back from the highest block and returns
+// up to n headers, oldest first. The walk stops at a gap or at a block stored
+// without its bits.
+func (db *DB) RecentChain(n int) ([]ChainHeader, error) {
+	rows, err := db.conn.Query(
+		`WITH RECURSIVE chain AS (
+		     SELECT block_hash, prev_block_hash, height, timestamp, bits, 1 AS depth
+		     FROM blocks
+		     WHERE height = (SELECT MAX(height) FROM blocks) AND bits IS NOT NULL
+		     UNION ALL
+		     SELECT b.block_hash, b.prev_block_hash, b.height, b.timestamp, b.bits, c.depth + 1
+		     FROM blocks b
+		     JOIN chain c ON b.block_hash = c.prev_block_hash
+		     WHERE c.depth < $1 AND b.bits IS NOT NULL
+		 )
+		 SELECT block_hash, prev_block_hash, height, timestamp, bits FROM chain ORDER BY depth DESC`,
+		n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query chain: %w", err)
+	}
+	defer rows.Close()
+
+	var headers []ChainHeader
+	for rows.Next() {
+		var h ChainHeader
+		var hash, prev []byte
+		var bits int64
+		if err := rows.Scan(&hash, &prev, &h.Height, &h.Timestamp, &bits); err != nil {
+			return nil, fmt.Errorf("scan chain: %w", err)
+		}
+		copy(h.Hash[:], hash)
+		copy(h.PrevHash[:], prev)
+		h.Bits = uint32(bits)
+		headers = append(headers, h)
+	}
+	return headers, rows.Err()
+}
+
+func scanChainPoints(rows *sql.Rows) ([]ChainPoint, error) {
+	var points []ChainPoint
+	for rows.Next() {
+		var p ChainPoint
+		var hash []byte
+		if err := rows.Scan(&p.Height, &hash); err != nil {
+			return nil, err
+		}
+		if len(hash) != 32 {
+			continue
+		}
+		copy(p.Hash[:], hash)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// RecordPeerChainStatus stores the latest chain comparison for a peer.
+// divergent_since keeps the time a peer was first seen on a divergent chain
+// until it rejoins ours.
+func (db *DB) RecordPeerChainStatus(peerAddr string, s PeerChainStatus) error {
+	var peerTipHash, forkHash []byte
+	var peerTipHeight, forkHeight sql.NullInt32
+	if s.PeerTip != nil {
+		peerTipHash = s.PeerTip.Hash[:]
+		peerTipHeight = sql.NullInt32{Int32: s.PeerTip.Height, Valid: true}
+	}
+	if s.ForkPoint != nil {
+		forkHash = s.ForkPoint.Hash[:]
+		forkHeight = sql.NullInt32{Int32: s.ForkPoint.Height, Valid: true}
+	}
+
+	_, err := db.conn.Exec(
+		`INSERT INTO peer_chain_status (peer_addr, status, our_tip_height, peer_tip_hash, peer_tip_height,
+		     fork_hash, fork_height, checked_at, divergent_since)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $2 = 'divergent' THEN $8::TIMESTAMP END)
+		 ON CONFLICT (peer_addr) DO UPDATE SET
+		     status = EXCLUDED.status,
+		     our_tip_height = EXCLUDED.our_tip_height,
+		     peer_tip_hash = EXCLUDED.peer_tip_hash,
+		     peer_tip_height = EXCLUDED.peer_tip_height,
+		     fork_hash = EXCLUDED.fork_hash,
+		     fork_height = EXCLUDED.fork_height,
+		     checked_at = EXCLUDED.checked_at,
+		     divergent_since = CASE
+		         WHEN EXCLUDED.status <> 'divergent' THEN NULL
+		         ELSE COALESCE(peer_chain_status.divergent_since, EXCLUDED.checked_at)
+		     END`,
+		peerAddr, s.Status, s.OurTipHeight, peerTipHash, peerTipHeight, forkHash, forkHeight, time.Now().UTC(),
+	)
+	return err
+}
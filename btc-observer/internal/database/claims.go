@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ClaimReport compares what a peer claimed in its version and feefilter
+// messages with how it behaved over one report interval
+type ClaimReport struct {
+	PeerAddr        string
+	Region          string
+	Services        uint64
+	StartHeight     int32
+	BestHeight      int32 // our best chain height when reported, 0 if unknown
+	FeeFilter       int64 // sat/kB, 0 if the peer sent none
+	BlocksRequested int
+	BlocksServed    int
+	BlocksNotFound  int
+	HeadersAsked    int
+	HeadersAnswered int
+	TxsRelayed      int // with a known fee
+	TxsBelowFilter  int
+	Divergences     []string
+	Streak          int // consecutive reports with a divergence
+	CleanStreak     int // consecutive judged reports without one
+	Excluded        bool
+}
+
+// ClaimState is where a peer's claim checks stood at its latest report
+type ClaimState struct {
+	PeerAddr    string
+	Streak      int
+	CleanStreak int
+	Excluded    bool
+}
+
+// SaveClaimReports stores one report interval's claim reports
+func (db *DB) SaveClaimReports(at time.Time, reports []ClaimReport) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	for _, r := range reports {
+		divergences := r.Divergences
+		if divergences == nil {
+			divergences = []string{}
+		}
+		_, err := dbTx.Exec(
+			`INSERT INTO peer_claim_reports (peer_addr, observer_id, region, reported_at, services, start_height,
+			     best_height, fee_filter, blocks_requested, blocks_served, blocks_not_found, headers_asked,
+			     headers_answered, txs_relayed, txs_below_filter, divergences, streak, clean_streak, excluded)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+			r.PeerAddr, db.observerID, r.Region, at, int64(r.Services), r.StartHeight,
+			r.BestHeight, r.FeeFilter, r.BlocksRequested, r.BlocksServed, r.BlocksNotFound, r.HeadersAsked,
+			r.HeadersAnswered, r.TxsRelayed, r.TxsBelowFilter, pq.Array(divergences), r.Streak, r.CleanStreak, r.Excluded,
+		)
+		if err != nil {
+			return fmt.Errorf("insert claim report: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
+
+// ClaimStates returns the state of this observer's peers whose latest claim
+// report left them excluded or on a streak
+func (db *DB) ClaimStates() ([]ClaimState, error) {
+	rows, err := db.conn.Query(
+		`SELECT peer_addr, streak, clean_streak, excluded FROM (
+		     SELECT DISTINCT ON (peer_addr) peer_addr, streak, clean_streak, excluded
+		     FROM peer_claim_reports
+		     WHERE observer_id = $1
+		     ORDER BY peer_addr, reported_at DESC
+		 ) latest
+		 WHERE excluded OR streak > 0`,
+		db.observerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query claim states: %w", err)
+	}
+	defer rows.Close()
+
+	var states []ClaimState
+	for rows.Next() {
+		var s ClaimState
+		if err := rows.Scan(&s.PeerAddr, &s.Streak, &s.CleanStreak, &s.Excluded); err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Conflict outcomes
+const (
+	ConflictOriginal    = "original"    // the first-seen tx confirmed
+	ConflictReplacement = "replacement" // the later tx confirmed
+	ConflictNeither     = "neither"     // a third tx spending the same input confirmed
+)
+
+// ConflictOutcome is a conflict resolved by a block
+type ConflictOutcome struct {
+	Outcome   string
+	ResolveMs int64 // from detection to the block arriving
+}
+
+// ResolveConflicts settles the open conflicts that a block's transactions
+// decide: a pair is resolved by whichever confirmed tx spends one of its
+// inputs. Each side's fee and fee rate are copied at resolution, when both
+// are most likely known.
+func (db *DB) ResolveConflicts(blockHash []byte, blockHeight int32, txHashes [][]byte) ([]ConflictOutcome, error) {
+	rows, err := db.conn.Query(
+		`WITH resolved AS (
+		     SELECT DISTINCT ON (co.original_tx, co.replacement_tx)
+		         co.original_tx, co.replacement_tx, b.tx_hash AS winner
+		     FROM conflict_outcomes co
+		     JOIN transaction_inputs a ON a.tx_hash IN (co.original_tx, co.replacement_tx)
+		     JOIN transaction_inputs b ON b.prev_tx_hash = a.prev_tx_hash AND b.prev_output_idx = a.prev_output_idx
+		     WHERE co.outcome IS NULL AND b.tx_hash = ANY($1)
+		     ORDER BY co.original_tx, co.replacement_tx, b.tx_hash IN (co.original_tx, co.replacement_tx) DESC
+		 )
+		 UPDATE conflict_outcomes co SET
+		     outcome = CASE r.winner WHEN co.original_tx THEN 'original'
+		                             WHEN co.replacement_tx THEN 'replacement'
+		                             ELSE 'neither' END,
+		     winner_tx = r.winner,
+		     original_fee = o.fee_satoshis,
+		     replacement_fee = rp.fee_satoshis,
+		     original_fee_rate = o.fee_rate,
+		     replacement_fee_rate = rp.fee_rate,
+		     winner_fee_delta = CASE r.winner WHEN co.original_tx THEN o.fee_satoshis - rp.fee_satoshis
+		                                      WHEN co.replacement_tx THEN rp.fee_satoshis - o.fee_satoshis END,
+		     resolved_block_hash = $2,
+		     resolved_height = $3,
+		     resolved_at = NOW(),
+		     resolve_ms = (EXTRACT(EPOCH FROM (NOW() - co.detected_at)) * 1000)::BIGINT
+		 FROM resolved r
+		 LEFT JOIN transactions o ON o.tx_hash = r.original_tx
+		 LEFT JOIN transactions rp ON rp.tx_hash = r.replacement_tx
+		 WHERE co.original_tx = r.original_tx AND co.replacement_tx = r.replacement_tx
+		 RETURNING co.outcome, co.resolve_ms`,
+		pq.ByteaArray(txHashes), blockHash, blockHeight,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("resolve conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var outcomes []ConflictOutcome
+	for rows.Next() {
+		var o ConflictOutcome
+		if err := rows.Scan(&o.Outcome, &o.ResolveMs); err != nil {
+			return nil, fmt.Errorf("scan conflict outcome: %w", err)
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes, rows.Err()
+}
@@ -6,14 +6,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/keato/btc-observer/internal/features"
 	"github.com/keato/btc-observer/internal/protocol"
 	_ "github.com/lib/pq"
 )
 
 type DB struct {
 	conn *sql.DB
+
+	// observerID stamps rows that can come from several observer instances
+	// sharing one database
+	observerID string
+
+	// experimentRun stamps observations made during a peer-set experiment
+	// (0 when none is running)
+	experimentRun atomic.Int64
+
+	// discard is set for NewDiscard's DB
+	discard bool
+
+	// skipPropagation stops RecordObservation writing propagation_events,
+	// for when another store keeps them
+	skipPropagation bool
 }
 
 type Config struct {
@@ -33,8 +50,14 @@ func LoadConfig(path string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config file: %w", err)
 	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
 
-	// Environment variables override config file values
+// ApplyEnv overrides config values with DB_* environment variables when set
+func (cfg *Config) ApplyEnv() error {
 	if v := os.Getenv("DB_HOST"); v != "" {
 		cfg.DBHost = v
 	}
@@ -49,11 +72,10 @@ func LoadConfig(path string) (*Config, error) {
 	}
 	if v := os.Getenv("DB_PORT"); v != "" {
 		if port, err := fmt.Sscanf(v, "%d", &cfg.DBPort); port != 1 || err != nil {
-			return nil, fmt.Errorf("invalid DB_PORT: %s", v)
+			return fmt.Errorf("invalid DB_PORT: %s", v)
 		}
 	}
-
-	return &cfg, nil
+	return nil
 }
 
 func New(host string, port int, user, password, dbname string) (*DB, error) {
@@ -78,6 +100,19 @@ func NewFromConfig(cfg *Config) (*DB, error) {
 	return New(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
 }
 
+// SetObserverID sets the instance id stamped on propagation events. Call it
+// before any observations are recorded.
+func (db *DB) SetObserverID(id string) {
+	db.observerID = id
+}
+
+// SkipPropagationEvents stops RecordObservation writing propagation_events
+// rows; first-seen observations are still recorded. Call it before any
+// observations are recorded.
+func (db *DB) SkipPropagationEvents() {
+	db.skipPropagation = true
+}
+
 func (db *DB) Conn() *sql.DB {
 	return db.conn
 }
@@ -97,19 +132,36 @@ type PeerGeoInfo struct {
 	OrgName     string
 }
 
-func (db *DB) RecordPeerConnection(peerAddr string, version *protocol.VersionMessage) error {
-	_, err := db.conn.Exec(
-		`INSERT INTO peer_connections (peer_addr, first_connected_at, last_seen_at, protocol_version, user_agent, services, connection_count)
-		 VALUES ($1, NOW(), NOW(), $2, $3, $4, 1)
+// RecordPeerConnection upserts a peer's version message. For a known peer
+// whose version, services or user agent changed since it was last seen, the
+// transition is recorded in peer_version_changes and returned.
+func (db *DB) RecordPeerConnection(peerAddr string, version *protocol.VersionMessage) (*PeerVersionChange, error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	change, err := recordVersionChange(dbTx, peerAddr, version)
+	if err != nil {
+		return nil, fmt.Errorf("record version change: %w", err)
+	}
+	_, err = dbTx.Exec(
+		`INSERT INTO peer_connections (peer_addr, first_connected_at, last_seen_at, protocol_version, user_agent, services, network, connection_count)
+		 VALUES ($1, NOW(), NOW(), $2, $3, $4, $5, 1)
 		 ON CONFLICT (peer_addr) DO UPDATE SET
 		     last_seen_at = NOW(),
 		     protocol_version = $2,
 		     user_agent = $3,
 		     services = $4,
+		     network = $5,
 		     connection_count = peer_connections.connection_count + 1`,
-		peerAddr, version.Version, version.UserAgent, version.Services,
+		peerAddr, version.Version, version.UserAgent, version.Services, protocol.AddressNetwork(peerAddr),
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return change, dbTx.Commit()
 }
 
 func (db *DB) UpdatePeerGeoInfo(peerAddr string, geo *PeerGeoInfo) error {
@@ -155,36 +207,83 @@ func (db *DB) UpdatePeerLatency(peerAddr string, latencyMs int) error {
 	return err
 }
 
+// UpdatePeerGeoCheck stores a peer's fastest ping, the lowest RTT its GeoIP
+// location allows, and whether the location is suspect
+func (db *DB) UpdatePeerGeoCheck(peerAddr string, minRTTMs, boundMs int, suspect bool) error {
+	_, err := db.conn.Exec(
+		`UPDATE peer_connections SET
+		     min_rtt_ms = $2,
+		     geo_min_rtt_ms = $3,
+		     geo_suspect = $4
+		 WHERE peer_addr = $1`,
+		peerAddr, minRTTMs, boundMs, suspect,
+	)
+	return err
+}
+
 
+// RecordObservation records that peerAddr announced txHash. Each peer's
+// first announcement is kept in transaction_peer_observations, and the
+// transaction's first sighting and count of distinct peers in
+// transaction_observations.
 func (db *DB) RecordObservation(txHash []byte, peerAddr string) error {
 	_, err := db.conn.Exec(
-		`INSERT INTO transaction_observations (tx_hash, first_seen_at, first_peer_addr)
-		 VALUES ($1, NOW(), $2)
+		`WITH peer AS (
+		     INSERT INTO transaction_peer_observations (tx_hash, peer_addr, observer_id, first_seen_at)
+		     VALUES ($1, $2, $3, NOW())
+		     ON CONFLICT DO NOTHING
+		     RETURNING tx_hash
+		 )
+		 INSERT INTO transaction_observations (tx_hash, first_seen_at, first_peer_addr, experiment_run_id)
+		 SELECT tx_hash, NOW(), $2, $4 FROM peer
 		 ON CONFLICT (tx_hash) DO UPDATE SET peer_count = transaction_observations.peer_count + 1`,
-		txHash, peerAddr,
+		txHash, peerAddr, db.observerID, db.currentExperimentRun(),
 	)
-	if err != nil {
+	if err != nil || db.skipPropagation {
 		return err
 	}
 
 	// Record propagation event with delay from first observation
 	_, err = db.conn.Exec(
-		`INSERT INTO propagation_events (tx_hash, peer_addr, announcement_time, delay_from_first_ms)
+		`INSERT INTO propagation_events (tx_hash, peer_addr, announcement_time, delay_from_first_ms, observer_id, experiment_run_id)
 		 VALUES ($1, $2, NOW(),
 		     COALESCE(
 		         EXTRACT(EPOCH FROM (NOW() - (SELECT first_seen_at FROM transaction_observations WHERE tx_hash = $1))) * 1000,
 		         0
-		     )::INT
+		     )::INT,
+		     $3, $4
 		 )`,
-		txHash, peerAddr,
+		txHash, peerAddr, db.observerID, db.currentExperimentRun(),
 	)
 	return err
 }
 
+// Fee is what a transaction pays, known once every input's value is
+type Fee struct {
+	Satoshis int64
+	Weight   int
+}
+
+// Rate is the fee rate in sat/vB, over the virtual size rounded up to a
+// whole vbyte as Bitcoin Core does
+func (f Fee) Rate() float64 {
+	if f.Weight <= 0 {
+		return 0
+	}
+	return float64(f.Satoshis) / float64((f.Weight+3)/4)
+}
+
 func (db *DB) RecordTransaction(tx *protocol.Transaction) error {
+	_, err := db.RecordTransactionFee(tx)
+	return err
+}
+
+// RecordTransactionFee records tx like RecordTransaction and returns its fee,
+// or nil if the value of any input is unknown
+func (db *DB) RecordTransactionFee(tx *protocol.Transaction) (*Fee, error) {
 	dbTx, err := db.conn.Begin()
 	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+		return nil, fmt.Errorf("begin transaction: %w", err)
 	}
 	defer dbTx.Rollback()
 
@@ -193,28 +292,29 @@ func (db *DB) RecordTransaction(tx *protocol.Transaction) error {
 		totalOutput += out.Value
 	}
 
-	// Calculate weight: non-witness data * 4 + witness data
-	// For non-segwit: weight = size * 4
-	// For segwit: we'd need to track witness size separately (approximation for now)
-	weight := tx.SizeBytes * 4
-	if tx.Segwit {
-		// Rough approximation: segwit txs are ~25% witness data on average
-		weight = tx.SizeBytes * 3
+	weight := tx.Weight()
+	var wtxid []byte
+	if features.Enabled(features.WitnessStorage) {
+		wtxid = tx.WTxID[:]
 	}
-
 	_, err = dbTx.Exec(
-		`INSERT INTO transactions (tx_hash, size_bytes, weight, input_count, output_count, total_output)
-		 VALUES ($1, $2, $3, $4, $5, $6)
+		`INSERT INTO transactions (tx_hash, wtxid, size_bytes, weight, vsize, input_count, output_count, total_output)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		 ON CONFLICT DO NOTHING`,
-		tx.TxID[:], tx.SizeBytes, weight, len(tx.Inputs), len(tx.Outputs), totalOutput,
+		tx.TxID[:], wtxid, tx.SizeBytes, weight, tx.VSize(), len(tx.Inputs), len(tx.Outputs), totalOutput,
 	)
 	if err != nil {
-		return fmt.Errorf("insert transaction: %w", err)
+		return nil, fmt.Errorf("insert transaction: %w", err)
 	}
 
 	totalInput := int64(0)
 	inputsFound := 0
 	for i, in := range tx.Inputs {
+		witnessSize := 0
+		if wtxid != nil {
+			witnessSize = in.WitnessSize
+		}
+
 		// Look up address and value from the output being spent
 		var address sql.NullString
 		var valueSatoshis sql.NullInt64
@@ -230,14 +330,14 @@ func (db *DB) RecordTransaction(tx *protocol.Transaction) error {
 		}
 
 		_, err = dbTx.Exec(
-			`INSERT INTO transaction_inputs (tx_hash, input_index, prev_tx_hash, prev_output_idx, script_sig, address, value_satoshis)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`INSERT INTO transaction_inputs (tx_hash, input_index, prev_tx_hash, prev_output_idx, script_sig, witness_size, address, value_satoshis)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			 ON CONFLICT DO NOTHING`,
-			tx.TxID[:], i, in.PrevTxHash[:], in.PrevIndex, in.ScriptSig,
+			tx.TxID[:], i, in.PrevTxHash[:], in.PrevIndex, in.ScriptSig, witnessSize,
 			address, valueSatoshis,
 		)
 		if err != nil {
-			return fmt.Errorf("insert input %d: %w", i, err)
+			return nil, fmt.Errorf("insert input %d: %w", i, err)
 		}
 
 		// Mark the spent output
@@ -248,19 +348,20 @@ func (db *DB) RecordTransaction(tx *protocol.Transaction) error {
 			tx.TxID[:], in.PrevTxHash[:], in.PrevIndex,
 		)
 		if err != nil {
-			return fmt.Errorf("mark output spent %d: %w", i, err)
+			return nil, fmt.Errorf("mark output spent %d: %w", i, err)
 		}
 	}
 
 	// Update total_input and fee only if we found ALL input values
+	var fee *Fee
 	if inputsFound == len(tx.Inputs) && totalInput > 0 {
-		fee := totalInput - totalOutput
+		fee = &Fee{Satoshis: totalInput - totalOutput, Weight: weight}
 		_, err = dbTx.Exec(
-			`UPDATE transactions SET total_input = $2, fee_satoshis = $3 WHERE tx_hash = $1`,
-			tx.TxID[:], totalInput, fee,
+			`UPDATE transactions SET total_input = $2, fee_satoshis = $3, fee_rate = $4 WHERE tx_hash = $1`,
+			tx.TxID[:], totalInput, fee.Satoshis, fee.Rate(),
 		)
 		if err != nil {
-			return fmt.Errorf("update fee: %w", err)
+			return nil, fmt.Errorf("update fee: %w", err)
 		}
 	}
 
@@ -274,32 +375,175 @@ func (db *DB) RecordTransaction(tx *protocol.Transaction) error {
 			sql.NullString{String: addr, Valid: addr != ""},
 		)
 		if err != nil {
-			return fmt.Errorf("insert output %d: %w", i, err)
+			return nil, fmt.Errorf("insert output %d: %w", i, err)
 		}
 	}
 
-	return dbTx.Commit()
+	if err := dbTx.Commit(); err != nil {
+		return nil, err
+	}
+	return fee, nil
 }
 
+// RecordBlock stores a block header. Partial blocks keep their parse error
+// until a complete copy arrives.
 func (db *DB) RecordBlock(block *protocol.Block, peerAddr string) error {
 	_, err := db.conn.Exec(
-		`INSERT INTO blocks (block_hash, height, prev_block_hash, merkle_root, timestamp, difficulty, nonce, tx_count, first_seen_at, first_peer_addr)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9)
+		`INSERT INTO blocks (block_hash, height, version, prev_block_hash, merkle_root, timestamp, difficulty, bits, nonce, tx_count, first_seen_at, first_peer_addr, parse_error)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), $11, $12)
 		 ON CONFLICT DO NOTHING`,
 		block.BlockHash[:],
 		block.Height,
+		block.Header.Version,
 		block.Header.PrevBlockHash[:],
 		block.Header.MerkleRoot[:],
 		time.Unix(int64(block.Header.Timestamp), 0),
 		block.Difficulty,
+		int64(block.Header.Bits),
 		int64(block.Header.Nonce),
-		len(block.Transactions),
+		max(len(block.Transactions), block.DeclaredTxCount),
+		peerAddr,
+		sql.NullString{String: block.ParseError, Valid: block.ParseError != ""},
+	)
+	if err != nil || block.ParseError != "" {
+		return err
+	}
+
+	// A complete copy replaces a partial one stored earlier
+	_, err = db.conn.Exec(
+		`UPDATE blocks SET tx_count = $2, parse_error = NULL
+		 WHERE block_hash = $1 AND parse_error IS NOT NULL`,
+		block.BlockHash[:], len(block.Transactions),
+	)
+	return err
+}
+
+// StoredHeader holds the header fields of a stored block needed for validation
+type StoredHeader struct {
+	Hash      []byte
+	Height    int32
+	Timestamp time.Time
+	Bits      uint32
+}
+
+// GetBlockHeader returns the stored header for a block hash, or nil if unknown
+func (db *DB) GetBlockHeader(blockHash []byte) (*StoredHeader, error) {
+	return db.scanHeader(db.conn.QueryRow(
+		`SELECT block_hash, height, timestamp, bits FROM blocks WHERE block_hash = $1`,
+		blockHash,
+	))
+}
+
+// GetBlockHeaderAtHeight returns the stored header at a height, or nil if unknown
+func (db *DB) GetBlockHeaderAtHeight(height int32) (*StoredHeader, error) {
+	return db.scanHeader(db.conn.QueryRow(
+		`SELECT block_hash, height, timestamp, bits FROM blocks WHERE height = $1`,
+		height,
+	))
+}
+
+func (db *DB) scanHeader(row *sql.Row) (*StoredHeader, error) {
+	var h StoredHeader
+	var bits sql.NullInt64
+	err := row.Scan(&h.Hash, &h.Height, &h.Timestamp, &bits)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bits.Valid {
+		// Blocks recorded before bits were stored can't be used for validation
+		return nil, nil
+	}
+	h.Bits = uint32(bits.Int64)
+	return &h, nil
+}
+
+// AncestorTimestamps walks the stored chain back from blockHash (inclusive) and
+// returns up to n block timestamps, newest first.
+func (db *DB) AncestorTimestamps(blockHash []byte, n int) ([]time.Time, error) {
+	rows, err := db.conn.Query(
+		`WITH RECURSIVE ancestors AS (
+		     SELECT block_hash, prev_block_hash, timestamp, 1 AS depth
+		     FROM blocks WHERE block_hash = $1
+		     UNION ALL
+		     SELECT b.block_hash, b.prev_block_hash, b.timestamp, a.depth + 1
+		     FROM blocks b
+		     JOIN ancestors a ON b.block_hash = a.prev_block_hash
+		     WHERE a.depth < $2
+		 )
+		 SELECT timestamp FROM ancestors ORDER BY depth`,
+		blockHash, n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query ancestors: %w", err)
+	}
+	defer rows.Close()
+
+	var timestamps []time.Time
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, fmt.Errorf("scan ancestor: %w", err)
+		}
+		timestamps = append(timestamps, ts)
+	}
+	return timestamps, rows.Err()
+}
+
+// AncestorHashAtHeight walks the stored chain back from blockHash (inclusive) and
+// returns the hash of its ancestor at the given height, or nil if the stored chain
+// doesn't reach that far.
+func (db *DB) AncestorHashAtHeight(blockHash []byte, height int32) ([]byte, error) {
+	var hash []byte
+	err := db.conn.QueryRow(
+		`WITH RECURSIVE ancestors AS (
+		     SELECT block_hash, prev_block_hash, height
+		     FROM blocks WHERE block_hash = $1
+		     UNION ALL
+		     SELECT b.block_hash, b.prev_block_hash, b.height
+		     FROM blocks b
+		     JOIN ancestors a ON b.block_hash = a.prev_block_hash
+		     WHERE a.height > $2
+		 )
+		 SELECT block_hash FROM ancestors WHERE height = $2`,
+		blockHash, height,
+	).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return hash, err
+}
+
+func (db *DB) RecordQuarantinedBlock(block *protocol.Block, peerAddr, reason string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO quarantined_blocks (block_hash, height, prev_block_hash, timestamp, reason, first_peer_addr, received_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 ON CONFLICT DO NOTHING`,
+		block.BlockHash[:],
+		block.Height,
+		block.Header.PrevBlockHash[:],
+		time.Unix(int64(block.Header.Timestamp), 0),
+		reason,
 		peerAddr,
 	)
 	return err
 }
 
-func (db *DB) DetectInputConflicts(tx *protocol.Transaction) error {
+func (db *DB) RecordBlockAnomaly(blockHash []byte, height int32, kind, detail string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO block_anomalies (block_hash, height, kind, detail, detected_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 ON CONFLICT (block_hash, kind) DO NOTHING`,
+		blockHash, height, kind, detail,
+	)
+	return err
+}
+
+// DetectInputConflicts flags tx and any unconfirmed transactions spending the
+// same inputs as double spends, returning the hashes of those transactions
+func (db *DB) DetectInputConflicts(tx *protocol.Transaction) ([][]byte, error) {
 	var zeroHash [32]byte
 
 	// Collect conflicting tx hashes across all inputs
@@ -320,37 +564,35 @@ func (db *DB) DetectInputConflicts(tx *protocol.Transaction) error {
 			in.PrevTxHash[:], in.PrevIndex, tx.TxID[:],
 		)
 		if err != nil {
-			return fmt.Errorf("query conflicts: %w", err)
+			return nil, fmt.Errorf("query conflicts: %w", err)
 		}
 
 		for rows.Next() {
 			var txHash []byte
 			if err := rows.Scan(&txHash); err != nil {
 				rows.Close()
-				return fmt.Errorf("scan conflict: %w", err)
+				return nil, fmt.Errorf("scan conflict: %w", err)
 			}
 			conflictingTxHashes = append(conflictingTxHashes, txHash)
 		}
 		rows.Close()
 		if err := rows.Err(); err != nil {
-			return fmt.Errorf("rows error: %w", err)
+			return nil, fmt.Errorf("rows error: %w", err)
 		}
 	}
 
 	if len(conflictingTxHashes) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Flag all conflicts in a single DB transaction
 	dbTx, err := db.conn.Begin()
 	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+		return nil, fmt.Errorf("begin transaction: %w", err)
 	}
 	defer dbTx.Rollback()
 
 	for _, oldTxHash := range conflictingTxHashes {
-		_ = oldTxHash
-
 		// Flag the old transaction's observation
 		_, err := dbTx.Exec(
 			`UPDATE transaction_observations
@@ -359,7 +601,18 @@ func (db *DB) DetectInputConflicts(tx *protocol.Transaction) error {
 			tx.TxID[:], oldTxHash,
 		)
 		if err != nil {
-			return fmt.Errorf("flag old tx: %w", err)
+			return nil, fmt.Errorf("flag old tx: %w", err)
+		}
+
+		// Track the pair until one side (or a third tx) confirms
+		_, err = dbTx.Exec(
+			`INSERT INTO conflict_outcomes (original_tx, replacement_tx, detected_at)
+			 VALUES ($1, $2, NOW())
+			 ON CONFLICT DO NOTHING`,
+			oldTxHash, tx.TxID[:],
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert conflict outcome: %w", err)
 		}
 	}
 
@@ -371,10 +624,13 @@ func (db *DB) DetectInputConflicts(tx *protocol.Transaction) error {
 		tx.TxID[:],
 	)
 	if err != nil {
-		return fmt.Errorf("flag new tx: %w", err)
+		return nil, fmt.Errorf("flag new tx: %w", err)
 	}
 
-	return dbTx.Commit()
+	if err := dbTx.Commit(); err != nil {
+		return nil, err
+	}
+	return conflictingTxHashes, nil
 }
 
 func (db *DB) ConfirmTransactions(blockHash []byte, blockHeight int, blockTimestamp time.Time, txHashes [][]byte) error {
@@ -384,11 +640,11 @@ func (db *DB) ConfirmTransactions(blockHash []byte, blockHeight int, blockTimest
 	}
 	defer dbTx.Rollback()
 
-	for _, txHash := range txHashes {
+	for position, txHash := range txHashes {
 		_, err = dbTx.Exec(
-			`UPDATE transactions SET block_hash = $1, block_height = $2
+			`UPDATE transactions SET block_hash = $1, block_height = $2, block_position = $4
 			 WHERE tx_hash = $3 AND block_hash IS NULL`,
-			blockHash, blockHeight, txHash,
+			blockHash, blockHeight, txHash, position,
 		)
 		if err != nil {
 			return fmt.Errorf("update transaction: %w", err)
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+)
+
+// NewDiscard returns a DB that accepts every write and finds nothing, for
+// running the observer without a database. Lookups behave as on an empty
+// database.
+func NewDiscard() *DB {
+	return &DB{conn: sql.OpenDB(discardConnector{}), discard: true}
+}
+
+// Discards reports whether the DB drops everything written to it
+func (db *DB) Discards() bool {
+	return db.discard
+}
+
+type discardConnector struct{}
+
+func (discardConnector) Connect(context.Context) (driver.Conn, error) { return discardConn{}, nil }
+func (discardConnector) Driver() driver.Driver                        { return discardDriver{} }
+
+type discardDriver struct{}
+
+func (discardDriver) Open(string) (driver.Conn, error) { return discardConn{}, nil }
+
+// discardConn implements the statement, query and transaction interfaces
+// database/sql needs, with every statement succeeding on zero rows
+type discardConn struct{}
+
+func (discardConn) Prepare(string) (driver.Stmt, error) { return discardStmt{}, nil }
+func (discardConn) Close() error                        { return nil }
+func (discardConn) Begin() (driver.Tx, error)           { return discardTx{}, nil }
+
+func (discardConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (discardConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return discardRows{}, nil
+}
+
+type discardStmt struct{}
+
+func (discardStmt) Close() error                               { return nil }
+func (discardStmt) NumInput() int                              { return -1 }
+func (discardStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (discardStmt) Query([]driver.Value) (driver.Rows, error)  { return discardRows{}, nil }
+
+type discardTx struct{}
+
+func (discardTx) Commit() error   { return nil }
+func (discardTx) Rollback() error { return nil }
+
+type discardRows struct{}
+
+func (discardRows) Columns() []string         { return nil }
+func (discardRows) Close() error              { return nil }
+func (discardRows) Next([]driver.Value) error { return io.EOF }
@@ -0,0 +1,42 @@
+package database
+
+import "fmt"
+
+// RegionSuggestion is a country or AS where nodes are seen but this
+// observer has no peer, ranked as a place to add a vantage point
+type RegionSuggestion struct {
+	Kind      string // country or asn
+	Key       string // country code or AS number
+	Country   string // of the first node seen, for an AS
+	OrgName   string
+	Rank      int
+	Nodes     int // distinct nodes geolocated there
+	Reachable int // of which listed by discovery
+	Heard     int // times their addresses were gossiped to us
+	ASNs      int // distinct ASes, for a country
+	AutoAdded bool
+}
+
+// SaveRegionSuggestions replaces this observer's region suggestions
+func (db *DB) SaveRegionSuggestions(suggestions []RegionSuggestion) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	if _, err := dbTx.Exec(`DELETE FROM region_suggestions WHERE observer_id = $1`, db.observerID); err != nil {
+		return fmt.Errorf("clear region suggestions: %w", err)
+	}
+	for _, s := range suggestions {
+		_, err := dbTx.Exec(
+			`INSERT INTO region_suggestions (kind, key, observer_id, country_code, org_name, rank, nodes, reachable, heard, asns, auto_added, computed_at)
+			 VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NOW())`,
+			s.Kind, s.Key, db.observerID, s.Country, s.OrgName,
+			s.Rank, s.Nodes, s.Reachable, s.Heard, s.ASNs, s.AutoAdded,
+		)
+		if err != nil {
+			return fmt.Errorf("insert region suggestion: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
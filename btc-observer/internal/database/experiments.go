@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// StartExperimentRun records the start of a peer-set experiment and stamps
+// observations recorded from now on with its run id
+func (db *DB) StartExperimentRun(name string, policy interface{}) (int64, error) {
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return 0, fmt.Errorf("encoding experiment policy: %w", err)
+	}
+	var id int64
+	err = db.conn.QueryRow(
+		`INSERT INTO experiment_runs (name, policy, started_at)
+		 VALUES ($1, $2, NOW())
+		 RETURNING id`,
+		name, policyJSON,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	db.experimentRun.Store(id)
+	return id, nil
+}
+
+// EndExperimentRun stops stamping observations and closes the run's window
+func (db *DB) EndExperimentRun(id int64) error {
+	db.experimentRun.CompareAndSwap(id, 0)
+	_, err := db.conn.Exec(`UPDATE experiment_runs SET ended_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// CloseInterruptedExperimentRuns ends runs left open by a crash or restart at
+// their last stamped observation
+func (db *DB) CloseInterruptedExperimentRuns() (int64, error) {
+	res, err := db.conn.Exec(
+		`UPDATE experiment_runs r SET ended_at = COALESCE(
+		     (SELECT MAX(announcement_time) FROM propagation_events WHERE experiment_run_id = r.id),
+		     r.started_at)
+		 WHERE ended_at IS NULL`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (db *DB) currentExperimentRun() sql.NullInt64 {
+	id := db.experimentRun.Load()
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FeeAlert is a transaction paying an extreme fee, with how far it had
+// propagated when the alert was raised
+type FeeAlert struct {
+	TxHash      []byte
+	Reason      string
+	Fee         Fee
+	FirstSeenAt time.Time
+	FirstPeer   string
+	FirstRegion string
+	PeerCount   int
+	RegionCount int
+}
+
+// RecordFeeAlert stores an alert for txHash, filling in its propagation so
+// far. It returns false if the transaction was already alerted on.
+func (db *DB) RecordFeeAlert(txHash []byte, reason string, fee Fee) (*FeeAlert, bool, error) {
+	var (
+		firstSeen   sql.NullTime
+		firstPeer   sql.NullString
+		firstRegion sql.NullString
+		peerCount   sql.NullInt64
+		regionCount sql.NullInt64
+	)
+	err := db.conn.QueryRow(
+		`INSERT INTO fee_alerts (tx_hash, reason, fee_satoshis, fee_rate, first_seen_at, first_peer_addr,
+		     first_region, peer_count, region_count, alerted_at)
+		 SELECT $1, $2, $3, $4, o.first_seen_at, o.first_peer_addr, pc.region, o.peer_count,
+		     (SELECT COUNT(DISTINCT pc2.region) FROM propagation_events pe
+		      JOIN peer_connections pc2 ON pc2.peer_addr = pe.peer_addr
+		      WHERE pe.tx_hash = $1),
+		     NOW()
+		 FROM (SELECT 1) AS one
+		 LEFT JOIN transaction_observations o ON o.tx_hash = $1
+		 LEFT JOIN peer_connections pc ON pc.peer_addr = o.first_peer_addr
+		 ON CONFLICT (tx_hash) DO NOTHING
+		 RETURNING first_seen_at, first_peer_addr, first_region, peer_count, region_count`,
+		txHash, reason, fee.Satoshis, fee.Rate(),
+	).Scan(&firstSeen, &firstPeer, &firstRegion, &peerCount, &regionCount)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("insert fee alert: %w", err)
+	}
+	return &FeeAlert{
+		TxHash:      txHash,
+		Reason:      reason,
+		Fee:         fee,
+		FirstSeenAt: firstSeen.Time,
+		FirstPeer:   firstPeer.String,
+		FirstRegion: firstRegion.String,
+		PeerCount:   int(peerCount.Int64),
+		RegionCount: int(regionCount.Int64),
+	}, true, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GeoCacheEntry is an IP's resolved location
+type GeoCacheEntry struct {
+	IP          string
+	Country     string
+	CountryCode string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	ISP         string
+	Org         string
+	AS          string // as the provider formats it, e.g. "AS3320 Deutsche Telekom AG"
+}
+
+// CachedGeo returns the cached locations of ips resolved within maxAge
+func (db *DB) CachedGeo(ips []string, maxAge time.Duration) (map[string]GeoCacheEntry, error) {
+	rows, err := db.conn.Query(
+		`SELECT ip, country, country_code, city, latitude, longitude, isp, org, asn
+		 FROM geo_cache
+		 WHERE ip = ANY($1) AND resolved_at > NOW() - $2 * INTERVAL '1 second'`,
+		pq.Array(ips), maxAge.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query geo cache: %w", err)
+	}
+	defer rows.Close()
+
+	cached := make(map[string]GeoCacheEntry)
+	for rows.Next() {
+		var e GeoCacheEntry
+		if err := rows.Scan(&e.IP, &e.Country, &e.CountryCode, &e.City, &e.Latitude, &e.Longitude, &e.ISP, &e.Org, &e.AS); err != nil {
+			return nil, err
+		}
+		cached[e.IP] = e
+	}
+	return cached, rows.Err()
+}
+
+// SaveGeo upserts resolved locations into the geo cache
+func (db *DB) SaveGeo(entries []GeoCacheEntry) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	for _, e := range entries {
+		_, err := dbTx.Exec(
+			`INSERT INTO geo_cache (ip, country, country_code, city, latitude, longitude, isp, org, asn, resolved_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+			 ON CONFLICT (ip) DO UPDATE SET
+			     country = EXCLUDED.country,
+			     country_code = EXCLUDED.country_code,
+			     city = EXCLUDED.city,
+			     latitude = EXCLUDED.latitude,
+			     longitude = EXCLUDED.longitude,
+			     isp = EXCLUDED.isp,
+			     org = EXCLUDED.org,
+			     asn = EXCLUDED.asn,
+			     resolved_at = NOW()`,
+			e.IP, e.Country, e.CountryCode, e.City, e.Latitude, e.Longitude, e.ISP, e.Org, e.AS,
+		)
+		if err != nil {
+			return fmt.Errorf("upsert geo cache: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
+
+// PeerMissingGeo is a recorded peer without a location, with the region it
+// was stored under, if any
+type PeerMissingGeo struct {
+	PeerAddr string
+	Region   string
+}
+
+// PeersMissingGeo returns up to limit recorded peers without a country,
+// ordered by address from after, so callers can page through them
+func (db *DB) PeersMissingGeo(after string, limit int) ([]PeerMissingGeo, error) {
+	rows, err := db.conn.Query(
+		`SELECT peer_addr, COALESCE(region, '')
+		 FROM peer_connections
+		 WHERE COALESCE(country_code, '') = '' AND peer_addr > $1
+		 ORDER BY peer_addr
+		 LIMIT $2`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query peers missing geo: %w", err)
+	}
+	defer rows.Close()
+
+	var peers []PeerMissingGeo
+	for rows.Next() {
+		var p PeerMissingGeo
+		if err := rows.Scan(&p.PeerAddr, &p.Region); err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	return peers, rows.Err()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// FullHeader is a stored block's complete 80-byte header with its hash and
+// height
+type FullHeader struct {
+	Hash   [32]byte
+	Height int32
+	Header protocol.BlockHeader
+}
+
+// FullHeaders returns the stored headers from height from to height to
+// (inclusive), lowest first, up to limit of them. Blocks stored before every
+// header field was recorded are left out.
+func (db *DB) FullHeaders(from, to int32, limit int) ([]FullHeader, error) {
+	rows, err := db.conn.Query(
+		`SELECT block_hash, height, version, prev_block_hash, merkle_root, timestamp, bits, nonce
+		 FROM blocks
+		 WHERE height BETWEEN $1 AND $2
+		   AND version IS NOT NULL AND bits IS NOT NULL AND nonce IS NOT NULL
+		 ORDER BY height
+		 LIMIT $3`,
+		from, to, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var headers []FullHeader
+	for rows.Next() {
+		var h FullHeader
+		var hash, prev, merkle []byte
+		var ts sql.NullTime
+		var bits, nonce int64
+		if err := rows.Scan(&hash, &h.Height, &h.Header.Version, &prev, &merkle, &ts, &bits, &nonce); err != nil {
+			return nil, err
+		}
+		copy(h.Hash[:], hash)
+		copy(h.Header.PrevBlockHash[:], prev)
+		copy(h.Header.MerkleRoot[:], merkle)
+		h.Header.Timestamp = uint32(ts.Time.Unix())
+		h.Header.Bits = uint32(bits)
+		h.Header.Nonce = uint32(nonce)
+		headers = append(headers, h)
+	}
+	return headers, rows.Err()
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Peer identity match results
+const (
+	IdentityKnown  = "known"  // the address was already linked to it
+	IdentityLinked = "linked" // a new address was attributed to an idle identity
+	IdentityNew    = "new"
+)
+
+// PeerIdentity is what identifies a node beyond its address
+type PeerIdentity struct {
+	UserAgent   string
+	Services    uint64
+	Fingerprint string
+	ASN         string
+}
+
+// identityIdleAfter is how long an identity's last address must have been
+// quiet before a new address can be attributed to it
+const identityIdleAfter = "10 minutes"
+
+// ResolvePeerIdentity links peerAddr to a peer identity and returns its id
+// and how it was matched. A new address joins an existing identity only when
+// exactly one identity with the same user agent, services, fingerprint and
+// ASN has gone idle at another address; otherwise a new identity is created.
+func (db *DB) ResolvePeerIdentity(peerAddr string, id PeerIdentity) (int64, string, error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return 0, "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	var identityID int64
+	result := IdentityKnown
+	err = dbTx.QueryRow(
+		`SELECT pi.id FROM peer_connections pc
+		 JOIN peer_identities pi ON pi.id = pc.identity_id
+		 WHERE pc.peer_addr = $1 AND pi.user_agent = $2 AND pi.services = $3 AND pi.fingerprint = $4`,
+		peerAddr, id.UserAgent, int64(id.Services), id.Fingerprint,
+	).Scan(&identityID)
+	if err == sql.ErrNoRows {
+		identityID, result, err = matchPeerIdentity(dbTx, peerAddr, id)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if _, err := dbTx.Exec(
+		`UPDATE peer_identities SET
+		     last_seen_at = NOW(),
+		     address_count = address_count + CASE WHEN last_addr = $2 OR $3 THEN 0 ELSE 1 END,
+		     last_addr = $2
+		 WHERE id = $1`,
+		identityID, peerAddr, result == IdentityNew,
+	); err != nil {
+		return 0, "", fmt.Errorf("update identity: %w", err)
+	}
+	if _, err := dbTx.Exec(
+		`UPDATE peer_connections SET identity_id = $2 WHERE peer_addr = $1`,
+		peerAddr, identityID,
+	); err != nil {
+		return 0, "", fmt.Errorf("link identity: %w", err)
+	}
+	return identityID, result, dbTx.Commit()
+}
+
+func matchPeerIdentity(dbTx *sql.Tx, peerAddr string, id PeerIdentity) (int64, string, error) {
+	rows, err := dbTx.Query(
+		`SELECT pi.id FROM peer_identities pi
+		 WHERE pi.user_agent = $1 AND pi.services = $2 AND pi.fingerprint = $3 AND pi.asn = $4
+		   AND pi.last_addr <> $5
+		   AND NOT EXISTS (
+		       SELECT 1 FROM peer_connections pc
+		       WHERE pc.peer_addr = pi.last_addr AND pc.last_seen_at > NOW() - INTERVAL '`+identityIdleAfter+`')
+		 LIMIT 2`,
+		id.UserAgent, int64(id.Services), id.Fingerprint, id.ASN, peerAddr,
+	)
+	if err != nil {
+		return 0, "", fmt.Errorf("match identity: %w", err)
+	}
+	var matches []int64
+	for rows.Next() {
+		var m int64
+		if err := rows.Scan(&m); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(matches) == 1 {
+		return matches[0], IdentityLinked, nil
+	}
+
+	var identityID int64
+	err = dbTx.QueryRow(
+		`INSERT INTO peer_identities (user_agent, services, fingerprint, asn, first_seen_at, last_seen_at, last_addr, address_count)
+		 VALUES ($1, $2, $3, $4, NOW(), NOW(), $5, 1)
+		 RETURNING id`,
+		id.UserAgent, int64(id.Services), id.Fingerprint, id.ASN, peerAddr,
+	).Scan(&identityID)
+	if err != nil {
+		return 0, "", fmt.Errorf("insert identity: %w", err)
+	}
+	return identityID, IdentityNew, nil
+}
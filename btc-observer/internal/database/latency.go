@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// LatencyProfile is how far behind the first announcement a peer announces
+// transactions or blocks, as a histogram of delays
+type LatencyProfile struct {
+	PeerAddr string
+	Kind     string // tx or block
+	Samples  int64
+	MedianMs int
+	P90Ms    int
+	Buckets  []int64 // counts per delay bucket, the observer's bucket bounds
+}
+
+// SaveLatencyProfiles upserts this observer's latency profiles
+func (db *DB) SaveLatencyProfiles(profiles []LatencyProfile) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	for _, p := range profiles {
+		_, err := dbTx.Exec(
+			`INSERT INTO peer_latency_profiles (peer_addr, kind, observer_id, samples, median_ms, p90_ms, buckets, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			 ON CONFLICT (peer_addr, kind, observer_id) DO UPDATE SET
+			     samples = EXCLUDED.samples,
+			     median_ms = EXCLUDED.median_ms,
+			     p90_ms = EXCLUDED.p90_ms,
+			     buckets = EXCLUDED.buckets,
+			     updated_at = NOW()`,
+			p.PeerAddr, p.Kind, db.observerID, p.Samples, p.MedianMs, p.P90Ms, pq.Array(p.Buckets),
+		)
+		if err != nil {
+			return fmt.Errorf("upsert latency profile: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
+
+// LatencyProfiles returns this observer's stored latency profiles
+func (db *DB) LatencyProfiles() ([]LatencyProfile, error) {
+	rows, err := db.conn.Query(
+		`SELECT peer_addr, kind, samples, median_ms, p90_ms, buckets
+		 FROM peer_latency_profiles
+		 WHERE observer_id = $1`,
+		db.observerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query latency profiles: %w", err)
+	}
+	defer rows.Close()
+
+	var profiles []LatencyProfile
+	for rows.Next() {
+		var p LatencyProfile
+		if err := rows.Scan(&p.PeerAddr, &p.Kind, &p.Samples, &p.MedianMs, &p.P90Ms, pq.Array(&p.Buckets)); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
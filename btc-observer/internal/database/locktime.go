@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LockTimeConfirmation is a tracked future-locktime transaction a block
+// confirmed
+type LockTimeConfirmation struct {
+	Kind string // height or time
+	// DelayBlocks is how many blocks after the lock expired the transaction
+	// confirmed; 0 means it confirmed in the first block it could be in
+	DelayBlocks int32
+}
+
+// RecordLockTime starts tracking a transaction whose locktime hadn't expired
+// when it was first seen at tipHeight. It returns false if the transaction
+// is already tracked.
+func (db *DB) RecordLockTime(txHash []byte, kind string, lockValue int64, tipHeight int32) (bool, error) {
+	res, err := db.conn.Exec(
+		`INSERT INTO locktime_transactions (tx_hash, lock_kind, lock_value, seen_tip_height, first_seen_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 ON CONFLICT (tx_hash) DO NOTHING`,
+		txHash, kind, lockValue, tipHeight,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert locktime transaction: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// UpdateLockTimes applies a block at height, whose median time past
+// (including itself) is mtp, to the tracked transactions: those among
+// txHashes are marked confirmed, then those whose lock the block expired are
+// marked valid from the next height. Confirmations are returned once each.
+func (db *DB) UpdateLockTimes(height int32, mtp time.Time, txHashes [][]byte) ([]LockTimeConfirmation, error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	// A transaction confirmed before we saw its lock expire (a missed block)
+	// is taken to have been valid from the earliest height it could be
+	rows, err := dbTx.Query(
+		`UPDATE locktime_transactions
+		 SET confirmed_height = $1, confirmed_at = NOW(),
+		     valid_height = COALESCE(valid_height, CASE WHEN lock_kind = 'height' THEN lock_value + 1 ELSE $1 END),
+		     valid_at = COALESCE(valid_at, NOW())
+		 WHERE tx_hash = ANY($2) AND confirmed_height IS NULL
+		 RETURNING lock_kind, confirmed_height - valid_height`,
+		height, pq.Array(txHashes),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("confirm locktime transactions: %w", err)
+	}
+	var confirmed []LockTimeConfirmation
+	for rows.Next() {
+		var c LockTimeConfirmation
+		if err := rows.Scan(&c.Kind, &c.DelayBlocks); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan locktime confirmation: %w", err)
+		}
+		confirmed = append(confirmed, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("confirm locktime transactions: %w", err)
+	}
+
+	// Height locks expire at lock_value + 1; time locks once the median time
+	// past of the previous block passes them (BIP113)
+	_, err = dbTx.Exec(
+		`UPDATE locktime_transactions
+		 SET valid_height = CASE WHEN lock_kind = 'height' THEN lock_value + 1 ELSE $1 + 1 END,
+		     valid_at = NOW()
+		 WHERE valid_height IS NULL
+		   AND ((lock_kind = 'height' AND lock_value <= $1) OR (lock_kind = 'time' AND lock_value < $2))`,
+		height, mtp.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("expire locktimes: %w", err)
+	}
+	return confirmed, dbTx.Commit()
+}
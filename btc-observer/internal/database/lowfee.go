@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RecordLowFeeTransaction stores a transaction relayed below the minimum
+// relay fee rate, with every peer that had announced it. It returns the
+// regions of those peers, one entry per peer, and false if the transaction
+// was already recorded.
+func (db *DB) RecordLowFeeTransaction(txHash []byte, kind string, fee Fee) ([]string, bool, error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	res, err := dbTx.Exec(
+		`INSERT INTO low_fee_transactions (tx_hash, kind, fee_satoshis, fee_rate, weight,
+		     first_seen_at, first_peer_addr, detected_at)
+		 SELECT $1, $2, $3, $4, $5, o.first_seen_at, o.first_peer_addr, NOW()
+		 FROM (SELECT 1) AS one
+		 LEFT JOIN transaction_observations o ON o.tx_hash = $1
+		 ON CONFLICT (tx_hash) DO NOTHING`,
+		txHash, kind, fee.Satoshis, fee.Rate(), fee.Weight,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("insert low-fee transaction: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, false, nil
+	}
+
+	rows, err := dbTx.Query(
+		`INSERT INTO low_fee_relays (tx_hash, peer_addr, announced_at)
+		 SELECT tx_hash, peer_addr, MIN(announcement_time)
+		 FROM propagation_events
+		 WHERE tx_hash = $1
+		 GROUP BY tx_hash, peer_addr
+		 ON CONFLICT DO NOTHING
+		 RETURNING (SELECT region FROM peer_connections pc WHERE pc.peer_addr = low_fee_relays.peer_addr)`,
+		txHash,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("insert low-fee relays: %w", err)
+	}
+	var regions []string
+	for rows.Next() {
+		var region sql.NullString
+		if err := rows.Scan(&region); err != nil {
+			rows.Close()
+			return nil, false, fmt.Errorf("scan low-fee relay: %w", err)
+		}
+		regions = append(regions, region.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("insert low-fee relays: %w", err)
+	}
+
+	_, err = dbTx.Exec(
+		`UPDATE low_fee_transactions SET relay_count = $2 WHERE tx_hash = $1`,
+		txHash, len(regions),
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("update relay count: %w", err)
+	}
+	return regions, true, dbTx.Commit()
+}
@@ -0,0 +1,919 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Memory is a Storage kept in process memory, for single-binary runs where
+// the pipeline's lookups must see earlier writes without a Postgres server.
+// Nothing is evicted, so it suits bounded runs: a simulation, a soak test, a
+// few hours on a test network.
+//
+// It keeps what the pipeline reads back: peers and their identities and
+// gossiped addresses, observations, transactions with their outputs, fees
+// and conflicts, alert and tag deduplication, projects, and the block chain
+// with its ancestry, reorgs and version bits. Every other method is a
+// deliberate no-op that returns nil:
+//
+//   - records only analytics read back: peer statistics, sessions and their
+//     features, chain status, misbehavior, parse failures, anomalies,
+//     quarantined blocks, block txids, ordering, fees, announcements and
+//     arrivals, traffic and claim reports
+//   - state saved for restarts, which a memory store can't outlive: version
+//     nonces, latency profiles, peer scores, claim states, region
+//     suggestions, the geo cache and the peers awaiting a geo backfill,
+//     whose reads come back empty
+//   - lock time tracking, so no lock time confirmations are reported
+//
+// It enforces none of the Postgres constraints, so a write that a foreign
+// key or unique index would reject there succeeds here.
+type Memory struct {
+	mu sync.Mutex
+
+	peers      map[string]*memPeer
+	identities []*memIdentity
+	addresses  map[string]*memAddress
+	sessions   int64
+
+	observations map[string]*memObservation
+	txs          map[string]*memTx
+	outputs      map[memOutpoint]*memOutput
+	spenders     map[memOutpoint][]string // unconfirmed-or-not txs spending each outpoint
+	conflicts    []*memConflict
+	feeAlerts    map[string]bool
+	lowFee       map[string]bool
+	matches      map[string]bool
+	spendTypes   map[string]bool
+	projects     map[string]int
+	projectTxs   map[string]bool
+
+	blocks  map[[32]byte]*memBlock
+	heights map[int32][32]byte
+}
+
+type memPeer struct {
+	region   string
+	identity *memIdentity
+	version  *protocol.VersionMessage
+}
+
+type memIdentity struct {
+	id       int64
+	key      PeerIdentity
+	lastAddr string
+	lastSeen time.Time
+}
+
+type memAddress struct {
+	network   string
+	lastHeard time.Time
+}
+
+type memObservation struct {
+	firstSeen time.Time
+	firstPeer string
+	peerCount int
+	// announcements, in arrival order
+	peers []string
+}
+
+type memOutpoint struct {
+	hash  [32]byte
+	index uint32
+}
+
+type memOutput struct {
+	value   int64
+	address string
+}
+
+type memTx struct {
+	inputs     []memOutpoint
+	inputAddrs []string
+	fee        *Fee
+	blockHash  []byte
+}
+
+type memConflict struct {
+	original, replacement string
+	detectedAt            time.Time
+	resolvedBy            []byte // block hash, nil while open
+}
+
+type memBlock struct {
+	header   StoredHeader
+	prevHash [32]byte
+	version  int32
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		peers:        make(map[string]*memPeer),
+		addresses:    make(map[string]*memAddress),
+		observations: make(map[string]*memObservation),
+		txs:          make(map[string]*memTx),
+		outputs:      make(map[memOutpoint]*memOutput),
+		spenders:     make(map[memOutpoint][]string),
+		feeAlerts:    make(map[string]bool),
+		lowFee:       make(map[string]bool),
+		matches:      make(map[string]bool),
+		spendTypes:   make(map[string]bool),
+		projects:     make(map[string]int),
+		projectTxs:   make(map[string]bool),
+		blocks:       make(map[[32]byte]*memBlock),
+		heights:      make(map[int32][32]byte),
+	}
+}
+
+var _ Storage = (*Memory)(nil)
+
+// Discards reports false: everything the pipeline reads back is kept
+func (m *Memory) Discards() bool {
+	return false
+}
+
+func (m *Memory) RecordPeerConnection(peerAddr string, version *protocol.VersionMessage) (*PeerVersionChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.peers[peerAddr]
+	if p == nil {
+		p = &memPeer{}
+		m.peers[peerAddr] = p
+	}
+	var change *PeerVersionChange
+	if p.version != nil {
+		change = versionChange(p.version.Version, p.version.Services, p.version.UserAgent, version)
+	}
+	p.version = version
+	return change, nil
+}
+
+func (m *Memory) UpdatePeerGeoInfo(peerAddr string, geo *PeerGeoInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.peers[peerAddr]; p != nil {
+		p.region = geo.Region
+	}
+	return nil
+}
+
+func (m *Memory) UpdatePeerLatency(peerAddr string, latencyMs int) error {
+	return nil
+}
+
+func (m *Memory) UpdatePeerGeoCheck(peerAddr string, minRTTMs, boundMs int, suspect bool) error {
+	return nil
+}
+
+func (m *Memory) IncrementPeerAnnouncements(peerAddr string, txCount, blockCount int) error {
+	return nil
+}
+
+// ResolvePeerIdentity links peerAddr to an identity by the same rules as
+// the Postgres store
+func (m *Memory) ResolvePeerIdentity(peerAddr string, id PeerIdentity) (int64, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	p := m.peers[peerAddr]
+	if p == nil {
+		p = &memPeer{}
+		m.peers[peerAddr] = p
+	}
+	if p.identity != nil && p.identity.key == id {
+		p.identity.lastAddr, p.identity.lastSeen = peerAddr, now
+		return p.identity.id, IdentityKnown, nil
+	}
+
+	var idle []*memIdentity
+	for _, i := range m.identities {
+		if i.key == id && i.lastAddr != peerAddr && now.Sub(i.lastSeen) > 10*time.Minute {
+			idle = append(idle, i)
+		}
+	}
+	result := IdentityLinked
+	if len(idle) != 1 {
+		idle = []*memIdentity{{id: int64(len(m.identities) + 1), key: id}}
+		m.identities = append(m.identities, idle[0])
+		result = IdentityNew
+	}
+	p.identity = idle[0]
+	p.identity.lastAddr, p.identity.lastSeen = peerAddr, now
+	return p.identity.id, result, nil
+}
+
+func (m *Memory) RecordPeerChainStatus(peerAddr string, s PeerChainStatus) error {
+	return nil
+}
+
+func (m *Memory) RecordPeerAddresses(peerAddr string, addrs []protocol.NetAddress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, a := range addrs {
+		m.addresses[a.String()] = &memAddress{network: a.Network, lastHeard: now}
+	}
+	return nil
+}
+
+// GossipedAddresses returns up to limit addresses on a network, most
+// recently heard first
+func (m *Memory) GossipedAddresses(network string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var addrs []string
+	for addr, a := range m.addresses {
+		if a.network == network {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return m.addresses[addrs[i]].lastHeard.After(m.addresses[addrs[j]].lastHeard)
+	})
+	if len(addrs) > limit {
+		addrs = addrs[:limit]
+	}
+	return addrs, nil
+}
+
+func (m *Memory) StartPeerSession(peerAddr, region, transport string, tcp *TCPStats) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions++
+	return m.sessions, nil
+}
+
+func (m *Memory) UpdatePeerSession(id int64, pingMs int, tcp *TCPStats) error {
+	return nil
+}
+
+func (m *Memory) EndPeerSession(id int64, tcp *TCPStats) error {
+	return nil
+}
+
+func (m *Memory) RecordSessionFeatures(id int64, f SessionFeatures) error {
+	return nil
+}
+
+func (m *Memory) RecordParseFailure(f ParseFailure) error {
+	return nil
+}
+
+// RecordVersionNonce is a no-op: the observer remembers the nonces it sends
+// for as long as the process, and so the Memory, lives
+func (m *Memory) RecordVersionNonce(nonce uint64, peerAddr string) error {
+	return nil
+}
+
+func (m *Memory) VersionNonces(since time.Time) ([]SentNonce, error) {
+	return nil, nil
+}
+
+func (m *Memory) PruneVersionNonces(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *Memory) RecordPeerMisbehavior(peerAddr, reason, detail string) error {
+	return nil
+}
+
+func (m *Memory) RecordObservation(txHash []byte, peerAddr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.observations[string(txHash)]
+	if o == nil {
+		o = &memObservation{firstSeen: time.Now(), firstPeer: peerAddr}
+		m.observations[string(txHash)] = o
+	}
+	if slices.Contains(o.peers, peerAddr) {
+		return nil
+	}
+	o.peerCount++
+	o.peers = append(o.peers, peerAddr)
+	return nil
+}
+
+// MergeWTxIDObservations moves observations recorded under a wtxid to its
+// txid, keeping the earliest sighting
+func (m *Memory) MergeWTxIDObservations(wtxid, txid []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from := m.observations[string(wtxid)]
+	if from == nil {
+		return nil
+	}
+	delete(m.observations, string(wtxid))
+	to := m.observations[string(txid)]
+	if to == nil {
+		m.observations[string(txid)] = from
+		return nil
+	}
+	if from.firstSeen.Before(to.firstSeen) {
+		to.firstSeen, to.firstPeer = from.firstSeen, from.firstPeer
+	}
+	for _, p := range from.peers {
+		if !slices.Contains(to.peers, p) {
+			to.peers = append(to.peers, p)
+		}
+	}
+	to.peerCount = len(to.peers)
+	return nil
+}
+
+func (m *Memory) RecordTransaction(tx *protocol.Transaction) error {
+	_, err := m.RecordTransactionFee(tx)
+	return err
+}
+
+// RecordTransactionFee records tx and returns its fee, or nil if the value
+// of any input is unknown
+func (m *Memory) RecordTransactionFee(tx *protocol.Transaction) (*Fee, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := string(tx.TxID[:])
+	stored := m.txs[key]
+	if stored == nil {
+		stored = &memTx{}
+		m.txs[key] = stored
+	}
+
+	totalOutput := int64(0)
+	for _, out := range tx.Outputs {
+		totalOutput += out.Value
+	}
+	totalInput := int64(0)
+	inputsFound := 0
+	inputs := make([]memOutpoint, len(tx.Inputs))
+	var addrs []string
+	for i, in := range tx.Inputs {
+		op := memOutpoint{hash: in.PrevTxHash, index: in.PrevIndex}
+		inputs[i] = op
+		if prev := m.outputs[op]; prev != nil {
+			totalInput += prev.value
+			inputsFound++
+			if prev.address != "" {
+				addrs = append(addrs, prev.address)
+			}
+		}
+		if stored.inputs == nil {
+			m.spenders[op] = append(m.spenders[op], key)
+		}
+	}
+	if stored.inputs == nil {
+		stored.inputs, stored.inputAddrs = inputs, addrs
+	}
+
+	if inputsFound == len(tx.Inputs) && totalInput > 0 {
+		stored.fee = &Fee{Satoshis: totalInput - totalOutput, Weight: tx.Weight()}
+	}
+	for i, out := range tx.Outputs {
+		op := memOutpoint{hash: tx.TxID, index: uint32(i)}
+		if _, ok := m.outputs[op]; !ok {
+			m.outputs[op] = &memOutput{value: out.Value, address: protocol.ExtractAddress(out.ScriptPubKey)}
+		}
+	}
+	if stored.fee == nil {
+		return nil, nil
+	}
+	fee := *stored.fee
+	return &fee, nil
+}
+
+// RecordInputValues records the fee of a stored transaction from prevout
+// values resolved elsewhere, in input order
+func (m *Memory) RecordInputValues(tx *protocol.Transaction, values []int64) (*Fee, error) {
+	if len(values) != len(tx.Inputs) {
+		return nil, fmt.Errorf("%d input values for %d inputs", len(values), len(tx.Inputs))
+	}
+	var totalInput, totalOutput int64
+	for _, v := range values {
+		totalInput += v
+	}
+	for _, out := range tx.Outputs {
+		totalOutput += out.Value
+	}
+	fee := Fee{Satoshis: totalInput - totalOutput, Weight: tx.Weight()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored := m.txs[string(tx.TxID[:])]; stored != nil {
+		stored.fee = &fee
+	}
+	return &fee, nil
+}
+
+// TxInputAddresses returns the addresses a stored transaction spends from
+func (m *Memory) TxInputAddresses(txHash []byte) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := m.txs[string(txHash)]
+	if tx == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, a := range tx.inputAddrs {
+		if !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
+// DetectInputConflicts returns the unconfirmed transactions spending any of
+// tx's inputs, and opens a conflict for each
+func (m *Memory) DetectInputConflicts(tx *protocol.Transaction) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var zeroHash [32]byte
+	self := string(tx.TxID[:])
+	var conflicting [][]byte
+	for _, in := range tx.Inputs {
+		if in.PrevTxHash == zeroHash {
+			continue
+		}
+		for _, other := range m.spenders[memOutpoint{hash: in.PrevTxHash, index: in.PrevIndex}] {
+			if other == self || m.txs[other].blockHash != nil {
+				continue
+			}
+			conflicting = append(conflicting, []byte(other))
+			if !m.hasConflict(other, self) {
+				m.conflicts = append(m.conflicts, &memConflict{original: other, replacement: self, detectedAt: time.Now()})
+			}
+		}
+	}
+	return conflicting, nil
+}
+
+func (m *Memory) hasConflict(original, replacement string) bool {
+	for _, c := range m.conflicts {
+		if c.original == original && c.replacement == replacement {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) ConfirmTransactions(blockHash []byte, blockHeight int, blockTimestamp time.Time, txHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range txHashes {
+		if tx := m.txs[string(h)]; tx != nil && tx.blockHash == nil {
+			tx.blockHash = blockHash
+		}
+	}
+	return nil
+}
+
+// ResolveConflicts settles the open conflicts that a block's transactions
+// decide, by whichever confirmed tx spends one of a pair's inputs
+func (m *Memory) ResolveConflicts(blockHash []byte, blockHeight int32, txHashes [][]byte) ([]ConflictOutcome, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	confirmed := make(map[string]bool, len(txHashes))
+	for _, h := range txHashes {
+		confirmed[string(h)] = true
+	}
+	now := time.Now()
+	var outcomes []ConflictOutcome
+	for _, c := range m.conflicts {
+		if c.resolvedBy != nil {
+			continue
+		}
+		outcome := ""
+		switch {
+		case confirmed[c.original]:
+			outcome = ConflictOriginal
+		case confirmed[c.replacement]:
+			outcome = ConflictReplacement
+		case m.thirdSpenderConfirmed(c, confirmed):
+			outcome = ConflictNeither
+		default:
+			continue
+		}
+		c.resolvedBy = blockHash
+		outcomes = append(outcomes, ConflictOutcome{Outcome: outcome, ResolveMs: now.Sub(c.detectedAt).Milliseconds()})
+	}
+	return outcomes, nil
+}
+
+// thirdSpenderConfirmed reports whether a confirmed tx outside the pair
+// spends one of its inputs
+func (m *Memory) thirdSpenderConfirmed(c *memConflict, confirmed map[string]bool) bool {
+	for _, side := range []string{c.original, c.replacement} {
+		tx := m.txs[side]
+		if tx == nil {
+			continue
+		}
+		for _, op := range tx.inputs {
+			for _, spender := range m.spenders[op] {
+				if confirmed[spender] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// RecordFeeAlert stores an alert for txHash with its propagation so far. It
+// returns false if the transaction was already alerted on.
+func (m *Memory) RecordFeeAlert(txHash []byte, reason string, fee Fee) (*FeeAlert, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.feeAlerts[string(txHash)] {
+		return nil, false, nil
+	}
+	m.feeAlerts[string(txHash)] = true
+	alert := &FeeAlert{TxHash: txHash, Reason: reason, Fee: fee}
+	if o := m.observations[string(txHash)]; o != nil {
+		alert.FirstSeenAt = o.firstSeen
+		alert.FirstPeer = o.firstPeer
+		alert.FirstRegion = m.peerRegion(o.firstPeer)
+		alert.PeerCount = o.peerCount
+		regions := make(map[string]bool)
+		for _, peer := range o.peers {
+			if r := m.peerRegion(peer); r != "" {
+				regions[r] = true
+			}
+		}
+		alert.RegionCount = len(regions)
+	}
+	return alert, true, nil
+}
+
+// RecordLowFeeTransaction stores a transaction relayed below the minimum
+// relay fee rate and returns the regions of the peers that announced it,
+// one per peer, or false if it was already recorded
+func (m *Memory) RecordLowFeeTransaction(txHash []byte, kind string, fee Fee) ([]string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lowFee[string(txHash)] {
+		return nil, false, nil
+	}
+	m.lowFee[string(txHash)] = true
+	var regions []string
+	if o := m.observations[string(txHash)]; o != nil {
+		seen := make(map[string]bool)
+		for _, peer := range o.peers {
+			if !seen[peer] {
+				seen[peer] = true
+				regions = append(regions, m.peerRegion(peer))
+			}
+		}
+	}
+	return regions, true, nil
+}
+
+func (m *Memory) RecordLockTime(txHash []byte, kind string, lockValue int64, tipHeight int32) (bool, error) {
+	return false, nil
+}
+
+func (m *Memory) UpdateLockTimes(height int32, mtp time.Time, txHashes [][]byte) ([]LockTimeConfirmation, error) {
+	return nil, nil
+}
+
+func (m *Memory) peerRegion(peerAddr string) string {
+	if p := m.peers[peerAddr]; p != nil {
+		return p.region
+	}
+	return ""
+}
+
+func (m *Memory) RecordScriptTemplateMatch(txHash []byte, template, location string, index int) (bool, error) {
+	key, _ := json.Marshal([]interface{}{txHash, template, location, index})
+	return m.tag(m.matches, string(key)), nil
+}
+
+func (m *Memory) RecordSpendTypes(txHash []byte, counts []SpendTypeCount) (bool, error) {
+	return m.tag(m.spendTypes, string(txHash)), nil
+}
+
+func (m *Memory) SaveLatencyProfiles(profiles []LatencyProfile) error {
+	return nil
+}
+
+func (m *Memory) LatencyProfiles() ([]LatencyProfile, error) {
+	return nil, nil
+}
+
+func (m *Memory) SaveRegionSuggestions(suggestions []RegionSuggestion) error {
+	return nil
+}
+
+func (m *Memory) SavePeerScores(scores []PeerScore) error {
+	return nil
+}
+
+func (m *Memory) PeerScores() ([]PeerScore, error) {
+	return nil, nil
+}
+
+func (m *Memory) CachedGeo(ips []string, maxAge time.Duration) (map[string]GeoCacheEntry, error) {
+	return nil, nil
+}
+
+func (m *Memory) SaveGeo(entries []GeoCacheEntry) error {
+	return nil
+}
+
+func (m *Memory) PeersMissingGeo(after string, limit int) ([]PeerMissingGeo, error) {
+	return nil, nil
+}
+
+func (m *Memory) RecordPeerTraffic(at time.Time, traffic []PeerTraffic) error {
+	return nil
+}
+
+func (m *Memory) SaveClaimReports(at time.Time, reports []ClaimReport) error {
+	return nil
+}
+
+func (m *Memory) ClaimStates() ([]ClaimState, error) {
+	return nil, nil
+}
+
+func (m *Memory) RegisterProject(p Project) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.projects[p.Name]
+	if !ok {
+		id = len(m.projects) + 1
+		m.projects[p.Name] = id
+	}
+	return id, nil
+}
+
+func (m *Memory) TagProjectTransaction(projectID int, txHash []byte, reason string) (bool, error) {
+	key, _ := json.Marshal([]interface{}{projectID, txHash})
+	return m.tag(m.projectTxs, string(key)), nil
+}
+
+// tag adds key to set, reporting whether it is new
+func (m *Memory) tag(set map[string]bool, key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if set[key] {
+		return false
+	}
+	set[key] = true
+	return true
+}
+
+// RecordBlock stores a block header. Like the blocks table, a block whose
+// height is already taken isn't stored.
+func (m *Memory) RecordBlock(block *protocol.Block, peerAddr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blocks[block.BlockHash]; ok {
+		return nil
+	}
+	if _, taken := m.heights[block.Height]; taken {
+		return nil
+	}
+	m.blocks[block.BlockHash] = &memBlock{
+		header: StoredHeader{
+			Hash:      block.BlockHash[:],
+			Height:    block.Height,
+			Timestamp: time.Unix(int64(block.Header.Timestamp), 0),
+			Bits:      block.Header.Bits,
+		},
+		prevHash: block.Header.PrevBlockHash,
+		version:  block.Header.Version,
+	}
+	m.heights[block.Height] = block.BlockHash
+	return nil
+}
+
+func (m *Memory) RecordBlockTxIDs(blockHash []byte, txids [][32]byte) error {
+	return nil
+}
+
+func (m *Memory) RecordQuarantinedBlock(block *protocol.Block, peerAddr, reason string) error {
+	return nil
+}
+
+func (m *Memory) RecordBlockOrdering(blockHash []byte, o BlockOrdering) error {
+	return nil
+}
+
+func (m *Memory) RecordBlockFees(blockHash []byte, f BlockFees) error {
+	return nil
+}
+
+func (m *Memory) RecordBlockAnnouncement(blockHash []byte, peerAddr, via string) error {
+	return nil
+}
+
+func (m *Memory) RecordBlockArrival(blockHash []byte, peerAddr, via string) error {
+	return nil
+}
+
+func (m *Memory) RecordBlockAnomaly(blockHash []byte, height int32, kind, detail string) error {
+	return nil
+}
+
+// GetBlockHeader returns the stored header for a block hash, or nil if unknown
+func (m *Memory) GetBlockHeader(blockHash []byte) (*StoredHeader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.header(toHash(blockHash)), nil
+}
+
+// GetBlockHeaderAtHeight returns the stored header at a height, or nil if unknown
+func (m *Memory) GetBlockHeaderAtHeight(height int32) (*StoredHeader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, ok := m.heights[height]
+	if !ok {
+		return nil, nil
+	}
+	return m.header(hash), nil
+}
+
+func (m *Memory) header(hash [32]byte) *StoredHeader {
+	b := m.blocks[hash]
+	if b == nil {
+		return nil
+	}
+	h := b.header
+	return &h
+}
+
+// AncestorTimestamps walks the stored chain back from blockHash (inclusive)
+// and returns up to n block timestamps, newest first
+func (m *Memory) AncestorTimestamps(blockHash []byte, n int) ([]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var timestamps []time.Time
+	for b := m.blocks[toHash(blockHash)]; b != nil && len(timestamps) < n; b = m.blocks[b.prevHash] {
+		timestamps = append(timestamps, b.header.Timestamp)
+	}
+	return timestamps, nil
+}
+
+// AncestorHashAtHeight walks the stored chain back from blockHash
+// (inclusive) and returns its ancestor at height, or nil if the stored chain
+// doesn't reach that far
+func (m *Memory) AncestorHashAtHeight(blockHash []byte, height int32) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for b := m.blocks[toHash(blockHash)]; b != nil && b.header.Height >= height; b = m.blocks[b.prevHash] {
+		if b.header.Height == height {
+			return b.header.Hash, nil
+		}
+	}
+	return nil, nil
+}
+
+// ChainLocator returns a block locator over the stored chain, newest first
+func (m *Memory) ChainLocator() ([]ChainPoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tip, ok := m.tipHeight()
+	if !ok {
+		return nil, nil
+	}
+	var points []ChainPoint
+	step := int32(1)
+	for h, n := tip, 0; h >= 0; h -= step {
+		if hash, ok := m.heights[h]; ok {
+			points = append(points, ChainPoint{Height: h, Hash: hash})
+		}
+		if n++; n >= 10 {
+			step *= 2
+		}
+	}
+	return points, nil
+}
+
+// ChainHashes returns the stored block hashes for heights in [from, to]
+func (m *Memory) ChainHashes(from, to int32) (map[int32][32]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hashes := make(map[int32][32]byte)
+	for h, hash := range m.heights {
+		if h >= from && h <= to {
+			hashes[h] = hash
+		}
+	}
+	return hashes, nil
+}
+
+// RecentChain walks the stored chain back from the highest block and
+// returns up to n headers, oldest first
+func (m *Memory) RecentChain(n int) ([]ChainHeader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tip, ok := m.tipHeight()
+	if !ok {
+		return nil, nil
+	}
+	var headers []ChainHeader
+	for b := m.blocks[m.heights[tip]]; b != nil && len(headers) < n; b = m.blocks[b.prevHash] {
+		headers = append(headers, ChainHeader{
+			ChainPoint: ChainPoint{Height: b.header.Height, Hash: toHash(b.header.Hash)},
+			PrevHash:   b.prevHash,
+			Timestamp:  b.header.Timestamp,
+			Bits:       b.header.Bits,
+		})
+	}
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers, nil
+}
+
+func (m *Memory) tipHeight() (int32, bool) {
+	tip, ok := int32(0), false
+	for h := range m.heights {
+		if !ok || h > tip {
+			tip, ok = h, true
+		}
+	}
+	return tip, ok
+}
+
+// OrphanBlocks drops blocks that left the best chain, un-confirms their
+// transactions and reopens the conflicts they settled
+func (m *Memory) OrphanBlocks(hashes, replacedBy [][]byte, forkHeight int32) (blocks, txs int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hash := range hashes {
+		b := m.blocks[toHash(hash)]
+		if b == nil {
+			continue
+		}
+		delete(m.blocks, toHash(hash))
+		delete(m.heights, b.header.Height)
+		blocks++
+		for _, tx := range m.txs {
+			if bytes.Equal(tx.blockHash, hash) {
+				tx.blockHash = nil
+				txs++
+			}
+		}
+		for _, c := range m.conflicts {
+			if bytes.Equal(c.resolvedBy, hash) {
+				c.resolvedBy = nil
+			}
+		}
+	}
+	return blocks, txs, nil
+}
+
+// UpdateVersionBitsPeriod counts per-bit signaling for the period starting
+// at periodStart over the stored blocks, returning the bits with any
+// signaling blocks
+func (m *Memory) UpdateVersionBitsPeriod(periodStart int32, periodLength int32) ([]BitSignaling, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var counts [29]int
+	total := 0
+	for h := periodStart; h < periodStart+periodLength; h++ {
+		hash, ok := m.heights[h]
+		if !ok {
+			continue
+		}
+		total++
+		v := uint32(m.blocks[hash].version)
+		if v&0xE0000000 != 0x20000000 {
+			continue
+		}
+		for bit := range counts {
+			if v>>bit&1 == 1 {
+				counts[bit]++
+			}
+		}
+	}
+	var result []BitSignaling
+	for bit, n := range counts {
+		if n > 0 {
+			result = append(result, BitSignaling{Bit: bit, Signaling: n, Total: total})
+		}
+	}
+	return result, nil
+}
+
+// RecordVersionBitsWindow counts per-bit signaling over the window blocks
+// ending at tipHeight
+func (m *Memory) RecordVersionBitsWindow(tipHeight int32, window int32) ([]BitSignaling, error) {
+	start := max(tipHeight-window+1, 0)
+	return m.UpdateVersionBitsPeriod(start, tipHeight-start+1)
+}
+
+func toHash(b []byte) [32]byte {
+	var h [32]byte
+	copy(h[:], b)
+	return h
+}
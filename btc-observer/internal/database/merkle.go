@@ -0,0 +1,16 @@
+package database
+
+// RecordBlockTxIDs stores a block's txids in block order, concatenated, for
+// building merkle inclusion proofs
+func (db *DB) RecordBlockTxIDs(blockHash []byte, txids [][32]byte) error {
+	packed := make([]byte, 0, 32*len(txids))
+	for _, txid := range txids {
+		packed = append(packed, txid[:]...)
+	}
+	_, err := db.conn.Exec(
+		`INSERT INTO block_txids (block_hash, txids) VALUES ($1, $2)
+		 ON CONFLICT (block_hash) DO NOTHING`,
+		blockHash, packed,
+	)
+	return err
+}
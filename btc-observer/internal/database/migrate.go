@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles are the numbered schema changes. A released migration is
+// never edited; schema changes go in a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key held while migrating, so observers
+// sharing a database don't apply the same migration twice
+const migrationLock = 0x6274636d6967 // "btcmig"
+
+// ErrSchemaOutdated is returned by CheckSchema when migrations are pending
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// ErrSchemaMismatch is returned when the database was migrated by a newer
+// build, or by one whose migrations differ from this build's
+var ErrSchemaMismatch = errors.New("database schema doesn't match this build")
+
+// recordedColumns are the columns the Record* queries write that tables
+// built by hand from the old schema.sql may lack. Migration 1 adds them, but
+// a database adopted before it did is already past that migration.
+var recordedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"peer_connections", []string{"network", "identity_id", "min_rtt_ms", "geo_min_rtt_ms", "geo_suspect"}},
+	{"blocks", []string{"bits", "version", "parse_error"}},
+	{"transaction_observations", []string{"experiment_run_id"}},
+	{"transactions", []string{"fee_rate", "vsize", "wtxid"}},
+	{"transaction_inputs", []string{"witness_size"}},
+	{"propagation_events", []string{"observer_id", "experiment_run_id"}},
+}
+
+// Migration is one schema change, from migrations/NNNN_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the embedded migrations in version order. Versions
+// must run from 1 without gaps.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, e := range entries {
+		num, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_description.sql", e.Name())
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing or duplicated", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the last applied migration, 0 for a database that
+// has never been migrated
+func (db *DB) SchemaVersion() (int, error) {
+	var exists bool
+	if err := db.conn.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int
+	err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// CheckSchema returns an error wrapping ErrSchemaOutdated when migrations
+// are pending, or ErrSchemaMismatch when the database was migrated by a
+// newer build, applied migrations this build doesn't have, or lacks columns
+// this build records
+func (db *DB) CheckSchema() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if current > 0 && current <= len(migrations) {
+		if err := compareApplied(context.Background(), db.conn, migrations); err != nil {
+			return err
+		}
+	}
+	if err := compareSchema(current, len(migrations)); err != nil {
+		return err
+	}
+	return db.checkColumns()
+}
+
+// checkColumns returns an error wrapping ErrSchemaMismatch naming the
+// recordedColumns the database lacks, so inserts don't fail on them later
+func (db *DB) checkColumns() error {
+	rows, err := db.conn.Query(
+		`SELECT table_name, column_name FROM information_schema.columns
+		 WHERE table_schema = current_schema()`)
+	if err != nil {
+		return fmt.Errorf("read columns: %w", err)
+	}
+	defer rows.Close()
+	have := make(map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		have[table+"."+column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var missing []string
+	for _, t := range recordedColumns {
+		for _, c := range t.columns {
+			if !have[t.table+"."+c] {
+				missing = append(missing, t.table+"."+c)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing columns %s", ErrSchemaMismatch, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func compareSchema(current, latest int) error {
+	switch {
+	case current < latest:
+		return fmt.Errorf("%w: at version %d, this build needs %d", ErrSchemaOutdated, current, latest)
+	case current > latest:
+		return fmt.Errorf("%w: version %d is newer than this build's %d", ErrSchemaMismatch, current, latest)
+	}
+	return nil
+}
+
+// querier is what compareApplied needs of a *sql.DB or *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// compareApplied checks that each migration recorded in schema_migrations
+// is the one this build has at that version, so a database migrated by a
+// diverging build isn't mistaken for a compatible one
+func compareApplied(ctx context.Context, q querier, migrations []Migration) error {
+	rows, err := q.QueryContext(ctx, `SELECT version, name FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return fmt.Errorf("read applied migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var name string
+		if err := rows.Scan(&version, &name); err != nil {
+			return err
+		}
+		if version < 1 || version > len(migrations) {
+			continue
+		}
+		if want := migrations[version-1].Name; name != want {
+			return fmt.Errorf("%w: migration %d is %q in the database but %q in this build", ErrSchemaMismatch, version, name, want)
+		}
+	}
+	return rows.Err()
+}
+
+// Migrate applies pending migrations, each in its own transaction, and
+// returns the ones applied. Concurrent callers wait on an advisory lock.
+func (db *DB) Migrate() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock belongs to a session, so everything runs on one connection
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	_, err = conn.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+		     version    INT PRIMARY KEY,
+		     name       VARCHAR(100) NOT NULL,
+		     applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		 )`)
+	if err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	var current int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return nil, compareSchema(current, len(migrations))
+	}
+	if err := compareApplied(ctx, conn, migrations); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations[current:] {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, fmt.Errorf("begin transaction: %w", err)
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("commit migration %d: %w", m.Version, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
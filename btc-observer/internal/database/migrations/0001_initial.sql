@@ -0,0 +1,438 @@
+-- Bitcoin Intelligence Platform - PostgreSQL Schema
+--
+-- Databases built by hand from the old schema.sql are adopted by this
+-- migration. Columns added to their tables since then are added with ALTER
+-- TABLE before anything indexes them.
+
+CREATE TABLE IF NOT EXISTS peer_connections (
+    peer_addr           VARCHAR(100) PRIMARY KEY,
+    first_connected_at  TIMESTAMP NOT NULL,
+    last_seen_at        TIMESTAMP,
+    protocol_version    INT,
+    user_agent          VARCHAR(200),
+    services            BIGINT,
+    network             VARCHAR(10),
+    identity_id         BIGINT,
+    avg_latency_ms      INT,
+    min_rtt_ms          INT,
+    geo_min_rtt_ms      INT,
+    geo_suspect         BOOLEAN,
+    tx_announcements    INT DEFAULT 0,
+    block_announcements INT DEFAULT 0,
+    connection_count    INT DEFAULT 0,
+    -- Geolocation fields
+    country_code        VARCHAR(2),
+    city                VARCHAR(100),
+    region              VARCHAR(50),
+    latitude            DECIMAL(9,6),
+    longitude           DECIMAL(9,6),
+    asn                 VARCHAR(100),
+    org_name            VARCHAR(200)
+);
+
+ALTER TABLE peer_connections ADD COLUMN IF NOT EXISTS network VARCHAR(10);
+ALTER TABLE peer_connections ADD COLUMN IF NOT EXISTS identity_id BIGINT;
+ALTER TABLE peer_connections ADD COLUMN IF NOT EXISTS min_rtt_ms INT;
+ALTER TABLE peer_connections ADD COLUMN IF NOT EXISTS geo_min_rtt_ms INT;
+ALTER TABLE peer_connections ADD COLUMN IF NOT EXISTS geo_suspect BOOLEAN;
+
+CREATE INDEX IF NOT EXISTS idx_peer_region ON peer_connections(region);
+
+CREATE TABLE IF NOT EXISTS peer_sessions (
+    id             BIGSERIAL PRIMARY KEY,
+    peer_addr      VARCHAR(100) NOT NULL,
+    region         VARCHAR(50),
+    transport      VARCHAR(10) NOT NULL,
+    started_at     TIMESTAMP NOT NULL,
+    ended_at       TIMESTAMP,
+    ping_count     INT NOT NULL DEFAULT 0,
+    avg_ping_ms    REAL,
+    min_ping_ms    INT,
+    tcp_rtt_ms     REAL,
+    tcp_rttvar_ms  REAL,
+    tcp_min_rtt_ms REAL,
+    mss            INT,
+    pmtu           INT,
+    retransmits    INT
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_sessions_addr ON peer_sessions(peer_addr, started_at);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    block_hash      BYTEA PRIMARY KEY,
+    height          INT UNIQUE NOT NULL,
+    version         INT,
+    prev_block_hash BYTEA,
+    merkle_root     BYTEA,
+    timestamp       TIMESTAMP,
+    difficulty      NUMERIC,
+    bits            BIGINT,
+    nonce           BIGINT,
+    tx_count        INT,
+    first_seen_at   TIMESTAMP,
+    first_peer_addr VARCHAR(100),
+    parse_error     TEXT
+);
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS bits BIGINT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS version INT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS parse_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_blocks_height ON blocks(height);
+CREATE INDEX IF NOT EXISTS idx_blocks_timestamp ON blocks(timestamp);
+
+CREATE TABLE IF NOT EXISTS block_anomalies (
+    block_hash  BYTEA NOT NULL,
+    height      INT,
+    kind        VARCHAR(50) NOT NULL,
+    detail      TEXT,
+    detected_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (block_hash, kind)
+);
+
+CREATE TABLE IF NOT EXISTS block_txids (
+    block_hash BYTEA PRIMARY KEY REFERENCES blocks(block_hash) ON DELETE CASCADE,
+    txids      BYTEA NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS version_bits_signaling (
+    period_start     INT NOT NULL,
+    bit              INT NOT NULL,
+    signaling_blocks INT NOT NULL,
+    total_blocks     INT NOT NULL,
+    updated_at       TIMESTAMP NOT NULL,
+    PRIMARY KEY (period_start, bit)
+);
+
+CREATE TABLE IF NOT EXISTS quarantined_blocks (
+    block_hash      BYTEA PRIMARY KEY,
+    height          INT,
+    prev_block_hash BYTEA,
+    timestamp       TIMESTAMP,
+    reason          VARCHAR(50) NOT NULL,
+    first_peer_addr VARCHAR(100),
+    received_at     TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transaction_observations (
+    tx_hash             BYTEA PRIMARY KEY,
+    first_seen_at       TIMESTAMP NOT NULL,
+    first_peer_addr     VARCHAR(100),
+    peer_count          INT DEFAULT 1,
+    experiment_run_id   INT,
+    in_block_hash       BYTEA,
+    confirmed_at        TIMESTAMP,
+    replaced_by_tx      BYTEA,
+    double_spend_flag   BOOLEAN DEFAULT FALSE
+);
+
+ALTER TABLE transaction_observations ADD COLUMN IF NOT EXISTS experiment_run_id INT;
+
+CREATE INDEX IF NOT EXISTS idx_tx_obs_first_seen ON transaction_observations(first_seen_at);
+CREATE INDEX IF NOT EXISTS idx_tx_obs_unconfirmed ON transaction_observations(in_block_hash)
+    WHERE in_block_hash IS NULL;
+
+CREATE TABLE IF NOT EXISTS transactions (
+    tx_hash         BYTEA PRIMARY KEY,
+    wtxid           BYTEA,
+    block_hash      BYTEA REFERENCES blocks(block_hash),
+    block_height    INT,
+    fee_satoshis    BIGINT,
+    fee_rate        DOUBLE PRECISION,
+    size_bytes      INT,
+    weight          INT,
+    vsize           INT,
+    input_count     INT,
+    output_count    INT,
+    total_input     BIGINT,
+    total_output    BIGINT
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fee_rate DOUBLE PRECISION;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS vsize INT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS wtxid BYTEA;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_block ON transactions(block_hash);
+
+CREATE TABLE IF NOT EXISTS transaction_inputs (
+    tx_hash         BYTEA NOT NULL,
+    input_index     INT NOT NULL,
+    prev_tx_hash    BYTEA NOT NULL,
+    prev_output_idx BIGINT NOT NULL,
+    value_satoshis  BIGINT,
+    script_sig      BYTEA,
+    witness_size    INT NOT NULL DEFAULT 0,
+    address         VARCHAR(100),
+    PRIMARY KEY (tx_hash, input_index)
+);
+
+ALTER TABLE transaction_inputs ADD COLUMN IF NOT EXISTS witness_size INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_tx_inputs_address ON transaction_inputs(address);
+CREATE INDEX IF NOT EXISTS idx_tx_inputs_prev_outpoint ON transaction_inputs(prev_tx_hash, prev_output_idx);
+
+CREATE TABLE IF NOT EXISTS transaction_outputs (
+    tx_hash         BYTEA NOT NULL,
+    output_index    INT NOT NULL,
+    address         VARCHAR(100),
+    value_satoshis  BIGINT NOT NULL,
+    script_pubkey   BYTEA,
+    spent_in_tx     BYTEA,
+    spent_at        TIMESTAMP,
+    PRIMARY KEY (tx_hash, output_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tx_outputs_address ON transaction_outputs(address);
+CREATE INDEX IF NOT EXISTS idx_tx_outputs_utxo ON transaction_outputs(spent_in_tx)
+    WHERE spent_in_tx IS NULL;
+
+CREATE TABLE IF NOT EXISTS script_template_matches (
+    tx_hash     BYTEA NOT NULL,
+    template    VARCHAR(100) NOT NULL,
+    location    VARCHAR(20) NOT NULL,
+    io_index    INT NOT NULL,
+    matched_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (tx_hash, template, location, io_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_script_template ON script_template_matches(template, matched_at);
+
+CREATE TABLE IF NOT EXISTS propagation_events (
+    id                  SERIAL PRIMARY KEY,
+    tx_hash             BYTEA NOT NULL,
+    peer_addr           VARCHAR(100) NOT NULL,
+    announcement_time   TIMESTAMP NOT NULL,
+    delay_from_first_ms INT,
+    observer_id         VARCHAR(100) NOT NULL DEFAULT '',
+    experiment_run_id   INT
+);
+
+ALTER TABLE propagation_events ADD COLUMN IF NOT EXISTS observer_id VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE propagation_events ADD COLUMN IF NOT EXISTS experiment_run_id INT;
+
+CREATE INDEX IF NOT EXISTS idx_propagation_tx ON propagation_events(tx_hash);
+
+CREATE TABLE IF NOT EXISTS observation_rollups (
+    granularity           VARCHAR(5) NOT NULL,
+    bucket_start          TIMESTAMP NOT NULL,
+    region                VARCHAR(50) NOT NULL,
+    tx_count              BIGINT NOT NULL DEFAULT 0,
+    total_fees            BIGINT NOT NULL DEFAULT 0,
+    median_fee_rate       DOUBLE PRECISION,
+    announcement_count    BIGINT NOT NULL DEFAULT 0,
+    median_propagation_ms DOUBLE PRECISION,
+    p90_propagation_ms    DOUBLE PRECISION,
+    peer_count            INT NOT NULL DEFAULT 0,
+    updated_at            TIMESTAMP NOT NULL,
+    PRIMARY KEY (granularity, bucket_start, region)
+);
+
+CREATE TABLE IF NOT EXISTS observers (
+    observer_id   VARCHAR(100) PRIMARY KEY,
+    latitude      DECIMAL(9,6) NOT NULL,
+    longitude     DECIMAL(9,6) NOT NULL,
+    registered_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tx_origin_estimates (
+    tx_hash        BYTEA PRIMARY KEY,
+    latitude       DECIMAL(9,6) NOT NULL,
+    longitude      DECIMAL(9,6) NOT NULL,
+    confidence     DOUBLE PRECISION NOT NULL,
+    residual_ms    DOUBLE PRECISION NOT NULL,
+    observer_count INT NOT NULL,
+    estimated_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS experiment_runs (
+    id         SERIAL PRIMARY KEY,
+    name       VARCHAR(100) NOT NULL,
+    policy     JSONB NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at   TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS peer_chain_status (
+    peer_addr       VARCHAR(100) PRIMARY KEY,
+    status          VARCHAR(20) NOT NULL,
+    our_tip_height  INT NOT NULL,
+    peer_tip_hash   BYTEA,
+    peer_tip_height INT,
+    fork_hash       BYTEA,
+    fork_height     INT,
+    checked_at      TIMESTAMP NOT NULL,
+    divergent_since TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS parse_failures (
+    id             BIGSERIAL PRIMARY KEY,
+    command        VARCHAR(12) NOT NULL,
+    error_class    VARCHAR(20) NOT NULL,
+    error          TEXT NOT NULL,
+    payload_size   INT NOT NULL,
+    payload_sample BYTEA,
+    peer_addr      VARCHAR(100) NOT NULL,
+    observed_at    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_parse_failures_observed ON parse_failures(observed_at);
+
+CREATE TABLE IF NOT EXISTS peer_addresses (
+    address          VARCHAR(100) PRIMARY KEY,
+    network          VARCHAR(10) NOT NULL,
+    services         BIGINT,
+    advertised_at    TIMESTAMP,
+    first_heard_at   TIMESTAMP NOT NULL,
+    last_heard_at    TIMESTAMP NOT NULL,
+    first_heard_from VARCHAR(100),
+    heard_count      INT DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_addresses_network ON peer_addresses(network);
+
+CREATE TABLE IF NOT EXISTS peer_address_sources (
+    address        VARCHAR(100) NOT NULL,
+    source_peer    VARCHAR(100) NOT NULL,
+    first_heard_at TIMESTAMP NOT NULL,
+    last_heard_at  TIMESTAMP NOT NULL,
+    heard_count    INT NOT NULL DEFAULT 1,
+    PRIMARY KEY (address, source_peer)
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_address_sources_source ON peer_address_sources(source_peer);
+
+CREATE TABLE IF NOT EXISTS census_snapshots (
+    id          BIGSERIAL PRIMARY KEY,
+    network     VARCHAR(20) NOT NULL,
+    started_at  TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    attempted   INT NOT NULL,
+    reachable   INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS census_nodes (
+    snapshot_id      BIGINT NOT NULL REFERENCES census_snapshots(id) ON DELETE CASCADE,
+    address          VARCHAR(100) NOT NULL,
+    network          VARCHAR(10) NOT NULL,
+    protocol_version INT,
+    user_agent       VARCHAR(200),
+    services         BIGINT,
+    start_height     INT,
+    relay            BOOLEAN,
+    handshake_ms     INT,
+    addrs_returned   INT,
+    country_code     VARCHAR(2),
+    city             VARCHAR(100),
+    latitude         DECIMAL(9,6),
+    longitude        DECIMAL(9,6),
+    asn              VARCHAR(100),
+    org_name         VARCHAR(200),
+    PRIMARY KEY (snapshot_id, address)
+);
+
+CREATE TABLE IF NOT EXISTS peer_identities (
+    id            BIGSERIAL PRIMARY KEY,
+    user_agent    VARCHAR(200) NOT NULL,
+    services      BIGINT NOT NULL,
+    fingerprint   VARCHAR(16) NOT NULL,
+    asn           VARCHAR(100) NOT NULL,
+    first_seen_at TIMESTAMP NOT NULL,
+    last_seen_at  TIMESTAMP NOT NULL,
+    last_addr     VARCHAR(100) NOT NULL,
+    address_count INT DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_identities_key ON peer_identities(user_agent, services, fingerprint, asn);
+CREATE INDEX IF NOT EXISTS idx_peer_identity ON peer_connections(identity_id);
+
+CREATE OR REPLACE VIEW peer_identity_stats AS
+SELECT
+    pi.id AS identity_id,
+    pi.user_agent,
+    pi.asn,
+    pi.first_seen_at,
+    pi.last_seen_at,
+    COUNT(pc.peer_addr)         AS addresses,
+    SUM(pc.connection_count)    AS connection_count,
+    SUM(pc.tx_announcements)    AS tx_announcements,
+    SUM(pc.block_announcements) AS block_announcements,
+    AVG(pc.avg_latency_ms)::INT AS avg_latency_ms
+FROM peer_identities pi
+JOIN peer_connections pc ON pc.identity_id = pi.id
+GROUP BY pi.id;
+
+CREATE TABLE IF NOT EXISTS fee_alerts (
+    tx_hash         BYTEA PRIMARY KEY,
+    reason          VARCHAR(20) NOT NULL,
+    fee_satoshis    BIGINT NOT NULL,
+    fee_rate        DOUBLE PRECISION NOT NULL,
+    first_seen_at   TIMESTAMP,
+    first_peer_addr VARCHAR(100),
+    first_region    VARCHAR(50),
+    peer_count      INT,
+    region_count    INT,
+    alerted_at      TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_fee_alerts_time ON fee_alerts(alerted_at);
+
+CREATE TABLE IF NOT EXISTS orphaned_blocks (
+    block_hash      BYTEA PRIMARY KEY,
+    height          INT NOT NULL,
+    prev_block_hash BYTEA,
+    timestamp       TIMESTAMP,
+    bits            BIGINT,
+    tx_count        INT,
+    first_seen_at   TIMESTAMP,
+    first_peer_addr VARCHAR(100),
+    replaced_by     BYTEA,
+    fork_height     INT NOT NULL,
+    orphaned_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_orphaned_blocks_time ON orphaned_blocks(orphaned_at);
+
+CREATE TABLE IF NOT EXISTS low_fee_transactions (
+    tx_hash         BYTEA PRIMARY KEY,
+    kind            VARCHAR(20) NOT NULL,
+    fee_satoshis    BIGINT NOT NULL,
+    fee_rate        DOUBLE PRECISION NOT NULL,
+    weight          INT,
+    relay_count     INT NOT NULL DEFAULT 0,
+    first_seen_at   TIMESTAMP,
+    first_peer_addr VARCHAR(100),
+    detected_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_low_fee_tx_time ON low_fee_transactions(detected_at);
+
+CREATE TABLE IF NOT EXISTS low_fee_relays (
+    tx_hash      BYTEA NOT NULL,
+    peer_addr    VARCHAR(100) NOT NULL,
+    announced_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tx_hash, peer_addr)
+);
+
+CREATE INDEX IF NOT EXISTS idx_low_fee_relays_peer ON low_fee_relays(peer_addr);
+
+CREATE TABLE IF NOT EXISTS conflict_outcomes (
+    original_tx          BYTEA NOT NULL,
+    replacement_tx       BYTEA NOT NULL,
+    detected_at          TIMESTAMP NOT NULL,
+    outcome              VARCHAR(20),
+    winner_tx            BYTEA,
+    original_fee         BIGINT,
+    replacement_fee      BIGINT,
+    original_fee_rate    DOUBLE PRECISION,
+    replacement_fee_rate DOUBLE PRECISION,
+    winner_fee_delta     BIGINT,
+    resolved_block_hash  BYTEA,
+    resolved_height      INT,
+    resolved_at          TIMESTAMP,
+    resolve_ms           BIGINT,
+    PRIMARY KEY (original_tx, replacement_tx)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conflict_outcomes_open ON conflict_outcomes(detected_at)
+    WHERE outcome IS NULL;
+CREATE INDEX IF NOT EXISTS idx_conflict_outcomes_resolved ON conflict_outcomes(resolved_at);
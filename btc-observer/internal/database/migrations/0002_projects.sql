@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS projects (
+    id             SERIAL PRIMARY KEY,
+    name           VARCHAR(100) UNIQUE NOT NULL,
+    description    TEXT,
+    config         JSONB NOT NULL,
+    peer_countries TEXT[] NOT NULL DEFAULT '{}',
+    peer_regions   TEXT[] NOT NULL DEFAULT '{}',
+    peer_asns      TEXT[] NOT NULL DEFAULT '{}',
+    created_at     TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS project_transactions (
+    project_id INT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    tx_hash    BYTEA NOT NULL,
+    matched_at TIMESTAMP NOT NULL,
+    reason     VARCHAR(20) NOT NULL,
+    PRIMARY KEY (project_id, tx_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_txs_matched ON project_transactions(project_id, matched_at);
+
+CREATE OR REPLACE VIEW project_propagation_events AS
+SELECT pt.project_id, pe.id, pe.tx_hash, pe.peer_addr, pe.announcement_time,
+       (EXTRACT(EPOCH FROM (pe.announcement_time
+           - MIN(pe.announcement_time) OVER (PARTITION BY pt.project_id, pe.tx_hash))) * 1000)::INT AS delay_from_first_ms,
+       pe.observer_id, pe.experiment_run_id
+FROM project_transactions pt
+JOIN projects p ON p.id = pt.project_id
+JOIN propagation_events pe ON pe.tx_hash = pt.tx_hash
+LEFT JOIN peer_connections pc ON pc.peer_addr = pe.peer_addr
+WHERE (cardinality(p.peer_countries) = 0 OR pc.country_code = ANY(p.peer_countries))
+  AND (cardinality(p.peer_regions) = 0 OR pc.region = ANY(p.peer_regions))
+  AND (cardinality(p.peer_asns) = 0 OR split_part(upper(pc.asn), ' ', 1) = ANY(p.peer_asns));
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS version_nonces (
+    nonce       BIGINT PRIMARY KEY,
+    peer_addr   VARCHAR(100) NOT NULL,
+    observer_id VARCHAR(100),
+    sent_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_version_nonces_sent ON version_nonces(sent_at);
+
+CREATE TABLE IF NOT EXISTS peer_misbehavior (
+    id          BIGSERIAL PRIMARY KEY,
+    peer_addr   VARCHAR(100) NOT NULL,
+    reason      VARCHAR(30) NOT NULL,
+    detail      TEXT,
+    observer_id VARCHAR(100),
+    observed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_misbehavior_peer ON peer_misbehavior(peer_addr, observed_at);
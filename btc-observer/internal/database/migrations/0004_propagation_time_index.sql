@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_propagation_time ON propagation_events(announcement_time);
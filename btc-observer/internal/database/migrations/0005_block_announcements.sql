@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS block_announcements (
+    id                  BIGSERIAL PRIMARY KEY,
+    block_hash          BYTEA NOT NULL,
+    peer_addr           VARCHAR(100) NOT NULL,
+    via                 VARCHAR(10) NOT NULL,
+    announcement_time   TIMESTAMP NOT NULL,
+    delay_from_first_ms INT,
+    observer_id         VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_announcements_hash ON block_announcements(block_hash);
+CREATE INDEX IF NOT EXISTS idx_block_announcements_time ON block_announcements(announcement_time);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS peer_version_changes (
+    id             BIGSERIAL PRIMARY KEY,
+    peer_addr      VARCHAR(100) NOT NULL,
+    kind           VARCHAR(20) NOT NULL,
+    old_version    INT,
+    new_version    INT,
+    old_services   BIGINT,
+    new_services   BIGINT,
+    old_user_agent VARCHAR(200),
+    new_user_agent VARCHAR(200),
+    observed_at    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_version_changes_time ON peer_version_changes(observed_at);
+CREATE INDEX IF NOT EXISTS idx_peer_version_changes_peer ON peer_version_changes(peer_addr, observed_at);
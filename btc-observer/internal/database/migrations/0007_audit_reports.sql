@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS audit_reports (
+    id            BIGSERIAL PRIMARY KEY,
+    check_name    VARCHAR(50) NOT NULL,
+    discrepancies BIGINT NOT NULL,
+    samples       TEXT[] NOT NULL DEFAULT '{}',
+    window_start  TIMESTAMP NOT NULL,
+    duration_ms   BIGINT NOT NULL,
+    ran_at        TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_reports_check ON audit_reports(check_name, ran_at);
@@ -0,0 +1,4 @@
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS subsidy BIGINT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS coinbase_value BIGINT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS total_fees BIGINT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS unresolved_fee_txs INT;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS version_bits_windows (
+    tip_height       INT NOT NULL,
+    bit              INT NOT NULL,
+    signaling_blocks INT NOT NULL,
+    total_blocks     INT NOT NULL,
+    recorded_at      TIMESTAMP NOT NULL,
+    PRIMARY KEY (tip_height, bit)
+);
+
+CREATE INDEX IF NOT EXISTS idx_version_bits_windows_bit ON version_bits_windows(bit, tip_height);
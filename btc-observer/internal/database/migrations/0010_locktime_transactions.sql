@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS locktime_transactions (
+    tx_hash          BYTEA PRIMARY KEY,
+    lock_kind        VARCHAR(8) NOT NULL,
+    lock_value       BIGINT NOT NULL,
+    seen_tip_height  INT NOT NULL,
+    first_seen_at    TIMESTAMP NOT NULL,
+    valid_height     INT,
+    valid_at         TIMESTAMP,
+    confirmed_height INT,
+    confirmed_at     TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_locktime_transactions_pending ON locktime_transactions(lock_kind, lock_value) WHERE valid_height IS NULL;
+CREATE INDEX IF NOT EXISTS idx_locktime_transactions_seen ON locktime_transactions(first_seen_at);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS transaction_peer_observations (
+    tx_hash       BYTEA NOT NULL,
+    peer_addr     VARCHAR(100) NOT NULL,
+    observer_id   VARCHAR(100) NOT NULL DEFAULT '',
+    first_seen_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tx_hash, peer_addr, observer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tx_peer_obs_first_seen ON transaction_peer_observations(first_seen_at);
+
+-- Seed from the propagation events still held, each peer's first announcement
+INSERT INTO transaction_peer_observations (tx_hash, peer_addr, observer_id, first_seen_at)
+SELECT tx_hash, peer_addr, observer_id, MIN(announcement_time)
+FROM propagation_events
+GROUP BY tx_hash, peer_addr, observer_id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS block_propagation (
+    block_hash          BYTEA NOT NULL,
+    peer_addr           VARCHAR(100) NOT NULL,
+    observer_id         VARCHAR(100) NOT NULL DEFAULT '',
+    via                 VARCHAR(10) NOT NULL,
+    announcement_time   TIMESTAMP NOT NULL,
+    delay_from_first_ms INT NOT NULL,
+    PRIMARY KEY (block_hash, peer_addr, observer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_propagation_time ON block_propagation(announcement_time);
+
+-- Seed from the announcements already recorded, each peer's first one
+INSERT INTO block_propagation (block_hash, peer_addr, observer_id, via, announcement_time, delay_from_first_ms)
+SELECT DISTINCT ON (block_hash, peer_addr, observer_id)
+       block_hash, peer_addr, observer_id, via, announcement_time, COALESCE(delay_from_first_ms, 0)
+FROM block_announcements
+ORDER BY block_hash, peer_addr, observer_id, announcement_time
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS relay_probes (
+    id              BIGSERIAL PRIMARY KEY,
+    tx_hash         BYTEA NOT NULL UNIQUE,
+    variant         VARCHAR(50) NOT NULL,
+    fee_rate        DOUBLE PRECISION NOT NULL,
+    vsize           INT NOT NULL,
+    output_type     VARCHAR(20) NOT NULL,
+    data_bytes      INT NOT NULL DEFAULT 0,
+    first_hop       VARCHAR(100) NOT NULL,
+    sent_at         TIMESTAMP NOT NULL,
+    observer_id     VARCHAR(100) NOT NULL DEFAULT '',
+    measured_at     TIMESTAMP,
+    relay_peers     INT,
+    first_relay_ms  INT,
+    median_relay_ms INT
+);
+
+CREATE INDEX IF NOT EXISTS idx_relay_probes_variant ON relay_probes(variant, sent_at);
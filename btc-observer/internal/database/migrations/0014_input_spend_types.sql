@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS input_spend_types (
+    tx_hash     BYTEA NOT NULL,
+    spend_type  VARCHAR(20) NOT NULL,
+    multisig    VARCHAR(20) NOT NULL DEFAULT '',
+    inputs      INT NOT NULL,
+    recorded_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tx_hash, spend_type, multisig)
+);
+
+CREATE INDEX IF NOT EXISTS idx_input_spend_types_time ON input_spend_types(recorded_at);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS peer_latency_profiles (
+    peer_addr   VARCHAR(100) NOT NULL,
+    kind        VARCHAR(10) NOT NULL,
+    observer_id VARCHAR(100) NOT NULL DEFAULT '',
+    samples     BIGINT NOT NULL,
+    median_ms   INT NOT NULL,
+    p90_ms      INT NOT NULL,
+    buckets     BIGINT[] NOT NULL,
+    updated_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (peer_addr, kind, observer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_latency_profiles_kind ON peer_latency_profiles(kind, median_ms);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS region_suggestions (
+    kind         VARCHAR(10) NOT NULL,
+    key          VARCHAR(100) NOT NULL,
+    observer_id  VARCHAR(100) NOT NULL DEFAULT '',
+    country_code VARCHAR(2),
+    org_name     VARCHAR(200),
+    rank         INT NOT NULL,
+    nodes        INT NOT NULL,
+    reachable    INT NOT NULL,
+    heard        INT NOT NULL,
+    asns         INT NOT NULL,
+    auto_added   BOOLEAN NOT NULL DEFAULT FALSE,
+    computed_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (kind, key, observer_id)
+);
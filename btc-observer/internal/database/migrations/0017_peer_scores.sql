@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS peer_scores (
+    peer_addr   VARCHAR(100) NOT NULL,
+    observer_id VARCHAR(100) NOT NULL DEFAULT '',
+    penalty     DOUBLE PRECISION NOT NULL,
+    counts      JSONB NOT NULL,
+    ban_reason  VARCHAR(200),
+    updated_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (peer_addr, observer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_scores_penalty ON peer_scores(penalty DESC);
@@ -0,0 +1,16 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS block_position INT;
+
+CREATE TABLE IF NOT EXISTS block_ordering (
+    block_hash      BYTEA PRIMARY KEY REFERENCES blocks(block_hash),
+    height          INT NOT NULL,
+    miner           VARCHAR(100) NOT NULL,
+    txs             INT NOT NULL,
+    fee_known_txs   INT NOT NULL,
+    inversions      BIGINT NOT NULL,
+    entropy         DOUBLE PRECISION NOT NULL,
+    prioritized     INT NOT NULL,
+    prioritized_txs BYTEA[] NOT NULL,
+    computed_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_ordering_miner ON block_ordering(miner, height);
@@ -0,0 +1,2 @@
+ALTER TABLE peer_scores ADD COLUMN IF NOT EXISTS score_ban BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE peer_scores ADD COLUMN IF NOT EXISTS failed_at TIMESTAMP;
@@ -0,0 +1,4 @@
+ALTER TABLE peer_sessions ADD COLUMN IF NOT EXISTS sendheaders BOOLEAN;
+ALTER TABLE peer_sessions ADD COLUMN IF NOT EXISTS cmpct_version INT;
+ALTER TABLE peer_sessions ADD COLUMN IF NOT EXISTS wtxidrelay BOOLEAN;
+ALTER TABLE peer_sessions ADD COLUMN IF NOT EXISTS addrv2 BOOLEAN;
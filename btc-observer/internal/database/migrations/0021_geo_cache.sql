@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS geo_cache (
+    ip           VARCHAR(45) PRIMARY KEY,
+    country      VARCHAR(100) NOT NULL,
+    country_code VARCHAR(2) NOT NULL,
+    city         VARCHAR(100) NOT NULL,
+    latitude     DOUBLE PRECISION NOT NULL,
+    longitude    DOUBLE PRECISION NOT NULL,
+    isp          VARCHAR(200) NOT NULL,
+    org          VARCHAR(200) NOT NULL,
+    asn          VARCHAR(200) NOT NULL,
+    resolved_at  TIMESTAMP NOT NULL
+);
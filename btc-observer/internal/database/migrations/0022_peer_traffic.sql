@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS peer_traffic (
+    hour              TIMESTAMP NOT NULL,
+    peer_addr         VARCHAR(100) NOT NULL,
+    command           VARCHAR(12) NOT NULL,
+    observer_id       VARCHAR(100) NOT NULL DEFAULT '',
+    region            VARCHAR(100) NOT NULL,
+    bytes_sent        BIGINT NOT NULL DEFAULT 0,
+    bytes_received    BIGINT NOT NULL DEFAULT 0,
+    messages_sent     BIGINT NOT NULL DEFAULT 0,
+    messages_received BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, peer_addr, command, observer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_traffic_region ON peer_traffic(region, hour);
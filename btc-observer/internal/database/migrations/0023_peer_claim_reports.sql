@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS peer_claim_reports (
+    id               BIGSERIAL PRIMARY KEY,
+    peer_addr        VARCHAR(100) NOT NULL,
+    observer_id      VARCHAR(100) NOT NULL DEFAULT '',
+    region           VARCHAR(100) NOT NULL,
+    reported_at      TIMESTAMP NOT NULL,
+    services         BIGINT NOT NULL,
+    start_height     INT NOT NULL,
+    best_height      INT NOT NULL,
+    fee_filter       BIGINT NOT NULL,
+    blocks_requested INT NOT NULL,
+    blocks_served    INT NOT NULL,
+    blocks_not_found INT NOT NULL,
+    headers_asked    INT NOT NULL,
+    headers_answered INT NOT NULL,
+    txs_relayed      INT NOT NULL,
+    txs_below_filter INT NOT NULL,
+    divergences      TEXT[] NOT NULL,
+    streak           INT NOT NULL,
+    clean_streak     INT NOT NULL,
+    excluded         BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_peer_claim_reports_peer ON peer_claim_reports(peer_addr, reported_at);
+CREATE INDEX IF NOT EXISTS idx_peer_claim_reports_time ON peer_claim_reports(reported_at);
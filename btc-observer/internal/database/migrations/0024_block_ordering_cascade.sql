@@ -0,0 +1,4 @@
+-- Orphaned blocks are deleted on reorg; their ordering rows go with them
+ALTER TABLE block_ordering DROP CONSTRAINT IF EXISTS block_ordering_block_hash_fkey;
+ALTER TABLE block_ordering ADD CONSTRAINT block_ordering_block_hash_fkey
+    FOREIGN KEY (block_hash) REFERENCES blocks(block_hash) ON DELETE CASCADE;
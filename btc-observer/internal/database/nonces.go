@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// SentNonce is a version nonce the observer sent in a handshake
+type SentNonce struct {
+	Nonce      uint64
+	PeerAddr   string
+	ObserverID string
+	SentAt     time.Time
+}
+
+// RecordVersionNonce stores a nonce sent to peerAddr, so a peer echoing it
+// later can be caught across restarts
+func (db *DB) RecordVersionNonce(nonce uint64, peerAddr string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO version_nonces (nonce, peer_addr, observer_id, sent_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (nonce) DO NOTHING`,
+		int64(nonce), peerAddr, db.observerID, time.Now().UTC(),
+	)
+	return err
+}
+
+// VersionNonces returns the nonces sent since a time, by any observer
+// sharing the database
+func (db *DB) VersionNonces(since time.Time) ([]SentNonce, error) {
+	rows, err := db.conn.Query(
+		`SELECT nonce, peer_addr, COALESCE(observer_id, ''), sent_at
+		 FROM version_nonces
+		 WHERE sent_at >= $1`,
+		since.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query version nonces: %w", err)
+	}
+	defer rows.Close()
+
+	var nonces []SentNonce
+	for rows.Next() {
+		var n SentNonce
+		var nonce int64
+		if err := rows.Scan(&nonce, &n.PeerAddr, &n.ObserverID, &n.SentAt); err != nil {
+			return nil, err
+		}
+		n.Nonce = uint64(nonce)
+		nonces = append(nonces, n)
+	}
+	return nonces, rows.Err()
+}
+
+// PruneVersionNonces deletes nonces sent before a time, returning how many
+// were removed
+func (db *DB) PruneVersionNonces(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM version_nonces WHERE sent_at < $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RecordPeerMisbehavior stores a protocol violation by a peer, such as
+// replaying a handshake nonce
+func (db *DB) RecordPeerMisbehavior(peerAddr, reason, detail string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO peer_misbehavior (peer_addr, reason, detail, observer_id, observed_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		peerAddr, reason, detail, db.observerID, time.Now().UTC(),
+	)
+	return err
+}
@@ -0,0 +1,39 @@
+package database
+
+import "github.com/lib/pq"
+
+// BlockOrdering compares a block's transaction order with the fee-rate
+// order a template would use
+type BlockOrdering struct {
+	Height      int32
+	Miner       string
+	Txs         int // non-coinbase transactions
+	FeeKnownTxs int // of which with a known fee, the ones compared
+	Inversions  int64
+	// Entropy is the fraction of compared pairs placed against fee-rate
+	// order: 0 for a fee-ordered block, around 0.5 for a shuffled one
+	Entropy float64
+	// Prioritized counts transactions placed ahead of one paying several
+	// times their fee rate; PrioritizedTxs lists the first of them
+	Prioritized    int
+	PrioritizedTxs [][]byte
+}
+
+// RecordBlockOrdering stores a block's ordering analysis, replacing an
+// earlier one
+func (db *DB) RecordBlockOrdering(blockHash []byte, o BlockOrdering) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO block_ordering (block_hash, height, miner, txs, fee_known_txs, inversions, entropy, prioritized, prioritized_txs, computed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		 ON CONFLICT (block_hash) DO UPDATE SET
+		     fee_known_txs = EXCLUDED.fee_known_txs,
+		     inversions = EXCLUDED.inversions,
+		     entropy = EXCLUDED.entropy,
+		     prioritized = EXCLUDED.prioritized,
+		     prioritized_txs = EXCLUDED.prioritized_txs,
+		     computed_at = NOW()`,
+		blockHash, o.Height, o.Miner, o.Txs, o.FeeKnownTxs, o.Inversions, o.Entropy,
+		o.Prioritized, pq.ByteaArray(o.PrioritizedTxs),
+	)
+	return err
+}
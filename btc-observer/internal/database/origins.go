@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Location is a point on the globe in degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// VantageArrival is the first time one observer instance saw a tx announced
+type VantageArrival struct {
+	ObserverID string
+	Location   Location
+	FirstSeen  time.Time
+}
+
+// OriginEstimate is an estimated geographic origin of a transaction
+type OriginEstimate struct {
+	Location
+	Confidence    float64
+	ResidualMs    float64
+	ObserverCount int
+}
+
+// RegisterObserver records where an observer instance runs so its
+// observations can be used for triangulation
+func (db *DB) RegisterObserver(id string, loc Location) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO observers (observer_id, latitude, longitude, registered_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT (observer_id) DO UPDATE SET
+		     latitude = EXCLUDED.latitude,
+		     longitude = EXCLUDED.longitude,
+		     registered_at = NOW()`,
+		id, loc.Latitude, loc.Longitude,
+	)
+	return err
+}
+
+// VantageArrivals returns, for each tx first seen in [from, to) that has no
+// origin estimate yet, the first announcement time at every registered
+// observer that saw it
+func (db *DB) VantageArrivals(from, to time.Time) (map[[32]byte][]VantageArrival, error) {
+	rows, err := db.conn.Query(
+		`SELECT pe.tx_hash, pe.observer_id, ob.latitude, ob.longitude, MIN(pe.announcement_time)
+		 FROM transaction_observations o
+		 JOIN propagation_events pe ON pe.tx_hash = o.tx_hash
+		 JOIN observers ob ON ob.observer_id = pe.observer_id
+		 LEFT JOIN tx_origin_estimates e ON e.tx_hash = o.tx_hash
+		 WHERE o.first_seen_at >= $1 AND o.first_seen_at < $2 AND e.tx_hash IS NULL
+		 GROUP BY pe.tx_hash, pe.observer_id, ob.latitude, ob.longitude`,
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arrivals := make(map[[32]byte][]VantageArrival)
+	for rows.Next() {
+		var hash []byte
+		var a VantageArrival
+		if err := rows.Scan(&hash, &a.ObserverID, &a.Location.Latitude, &a.Location.Longitude, &a.FirstSeen); err != nil {
+			return nil, err
+		}
+		if len(hash) != 32 {
+			continue
+		}
+		var key [32]byte
+		copy(key[:], hash)
+		arrivals[key] = append(arrivals[key], a)
+	}
+	return arrivals, rows.Err()
+}
+
+// RecordOriginEstimate stores a tx's estimated origin
+func (db *DB) RecordOriginEstimate(txHash []byte, est OriginEstimate) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO tx_origin_estimates (tx_hash, latitude, longitude, confidence, residual_ms, observer_count, estimated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 ON CONFLICT (tx_hash) DO NOTHING`,
+		txHash, est.Latitude, est.Longitude, est.Confidence, est.ResidualMs, est.ObserverCount,
+	)
+	if err != nil {
+		return fmt.Errorf("record origin estimate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package database
+
+import "time"
+
+// ParseFailure is a message the observer couldn't parse
+type ParseFailure struct {
+	Command  string
+	Class    string
+	Error    string
+	Size     int
+	Sample   []byte
+	PeerAddr string
+}
+
+// RecordParseFailure stores a parse failure
+func (db *DB) RecordParseFailure(f ParseFailure) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO parse_failures (command, error_class, error, payload_size, payload_sample, peer_addr, observed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		f.Command, f.Class, f.Error, f.Size, f.Sample, f.PeerAddr, time.Now().UTC(),
+	)
+	return err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PeerScore is a peer's decayed penalty and the misbehavior behind it
+type PeerScore struct {
+	PeerAddr  string
+	Penalty   float64
+	Counts    map[string]int // penalties by kind since the score was last reset
+	BanReason string         // "" when not banned
+	ScoreBan  bool           // banned for reaching the ban score rather than outright
+	FailedAt  time.Time      // last connection failure, zero if none
+	UpdatedAt time.Time      // when Penalty was current; set on load
+}
+
+// SavePeerScores upserts this observer's peer scores
+func (db *DB) SavePeerScores(scores []PeerScore) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	for _, s := range scores {
+		counts, err := json.Marshal(s.Counts)
+		if err != nil {
+			return fmt.Errorf("encode penalty counts: %w", err)
+		}
+		_, err = dbTx.Exec(
+			`INSERT INTO peer_scores (peer_addr, observer_id, penalty, counts, ban_reason, score_ban, failed_at, updated_at)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NOW())
+			 ON CONFLICT (peer_addr, observer_id) DO UPDATE SET
+			     penalty = EXCLUDED.penalty,
+			     counts = EXCLUDED.counts,
+			     ban_reason = EXCLUDED.ban_reason,
+			     score_ban = EXCLUDED.score_ban,
+			     failed_at = EXCLUDED.failed_at,
+			     updated_at = NOW()`,
+			s.PeerAddr, db.observerID, s.Penalty, counts, s.BanReason, s.ScoreBan,
+			sql.NullTime{Time: s.FailedAt, Valid: !s.FailedAt.IsZero()},
+		)
+		if err != nil {
+			return fmt.Errorf("upsert peer score: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
+
+// PeerScores returns this observer's stored peer scores that may still
+// matter: banned, penalized, or with a recorded failure
+func (db *DB) PeerScores() ([]PeerScore, error) {
+	rows, err := db.conn.Query(
+		`SELECT peer_addr, penalty, counts, COALESCE(ban_reason, ''), score_ban, failed_at, updated_at
+		 FROM peer_scores
+		 WHERE observer_id = $1
+		   AND (ban_reason IS NOT NULL OR penalty >= 0.1 OR failed_at IS NOT NULL)`,
+		db.observerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query peer scores: %w", err)
+	}
+	defer rows.Close()
+
+	var scores []PeerScore
+	for rows.Next() {
+		var s PeerScore
+		var counts []byte
+		var failedAt sql.NullTime
+		if err := rows.Scan(&s.PeerAddr, &s.Penalty, &counts, &s.BanReason, &s.ScoreBan, &failedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(counts, &s.Counts); err != nil {
+			return nil, fmt.Errorf("decode penalty counts: %w", err)
+		}
+		s.FailedAt = failedAt.Time
+		scores = append(scores, s)
+	}
+	return scores, rows.Err()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RelayProbe is a crafted transaction sent to one peer to measure which
+// other peers relay it
+type RelayProbe struct {
+	TxHash     []byte
+	Variant    string
+	FeeRate    float64 // sat/vB
+	VSize      int
+	OutputType string
+	DataBytes  int // OP_RETURN payload size, 0 for none
+	FirstHop   string
+	SentAt     time.Time
+}
+
+// RelayProbeResult is what came back from the network for a probe
+type RelayProbeResult struct {
+	RelayPeers    int
+	FirstRelayMs  sql.NullInt64
+	MedianRelayMs sql.NullInt64
+}
+
+// RecordRelayProbe stores a probe as it is sent and returns its id
+func (db *DB) RecordRelayProbe(p RelayProbe) (int64, error) {
+	var id int64
+	err := db.conn.QueryRow(
+		`INSERT INTO relay_probes (tx_hash, variant, fee_rate, vsize, output_type, data_bytes, first_hop, sent_at, observer_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id`,
+		p.TxHash, p.Variant, p.FeeRate, p.VSize, p.OutputType, p.DataBytes, p.FirstHop, p.SentAt, db.observerID,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert relay probe: %w", err)
+	}
+	return id, nil
+}
+
+// MeasureRelayProbe counts the peers other than the first hop that
+// announced a probe to us and how long after sending, and stores the result
+func (db *DB) MeasureRelayProbe(id int64) (RelayProbeResult, error) {
+	var r RelayProbeResult
+	err := db.conn.QueryRow(
+		`WITH relays AS (
+		     SELECT EXTRACT(EPOCH FROM (po.first_seen_at - p.sent_at)) * 1000 AS ms
+		     FROM relay_probes p
+		     JOIN transaction_peer_observations po
+		       ON po.tx_hash = p.tx_hash AND po.observer_id = p.observer_id AND po.peer_addr <> p.first_hop
+		     WHERE p.id = $1
+		 )
+		 UPDATE relay_probes SET
+		     measured_at = NOW(),
+		     relay_peers = (SELECT COUNT(*) FROM relays),
+		     first_relay_ms = (SELECT MIN(ms) FROM relays)::INT,
+		     median_relay_ms = (SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY ms) FROM relays)::INT
+		 WHERE id = $1
+		 RETURNING relay_peers, first_relay_ms, median_relay_ms`,
+		id,
+	).Scan(&r.RelayPeers, &r.FirstRelayMs, &r.MedianRelayMs)
+	if err != nil {
+		return r, fmt.Errorf("measure relay probe: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Reasons a transaction was tagged for a project
+const (
+	ProjectMatchAddress  = "address"
+	ProjectMatchTemplate = "script_template"
+	ProjectMatchFeeRate  = "fee_rate"
+)
+
+// Project is a named study recorded alongside everything else. Its peer
+// filter is applied when its data is read, through the
+// project_propagation_events view; empty lists allow any peer.
+type Project struct {
+	Name          string
+	Description   string
+	Config        interface{}
+	PeerCountries []string
+	PeerRegions   []string
+	PeerASNs      []string
+}
+
+// RegisterProject creates the project, or updates its description and
+// filters if it exists, and returns its id. Tagged transactions are kept.
+func (db *DB) RegisterProject(p Project) (int, error) {
+	configJSON, err := json.Marshal(p.Config)
+	if err != nil {
+		return 0, fmt.Errorf("encoding project config: %w", err)
+	}
+	var id int
+	err = db.conn.QueryRow(
+		`INSERT INTO projects (name, description, config, peer_countries, peer_regions, peer_asns)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (name) DO UPDATE SET
+		     description = EXCLUDED.description,
+		     config = EXCLUDED.config,
+		     peer_countries = EXCLUDED.peer_countries,
+		     peer_regions = EXCLUDED.peer_regions,
+		     peer_asns = EXCLUDED.peer_asns,
+		     updated_at = NOW()
+		 RETURNING id`,
+		p.Name, sql.NullString{String: p.Description, Valid: p.Description != ""}, configJSON,
+		pq.Array(nonNil(p.PeerCountries)), pq.Array(nonNil(p.PeerRegions)), pq.Array(nonNil(p.PeerASNs)),
+	).Scan(&id)
+	return id, err
+}
+
+// nonNil turns a nil list into an empty one, since the filter columns are
+// NOT NULL
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// ProjectID looks up a project by name, returning false if there is none
+func (db *DB) ProjectID(name string) (int, bool, error) {
+	var id int
+	err := db.conn.QueryRow(`SELECT id FROM projects WHERE name = $1`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// TagProjectTransaction adds a transaction to a project, reporting whether
+// it wasn't tagged already
+func (db *DB) TagProjectTransaction(projectID int, txHash []byte, reason string) (bool, error) {
+	res, err := db.conn.Exec(
+		`INSERT INTO project_transactions (project_id, tx_hash, matched_at, reason)
+		 VALUES ($1, $2, NOW(), $3)
+		 ON CONFLICT DO NOTHING`,
+		projectID, txHash, reason,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TxInputAddresses returns the addresses a stored transaction spends from,
+// for inputs whose previous output is stored
+func (db *DB) TxInputAddresses(txHash []byte) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT DISTINCT address FROM transaction_inputs
+		 WHERE tx_hash = $1 AND address IS NOT NULL`,
+		txHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addrs []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, rows.Err()
+}
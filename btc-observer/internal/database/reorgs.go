@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OrphanBlocks applies a reorg to the stored chain. The blocks in hashes left
+// the best chain: they move from blocks to orphaned_blocks and their
+// transactions lose their confirmation, freeing the heights for the blocks
+// that replaced them. Conflicts they settled are reopened. replacedBy holds
+// the new best chain's block at each orphan's height, or nil above the new
+// tip. It returns how many stored blocks were orphaned and how many
+// transactions were un-confirmed.
+func (db *DB) OrphanBlocks(hashes, replacedBy [][]byte, forkHeight int32) (blocks, txs int64, err error) {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	_, err = dbTx.Exec(
+		`INSERT INTO orphaned_blocks (block_hash, height, prev_block_hash, timestamp, bits, tx_count,
+		     first_seen_at, first_peer_addr, replaced_by, fork_height, orphaned_at)
+		 SELECT b.block_hash, b.height, b.prev_block_hash, b.timestamp, b.bits, b.tx_count,
+		     b.first_seen_at, b.first_peer_addr, NULLIF(o.replaced_by, ''::BYTEA), $3, $4
+		 FROM unnest($1::BYTEA[], $2::BYTEA[]) AS o(block_hash, replaced_by)
+		 JOIN blocks b ON b.block_hash = o.block_hash
+		 ON CONFLICT (block_hash) DO UPDATE SET
+		     replaced_by = EXCLUDED.replaced_by,
+		     fork_height = EXCLUDED.fork_height,
+		     orphaned_at = EXCLUDED.orphaned_at`,
+		pq.ByteaArray(hashes), pq.ByteaArray(replacedBy), forkHeight, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("insert orphaned blocks: %w", err)
+	}
+
+	res, err := dbTx.Exec(
+		`UPDATE transactions SET block_hash = NULL, block_height = NULL
+		 WHERE block_hash = ANY($1)`,
+		pq.ByteaArray(hashes),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unconfirm transactions: %w", err)
+	}
+	txs, _ = res.RowsAffected()
+
+	_, err = dbTx.Exec(
+		`UPDATE transaction_observations SET in_block_hash = NULL, confirmed_at = NULL
+		 WHERE in_block_hash = ANY($1)`,
+		pq.ByteaArray(hashes),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unconfirm observations: %w", err)
+	}
+
+	// Conflicts the orphans settled are open again
+	_, err = dbTx.Exec(
+		`UPDATE conflict_outcomes SET outcome = NULL, winner_tx = NULL, winner_fee_delta = NULL,
+		     resolved_block_hash = NULL, resolved_height = NULL, resolved_at = NULL, resolve_ms = NULL
+		 WHERE resolved_block_hash = ANY($1)`,
+		pq.ByteaArray(hashes),
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reopen conflicts: %w", err)
+	}
+
+	res, err = dbTx.Exec(`DELETE FROM blocks WHERE block_hash = ANY($1)`, pq.ByteaArray(hashes))
+	if err != nil {
+		return 0, 0, fmt.Errorf("delete blocks: %w", err)
+	}
+	blocks, _ = res.RowsAffected()
+
+	return blocks, txs, dbTx.Commit()
+}
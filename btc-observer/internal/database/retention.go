@@ -0,0 +1,51 @@
+package database
+
+import "time"
+
+// PruneUnconfirmedObservations deletes observations of transactions first
+// seen before the cutoff that never confirmed, returning how many were removed
+func (db *DB) PruneUnconfirmedObservations(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(
+		`DELETE FROM transaction_observations
+		 WHERE in_block_hash IS NULL AND first_seen_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PrunePeerObservations deletes per-peer first sightings made before the
+// cutoff and returns how many were removed
+func (db *DB) PrunePeerObservations(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM transaction_peer_observations WHERE first_seen_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PruneScripts clears the raw scriptSig and scriptPubKey of transactions in
+// blocks mined before the cutoff, keeping the rows and their addresses. It
+// returns how many inputs and outputs were cleared.
+func (db *DB) PruneScripts(before time.Time) (int64, error) {
+	var total int64
+	for _, q := range []string{
+		`UPDATE transaction_inputs i SET script_sig = NULL
+		 FROM transactions t JOIN blocks b ON b.block_hash = t.block_hash
+		 WHERE i.tx_hash = t.tx_hash AND b.timestamp < $1 AND i.script_sig IS NOT NULL`,
+		`UPDATE transaction_outputs o SET script_pubkey = NULL
+		 FROM transactions t JOIN blocks b ON b.block_hash = t.block_hash
+		 WHERE o.tx_hash = t.tx_hash AND b.timestamp < $1 AND o.script_pubkey IS NOT NULL`,
+	} {
+		res, err := db.conn.Exec(q, before)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RollupAllRegions is the region label of the rollup row covering every region
+const RollupAllRegions = "all"
+
+// RollupBucket recomputes the observation rollup rows for one time bucket
+// [start, end). Transactions are attributed to the region of the peer that
+// announced them first; propagation delays and peer counts to the region of
+// each announcing peer. A row with region "all" covers every region.
+func (db *DB) RollupBucket(granularity string, start, end time.Time) error {
+	_, err := db.conn.Exec(
+		`WITH obs AS (
+		     SELECT COALESCE(pc.region, 'unknown') AS region, t.fee_satoshis, t.fee_rate, t.weight
+		     FROM transaction_observations o
+		     LEFT JOIN peer_connections pc ON pc.peer_addr = o.first_peer_addr
+		     LEFT JOIN transactions t ON t.tx_hash = o.tx_hash
+		     WHERE o.first_seen_at >= $2 AND o.first_seen_at < $3
+		 ),
+		 tx_stats AS (
+		     SELECT CASE WHEN GROUPING(region) = 1 THEN 'all' ELSE region END AS region,
+		         COUNT(*) AS tx_count,
+		         COALESCE(SUM(fee_satoshis), 0) AS total_fees,
+		         percentile_cont(0.5) WITHIN GROUP (ORDER BY COALESCE(fee_rate, fee_satoshis * 4.0 / weight))
+		             FILTER (WHERE fee_satoshis IS NOT NULL AND weight > 0) AS median_fee_rate
+		     FROM obs
+		     GROUP BY ROLLUP (region)
+		 ),
+		 prop AS (
+		     SELECT COALESCE(pc.region, 'unknown') AS region, pe.peer_addr, pe.delay_from_first_ms
+		     FROM propagation_events pe
+		     LEFT JOIN peer_connections pc ON pc.peer_addr = pe.peer_addr
+		     WHERE pe.announcement_time >= $2 AND pe.announcement_time < $3
+		 ),
+		 prop_stats AS (
+		     SELECT CASE WHEN GROUPING(region) = 1 THEN 'all' ELSE region END AS region,
+		         COUNT(*) AS announcement_count,
+		         percentile_cont(0.5) WITHIN GROUP (ORDER BY delay_from_first_ms) AS median_propagation_ms,
+		         percentile_cont(0.9) WITHIN GROUP (ORDER BY delay_from_first_ms) AS p90_propagation_ms,
+		         COUNT(DISTINCT peer_addr) AS peer_count
+		     FROM prop
+		     GROUP BY ROLLUP (region)
+		 )
+		 INSERT INTO observation_rollups (granularity, bucket_start, region, tx_count, total_fees,
+		     median_fee_rate, announcement_count, median_propagation_ms, p90_propagation_ms, peer_count, updated_at)
+		 SELECT $1, $2, region,
+		     COALESCE(t.tx_count, 0), COALESCE(t.total_fees, 0), t.median_fee_rate,
+		     COALESCE(p.announcement_count, 0), p.median_propagation_ms, p.p90_propagation_ms,
+		     COALESCE(p.peer_count, 0), NOW()
+		 FROM tx_stats t
+		 FULL OUTER JOIN prop_stats p USING (region)
+		 ON CONFLICT (granularity, bucket_start, region) DO UPDATE SET
+		     tx_count = EXCLUDED.tx_count,
+		     total_fees = EXCLUDED.total_fees,
+		     median_fee_rate = EXCLUDED.median_fee_rate,
+		     announcement_count = EXCLUDED.announcement_count,
+		     median_propagation_ms = EXCLUDED.median_propagation_ms,
+		     p90_propagation_ms = EXCLUDED.p90_propagation_ms,
+		     peer_count = EXCLUDED.peer_count,
+		     updated_at = NOW()`,
+		granularity, start, end,
+	)
+	if err != nil {
+		return fmt.Errorf("rollup %s bucket %s: %w", granularity, start.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// LatestRollupBucket returns the start of the newest stored bucket of a
+// granularity, or false if there is none
+func (db *DB) LatestRollupBucket(granularity string) (time.Time, bool, error) {
+	var latest sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT MAX(bucket_start) FROM observation_rollups WHERE granularity = $1`,
+		granularity,
+	).Scan(&latest)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return latest.Time, latest.Valid, nil
+}
+
+// PrunePropagationEvents deletes raw propagation events announced before the
+// cutoff and returns how many were removed
+func (db *DB) PrunePropagationEvents(before time.Time) (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM propagation_events WHERE announcement_time < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,18 @@
+package database
+
+// RecordScriptTemplateMatch tags a transaction with a matched script template.
+// It reports whether the tag is new, so a tx seen both in the mempool and in a
+// block is only counted once.
+func (db *DB) RecordScriptTemplateMatch(txHash []byte, template, location string, index int) (bool, error) {
+	res, err := db.conn.Exec(
+		`INSERT INTO script_template_matches (tx_hash, template, location, io_index, matched_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 ON CONFLICT DO NOTHING`,
+		txHash, template, location, index,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TransportV1 is the unencrypted P2P transport; BIP324 peers will be "v2"
+const TransportV1 = "v1"
+
+// TCPStats is the kernel's view of a peer's TCP connection
+type TCPStats struct {
+	RTTMs       float64
+	RTTVarMs    float64
+	MinRTTMs    float64
+	MSS         int
+	PMTU        int
+	Retransmits int
+}
+
+// tcpArgs returns the stats as query arguments, all NULL when tcp is nil
+func tcpArgs(tcp *TCPStats) []any {
+	valid := tcp != nil
+	if tcp == nil {
+		tcp = &TCPStats{}
+	}
+	return []any{
+		sql.NullFloat64{Float64: tcp.RTTMs, Valid: valid},
+		sql.NullFloat64{Float64: tcp.RTTVarMs, Valid: valid},
+		sql.NullFloat64{Float64: tcp.MinRTTMs, Valid: valid},
+		sql.NullInt32{Int32: int32(tcp.MSS), Valid: valid},
+		sql.NullInt32{Int32: int32(tcp.PMTU), Valid: valid},
+		sql.NullInt32{Int32: int32(tcp.Retransmits), Valid: valid},
+	}
+}
+
+// StartPeerSession records a new connection to a peer and returns its id.
+// tcp is nil where the kernel exposes no TCP statistics, or the connection
+// runs through a proxy.
+func (db *DB) StartPeerSession(peerAddr, region, transport string, tcp *TCPStats) (int64, error) {
+	args := append([]any{peerAddr, region, transport}, tcpArgs(tcp)...)
+	var id int64
+	err := db.conn.QueryRow(
+		`INSERT INTO peer_sessions (peer_addr, region, transport, started_at,
+		     tcp_rtt_ms, tcp_rttvar_ms, tcp_min_rtt_ms, mss, pmtu, retransmits)
+		 VALUES ($1, $2, $3, NOW(), $4, $5, $6, $7, $8, $9)
+		 RETURNING id`,
+		args...,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert session: %w", err)
+	}
+	return id, nil
+}
+
+// UpdatePeerSession adds a ping round trip to a session, with the TCP
+// statistics sampled alongside it
+func (db *DB) UpdatePeerSession(id int64, pingMs int, tcp *TCPStats) error {
+	args := append([]any{id, pingMs}, tcpArgs(tcp)...)
+	_, err := db.conn.Exec(
+		`UPDATE peer_sessions SET
+		     ping_count = ping_count + 1,
+		     avg_ping_ms = COALESCE((avg_ping_ms * ping_count + $2::INT) / (ping_count + 1), $2::INT),
+		     min_ping_ms = LEAST(min_ping_ms, $2::INT),
+		     tcp_rtt_ms = COALESCE($3, tcp_rtt_ms),
+		     tcp_rttvar_ms = COALESCE($4, tcp_rttvar_ms),
+		     tcp_min_rtt_ms = COALESCE($5, tcp_min_rtt_ms),
+		     mss = COALESCE($6, mss),
+		     pmtu = COALESCE($7, pmtu),
+		     retransmits = COALESCE($8, retransmits)
+		 WHERE id = $1`,
+		args...,
+	)
+	return err
+}
+
+// SessionFeatures is what a peer negotiated in a session
+type SessionFeatures struct {
+	SendHeaders  bool   // the peer asked for headers announcements (BIP130)
+	CmpctVersion uint64 // highest compact block version the peer offered, 0 if none (BIP152)
+	WTxIDRelay   bool   // both sides sent wtxidrelay (BIP339)
+	AddrV2       bool   // the peer asked for addrv2 gossip (BIP155)
+}
+
+// RecordSessionFeatures stores a session's negotiated features
+func (db *DB) RecordSessionFeatures(id int64, f SessionFeatures) error {
+	_, err := db.conn.Exec(
+		`UPDATE peer_sessions SET
+		     sendheaders = $2,
+		     cmpct_version = $3,
+		     wtxidrelay = $4,
+		     addrv2 = $5
+		 WHERE id = $1`,
+		id, f.SendHeaders, int64(f.CmpctVersion), f.WTxIDRelay, f.AddrV2,
+	)
+	return err
+}
+
+// EndPeerSession closes a session with its final TCP statistics
+func (db *DB) EndPeerSession(id int64, tcp *TCPStats) error {
+	args := append([]any{id}, tcpArgs(tcp)...)
+	_, err := db.conn.Exec(
+		`UPDATE peer_sessions SET
+		     ended_at = NOW(),
+		     tcp_rtt_ms = COALESCE($2, tcp_rtt_ms),
+		     tcp_rttvar_ms = COALESCE($3, tcp_rttvar_ms),
+		     tcp_min_rtt_ms = COALESCE($4, tcp_min_rtt_ms),
+		     mss = COALESCE($5, mss),
+		     pmtu = COALESCE($6, pmtu),
+		     retransmits = COALESCE($7, retransmits)
+		 WHERE id = $1`,
+		args...,
+	)
+	return err
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// SpendTypeCount is how many of a transaction's inputs spend one way
+type SpendTypeCount struct {
+	SpendType string
+	Multisig  string // "m-of-n" when the revealed script is a multisig, else ""
+	Inputs    int
+}
+
+// RecordSpendTypes stores a transaction's input counts by spend type. It
+// reports whether they are new, so a tx seen both in the mempool and in a
+// block is only counted once.
+func (db *DB) RecordSpendTypes(txHash []byte, counts []SpendTypeCount) (bool, error) {
+	types := make([]string, len(counts))
+	multisigs := make([]string, len(counts))
+	inputs := make([]int64, len(counts))
+	for i, c := range counts {
+		types[i], multisigs[i], inputs[i] = c.SpendType, c.Multisig, int64(c.Inputs)
+	}
+	res, err := db.conn.Exec(
+		`INSERT INTO input_spend_types (tx_hash, spend_type, multisig, inputs, recorded_at)
+		 SELECT $1, t.spend_type, t.multisig, t.inputs, NOW()
+		 FROM unnest($2::TEXT[], $3::TEXT[], $4::INT[]) AS t(spend_type, multisig, inputs)
+		 ON CONFLICT DO NOTHING`,
+		txHash, pq.Array(types), pq.Array(multisigs), pq.Array(inputs),
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert spend types: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
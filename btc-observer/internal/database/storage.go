@@ -0,0 +1,87 @@
+package database
+
+import (
+	"time"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Storage is what the observer's peer pipeline records to and reads back.
+// *DB implements it on Postgres and *Memory in process memory; analytics,
+// rollups and the other subsystems that query with SQL need a *DB.
+type Storage interface {
+	// Discards reports whether writes are dropped
+	Discards() bool
+
+	// Peers
+	RecordPeerConnection(peerAddr string, version *protocol.VersionMessage) (*PeerVersionChange, error)
+	UpdatePeerGeoInfo(peerAddr string, geo *PeerGeoInfo) error
+	UpdatePeerLatency(peerAddr string, latencyMs int) error
+	UpdatePeerGeoCheck(peerAddr string, minRTTMs, boundMs int, suspect bool) error
+	IncrementPeerAnnouncements(peerAddr string, txCount, blockCount int) error
+	ResolvePeerIdentity(peerAddr string, id PeerIdentity) (int64, string, error)
+	RecordPeerChainStatus(peerAddr string, s PeerChainStatus) error
+	RecordPeerAddresses(peerAddr string, addrs []protocol.NetAddress) error
+	GossipedAddresses(network string, limit int) ([]string, error)
+	StartPeerSession(peerAddr, region, transport string, tcp *TCPStats) (int64, error)
+	UpdatePeerSession(id int64, pingMs int, tcp *TCPStats) error
+	EndPeerSession(id int64, tcp *TCPStats) error
+	RecordSessionFeatures(id int64, f SessionFeatures) error
+	RecordParseFailure(f ParseFailure) error
+	RecordVersionNonce(nonce uint64, peerAddr string) error
+	VersionNonces(since time.Time) ([]SentNonce, error)
+	PruneVersionNonces(before time.Time) (int64, error)
+	RecordPeerMisbehavior(peerAddr, reason, detail string) error
+	SaveLatencyProfiles(profiles []LatencyProfile) error
+	LatencyProfiles() ([]LatencyProfile, error)
+	SaveRegionSuggestions(suggestions []RegionSuggestion) error
+	SavePeerScores(scores []PeerScore) error
+	PeerScores() ([]PeerScore, error)
+	CachedGeo(ips []string, maxAge time.Duration) (map[string]GeoCacheEntry, error)
+	SaveGeo(entries []GeoCacheEntry) error
+	PeersMissingGeo(after string, limit int) ([]PeerMissingGeo, error)
+	RecordPeerTraffic(at time.Time, traffic []PeerTraffic) error
+	SaveClaimReports(at time.Time, reports []ClaimReport) error
+	ClaimStates() ([]ClaimState, error)
+
+	// Transactions
+	RecordObservation(txHash []byte, peerAddr string) error
+	MergeWTxIDObservations(wtxid, txid []byte) error
+	RecordTransaction(tx *protocol.Transaction) error
+	RecordTransactionFee(tx *protocol.Transaction) (*Fee, error)
+	RecordInputValues(tx *protocol.Transaction, values []int64) (*Fee, error)
+	TxInputAddresses(txHash []byte) ([]string, error)
+	DetectInputConflicts(tx *protocol.Transaction) ([][]byte, error)
+	ConfirmTransactions(blockHash []byte, blockHeight int, blockTimestamp time.Time, txHashes [][]byte) error
+	ResolveConflicts(blockHash []byte, blockHeight int32, txHashes [][]byte) ([]ConflictOutcome, error)
+	RecordFeeAlert(txHash []byte, reason string, fee Fee) (*FeeAlert, bool, error)
+	RecordLowFeeTransaction(txHash []byte, kind string, fee Fee) ([]string, bool, error)
+	RecordLockTime(txHash []byte, kind string, lockValue int64, tipHeight int32) (bool, error)
+	UpdateLockTimes(height int32, mtp time.Time, txHashes [][]byte) ([]LockTimeConfirmation, error)
+	RecordScriptTemplateMatch(txHash []byte, template, location string, index int) (bool, error)
+	RecordSpendTypes(txHash []byte, counts []SpendTypeCount) (bool, error)
+	RegisterProject(p Project) (int, error)
+	TagProjectTransaction(projectID int, txHash []byte, reason string) (bool, error)
+
+	// Blocks
+	RecordBlock(block *protocol.Block, peerAddr string) error
+	RecordBlockOrdering(blockHash []byte, o BlockOrdering) error
+	RecordBlockFees(blockHash []byte, f BlockFees) error
+	RecordBlockAnnouncement(blockHash []byte, peerAddr, via string) error
+	RecordBlockArrival(blockHash []byte, peerAddr, via string) error
+	RecordBlockTxIDs(blockHash []byte, txids [][32]byte) error
+	RecordQuarantinedBlock(block *protocol.Block, peerAddr, reason string) error
+	RecordBlockAnomaly(blockHash []byte, height int32, kind, detail string) error
+	GetBlockHeader(blockHash []byte) (*StoredHeader, error)
+	GetBlockHeaderAtHeight(height int32) (*StoredHeader, error)
+	AncestorTimestamps(blockHash []byte, n int) ([]time.Time, error)
+	AncestorHashAtHeight(blockHash []byte, height int32) ([]byte, error)
+	ChainLocator() ([]ChainPoint, error)
+	ChainHashes(from, to int32) (map[int32][32]byte, error)
+	RecentChain(n int) ([]ChainHeader, error)
+	OrphanBlocks(hashes, replacedBy [][]byte, forkHeight int32) (blocks, txs int64, err error)
+	UpdateVersionBitsPeriod(periodStart int32, periodLength int32) ([]BitSignaling, error)
+	RecordVersionBitsWindow(tipHeight int32, window int32) ([]BitSignaling, error)
+}
+
+var _ Storage = (*DB)(nil)
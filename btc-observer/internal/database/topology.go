@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TopologyPeer is a peer seen since the start of a topology window
+type TopologyPeer struct {
+	Addr        string
+	UserAgent   string
+	Region      string
+	CountryCode string
+	ASN         string
+	Latitude    float64
+	Longitude   float64
+	LatencyMs   int
+	LastSeenAt  time.Time
+}
+
+// GossipEdge counts txs that To announced shortly after From, with no other
+// peer announcing in between
+type GossipEdge struct {
+	From  string
+	To    string
+	Count int
+}
+
+// TopologyPeers returns peers last seen at or after since. A non-zero
+// projectID keeps only peers the project's peer filter allows.
+func (db *DB) TopologyPeers(since time.Time, projectID int) ([]TopologyPeer, error) {
+	rows, err := db.conn.Query(
+		`SELECT peer_addr, COALESCE(user_agent, ''), COALESCE(region, ''), COALESCE(country_code, ''),
+		     COALESCE(asn, ''), latitude, longitude, avg_latency_ms, last_seen_at
+		 FROM peer_connections pc
+		 WHERE last_seen_at >= $1
+		   AND ($2 = 0 OR EXISTS (
+		       SELECT 1 FROM projects p
+		       WHERE p.id = $2
+		         AND (cardinality(p.peer_countries) = 0 OR pc.country_code = ANY(p.peer_countries))
+		         AND (cardinality(p.peer_regions) = 0 OR pc.region = ANY(p.peer_regions))
+		         AND (cardinality(p.peer_asns) = 0 OR split_part(upper(pc.asn), ' ', 1) = ANY(p.peer_asns))
+		   ))
+		 ORDER BY peer_addr`,
+		since, projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []TopologyPeer
+	for rows.Next() {
+		var p TopologyPeer
+		var lat, lon sql.NullFloat64
+		var latency sql.NullInt64
+		if err := rows.Scan(&p.Addr, &p.UserAgent, &p.Region, &p.CountryCode, &p.ASN,
+			&lat, &lon, &latency, &p.LastSeenAt); err != nil {
+			return nil, err
+		}
+		p.Latitude, p.Longitude, p.LatencyMs = lat.Float64, lon.Float64, int(latency.Int64)
+		peers = append(peers, p)
+	}
+	return peers, rows.Err()
+}
+
+// GossipEdges infers relay links from announcement order: for each tx
+// announced since the window start, every announcement that followed the
+// previous one within maxGapMs counts towards an edge from the previous
+// announcer. Edges seen fewer than minCount times are dropped. A non-zero
+// projectID only considers the project's transactions and peers.
+func (db *DB) GossipEdges(since time.Time, maxGapMs, minCount, projectID int) ([]GossipEdge, error) {
+	events := "propagation_events"
+	args := []interface{}{since, maxGapMs, minCount}
+	if projectID != 0 {
+		events = "(SELECT * FROM project_propagation_events WHERE project_id = $4)"
+		args = append(args, projectID)
+	}
+	rows, err := db.conn.Query(
+		`SELECT prev_peer, peer_addr, COUNT(*)
+		 FROM (
+		     SELECT peer_addr,
+		         LAG(peer_addr) OVER w AS prev_peer,
+		         delay_from_first_ms - LAG(delay_from_first_ms) OVER w AS gap_ms
+		     FROM `+events+` e
+		     WHERE announcement_time >= $1
+		     WINDOW w AS (PARTITION BY tx_hash ORDER BY announcement_time, id)
+		 ) s
+		 WHERE prev_peer IS NOT NULL AND prev_peer <> peer_addr AND gap_ms <= $2
+		 GROUP BY prev_peer, peer_addr
+		 HAVING COUNT(*) >= $3
+		 ORDER BY COUNT(*) DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []GossipEdge
+	for rows.Next() {
+		var e GossipEdge
+		if err := rows.Scan(&e.From, &e.To, &e.Count); err != nil {
+			return nil, err
+		}
+		edges = append(edges, e)
+	}
+	return edges, rows.Err()
+}
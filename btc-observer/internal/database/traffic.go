@@ -0,0 +1,46 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeerTraffic is one peer's traffic of one message type since it was last
+// recorded
+type PeerTraffic struct {
+	PeerAddr         string
+	Region           string
+	Command          string
+	BytesSent        int64
+	BytesReceived    int64
+	MessagesSent     int64
+	MessagesReceived int64
+}
+
+// RecordPeerTraffic adds traffic to the peers' totals for the hour of at
+func (db *DB) RecordPeerTraffic(at time.Time, traffic []PeerTraffic) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+	hour := at.UTC().Truncate(time.Hour)
+	for _, t := range traffic {
+		_, err := dbTx.Exec(
+			`INSERT INTO peer_traffic (hour, peer_addr, command, observer_id, region, bytes_sent, bytes_received, messages_sent, messages_received)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			 ON CONFLICT (hour, peer_addr, command, observer_id) DO UPDATE SET
+			     region = EXCLUDED.region,
+			     bytes_sent = peer_traffic.bytes_sent + EXCLUDED.bytes_sent,
+			     bytes_received = peer_traffic.bytes_received + EXCLUDED.bytes_received,
+			     messages_sent = peer_traffic.messages_sent + EXCLUDED.messages_sent,
+			     messages_received = peer_traffic.messages_received + EXCLUDED.messages_received`,
+			hour, t.PeerAddr, t.Command, db.observerID, t.Region,
+			t.BytesSent, t.BytesReceived, t.MessagesSent, t.MessagesReceived,
+		)
+		if err != nil {
+			return fmt.Errorf("upsert peer traffic: %w", err)
+		}
+	}
+	return dbTx.Commit()
+}
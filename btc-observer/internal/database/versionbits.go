@@ -0,0 +1,102 @@
+package database
+
+import "fmt"
+
+// BitSignaling holds signaling counts for one version bit within a period
+type BitSignaling struct {
+	Bit       int
+	Signaling int
+	Total     int
+}
+
+// UpdateVersionBitsPeriod recomputes per-bit signaling counts for the period
+// starting at periodStart from the stored blocks, and returns the bits that
+// have any signaling blocks.
+func (db *DB) UpdateVersionBitsPeriod(periodStart int32, periodLength int32) ([]BitSignaling, error) {
+	// 3758096384 = 0xE0000000 (top-bits mask), 536870912 = 0x20000000 (BIP9 marker)
+	_, err := db.conn.Exec(
+		`INSERT INTO version_bits_signaling (period_start, bit, signaling_blocks, total_blocks, updated_at)
+		 SELECT $1, bit,
+		     COUNT(*) FILTER (
+		         WHERE (b.version::BIGINT & 3758096384) = 536870912
+		           AND ((b.version::BIGINT >> bit) & 1) = 1
+		     ),
+		     COUNT(*),
+		     NOW()
+		 FROM blocks b, generate_series(0, 28) AS bit
+		 WHERE b.height >= $1 AND b.height < $1 + $2 AND b.version IS NOT NULL
+		 GROUP BY bit
+		 ON CONFLICT (period_start, bit) DO UPDATE SET
+		     signaling_blocks = EXCLUDED.signaling_blocks,
+		     total_blocks = EXCLUDED.total_blocks,
+		     updated_at = NOW()`,
+		periodStart, periodLength,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update signaling: %w", err)
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT bit, signaling_blocks, total_blocks FROM version_bits_signaling
+		 WHERE period_start = $1 AND signaling_blocks > 0
+		 ORDER BY bit`,
+		periodStart,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query signaling: %w", err)
+	}
+	defer rows.Close()
+
+	var result []BitSignaling
+	for rows.Next() {
+		var s BitSignaling
+		if err := rows.Scan(&s.Bit, &s.Signaling, &s.Total); err != nil {
+			return nil, fmt.Errorf("scan signaling: %w", err)
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// RecordVersionBitsWindow counts per-bit signaling over the window blocks
+// ending at tipHeight, regardless of period boundaries, and stores the bits
+// that have any signaling blocks
+func (db *DB) RecordVersionBitsWindow(tipHeight int32, window int32) ([]BitSignaling, error) {
+	rows, err := db.conn.Query(
+		`INSERT INTO version_bits_windows (tip_height, bit, signaling_blocks, total_blocks, recorded_at)
+		 SELECT $1, bit,
+		     COUNT(*) FILTER (
+		         WHERE (b.version::BIGINT & 3758096384) = 536870912
+		           AND ((b.version::BIGINT >> bit) & 1) = 1
+		     ),
+		     COUNT(*),
+		     NOW()
+		 FROM blocks b, generate_series(0, 28) AS bit
+		 WHERE b.height > $1 - $2 AND b.height <= $1 AND b.version IS NOT NULL
+		 GROUP BY bit
+		 HAVING COUNT(*) FILTER (
+		     WHERE (b.version::BIGINT & 3758096384) = 536870912
+		       AND ((b.version::BIGINT >> bit) & 1) = 1
+		 ) > 0
+		 ON CONFLICT (tip_height, bit) DO UPDATE SET
+		     signaling_blocks = EXCLUDED.signaling_blocks,
+		     total_blocks = EXCLUDED.total_blocks,
+		     recorded_at = NOW()
+		 RETURNING bit, signaling_blocks, total_blocks`,
+		tipHeight, window,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("record signaling window: %w", err)
+	}
+	defer rows.Close()
+
+	var result []BitSignaling
+	for rows.Next() {
+		var s BitSignaling
+		if err := rows.Scan(&s.Bit, &s.Signaling, &s.Total); err != nil {
+			return nil, fmt.Errorf("scan signaling: %w", err)
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Peer version change kinds, the most significant difference first
+const (
+	VersionUpgrade   = "upgrade"   // higher protocol version
+	VersionDowngrade = "downgrade" // lower protocol version
+	ServicesReduced  = "services_reduced"
+	ServicesAdded    = "services_added"
+	UserAgentChanged = "user_agent" // same version and services, new software
+)
+
+// PeerVersionChange is a known peer reconnecting with a different version
+// message than last time
+type PeerVersionChange struct {
+	Kind         string
+	OldVersion   int32
+	NewVersion   int32
+	OldServices  uint64
+	NewServices  uint64
+	OldUserAgent string
+	NewUserAgent string
+}
+
+// versionChange compares a peer's previous version message fields with its
+// new one, returning nil when nothing changed
+func versionChange(oldVersion int32, oldServices uint64, oldUserAgent string, v *protocol.VersionMessage) *PeerVersionChange {
+	c := &PeerVersionChange{
+		OldVersion: oldVersion, NewVersion: v.Version,
+		OldServices: oldServices, NewServices: v.Services,
+		OldUserAgent: oldUserAgent, NewUserAgent: v.UserAgent,
+	}
+	switch {
+	case v.Version > oldVersion:
+		c.Kind = VersionUpgrade
+	case v.Version < oldVersion:
+		c.Kind = VersionDowngrade
+	case oldServices&^v.Services != 0:
+		c.Kind = ServicesReduced
+	case v.Services != oldServices:
+		c.Kind = ServicesAdded
+	case v.UserAgent != oldUserAgent:
+		c.Kind = UserAgentChanged
+	default:
+		return nil
+	}
+	return c
+}
+
+// recordVersionChange compares the stored version of a peer, locked by the
+// caller's transaction, with its new one and logs any change
+func recordVersionChange(dbTx *sql.Tx, peerAddr string, v *protocol.VersionMessage) (*PeerVersionChange, error) {
+	var version sql.NullInt32
+	var services sql.NullInt64
+	var userAgent sql.NullString
+	err := dbTx.QueryRow(
+		`SELECT protocol_version, services, user_agent FROM peer_connections WHERE peer_addr = $1 FOR UPDATE`,
+		peerAddr,
+	).Scan(&version, &services, &userAgent)
+	if err == sql.ErrNoRows || (err == nil && !version.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := versionChange(version.Int32, uint64(services.Int64), userAgent.String, v)
+	if change == nil {
+		return nil, nil
+	}
+	_, err = dbTx.Exec(
+		`INSERT INTO peer_version_changes (peer_addr, kind, old_version, new_version, old_services, new_services, old_user_agent, new_user_agent, observed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())`,
+		peerAddr, change.Kind, change.OldVersion, change.NewVersion,
+		int64(change.OldServices), int64(change.NewServices), change.OldUserAgent, change.NewUserAgent,
+	)
+	return change, err
+}
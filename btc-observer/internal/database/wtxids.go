@@ -0,0 +1,72 @@
+package database
+
+import "fmt"
+
+// MergeWTxIDObservations moves observations recorded under a wtxid, from
+// peers that announced the transaction by wtxid (BIP339) before it arrived,
+// to its txid. The earliest sighting wins first_seen_at, each peer keeps its
+// earliest announcement, and the propagation delays are recomputed.
+func (db *DB) MergeWTxIDObservations(wtxid, txid []byte) error {
+	dbTx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	_, err = dbTx.Exec(
+		`INSERT INTO transaction_observations (tx_hash, first_seen_at, first_peer_addr, peer_count, experiment_run_id)
+		 SELECT $2, first_seen_at, first_peer_addr, peer_count, experiment_run_id
+		 FROM transaction_observations WHERE tx_hash = $1
+		 ON CONFLICT (tx_hash) DO UPDATE SET
+		     peer_count = transaction_observations.peer_count + EXCLUDED.peer_count,
+		     first_peer_addr = CASE WHEN EXCLUDED.first_seen_at < transaction_observations.first_seen_at
+		         THEN EXCLUDED.first_peer_addr ELSE transaction_observations.first_peer_addr END,
+		     first_seen_at = LEAST(transaction_observations.first_seen_at, EXCLUDED.first_seen_at)`,
+		wtxid, txid,
+	)
+	if err != nil {
+		return fmt.Errorf("merge observation: %w", err)
+	}
+	if _, err := dbTx.Exec(`DELETE FROM transaction_observations WHERE tx_hash = $1`, wtxid); err != nil {
+		return fmt.Errorf("delete wtxid observation: %w", err)
+	}
+
+	// A peer that announced both the wtxid and the txid counts once, from
+	// its earlier announcement
+	_, err = dbTx.Exec(
+		`INSERT INTO transaction_peer_observations (tx_hash, peer_addr, observer_id, first_seen_at)
+		 SELECT $2, peer_addr, observer_id, first_seen_at
+		 FROM transaction_peer_observations WHERE tx_hash = $1
+		 ON CONFLICT (tx_hash, peer_addr, observer_id) DO UPDATE SET
+		     first_seen_at = LEAST(transaction_peer_observations.first_seen_at, EXCLUDED.first_seen_at)`,
+		wtxid, txid,
+	)
+	if err != nil {
+		return fmt.Errorf("merge peer observations: %w", err)
+	}
+	if _, err := dbTx.Exec(`DELETE FROM transaction_peer_observations WHERE tx_hash = $1`, wtxid); err != nil {
+		return fmt.Errorf("delete wtxid peer observations: %w", err)
+	}
+	_, err = dbTx.Exec(
+		`UPDATE transaction_observations o SET peer_count = p.n
+		 FROM (SELECT COUNT(*) AS n FROM transaction_peer_observations WHERE tx_hash = $1) p
+		 WHERE o.tx_hash = $1 AND p.n > 0`,
+		txid,
+	)
+	if err != nil {
+		return fmt.Errorf("recount peers: %w", err)
+	}
+
+	_, err = dbTx.Exec(
+		`UPDATE propagation_events pe
+		 SET tx_hash = $2,
+		     delay_from_first_ms = (EXTRACT(EPOCH FROM (pe.announcement_time - o.first_seen_at)) * 1000)::INT
+		 FROM transaction_observations o
+		 WHERE o.tx_hash = $2 AND pe.tx_hash IN ($1, $2)`,
+		wtxid, txid,
+	)
+	if err != nil {
+		return fmt.Errorf("move propagation events: %w", err)
+	}
+	return dbTx.Commit()
+}
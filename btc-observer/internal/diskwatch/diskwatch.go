@@ -0,0 +1,144 @@
+package diskwatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	defaultInterval = 60 * time.Second
+	// recentWriteGuard protects files still being actively written from deletion
+	recentWriteGuard = time.Minute
+)
+
+// ownedPatterns match the files the observer writes itself: per-peer
+// capture logs (named after the peer's address and port) with their rotated
+// backups, and message store segments. Only these are dropped from a
+// watched directory.
+var ownedPatterns = []string{"*_[0-9]*.log", "*_[0-9]*.log.[0-9]*", "[0-9]*.msgs.gz"}
+
+// Config configures the disk-space watchdog. A path is a directory, whose
+// observer-written files may be dropped, or a glob such as
+// "/var/spool/btc-observer/*.pcap", whose matches may be.
+type Config struct {
+	Paths           []string `json:"paths"`
+	WarnFreeMB      uint64   `json:"warn_free_mb"`
+	MinFreeMB       uint64   `json:"min_free_mb"`
+	IntervalSeconds int      `json:"interval_seconds"`
+}
+
+// Start periodically checks free space on each watched directory. Below
+// WarnFreeMB it logs a warning; below MinFreeMB it deletes the oldest
+// droppable files in that directory until free space recovers.
+func Start(ctx context.Context, cfg Config) {
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, path := range cfg.Paths {
+				check(path, cfg)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// watched splits a configured path into the directory to check and the
+// patterns of the files that may be dropped from it
+func watched(path string) (dir string, patterns []string) {
+	if strings.ContainsAny(filepath.Base(path), "*?[") {
+		return filepath.Dir(path), []string{filepath.Base(path)}
+	}
+	return path, ownedPatterns
+}
+
+func check(path string, cfg Config) {
+	path, patterns := watched(path)
+	free, err := freeBytes(path)
+	if err != nil {
+		logger.Log.Warn().Err(err).Str("path", path).Msg("Disk space check failed")
+		return
+	}
+	metrics.DiskFreeBytes.WithLabelValues(path).Set(float64(free))
+
+	minFree := cfg.MinFreeMB * 1024 * 1024
+	warnFree := cfg.WarnFreeMB * 1024 * 1024
+
+	if free < minFree {
+		logger.Log.Error().
+			Str("path", path).
+			Uint64("free_mb", free/1024/1024).
+			Msg("Disk space critically low, dropping oldest files")
+		dropOldest(path, patterns, minFree)
+	} else if free < warnFree {
+		logger.Log.Warn().
+			Str("path", path).
+			Uint64("free_mb", free/1024/1024).
+			Msg("Disk space low")
+	}
+}
+
+// dropOldest deletes the files in dir matching patterns, oldest first, until
+// free space reaches target
+func dropOldest(dir string, patterns []string, target uint64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Log.Warn().Err(err).Str("path", dir).Msg("Failed to list directory")
+		return
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	cutoff := time.Now().Add(-recentWriteGuard)
+	for _, e := range entries {
+		if e.IsDir() || !matchesAny(e.Name(), patterns) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		files = append(files, file{filepath.Join(dir, e.Name()), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	for _, f := range files {
+		if free, err := freeBytes(dir); err == nil && free >= target {
+			return
+		}
+		if err := os.Remove(f.path); err != nil {
+			logger.Log.Warn().Err(err).Str("file", f.path).Msg("Failed to drop file")
+			continue
+		}
+		metrics.DiskFilesDropped.Inc()
+		logger.Log.Warn().Str("file", f.path).Msg("Dropped file to free disk space")
+	}
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
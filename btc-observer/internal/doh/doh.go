@@ -0,0 +1,279 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	defaultURL     = "https://cloudflare-dns.com/dns-query"
+	defaultTimeout = 10 * time.Second
+	dnsMessageType = "application/dns-message"
+	maxResponse    = 64 * 1024
+)
+
+// DNS record types
+const (
+	typeA    = 1
+	typeAAAA = 28
+)
+
+// Config selects the DNS-over-HTTPS resolver
+type Config struct {
+	// URL is the resolver's RFC 8484 endpoint (default Cloudflare)
+	URL string `json:"url"`
+
+	// Bootstrap are IPs the resolver is reached at, so finding it doesn't
+	// need a plain DNS lookup. Unneeded when URL already names an IP.
+	Bootstrap []string `json:"bootstrap"`
+
+	// TimeoutSeconds bounds each query (default 10)
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// Resolver resolves hostnames over HTTPS so the queries can't be seen or
+// filtered by the local network
+type Resolver struct {
+	url    string
+	client *http.Client
+}
+
+// New builds a resolver. Cloudflare's addresses are used as bootstrap for
+// the default URL.
+func New(cfg Config) (*Resolver, error) {
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+		if len(cfg.Bootstrap) == 0 {
+			cfg.Bootstrap = []string{"1.1.1.1", "1.0.0.1"}
+		}
+	}
+	timeout := defaultTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("DoH URL %q must be an https URL", cfg.URL)
+	}
+	for _, ip := range cfg.Bootstrap {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("DoH bootstrap %q is not an IP address", ip)
+		}
+	}
+
+	// TLS still verifies the certificate against the URL's hostname; only
+	// the TCP connection goes to a bootstrap address
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(cfg.Bootstrap) > 0 {
+		bootstrap := cfg.Bootstrap
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			var errs []error
+			for _, ip := range bootstrap {
+				conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+				if err == nil {
+					return conn, nil
+				}
+				errs = append(errs, err)
+			}
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	return &Resolver{
+		url:    cfg.URL,
+		client: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of host. An IP literal is
+// returned as is.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	var ips []net.IP
+	var errs []error
+	for _, qtype := range []uint16{typeA, typeAAAA} {
+		found, err := r.query(ctx, host, qtype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ips = append(ips, found...)
+	}
+	if len(ips) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return ips, nil
+}
+
+// DialContext connects to addr, resolving its host over DoH
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// HTTPClient returns a client whose connections resolve hostnames over DoH
+func (r *Resolver) HTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = r.DialContext
+	return &http.Client{Transport: transport}
+}
+
+func (r *Resolver) query(ctx context.Context, host string, qtype uint16) ([]net.IP, error) {
+	msg, err := encodeQuery(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		r.url+"?dns="+base64.RawURLEncoding.EncodeToString(msg), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		metrics.DoHQueries.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("DoH query for %s: %w", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		metrics.DoHQueries.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("DoH query for %s: status %d", host, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		metrics.DoHQueries.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("DoH query for %s: %w", host, err)
+	}
+
+	ips, err := parseResponse(body, qtype)
+	if err != nil {
+		metrics.DoHQueries.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("DoH response for %s: %w", host, err)
+	}
+	metrics.DoHQueries.WithLabelValues("ok").Inc()
+	return ips, nil
+}
+
+// encodeQuery builds a recursive query for one name. The ID is zero, as
+// RFC 8484 recommends for cache friendliness.
+func encodeQuery(host string, qtype uint16) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, 0, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0})
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	binary.Write(buf, binary.BigEndian, qtype)
+	binary.Write(buf, binary.BigEndian, uint16(1)) // IN
+	return buf.Bytes(), nil
+}
+
+// parseResponse returns the addresses of the given type in the answer
+// section. CNAMEs are skipped; the resolver already followed them.
+func parseResponse(msg []byte, qtype uint16) ([]net.IP, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("short message")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		return nil, fmt.Errorf("rcode %d", rcode)
+	}
+	qdcount := binary.BigEndian.Uint16(msg[4:6])
+	ancount := binary.BigEndian.Uint16(msg[6:8])
+
+	off := 12
+	for i := 0; i < int(qdcount); i++ {
+		var err error
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+
+	var ips []net.IP
+	for i := 0; i < int(ancount); i++ {
+		var err error
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, fmt.Errorf("truncated answer")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off : off+2])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, fmt.Errorf("truncated answer")
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+
+		switch {
+		case rtype == qtype && qtype == typeA && rdlen == net.IPv4len,
+			rtype == qtype && qtype == typeAAAA && rdlen == net.IPv6len:
+			ips = append(ips, net.IP(append([]byte(nil), rdata...)))
+		}
+	}
+	return ips, nil
+}
+
+// skipName returns the offset after the (possibly compressed) name at off
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, fmt.Errorf("truncated name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += 1 + n
+		}
+	}
+}
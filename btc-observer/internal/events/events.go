@@ -0,0 +1,244 @@
+package events
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Type identifies the kind of event published on the bus
+type Type string
+
+const (
+	// PressureChanged is published when pipeline backpressure changes level
+	PressureChanged Type = "pressure_changed"
+
+	// PeerConnected is published after a peer completes the handshake
+	PeerConnected Type = "peer_connected"
+
+	// PeerDisconnected is published when a peer's message loop exits
+	PeerDisconnected Type = "peer_disconnected"
+
+	// TxAnnounced is published for every tx hash a peer announces in an inv
+	TxAnnounced Type = "tx_announced"
+
+	// BlockReceived is published when a peer delivers a block that passed the
+	// checkpoint check, before it is stored; sanity-check anomalies don't hold
+	// it back
+	BlockReceived Type = "block_received"
+
+	// TxReceived is published for every transaction body a peer delivers
+	TxReceived Type = "tx_received"
+
+	// DoubleSpendDetected is published when a transaction spends an input
+	// already spent by an unconfirmed transaction
+	DoubleSpendDetected Type = "double_spend_detected"
+
+	// FeeOutlier is published when a transaction pays an extreme fee
+	FeeOutlier Type = "fee_outlier"
+
+	// ChainReorg is published when the best header chain switches branches
+	ChainReorg Type = "chain_reorg"
+
+	// PeerBanned is published when a peer is blacklisted
+	PeerBanned Type = "peer_banned"
+
+	// AddressActivity is published when a transaction pays to or spends from
+	// an address on a project's watchlist
+	AddressActivity Type = "address_activity"
+)
+
+// Hash is a tx or block hash. It encodes to JSON as hex in the usual
+// (byte-reversed) display order.
+type Hash [32]byte
+
+// MarshalJSON encodes the hash as display-order hex
+func (h Hash) MarshalJSON() ([]byte, error) {
+	var buf [2 + 64]byte
+	buf[0], buf[len(buf)-1] = '"', '"'
+	var rev [32]byte
+	for i := range h {
+		rev[i] = h[len(h)-1-i]
+	}
+	hex.Encode(buf[1:], rev[:])
+	return buf[:], nil
+}
+
+// PeerInfo is the data for PeerConnected and PeerDisconnected
+type PeerInfo struct {
+	Peer   string `json:"peer"`
+	Region string `json:"region"`
+}
+
+// TxAnnouncement is the data for TxAnnounced
+type TxAnnouncement struct {
+	Peer   string `json:"peer"`
+	Region string `json:"region"`
+	TxHash Hash   `json:"tx_hash"`
+}
+
+// BlockArrival is the data for BlockReceived
+type BlockArrival struct {
+	Peer    string `json:"peer"`
+	Region  string `json:"region"`
+	Hash    Hash   `json:"hash"`
+	Height  int32  `json:"height"`
+	TxCount int    `json:"tx_count"`
+}
+
+// TxReceipt is the data for TxReceived
+type TxReceipt struct {
+	Peer        string `json:"peer"`
+	Region      string `json:"region"`
+	TxID        Hash   `json:"txid"`
+	Size        int    `json:"size"`
+	Inputs      int    `json:"inputs"`
+	Outputs     int    `json:"outputs"`
+	OutputValue int64  `json:"output_value"` // satoshis
+	Segwit      bool   `json:"segwit"`
+}
+
+// DoubleSpend is the data for DoubleSpendDetected
+type DoubleSpend struct {
+	Peer      string `json:"peer"`
+	Region    string `json:"region"`
+	TxID      Hash   `json:"txid"`
+	Conflicts []Hash `json:"conflicts"` // earlier transactions spending the same inputs
+}
+
+// FeeAlert is the data for FeeOutlier
+type FeeAlert struct {
+	TxID        Hash      `json:"txid"`
+	Reason      string    `json:"reason"`
+	FeeSatoshis int64     `json:"fee_satoshis"`
+	FeeRate     float64   `json:"fee_rate"` // sat/vB
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	FirstPeer   string    `json:"first_peer"`
+	FirstRegion string    `json:"first_region"`
+	PeerCount   int       `json:"peer_count"`   // peers that had announced it
+	RegionCount int       `json:"region_count"` // regions those peers are in
+}
+
+// Reorg is the data for ChainReorg
+type Reorg struct {
+	ForkHeight   int32  `json:"fork_height"`
+	ForkHash     Hash   `json:"fork_hash"`
+	OldTipHeight int32  `json:"old_tip_height"`
+	OldTipHash   Hash   `json:"old_tip_hash"`
+	NewTipHeight int32  `json:"new_tip_height"`
+	NewTipHash   Hash   `json:"new_tip_hash"`
+	Orphaned     []Hash `json:"orphaned"` // blocks that left the best chain, highest first
+}
+
+// PeerBan is the data for PeerBanned
+type PeerBan struct {
+	Peer   string `json:"peer"`
+	Reason string `json:"reason"`
+}
+
+// WatchedAddress is the data for AddressActivity
+type WatchedAddress struct {
+	Project   string   `json:"project"`
+	TxID      Hash     `json:"txid"`
+	Addresses []string `json:"addresses"` // watched addresses the tx pays to or spends from
+}
+
+// Event is a single message on the bus
+type Event struct {
+	Type Type        `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event.
+type Bus struct {
+	sync.RWMutex
+	subs map[int]subscriber
+	next int
+}
+
+type subscriber struct {
+	name string
+	ch   chan Event
+}
+
+// Queue is a subscriber's backlog of undelivered events
+type Queue struct {
+	Name     string `json:"name"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]subscriber)}
+}
+
+// Subscribe returns a channel receiving all future events and a function that
+// unsubscribes and closes it. name identifies the subscriber in Queues.
+func (b *Bus) Subscribe(name string, buffer int) (<-chan Event, func()) {
+	b.Lock()
+	defer b.Unlock()
+	id := b.next
+	b.next++
+	ch := make(chan Event, buffer)
+	b.subs[id] = subscriber{name: name, ch: ch}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.Lock()
+			delete(b.subs, id)
+			b.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers an event to every subscriber without blocking
+func (b *Bus) Publish(t Type, data interface{}) {
+	e := Event{Type: t, Time: time.Now(), Data: data}
+	b.RLock()
+	defer b.RUnlock()
+	for _, sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			metrics.EventsDropped.WithLabelValues(string(t)).Inc()
+		}
+	}
+}
+
+// Queues returns every subscriber's backlog, by name
+func (b *Bus) Queues() []Queue {
+	b.RLock()
+	defer b.RUnlock()
+	queues := make([]Queue, 0, len(b.subs))
+	for _, sub := range b.subs {
+		queues = append(queues, Queue{Name: sub.name, Depth: len(sub.ch), Capacity: cap(sub.ch)})
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues
+}
+
+// Default is the process-wide event bus
+var Default = NewBus()
+
+// Publish publishes an event on the default bus
+func Publish(t Type, data interface{}) {
+	Default.Publish(t, data)
+}
+
+// Subscribe subscribes to the default bus
+func Subscribe(name string, buffer int) (<-chan Event, func()) {
+	return Default.Subscribe(name, buffer)
+}
+
+// Queues returns the default bus's subscriber backlogs
+func Queues() []Queue {
+	return Default.Queues()
+}
@@ -0,0 +1,136 @@
+package experiment
+
+import (
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q): want error", expr)
+		}
+	}
+}
+
+// 2024-04-20 is a Saturday
+func TestScheduleMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		at   string
+		want bool
+	}{
+		// Fields
+		{"30 14 * * *", "2024-04-20 14:30", true},
+		{"30 14 * * *", "2024-04-20 14:31", false},
+		{"30 14 * * *", "2024-04-20 15:30", false},
+		{"0 0 1 6 *", "2024-06-01 00:00", true},
+		{"0 0 1 6 *", "2024-05-01 00:00", false},
+		{"0 0 1,15 * *", "2024-04-15 00:00", true},
+		{"0 0 1,15 * *", "2024-04-16 00:00", false},
+
+		// Steps
+		{"*/15 * * * *", "2024-04-20 10:45", true},
+		{"*/15 * * * *", "2024-04-20 10:50", false},
+		{"5/20 * * * *", "2024-04-20 10:45", true},
+		{"5/20 * * * *", "2024-04-20 10:55", false},
+		{"0-30/10 * * * *", "2024-04-20 10:20", true},
+		{"0-30/10 * * * *", "2024-04-20 10:25", false},
+		{"0-30/10 * * * *", "2024-04-20 10:40", false},
+
+		// Ranges
+		{"0 9-17 * * 1-5", "2024-04-22 10:00", true},
+		{"0 9-17 * * 1-5", "2024-04-22 18:00", false},
+		{"0 9-17 * * 1-5", "2024-04-20 10:00", false},
+
+		// Sunday is 0 or 7
+		{"0 0 * * 0", "2024-04-21 00:00", true},
+		{"0 0 * * 7", "2024-04-21 00:00", true},
+		{"0 0 * * 7", "2024-04-20 00:00", false},
+
+		// Both day fields restricted: either one matches
+		{"0 0 13 * 5", "2024-04-13 00:00", true},
+		{"0 0 13 * 5", "2024-04-19 00:00", true},
+		{"0 0 13 * 5", "2024-04-20 00:00", false},
+
+		// A day field starting with "*" is unrestricted, so both must match
+		{"0 0 */2 * 1", "2024-04-15 00:00", true},
+		{"0 0 */2 * 1", "2024-04-17 00:00", false},
+		{"0 0 */2 * 1", "2024-04-22 00:00", false},
+		{"0 0 13 * */2", "2024-03-13 00:00", false},
+		{"0 0 13 * */2", "2024-06-13 00:00", true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", tt.expr, err)
+		}
+		if got := s.Matches(at(tt.at)); got != tt.want {
+			t.Errorf("%q at %s: got %v, want %v", tt.expr, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleMatchesUTC(t *testing.T) {
+	s, err := ParseSchedule("30 14 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := at("2024-04-20 14:30").In(time.FixedZone("UTC+2", 2*60*60))
+	if !s.Matches(local) {
+		t.Errorf("%s doesn't match 14:30 UTC", local)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from string
+		want string // empty for never
+	}{
+		{"0 0 * * *", "2024-04-20 12:34", "2024-04-21 00:00"},
+		{"*/15 * * * *", "2024-04-20 12:45", "2024-04-20 13:00"},
+		{"0 0 13 * 5", "2024-04-13 00:00", "2024-04-19 00:00"},
+		{"0 0 1 1 *", "2024-04-20 00:00", "2025-01-01 00:00"},
+		{"0 0 29 2 *", "2024-04-20 00:00", "2028-02-29 00:00"},
+		{"0 0 31 2 *", "2024-04-20 00:00", ""},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", tt.expr, err)
+		}
+		got := s.Next(at(tt.from).Add(30 * time.Second))
+		var want time.Time
+		if tt.want != "" {
+			want = at(tt.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package experiment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/observer"
+)
+
+// Config is one scheduled peer-set experiment
+type Config struct {
+	Name string `json:"name"`
+
+	// Schedule is a five-field cron expression in UTC
+	Schedule string `json:"schedule"`
+
+	// DurationMinutes is how long the policy stays in force each run
+	DurationMinutes int `json:"duration_minutes"`
+
+	// Policy replaces the default peer selection while the experiment runs
+	Policy observer.PeerPolicy `json:"policy"`
+}
+
+type experiment struct {
+	Config
+	schedule *Schedule
+	duration time.Duration
+}
+
+// Start validates the experiments and runs them on their schedules. One
+// experiment runs at a time; a run falling due while another is active is
+// skipped. Observations made during a run are stamped with its run id.
+func Start(ctx context.Context, db *database.DB, cfgs []Config) error {
+	exps := make([]*experiment, 0, len(cfgs))
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if cfg.Name == "" || names[cfg.Name] {
+			return fmt.Errorf("experiment names must be unique and non-empty (got %q)", cfg.Name)
+		}
+		names[cfg.Name] = true
+		sched, err := ParseSchedule(cfg.Schedule)
+		if err != nil {
+			return fmt.Errorf("experiment %q: %w", cfg.Name, err)
+		}
+		if cfg.DurationMinutes <= 0 {
+			return fmt.Errorf("experiment %q: duration_minutes must be positive", cfg.Name)
+		}
+		exps = append(exps, &experiment{Config: cfg, schedule: sched, duration: time.Duration(cfg.DurationMinutes) * time.Minute})
+	}
+
+	if n, err := db.CloseInterruptedExperimentRuns(); err != nil {
+		return fmt.Errorf("closing interrupted experiment runs: %w", err)
+	} else if n > 0 {
+		logger.Log.Warn().Int64("runs", n).Msg("Closed experiment runs interrupted by a restart")
+	}
+
+	for _, e := range exps {
+		observer.WidenDiscovery(e.Policy)
+		logger.Log.Info().Str("experiment", e.Name).Time("next_run", e.schedule.Next(time.Now())).Msg("Experiment scheduled")
+	}
+
+	go run(ctx, db, exps)
+	return nil
+}
+
+func run(ctx context.Context, db *database.DB, exps []*experiment) {
+	var (
+		active *experiment
+		runID  int64
+		until  time.Time
+	)
+	stop := func() {
+		observer.SetPeerPolicy(nil)
+		if err := db.EndExperimentRun(runID); err != nil {
+			logger.Log.Error().Err(err).Int64("run_id", runID).Msg("Failed to record experiment end")
+		}
+		metrics.ExperimentActive.WithLabelValues(active.Name).Set(0)
+		logger.Log.Info().Str("experiment", active.Name).Int64("run_id", runID).Msg("Experiment ended")
+		active = nil
+	}
+	defer func() {
+		if active != nil {
+			stop()
+		}
+	}()
+
+	for {
+		// Wake at the top of each minute
+		now := time.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+		}
+		now = time.Now()
+
+		if active != nil && !now.Before(until) {
+			stop()
+		}
+
+		for _, e := range exps {
+			if !e.schedule.Matches(now) {
+				continue
+			}
+			if active != nil {
+				logger.Log.Warn().Str("experiment", e.Name).Str("active", active.Name).Msg("Experiment skipped, another is running")
+				continue
+			}
+			id, err := db.StartExperimentRun(e.Name, e.Policy)
+			if err != nil {
+				logger.Log.Error().Err(err).Str("experiment", e.Name).Msg("Failed to record experiment start")
+				continue
+			}
+			policy := e.Policy
+			observer.SetPeerPolicy(&policy)
+			active, runID, until = e, id, now.Add(e.duration)
+			metrics.ExperimentActive.WithLabelValues(e.Name).Set(1)
+			logger.Log.Info().Str("experiment", e.Name).Int64("run_id", id).Time("until", until).Msg("Experiment started")
+		}
+	}
+}
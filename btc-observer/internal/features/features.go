@@ -0,0 +1,92 @@
+package features
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Flags gating expensive subsystems. All are on by default.
+const (
+	// WitnessStorage stores wtxids and per-input witness sizes
+	WitnessStorage = "witness_storage"
+	// ConflictDetection checks every transaction's inputs for double spends
+	// and settles conflicts when blocks confirm them
+	ConflictDetection = "conflict_detection"
+	// MempoolTracking keeps received transactions in memory for compact
+	// block reconstruction
+	MempoolTracking = "mempool_tracking"
+	// BlockDownloads requests announced blocks; when off, announcements are
+	// still recorded
+	BlockDownloads = "block_downloads"
+)
+
+// flags is fixed at init, so lookups need no lock
+var flags = map[string]*atomic.Bool{}
+
+func init() {
+	for _, name := range []string{WitnessStorage, ConflictDetection, MempoolTracking, BlockDownloads} {
+		flags[name] = new(atomic.Bool)
+		flags[name].Store(true)
+		metrics.FeatureEnabled.WithLabelValues(name).Set(1)
+	}
+}
+
+// Enabled reports whether a feature is on; unknown names are off
+func Enabled(name string) bool {
+	f := flags[name]
+	return f != nil && f.Load()
+}
+
+// Set turns a feature on or off
+func Set(name string, on bool) error {
+	f := flags[name]
+	if f == nil {
+		return fmt.Errorf("unknown feature %q", name)
+	}
+	if f.Swap(on) == on {
+		return nil
+	}
+	value := 0.0
+	if on {
+		value = 1
+	}
+	metrics.FeatureEnabled.WithLabelValues(name).Set(value)
+	logger.Log.Info().Str("feature", name).Bool("enabled", on).Msg("Feature flag changed")
+	return nil
+}
+
+// Configure applies the flags set in the config file
+func Configure(cfg map[string]bool) error {
+	for name := range cfg {
+		if flags[name] == nil {
+			return fmt.Errorf("unknown feature %q (want one of %v)", name, Names())
+		}
+	}
+	for name, on := range cfg {
+		Set(name, on)
+	}
+	return nil
+}
+
+// Names lists the features
+func Names() []string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// States returns every feature's current setting
+func States() map[string]bool {
+	states := make(map[string]bool, len(flags))
+	for name, f := range flags {
+		states[name] = f.Load()
+	}
+	return states
+}
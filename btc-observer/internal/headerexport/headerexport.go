@@ -0,0 +1,137 @@
+package headerexport
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// ErrNotContiguous is returned when the stored headers in a range don't form
+// a chain: a height is missing or a header doesn't hash or link correctly
+var ErrNotContiguous = errors.New("stored headers are not a contiguous chain")
+
+// MaxHeaders caps one export (about 72 MB of raw headers)
+const MaxHeaders = 900000
+
+// ErrTooMany is returned when a range holds more than MaxHeaders headers
+var ErrTooMany = errors.New("too many headers")
+
+// Chain is a contiguous run of stored headers, lowest first
+type Chain struct {
+	Headers []database.FullHeader
+}
+
+// Load reads the stored headers from height from up to height to (inclusive;
+// negative means the tip). Every header must hash to its stored block hash
+// and link to the one below it, so a gap in the stored chain is an error
+// rather than a silently broken export.
+func Load(db *database.DB, from, to int32) (*Chain, error) {
+	if to < 0 {
+		to = math.MaxInt32
+	}
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid height range %d-%d", from, to)
+	}
+	headers, err := db.FullHeaders(from, to, MaxHeaders+1)
+	if err != nil {
+		return nil, fmt.Errorf("loading headers: %w", err)
+	}
+	if len(headers) > MaxHeaders {
+		return nil, fmt.Errorf("%w: more than %d headers in range", ErrTooMany, MaxHeaders)
+	}
+
+	for i, h := range headers {
+		raw := protocol.EncodeBlockHeader(h.Header)
+		hash1 := sha256.Sum256(raw)
+		if sha256.Sum256(hash1[:]) != h.Hash {
+			return nil, fmt.Errorf("%w: header at height %d doesn't hash to its block hash", ErrNotContiguous, h.Height)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := headers[i-1]
+		if h.Height != prev.Height+1 {
+			return nil, fmt.Errorf("%w: no stored header at height %d", ErrNotContiguous, prev.Height+1)
+		}
+		if h.Header.PrevBlockHash != prev.Hash {
+			return nil, fmt.Errorf("%w: header at height %d doesn't link to the one below it", ErrNotContiguous, h.Height)
+		}
+	}
+	return &Chain{Headers: headers}, nil
+}
+
+// Write renders the chain in the named format ("raw" or "json")
+func (c *Chain) Write(w io.Writer, format string) error {
+	switch format {
+	case "raw":
+		return c.WriteRaw(w)
+	case "json":
+		return c.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown header format %q (want raw or json)", format)
+	}
+}
+
+// WriteRaw writes the 80-byte headers back to back, the format of Bitcoin
+// Core's headers download and most SPV header stores
+func (c *Chain) WriteRaw(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, h := range c.Headers {
+		bw.Write(protocol.EncodeBlockHeader(h.Header))
+	}
+	return bw.Flush()
+}
+
+type jsonHeader struct {
+	Height        int32  `json:"height"`
+	Hash          string `json:"hash"`
+	Version       int32  `json:"version"`
+	PrevBlockHash string `json:"prev_block_hash"`
+	MerkleRoot    string `json:"merkle_root"`
+	Timestamp     uint32 `json:"timestamp"`
+	Bits          string `json:"bits"`
+	Nonce         uint32 `json:"nonce"`
+	Hex           string `json:"hex"`
+}
+
+// WriteJSON writes a JSON array of decoded headers, hashes in display byte
+// order and bits as hex like Bitcoin Core's getblockheader, one per line
+func (c *Chain) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	for i, h := range c.Headers {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		data, err := json.Marshal(jsonHeader{
+			Height:        h.Height,
+			Hash:          displayHash(h.Hash),
+			Version:       h.Header.Version,
+			PrevBlockHash: displayHash(h.Header.PrevBlockHash),
+			MerkleRoot:    displayHash(h.Header.MerkleRoot),
+			Timestamp:     h.Header.Timestamp,
+			Bits:          fmt.Sprintf("%08x", h.Header.Bits),
+			Nonce:         h.Header.Nonce,
+			Hex:           hex.EncodeToString(protocol.EncodeBlockHeader(h.Header)),
+		})
+		if err != nil {
+			return err
+		}
+		bw.WriteString("\n")
+		bw.Write(data)
+	}
+	bw.WriteString("\n]\n")
+	return bw.Flush()
+}
+
+func displayHash(h [32]byte) string {
+	return hex.EncodeToString(protocol.ReverseBytes(h[:]))
+}
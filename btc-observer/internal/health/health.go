@@ -0,0 +1,153 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/observer"
+)
+
+// dbPingTimeout bounds the database check, so a hung connection fails it
+// rather than the probe timing out
+const dbPingTimeout = 2 * time.Second
+
+// Config sets the thresholds past which the observer reports itself degraded
+type Config struct {
+	MinPeerFraction        float64 `json:"min_peer_fraction"`         // of the peer target's slots filled (default 0.5)
+	DiscoveryMaxAgeMinutes int     `json:"discovery_max_age_minutes"` // since the last peer pool refresh (default 90)
+	MaxQueueFill           float64 `json:"max_queue_fill"`            // of any queue's capacity (default 0.9)
+}
+
+func (c *Config) applyDefaults() {
+	if c.MinPeerFraction <= 0 || c.MinPeerFraction > 1 {
+		c.MinPeerFraction = 0.5
+	}
+	if c.DiscoveryMaxAgeMinutes <= 0 {
+		c.DiscoveryMaxAgeMinutes = 90
+	}
+	if c.MaxQueueFill <= 0 || c.MaxQueueFill > 1 {
+		c.MaxQueueFill = 0.9
+	}
+}
+
+// Check is the outcome of one health check
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	// liveness marks a failure a restart can fix, which fails /healthz as
+	// well as /readyz
+	liveness bool
+}
+
+// Report is the body of /healthz and /readyz
+type Report struct {
+	Status           string           `json:"status"` // ok or degraded
+	Time             time.Time        `json:"time"`
+	Checks           map[string]Check `json:"checks"`
+	Queues           []events.Queue   `json:"queues"`
+	Pressure         string           `json:"pressure"`
+	DBWriteLatencyMs int64            `json:"db_write_latency_ms"`
+}
+
+// checker runs the checks against the observer's live state
+type checker struct {
+	cfg       Config
+	db        *database.DB
+	pm        *observer.PeerManager
+	discovery bool
+}
+
+// Register serves /healthz and /readyz on the default mux, alongside
+// /metrics. /readyz fails while any check does: the database is unreachable,
+// too few peer slots are filled, discovery is stale or a queue is nearly
+// full. /healthz fails only on the database, a stale discovery routine or a
+// full queue, which a restart can fix; missing peers are left to readiness.
+// pm is nil when the observer isn't connecting to the network, and discovery
+// false when it only connects to static peers.
+func Register(cfg Config, db *database.DB, pm *observer.PeerManager, discovery bool) {
+	cfg.applyDefaults()
+	c := &checker{cfg: cfg, db: db, pm: pm, discovery: discovery}
+	http.Handle("/healthz", c.handler(true))
+	http.Handle("/readyz", c.handler(false))
+}
+
+// handler answers 200 when healthy and 503 when degraded, with the report
+// either way
+func (c *checker) handler(liveness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.run(r.Context())
+		status := http.StatusOK
+		for _, check := range report.Checks {
+			if !check.OK && (check.liveness || !liveness) {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		if status != http.StatusOK {
+			report.Status = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+func (c *checker) run(ctx context.Context) Report {
+	report := Report{
+		Status:           "ok",
+		Time:             time.Now().UTC(),
+		Checks:           map[string]Check{"database": c.checkDatabase(ctx)},
+		Queues:           observer.Queues(),
+		Pressure:         observer.CurrentPressure().String(),
+		DBWriteLatencyMs: observer.DBWriteLatency().Milliseconds(),
+	}
+	report.Checks["queues"] = c.checkQueues(report.Queues)
+	if c.pm != nil && c.discovery {
+		report.Checks["peers"] = c.checkPeers()
+		report.Checks["discovery"] = c.checkDiscovery()
+	}
+	return report
+}
+
+func (c *checker) checkDatabase(ctx context.Context) Check {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := c.db.Conn().PingContext(ctx); err != nil {
+		return Check{Detail: err.Error(), liveness: true}
+	}
+	return Check{OK: true, Detail: fmt.Sprintf("ping %dms", time.Since(start).Milliseconds())}
+}
+
+func (c *checker) checkQueues(queues []events.Queue) Check {
+	for _, q := range queues {
+		if q.Capacity > 0 && float64(q.Depth) >= c.cfg.MaxQueueFill*float64(q.Capacity) {
+			return Check{Detail: fmt.Sprintf("%s queue at %d of %d", q.Name, q.Depth, q.Capacity), liveness: true}
+		}
+	}
+	return Check{OK: true, Detail: fmt.Sprintf("%d queues below %.0f%%", len(queues), c.cfg.MaxQueueFill*100)}
+}
+
+func (c *checker) checkPeers() Check {
+	active, target := c.pm.TargetFill()
+	detail := fmt.Sprintf("%d of %d peer slots filled", active, target)
+	return Check{OK: float64(active) >= c.cfg.MinPeerFraction*float64(target), Detail: detail}
+}
+
+// checkDiscovery fails readiness until the first refresh, and liveness once
+// a refresh is overdue
+func (c *checker) checkDiscovery() Check {
+	last := observer.LastDiscovery()
+	if last.IsZero() {
+		return Check{Detail: "no peer pool refresh yet"}
+	}
+	age := time.Since(last).Round(time.Second)
+	if age > time.Duration(c.cfg.DiscoveryMaxAgeMinutes)*time.Minute {
+		return Check{Detail: fmt.Sprintf("last peer pool refresh %s ago", age), liveness: true}
+	}
+	return Check{OK: true, Detail: fmt.Sprintf("last peer pool refresh %s ago", age)}
+}
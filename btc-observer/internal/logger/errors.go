@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// errorSummaryInterval is how often suppressed repeats are summarized
+const errorSummaryInterval = 30 * time.Second
+
+// repeatedError tracks occurrences of one distinct (message, error) pair
+type repeatedError struct {
+	msg        string
+	err        string
+	suppressed int
+	first      time.Time
+	last       time.Time
+}
+
+var repeatedErrors = struct {
+	sync.Mutex
+	m map[string]*repeatedError
+}{m: make(map[string]*repeatedError)}
+
+// Error logs err at error level, but only the first time a given (msg, err)
+// pair is seen within a summary interval. Repeats are counted and reported in
+// aggregate by the summary routine, so an outage produces a handful of lines
+// instead of one per message.
+func Error(l zerolog.Logger, err error, msg string) {
+	key := msg + "\x00" + err.Error()
+	now := time.Now()
+
+	repeatedErrors.Lock()
+	rec, seen := repeatedErrors.m[key]
+	if seen {
+		if rec.suppressed == 0 {
+			rec.first = now
+		}
+		rec.suppressed++
+		rec.last = now
+		repeatedErrors.Unlock()
+		return
+	}
+	repeatedErrors.m[key] = &repeatedError{msg: msg, err: err.Error(), first: now, last: now}
+	repeatedErrors.Unlock()
+
+	l.Error().Err(err).Msg(msg)
+}
+
+// FlushRepeatedErrors logs a summary line for every error that was suppressed
+// since the last flush and forgets errors that have stopped recurring
+func FlushRepeatedErrors() {
+	now := time.Now()
+
+	repeatedErrors.Lock()
+	defer repeatedErrors.Unlock()
+	for key, rec := range repeatedErrors.m {
+		if rec.suppressed > 0 {
+			Log.Error().
+				Str("error", rec.err).
+				Int("repeats", rec.suppressed).
+				Time("first_seen", rec.first).
+				Time("last_seen", rec.last).
+				Msg(rec.msg + " (repeated)")
+			rec.suppressed = 0
+			continue
+		}
+		if now.Sub(rec.last) >= errorSummaryInterval {
+			delete(repeatedErrors.m, key)
+		}
+	}
+}
+
+// StartErrorSummary starts periodic summarizing of repeated errors
+func StartErrorSummary(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(errorSummaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				FlushRepeatedErrors()
+				return
+			case <-ticker.C:
+				FlushRepeatedErrors()
+			}
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultCustomInterval = 60 * time.Second
+	customQueryTimeout    = 10 * time.Second
+)
+
+// CustomMetric is an operator-defined gauge whose value comes from a SQL query
+// returning a single number
+type CustomMetric struct {
+	Name            string `json:"name"`
+	Help            string `json:"help"`
+	Query           string `json:"query"`
+	IntervalSeconds int    `json:"interval_seconds"`
+}
+
+// StartCustomMetrics registers a gauge for each definition and refreshes it on
+// its interval. Queries run in read-only transactions.
+func StartCustomMetrics(ctx context.Context, db *sql.DB, defs []CustomMetric) error {
+	gauges := make([]prometheus.Gauge, len(defs))
+	for i, def := range defs {
+		if def.Query == "" {
+			return fmt.Errorf("custom metric %q has no query", def.Name)
+		}
+		help := def.Help
+		if help == "" {
+			help = "Custom metric defined in config"
+		}
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: def.Name, Help: help})
+		if err := prometheus.Register(gauge); err != nil {
+			return fmt.Errorf("registering custom metric %q: %w", def.Name, err)
+		}
+		gauges[i] = gauge
+	}
+
+	for i, def := range defs {
+		interval := time.Duration(def.IntervalSeconds) * time.Second
+		if interval <= 0 {
+			interval = defaultCustomInterval
+		}
+		go runCustomMetric(ctx, db, def, gauges[i], interval)
+	}
+	return nil
+}
+
+func runCustomMetric(ctx context.Context, db *sql.DB, def CustomMetric, gauge prometheus.Gauge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := refreshCustomMetric(ctx, db, def.Query, gauge); err != nil && ctx.Err() == nil {
+			log.Printf("Custom metric %s query failed: %v", def.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshCustomMetric(ctx context.Context, db *sql.DB, query string, gauge prometheus.Gauge) error {
+	ctx, cancel := context.WithTimeout(ctx, customQueryTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var value sql.NullFloat64
+	if err := tx.QueryRowContext(ctx, query).Scan(&value); err != nil {
+		return err
+	}
+	if value.Valid {
+		gauge.Set(value.Float64)
+	} else {
+		gauge.Set(0)
+	}
+	return nil
+}
@@ -27,6 +27,41 @@ var (
 		Help: "Total number of double-spend conflicts detected",
 	})
 
+	ConflictOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_conflict_outcomes_total",
+		Help: "Double-spend conflicts settled by a confirmation, by which side confirmed",
+	}, []string{"outcome"})
+
+	ConflictResolveSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_conflict_resolve_seconds",
+		Help:    "Time from detecting a double-spend conflict to the block that settled it",
+		Buckets: []float64{60, 300, 600, 1800, 3600, 3 * 3600, 6 * 3600, 24 * 3600, 3 * 24 * 3600},
+	})
+
+	TxFeeRate = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_tx_fee_rate_sat_vb",
+		Help:    "Fee rate of relayed transactions whose inputs are all known, in sat/vB",
+		Buckets: []float64{1, 2, 3, 5, 8, 10, 15, 20, 30, 50, 75, 100, 150, 250, 500, 1000},
+	})
+
+	TxVSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_tx_vsize_vbytes",
+		Help:    "Virtual size of relayed transactions, in vbytes",
+		Buckets: []float64{110, 150, 200, 250, 350, 500, 1000, 2500, 10000, 100000},
+	})
+
+	TxInputCount = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_tx_input_count",
+		Help:    "Number of inputs per relayed transaction",
+		Buckets: []float64{1, 2, 3, 5, 10, 20, 50, 100, 500},
+	})
+
+	TxOutputCount = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_tx_output_count",
+		Help:    "Number of outputs per relayed transaction",
+		Buckets: []float64{1, 2, 3, 5, 10, 20, 50, 100, 500},
+	})
+
 	// Block metrics
 	BlocksReceived = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "btc_blocks_received_total",
@@ -35,7 +70,7 @@ var (
 
 	BlockHeight = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "btc_block_height",
-		Help: "Latest block height observed",
+		Help: "Height of the latest received block on the strongest chain",
 	})
 
 	BlockTxCount = promauto.NewHistogram(prometheus.HistogramOpts{
@@ -44,6 +79,83 @@ var (
 		Buckets: []float64{100, 500, 1000, 2000, 3000, 4000, 5000, 7500, 10000},
 	})
 
+	BlockFees = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_block_fees_satoshis",
+		Help:    "Total fees of received blocks whose prevouts were all resolved",
+		Buckets: prometheus.ExponentialBuckets(1e5, 2, 16), // 0.001 BTC .. ~33 BTC
+	})
+
+	BlockSubsidy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_block_subsidy_satoshis",
+		Help: "Block subsidy at the height of the last received block",
+	})
+
+	BlockOrderingEntropy = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_block_ordering_entropy",
+		Help:    "Fraction of a block's transaction pairs placed against fee-rate order, by miner",
+		Buckets: prometheus.LinearBuckets(0.05, 0.05, 10),
+	}, []string{"miner"})
+
+	PrioritizedTxs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_block_prioritized_txs_total",
+		Help: "Block transactions placed ahead of ones paying several times their fee rate, by miner",
+	}, []string{"miner"})
+
+	BlockFeesUnresolved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_block_fees_unresolved_total",
+		Help: "Received blocks whose total fees are unknown because some prevouts couldn't be resolved",
+	})
+
+	BlockAnomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_block_anomalies_total",
+		Help: "Total number of block validation anomalies detected",
+	}, []string{"kind"})
+
+	BlocksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_blocks_rejected_total",
+		Help: "Total number of blocks rejected or quarantined by checkpoint validation",
+	}, []string{"reason"})
+
+	BlockFallbackRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_block_fallback_requests_total",
+		Help: "Total blocks requested from headers-only regions because no full-download region announced them in time",
+	})
+
+	BlocksPartial = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_blocks_partial_total",
+		Help: "Total blocks stored with only the transactions before a parse failure",
+	})
+
+	CompactBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_compact_blocks_total",
+		Help: "Compact blocks by outcome: reconstructed from the mempool, missing txs requested, completed from blocktxn, or fallen back to a full block",
+	}, []string{"result"})
+
+	CompactHighBandwidthPeers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_compact_hb_peers",
+		Help: "Peers currently selected for high-bandwidth compact block relay",
+	})
+
+	CompactHighBandwidthChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_compact_hb_changes_total",
+		Help: "Peers moved into (promoted) or out of (demoted) high-bandwidth compact block relay",
+	}, []string{"change"})
+
+	MempoolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_mempool_txs",
+		Help: "Transactions held in memory for compact block reconstruction",
+	})
+
+	VersionBitsSignaling = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_versionbits_signaling_ratio",
+		Help: "Fraction of blocks in the current retarget period signaling each version bit",
+	}, []string{"bit"})
+
+	VersionBitsWindowSignaling = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_versionbits_window_signaling_ratio",
+		Help: "Fraction of the last 2016 blocks signaling each version bit",
+	}, []string{"bit"})
+
 	// Peer metrics
 	PeersActive = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "btc_peers_active",
@@ -55,6 +167,31 @@ var (
 		Help: "Number of active peers by region",
 	}, []string{"region"})
 
+	RegionTxRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_region_tx_per_second",
+		Help: "Transaction announcements per second from each region's peers, averaged over the last minute",
+	}, []string{"region"})
+
+	PeerVersionChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_version_changes_total",
+		Help: "Known peers that reconnected with a different version message, by kind of change",
+	}, []string{"kind"})
+
+	WTxIDRelayPeers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_wtxid_relay_peers",
+		Help: "Active peers that negotiated wtxid relay (BIP339)",
+	})
+
+	EgressHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_egress_healthy",
+		Help: "Whether each dial source's last egress check confirmed its expected exit country (1) or not (0)",
+	}, []string{"source"})
+
+	EgressSkippedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_egress_skipped_connections_total",
+		Help: "Peer connections not made because their dial source's egress wasn't verified",
+	}, []string{"source"})
+
 	PeerConnections = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "btc_peer_connections_total",
 		Help: "Total number of peer connection attempts",
@@ -76,6 +213,125 @@ var (
 		Buckets: []float64{10, 25, 50, 100, 200, 500, 1000, 2000, 5000},
 	}, []string{"region"})
 
+	PeerTCPRTT = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_peer_tcp_rtt_ms",
+		Help:    "Kernel smoothed TCP round trip time to peers in milliseconds",
+		Buckets: []float64{10, 25, 50, 100, 200, 500, 1000, 2000, 5000},
+	}, []string{"region"})
+
+	GeoSuspectPeers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_geo_suspect_peers_total",
+		Help: "Peers whose ping RTT is below the physical minimum for their GeoIP location",
+	}, []string{"region"})
+
+	ParseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_parse_errors_total",
+		Help: "Total messages that failed to parse, by command and error class",
+	}, []string{"command", "class"})
+
+	CorruptMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_corrupt_messages_total",
+		Help: "Total corrupt messages received from peers, by framing error",
+	}, []string{"reason"})
+
+	PeerMisbehavior = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_misbehavior_total",
+		Help: "Total handshake misbehavior by peers, by reason",
+	}, []string{"reason"})
+
+	BandwidthThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_bandwidth_throttled_seconds_total",
+		Help: "Total time peer reads and writes were delayed by bandwidth caps",
+	}, []string{"direction"})
+
+	PeerTrafficBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_traffic_bytes_total",
+		Help: "Bytes on the wire to and from peers, by region and direction",
+	}, []string{"region", "direction"})
+
+	MessageTrafficBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_message_traffic_bytes_total",
+		Help: "Bytes of peer messages, framing included, by direction and message type",
+	}, []string{"direction", "command"})
+
+	PeerIdentities = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_identities_total",
+		Help: "Peer connections matched to a long-term identity, by result (known, linked, new)",
+	}, []string{"result"})
+
+	AddressesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_addresses_received_total",
+		Help: "Peer addresses gossiped to us in addr and addrv2 messages, by network",
+	}, []string{"network"})
+
+	CrawlAddresses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_crawl_addresses_total",
+		Help: "Gossiped addresses offered to the crawler, by result (queued, known, dropped, added, unwanted)",
+	}, []string{"result"})
+
+	DoHQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_doh_queries_total",
+		Help: "DNS-over-HTTPS queries made for discovery and peer hostnames, by result",
+	}, []string{"result"})
+
+	// Fork monitor metrics
+	ForkChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_fork_checks_total",
+		Help: "Peer chain comparisons by result",
+	}, []string{"status"})
+
+	PeersDivergent = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_peers_divergent",
+		Help: "Number of connected peers whose last reported chain diverged from ours",
+	})
+
+	ClaimDivergences = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_claim_divergences_total",
+		Help: "Claim reports in which a peer's behavior contradicted its claims, by kind",
+	}, []string{"kind"})
+
+	PeersClaimExcluded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_peers_claim_excluded",
+		Help: "Peers excluded from measurements for persistently contradicting their claims",
+	})
+
+	// Header chain metrics
+	HeaderChainHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_header_chain_height",
+		Help: "Height of the best header chain the observer has validated",
+	})
+
+	HeaderChainWork = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_header_chain_work_log2",
+		Help: "Log2 of the best header chain's cumulative work from its root",
+	})
+
+	ChainBranches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_header_chain_branches",
+		Help: "Weaker branches held in the header chain beside the best one",
+	})
+
+	HeadersRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_headers_rejected_total",
+		Help: "Headers that failed to join the header chain, by reason",
+	}, []string{"reason"})
+
+	ChainReorgs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_chain_reorgs_total",
+		Help: "Best header chain switches to another branch",
+	})
+
+	ReorgDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btc_chain_reorg_depth",
+		Help:    "Blocks disconnected from the best chain per reorg",
+		Buckets: []float64{1, 2, 3, 4, 6, 10, 20, 50, 100},
+	})
+
+	OrphanedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_orphaned_blocks_total",
+		Help: "Stored blocks marked orphaned after a reorg",
+	})
+
 	// Database metrics
 	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "btc_db_query_duration_seconds",
@@ -99,6 +355,27 @@ var (
 		Help: "Total block announcements received via inv messages",
 	})
 
+	HeaderBlockAnnouncements = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_headers_block_announcements_total",
+		Help: "Total block announcements received via unsolicited headers messages (BIP130)",
+	})
+
+	BlockRegionDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_block_region_delay_seconds",
+		Help:    "Delay from a block's first arrival, from a peer in region from, to its first arrival from a peer in region to",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+	}, []string{"from", "to"})
+
+	InvWTxAnnouncements = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_inv_wtx_announcements_total",
+		Help: "Transaction announcements made by wtxid (BIP339)",
+	})
+
+	InvEchoes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_inv_echoes_total",
+		Help: "Inv items ignored because the same peer had already announced them",
+	})
+
 	// Dedup metrics
 	TxDeduplicated = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "btc_tx_deduplicated_total",
@@ -109,6 +386,250 @@ var (
 		Name: "btc_seen_map_size",
 		Help: "Current size of seen maps",
 	}, []string{"type"})
+
+	// Memory watchdog metrics
+	HeapBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_heap_bytes",
+		Help: "Heap bytes in use as of the last memory check",
+	})
+
+	MemoryShedding = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_memory_shedding",
+		Help: "1 while the observer is shedding load to stay within its memory budget",
+	})
+
+	TxShed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_tx_shed_total",
+		Help: "Total transaction downloads skipped due to memory pressure",
+	})
+
+	ScriptTemplateMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_script_template_matches_total",
+		Help: "Total transactions tagged with a known script template",
+	}, []string{"template", "location"})
+
+	InputSpendTypes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_input_spend_types_total",
+		Help: "Transaction inputs by spend type, classified from their scriptSig and witness",
+	}, []string{"type"})
+
+	InputMultisig = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_input_multisig_total",
+		Help: "Transaction inputs revealing an m-of-n multisig script, by spend type and threshold",
+	}, []string{"type", "multisig"})
+
+	ProjectTransactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_project_transactions_total",
+		Help: "Transactions tagged for an observation project, by the filter they matched",
+	}, []string{"project", "reason"})
+
+	// Pluggable model metrics
+	ModelPeerScore = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_model_peer_score",
+		Help: "Latest per-peer score published by a propagation model",
+	}, []string{"model", "peer"})
+
+	// Peer-set experiment metrics
+	ExperimentActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_experiment_active",
+		Help: "Whether a scheduled peer-set experiment is currently running (1) or not (0)",
+	}, []string{"experiment"})
+
+	// Event bus and backpressure metrics
+	EventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_events_dropped_total",
+		Help: "Total events dropped because a subscriber was not keeping up",
+	}, []string{"type"})
+
+	PressureLevel = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_pressure_level",
+		Help: "Current pipeline backpressure level (0 normal, 1 feefilter, 2 getdata paused, 3 shedding peers)",
+	})
+
+	PeersShed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_peers_shed_total",
+		Help: "Total peers disconnected to relieve backpressure",
+	})
+
+	DBWriteLatency = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_db_write_latency_ewma_seconds",
+		Help: "Moving average of per-transaction database write latency",
+	})
+
+	// Disk watchdog metrics
+	DiskFreeBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_disk_free_bytes",
+		Help: "Free disk space available at each watched path",
+	}, []string{"path"})
+
+	DiskFilesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_disk_files_dropped_total",
+		Help: "Total files deleted by the disk watchdog to free space",
+	})
+
+	// Live stream metrics
+	StreamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_stream_clients",
+		Help: "WebSocket clients connected to the live event stream",
+	})
+
+	StreamEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_stream_events_total",
+		Help: "Total events sent to live stream clients",
+	}, []string{"type"})
+
+	// Fee alert metrics
+	FeeAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_fee_alerts_total",
+		Help: "Total transactions alerted on for extreme fees, by reason",
+	}, []string{"reason"})
+
+	// Low-fee relay metrics
+	LowFeeTxs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_low_fee_txs_total",
+		Help: "Transactions relayed below the minimum relay fee rate, by kind",
+	}, []string{"kind"})
+
+	LowFeeRelays = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_low_fee_relays_total",
+		Help: "Peers that announced a below-minimum-fee transaction, by peer region",
+	}, []string{"region"})
+
+	LockTimeTxs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_locktime_txs_total",
+		Help: "Transactions seen before their locktime expired, by lock kind (height or time)",
+	}, []string{"kind"})
+
+	LockTimeConfirmDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_locktime_confirm_delay_blocks",
+		Help:    "Blocks between a tracked transaction's locktime expiring and its confirmation, by lock kind",
+		Buckets: []float64{0, 1, 2, 3, 6, 12, 24, 72, 144},
+	}, []string{"kind"})
+
+	RelayProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_relay_probes_total",
+		Help: "Relay probe transactions by variant and result (sent or failed)",
+	}, []string{"variant", "result"})
+
+	RelayProbePeers = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_relay_probe_peers",
+		Help:    "Peers other than the first hop that announced a relay probe, by variant",
+		Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64},
+	}, []string{"variant"})
+
+	RelayProbeFirstRelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "btc_relay_probe_first_relay_seconds",
+		Help:    "Time from sending a relay probe to its first announcement by another peer, by variant",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"variant"})
+
+	RelayProbeWalletBalance = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_relay_probe_wallet_balance_satoshis",
+		Help: "Confirmed and pending balance of the relay probe wallet, as last checked for funding",
+	})
+
+	RelayProbeFunding = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_relay_probe_funding_total",
+		Help: "Relay probe wallet top-ups by source (rpc or faucet) and result (requested or failed)",
+	}, []string{"source", "result"})
+
+	// Kafka publisher metrics
+	KafkaMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_kafka_messages_total",
+		Help: "Total messages delivered to Kafka, by topic and result",
+	}, []string{"topic", "result"})
+
+	// Webhook alert metrics
+	AlertDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_alert_deliveries_total",
+		Help: "Alerts sent to webhooks, by webhook and result (ok, failed, dropped)",
+	}, []string{"webhook", "result"})
+
+	AlertRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_alert_retries_total",
+		Help: "Webhook delivery attempts retried after a failure, by webhook",
+	}, []string{"webhook"})
+
+	// ClickHouse writer metrics
+	ClickHouseRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_clickhouse_rows_total",
+		Help: "Total rows inserted into ClickHouse, by table and result",
+	}, []string{"table", "result"})
+
+	// Subsystem supervisor metrics
+	SubsystemRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_subsystem_restarts_total",
+		Help: "Total subsystem restarts requested through the admin API, by subsystem",
+	}, []string{"subsystem"})
+
+	RegionRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_region_restarts_total",
+		Help: "Total restarts of one region's peer connections, by region",
+	}, []string{"region"})
+
+	PeerPenalties = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_peer_penalties_total",
+		Help: "Misbehavior added to peers' penalty scores, by kind",
+	}, []string{"kind"})
+
+	PeerLatencyRotations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_peer_latency_rotations_total",
+		Help: "Peers disconnected for announcing transactions far behind the other connected peers",
+	})
+
+	RegionSuggestions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_region_suggestions",
+		Help: "Countries or ASes with nodes seen but no connected peer, suggested for a vantage point",
+	}, []string{"kind"})
+
+	RegionAutoAdded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_region_auto_added_total",
+		Help: "Suggested countries added to the peer targets automatically",
+	})
+
+	GeoLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_geo_lookups_total",
+		Help: "IPs geolocated through the geo cache, by whether the cache answered, the provider resolved them or neither did",
+	}, []string{"result"})
+
+	GeoBackfillPeers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_geo_backfill_peers_total",
+		Help: "Recorded peers the geo backfill looked up, by whether they were located",
+	}, []string{"result"})
+
+	// Feature flag metrics
+	FeatureEnabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_feature_enabled",
+		Help: "Whether each runtime feature flag is on (1) or off (0)",
+	}, []string{"feature"})
+
+	// Raw message store metrics
+	StoredMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btc_stored_messages_total",
+		Help: "Total raw wire messages written to the message store",
+	})
+
+	MessageStoreBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_message_store_bytes",
+		Help: "Compressed size of the raw message store on disk",
+	})
+
+	// Retention metrics
+	RetentionRowsPruned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "btc_retention_rows_pruned_total",
+		Help: "Total rows deleted, or scripts cleared, by the retention job, by kind of data",
+	}, []string{"kind"})
+
+	// Integrity audit metrics
+	AuditDiscrepancies = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "btc_audit_discrepancies",
+		Help: "Rows breaking each integrity check in the last audit run",
+	}, []string{"check"})
+
+	AuditLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "btc_audit_last_run_timestamp_seconds",
+		Help: "Unix time the integrity audit last ran",
+	})
 )
 
 // SeedFromDB initializes counter metrics from historical database totals
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Snapshot formats
+const (
+	SnapshotJSON        = "json"
+	SnapshotOpenMetrics = "openmetrics"
+)
+
+// SnapshotConfig writes every metric to a file at graceful shutdown, so a
+// short run's summary survives even if Prometheus never scraped it
+type SnapshotConfig struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // json (default) or openmetrics
+}
+
+// Validate checks the config, filling in the default format
+func (c *SnapshotConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("metrics snapshot needs a path")
+	}
+	switch c.Format {
+	case "":
+		c.Format = SnapshotJSON
+	case SnapshotJSON, SnapshotOpenMetrics:
+	default:
+		return fmt.Errorf("unknown metrics snapshot format %q (json or openmetrics)", c.Format)
+	}
+	return nil
+}
+
+// RunInfo describes the run a snapshot is taken at the end of
+type RunInfo struct {
+	Observer  string    `json:"observer"`
+	Started   time.Time `json:"started"`
+	Ended     time.Time `json:"ended"`
+	StoppedBy string    `json:"stopped_by"`
+	Headline  Headline  `json:"headline"` // counts for this run only
+	// Shutdown is how long each teardown phase took, in seconds
+	Shutdown map[string]float64 `json:"shutdown_seconds,omitempty"`
+}
+
+// jsonSnapshot is the json format: the run's headline and every sample
+type jsonSnapshot struct {
+	Run     RunInfo      `json:"run"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Value   *float64          `json:"value,omitempty"`
+	Count   *uint64           `json:"count,omitempty"` // histograms and summaries
+	Sum     *float64          `json:"sum,omitempty"`
+	Buckets map[string]uint64 `json:"buckets,omitempty"` // cumulative, by upper bound
+}
+
+// WriteSnapshot writes all registered metrics to the configured file. The
+// file is written next to its destination and renamed into place, so a crash
+// mid-write doesn't leave a truncated snapshot.
+func WriteSnapshot(cfg SnapshotConfig, run RunInfo) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.Path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if cfg.Format == SnapshotOpenMetrics {
+		err = writeOpenMetrics(tmp, families)
+	} else {
+		err = writeJSON(tmp, families, run)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cfg.Path)
+}
+
+func writeOpenMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return fmt.Errorf("encode %s: %w", mf.GetName(), err)
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+func writeJSON(w io.Writer, families []*dto.MetricFamily, run RunInfo) error {
+	snap := jsonSnapshot{Run: run}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			jm := jsonMetric{Name: mf.GetName(), Type: mf.GetType().String()}
+			if len(m.GetLabel()) > 0 {
+				jm.Labels = make(map[string]string, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					jm.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				jm.Value = ptr(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				jm.Value = ptr(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				jm.Value = ptr(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				jm.Count, jm.Sum = ptr(h.GetSampleCount()), ptr(h.GetSampleSum())
+				jm.Buckets = make(map[string]uint64, len(h.GetBucket()))
+				for _, b := range h.GetBucket() {
+					jm.Buckets[fmt.Sprint(b.GetUpperBound())] = b.GetCumulativeCount()
+				}
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				jm.Count, jm.Sum = ptr(s.GetSampleCount()), ptr(s.GetSampleSum())
+			}
+			snap.Metrics = append(snap.Metrics, jm)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	defaultStatsDFlush = 10 * time.Second
+	statsDMaxPacket    = 1400
+	// statsDMetricPrefix limits mirroring to the observer's own metrics
+	statsDMetricPrefix = "btc_"
+)
+
+// StatsDConfig configures the optional StatsD/DogStatsD emitter
+type StatsDConfig struct {
+	Addr         string `json:"addr"`
+	Prefix       string `json:"prefix"`
+	DogStatsD    bool   `json:"dogstatsd"`
+	FlushSeconds int    `json:"flush_seconds"`
+}
+
+// statsdEmitter mirrors the Prometheus registry to a StatsD server. Counters and
+// histogram count/sum are sent as deltas since the previous flush, gauges as-is.
+type statsdEmitter struct {
+	cfg  StatsDConfig
+	conn net.Conn
+	last map[string]float64
+	buf  bytes.Buffer
+}
+
+// StartStatsD starts periodically pushing core metrics to a StatsD server over UDP
+func StartStatsD(ctx context.Context, cfg StatsDConfig) error {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("dial statsd: %w", err)
+	}
+
+	interval := time.Duration(cfg.FlushSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultStatsDFlush
+	}
+
+	e := &statsdEmitter{cfg: cfg, conn: conn, last: make(map[string]float64)}
+	go func() {
+		defer conn.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := e.flush(); err != nil {
+					log.Printf("StatsD flush failed: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (e *statsdEmitter) flush() error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, statsDMetricPrefix) {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				e.emitDelta(name, m.GetLabel(), m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				e.emit(name, m.GetLabel(), m.GetGauge().GetValue(), "g")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				e.emitDelta(name+".count", m.GetLabel(), float64(h.GetSampleCount()))
+				e.emitDelta(name+".sum", m.GetLabel(), h.GetSampleSum())
+			}
+		}
+	}
+	return e.send()
+}
+
+// emitDelta sends the change in a cumulative value since the last flush
+func (e *statsdEmitter) emitDelta(name string, labels []*dto.LabelPair, value float64) {
+	key := name + labelKey(labels)
+	prev, seen := e.last[key]
+	e.last[key] = value
+	if !seen || value < prev {
+		// First sighting (counters seeded from the DB) or a reset: establish a baseline
+		return
+	}
+	if delta := value - prev; delta > 0 {
+		e.emit(name, labels, delta, "c")
+	}
+}
+
+func (e *statsdEmitter) emit(name string, labels []*dto.LabelPair, value float64, kind string) {
+	var line strings.Builder
+	line.WriteString(e.cfg.Prefix)
+	line.WriteString(name)
+	if !e.cfg.DogStatsD {
+		// Plain StatsD has no tags, so fold label values into the metric name
+		for _, l := range labels {
+			line.WriteString(".")
+			line.WriteString(sanitizeStatsD(l.GetValue()))
+		}
+	}
+	fmt.Fprintf(&line, ":%g|%s", value, kind)
+	if e.cfg.DogStatsD && len(labels) > 0 {
+		tags := make([]string, len(labels))
+		for i, l := range labels {
+			tags[i] = l.GetName() + ":" + sanitizeStatsD(l.GetValue())
+		}
+		line.WriteString("|#")
+		line.WriteString(strings.Join(tags, ","))
+	}
+
+	if e.buf.Len() > 0 && e.buf.Len()+line.Len()+1 > statsDMaxPacket {
+		e.send()
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte('\n')
+	}
+	e.buf.WriteString(line.String())
+}
+
+func (e *statsdEmitter) send() error {
+	if e.buf.Len() == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(e.buf.Bytes())
+	e.buf.Reset()
+	return err
+}
+
+func labelKey(labels []*dto.LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.GetName() + "=" + l.GetValue()
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sanitizeStatsD(s string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_").Replace(s)
+}
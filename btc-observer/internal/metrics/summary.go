@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Headline is the handful of numbers that summarize an observer run
+type Headline struct {
+	TxReceived         float64 `json:"tx_received"`
+	TxRecorded         float64 `json:"tx_recorded"`
+	TxConflicts        float64 `json:"tx_conflicts"`
+	BlocksReceived     float64 `json:"blocks_received"`
+	InvTxAnnouncements float64 `json:"inv_tx_announcements"`
+	InvBlockAnnounces  float64 `json:"inv_block_announcements"`
+	PeerConnections    float64 `json:"peer_connections"`
+	PeerDisconnections float64 `json:"peer_disconnections"`
+	HandshakeFailures  float64 `json:"handshake_failures"`
+
+	// Gauges, as of when the headline was read
+	PeersActive float64 `json:"peers_active"`
+	BlockHeight float64 `json:"block_height"`
+}
+
+// ReadHeadline reads the headline counters and gauges
+func ReadHeadline() Headline {
+	return Headline{
+		TxReceived:         value(TxReceived),
+		TxRecorded:         value(TxRecordedDB),
+		TxConflicts:        value(TxConflicts),
+		BlocksReceived:     value(BlocksReceived),
+		InvTxAnnouncements: value(InvTxAnnouncements),
+		InvBlockAnnounces:  value(InvBlockAnnouncements),
+		PeerConnections:    value(PeerConnections),
+		PeerDisconnections: value(PeerDisconnections),
+		HandshakeFailures:  value(PeerHandshakeFailures),
+		PeersActive:        value(PeersActive),
+		BlockHeight:        value(BlockHeight),
+	}
+}
+
+// Since returns the counters' growth since base, which SeedFromDB may have
+// started above zero; gauges are kept as they are
+func (h Headline) Since(base Headline) Headline {
+	h.TxReceived -= base.TxReceived
+	h.TxRecorded -= base.TxRecorded
+	h.TxConflicts -= base.TxConflicts
+	h.BlocksReceived -= base.BlocksReceived
+	h.InvTxAnnouncements -= base.InvTxAnnouncements
+	h.InvBlockAnnounces -= base.InvBlockAnnounces
+	h.PeerConnections -= base.PeerConnections
+	h.PeerDisconnections -= base.PeerDisconnections
+	h.HandshakeFailures -= base.HandshakeFailures
+	return h
+}
+
+// value reads a counter or gauge
+func value(c prometheus.Metric) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
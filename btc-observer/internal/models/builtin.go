@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+)
+
+func init() {
+	Register("first_seen_share", newFirstSeenShare)
+	Register("announce_delay", newAnnounceDelay)
+}
+
+// firstSeen remembers when each tx was first announced by any peer, forgetting
+// txs older than ttl
+type firstSeen struct {
+	ttl       time.Duration
+	seen      map[[32]byte]time.Time
+	lastPrune time.Time
+}
+
+func newFirstSeen(ttl time.Duration) *firstSeen {
+	return &firstSeen{ttl: ttl, seen: make(map[[32]byte]time.Time), lastPrune: time.Now()}
+}
+
+// observe records an announcement and returns how long after the first
+// announcement it arrived (zero when this one was first)
+func (f *firstSeen) observe(hash [32]byte, at time.Time) (time.Duration, bool) {
+	if at.Sub(f.lastPrune) > f.ttl/4 {
+		for h, t := range f.seen {
+			if at.Sub(t) > f.ttl {
+				delete(f.seen, h)
+			}
+		}
+		f.lastPrune = at
+	}
+
+	first, ok := f.seen[hash]
+	if !ok {
+		f.seen[hash] = at
+		return 0, true
+	}
+	return at.Sub(first), false
+}
+
+// firstSeenShare scores each peer by the fraction of its tx announcements
+// that were the first seen from any peer
+type firstSeenShare struct {
+	minAnnouncements int
+	seen             *firstSeen
+	peers            map[string]*shareCounts
+}
+
+type shareCounts struct {
+	announced int
+	first     int
+}
+
+func newFirstSeenShare(params json.RawMessage) (Model, error) {
+	p := struct {
+		TTLSecs          int `json:"ttl_secs"`
+		MinAnnouncements int `json:"min_announcements"`
+	}{TTLSecs: 600, MinAnnouncements: 100}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.TTLSecs <= 0 {
+		return nil, fmt.Errorf("ttl_secs must be positive")
+	}
+	return &firstSeenShare{
+		minAnnouncements: p.MinAnnouncements,
+		seen:             newFirstSeen(time.Duration(p.TTLSecs) * time.Second),
+		peers:            make(map[string]*shareCounts),
+	}, nil
+}
+
+func (m *firstSeenShare) Observe(e events.Event) {
+	switch e.Type {
+	case events.TxAnnounced:
+		a := e.Data.(events.TxAnnouncement)
+		c := m.peers[a.Peer]
+		if c == nil {
+			c = &shareCounts{}
+			m.peers[a.Peer] = c
+		}
+		c.announced++
+		if _, first := m.seen.observe(a.TxHash, e.Time); first {
+			c.first++
+		}
+	case events.PeerDisconnected:
+		delete(m.peers, e.Data.(events.PeerInfo).Peer)
+	}
+}
+
+func (m *firstSeenShare) Scores() []Score {
+	scores := make([]Score, 0, len(m.peers))
+	for peer, c := range m.peers {
+		if c.announced < m.minAnnouncements {
+			continue
+		}
+		scores = append(scores, Score{Peer: peer, Value: float64(c.first) / float64(c.announced)})
+	}
+	return scores
+}
+
+// announceDelay scores each peer by a moving average of how many milliseconds
+// after the first announcement it announces txs (lower is faster)
+type announceDelay struct {
+	alpha float64
+	seen  *firstSeen
+	peers map[string]float64
+}
+
+func newAnnounceDelay(params json.RawMessage) (Model, error) {
+	p := struct {
+		TTLSecs int     `json:"ttl_secs"`
+		Alpha   float64 `json:"alpha"`
+	}{TTLSecs: 600, Alpha: 0.05}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.TTLSecs <= 0 {
+		return nil, fmt.Errorf("ttl_secs must be positive")
+	}
+	if p.Alpha <= 0 || p.Alpha > 1 {
+		return nil, fmt.Errorf("alpha must be in (0, 1]")
+	}
+	return &announceDelay{
+		alpha: p.Alpha,
+		seen:  newFirstSeen(time.Duration(p.TTLSecs) * time.Second),
+		peers: make(map[string]float64),
+	}, nil
+}
+
+func (m *announceDelay) Observe(e events.Event) {
+	switch e.Type {
+	case events.TxAnnounced:
+		a := e.Data.(events.TxAnnouncement)
+		delay, _ := m.seen.observe(a.TxHash, e.Time)
+		ms := float64(delay.Milliseconds())
+		if avg, ok := m.peers[a.Peer]; ok {
+			m.peers[a.Peer] = avg + m.alpha*(ms-avg)
+		} else {
+			m.peers[a.Peer] = ms
+		}
+	case events.PeerDisconnected:
+		delete(m.peers, e.Data.(events.PeerInfo).Peer)
+	}
+}
+
+func (m *announceDelay) Scores() []Score {
+	scores := make([]Score, 0, len(m.peers))
+	for peer, avg := range m.peers {
+		scores = append(scores, Score{Peer: peer, Value: avg})
+	}
+	return scores
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	eventBuffer          = 8192
+	defaultScoreInterval = 30
+)
+
+// Model is a peer-scoring or propagation-analysis model fed from the event
+// bus. Observe and Scores are called from a single goroutine, so
+// implementations need no locking of their own.
+type Model interface {
+	// Observe is called for every event published on the bus
+	Observe(e events.Event)
+
+	// Scores returns the model's current per-peer scores
+	Scores() []Score
+}
+
+// Score is one peer's score from a model
+type Score struct {
+	Peer  string  `json:"peer"`
+	Value float64 `json:"value"`
+}
+
+// Factory builds a model from its config params (nil when none are given)
+type Factory func(params json.RawMessage) (Model, error)
+
+// Config enables a registered model
+type Config struct {
+	Name string `json:"name"`
+
+	// Params are passed to the model's factory as-is
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// ScoreIntervalSecs is how often scores are exported (default 30)
+	ScoreIntervalSecs int `json:"score_interval_secs,omitempty"`
+}
+
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes a model available by name. It is meant to be called from an
+// init function in the file defining the model; registering the same name
+// twice panics.
+func Register(name string, f Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.factories[name]; ok {
+		panic("models: Register called twice for " + name)
+	}
+	registry.factories[name] = f
+}
+
+// Names returns the registered model names in sorted order
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// running holds the latest exported scores of each started model
+var running = struct {
+	sync.RWMutex
+	scores map[string][]Score
+}{scores: make(map[string][]Score)}
+
+// Running returns the names of started models in sorted order
+func Running() []string {
+	running.RLock()
+	defer running.RUnlock()
+	names := make([]string, 0, len(running.scores))
+	for name := range running.scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LatestScores returns the scores a started model last exported
+func LatestScores(name string) ([]Score, bool) {
+	running.RLock()
+	defer running.RUnlock()
+	scores, ok := running.scores[name]
+	return scores, ok
+}
+
+// Start builds every configured model and feeds it events until ctx is done.
+// All models are built before any start, so a bad config starts none.
+func Start(ctx context.Context, cfgs []Config) error {
+	type instance struct {
+		cfg   Config
+		model Model
+	}
+
+	var instances []instance
+	seen := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if seen[cfg.Name] {
+			return fmt.Errorf("model %q configured twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		registry.RLock()
+		factory, ok := registry.factories[cfg.Name]
+		registry.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown model %q (registered: %v)", cfg.Name, Names())
+		}
+		m, err := factory(cfg.Params)
+		if err != nil {
+			return fmt.Errorf("model %q: %w", cfg.Name, err)
+		}
+		if cfg.ScoreIntervalSecs <= 0 {
+			cfg.ScoreIntervalSecs = defaultScoreInterval
+		}
+		instances = append(instances, instance{cfg, m})
+	}
+
+	for _, inst := range instances {
+		running.Lock()
+		running.scores[inst.cfg.Name] = nil
+		running.Unlock()
+
+		ch, unsubscribe := events.Subscribe("model:"+inst.cfg.Name, eventBuffer)
+		go run(ctx, inst.cfg, inst.model, ch, unsubscribe)
+	}
+	return nil
+}
+
+func run(ctx context.Context, cfg Config, m Model, ch <-chan events.Event, unsubscribe func()) {
+	defer unsubscribe()
+	ticker := time.NewTicker(time.Duration(cfg.ScoreIntervalSecs) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			m.Observe(e)
+		case <-ticker.C:
+			export(cfg.Name, m.Scores())
+		}
+	}
+}
+
+// export publishes a model's scores, replacing its previous gauge series so
+// peers that dropped out of the model don't linger
+func export(name string, scores []Score) {
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Peer < scores[j].Peer })
+
+	metrics.ModelPeerScore.DeletePartialMatch(prometheus.Labels{"model": name})
+	for _, s := range scores {
+		metrics.ModelPeerScore.WithLabelValues(name, s.Peer).Set(s.Value)
+	}
+
+	running.Lock()
+	running.scores[name] = scores
+	running.Unlock()
+
+	logger.Log.Debug().Str("model", name).Int("peers", len(scores)).Msg("Model scores exported")
+}
+
+// decodeParams unmarshals optional factory params into v, leaving defaults
+// in place when none were given
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package msgstore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	segmentSuffix = ".msgs.gz"
+	// maxFieldSize bounds a record's peer, command or payload when reading
+	// back, so a damaged segment can't trigger a huge allocation
+	maxFieldSize = 32 * 1024 * 1024
+
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Config configures the raw message store
+type Config struct {
+	Dir            string   `json:"dir"`
+	RetentionHours int      `json:"retention_hours"`
+	SegmentMinutes int      `json:"segment_minutes"`
+	MaxMB          int      `json:"max_mb"`
+	Commands       []string `json:"commands,omitempty"` // only store these (default all)
+}
+
+func (c *Config) applyDefaults() {
+	if c.RetentionHours <= 0 {
+		c.RetentionHours = 6
+	}
+	if c.SegmentMinutes <= 0 {
+		c.SegmentMinutes = 10
+	}
+	if c.MaxMB <= 0 {
+		c.MaxMB = 1024
+	}
+}
+
+// Message is one stored wire message
+type Message struct {
+	Time    time.Time `json:"time"`
+	Peer    string    `json:"peer"`
+	Command string    `json:"command"`
+	Payload []byte    `json:"-"`
+}
+
+// Query selects stored messages. Empty fields match everything.
+type Query struct {
+	Peer    string
+	Command string
+	Since   time.Time
+	Until   time.Time
+	Limit   int // default 100, at most 1000
+}
+
+func (q Query) match(m *Message) bool {
+	return (q.Peer == "" || m.Peer == q.Peer) &&
+		(q.Command == "" || m.Command == q.Command) &&
+		(q.Since.IsZero() || !m.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || m.Time.Before(q.Until))
+}
+
+// segment is one gzip file of messages received over SegmentMinutes
+type segment struct {
+	path  string
+	start time.Time
+	end   time.Time
+	size  int64
+	peers map[string]bool // nil for segments left by a previous run
+}
+
+func (s *segment) overlaps(q Query) bool {
+	if !q.Since.IsZero() && s.end.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !s.start.Before(q.Until) {
+		return false
+	}
+	return q.Peer == "" || s.peers == nil || s.peers[q.Peer]
+}
+
+// Store keeps the most recent raw wire messages from every peer as a ring of
+// compressed segment files. Segments older than the retention, or beyond the
+// size cap, are deleted oldest first.
+type Store struct {
+	cfg      Config
+	commands map[string]bool
+
+	mu       sync.Mutex
+	segments []*segment // oldest first; the last one is being written
+	file     *countingFile
+	gz       *gzip.Writer
+}
+
+// Open creates the store directory, picking up segments left by a previous run
+func Open(cfg Config) (*Store, error) {
+	cfg.applyDefaults()
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("message store requires a dir")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{cfg: cfg}
+	if len(cfg.Commands) > 0 {
+		s.commands = make(map[string]bool)
+		for _, c := range cfg.Commands {
+			s.commands[c] = true
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		ms, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &segment{
+			path:  filepath.Join(cfg.Dir, name),
+			start: time.UnixMilli(ms),
+			end:   info.ModTime(),
+			size:  info.Size(),
+		})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].start.Before(s.segments[j].start) })
+
+	s.mu.Lock()
+	s.prune(time.Now())
+	s.mu.Unlock()
+	return s, nil
+}
+
+// Start rolls and prunes segments in the background until ctx is done, then
+// closes the store and marks wg done
+func (s *Store) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := s.Close(); err != nil {
+					logger.Log.Warn().Err(err).Msg("Failed to close message store")
+				}
+				return
+			case now := <-ticker.C:
+				s.mu.Lock()
+				if s.gz != nil && now.Sub(s.current().start) >= s.segmentDuration() {
+					s.seal()
+				}
+				s.prune(now)
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Record stores a message received from peer
+func (s *Store) Record(peer, command string, payload []byte) {
+	if s.commands != nil && !s.commands[command] {
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gz != nil && now.Sub(s.current().start) >= s.segmentDuration() {
+		s.seal()
+	}
+	if s.gz == nil {
+		if err := s.create(now); err != nil {
+			logger.Log.Warn().Err(err).Msg("Failed to create message store segment")
+			return
+		}
+	}
+
+	if err := writeRecord(s.gz, now, peer, command, payload); err != nil {
+		s.failed(err)
+		return
+	}
+
+	seg := s.current()
+	seg.end = now
+	seg.peers[peer] = true
+	seg.size = s.file.size
+	metrics.StoredMessages.Inc()
+}
+
+// Query returns matching messages in the order they were received
+func (s *Store) Query(q Query) ([]Message, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	q.Limit = min(q.Limit, maxLimit)
+
+	s.mu.Lock()
+	if s.gz != nil {
+		// Make the open segment's messages readable
+		if err := s.gz.Flush(); err != nil {
+			s.failed(err)
+		}
+	}
+	var segments []*segment
+	for _, seg := range s.segments {
+		if seg.overlaps(q) {
+			segments = append(segments, seg)
+		}
+	}
+	s.mu.Unlock()
+
+	messages := []Message{}
+	for _, seg := range segments {
+		err := readSegment(seg.path, func(m *Message) bool {
+			if q.match(m) {
+				messages = append(messages, *m)
+			}
+			return len(messages) < q.Limit
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return messages, fmt.Errorf("reading %s: %w", filepath.Base(seg.path), err)
+		}
+		if len(messages) >= q.Limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// Close finishes the segment being written
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gz == nil {
+		return nil
+	}
+	return s.seal()
+}
+
+func (s *Store) segmentDuration() time.Duration {
+	return time.Duration(s.cfg.SegmentMinutes) * time.Minute
+}
+
+// current is the segment being written. Callers hold mu with gz set.
+func (s *Store) current() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Store) create(now time.Time) error {
+	path := filepath.Join(s.cfg.Dir, strconv.FormatInt(now.UnixMilli(), 10)+segmentSuffix)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = &countingFile{File: f}
+	s.gz = gzip.NewWriter(s.file)
+	s.segments = append(s.segments, &segment{path: path, start: now, end: now, peers: make(map[string]bool)})
+	return nil
+}
+
+// seal finishes the current segment so the next message starts a new one
+func (s *Store) seal() error {
+	err := s.gz.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.current().size = s.file.size
+	s.gz, s.file = nil, nil
+	return err
+}
+
+// failed abandons the current segment after a write error; what was written
+// before the error stays readable
+func (s *Store) failed(err error) {
+	logger.Log.Warn().Err(err).Msg("Message store write failed")
+	s.seal()
+}
+
+// prune deletes sealed segments past the retention, then the oldest ones
+// until the store fits its size cap. Callers hold mu.
+func (s *Store) prune(now time.Time) {
+	cutoff := now.Add(-time.Duration(s.cfg.RetentionHours) * time.Hour)
+	maxBytes := int64(s.cfg.MaxMB) * 1024 * 1024
+
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	sealed := len(s.segments)
+	if s.gz != nil {
+		sealed--
+	}
+	drop := 0
+	for drop < sealed {
+		seg := s.segments[drop]
+		if !seg.end.Before(cutoff) && total <= maxBytes {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Log.Warn().Err(err).Str("path", seg.path).Msg("Failed to delete message store segment")
+		}
+		total -= seg.size
+		drop++
+	}
+	s.segments = s.segments[drop:]
+	metrics.MessageStoreBytes.Set(float64(total))
+}
+
+// Records are the receive time (unix nanoseconds, 8 bytes little-endian),
+// then the peer, command and payload, each prefixed with its uvarint length
+func writeRecord(w io.Writer, t time.Time, peer, command string, payload []byte) error {
+	buf := make([]byte, 0, 8+3*binary.MaxVarintLen64+len(peer)+len(command)+len(payload))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(t.UnixNano()))
+	buf = binary.AppendUvarint(buf, uint64(len(peer)))
+	buf = append(buf, peer...)
+	buf = binary.AppendUvarint(buf, uint64(len(command)))
+	buf = append(buf, command...)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readSegment calls fn for each message in a segment until fn returns false.
+// A segment still being written ends without a gzip trailer; the messages
+// flushed so far are read and the missing trailer is not an error.
+func readSegment(path string, fn func(*Message) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	r := bufio.NewReader(gz)
+	for {
+		m, err := readRecord(r)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(m) {
+			return nil
+		}
+	}
+}
+
+func readRecord(r *bufio.Reader) (*Message, error) {
+	var ts [8]byte
+	if _, err := io.ReadFull(r, ts[:]); err != nil {
+		return nil, err
+	}
+	m := &Message{Time: time.Unix(0, int64(binary.LittleEndian.Uint64(ts[:])))}
+	fields := make([][]byte, 3)
+	for i := range fields {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if n > maxFieldSize {
+			return nil, fmt.Errorf("record field of %d bytes", n)
+		}
+		fields[i] = make([]byte, n)
+		if _, err := io.ReadFull(r, fields[i]); err != nil {
+			return nil, noEOF(err)
+		}
+	}
+	m.Peer, m.Command, m.Payload = string(fields[0]), string(fields[1]), fields[2]
+	return m, nil
+}
+
+// noEOF reports a record cut short as truncated rather than cleanly ended
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countingFile tracks how many bytes have been written to a segment
+type countingFile struct {
+	*os.File
+	size int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.size += int64(n)
+	return n, err
+}
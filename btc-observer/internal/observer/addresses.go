@@ -0,0 +1,32 @@
+package observer
+
+import (
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// recordAddresses stores the addresses gossiped in an addr or addrv2 message.
+// Entries parsed before a malformed one are kept.
+func recordAddresses(command string, payload []byte, peerAddr string, plog zerolog.Logger, db database.Storage) {
+	var addrs []protocol.NetAddress
+	var err error
+	if command == "addrv2" {
+		addrs, err = protocol.ParseAddrV2Message(payload)
+	} else {
+		addrs, err = protocol.ParseAddrEntries(payload)
+	}
+	if err != nil {
+		recordParseError(command, payload, err, peerAddr, plog, db)
+	}
+
+	for _, a := range addrs {
+		metrics.AddressesReceived.WithLabelValues(a.Network).Inc()
+	}
+	if err := db.RecordPeerAddresses(peerAddr, addrs); err != nil {
+		logger.Error(plog, err, "DB RecordPeerAddresses error")
+	}
+	enqueueCrawl(addrs)
+}
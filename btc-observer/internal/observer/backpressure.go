@@ -0,0 +1,268 @@
+package observer
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// PressureLevel describes how saturated the ingest pipeline is. Each level
+// includes the responses of the levels below it.
+type PressureLevel int32
+
+const (
+	PressureNormal       PressureLevel = iota
+	PressureFeeFilter                  // ask peers to stop announcing low-fee txs
+	PressurePauseGetData               // stop requesting tx bodies
+	PressureShedPeers                  // disconnect the chattiest peers
+)
+
+func (l PressureLevel) String() string {
+	switch l {
+	case PressureFeeFilter:
+		return "feefilter"
+	case PressurePauseGetData:
+		return "pause_getdata"
+	case PressureShedPeers:
+		return "shed_peers"
+	default:
+		return "normal"
+	}
+}
+
+// PressureEvent is the payload of events.PressureChanged
+type PressureEvent struct {
+	Level    PressureLevel `json:"level"`
+	Previous PressureLevel `json:"previous"`
+	Reason   string        `json:"reason"`
+}
+
+// BackpressureConfig holds the thresholds for backpressure signaling
+type BackpressureConfig struct {
+	FeeFilterSatPerKB  int64   `json:"fee_filter_sat_per_kb"`
+	FeeFilterLatencyMs int     `json:"fee_filter_latency_ms"`
+	PauseLatencyMs     int     `json:"pause_latency_ms"`
+	ShedLatencyMs      int     `json:"shed_latency_ms"`
+	ShedFraction       float64 `json:"shed_fraction"`
+}
+
+func (c *BackpressureConfig) applyDefaults() {
+	if c.FeeFilterSatPerKB <= 0 {
+		c.FeeFilterSatPerKB = 100000
+	}
+	if c.FeeFilterLatencyMs <= 0 {
+		c.FeeFilterLatencyMs = 250
+	}
+	if c.PauseLatencyMs <= 0 {
+		c.PauseLatencyMs = 1000
+	}
+	if c.ShedLatencyMs <= 0 {
+		c.ShedLatencyMs = 3000
+	}
+	if c.ShedFraction <= 0 || c.ShedFraction > 1 {
+		c.ShedFraction = 0.25
+	}
+}
+
+const (
+	pressureCheckInterval = 5 * time.Second
+	// writeLatencyAlpha is the smoothing factor of the write latency average
+	writeLatencyAlpha = 0.1
+	// writeLatencyHalfLife is how fast the average decays once writes stop
+	// being sampled, so a pause that stops the slow writes can still lift
+	writeLatencyHalfLife = 30 * time.Second
+)
+
+// pressure is the level last applied by the controller
+var pressure atomic.Int32
+
+// feeFilterRate is the feefilter currently in effect, sent to new peers too
+var feeFilterRate atomic.Int64
+
+var writeLatency = struct {
+	sync.Mutex
+	ewma    time.Duration
+	sampled time.Time
+}{}
+
+// recordWriteLatency feeds a DB write duration into the moving average
+func recordWriteLatency(d time.Duration) {
+	now := time.Now()
+	writeLatency.Lock()
+	if writeLatency.ewma == 0 {
+		writeLatency.ewma = d
+	} else {
+		ewma := decayedWriteLatency(now)
+		writeLatency.ewma = ewma + time.Duration(writeLatencyAlpha*float64(d-ewma))
+	}
+	writeLatency.sampled = now
+	avg := writeLatency.ewma
+	writeLatency.Unlock()
+	metrics.DBWriteLatency.Set(avg.Seconds())
+}
+
+// decayedWriteLatency is the average halved for every writeLatencyHalfLife
+// since the last sample; the caller holds the lock
+func decayedWriteLatency(now time.Time) time.Duration {
+	idle := now.Sub(writeLatency.sampled)
+	if idle <= 0 {
+		return writeLatency.ewma
+	}
+	return time.Duration(float64(writeLatency.ewma) * math.Pow(0.5, float64(idle)/float64(writeLatencyHalfLife)))
+}
+
+func currentWriteLatency() time.Duration {
+	writeLatency.Lock()
+	defer writeLatency.Unlock()
+	return decayedWriteLatency(time.Now())
+}
+
+// CurrentPressure returns the backpressure level currently in effect
+func CurrentPressure() PressureLevel {
+	return PressureLevel(pressure.Load())
+}
+
+func getdataPaused() bool {
+	return CurrentPressure() >= PressurePauseGetData
+}
+
+// StartBackpressure starts the pressure monitor, which publishes level changes
+// on the event bus, and the controller, which reacts to them by signaling peers
+func StartBackpressure(ctx context.Context, cfg BackpressureConfig) {
+	cfg.applyDefaults()
+
+	ch, unsubscribe := events.Subscribe("backpressure", 16)
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-ch:
+				if pe, ok := e.Data.(PressureEvent); ok && e.Type == events.PressureChanged {
+					applyPressure(pe, cfg)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(pressureCheckInterval)
+		defer ticker.Stop()
+		level := PressureNormal
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				target, reason := measurePressure(cfg)
+				// Step down one level at a time so relief is gradual
+				if target < level {
+					target = level - 1
+				}
+				if target != level {
+					events.Publish(events.PressureChanged, PressureEvent{Level: target, Previous: level, Reason: reason})
+					level = target
+				}
+			}
+		}
+	}()
+}
+
+func measurePressure(cfg BackpressureConfig) (PressureLevel, string) {
+	latency := currentWriteLatency()
+	metrics.DBWriteLatency.Set(latency.Seconds())
+	switch {
+	case latency >= time.Duration(cfg.ShedLatencyMs)*time.Millisecond:
+		return PressureShedPeers, "db_latency"
+	case Shedding():
+		return PressurePauseGetData, "memory"
+	case latency >= time.Duration(cfg.PauseLatencyMs)*time.Millisecond:
+		return PressurePauseGetData, "db_latency"
+	case latency >= time.Duration(cfg.FeeFilterLatencyMs)*time.Millisecond:
+		return PressureFeeFilter, "db_latency"
+	}
+	return PressureNormal, ""
+}
+
+func applyPressure(e PressureEvent, cfg BackpressureConfig) {
+	pressure.Store(int32(e.Level))
+	metrics.PressureLevel.Set(float64(e.Level))
+
+	logger.Log.Warn().
+		Str("level", e.Level.String()).
+		Str("previous", e.Previous.String()).
+		Str("reason", e.Reason).
+		Dur("db_write_latency", currentWriteLatency()).
+		Msg("Backpressure level changed")
+
+	switch {
+	case e.Level >= PressureFeeFilter && e.Previous < PressureFeeFilter:
+		broadcastFeeFilter(cfg.FeeFilterSatPerKB)
+	case e.Level < PressureFeeFilter && e.Previous >= PressureFeeFilter:
+		broadcastFeeFilter(0)
+	}
+
+	if e.Level >= PressureShedPeers {
+		disconnectChattiest(cfg.ShedFraction)
+	}
+}
+
+func sendFeeFilter(conn net.Conn, feeRate int64) error {
+	packet := protocol.CreateMessagePacket("feefilter", protocol.CreateFeeFilterPayload(feeRate))
+	_, err := conn.Write(packet)
+	return err
+}
+
+func broadcastFeeFilter(feeRate int64) {
+	feeFilterRate.Store(feeRate)
+	activeConns.Lock()
+	defer activeConns.Unlock()
+	for conn := range activeConns.conns {
+		sendFeeFilter(conn, feeRate)
+	}
+	logger.Log.Info().Int64("sat_per_kb", feeRate).Int("peers", len(activeConns.conns)).Msg("Sent feefilter")
+}
+
+// disconnectChattiest closes the given fraction of connections with the
+// highest inv announcement rate
+func disconnectChattiest(fraction float64) {
+	type peerRate struct {
+		conn net.Conn
+		rate float64
+	}
+
+	activeConns.Lock()
+	defer activeConns.Unlock()
+
+	rates := make([]peerRate, 0, len(activeConns.conns))
+	for conn, stats := range activeConns.conns {
+		rates = append(rates, peerRate{conn: conn, rate: stats.invRate()})
+	}
+	if len(rates) == 0 {
+		return
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].rate > rates[j].rate })
+
+	n := int(float64(len(rates)) * fraction)
+	if n < 1 {
+		n = 1
+	}
+	for _, r := range rates[:n] {
+		logger.Log.Warn().
+			Str("peer", r.conn.RemoteAddr().String()).
+			Float64("inv_per_sec", r.rate).
+			Msg("Disconnecting chatty peer under backpressure")
+		r.conn.Close()
+	}
+	metrics.PeersShed.Add(float64(n))
+}
@@ -0,0 +1,145 @@
+package observer
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// BandwidthConfig caps peer traffic in KB/s. Zero leaves a direction
+// unlimited.
+type BandwidthConfig struct {
+	PeerReadKBps    int `json:"peer_read_kbps"`
+	PeerWriteKBps   int `json:"peer_write_kbps"`
+	GlobalReadKBps  int `json:"global_read_kbps"`
+	GlobalWriteKBps int `json:"global_write_kbps"`
+}
+
+// tokenBucket is a rate limiter that lets a caller go into debt: a take is
+// never refused, it returns how long to wait for the bucket to recover. A
+// nil bucket is unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(kbps int) *tokenBucket {
+	if kbps <= 0 {
+		return nil
+	}
+	rate := float64(kbps) * 1024
+	return &tokenBucket{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take spends n bytes and returns the wait until the bucket is out of debt
+func (b *tokenBucket) take(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// exhausted reports whether the bucket is spent
+func (b *tokenBucket) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens <= 0
+}
+
+// bandwidthLimits holds the configuration and the shared global buckets
+type bandwidthLimits struct {
+	cfg                     BandwidthConfig
+	globalRead, globalWrite *tokenBucket
+}
+
+var bandwidth atomic.Pointer[bandwidthLimits]
+
+// SetBandwidth applies bandwidth caps to peers connected from now on. The
+// global caps are shared by all of them.
+func SetBandwidth(cfg BandwidthConfig) {
+	bandwidth.Store(&bandwidthLimits{
+		cfg:         cfg,
+		globalRead:  newTokenBucket(cfg.GlobalReadKBps),
+		globalWrite: newTokenBucket(cfg.GlobalWriteKBps),
+	})
+}
+
+// limitedConn throttles a peer connection. Reads are charged after the fact,
+// so a large message delays the next read rather than being cut short.
+type limitedConn struct {
+	net.Conn
+	limits      *bandwidthLimits
+	read, write *tokenBucket
+}
+
+// limitConn wraps conn in the configured bandwidth caps, if any
+func limitConn(conn net.Conn) net.Conn {
+	limits := bandwidth.Load()
+	if limits == nil {
+		return conn
+	}
+	return &limitedConn{
+		Conn:   conn,
+		limits: limits,
+		read:   newTokenBucket(limits.cfg.PeerReadKBps),
+		write:  newTokenBucket(limits.cfg.PeerWriteKBps),
+	}
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		throttle("read", max(c.read.take(n), c.limits.globalRead.take(n)))
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	throttle("write", max(c.write.take(len(p)), c.limits.globalWrite.take(len(p))))
+	return c.Conn.Write(p)
+}
+
+// saturated reports whether the peer or global read budget is spent, in
+// which case tx bodies aren't requested so the link is left to inv traffic
+func (c *limitedConn) saturated() bool {
+	return c.read.exhausted() || c.limits.globalRead.exhausted()
+}
+
+func throttle(direction string, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	metrics.BandwidthThrottled.WithLabelValues(direction).Add(wait.Seconds())
+	time.Sleep(wait)
+}
+
+// bandwidthSaturated reports whether conn is over its read budget
+func bandwidthSaturated(conn net.Conn) bool {
+	if c, ok := conn.(*limitedConn); ok {
+		return c.saturated()
+	}
+	return false
+}
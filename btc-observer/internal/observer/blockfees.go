@@ -0,0 +1,196 @@
+package observer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// PrevoutConfig resolves the prevouts of block transactions the database
+// doesn't know, typically coins created before the observer started, from a
+// Bitcoin Core node's RPC. It uses getblock verbosity 3 (Core 25+), one call
+// per block that needs it.
+type PrevoutConfig struct {
+	RPCURL      string `json:"rpc_url"`
+	RPCUser     string `json:"rpc_user"`
+	RPCPassword string `json:"rpc_password"`
+	TimeoutMs   int    `json:"timeout_ms"` // default 30000
+}
+
+// prevoutRPC is the configured prevout source
+type prevoutRPC struct {
+	PrevoutConfig
+	rpc *rpcClient
+}
+
+var (
+	prevoutSource atomic.Pointer[prevoutRPC]
+
+	// resolvingBlocks holds blocks whose prevouts are being fetched, so a
+	// block delivered twice is only fetched once
+	resolvingBlocks sync.Map
+)
+
+// SetPrevoutSource resolves unknown block prevouts over the node's RPC
+func SetPrevoutSource(cfg PrevoutConfig) error {
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 30000
+	}
+	rpc, err := newRPCClient(cfg.RPCURL, cfg.RPCUser, cfg.RPCPassword, time.Duration(cfg.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("prevouts: %w", err)
+	}
+	prevoutSource.Store(&prevoutRPC{PrevoutConfig: cfg, rpc: rpc})
+	return nil
+}
+
+// accountBlockFees totals a block's fees and subsidy. fees holds each
+// transaction's fee as recorded, nil where a prevout was unknown; those are
+// resolved over RPC, off the peer's goroutine, when a source is configured.
+func accountBlockFees(block *protocol.Block, fees []*database.Fee, plog zerolog.Logger, db database.Storage) {
+	if block.ParseError != "" || len(block.Transactions) == 0 {
+		return
+	}
+	var unresolved []int
+	for i := 1; i < len(fees); i++ {
+		if fees[i] == nil {
+			unresolved = append(unresolved, i)
+		}
+	}
+	src := prevoutSource.Load()
+	if len(unresolved) == 0 || src == nil {
+		recordBlockFees(block, fees, plog, db)
+		return
+	}
+	if _, busy := resolvingBlocks.LoadOrStore(block.BlockHash, true); busy {
+		return
+	}
+	go func() {
+		defer resolvingBlocks.Delete(block.BlockHash)
+		values, err := src.blockPrevouts(block.BlockHash)
+		if err != nil {
+			logger.Error(plog, err, "Prevout resolution failed")
+		}
+		for _, i := range unresolved {
+			tx := block.Transactions[i]
+			v, ok := values[tx.TxID]
+			if !ok {
+				continue
+			}
+			fee, err := db.RecordInputValues(tx, v)
+			if err != nil {
+				logger.Error(plog, err, "DB RecordInputValues error")
+				continue
+			}
+			fees[i] = fee
+		}
+		recordBlockFees(block, fees, plog, db)
+	}()
+}
+
+func recordBlockFees(block *protocol.Block, fees []*database.Fee, plog zerolog.Logger, db database.Storage) {
+	f := database.BlockFees{Subsidy: protocol.ActiveNetwork().Subsidy(block.Height)}
+	for _, out := range block.Transactions[0].Outputs {
+		f.CoinbaseValue += out.Value
+	}
+	for _, fee := range fees[1:] {
+		if fee == nil {
+			f.UnresolvedTxs++
+			continue
+		}
+		f.TotalFees += fee.Satoshis
+	}
+
+	metrics.BlockSubsidy.Set(float64(f.Subsidy))
+	if f.UnresolvedTxs == 0 {
+		metrics.BlockFees.Observe(float64(f.TotalFees))
+	} else {
+		metrics.BlockFeesUnresolved.Inc()
+	}
+	plog.Debug().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Int64("subsidy", f.Subsidy).
+		Int64("coinbase", f.CoinbaseValue).
+		Int64("fees", f.TotalFees).
+		Int("unresolved_txs", f.UnresolvedTxs).
+		Msg("Block fees")
+	if err := db.RecordBlockFees(block.BlockHash[:], f); err != nil {
+		logger.Error(plog, err, "DB RecordBlockFees error")
+	}
+	analyzeBlockOrdering(block, fees, plog, db)
+}
+
+// blockPrevouts fetches a block with its prevouts, returning each
+// non-coinbase transaction's input values in input order
+func (r *prevoutRPC) blockPrevouts(hash [32]byte) (map[[32]byte][]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.TimeoutMs)*time.Millisecond)
+	defer cancel()
+	var block struct {
+		Tx []struct {
+			TxID string `json:"txid"`
+			Vin  []struct {
+				Coinbase string `json:"coinbase"`
+				Prevout  *struct {
+					Value json.Number `json:"value"`
+				} `json:"prevout"`
+			} `json:"vin"`
+		} `json:"tx"`
+	}
+	params := []interface{}{fmt.Sprintf("%x", protocol.ReverseBytes(hash[:])), 3}
+	if err := r.rpc.call(ctx, "getblock", params, &block); err != nil {
+		return nil, err
+	}
+
+	values := make(map[[32]byte][]int64, len(block.Tx))
+txs:
+	for _, tx := range block.Tx {
+		raw, err := hex.DecodeString(tx.TxID)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("getblock: invalid txid %q", tx.TxID)
+		}
+		txid := [32]byte(protocol.ReverseBytes(raw))
+		v := make([]int64, len(tx.Vin))
+		for i, in := range tx.Vin {
+			if in.Coinbase != "" || in.Prevout == nil {
+				continue txs
+			}
+			if v[i], err = btcToSats(in.Prevout.Value); err != nil {
+				return nil, fmt.Errorf("getblock: tx %s input %d: %w", tx.TxID, i, err)
+			}
+		}
+		values[txid] = v
+	}
+	return values, nil
+}
+
+// btcToSats converts an RPC amount in BTC to satoshis without going through
+// a float where it can be avoided
+func btcToSats(n json.Number) (int64, error) {
+	s := n.String()
+	whole, frac, _ := strings.Cut(s, ".")
+	if strings.ContainsAny(s, "eE") || len(frac) > 8 {
+		f, err := n.Float64()
+		if err != nil {
+			return 0, err
+		}
+		return int64(math.Round(f * 1e8)), nil
+	}
+	sats, err := strconv.ParseInt(whole+frac+strings.Repeat("0", 8-len(frac)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q: %w", s, err)
+	}
+	return sats, nil
+}
@@ -0,0 +1,81 @@
+package observer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// Routes by which a block reaches us from a peer
+const (
+	arrivalInv       = "inv"
+	arrivalHeaders   = "headers"
+	arrivalCmpctPush = "cmpctblock"
+)
+
+// recentArrivalBlocks is how many blocks' per-region arrivals are kept
+const recentArrivalBlocks = 64
+
+// blockArrival is when a block first arrived, and from which regions and
+// peers since
+type blockArrival struct {
+	first       time.Time
+	firstRegion string
+	regions     map[string]bool
+	peers       map[string]bool
+}
+
+// blockArrivals tracks recent blocks' first arrival from each peer region,
+// for the inter-region delay metric
+var blockArrivals = struct {
+	sync.Mutex
+	blocks map[[32]byte]*blockArrival
+	order  [][32]byte
+}{blocks: make(map[[32]byte]*blockArrival)}
+
+// noteBlockArrival records a peer making a block known to us, its delay in
+// the peer's latency profile and, the first time a region sees the block,
+// how long after the first region it did. A peer excluded for contradicting
+// its claims only has the arrival recorded.
+func noteBlockArrival(hash [32]byte, peerAddr, region, via string, plog zerolog.Logger, db database.Storage) {
+	if err := db.RecordBlockArrival(hash[:], peerAddr, via); err != nil {
+		logger.Error(plog, err, "DB RecordBlockArrival error")
+	}
+	if claimsExcluded(peerAddr) {
+		return
+	}
+
+	now := time.Now()
+	blockArrivals.Lock()
+	defer blockArrivals.Unlock()
+	a := blockArrivals.blocks[hash]
+	if a == nil {
+		blockArrivals.blocks[hash] = &blockArrival{
+			first:       now,
+			firstRegion: region,
+			regions:     map[string]bool{region: true},
+			peers:       map[string]bool{peerAddr: true},
+		}
+		observeLatency(peerAddr, latencyBlock, 0)
+		blockArrivals.order = append(blockArrivals.order, hash)
+		if len(blockArrivals.order) > recentArrivalBlocks {
+			delete(blockArrivals.blocks, blockArrivals.order[0])
+			blockArrivals.order = blockArrivals.order[1:]
+		}
+		return
+	}
+	if !a.peers[peerAddr] {
+		a.peers[peerAddr] = true
+		observeLatency(peerAddr, latencyBlock, now.Sub(a.first))
+		noteStaleBlock(peerAddr, now.Sub(a.first))
+	}
+	if a.regions[region] {
+		return
+	}
+	a.regions[region] = true
+	metrics.BlockRegionDelay.WithLabelValues(a.firstRegion, region).Observe(now.Sub(a.first).Seconds())
+}
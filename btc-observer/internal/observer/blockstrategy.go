@@ -0,0 +1,142 @@
+package observer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/features"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// BlockDownloadConfig picks which regions download block bodies. Peers in
+// other regions still have their block announcements recorded, but blocks
+// are only requested from them as a fallback.
+type BlockDownloadConfig struct {
+	// FullRegions download full blocks; every other region is headers-only.
+	// Empty means every region downloads.
+	FullRegions []string `json:"full_regions"`
+
+	// FallbackSeconds is how long a block announced only by headers-only
+	// peers waits for a full region before one of them is asked for it
+	// (default 30)
+	FallbackSeconds int `json:"fallback_seconds"`
+}
+
+// blockStrategy is the parsed BlockDownloadConfig
+type blockStrategy struct {
+	full     map[string]bool
+	fallback time.Duration
+}
+
+var currentBlockStrategy atomic.Pointer[blockStrategy]
+
+// downloadsBlocks reports whether peers in region are asked for block bodies
+// as soon as they announce them
+func downloadsBlocks(region string) bool {
+	s := currentBlockStrategy.Load()
+	return s == nil || s.full[region]
+}
+
+// deferredBlocks holds blocks announced only by headers-only peers, with the
+// connections that announced them
+var deferredBlocks = struct {
+	sync.Mutex
+	m map[[32]byte]*deferredBlock
+}{m: make(map[[32]byte]*deferredBlock)}
+
+type deferredBlock struct {
+	since time.Time
+	conns []net.Conn
+}
+
+// maxDeferredConns bounds the fallback candidates kept per block
+const maxDeferredConns = 3
+
+// deferBlock notes a headers-only peer's announcement of a block nobody has
+// been asked for yet
+func deferBlock(conn net.Conn, hash [32]byte) {
+	if blockSeen(hash) {
+		return
+	}
+	deferredBlocks.Lock()
+	defer deferredBlocks.Unlock()
+	d := deferredBlocks.m[hash]
+	if d == nil {
+		d = &deferredBlock{since: time.Now()}
+		deferredBlocks.m[hash] = d
+	}
+	if len(d.conns) < maxDeferredConns {
+		d.conns = append(d.conns, conn)
+	}
+}
+
+// StartBlockStrategy limits block downloads to the configured regions and
+// runs the fallback for blocks no full region announces in time
+func StartBlockStrategy(ctx context.Context, cfg BlockDownloadConfig) {
+	if len(cfg.FullRegions) == 0 {
+		return
+	}
+	if cfg.FallbackSeconds <= 0 {
+		cfg.FallbackSeconds = 30
+	}
+	s := &blockStrategy{full: make(map[string]bool), fallback: time.Duration(cfg.FallbackSeconds) * time.Second}
+	for _, r := range cfg.FullRegions {
+		s.full[r] = true
+	}
+	currentBlockStrategy.Store(s)
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if features.Enabled(features.BlockDownloads) {
+					requestDeferredBlocks(s.fallback)
+				}
+			}
+		}
+	}()
+}
+
+// requestDeferredBlocks drops deferred blocks a full region has since
+// requested and asks a headers-only peer for the rest once they're overdue
+func requestDeferredBlocks(fallback time.Duration) {
+	deferredBlocks.Lock()
+	due := make(map[[32]byte]*deferredBlock)
+	for hash, d := range deferredBlocks.m {
+		if blockSeen(hash) {
+			delete(deferredBlocks.m, hash)
+		} else if time.Since(d.since) >= fallback {
+			due[hash] = d
+			delete(deferredBlocks.m, hash)
+		}
+	}
+	deferredBlocks.Unlock()
+
+	for hash, d := range due {
+		if !MarkSeenBlock(hash) {
+			continue
+		}
+		packet := protocol.CreateMessagePacket("getdata", protocol.CreateGetDataPayload([]protocol.InvVector{{Type: 2, Hash: hash}}))
+		sent := false
+		for _, conn := range d.conns {
+			if _, err := conn.Write(packet); err == nil {
+				sent = true
+				break
+			}
+		}
+		if sent {
+			metrics.BlockFallbackRequests.Inc()
+		} else {
+			// Every announcer has gone; let the next announcement request it
+			ForgetSeenBlock(hash)
+		}
+	}
+}
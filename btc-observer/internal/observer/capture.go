@@ -0,0 +1,136 @@
+package observer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/msgstore"
+	"github.com/rs/zerolog"
+)
+
+const (
+	peerLogMaxBytes   = 10 * 1024 * 1024
+	peerLogMaxBackups = 3
+)
+
+// debugCaptures tracks peers with message-level debug capture enabled (addr -> expiry)
+var debugCaptures = struct {
+	sync.RWMutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// peerLogDir, when set, sends each captured peer's trace to its own rotating file
+var peerLogDir string
+
+// SetPeerLogDir enables per-peer log files for debug captures
+func SetPeerLogDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	peerLogDir = dir
+	return nil
+}
+
+// messageStore, when set, keeps the recent raw messages of every peer
+var messageStore atomic.Pointer[msgstore.Store]
+
+// SetMessageStore stores every message received from peers in s
+func SetMessageStore(s *msgstore.Store) {
+	messageStore.Store(s)
+}
+
+// MessageStore returns the raw message store, or nil if it is disabled
+func MessageStore() *msgstore.Store {
+	return messageStore.Load()
+}
+
+func storeMessage(addr, command string, payload []byte) {
+	if s := messageStore.Load(); s != nil {
+		s.Record(addr, command, payload)
+	}
+}
+
+// EnableCapture turns on message-level debug capture for a peer for the given duration
+func EnableCapture(addr string, d time.Duration) {
+	debugCaptures.Lock()
+	defer debugCaptures.Unlock()
+	debugCaptures.m[addr] = time.Now().Add(d)
+}
+
+// DisableCapture turns off debug capture for a peer
+func DisableCapture(addr string) {
+	debugCaptures.Lock()
+	defer debugCaptures.Unlock()
+	delete(debugCaptures.m, addr)
+}
+
+func captureEnabled(addr string) bool {
+	debugCaptures.RLock()
+	expiry, ok := debugCaptures.m[addr]
+	debugCaptures.RUnlock()
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		DisableCapture(addr)
+		return false
+	}
+	return true
+}
+
+// peerTrace writes message-level trace lines for a peer while capture is enabled.
+// Trace lines bypass the global log level so debug logging stays off elsewhere.
+type peerTrace struct {
+	addr string
+	plog zerolog.Logger
+	file *logger.RotatingFile
+	flog zerolog.Logger
+}
+
+func newPeerTrace(addr string, plog zerolog.Logger) *peerTrace {
+	return &peerTrace{addr: addr, plog: plog}
+}
+
+// Message records a received message, including its full payload, if capture
+// is enabled for the peer
+func (t *peerTrace) Message(command string, payload []byte) {
+	if !captureEnabled(t.addr) {
+		t.Close()
+		return
+	}
+	t.logger().Log().
+		Str("command", command).
+		Int("size", len(payload)).
+		Hex("payload", payload).
+		Msg("Message")
+}
+
+func (t *peerTrace) logger() *zerolog.Logger {
+	if peerLogDir == "" {
+		return &t.plog
+	}
+	if t.file == nil {
+		name := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(t.addr) + ".log"
+		f, err := logger.NewRotatingFile(filepath.Join(peerLogDir, name), peerLogMaxBytes, peerLogMaxBackups)
+		if err != nil {
+			t.plog.Warn().Err(err).Msg("Failed to open peer log file")
+			return &t.plog
+		}
+		t.file = f
+		t.flog = zerolog.New(f).With().Timestamp().Str("peer", t.addr).Logger()
+	}
+	return &t.flog
+}
+
+// Close releases the peer's log file, if open
+func (t *peerTrace) Close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
@@ -0,0 +1,352 @@
+package observer
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Ways a peer's behavior can contradict what it claimed
+const (
+	DivergenceStartHeight = "start_height"       // claimed a start height past our best chain
+	DivergenceBlocks      = "blocks_not_served"  // advertises block service but doesn't deliver requested blocks
+	DivergenceHeaders     = "headers_unanswered" // advertises block service but doesn't answer getheaders
+	DivergenceFeeFilter   = "fee_filter"         // relays transactions below its own fee filter
+)
+
+// nodeNetworkLimited is the BIP159 service bit for peers serving the last
+// 288 blocks
+const nodeNetworkLimited = 1 << 10
+
+// ClaimCheckConfig compares what peers claim (services, start height, fee
+// filter) with what they do, reporting every interval. A peer diverging in
+// ExcludeAfter reports in a row is excluded from latency profiles and the
+// block propagation measurements until as many clean reports in a row.
+type ClaimCheckConfig struct {
+	IntervalMinutes int     `json:"interval_minutes"` // between reports (default 60)
+	HeightSlack     int32   `json:"height_slack"`     // blocks a start height may run past our best chain (default 6)
+	MinSamples      int     `json:"min_samples"`      // requests or transactions before serving and relay are judged (default 5)
+	MinServedRatio  float64 `json:"min_served_ratio"` // share of requested blocks and headers a serving peer must answer (default 0.5)
+	FeeFilterSlack  float64 `json:"fee_filter_slack"` // fraction below its fee filter a transaction may pay, for filter rounding (default 0.2)
+	ExcludeAfter    int     `json:"exclude_after"`    // reports in a row before a peer is excluded or readmitted (default 3)
+}
+
+func (c *ClaimCheckConfig) applyDefaults() {
+	if c.IntervalMinutes <= 0 {
+		c.IntervalMinutes = 60
+	}
+	if c.HeightSlack <= 0 {
+		c.HeightSlack = 6
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = 5
+	}
+	if c.MinServedRatio <= 0 || c.MinServedRatio > 1 {
+		c.MinServedRatio = 0.5
+	}
+	if c.FeeFilterSlack <= 0 || c.FeeFilterSlack >= 1 {
+		c.FeeFilterSlack = 0.2
+	}
+	if c.ExcludeAfter <= 0 {
+		c.ExcludeAfter = 3
+	}
+}
+
+// peerClaims is what a peer claimed and how it behaved since the last
+// report. The claims persist across reports; the behavior counts reset.
+type peerClaims struct {
+	region      string
+	services    uint64
+	startHeight int32
+	feeFilter   int64 // sat/kB
+	connected   bool
+
+	blocksRequested, blocksServed, blocksNotFound int
+	headersAsked, headersAnswered                 int
+	txsRelayed, txsBelowFilter                    int
+
+	streak, cleanStreak int
+	excluded            bool
+}
+
+// servesBlocks reports whether the peer advertises serving at least recent
+// blocks
+func (c *peerClaims) servesBlocks() bool {
+	return c.services&(protocol.ServicesNodeNetwork|nodeNetworkLimited) != 0
+}
+
+// divergences returns where the peer's behavior contradicted its claims,
+// and whether there was enough behavior to judge it at all
+func (c *peerClaims) divergences(cfg *ClaimCheckConfig, best int32) (kinds []string, judged bool) {
+	if best > 0 && c.startHeight > 0 {
+		judged = true
+		if c.startHeight > best+cfg.HeightSlack {
+			kinds = append(kinds, DivergenceStartHeight)
+		}
+	}
+	if c.servesBlocks() && c.blocksRequested >= cfg.MinSamples {
+		judged = true
+		served := min(c.blocksServed, c.blocksRequested)
+		if float64(served) < cfg.MinServedRatio*float64(c.blocksRequested) {
+			kinds = append(kinds, DivergenceBlocks)
+		}
+	}
+	if c.servesBlocks() && c.headersAsked >= cfg.MinSamples {
+		judged = true
+		answered := min(c.headersAnswered, c.headersAsked)
+		if float64(answered) < cfg.MinServedRatio*float64(c.headersAsked) {
+			kinds = append(kinds, DivergenceHeaders)
+		}
+	}
+	if c.feeFilter > 0 && c.txsRelayed >= cfg.MinSamples {
+		judged = true
+		if c.txsBelowFilter >= cfg.MinSamples {
+			kinds = append(kinds, DivergenceFeeFilter)
+		}
+	}
+	return kinds, judged
+}
+
+// claimChecks holds every checked peer's claims by address, while enabled
+var claimChecks = struct {
+	sync.Mutex
+	enabled bool
+	slack   float64
+	peers   map[string]*peerClaims
+}{peers: make(map[string]*peerClaims)}
+
+// updateClaims applies fn to a peer's claims, creating them, when claim
+// checks are enabled
+func updateClaims(peerAddr string, fn func(c *peerClaims)) {
+	claimChecks.Lock()
+	defer claimChecks.Unlock()
+	if !claimChecks.enabled {
+		return
+	}
+	c := claimChecks.peers[peerAddr]
+	if c == nil {
+		c = &peerClaims{}
+		claimChecks.peers[peerAddr] = c
+	}
+	fn(c)
+}
+
+// noteVersionClaims records the services and start height a peer claimed in
+// its version message
+func noteVersionClaims(peerAddr, region string, v *protocol.VersionMessage) {
+	updateClaims(peerAddr, func(c *peerClaims) {
+		c.region = region
+		c.services = v.Services
+		c.startHeight = v.StartHeight
+		c.feeFilter = 0
+		c.connected = true
+	})
+}
+
+// noteFeeFilter records the fee rate below which a peer said it won't relay
+func noteFeeFilter(peerAddr string, payload []byte) {
+	if len(payload) < 8 {
+		return
+	}
+	rate := int64(binary.LittleEndian.Uint64(payload))
+	updateClaims(peerAddr, func(c *peerClaims) { c.feeFilter = rate })
+}
+
+func noteClaimsDisconnected(peerAddr string) {
+	updateClaims(peerAddr, func(c *peerClaims) { c.connected = false })
+}
+
+// noteBlocksRequested counts the blocks among vectors requested from a peer
+func noteBlocksRequested(peerAddr string, vectors []protocol.InvVector) {
+	n := 0
+	for _, v := range vectors {
+		if v.Type&^protocol.InvWitnessFlag == protocol.InvTypeBlock || v.Type == protocol.InvTypeCmpctBlock {
+			n++
+		}
+	}
+	if n > 0 {
+		updateClaims(peerAddr, func(c *peerClaims) { c.blocksRequested += n })
+	}
+}
+
+func noteBlockServed(peerAddr string) {
+	updateClaims(peerAddr, func(c *peerClaims) { c.blocksServed++ })
+}
+
+// noteNotFound counts the blocks a peer said it doesn't have
+func noteNotFound(peerAddr string, payload []byte) {
+	if n := protocol.ParseInvMessage(payload).BlockCount; n > 0 {
+		updateClaims(peerAddr, func(c *peerClaims) { c.blocksNotFound += n })
+	}
+}
+
+func noteHeadersAsked(peerAddr string) {
+	updateClaims(peerAddr, func(c *peerClaims) { c.headersAsked++ })
+}
+
+func noteHeadersAnswered(peerAddr string, n int) {
+	updateClaims(peerAddr, func(c *peerClaims) { c.headersAnswered += n })
+}
+
+// noteTxRelayed checks a transaction a peer sent against the fee filter it
+// claimed. Filters are rounded before they are sent, so a transaction only
+// counts as below one when it pays FeeFilterSlack less.
+func noteTxRelayed(peerAddr string, fee *database.Fee) {
+	if fee == nil {
+		return
+	}
+	rate := fee.Rate() * 1000 // sat/kB, as filters are given
+	updateClaims(peerAddr, func(c *peerClaims) {
+		c.txsRelayed++
+		if c.feeFilter > 0 && rate < float64(c.feeFilter)*(1-claimChecks.slack) {
+			c.txsBelowFilter++
+		}
+	})
+}
+
+// claimsExcluded reports whether a peer is excluded from measurements for
+// persistently contradicting its claims
+func claimsExcluded(peerAddr string) bool {
+	claimChecks.Lock()
+	defer claimChecks.Unlock()
+	c := claimChecks.peers[peerAddr]
+	return c != nil && c.excluded
+}
+
+// bestHeight is our best chain height: the header chain's tip, or the
+// highest block received without it. 0 until known.
+func bestHeight() int32 {
+	if headerChain != nil {
+		return headerChain.Tip().Height
+	}
+	return highestBlock.Load()
+}
+
+// StartClaimChecks restores peers' exclusions and streaks from their latest
+// reports, then reports on every peer each interval until ctx is done
+func StartClaimChecks(ctx context.Context, cfg ClaimCheckConfig, db database.Storage) (int, error) {
+	cfg.applyDefaults()
+	states, err := db.ClaimStates()
+	if err != nil {
+		return 0, err
+	}
+	excluded := 0
+	claimChecks.Lock()
+	for _, s := range states {
+		claimChecks.peers[s.PeerAddr] = &peerClaims{streak: s.Streak, cleanStreak: s.CleanStreak, excluded: s.Excluded}
+		if s.Excluded {
+			excluded++
+		}
+	}
+	claimChecks.enabled = true
+	claimChecks.slack = cfg.FeeFilterSlack
+	claimChecks.Unlock()
+	metrics.PeersClaimExcluded.Set(float64(excluded))
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalMinutes) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reportClaims(&cfg, db)
+			}
+		}
+	}()
+	return excluded, nil
+}
+
+// reportClaims judges each peer's behavior since the last report against
+// its claims and saves the reports. Disconnected peers are kept while
+// excluded or on a streak, so reconnecting doesn't clear their record.
+func reportClaims(cfg *ClaimCheckConfig, db database.Storage) {
+	best := bestHeight()
+	var reports []database.ClaimReport
+	var excludedNow, readmitted []string
+	excluded := 0
+
+	claimChecks.Lock()
+	for addr, c := range claimChecks.peers {
+		// Peers gone since the last report, and ones restored from a
+		// previous run that haven't connected, have nothing to judge
+		if !c.connected && c.blocksRequested+c.headersAsked+c.txsRelayed == 0 {
+			if c.excluded {
+				excluded++
+			} else if c.streak == 0 {
+				delete(claimChecks.peers, addr)
+			}
+			continue
+		}
+		kinds, judged := c.divergences(cfg, best)
+		switch {
+		case len(kinds) > 0:
+			c.streak++
+			c.cleanStreak = 0
+			for _, kind := range kinds {
+				metrics.ClaimDivergences.WithLabelValues(kind).Inc()
+			}
+		case judged:
+			c.streak = 0
+			c.cleanStreak++
+		}
+		if !c.excluded && c.streak >= cfg.ExcludeAfter {
+			c.excluded = true
+			excludedNow = append(excludedNow, addr)
+		} else if c.excluded && c.cleanStreak >= cfg.ExcludeAfter {
+			c.excluded = false
+			readmitted = append(readmitted, addr)
+		}
+		if c.excluded {
+			excluded++
+		}
+
+		reports = append(reports, database.ClaimReport{
+			PeerAddr:        addr,
+			Region:          c.region,
+			Services:        c.services,
+			StartHeight:     c.startHeight,
+			BestHeight:      best,
+			FeeFilter:       c.feeFilter,
+			BlocksRequested: c.blocksRequested,
+			BlocksServed:    c.blocksServed,
+			BlocksNotFound:  c.blocksNotFound,
+			HeadersAsked:    c.headersAsked,
+			HeadersAnswered: c.headersAnswered,
+			TxsRelayed:      c.txsRelayed,
+			TxsBelowFilter:  c.txsBelowFilter,
+			Divergences:     kinds,
+			Streak:          c.streak,
+			CleanStreak:     c.cleanStreak,
+			Excluded:        c.excluded,
+		})
+		c.blocksRequested, c.blocksServed, c.blocksNotFound = 0, 0, 0
+		c.headersAsked, c.headersAnswered = 0, 0
+		c.txsRelayed, c.txsBelowFilter = 0, 0
+		if !c.connected && !c.excluded && c.streak == 0 {
+			delete(claimChecks.peers, addr)
+		}
+	}
+	claimChecks.Unlock()
+
+	metrics.PeersClaimExcluded.Set(float64(excluded))
+	for _, addr := range excludedNow {
+		logger.Log.Warn().Str("peer", addr).Int("reports", cfg.ExcludeAfter).Msg("Excluded peer from measurements for contradicting its claims")
+	}
+	for _, addr := range readmitted {
+		logger.Log.Info().Str("peer", addr).Msg("Readmitted peer to measurements")
+	}
+	if len(reports) == 0 {
+		return
+	}
+	if err := db.SaveClaimReports(time.Now(), reports); err != nil {
+		logger.Log.Error().Err(err).Int("peers", len(reports)).Msg("Failed to save claim reports")
+	}
+}
@@ -0,0 +1,296 @@
+package observer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Compact block outcomes
+const (
+	compactReconstructed = "reconstructed" // every tx was in the mempool
+	compactRequested     = "requested"     // getblocktxn sent for the rest
+	compactCompleted     = "completed"     // blocktxn filled the block
+	compactFallback      = "fallback"      // full block requested instead
+)
+
+// pendingCompactTimeout drops a getblocktxn the peer never answered
+const pendingCompactTimeout = time.Minute
+
+// CompactBlockConfig enables BIP152 compact block relay
+type CompactBlockConfig struct {
+	// HighBandwidth asks peers to push compact blocks before validating
+	// them instead of announcing with inv first
+	HighBandwidth bool `json:"high_bandwidth"`
+
+	// AutoHighBandwidth puts only the best HighBandwidthPeers peers in
+	// high-bandwidth mode, as Bitcoin Core does, instead of every peer: the
+	// lowest-latency ones at first, then whichever most recently delivered
+	// a new block first. It overrides HighBandwidth.
+	AutoHighBandwidth  bool `json:"auto_high_bandwidth"`
+	HighBandwidthPeers int  `json:"high_bandwidth_peers"` // default 3
+
+	// MempoolSize is how many recent transactions are kept to reconstruct
+	// blocks from (default 50000)
+	MempoolSize int `json:"mempool_size"`
+}
+
+func (c *CompactBlockConfig) applyDefaults() {
+	if c.MempoolSize <= 0 {
+		c.MempoolSize = 50000
+	}
+	if c.HighBandwidthPeers <= 0 {
+		c.HighBandwidthPeers = 3
+	}
+}
+
+var compactBlocks atomic.Pointer[CompactBlockConfig]
+
+// SetCompactBlocks enables compact blocks for peers connected from now on
+func SetCompactBlocks(cfg CompactBlockConfig) {
+	cfg.applyDefaults()
+	mempool.resize(cfg.MempoolSize)
+	compactBlocks.Store(&cfg)
+}
+
+// txPool keeps recently received transactions by wtxid, in two generations
+// like knownInventory so it stays bounded without timestamps
+type txPool struct {
+	sync.Mutex
+	cur, prev map[[32]byte]*protocol.Transaction
+	limit     int
+}
+
+var mempool = &txPool{}
+
+func (p *txPool) resize(size int) {
+	p.Lock()
+	defer p.Unlock()
+	p.limit = max(size/2, 1)
+	p.cur = make(map[[32]byte]*protocol.Transaction)
+	p.prev = nil
+}
+
+// add keeps tx for reconstruction; a no-op until compact blocks are enabled
+func (p *txPool) add(tx *protocol.Transaction) {
+	p.Lock()
+	defer p.Unlock()
+	if p.cur == nil {
+		return
+	}
+	if len(p.cur) >= p.limit {
+		p.prev, p.cur = p.cur, make(map[[32]byte]*protocol.Transaction, p.limit)
+	}
+	p.cur[tx.WTxID] = tx
+	metrics.MempoolSize.Set(float64(len(p.cur) + len(p.prev)))
+}
+
+// candidates returns every pooled transaction
+func (p *txPool) candidates() []*protocol.Transaction {
+	p.Lock()
+	defer p.Unlock()
+	txs := make([]*protocol.Transaction, 0, len(p.cur)+len(p.prev))
+	for _, tx := range p.prev {
+		txs = append(txs, tx)
+	}
+	for _, tx := range p.cur {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// confirm drops a block's transactions from the pool
+func (p *txPool) confirm(txs []*protocol.Transaction) {
+	p.Lock()
+	defer p.Unlock()
+	for _, tx := range txs {
+		delete(p.cur, tx.WTxID)
+		delete(p.prev, tx.WTxID)
+	}
+	metrics.MempoolSize.Set(float64(len(p.cur) + len(p.prev)))
+}
+
+// shrink drops the older generation under memory pressure
+func (p *txPool) shrink() {
+	p.Lock()
+	defer p.Unlock()
+	p.prev = nil
+	metrics.MempoolSize.Set(float64(len(p.cur)))
+}
+
+// pendingCompact is a compact block waiting on a blocktxn reply
+type pendingCompact struct {
+	block   *protocol.CompactBlock
+	txs     []*protocol.Transaction
+	missing []int
+	sentAt  time.Time
+}
+
+// compactPeer is one connection's compact block state, owned by its message
+// loop. It is nil when compact blocks are disabled.
+type compactPeer struct {
+	// supported is set once the peer sends sendcmpct for our version
+	supported bool
+	requested map[[32]byte]time.Time
+	pending   map[[32]byte]*pendingCompact
+
+	// wantHB is set by the high-bandwidth selector; highBandwidth is the
+	// mode last announced to the peer
+	wantHB        atomic.Bool
+	highBandwidth bool
+}
+
+func newCompactPeer() *compactPeer {
+	if compactBlocks.Load() == nil {
+		return nil
+	}
+	return &compactPeer{
+		requested: make(map[[32]byte]time.Time),
+		pending:   make(map[[32]byte]*pendingCompact),
+	}
+}
+
+// announce sends our sendcmpct after the handshake
+func (c *compactPeer) announce(conn net.Conn) {
+	cfg := compactBlocks.Load()
+	c.highBandwidth = cfg.HighBandwidth && !cfg.AutoHighBandwidth
+	payload := protocol.CreateSendCmpctPayload(c.highBandwidth, protocol.CompactBlockVersion)
+	conn.Write(protocol.CreateMessagePacket("sendcmpct", payload))
+}
+
+// handleSendCmpct records whether the peer speaks our compact block
+// version, making it a high-bandwidth candidate if it does
+func (c *compactPeer) handleSendCmpct(payload []byte, address string) {
+	if c.supported || len(payload) < 9 || binary.LittleEndian.Uint64(payload[1:9]) != protocol.CompactBlockVersion {
+		return
+	}
+	c.supported = true
+	if compactBlocks.Load().AutoHighBandwidth {
+		highBandwidth.join(c, address)
+	}
+}
+
+// requestTypes rewrites getdata vectors to ask for witness transactions,
+// which carry the wtxids short IDs are computed from, and compact blocks
+// when the peer supports them
+func (c *compactPeer) requestTypes(vectors []protocol.InvVector) {
+	if c == nil {
+		return
+	}
+	for i := range vectors {
+		switch vectors[i].Type {
+		case protocol.InvTypeTx:
+			vectors[i].Type = protocol.InvTypeWitnessTx
+		case protocol.InvTypeBlock:
+			if c.supported {
+				vectors[i].Type = protocol.InvTypeCmpctBlock
+				c.requested[vectors[i].Hash] = time.Now()
+			}
+		}
+	}
+}
+
+// handleCmpctBlock reconstructs a block from a cmpctblock message. The
+// block is nil while transactions are still being fetched from the peer. A
+// compact block pushed unasked is the peer's announcement of the block.
+func (c *compactPeer) handleCmpctBlock(conn net.Conn, payload []byte, peerAddr, region string, plog zerolog.Logger, db database.Storage) (*protocol.Block, error) {
+	cb, err := protocol.ParseCompactBlock(payload)
+	if err != nil {
+		return nil, err
+	}
+	c.expire()
+
+	// High-bandwidth peers push blocks we may already have from another peer
+	_, asked := c.requested[cb.BlockHash]
+	delete(c.requested, cb.BlockHash)
+	if !asked {
+		noteBlockArrival(cb.BlockHash, peerAddr, region, arrivalCmpctPush, plog, db)
+	}
+	if _, pending := c.pending[cb.BlockHash]; pending || (!asked && !MarkSeenBlock(cb.BlockHash)) {
+		return nil, nil
+	}
+
+	txs, missing := cb.Fill(mempool.candidates())
+	if len(missing) == 0 {
+		return c.complete(conn, cb, txs, compactReconstructed, plog), nil
+	}
+
+	metrics.CompactBlocks.WithLabelValues(compactRequested).Inc()
+	plog.Debug().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(cb.BlockHash[:]))).
+		Int("txs", cb.TxCount()).
+		Int("missing", len(missing)).
+		Msg("Requesting compact block transactions")
+	c.pending[cb.BlockHash] = &pendingCompact{block: cb, txs: txs, missing: missing, sentAt: time.Now()}
+	conn.Write(protocol.CreateMessagePacket("getblocktxn", protocol.CreateGetBlockTxnPayload(cb.BlockHash, missing)))
+	return nil, nil
+}
+
+// handleBlockTxn fills a pending compact block with the peer's reply
+func (c *compactPeer) handleBlockTxn(conn net.Conn, payload []byte, plog zerolog.Logger) (*protocol.Block, error) {
+	hash, txs, err := protocol.ParseBlockTxn(payload)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := c.pending[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(c.pending, hash)
+
+	if len(txs) != len(p.missing) {
+		c.fallback(conn, hash, plog, "blocktxn count mismatch")
+		return nil, nil
+	}
+	for i, index := range p.missing {
+		p.txs[index] = txs[i]
+	}
+	return c.complete(conn, p.block, p.txs, compactCompleted, plog), nil
+}
+
+// expire forgets requests the peer never answered, letting another peer's
+// announcement fetch the block
+func (c *compactPeer) expire() {
+	for hash, at := range c.requested {
+		if time.Since(at) > pendingCompactTimeout {
+			delete(c.requested, hash)
+		}
+	}
+	for hash, p := range c.pending {
+		if time.Since(p.sentAt) > pendingCompactTimeout {
+			delete(c.pending, hash)
+			ForgetSeenBlock(hash)
+		}
+	}
+}
+
+// complete assembles the block, or requests it in full when a short ID
+// collision left a wrong transaction in it
+func (c *compactPeer) complete(conn net.Conn, cb *protocol.CompactBlock, txs []*protocol.Transaction, result string, plog zerolog.Logger) *protocol.Block {
+	block, err := cb.Block(txs)
+	if err != nil {
+		c.fallback(conn, cb.BlockHash, plog, err.Error())
+		return nil
+	}
+	metrics.CompactBlocks.WithLabelValues(result).Inc()
+	mempool.confirm(txs)
+	return block
+}
+
+func (c *compactPeer) fallback(conn net.Conn, hash [32]byte, plog zerolog.Logger, reason string) {
+	metrics.CompactBlocks.WithLabelValues(compactFallback).Inc()
+	plog.Debug().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(hash[:]))).
+		Str("reason", reason).
+		Msg("Compact block failed, requesting full block")
+	vector := protocol.InvVector{Type: protocol.InvTypeWitnessBlock, Hash: hash}
+	conn.Write(protocol.CreateMessagePacket("getdata", protocol.CreateGetDataPayload([]protocol.InvVector{vector})))
+}
@@ -0,0 +1,147 @@
+package observer
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// hbCandidate is a compact block peer's standing for high-bandwidth mode
+type hbCandidate struct {
+	addr      string
+	latencyMs int       // fastest ping round trip, 0 until measured
+	lastFirst time.Time // when it last delivered a new block before any other peer
+	selected  bool
+}
+
+// hbSelector picks the peers asked to push compact blocks in
+// high-bandwidth mode. Like Bitcoin Core, it keeps the peers that most
+// recently delivered a new block first; until peers have delivered blocks,
+// the lowest-latency ones fill the slots.
+type hbSelector struct {
+	sync.Mutex
+	peers map[*compactPeer]*hbCandidate
+}
+
+var highBandwidth = &hbSelector{peers: make(map[*compactPeer]*hbCandidate)}
+
+// join adds a peer that supports our compact block version
+func (s *hbSelector) join(c *compactPeer, addr string) {
+	s.Lock()
+	defer s.Unlock()
+	s.peers[c] = &hbCandidate{addr: addr}
+	s.reselect()
+}
+
+// leave drops a disconnected peer, freeing its slot for the next best
+func (s *hbSelector) leave(c *compactPeer) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.peers[c]; !ok {
+		return
+	}
+	delete(s.peers, c)
+	s.reselect()
+}
+
+// noteLatency records a ping round trip
+func (s *hbSelector) noteLatency(c *compactPeer, ms int) {
+	s.Lock()
+	defer s.Unlock()
+	p, ok := s.peers[c]
+	if !ok || (p.latencyMs > 0 && ms >= p.latencyMs) {
+		return
+	}
+	p.latencyMs = max(ms, 1)
+	s.reselect()
+}
+
+// noteFirstDelivery records that the peer delivered a new block first
+func (s *hbSelector) noteFirstDelivery(c *compactPeer) {
+	s.Lock()
+	defer s.Unlock()
+	p, ok := s.peers[c]
+	if !ok {
+		return
+	}
+	p.lastFirst = time.Now()
+	s.reselect()
+}
+
+// reselect ranks the candidates, most recent first delivery first and then
+// lowest latency, and moves the high-bandwidth slots to the top ones.
+// Peers with neither measured yet aren't eligible.
+func (s *hbSelector) reselect() {
+	cfg := compactBlocks.Load()
+	if cfg == nil || !cfg.AutoHighBandwidth {
+		return
+	}
+	ranked := make([]*compactPeer, 0, len(s.peers))
+	for c, p := range s.peers {
+		if p.latencyMs > 0 || !p.lastFirst.IsZero() {
+			ranked = append(ranked, c)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := s.peers[ranked[i]], s.peers[ranked[j]]
+		if !a.lastFirst.Equal(b.lastFirst) {
+			return a.lastFirst.After(b.lastFirst)
+		}
+		if a.latencyMs != b.latencyMs {
+			return a.latencyMs < b.latencyMs
+		}
+		return a.addr < b.addr
+	})
+
+	want := make(map[*compactPeer]bool, cfg.HighBandwidthPeers)
+	for _, c := range ranked[:min(len(ranked), cfg.HighBandwidthPeers)] {
+		want[c] = true
+	}
+	selected := 0
+	for c, p := range s.peers {
+		if want[c] != p.selected {
+			p.selected = want[c]
+			c.wantHB.Store(want[c])
+			if want[c] {
+				metrics.CompactHighBandwidthChanges.WithLabelValues("promoted").Inc()
+			} else {
+				metrics.CompactHighBandwidthChanges.WithLabelValues("demoted").Inc()
+			}
+			logger.Log.Debug().
+				Str("peer", p.addr).
+				Bool("high_bandwidth", want[c]).
+				Int("latency_ms", p.latencyMs).
+				Time("last_first_block", p.lastFirst).
+				Msg("Compact block mode changed")
+		}
+		if p.selected {
+			selected++
+		}
+	}
+	metrics.CompactHighBandwidthPeers.Set(float64(selected))
+}
+
+// syncMode sends sendcmpct again when the selector has moved the peer into
+// or out of high-bandwidth mode. It runs on the peer's message loop, which
+// owns the connection's writes.
+func (c *compactPeer) syncMode(conn net.Conn, plog zerolog.Logger) {
+	if c == nil || !c.supported || !compactBlocks.Load().AutoHighBandwidth {
+		return
+	}
+	want := c.wantHB.Load()
+	if want == c.highBandwidth {
+		return
+	}
+	payload := protocol.CreateSendCmpctPayload(want, protocol.CompactBlockVersion)
+	if _, err := conn.Write(protocol.CreateMessagePacket("sendcmpct", payload)); err != nil {
+		return
+	}
+	c.highBandwidth = want
+	plog.Debug().Bool("high_bandwidth", want).Msg("Sent sendcmpct")
+}
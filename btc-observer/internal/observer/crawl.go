@@ -0,0 +1,179 @@
+package observer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// CrawlConfig controls peer discovery from addr gossip
+type CrawlConfig struct {
+	// QueueSize bounds the addresses waiting for geolocation (default 10000)
+	QueueSize int `json:"queue_size"`
+	// BatchSize is how many are geolocated per round (default 100, the
+	// geolocation API's batch limit)
+	BatchSize int `json:"batch_size"`
+	// IntervalSeconds is the time between rounds (default 60)
+	IntervalSeconds int `json:"interval_seconds"`
+	// MaxPerCountry caps crawled candidates per country, newest kept
+	// (default 50)
+	MaxPerCountry int `json:"max_per_country"`
+}
+
+func (c *CrawlConfig) applyDefaults() {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10000
+	}
+	if c.BatchSize <= 0 || c.BatchSize > 100 {
+		c.BatchSize = 100
+	}
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = 60
+	}
+	if c.MaxPerCountry <= 0 {
+		c.MaxPerCountry = 50
+	}
+}
+
+// crawlSeenTTL is how long a queued address is skipped when gossiped again
+const crawlSeenTTL = 6 * time.Hour
+
+// crawler turns gossiped addresses into geolocated peer candidates
+type crawler struct {
+	cfg   CrawlConfig
+	queue chan *Node
+
+	mu    sync.Mutex
+	seen  map[string]time.Time // address -> when queued
+	found map[string][]*Node   // country -> candidates, oldest first
+}
+
+var activeCrawler atomic.Pointer[crawler]
+
+// StartCrawler queues the IPv4 full-node addresses peers gossip and, every
+// interval, geolocates a batch and adds the ones in wanted countries to the
+// peer pool alongside the bitnodes candidates
+func StartCrawler(ctx context.Context, cfg CrawlConfig, pm *PeerManager) {
+	cfg.applyDefaults()
+	c := &crawler{
+		cfg:   cfg,
+		queue: make(chan *Node, cfg.QueueSize),
+		seen:  make(map[string]time.Time),
+		found: make(map[string][]*Node),
+	}
+	activeCrawler.Store(c)
+	logger.Log.Info().Int("queue_size", cfg.QueueSize).Int("interval_seconds", cfg.IntervalSeconds).Msg("Address crawler enabled")
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				activeCrawler.CompareAndSwap(c, nil)
+				return
+			case <-ticker.C:
+				c.crawl(pm)
+			}
+		}
+	}()
+}
+
+// enqueueCrawl offers gossiped addresses to the crawler, if running
+func enqueueCrawl(addrs []protocol.NetAddress) {
+	c := activeCrawler.Load()
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range addrs {
+		if a.Network != protocol.NetIPv4 || a.Port == 0 || a.Services&protocol.ServicesNodeNetwork == 0 {
+			continue
+		}
+		addr := a.String()
+		if _, ok := c.seen[addr]; ok {
+			metrics.CrawlAddresses.WithLabelValues("known").Inc()
+			noteExpansionGossip(addr)
+			continue
+		}
+		select {
+		case c.queue <- &Node{Address: a.Host, Port: int(a.Port)}:
+			c.seen[addr] = now
+			metrics.CrawlAddresses.WithLabelValues("queued").Inc()
+		default:
+			metrics.CrawlAddresses.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// crawl geolocates one batch from the queue, then merges every crawled
+// candidate into the peer pool, since discovery refreshes replace it
+func (c *crawler) crawl(pm *PeerManager) {
+	nodesByIP := make(map[string]*Node)
+	var ips []string
+drain:
+	for len(ips) < c.cfg.BatchSize {
+		select {
+		case node := <-c.queue:
+			if _, dup := nodesByIP[node.Address]; !dup {
+				nodesByIP[node.Address] = node
+				ips = append(ips, node.Address)
+			}
+		default:
+			break drain
+		}
+	}
+
+	if len(ips) > 0 {
+		geoMap, err := lookupGeo(ips)
+		if err != nil {
+			logger.Log.Warn().Err(err).Msg("Crawler geo lookup failed")
+		}
+		c.mu.Lock()
+		for ip, geo := range geoMap {
+			node := nodesByIP[ip]
+			node.CountryCode = geo.CountryCode
+			node.City = geo.City
+			node.Latitude = geo.Lat
+			node.Longitude = geo.Lon
+			node.ASN = geo.AS
+			node.OrgName = geo.Org
+			noteExpansionNode(node, expansionGossip)
+			if keep, _ := wantedCandidate(node); !keep {
+				metrics.CrawlAddresses.WithLabelValues("unwanted").Inc()
+				continue
+			}
+			metrics.CrawlAddresses.WithLabelValues("added").Inc()
+			found := append(c.found[node.CountryCode], node)
+			if len(found) > c.cfg.MaxPerCountry {
+				found = found[len(found)-c.cfg.MaxPerCountry:]
+			}
+			c.found[node.CountryCode] = found
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	cutoff := time.Now().Add(-crawlSeenTTL)
+	for addr, at := range c.seen {
+		if at.Before(cutoff) {
+			delete(c.seen, addr)
+		}
+	}
+	added := 0
+	for country, nodes := range c.found {
+		added += pm.AddAvailable(country, nodes)
+	}
+	c.mu.Unlock()
+
+	if len(ips) > 0 || added > 0 {
+		logger.Log.Info().Int("geolocated", len(ips)).Int("added", added).Int("queued", len(c.queue)).Msg("Crawled gossiped addresses")
+	}
+}
@@ -22,6 +22,49 @@ var seenBlocks = struct {
 	m map[[32]byte]time.Time
 }{m: make(map[[32]byte]time.Time)}
 
+// wtxids maps the wtxids of recently received segwit transactions to their
+// txids, so announcements by wtxid (BIP339) are recorded under the txid.
+// Entries without a txid yet are wtxids announced before the transaction
+// arrived, whose observations were recorded under the wtxid.
+var wtxids = struct {
+	sync.Mutex
+	m map[[32]byte]wtxidEntry
+}{m: make(map[[32]byte]wtxidEntry)}
+
+type wtxidEntry struct {
+	txid  [32]byte
+	known bool
+	at    time.Time
+}
+
+// txidForWTxID returns the txid of a received transaction with this wtxid.
+// Otherwise the wtxid is noted as announced before its transaction arrived.
+func txidForWTxID(wtxid [32]byte) ([32]byte, bool) {
+	wtxids.Lock()
+	defer wtxids.Unlock()
+	e, ok := wtxids.m[wtxid]
+	if ok && e.known {
+		return e.txid, true
+	}
+	if !ok {
+		wtxids.m[wtxid] = wtxidEntry{at: time.Now()}
+	}
+	return [32]byte{}, false
+}
+
+// linkWTxID records a received transaction's wtxid and reports whether it
+// had been announced by wtxid before it arrived
+func linkWTxID(wtxid, txid [32]byte) bool {
+	wtxids.Lock()
+	defer wtxids.Unlock()
+	e, announced := wtxids.m[wtxid]
+	if announced && e.known {
+		return false
+	}
+	wtxids.m[wtxid] = wtxidEntry{txid: txid, known: true, at: time.Now()}
+	return announced
+}
+
 // MarkSeenTx returns true if this is the first time seeing this tx hash
 func MarkSeenTx(hash [32]byte) bool {
 	seenTxs.Lock()
@@ -44,9 +87,36 @@ func MarkSeenBlock(hash [32]byte) bool {
 	return true
 }
 
+// blockSeen reports whether a block has already been requested
+func blockSeen(hash [32]byte) bool {
+	seenBlocks.RLock()
+	defer seenBlocks.RUnlock()
+	_, exists := seenBlocks.m[hash]
+	return exists
+}
+
+// ForgetSeenBlock lets a block be requested again, e.g. after only part of it
+// could be parsed
+func ForgetSeenBlock(hash [32]byte) {
+	seenBlocks.Lock()
+	delete(seenBlocks.m, hash)
+	seenBlocks.Unlock()
+}
+
 // CleanupSeenMaps removes entries older than seenExpiry
 func CleanupSeenMaps() {
-	cutoff := time.Now().Add(-seenExpiry)
+	cleanupSeenMapsOlderThan(seenExpiry)
+}
+
+// ShrinkSeenMaps aggressively removes entries older than maxAge, used when
+// shedding load under memory pressure
+func ShrinkSeenMaps(maxAge time.Duration) {
+	cleanupSeenMapsOlderThan(maxAge)
+}
+
+func cleanupSeenMapsOlderThan(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	cleanupLatencyTxs(maxAge)
 
 	seenTxs.Lock()
 	for hash, t := range seenTxs.m {
@@ -65,6 +135,15 @@ func CleanupSeenMaps() {
 	}
 	metrics.SeenMapSize.WithLabelValues("block").Set(float64(len(seenBlocks.m)))
 	seenBlocks.Unlock()
+
+	wtxids.Lock()
+	for hash, e := range wtxids.m {
+		if e.at.Before(cutoff) {
+			delete(wtxids.m, hash)
+		}
+	}
+	metrics.SeenMapSize.WithLabelValues("wtxid").Set(float64(len(wtxids.m)))
+	wtxids.Unlock()
 }
 
 // StartCleanupRoutine starts periodic cleanup of seen maps
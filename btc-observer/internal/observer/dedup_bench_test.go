@@ -0,0 +1,96 @@
+package observer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func hashFixture(i int) [32]byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(i))
+	return sha256.Sum256(b[:])
+}
+
+func resetSeenMaps() {
+	seenTxs.Lock()
+	seenTxs.m = make(map[[32]byte]time.Time)
+	seenTxs.Unlock()
+	seenBlocks.Lock()
+	seenBlocks.m = make(map[[32]byte]time.Time)
+	seenBlocks.Unlock()
+}
+
+func BenchmarkMarkSeenTxNew(b *testing.B) {
+	resetSeenMaps()
+	defer resetSeenMaps()
+	hashes := make([][32]byte, b.N)
+	for i := range hashes {
+		hashes[i] = hashFixture(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkSeenTx(hashes[i])
+	}
+}
+
+// Most inv announcements are for txs another peer already sent us
+func BenchmarkMarkSeenTxDuplicate(b *testing.B) {
+	resetSeenMaps()
+	defer resetSeenMaps()
+	const n = 50000
+	hashes := make([][32]byte, n)
+	for i := range hashes {
+		hashes[i] = hashFixture(i)
+		MarkSeenTx(hashes[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MarkSeenTx(hashes[i%n])
+	}
+}
+
+// Every peer goroutine contends on the same map
+func BenchmarkMarkSeenTxParallel(b *testing.B) {
+	resetSeenMaps()
+	defer resetSeenMaps()
+	const n = 50000
+	hashes := make([][32]byte, n)
+	for i := range hashes {
+		hashes[i] = hashFixture(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			MarkSeenTx(hashes[i%n])
+			i++
+		}
+	})
+}
+
+func BenchmarkCleanupSeenMaps(b *testing.B) {
+	defer resetSeenMaps()
+	const n = 100000
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		resetSeenMaps()
+		old := time.Now().Add(-2 * seenExpiry)
+		seenTxs.Lock()
+		for j := 0; j < n; j++ {
+			// Half the entries are expired
+			t := time.Now()
+			if j%2 == 0 {
+				t = old
+			}
+			seenTxs.m[hashFixture(j)] = t
+		}
+		seenTxs.Unlock()
+		b.StartTimer()
+		CleanupSeenMaps()
+	}
+}
@@ -0,0 +1,211 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DialSourceConfig binds outbound peer connections to local addresses, so a
+// multi-homed host or one with a VPN egress per country can observe from
+// several network vantage points at once
+type DialSourceConfig struct {
+	// Default applies to connections no group matches; unset uses the
+	// system's choice
+	Default *DialSource `json:"default,omitempty"`
+
+	// Groups map a region label, or a peer-selection slot (a country code,
+	// static), to the source its connections leave from. The region is
+	// matched first, so region overrides can split a country's peers.
+	Groups map[string]DialSource `json:"groups,omitempty"`
+
+	// EgressCheck verifies the exit country of sources that set one
+	EgressCheck *EgressCheckConfig `json:"egress_check,omitempty"`
+}
+
+// DialSource is a local IP, an interface, or both. An interface alone uses
+// its address of the peer's family; on Linux the socket is also bound to the
+// interface so routing follows it.
+type DialSource struct {
+	IP        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+
+	// ExpectCountry is the country code traffic from this source should
+	// exit in, such as a VPN egress; connections wait for the egress check
+	// to confirm it
+	ExpectCountry string `json:"expect_country,omitempty"`
+}
+
+// dialSources is the parsed configuration
+type dialSources struct {
+	fallback *dialSource
+	groups   map[string]*dialSource
+}
+
+// dialSource is one validated DialSource
+type dialSource struct {
+	name   string // group, or "default"
+	ip     net.IP
+	iface  string
+	expect string
+
+	// egress is the last egress check's verdict, when expect is set
+	egress atomic.Int32
+}
+
+var peerDialSources atomic.Pointer[dialSources]
+
+// SetDialSources binds outbound peer connections per region or group. Onion
+// peers are unaffected: they are dialed through the Tor proxy.
+func SetDialSources(cfg DialSourceConfig) error {
+	s := &dialSources{groups: make(map[string]*dialSource)}
+	if cfg.Default != nil {
+		src, err := parseDialSource("default", *cfg.Default)
+		if err != nil {
+			return err
+		}
+		s.fallback = src
+	}
+	for group, ds := range cfg.Groups {
+		src, err := parseDialSource(group, ds)
+		if err != nil {
+			return err
+		}
+		s.groups[group] = src
+	}
+	if cfg.EgressCheck == nil {
+		for _, src := range s.all() {
+			if src.expect != "" {
+				return fmt.Errorf("dial source %q: expect_country needs egress_check", src.name)
+			}
+		}
+	}
+	peerDialSources.Store(s)
+	return nil
+}
+
+// all returns every configured source
+func (s *dialSources) all() []*dialSource {
+	var all []*dialSource
+	if s.fallback != nil {
+		all = append(all, s.fallback)
+	}
+	for _, src := range s.groups {
+		all = append(all, src)
+	}
+	return all
+}
+
+func parseDialSource(name string, ds DialSource) (*dialSource, error) {
+	src := &dialSource{name: name, iface: ds.Interface, expect: strings.ToUpper(ds.ExpectCountry)}
+	if ds.IP == "" && ds.Interface == "" {
+		return nil, fmt.Errorf("dial source %q: set ip or interface", name)
+	}
+	if ds.IP != "" {
+		if src.ip = net.ParseIP(ds.IP); src.ip == nil {
+			return nil, fmt.Errorf("dial source %q: invalid ip %q", name, ds.IP)
+		}
+	}
+	if ds.Interface != "" {
+		if _, err := net.InterfaceByName(ds.Interface); err != nil {
+			return nil, fmt.Errorf("dial source %q: %w", name, err)
+		}
+	}
+	return src, nil
+}
+
+// dialSourceFor returns the source for a peer's region and slot, or nil to
+// let the system choose
+func dialSourceFor(region, slot string) *dialSource {
+	s := peerDialSources.Load()
+	if s == nil {
+		return nil
+	}
+	if src, ok := s.groups[region]; ok {
+		return src
+	}
+	if src, ok := s.groups[slot]; ok {
+		return src
+	}
+	return s.fallback
+}
+
+// String describes the source for logs
+func (s *dialSource) String() string {
+	switch {
+	case s.ip != nil && s.iface != "":
+		return fmt.Sprintf("%s (%s on %s)", s.name, s.ip, s.iface)
+	case s.ip != nil:
+		return fmt.Sprintf("%s (%s)", s.name, s.ip)
+	}
+	return fmt.Sprintf("%s (%s)", s.name, s.iface)
+}
+
+// localAddr picks the source address for a peer IP: the configured one, or
+// the interface's first address of the same family
+func (s *dialSource) localAddr(peer net.IP) (*net.TCPAddr, error) {
+	v4 := peer.To4() != nil
+	if s.ip != nil {
+		if (s.ip.To4() != nil) != v4 {
+			return nil, fmt.Errorf("dial source %s can't reach %s", s, peer)
+		}
+		return &net.TCPAddr{IP: s.ip}, nil
+	}
+	ifi, err := net.InterfaceByName(s.iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() != nil) != v4 || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return &net.TCPAddr{IP: ipnet.IP}, nil
+	}
+	return nil, fmt.Errorf("dial source %s has no address to reach %s", s, peer)
+}
+
+// dial connects to addr from this source
+func (s *dialSource) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.dialContext(ctx, "tcp", addr)
+}
+
+// dialContext connects to addr from this source, resolving a hostname
+// through the configured resolver and trying each of its addresses
+func (s *dialSource) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = lookupIP(host); err != nil {
+			return nil, err
+		}
+	}
+	var errs []error
+	for _, ip := range ips {
+		local, err := s.localAddr(ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dialer := net.Dialer{LocalAddr: local, Control: bindToDevice(s.iface)}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package observer
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice returns a socket control function binding the socket to an
+// interface (SO_BINDTODEVICE), or nil for none. Binding needs CAP_NET_RAW on
+// kernels before 5.7.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = unix.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package observer
+
+import "syscall"
+
+// bindToDevice returns nil: only Linux binds sockets to an interface, so
+// elsewhere a source interface only selects the local address
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
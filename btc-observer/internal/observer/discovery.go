@@ -7,9 +7,12 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/keato/btc-observer/internal/doh"
 	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/protocol"
 )
 
 const (
@@ -17,8 +20,57 @@ const (
 	ipGeoBatchAPI = "http://ip-api.com/batch?fields=status,query,country,countryCode,city,lat,lon,isp,org,as"
 )
 
-// geoResult holds IP geolocation response
-type geoResult struct {
+// resolver, when set, replaces system DNS for seeds, discovery APIs and peer
+// hostnames
+var resolver atomic.Pointer[doh.Resolver]
+
+// httpClient is used for the discovery and geolocation APIs
+var httpClient atomic.Pointer[http.Client]
+
+// SetResolver sends all of the observer's name lookups through r
+func SetResolver(r *doh.Resolver) {
+	resolver.Store(r)
+	httpClient.Store(r.HTTPClient())
+}
+
+func discoveryClient() *http.Client {
+	if c := httpClient.Load(); c != nil {
+		return c
+	}
+	return http.DefaultClient
+}
+
+func lookupIP(host string) ([]net.IP, error) {
+	if r := resolver.Load(); r != nil {
+		return r.LookupIP(context.Background(), host)
+	}
+	return net.LookupIP(host)
+}
+
+// dialPeer connects to a peer, resolving a hostname through the configured
+// resolver and leaving from src when set. Onion peers are dialed through the
+// Tor proxy.
+func dialPeer(addr string, src *dialSource, timeout time.Duration) (net.Conn, error) {
+	if protocol.AddressNetwork(addr) == protocol.NetTorV3 {
+		proxy := torProxy.Load()
+		if proxy == nil {
+			return nil, fmt.Errorf("no Tor proxy configured for %s", addr)
+		}
+		return dialSOCKS5(*proxy, addr, timeout)
+	}
+	if src != nil {
+		return src.dial(addr, timeout)
+	}
+	if r := resolver.Load(); r != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return r.DialContext(ctx, "tcp", addr)
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// GeoResult is one IP's geolocation
+type GeoResult struct {
 	Status      string  `json:"status"`
 	Query       string  `json:"query"`
 	Country     string  `json:"country"`
@@ -31,21 +83,21 @@ type geoResult struct {
 	AS          string  `json:"as"`
 }
 
-// lookupGeoBatch fetches geolocation for up to 100 IPs at once
-func lookupGeoBatch(ips []string) (map[string]*geoResult, error) {
+// LookupGeoBatch fetches geolocation for up to 100 IPs at once
+func LookupGeoBatch(ips []string) (map[string]*GeoResult, error) {
 	body, _ := json.Marshal(ips)
-	resp, err := http.Post(ipGeoBatchAPI, "application/json", strings.NewReader(string(body)))
+	resp, err := discoveryClient().Post(ipGeoBatchAPI, "application/json", strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var results []geoResult
+	var results []GeoResult
 	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
 		return nil, err
 	}
 
-	geoMap := make(map[string]*geoResult)
+	geoMap := make(map[string]*GeoResult)
 	for i := range results {
 		if results[i].Status == "success" {
 			geoMap[results[i].Query] = &results[i]
@@ -54,16 +106,76 @@ func lookupGeoBatch(ips []string) (map[string]*geoResult, error) {
 	return geoMap, nil
 }
 
-// FetchNodes retrieves nodes from bitnodes.io and looks up their geolocation
+// FetchNodes retrieves candidate nodes, from bitnodes.io on mainnet or the
+// network's DNS seeds elsewhere, and looks up their geolocation with the
+// configured provider
 func FetchNodes() (map[string][]*Node, error) {
+	var nodesByIP map[string]*Node
+	var allIPs []string
+	var err error
+	if network := protocol.ActiveNetwork(); network == protocol.MainNet {
+		nodesByIP, allIPs, err = fetchBitnodes()
+	} else {
+		nodesByIP, allIPs, err = fetchSeedNodes(network)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info().Int("count", len(allIPs)).Msg("Found IPv4 nodes, looking up geolocation")
+
+	nodesByCountry := make(map[string][]*Node)
+	maxNodes := 1000
+	nodesPerCountry := 10 // Keep 10 candidates per country for failover
+
+	ips := allIPs[:min(len(allIPs), maxNodes)]
+	geoMap, err := lookupGeo(ips)
+	if err != nil {
+		logger.Log.Warn().Err(err).Int("located", len(geoMap)).Msg("Geo lookup failed for some nodes")
+	}
+
+	// In discovery order, so the per-country cap keeps the same candidates
+	// whichever provider answered
+	for _, ip := range ips {
+		geo, ok := geoMap[ip]
+		if !ok {
+			continue
+		}
+		node := nodesByIP[ip]
+		node.CountryCode = geo.CountryCode
+		node.City = geo.City
+		node.Latitude = geo.Lat
+		node.Longitude = geo.Lon
+		node.ASN = geo.AS
+		node.OrgName = geo.Org
+		noteExpansionNode(node, expansionDiscovery)
+
+		// Only add if it's a wanted country and we don't have enough
+		// candidates, or its AS is wanted by a scheduled peer policy
+		keep, uncapped := wantedCandidate(node)
+		if keep && (uncapped || len(nodesByCountry[node.CountryCode]) < nodesPerCountry) {
+			nodesByCountry[node.CountryCode] = append(nodesByCountry[node.CountryCode], node)
+		}
+	}
+
+	for country, nodes := range nodesByCountry {
+		logger.Log.Info().Str("country", country).Int("count", len(nodes)).Msg("Found nodes")
+	}
+
+	return nodesByCountry, nil
+}
+
+// fetchBitnodes lists reachable IPv4 mainnet nodes from bitnodes.io,
+// keeping its onion nodes for StartTorPeers
+func fetchBitnodes() (map[string]*Node, []string, error) {
 	logger.Log.Info().Msg("Fetching nodes from bitnodes.io")
 
 	var resp *http.Response
 	var err error
 	for attempt := 0; attempt < 3; attempt++ {
-		resp, err = http.Get(bitnodesAPI)
+		resp, err = discoveryClient().Get(bitnodesAPI)
 		if err != nil {
-			return nil, fmt.Errorf("HTTP GET failed: %w", err)
+			return nil, nil, fmt.Errorf("HTTP GET failed: %w", err)
 		}
 		if resp.StatusCode == 200 {
 			break
@@ -75,10 +187,10 @@ func FetchNodes() (map[string][]*Node, error) {
 			time.Sleep(backoff)
 			continue
 		}
-		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed after retries, status: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("failed after retries, status: %d", resp.StatusCode)
 	}
 	defer resp.Body.Close()
 
@@ -86,7 +198,7 @@ func FetchNodes() (map[string][]*Node, error) {
 		Nodes map[string][]interface{} `json:"nodes"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("JSON decode failed: %w", err)
+		return nil, nil, fmt.Errorf("JSON decode failed: %w", err)
 	}
 
 	logger.Log.Info().Int("count", len(result.Nodes)).Msg("Retrieved nodes from bitnodes")
@@ -94,6 +206,7 @@ func FetchNodes() (map[string][]*Node, error) {
 	// Collect all valid IPv4 nodes
 	nodesByIP := make(map[string]*Node)
 	var allIPs []string
+	var onions []*Node
 
 	for addrPort, data := range result.Nodes {
 		if len(data) < 5 {
@@ -113,8 +226,9 @@ func FetchNodes() (map[string][]*Node, error) {
 		addr = parts[0]
 		fmt.Sscanf(parts[1], "%d", &port)
 
-		// Skip .onion and non-IPv4
+		// Onion nodes are kept for the Tor routine; skip other non-IPv4
 		if strings.HasSuffix(addr, ".onion") {
+			onions = append(onions, &Node{Address: addr, Port: port, CountryCode: TorRegion})
 			continue
 		}
 		if net.ParseIP(addr) == nil || net.ParseIP(addr).To4() == nil {
@@ -132,54 +246,46 @@ func FetchNodes() (map[string][]*Node, error) {
 		nodesByIP[addr] = node
 		allIPs = append(allIPs, addr)
 	}
+	bitnodesOnions.Store(&onions)
 
-	logger.Log.Info().Int("count", len(allIPs)).Msg("Found IPv4 nodes, looking up geolocation")
-
-	// Batch lookup geolocation (100 IPs per request)
-	nodesByCountry := make(map[string][]*Node)
-	batchSize := 100
-	maxNodes := 1000
-	nodesPerCountry := 10 // Keep 10 candidates per country for failover
+	return nodesByIP, allIPs, nil
+}
 
-	for i := 0; i < len(allIPs) && i < maxNodes; i += batchSize {
-		end := i + batchSize
-		if end > len(allIPs) {
-			end = len(allIPs)
-		}
-		batch := allIPs[i:end]
+// fetchSeedNodes resolves the network's DNS seeds to IPv4 nodes on its
+// default port
+func fetchSeedNodes(network *protocol.NetworkParams) (map[string]*Node, []string, error) {
+	if len(network.DNSSeeds) == 0 {
+		return nil, nil, fmt.Errorf("%s has no DNS seeds; use static_peers", network.Name)
+	}
+	logger.Log.Info().Str("network", network.Name).Msg("Resolving DNS seeds")
 
-		geoMap, err := lookupGeoBatch(batch)
+	nodesByIP := make(map[string]*Node)
+	var allIPs []string
+	for _, seed := range network.DNSSeeds {
+		ips, err := lookupIP(seed)
 		if err != nil {
-			logger.Log.Warn().Err(err).Msg("Batch geo lookup failed")
+			logger.Log.Warn().Err(err).Str("seed", seed).Msg("DNS seed lookup failed")
 			continue
 		}
-
-		for ip, geo := range geoMap {
-			node := nodesByIP[ip]
-			node.CountryCode = geo.CountryCode
-			node.City = geo.City
-			node.Latitude = geo.Lat
-			node.Longitude = geo.Lon
-			node.ASN = geo.AS
-			node.OrgName = geo.Org
-
-			// Only add if it's a target country and we don't have enough candidates
-			if IsTargetCountry(node.CountryCode) && len(nodesByCountry[node.CountryCode]) < nodesPerCountry {
-				nodesByCountry[node.CountryCode] = append(nodesByCountry[node.CountryCode], node)
+		for _, ip := range ips {
+			if ip.To4() == nil {
+				continue
 			}
+			addr := ip.String()
+			if _, ok := nodesByIP[addr]; ok {
+				continue
+			}
+			nodesByIP[addr] = &Node{Address: addr, Port: network.DefaultPort}
+			allIPs = append(allIPs, addr)
 		}
-
-		// Rate limit between batches
-		time.Sleep(100 * time.Millisecond)
 	}
-
-	for country, nodes := range nodesByCountry {
-		logger.Log.Info().Str("country", country).Int("count", len(nodes)).Msg("Found nodes")
-	}
-
-	return nodesByCountry, nil
+	logger.Log.Info().Int("count", len(allIPs)).Msg("Retrieved nodes from DNS seeds")
+	return nodesByIP, allIPs, nil
 }
 
+// lastDiscovery is when the peer pool was last refreshed, in Unix nanoseconds
+var lastDiscovery atomic.Int64
+
 // RefreshPeerPool fetches new nodes and updates the peer manager
 func RefreshPeerPool(pm *PeerManager) {
 	nodesByCountry, err := FetchNodes()
@@ -191,6 +297,16 @@ func RefreshPeerPool(pm *PeerManager) {
 	for country, nodes := range nodesByCountry {
 		pm.SetAvailable(country, nodes)
 	}
+	lastDiscovery.Store(time.Now().UnixNano())
+}
+
+// LastDiscovery returns when the peer pool was last refreshed, or the zero
+// time if it never has been
+func LastDiscovery() time.Time {
+	if at := lastDiscovery.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
 }
 
 // StartDiscoveryRoutine starts periodic peer discovery
@@ -0,0 +1,160 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Egress check verdicts
+const (
+	egressUnchecked int32 = iota
+	egressVerified
+	egressMismatch
+	egressFailed
+)
+
+// EgressCheckConfig asks a self-check service, through each dial source that
+// expects an exit country, where its traffic appears to come from
+type EgressCheckConfig struct {
+	// URL returns the caller's location as a JSON object (default ip-api.com)
+	URL string `json:"url"`
+	// CountryField is the object's country code field (default countryCode)
+	CountryField    string `json:"country_field"`
+	IntervalSeconds int    `json:"interval_seconds"` // default 300
+	TimeoutSeconds  int    `json:"timeout_seconds"`  // default 10
+}
+
+func (c *EgressCheckConfig) applyDefaults() {
+	if c.URL == "" {
+		c.URL = "http://ip-api.com/json?fields=status,query,countryCode"
+	}
+	if c.CountryField == "" {
+		c.CountryField = "countryCode"
+	}
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = 300
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = 10
+	}
+}
+
+// verified reports whether connections may leave from this source: it
+// expects no exit country, or the last egress check confirmed it
+func (s *dialSource) verified() bool {
+	return s.expect == "" || s.egress.Load() == egressVerified
+}
+
+// StartEgressCheck checks each dial source with an expected exit country
+// now and then on an interval. Until a source passes, no peers are dialed
+// through it; when it stops passing, its open connections are closed.
+func StartEgressCheck(ctx context.Context, cfg DialSourceConfig) {
+	s := peerDialSources.Load()
+	if s == nil || cfg.EgressCheck == nil {
+		return
+	}
+	check := *cfg.EgressCheck
+	check.applyDefaults()
+	for _, src := range s.all() {
+		if src.expect == "" {
+			continue
+		}
+		client := &http.Client{
+			Timeout:   time.Duration(check.TimeoutSeconds) * time.Second,
+			Transport: &http.Transport{DialContext: src.dialContext, DisableKeepAlives: true},
+		}
+		go func(src *dialSource) {
+			ticker := time.NewTicker(time.Duration(check.IntervalSeconds) * time.Second)
+			defer ticker.Stop()
+			for {
+				checkEgress(ctx, src, client, check)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(src)
+	}
+}
+
+func checkEgress(ctx context.Context, src *dialSource, client *http.Client, cfg EgressCheckConfig) {
+	country, err := egressCountry(ctx, client, cfg)
+	verdict := egressVerified
+	switch {
+	case err != nil:
+		verdict = egressFailed
+	case country != src.expect:
+		verdict = egressMismatch
+	}
+	prev := src.egress.Swap(verdict)
+
+	healthy := 0.0
+	if verdict == egressVerified {
+		healthy = 1
+	}
+	metrics.EgressHealthy.WithLabelValues(src.name).Set(healthy)
+
+	log := logger.Log.With().Stringer("source", src).Str("expected", src.expect).Logger()
+	switch {
+	case verdict == egressVerified && prev != egressVerified:
+		log.Info().Msg("Egress verified")
+	case verdict == egressMismatch && prev != egressMismatch:
+		log.Warn().Str("country", country).Msg("Egress exits in the wrong country")
+	case verdict == egressFailed && prev != egressFailed:
+		log.Warn().Err(err).Msg("Egress check failed")
+	}
+	if verdict != egressVerified && prev == egressVerified {
+		if n := closeSourceConns(src); n > 0 {
+			log.Warn().Int("peers", n).Msg("Closed connections through unverified egress")
+		}
+	}
+}
+
+// egressCountry asks the self-check service where the client's traffic exits
+func egressCountry(ctx context.Context, client *http.Client, cfg EgressCheckConfig) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("self-check service: %s", resp.Status)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err != nil {
+		return "", fmt.Errorf("self-check service: %w", err)
+	}
+	country, _ := body[cfg.CountryField].(string)
+	if country == "" {
+		return "", fmt.Errorf("self-check service: no %q in response", cfg.CountryField)
+	}
+	return strings.ToUpper(country), nil
+}
+
+// closeSourceConns closes the open connections that left from src,
+// returning how many
+func closeSourceConns(src *dialSource) int {
+	activeConns.Lock()
+	defer activeConns.Unlock()
+	closed := 0
+	for conn, stats := range activeConns.conns {
+		if stats.source == src {
+			conn.Close()
+			closed++
+		}
+	}
+	return closed
+}
@@ -0,0 +1,275 @@
+package observer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// ExpansionConfig ranks the countries and ASes that discovery and addr
+// gossip show nodes in, but that this observer has no peer in, as places
+// to add a vantage point. With AutoAdd set, the top country is added to
+// the peer policy's targets.
+type ExpansionConfig struct {
+	IntervalMinutes int `json:"interval_minutes"` // between analyses (default 60)
+	WindowHours     int `json:"window_hours"`     // nodes not seen again for this long are forgotten (default 24)
+	MinNodes        int `json:"min_nodes"`        // before a country or AS is suggested (default 5)
+	MaxSuggestions  int `json:"max_suggestions"`  // per kind, kept each analysis (default 20)
+
+	AutoAdd    bool `json:"auto_add"`     // add the top suggested country to the targets each analysis
+	AutoAddMax int  `json:"auto_add_max"` // countries added over the observer's lifetime (default 3)
+}
+
+func (c *ExpansionConfig) applyDefaults() {
+	if c.IntervalMinutes <= 0 {
+		c.IntervalMinutes = 60
+	}
+	if c.WindowHours <= 0 {
+		c.WindowHours = 24
+	}
+	if c.MinNodes <= 0 {
+		c.MinNodes = 5
+	}
+	if c.MaxSuggestions <= 0 {
+		c.MaxSuggestions = 20
+	}
+	if c.AutoAddMax <= 0 {
+		c.AutoAddMax = 3
+	}
+}
+
+// Where a geolocated node was found
+const (
+	expansionDiscovery = "discovery"
+	expansionGossip    = "gossip"
+)
+
+// expansionNode is a geolocated node seen outside the target countries
+type expansionNode struct {
+	node       *Node
+	discovered bool // listed by discovery, so known reachable
+	heard      int  // times gossiped to us
+	lastSeen   time.Time
+}
+
+// expansion collects the geolocated nodes, by address, while the analysis
+// is enabled
+var expansion = struct {
+	sync.Mutex
+	enabled bool
+	nodes   map[string]*expansionNode
+	added   int
+}{nodes: make(map[string]*expansionNode)}
+
+// noteExpansionNode records a geolocated node found by discovery or the
+// crawler
+func noteExpansionNode(node *Node, source string) {
+	if node.CountryCode == "" {
+		return
+	}
+	expansion.Lock()
+	defer expansion.Unlock()
+	if !expansion.enabled {
+		return
+	}
+	n := expansion.nodes[node.Addr()]
+	if n == nil {
+		n = &expansionNode{node: node}
+		expansion.nodes[node.Addr()] = n
+	}
+	n.lastSeen = time.Now()
+	if source == expansionDiscovery {
+		n.discovered = true
+	} else {
+		n.heard++
+	}
+}
+
+// noteExpansionGossip counts an address gossiped again after it was
+// geolocated
+func noteExpansionGossip(addr string) {
+	expansion.Lock()
+	defer expansion.Unlock()
+	if n := expansion.nodes[addr]; n != nil {
+		n.heard++
+		n.lastSeen = time.Now()
+	}
+}
+
+// StartExpansion analyses the collected nodes every interval until ctx is
+// done, saving the ranked suggestions. Gossiped addresses are only
+// geolocated with the crawler enabled.
+func StartExpansion(ctx context.Context, cfg ExpansionConfig, pm *PeerManager, db database.Storage) {
+	cfg.applyDefaults()
+	expansion.Lock()
+	expansion.enabled = true
+	expansion.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalMinutes) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				analyseExpansion(&cfg, pm, db)
+			}
+		}
+	}()
+}
+
+// analyseExpansion ranks the countries and ASes without a connected peer by
+// the nodes seen there, then by how often their addresses are gossiped
+func analyseExpansion(cfg *ExpansionConfig, pm *PeerManager, db database.Storage) {
+	covered := make(map[string]bool)
+	for _, c := range CurrentPeerPolicy().targetCountries() {
+		covered[c] = true
+	}
+	coveredASNs := make(map[string]bool)
+	activeConns.Lock()
+	for _, stats := range activeConns.conns {
+		covered[stats.node.CountryCode] = true
+		if asn := normalizeASN(stats.node.ASN); asn != "" {
+			coveredASNs[asn] = true
+		}
+	}
+	activeConns.Unlock()
+
+	countries := make(map[string]*database.RegionSuggestion)
+	asns := make(map[string]*database.RegionSuggestion)
+	countryASNs := make(map[string]map[string]bool)
+	candidates := make(map[string][]*Node)
+	cutoff := time.Now().Add(-time.Duration(cfg.WindowHours) * time.Hour)
+
+	expansion.Lock()
+	for addr, n := range expansion.nodes {
+		if n.lastSeen.Before(cutoff) {
+			delete(expansion.nodes, addr)
+			continue
+		}
+		country, asn := n.node.CountryCode, normalizeASN(n.node.ASN)
+		if !covered[country] {
+			s := countries[country]
+			if s == nil {
+				s = &database.RegionSuggestion{Kind: "country", Key: country, Country: country}
+				countries[country] = s
+				countryASNs[country] = make(map[string]bool)
+			}
+			tallyExpansion(s, n)
+			countryASNs[country][asn] = true
+			candidates[country] = append(candidates[country], n.node)
+		}
+		if asn != "" && !coveredASNs[asn] {
+			s := asns[asn]
+			if s == nil {
+				s = &database.RegionSuggestion{Kind: "asn", Key: asn, Country: country, OrgName: n.node.OrgName}
+				asns[asn] = s
+			}
+			tallyExpansion(s, n)
+		}
+	}
+	expansion.Unlock()
+	for country, s := range countries {
+		s.ASNs = len(countryASNs[country])
+	}
+
+	countryRanked, asnRanked := rankSuggestions(countries, cfg), rankSuggestions(asns, cfg)
+	if cfg.AutoAdd {
+		autoAddTopCountry(countryRanked, candidates, cfg, pm)
+	}
+	metrics.RegionSuggestions.WithLabelValues("country").Set(float64(len(countryRanked)))
+	metrics.RegionSuggestions.WithLabelValues("asn").Set(float64(len(asnRanked)))
+	if err := db.SaveRegionSuggestions(append(countryRanked, asnRanked...)); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to save region suggestions")
+	}
+	if len(countryRanked) > 0 {
+		logger.Log.Info().
+			Str("top_country", countryRanked[0].Key).
+			Int("nodes", countryRanked[0].Nodes).
+			Int("countries", len(countryRanked)).
+			Int("asns", len(asnRanked)).
+			Msg("Region expansion suggestions updated")
+	}
+}
+
+// tallyExpansion counts a node towards a suggestion
+func tallyExpansion(s *database.RegionSuggestion, n *expansionNode) {
+	s.Nodes++
+	if n.discovered {
+		s.Reachable++
+	}
+	s.Heard += n.heard
+}
+
+// rankSuggestions orders the suggestions with at least MinNodes nodes and
+// keeps the top MaxSuggestions
+func rankSuggestions(byKey map[string]*database.RegionSuggestion, cfg *ExpansionConfig) []database.RegionSuggestion {
+	var ranked []database.RegionSuggestion
+	for _, s := range byKey {
+		if s.Nodes >= cfg.MinNodes {
+			ranked = append(ranked, *s)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Nodes != ranked[j].Nodes {
+			return ranked[i].Nodes > ranked[j].Nodes
+		}
+		if ranked[i].Heard != ranked[j].Heard {
+			return ranked[i].Heard > ranked[j].Heard
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	if len(ranked) > cfg.MaxSuggestions {
+		ranked = ranked[:cfg.MaxSuggestions]
+	}
+	for i := range ranked {
+		ranked[i].Rank = i + 1
+	}
+	return ranked
+}
+
+// autoAddTopCountry adds the top suggested country to the peer policy's
+// targets and hands the peer manager its nodes as candidates. One country
+// is added per analysis, up to AutoAddMax over the observer's lifetime.
+func autoAddTopCountry(ranked []database.RegionSuggestion, candidates map[string][]*Node, cfg *ExpansionConfig, pm *PeerManager) {
+	if len(ranked) == 0 {
+		return
+	}
+	expansion.Lock()
+	if expansion.added >= cfg.AutoAddMax {
+		expansion.Unlock()
+		return
+	}
+	expansion.added++
+	expansion.Unlock()
+
+	country := ranked[0].Key
+	policy := *CurrentPeerPolicy()
+	if len(policy.Countries) > 0 {
+		policy.Countries = append(policy.Countries[:len(policy.Countries):len(policy.Countries)], country)
+	} else {
+		overrides := make(map[string]int, len(policy.CountryPeers)+1)
+		for c, n := range policy.CountryPeers {
+			overrides[c] = n
+		}
+		overrides[country] = policy.target(country)
+		policy.CountryPeers = overrides
+	}
+	WidenDiscovery(policy)
+	SetPeerPolicy(&policy)
+	pm.AddAvailable(country, candidates[country])
+	ranked[0].AutoAdded = true
+
+	metrics.RegionAutoAdded.Inc()
+	logger.Log.Info().
+		Str("country", country).
+		Int("nodes", ranked[0].Nodes).
+		Int("asns", ranked[0].ASNs).
+		Msg("Added suggested country to peer targets")
+}
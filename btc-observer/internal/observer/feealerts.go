@@ -0,0 +1,150 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Fee alert reasons
+const (
+	FeeAlertRate     = "fee_rate"
+	FeeAlertAbsolute = "absolute_fee"
+)
+
+const feeAlertWebhookTimeout = 10 * time.Second
+
+// FeeAlertConfig configures alerts for transactions paying extreme fees.
+// Fees are only known for transactions whose inputs are all stored.
+type FeeAlertConfig struct {
+	// MaxFeeRate alerts above this many sat/vB (default 1000)
+	MaxFeeRate float64 `json:"max_fee_rate"`
+
+	// MaxFeeSatoshis alerts above this absolute fee (default 1 BTC)
+	MaxFeeSatoshis int64 `json:"max_fee_satoshis"`
+
+	// DelaySeconds waits for the transaction to propagate before alerting,
+	// so the alert shows how far it spread (default 10)
+	DelaySeconds int `json:"delay_seconds"`
+
+	// WebhookURL, if set, receives each alert as a JSON POST
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+func (c *FeeAlertConfig) applyDefaults() {
+	if c.MaxFeeRate <= 0 {
+		c.MaxFeeRate = 1000
+	}
+	if c.MaxFeeSatoshis <= 0 {
+		c.MaxFeeSatoshis = 100_000_000
+	}
+	if c.DelaySeconds <= 0 {
+		c.DelaySeconds = 10
+	}
+}
+
+var feeAlerts atomic.Pointer[FeeAlertConfig]
+
+// SetFeeAlerts enables fee outlier alerts
+func SetFeeAlerts(cfg FeeAlertConfig) {
+	cfg.applyDefaults()
+	feeAlerts.Store(&cfg)
+}
+
+// checkFee schedules an alert if tx pays an extreme fee
+func checkFee(tx *protocol.Transaction, fee *database.Fee, plog zerolog.Logger, db database.Storage) {
+	cfg := feeAlerts.Load()
+	if cfg == nil || fee == nil {
+		return
+	}
+	var reason string
+	switch {
+	case fee.Satoshis > cfg.MaxFeeSatoshis:
+		reason = FeeAlertAbsolute
+	case fee.Rate() > cfg.MaxFeeRate:
+		reason = FeeAlertRate
+	default:
+		return
+	}
+
+	txHash := tx.TxID
+	f := *fee
+	time.AfterFunc(time.Duration(cfg.DelaySeconds)*time.Second, func() {
+		raiseFeeAlert(cfg, txHash, reason, f, plog, db)
+	})
+}
+
+func raiseFeeAlert(cfg *FeeAlertConfig, txHash [32]byte, reason string, fee database.Fee, plog zerolog.Logger, db database.Storage) {
+	alert, created, err := db.RecordFeeAlert(txHash[:], reason, fee)
+	if err != nil {
+		logger.Error(plog, err, "DB RecordFeeAlert error")
+		return
+	}
+	if !created {
+		return // another peer's copy already raised it
+	}
+
+	metrics.FeeAlerts.WithLabelValues(reason).Inc()
+	data := events.FeeAlert{
+		TxID:        txHash,
+		Reason:      reason,
+		FeeSatoshis: fee.Satoshis,
+		FeeRate:     fee.Rate(),
+		FirstSeenAt: alert.FirstSeenAt,
+		FirstPeer:   alert.FirstPeer,
+		FirstRegion: alert.FirstRegion,
+		PeerCount:   alert.PeerCount,
+		RegionCount: alert.RegionCount,
+	}
+	events.Publish(events.FeeOutlier, data)
+	logger.Log.Warn().
+		Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(txHash[:]))).
+		Str("reason", reason).
+		Int64("fee_sats", fee.Satoshis).
+		Float64("fee_rate", fee.Rate()).
+		Str("first_peer", alert.FirstPeer).
+		Str("first_region", alert.FirstRegion).
+		Int("peers", alert.PeerCount).
+		Int("regions", alert.RegionCount).
+		Msg("Fee outlier observed")
+
+	if cfg.WebhookURL != "" {
+		if err := postFeeAlert(cfg.WebhookURL, data); err != nil {
+			logger.Log.Warn().Err(err).Msg("Fee alert webhook failed")
+		}
+	}
+}
+
+func postFeeAlert(url string, alert events.FeeAlert) error {
+	body, err := json.Marshal(events.Event{Type: events.FeeOutlier, Time: time.Now(), Data: alert})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), feeAlertWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := discoveryClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package observer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Fork check results
+const (
+	ChainInSync    = "in_sync"
+	ChainAhead     = "ahead"
+	ChainBehind    = "behind"
+	ChainDivergent = "divergent"
+	ChainUnknown   = "unknown"
+)
+
+// forkProbeTimeout drops a getheaders probe the peer never answered
+const forkProbeTimeout = 2 * time.Minute
+
+// ForkMonitorConfig enables periodic chain comparison with each peer
+type ForkMonitorConfig struct {
+	// IntervalSeconds is how often each peer is asked for headers (default 600)
+	IntervalSeconds int `json:"interval_seconds"`
+}
+
+var forkCheckInterval atomic.Int64
+
+// SetForkMonitor enables fork monitoring for peers connected from now on
+func SetForkMonitor(cfg ForkMonitorConfig) {
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 600
+	}
+	forkCheckInterval.Store(int64(cfg.IntervalSeconds) * int64(time.Second))
+}
+
+// divergentPeers tracks connected peers whose last check was divergent
+var divergentPeers = struct {
+	sync.Mutex
+	peers map[string]bool
+}{peers: make(map[string]bool)}
+
+func setDivergent(peer string, divergent bool) {
+	divergentPeers.Lock()
+	defer divergentPeers.Unlock()
+	if divergent {
+		divergentPeers.peers[peer] = true
+	} else {
+		delete(divergentPeers.peers, peer)
+	}
+	metrics.PeersDivergent.Set(float64(len(divergentPeers.peers)))
+}
+
+// forkProbe is an outstanding getheaders request
+type forkProbe struct {
+	sentAt  time.Time
+	locator []database.ChainPoint
+}
+
+// forkMonitor compares one peer's chain with ours by sending it our block
+// locator and checking the headers it returns against the stored chain
+type forkMonitor struct {
+	address  string
+	interval time.Duration
+	lastSent time.Time
+	pending  *forkProbe
+	plog     zerolog.Logger
+	db       database.Storage
+}
+
+// newForkMonitor returns nil when fork monitoring is disabled
+func newForkMonitor(address string, plog zerolog.Logger, db database.Storage) *forkMonitor {
+	interval := time.Duration(forkCheckInterval.Load())
+	if interval <= 0 {
+		return nil
+	}
+	return &forkMonitor{address: address, interval: interval, plog: plog, db: db}
+}
+
+// maybeProbe sends a getheaders request when one is due
+func (m *forkMonitor) maybeProbe(conn net.Conn) {
+	if m.pending != nil && time.Since(m.pending.sentAt) > forkProbeTimeout {
+		m.plog.Debug().Msg("Fork probe unanswered")
+		m.pending = nil
+	}
+	if m.pending != nil || time.Since(m.lastSent) < m.interval {
+		return
+	}
+	m.lastSent = time.Now()
+
+	locator, err := m.db.ChainLocator()
+	if err != nil {
+		logger.Error(m.plog, err, "DB ChainLocator error")
+		return
+	}
+	if len(locator) == 0 {
+		return
+	}
+	if last := locator[len(locator)-1]; last.Height != 0 {
+		locator = append(locator, database.ChainPoint{Height: 0, Hash: *protocol.ActiveNetwork().Chain.GenesisHash})
+	}
+
+	hashes := make([][32]byte, len(locator))
+	for i, p := range locator {
+		hashes[i] = p.Hash
+	}
+	packet := protocol.CreateMessagePacket("getheaders", protocol.CreateGetHeadersPayload(hashes, [32]byte{}))
+	if _, err := conn.Write(packet); err != nil {
+		return
+	}
+	noteHeadersAsked(conn.RemoteAddr().String())
+	m.pending = &forkProbe{sentAt: time.Now(), locator: locator}
+}
+
+// awaitingHeaders reports whether a probe is waiting for its headers
+func (m *forkMonitor) awaitingHeaders() bool {
+	return m != nil && m.pending != nil
+}
+
+// handleHeaders classifies the reply to the pending probe. Headers arriving
+// without a probe outstanding are ignored.
+func (m *forkMonitor) handleHeaders(payload []byte) {
+	probe := m.pending
+	if probe == nil {
+		return
+	}
+	m.pending = nil
+
+	entries, err := protocol.ParseHeadersMessage(payload)
+	if err != nil {
+		m.plog.Debug().Err(err).Msg("Bad headers message")
+		return
+	}
+	status, err := m.classify(probe.locator, entries)
+	if err != nil {
+		logger.Error(m.plog, err, "DB ChainHashes error")
+		return
+	}
+
+	metrics.ForkChecks.WithLabelValues(status.Status).Inc()
+	setDivergent(m.address, status.Status == ChainDivergent)
+	if status.Status == ChainDivergent {
+		m.plog.Warn().
+			Int32("fork_height", status.ForkPoint.Height).
+			Str("fork_hash", fmt.Sprintf("%x", protocol.ReverseBytes(status.ForkPoint.Hash[:]))).
+			Int32("peer_tip_height", status.PeerTip.Height).
+			Int32("our_tip_height", status.OurTipHeight).
+			Msg("Peer is on a divergent chain")
+	}
+	if err := m.db.RecordPeerChainStatus(m.address, status); err != nil {
+		logger.Error(m.plog, err, "DB RecordPeerChainStatus error")
+	}
+}
+
+// classify compares the headers a peer sent after the first locator entry it
+// knows with the stored chain. A peer with nothing after our tip, or lagging
+// within the dense top of the locator, sends no headers and counts as in sync.
+func (m *forkMonitor) classify(locator []database.ChainPoint, entries []protocol.HeaderEntry) (database.PeerChainStatus, error) {
+	tip := locator[0]
+	status := database.PeerChainStatus{OurTipHeight: tip.Height}
+	if len(entries) == 0 {
+		status.Status = ChainInSync
+		status.PeerTip = &tip
+		return status, nil
+	}
+
+	// The headers must extend a locator entry and link to each other
+	base := -1
+	for i, p := range locator {
+		if p.Hash == entries[0].Header.PrevBlockHash {
+			base = i
+			break
+		}
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Header.PrevBlockHash != entries[i-1].Hash {
+			base = -1
+			break
+		}
+	}
+	if base < 0 {
+		status.Status = ChainUnknown
+		return status, nil
+	}
+	baseHeight := locator[base].Height
+	status.PeerTip = &database.ChainPoint{
+		Height: baseHeight + int32(len(entries)),
+		Hash:   entries[len(entries)-1].Hash,
+	}
+
+	if baseHeight < tip.Height {
+		stored, err := m.db.ChainHashes(baseHeight+1, min(tip.Height, status.PeerTip.Height))
+		if err != nil {
+			return status, err
+		}
+		for i, e := range entries {
+			height := baseHeight + 1 + int32(i)
+			if height > tip.Height {
+				break
+			}
+			if hash, ok := stored[height]; ok && hash != e.Hash {
+				status.Status = ChainDivergent
+				status.ForkPoint = &database.ChainPoint{Height: height - 1, Hash: e.Header.PrevBlockHash}
+				return status, nil
+			}
+		}
+	}
+
+	switch {
+	case status.PeerTip.Height > tip.Height:
+		status.Status = ChainAhead
+	case status.PeerTip.Height < tip.Height:
+		status.Status = ChainBehind
+	default:
+		status.Status = ChainInSync
+	}
+	return status, nil
+}
+
+// close drops the peer from the divergent count when it disconnects
+func (m *forkMonitor) close() {
+	setDivergent(m.address, false)
+}
@@ -0,0 +1,152 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// ProbeFundingConfig tops up the probe wallet when its balance runs low, so
+// probes keep running unattended. Funds come from another Bitcoin Core
+// wallet over RPC, or from an HTTP faucet.
+type ProbeFundingConfig struct {
+	MinBalanceSats  int64 `json:"min_balance_sats"` // top up below this (default two rounds of probes)
+	TopUpSats       int64 `json:"top_up_sats"`      // asked for each time (default ten rounds of probes)
+	CooldownMinutes int   `json:"cooldown_minutes"` // between requests, while the last one confirms (default 60)
+
+	// A funding wallet on a node of the same network, paying with sendtoaddress
+	RPCURL      string `json:"rpc_url"`
+	RPCUser     string `json:"rpc_user"`
+	RPCPassword string `json:"rpc_password"`
+	Wallet      string `json:"wallet"`
+
+	// FaucetURL is requested with {address} and {sats} replaced by the
+	// probe wallet's new address and TopUpSats
+	FaucetURL    string `json:"faucet_url"`
+	FaucetMethod string `json:"faucet_method"` // default POST
+}
+
+func (c *ProbeFundingConfig) applyDefaults(round int64) {
+	if c.MinBalanceSats <= 0 {
+		c.MinBalanceSats = 2 * round
+	}
+	if c.TopUpSats <= 0 {
+		c.TopUpSats = 10 * round
+	}
+	if c.CooldownMinutes <= 0 {
+		c.CooldownMinutes = 60
+	}
+	if c.FaucetMethod == "" {
+		c.FaucetMethod = http.MethodPost
+	}
+}
+
+// funder pays the probe wallet from the configured source
+type funder struct {
+	cfg      ProbeFundingConfig
+	rpc      *rpcClient   // set for a funding wallet
+	client   *http.Client // set for a faucet
+	source   string
+	lastTime time.Time
+}
+
+func newFunder(cfg ProbeFundingConfig) (*funder, error) {
+	switch {
+	case (cfg.RPCURL == "") == (cfg.FaucetURL == ""):
+		return nil, fmt.Errorf("probe funding needs either rpc_url or faucet_url")
+	case cfg.RPCURL != "":
+		rpc, err := newRPCClient(cfg.RPCURL, cfg.RPCUser, cfg.RPCPassword, 30*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("probe funding: %w", err)
+		}
+		return &funder{cfg: cfg, rpc: rpc.wallet(cfg.Wallet), source: "rpc"}, nil
+	default:
+		if _, err := url.Parse(cfg.FaucetURL); err != nil {
+			return nil, fmt.Errorf("probe funding: faucet_url: %w", err)
+		}
+		return &funder{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}, source: "faucet"}, nil
+	}
+}
+
+// ensureFunds asks the funding source for a top-up when the wallet's
+// confirmed and pending balance is below the minimum. Pending funds count,
+// so a top-up that hasn't confirmed yet isn't requested again; the cooldown
+// covers one that never arrives.
+func (p *prober) ensureFunds(ctx context.Context) {
+	f := p.funder
+	if f == nil || time.Since(f.lastTime) < time.Duration(f.cfg.CooldownMinutes)*time.Minute {
+		return
+	}
+	var balances struct {
+		Mine struct {
+			Trusted          json.Number `json:"trusted"`
+			UntrustedPending json.Number `json:"untrusted_pending"`
+		} `json:"mine"`
+	}
+	if err := p.wallet.call(ctx, "getbalances", nil, &balances); err != nil {
+		logger.Log.Warn().Err(err).Msg("Failed to read relay probe wallet balance")
+		return
+	}
+	trusted, err1 := btcToSats(balances.Mine.Trusted)
+	pending, err2 := btcToSats(balances.Mine.UntrustedPending)
+	if err1 != nil || err2 != nil {
+		logger.Log.Warn().Str("trusted", balances.Mine.Trusted.String()).Msg("Unreadable relay probe wallet balance")
+		return
+	}
+	metrics.RelayProbeWalletBalance.Set(float64(trusted + pending))
+	if trusted+pending >= f.cfg.MinBalanceSats {
+		return
+	}
+
+	f.lastTime = time.Now()
+	var addr string
+	if err := p.wallet.call(ctx, "getnewaddress", []interface{}{"", "bech32"}, &addr); err != nil {
+		logger.Log.Warn().Err(err).Msg("Failed to get relay probe funding address")
+		return
+	}
+	if err := f.request(ctx, addr); err != nil {
+		metrics.RelayProbeFunding.WithLabelValues(f.source, "failed").Inc()
+		logger.Log.Warn().Err(err).Str("source", f.source).Msg("Relay probe wallet top-up failed")
+		return
+	}
+	metrics.RelayProbeFunding.WithLabelValues(f.source, "requested").Inc()
+	logger.Log.Info().
+		Str("source", f.source).
+		Int64("balance_sats", trusted+pending).
+		Int64("top_up_sats", f.cfg.TopUpSats).
+		Str("address", addr).
+		Msg("Relay probe wallet top-up requested")
+}
+
+// request has the source pay TopUpSats to addr
+func (f *funder) request(ctx context.Context, addr string) error {
+	if f.rpc != nil {
+		var txid string
+		return f.rpc.call(ctx, "sendtoaddress", []interface{}{addr, satsToBTC(f.cfg.TopUpSats)}, &txid)
+	}
+	target := strings.NewReplacer(
+		"{address}", url.QueryEscape(addr),
+		"{sats}", strconv.FormatInt(f.cfg.TopUpSats, 10),
+	).Replace(f.cfg.FaucetURL)
+	req, err := http.NewRequestWithContext(ctx, f.cfg.FaucetMethod, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("faucet: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package observer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// GeoProvider geolocates IPs. IPs it can't locate are left out of the
+// result; an error with a partial result means some lookups failed.
+type GeoProvider interface {
+	Lookup(ips []string) (map[string]*GeoResult, error)
+}
+
+const (
+	// ipAPIBatchSize is the most IPs ip-api's batch endpoint takes at once
+	ipAPIBatchSize = 100
+	// ipAPIBatchPause keeps consecutive batches under ip-api's rate limit
+	ipAPIBatchPause = 100 * time.Millisecond
+)
+
+// IPAPI looks IPs up with ip-api.com's batch endpoint
+type IPAPI struct{}
+
+// Lookup queries ip-api in batches of 100. A failed batch is skipped and
+// the rest still looked up.
+func (IPAPI) Lookup(ips []string) (map[string]*GeoResult, error) {
+	located := make(map[string]*GeoResult, len(ips))
+	var firstErr error
+	for start := 0; start < len(ips); start += ipAPIBatchSize {
+		if start > 0 {
+			time.Sleep(ipAPIBatchPause)
+		}
+		geoMap, err := LookupGeoBatch(ips[start:min(start+ipAPIBatchSize, len(ips))])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for ip, geo := range geoMap {
+			located[ip] = geo
+		}
+	}
+	return located, firstErr
+}
+
+// GeoCacheConfig keeps resolved locations in the database, so IPs seen
+// again aren't looked up again until their entry is MaxAgeHours old
+type GeoCacheConfig struct {
+	MaxAgeHours int `json:"max_age_hours"` // default 168
+}
+
+func (c *GeoCacheConfig) applyDefaults() {
+	if c.MaxAgeHours <= 0 {
+		c.MaxAgeHours = 168
+	}
+}
+
+// CachedGeo answers from the database's geo cache and asks next only for
+// the IPs missing or expired there, saving what it resolves
+type CachedGeo struct {
+	next   GeoProvider
+	db     database.Storage
+	maxAge time.Duration
+}
+
+// NewCachedGeo puts the database's geo cache in front of next
+func NewCachedGeo(next GeoProvider, cfg GeoCacheConfig, db database.Storage) *CachedGeo {
+	cfg.applyDefaults()
+	return &CachedGeo{next: next, db: db, maxAge: time.Duration(cfg.MaxAgeHours) * time.Hour}
+}
+
+// Lookup returns cached locations and resolves the rest. A cache that
+// can't be read is bypassed rather than failing the lookup.
+func (c *CachedGeo) Lookup(ips []string) (map[string]*GeoResult, error) {
+	cached, err := c.db.CachedGeo(ips, c.maxAge)
+	if err != nil {
+		logger.Log.Warn().Err(err).Msg("Geo cache read failed")
+	}
+	located := make(map[string]*GeoResult, len(ips))
+	var missing []string
+	for _, ip := range ips {
+		e, ok := cached[ip]
+		if !ok {
+			missing = append(missing, ip)
+			continue
+		}
+		located[ip] = &GeoResult{
+			Status:      "success",
+			Query:       ip,
+			Country:     e.Country,
+			CountryCode: e.CountryCode,
+			City:        e.City,
+			Lat:         e.Latitude,
+			Lon:         e.Longitude,
+			ISP:         e.ISP,
+			Org:         e.Org,
+			AS:          e.AS,
+		}
+	}
+	metrics.GeoLookups.WithLabelValues("cached").Add(float64(len(located)))
+	if len(missing) == 0 {
+		return located, nil
+	}
+
+	resolved, lookupErr := c.next.Lookup(missing)
+	metrics.GeoLookups.WithLabelValues("resolved").Add(float64(len(resolved)))
+	metrics.GeoLookups.WithLabelValues("unresolved").Add(float64(len(missing) - len(resolved)))
+	entries := make([]database.GeoCacheEntry, 0, len(resolved))
+	for ip, geo := range resolved {
+		located[ip] = geo
+		entries = append(entries, database.GeoCacheEntry{
+			IP:          ip,
+			Country:     geo.Country,
+			CountryCode: geo.CountryCode,
+			City:        geo.City,
+			Latitude:    geo.Lat,
+			Longitude:   geo.Lon,
+			ISP:         geo.ISP,
+			Org:         geo.Org,
+			AS:          geo.AS,
+		})
+	}
+	if len(entries) > 0 {
+		if err := c.db.SaveGeo(entries); err != nil {
+			logger.Log.Warn().Err(err).Int("ips", len(entries)).Msg("Geo cache write failed")
+		}
+	}
+	return located, lookupErr
+}
+
+var geoProvider atomic.Pointer[GeoProvider]
+
+// SetGeoProvider replaces ip-api as the source of discovered and crawled
+// nodes' locations
+func SetGeoProvider(p GeoProvider) {
+	geoProvider.Store(&p)
+}
+
+// lookupGeo locates ips with the configured provider, ip-api by default
+func lookupGeo(ips []string) (map[string]*GeoResult, error) {
+	if p := geoProvider.Load(); p != nil {
+		return (*p).Lookup(ips)
+	}
+	return IPAPI{}.Lookup(ips)
+}
@@ -0,0 +1,150 @@
+package observer
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// GeoBackfillConfig geolocates peers recorded without a location, such as
+// ones recorded before geolocation or static peers, through the configured
+// provider. Batches are spaced out so the backfill leaves the provider's
+// rate limit to discovery and the crawler.
+type GeoBackfillConfig struct {
+	BatchSize     int `json:"batch_size"`     // peers looked up at once (default 100)
+	BatchSeconds  int `json:"batch_seconds"`  // between batches (default 10)
+	IntervalHours int `json:"interval_hours"` // between passes over the recorded peers (default 24)
+}
+
+func (c *GeoBackfillConfig) applyDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.BatchSeconds <= 0 {
+		c.BatchSeconds = 10
+	}
+	if c.IntervalHours <= 0 {
+		c.IntervalHours = 24
+	}
+}
+
+// StartGeoBackfill passes over the recorded peers missing a location at
+// startup and every IntervalHours until ctx is done
+func StartGeoBackfill(ctx context.Context, cfg GeoBackfillConfig, db database.Storage) {
+	cfg.applyDefaults()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalHours) * time.Hour)
+		defer ticker.Stop()
+		for {
+			backfillGeo(ctx, &cfg, db)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// backfillGeo pages through the peers missing a location and stores what
+// the provider resolves. Peers it can't locate are paged past and retried
+// on the next pass.
+func backfillGeo(ctx context.Context, cfg *GeoBackfillConfig, db database.Storage) {
+	pause := time.Duration(cfg.BatchSeconds) * time.Second
+	after := ""
+	located, unresolved := 0, 0
+	for {
+		peers, err := db.PeersMissingGeo(after, cfg.BatchSize)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list peers missing geo")
+			return
+		}
+		if len(peers) == 0 {
+			break
+		}
+		after = peers[len(peers)-1].PeerAddr
+
+		// A node can be recorded under several ports; it is looked up once.
+		// Onion and I2P peers have no IP to locate.
+		byIP := make(map[string][]database.PeerMissingGeo)
+		var ips []string
+		for _, p := range peers {
+			switch protocol.AddressNetwork(p.PeerAddr) {
+			case protocol.NetIPv4, protocol.NetIPv6:
+			default:
+				continue
+			}
+			host, _, err := net.SplitHostPort(p.PeerAddr)
+			if err != nil {
+				continue
+			}
+			if _, ok := byIP[host]; !ok {
+				ips = append(ips, host)
+			}
+			byIP[host] = append(byIP[host], p)
+		}
+		if len(ips) > 0 {
+			geoMap, err := lookupGeo(ips)
+			if err != nil {
+				logger.Log.Warn().Err(err).Int("located", len(geoMap)).Msg("Geo backfill lookup failed for some peers")
+			}
+			for _, ip := range ips {
+				geo, ok := geoMap[ip]
+				if !ok {
+					unresolved += len(byIP[ip])
+					metrics.GeoBackfillPeers.WithLabelValues("unresolved").Add(float64(len(byIP[ip])))
+					continue
+				}
+				for _, p := range byIP[ip] {
+					if storePeerGeo(p, ip, geo, db) {
+						located++
+						metrics.GeoBackfillPeers.WithLabelValues("located").Inc()
+					}
+				}
+			}
+		}
+		if len(peers) < cfg.BatchSize {
+			break
+		}
+		if len(ips) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+	}
+	if located+unresolved > 0 {
+		logger.Log.Info().Int("located", located).Int("unresolved", unresolved).Msg("Geo backfill pass done")
+	}
+}
+
+// storePeerGeo saves a backfilled location. A peer keeps the region it was
+// recorded under; one recorded without a region gets the one it would be
+// observed under now.
+func storePeerGeo(p database.PeerMissingGeo, ip string, geo *GeoResult, db database.Storage) bool {
+	region := p.Region
+	if region == "" {
+		region = peerRegion(&Node{Address: ip, ASN: geo.AS}, geo.CountryCode)
+	}
+	err := db.UpdatePeerGeoInfo(p.PeerAddr, &database.PeerGeoInfo{
+		CountryCode: geo.CountryCode,
+		City:        geo.City,
+		Region:      region,
+		Latitude:    geo.Lat,
+		Longitude:   geo.Lon,
+		ASN:         geo.AS,
+		OrgName:     geo.Org,
+	})
+	if err != nil {
+		logger.Log.Error().Err(err).Str("peer", p.PeerAddr).Msg("DB UpdatePeerGeoInfo error")
+		return false
+	}
+	return true
+}
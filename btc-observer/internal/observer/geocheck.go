@@ -0,0 +1,88 @@
+package observer
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/triangulate"
+	"github.com/rs/zerolog"
+)
+
+// GeoCheckConfig places the observer for RTT sanity checks of peer GeoIP
+// locations. A peer whose fastest ping beats light in fiber from here to its
+// GeoIP location is flagged: usually an anycast address or a VPN exit.
+type GeoCheckConfig struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// MinSamples is how many pings to measure before judging (default 3)
+	MinSamples int `json:"min_samples"`
+	// SpeedKmPerMs is the signal speed in fiber (default 200, about 2/3 c)
+	SpeedKmPerMs float64 `json:"speed_km_per_ms"`
+	// SlackMs absorbs GeoIP coordinates being a city or country centroid
+	// (default 5)
+	SlackMs int `json:"slack_ms"`
+}
+
+func (c *GeoCheckConfig) applyDefaults() {
+	if c.MinSamples <= 0 {
+		c.MinSamples = 3
+	}
+	if c.SpeedKmPerMs <= 0 {
+		c.SpeedKmPerMs = 200
+	}
+	if c.SlackMs <= 0 {
+		c.SlackMs = 5
+	}
+}
+
+var geoCheck atomic.Pointer[GeoCheckConfig]
+
+// SetGeoCheck enables RTT checks from the given vantage point
+func SetGeoCheck(cfg GeoCheckConfig) error {
+	if cfg.Latitude < -90 || cfg.Latitude > 90 || cfg.Longitude < -180 || cfg.Longitude > 180 {
+		return fmt.Errorf("geo check location %.4f,%.4f out of range", cfg.Latitude, cfg.Longitude)
+	}
+	cfg.applyDefaults()
+	geoCheck.Store(&cfg)
+	return nil
+}
+
+// checkGeoRTT records a ping round trip. Once enough are measured, and again
+// whenever the fastest one improves, it compares that against the physical
+// minimum for the peer's GeoIP location.
+func checkGeoRTT(stats *connStats, rttMs int, address, region string, plog zerolog.Logger, db database.Storage) {
+	cfg := geoCheck.Load()
+	node := stats.node
+	if cfg == nil || (node.Latitude == 0 && node.Longitude == 0) {
+		return
+	}
+
+	stats.rttSamples++
+	improved := stats.rttSamples == 1 || rttMs < stats.minRTTMs
+	if improved {
+		stats.minRTTMs = rttMs
+	}
+	if stats.rttSamples < cfg.MinSamples || (stats.rttSamples > cfg.MinSamples && !improved) {
+		return
+	}
+
+	distanceKm := triangulate.HaversineKm(cfg.Latitude, cfg.Longitude, node.Latitude, node.Longitude)
+	boundMs := int(2 * distanceKm / cfg.SpeedKmPerMs)
+	suspect := stats.minRTTMs+cfg.SlackMs < boundMs
+	if suspect && !stats.geoSuspect {
+		metrics.GeoSuspectPeers.WithLabelValues(region).Inc()
+		plog.Warn().
+			Str("country", node.CountryCode).
+			Int("min_rtt_ms", stats.minRTTMs).
+			Int("bound_ms", boundMs).
+			Msg("RTT too low for GeoIP location")
+	}
+	stats.geoSuspect = suspect
+
+	if err := db.UpdatePeerGeoCheck(address, stats.minRTTMs, boundMs, suspect); err != nil {
+		logger.Error(plog, err, "DB UpdatePeerGeoCheck error")
+	}
+}
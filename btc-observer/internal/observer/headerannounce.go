@@ -0,0 +1,77 @@
+package observer
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// sendHeadersVersion is the first protocol version that understands
+	// sendheaders (BIP130)
+	sendHeadersVersion = 70012
+
+	// maxAnnouncedHeaders is the most headers Bitcoin Core announces in one
+	// message; longer headers messages only answer getheaders
+	maxAnnouncedHeaders = 8
+)
+
+// headerAnnouncements is set when peers are asked to announce new blocks
+// with headers instead of inv
+var headerAnnouncements atomic.Bool
+
+// SetHeaderAnnouncements asks peers connected from now on to announce new
+// blocks with headers (BIP130). Modern nodes announce that way to peers
+// that ask, so blocks arrive a round trip sooner.
+func SetHeaderAnnouncements(on bool) {
+	headerAnnouncements.Store(on)
+}
+
+// requestHeaderAnnouncements sends sendheaders when enabled and the peer
+// understands it
+func requestHeaderAnnouncements(conn net.Conn, version int32) {
+	if !headerAnnouncements.Load() || version < sendHeadersVersion {
+		return
+	}
+	conn.Write(protocol.CreateMessagePacket("sendheaders", []byte{}))
+}
+
+// handleHeaderAnnouncement records the blocks announced by a headers message
+// the peer sent unasked, and requests them as if they had been inv'd
+func handleHeaderAnnouncement(conn net.Conn, stats *connStats, payload []byte, address, peerAddr, region string, plog zerolog.Logger, db database.Storage) {
+	entries, err := protocol.ParseHeadersMessage(payload)
+	if err != nil || len(entries) == 0 || len(entries) > maxAnnouncedHeaders {
+		return
+	}
+	vectors := make([]protocol.InvVector, len(entries))
+	for i, e := range entries {
+		vectors[i] = protocol.InvVector{Type: protocol.InvTypeBlock, Hash: e.Hash}
+	}
+	vectors, _ = stats.known.filter(vectors)
+	if len(vectors) == 0 {
+		return
+	}
+
+	metrics.HeaderBlockAnnouncements.Add(float64(len(vectors)))
+	recordBlockAnnouncements(vectors, peerAddr, region, arrivalHeaders, plog, db)
+	if err := db.IncrementPeerAnnouncements(address, 0, len(vectors)); err != nil {
+		logger.Error(plog, err, "DB IncrementPeerAnnouncements error")
+	}
+	requestBlocks(conn, stats, vectors, region)
+}
+
+// recordBlockAnnouncements stores a peer's block announcements with how
+// they arrived, inv or headers
+func recordBlockAnnouncements(vectors []protocol.InvVector, peerAddr, region, via string, plog zerolog.Logger, db database.Storage) {
+	for _, v := range vectors {
+		if err := db.RecordBlockAnnouncement(v.Hash[:], peerAddr, via); err != nil {
+			logger.Error(plog, err, "DB RecordBlockAnnouncement error")
+		}
+		noteBlockArrival(v.Hash, peerAddr, region, via, plog, db)
+	}
+}
@@ -0,0 +1,352 @@
+package observer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/chain"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// HeaderChainConfig enables tracking the best header chain with reorg detection
+type HeaderChainConfig struct {
+	// SyncIntervalSeconds is how often each peer is asked for headers past
+	// our best tip (default 60)
+	SyncIntervalSeconds int `json:"sync_interval_seconds"`
+
+	// KeepHeaders is how many headers below the best tip are held in memory;
+	// deeper reorgs go undetected (default 2016)
+	KeepHeaders int `json:"keep_headers"`
+}
+
+func (c *HeaderChainConfig) applyDefaults() {
+	if c.SyncIntervalSeconds <= 0 {
+		c.SyncIntervalSeconds = 60
+	}
+	if c.KeepHeaders <= 0 {
+		c.KeepHeaders = chain.RetargetInterval
+	}
+}
+
+// headerChain is the best header chain, shared by all peers, when enabled
+var (
+	headerChain        *chain.HeaderChain
+	headerSyncInterval time.Duration
+)
+
+// SetHeaderChain enables header-chain tracking, starting from the stored
+// chain's most recent blocks or from genesis when nothing is stored. With a
+// checkpoint it always starts from genesis, so the synced headers pass
+// through the checkpoint and verify the chain above it. Call it after
+// SetCheckpoint and before any peer connects.
+func SetHeaderChain(cfg HeaderChainConfig, db database.Storage) (chain.Tip, error) {
+	cfg.applyDefaults()
+	var stored []database.ChainHeader
+	if checkpoint == nil {
+		var err error
+		stored, err = db.RecentChain(cfg.KeepHeaders)
+		if err != nil {
+			return chain.Tip{}, fmt.Errorf("load stored chain: %w", err)
+		}
+	}
+
+	var c *chain.HeaderChain
+	if len(stored) == 0 {
+		params := protocol.ActiveNetwork().Chain
+		genesis := params.GenesisBlock.Header
+		c = chain.NewHeaderChain(chain.Header{
+			Hash:      *params.GenesisHash,
+			PrevHash:  genesis.PrevBlock,
+			Timestamp: uint32(genesis.Timestamp.Unix()),
+			Bits:      genesis.Bits,
+		}, 0, cfg.KeepHeaders)
+	} else {
+		root := stored[0]
+		c = chain.NewHeaderChain(storedHeader(root), root.Height, cfg.KeepHeaders)
+		headers := make([]chain.Header, len(stored)-1)
+		for i, h := range stored[1:] {
+			headers[i] = storedHeader(h)
+		}
+		// A stored block that fails validation ends the rebuilt chain there;
+		// peers' headers take over from the last good one
+		if _, _, err := c.Add(headers); err != nil {
+			logger.Log.Warn().Err(err).Msg("Stored chain failed header validation")
+		}
+	}
+
+	headerChain = c
+	headerSyncInterval = time.Duration(cfg.SyncIntervalSeconds) * time.Second
+	updateChainMetrics()
+	return c.Tip(), nil
+}
+
+// updateChainMetrics reports the best chain's height and work, and how many
+// weaker branches are held beside it
+func updateChainMetrics() {
+	branches := headerChain.Branches()
+	work, _ := new(big.Float).SetInt(branches[0].Work).Float64()
+	metrics.HeaderChainHeight.Set(float64(branches[0].Height))
+	metrics.HeaderChainWork.Set(math.Log2(work))
+	metrics.ChainBranches.Set(float64(len(branches) - 1))
+}
+
+// ChainTip is a branch of the header chain, for the admin API
+type ChainTip struct {
+	Height     int32  `json:"height"`
+	Hash       string `json:"hash"`
+	Work       string `json:"work"` // cumulative from the header chain's root, hex
+	ForkHeight int32  `json:"fork_height"`
+	BranchLen  int32  `json:"branch_len"`
+	Status     string `json:"status"` // active for the best chain, else valid-fork
+}
+
+// ChainTips lists the header chain's branches, best first, then by work. It
+// returns nil when header-chain tracking is disabled.
+func ChainTips() []ChainTip {
+	if headerChain == nil {
+		return nil
+	}
+	branches := headerChain.Branches()
+	tips := make([]ChainTip, len(branches))
+	for i, b := range branches {
+		tips[i] = ChainTip{
+			Height:     b.Height,
+			Hash:       fmt.Sprintf("%x", protocol.ReverseBytes(b.Hash[:])),
+			Work:       fmt.Sprintf("%064x", b.Work),
+			ForkHeight: b.ForkPoint.Height,
+			BranchLen:  b.Length,
+			Status:     "valid-fork",
+		}
+		if b.Best {
+			tips[i].Status = "active"
+		}
+	}
+	return tips
+}
+
+// highestBlock is the highest block received, taken as the best tip when
+// header-chain tracking is disabled
+var highestBlock atomic.Int32
+
+// noteBlockHeight moves btc_block_height to a received block that is the
+// best chain's tip, so a block on a weaker branch never stands in for the
+// chain. Without the header chain to weigh branches by work, the highest
+// block received is taken as the tip.
+func noteBlockHeight(block *protocol.Block) {
+	if headerChain != nil {
+		if headerChain.Tip().Hash == block.BlockHash {
+			metrics.BlockHeight.Set(float64(block.Height))
+		}
+		return
+	}
+	for {
+		highest := highestBlock.Load()
+		if block.Height <= highest {
+			return
+		}
+		if highestBlock.CompareAndSwap(highest, block.Height) {
+			metrics.BlockHeight.Set(float64(block.Height))
+			return
+		}
+	}
+}
+
+func storedHeader(h database.ChainHeader) chain.Header {
+	return chain.Header{
+		Hash:      h.Hash,
+		PrevHash:  h.PrevHash,
+		Timestamp: uint32(h.Timestamp.Unix()),
+		Bits:      h.Bits,
+	}
+}
+
+// headerSync feeds one peer's headers into the header chain
+type headerSync struct {
+	interval time.Duration
+	lastSent time.Time
+	awaiting bool // a getheaders request is unanswered
+	plog     zerolog.Logger
+	db       database.Storage
+}
+
+// newHeaderSync returns nil when header-chain tracking is disabled
+func newHeaderSync(plog zerolog.Logger, db database.Storage) *headerSync {
+	if headerChain == nil {
+		return nil
+	}
+	return &headerSync{interval: headerSyncInterval, plog: plog, db: db}
+}
+
+// maybeSync asks the peer for the headers past our best tip when due
+func (s *headerSync) maybeSync(conn net.Conn) {
+	if time.Since(s.lastSent) < s.interval {
+		return
+	}
+	s.request(conn)
+}
+
+func (s *headerSync) request(conn net.Conn) {
+	s.lastSent = time.Now()
+	s.awaiting = true
+	packet := protocol.CreateMessagePacket("getheaders", protocol.CreateGetHeadersPayload(headerChain.Locator(), [32]byte{}))
+	if _, err := conn.Write(packet); err == nil {
+		noteHeadersAsked(conn.RemoteAddr().String())
+	}
+}
+
+// awaitingHeaders reports whether a getheaders request is unanswered
+func (s *headerSync) awaitingHeaders() bool {
+	return s != nil && s.awaiting
+}
+
+// handleHeaders adds the headers a peer sent to the header chain. A full
+// message means the peer has more, so the next batch is requested at once.
+func (s *headerSync) handleHeaders(conn net.Conn, payload []byte) {
+	s.awaiting = false
+	entries, err := protocol.ParseHeadersMessage(payload)
+	if err != nil {
+		s.plog.Debug().Err(err).Msg("Bad headers message")
+		return
+	}
+	headers := make([]chain.Header, len(entries))
+	for i, e := range entries {
+		headers[i] = chain.Header{
+			Hash:      e.Hash,
+			PrevHash:  e.Header.PrevBlockHash,
+			Timestamp: e.Header.Timestamp,
+			Bits:      e.Header.Bits,
+		}
+	}
+
+	added, reorg, err := headerChain.Add(headers)
+	if err != nil {
+		rejectedHeader(err, s.plog)
+	}
+	if added > 0 {
+		updateChainMetrics()
+		verifyCheckpointHeaders(headers)
+	}
+	if reorg != nil {
+		applyReorg(reorg, s.plog, s.db)
+		fetchBlocks(conn, reorg.Connected)
+	}
+	if added > 0 && len(entries) == protocol.MaxHeadersPerMessage {
+		s.request(conn)
+	}
+}
+
+// trackBlockHeader adds a received block's header to the header chain. When
+// the block makes another branch best, the reorg is applied before the block
+// is stored, so it finds its height free.
+func trackBlockHeader(conn net.Conn, block *protocol.Block, plog zerolog.Logger, db database.Storage) {
+	if headerChain == nil {
+		return
+	}
+	added, reorg, err := headerChain.Add([]chain.Header{{
+		Hash:      block.BlockHash,
+		PrevHash:  block.Header.PrevBlockHash,
+		Timestamp: block.Header.Timestamp,
+		Bits:      block.Header.Bits,
+	}})
+	if err != nil {
+		rejectedHeader(err, plog)
+	}
+	if added > 0 {
+		updateChainMetrics()
+		if !headerChain.OnBest(block.BlockHash) {
+			tip := headerChain.Tip()
+			plog.Info().
+				Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+				Int32("height", block.Height).
+				Int32("best_height", tip.Height).
+				Msg("Block on a weaker branch")
+		}
+	}
+	if reorg != nil {
+		applyReorg(reorg, plog, db)
+		fetchBlocks(conn, reorg.Connected[:len(reorg.Connected)-1])
+	}
+}
+
+// rejectedHeader counts a header that failed to join the chain. Unconnected
+// headers are routine (a peer far ahead or on a branch we pruned); the rest
+// mean a peer sent invalid work.
+func rejectedHeader(err error, plog zerolog.Logger) {
+	var herr *chain.HeaderError
+	if !errors.As(err, &herr) {
+		return
+	}
+	metrics.HeadersRejected.WithLabelValues(herr.Reason).Inc()
+	if herr.Reason == chain.HeaderUnconnected {
+		plog.Debug().Err(err).Msg("Header not connected to header chain")
+		return
+	}
+	plog.Warn().Err(err).Msg("Invalid header")
+}
+
+// applyReorg marks the blocks that left the best chain as orphaned and
+// un-confirms their transactions
+func applyReorg(r *chain.Reorg, plog zerolog.Logger, db database.Storage) {
+	metrics.ChainReorgs.Inc()
+	metrics.ReorgDepth.Observe(float64(len(r.Disconnected)))
+
+	hashes := make([][]byte, len(r.Disconnected))
+	replacedBy := make([][]byte, len(r.Disconnected))
+	orphaned := make([]events.Hash, len(r.Disconnected))
+	for i, d := range r.Disconnected {
+		hashes[i] = d.Hash[:]
+		if j := int(d.Height - r.ForkPoint.Height - 1); j < len(r.Connected) {
+			replacedBy[i] = r.Connected[j].Hash[:]
+		}
+		orphaned[i] = d.Hash
+	}
+	blocks, txs, err := db.OrphanBlocks(hashes, replacedBy, r.ForkPoint.Height)
+	if err != nil {
+		logger.Error(plog, err, "DB OrphanBlocks error")
+	}
+	metrics.OrphanedBlocks.Add(float64(blocks))
+
+	plog.Warn().
+		Int32("fork_height", r.ForkPoint.Height).
+		Str("fork_hash", fmt.Sprintf("%x", protocol.ReverseBytes(r.ForkPoint.Hash[:]))).
+		Int32("old_tip_height", r.OldTip.Height).
+		Int32("new_tip_height", r.NewTip.Height).
+		Str("new_tip_hash", fmt.Sprintf("%x", protocol.ReverseBytes(r.NewTip.Hash[:]))).
+		Int("depth", len(r.Disconnected)).
+		Int64("orphaned_blocks", blocks).
+		Int64("unconfirmed_txs", txs).
+		Msg("Chain reorg")
+
+	events.Publish(events.ChainReorg, events.Reorg{
+		ForkHeight:   r.ForkPoint.Height,
+		ForkHash:     r.ForkPoint.Hash,
+		OldTipHeight: r.OldTip.Height,
+		OldTipHash:   r.OldTip.Hash,
+		NewTipHeight: r.NewTip.Height,
+		NewTipHash:   r.NewTip.Hash,
+		Orphaned:     orphaned,
+	})
+}
+
+// fetchBlocks requests the new best chain's blocks from the peer. Blocks
+// received earlier are fetched again, as they weren't stored while an
+// orphan held their height.
+func fetchBlocks(conn net.Conn, tips []chain.Tip) {
+	vectors := make([]protocol.InvVector, 0, len(tips))
+	for _, t := range tips {
+		ForgetSeenBlock(t.Hash)
+		MarkSeenBlock(t.Hash)
+		vectors = append(vectors, protocol.InvVector{Type: protocol.InvTypeBlock, Hash: t.Hash})
+	}
+	sendGetData(conn, vectors)
+}
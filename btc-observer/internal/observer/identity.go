@@ -0,0 +1,105 @@
+package observer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// identityWindow is how long after the handshake a peer's control
+	// messages count toward its fingerprint
+	identityWindow = 30 * time.Second
+	// identityMaxCommands caps the control messages fingerprinted
+	identityMaxCommands = 16
+)
+
+// fingerprintCommands are the control messages whose presence, order and
+// parameters differ between node implementations and versions. Data and
+// keepalive messages depend on timing and are left out.
+var fingerprintCommands = map[string]bool{
+	"wtxidrelay":  true,
+	"sendaddrv2":  true,
+	"sendheaders": true,
+	"sendcmpct":   true,
+	"feefilter":   true,
+	"sendtxrcncl": true,
+}
+
+// peerIdentity builds a peer's behavioral fingerprint from its version
+// message and the control messages it sends around the handshake, then links
+// the connection to a long-term identity. Owned by the peer's message loop.
+type peerIdentity struct {
+	version  *protocol.VersionMessage
+	asn      string
+	commands []string
+	since    time.Time
+	resolved bool
+}
+
+func newPeerIdentity(version *protocol.VersionMessage) *peerIdentity {
+	return &peerIdentity{version: version}
+}
+
+// observe records a command received during or after the handshake
+func (p *peerIdentity) observe(command string, payload []byte) {
+	if p.resolved || !fingerprintCommands[command] || len(p.commands) >= identityMaxCommands {
+		return
+	}
+	// The sendcmpct versions and announce flag differ between implementations
+	if command == "sendcmpct" && len(payload) >= 9 {
+		command = fmt.Sprintf("sendcmpct:%d:%d", payload[0], binary.LittleEndian.Uint64(payload[1:9]))
+	}
+	p.commands = append(p.commands, command)
+}
+
+// start begins the post-handshake window. The ASN is part of the identity
+// because dynamic addresses are reassigned within the same network.
+func (p *peerIdentity) start(asn string) {
+	p.asn = asn
+	p.since = time.Now()
+}
+
+// maybeResolve links the peer to an identity once the window has passed
+func (p *peerIdentity) maybeResolve(address string, plog zerolog.Logger, db database.Storage) {
+	if p.resolved || time.Since(p.since) < identityWindow {
+		return
+	}
+	p.resolved = true
+	if db.Discards() {
+		return
+	}
+
+	behavior := p.behavior()
+	sum := sha256.Sum256([]byte(behavior))
+	id := database.PeerIdentity{
+		UserAgent:   p.version.UserAgent,
+		Services:    p.version.Services,
+		Fingerprint: fmt.Sprintf("%x", sum[:8]),
+		ASN:         p.asn,
+	}
+	identityID, result, err := db.ResolvePeerIdentity(address, id)
+	if err != nil {
+		logger.Error(plog, err, "DB ResolvePeerIdentity error")
+		return
+	}
+	metrics.PeerIdentities.WithLabelValues(result).Inc()
+	plog.Debug().
+		Int64("identity", identityID).
+		Str("result", result).
+		Str("behavior", behavior).
+		Msg("Peer identity resolved")
+}
+
+// behavior is the readable form of the fingerprint
+func (p *peerIdentity) behavior() string {
+	return fmt.Sprintf("v=%d;relay=%t;cmds=%s", p.version.Version, p.version.Relay, strings.Join(p.commands, ","))
+}
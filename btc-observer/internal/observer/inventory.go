@@ -0,0 +1,104 @@
+package observer
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// InvLimitsConfig bounds per-peer inventory tracking and request sizes
+type InvLimitsConfig struct {
+	// KnownInventory is how many recent announcements are remembered per
+	// peer to suppress echoes (default 50000)
+	KnownInventory int `json:"known_inventory"`
+
+	// GetDataBatch is the most items requested in one getdata message
+	// (default 1000, as Bitcoin Core)
+	GetDataBatch int `json:"getdata_batch"`
+}
+
+func (c *InvLimitsConfig) applyDefaults() {
+	if c.KnownInventory <= 0 {
+		c.KnownInventory = 50000
+	}
+	if c.GetDataBatch <= 0 {
+		c.GetDataBatch = 1000
+	}
+	c.GetDataBatch = min(c.GetDataBatch, protocol.MaxInvVectors)
+}
+
+var invLimits atomic.Pointer[InvLimitsConfig]
+
+// SetInvLimits replaces the inventory limits for peers connected from now on
+// (known inventory) and for all subsequent requests (getdata batch size)
+func SetInvLimits(cfg InvLimitsConfig) {
+	cfg.applyDefaults()
+	invLimits.Store(&cfg)
+}
+
+func currentInvLimits() *InvLimitsConfig {
+	if c := invLimits.Load(); c != nil {
+		return c
+	}
+	c := &InvLimitsConfig{}
+	c.applyDefaults()
+	return c
+}
+
+// knownInventory remembers the hashes a peer has announced to us. It keeps
+// two generations so memory stays bounded: when the current one fills it
+// becomes the previous one, and a hash is known while it's in either. Only
+// the peer's message loop touches it.
+type knownInventory struct {
+	cur, prev map[[32]byte]struct{}
+	limit     int
+}
+
+func newKnownInventory(size int) *knownInventory {
+	limit := max(size/2, 1)
+	return &knownInventory{cur: make(map[[32]byte]struct{}, limit), limit: limit}
+}
+
+// add records hash and reports whether the peer hadn't announced it before
+func (k *knownInventory) add(hash [32]byte) bool {
+	if _, ok := k.cur[hash]; ok {
+		return false
+	}
+	if _, ok := k.prev[hash]; ok {
+		k.cur[hash] = struct{}{}
+		return false
+	}
+	if len(k.cur) >= k.limit {
+		k.prev, k.cur = k.cur, make(map[[32]byte]struct{}, k.limit)
+	}
+	k.cur[hash] = struct{}{}
+	return true
+}
+
+// filter drops vectors the peer already announced and returns how many it
+// dropped
+func (k *knownInventory) filter(vectors []protocol.InvVector) ([]protocol.InvVector, int) {
+	fresh := vectors[:0]
+	for _, v := range vectors {
+		if k.add(v.Hash) {
+			fresh = append(fresh, v)
+		}
+	}
+	return fresh, len(vectors) - len(fresh)
+}
+
+// sendGetData requests vectors from the peer in batches of at most the
+// configured getdata size
+func sendGetData(conn net.Conn, vectors []protocol.InvVector) {
+	noteBlocksRequested(conn.RemoteAddr().String(), vectors)
+	batch := currentInvLimits().GetDataBatch
+	for len(vectors) > 0 {
+		n := min(len(vectors), batch)
+		packet := protocol.CreateMessagePacket("getdata", protocol.CreateGetDataPayload(vectors[:n]))
+		if _, err := conn.Write(packet); err != nil {
+			return
+		}
+		vectors = vectors[n:]
+	}
+}
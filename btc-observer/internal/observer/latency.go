@@ -0,0 +1,325 @@
+package observer
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Kinds of announcement a latency profile covers
+const (
+	latencyTx    = "tx"
+	latencyBlock = "block"
+)
+
+// latencyBoundsMs are the upper bounds of the delay histogram buckets; the
+// last bucket holds everything slower. Stored profiles with a different
+// bucket count are discarded on load.
+var latencyBoundsMs = []int{50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 15000, 30000, 60000}
+
+// LatencyProfileConfig keeps per-peer profiles of how far behind the first
+// announcement each peer announces transactions and blocks. With
+// RotateMinutes set, the slowest tx announcer is periodically swapped out.
+type LatencyProfileConfig struct {
+	FlushSeconds int   `json:"flush_seconds"` // between saving changed profiles (default 60)
+	MaxSamples   int64 `json:"max_samples"`   // counts are halved past this, so profiles follow recent behavior (default 10000)
+
+	RotateMinutes int     `json:"rotate_minutes"` // between rotation checks (default 0, no rotation)
+	MinSamples    int64   `json:"min_samples"`    // tx samples before a peer can be rotated out (default 500)
+	RotateFactor  float64 `json:"rotate_factor"`  // rotate when its median is this many times the connected peers' median (default 3)
+	CooldownHours int     `json:"cooldown_hours"` // before a rotated-out peer is dialed again (default 6)
+}
+
+func (c *LatencyProfileConfig) applyDefaults() {
+	if c.FlushSeconds <= 0 {
+		c.FlushSeconds = 60
+	}
+	if c.MaxSamples <= 0 {
+		c.MaxSamples = 10000
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = 500
+	}
+	if c.RotateFactor <= 1 {
+		c.RotateFactor = 3
+	}
+	if c.CooldownHours <= 0 {
+		c.CooldownHours = 6
+	}
+}
+
+// latencyHistogram counts one peer's announcement delays of one kind
+type latencyHistogram struct {
+	buckets []int64
+	total   int64
+	dirty   bool
+}
+
+func (h *latencyHistogram) add(delayMs int, maxSamples int64) {
+	i := sort.SearchInts(latencyBoundsMs, delayMs)
+	h.buckets[i]++
+	h.total++
+	h.dirty = true
+	if h.total > maxSamples {
+		h.total = 0
+		for j := range h.buckets {
+			h.buckets[j] /= 2
+			h.total += h.buckets[j]
+		}
+	}
+}
+
+// quantile interpolates the q-th delay within its bucket; the open last
+// bucket reports its lower bound
+func (h *latencyHistogram) quantile(q float64) int {
+	if h.total == 0 {
+		return 0
+	}
+	rank := q * float64(h.total)
+	var seen int64
+	for i, n := range h.buckets {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		lo := 0
+		if i > 0 {
+			lo = latencyBoundsMs[i-1]
+		}
+		if i == len(latencyBoundsMs) {
+			return lo
+		}
+		return lo + int(float64(latencyBoundsMs[i]-lo)*(rank-float64(seen))/float64(n))
+	}
+	return latencyBoundsMs[len(latencyBoundsMs)-1]
+}
+
+// latencyProfiles holds every peer's histograms by address and kind, plus
+// the first announcement time of recent transactions to measure against
+var latencyProfiles = struct {
+	sync.Mutex
+	peers      map[string]map[string]*latencyHistogram
+	txFirst    map[[32]byte]time.Time
+	rotatedOut map[string]time.Time
+}{
+	peers:      make(map[string]map[string]*latencyHistogram),
+	txFirst:    make(map[[32]byte]time.Time),
+	rotatedOut: make(map[string]time.Time),
+}
+
+var latencyConfig atomic.Pointer[LatencyProfileConfig]
+
+// observeLatency adds a delay to a peer's profile, unless the peer is
+// excluded for contradicting its claims
+func observeLatency(peerAddr, kind string, delay time.Duration) {
+	cfg := latencyConfig.Load()
+	if cfg == nil || claimsExcluded(peerAddr) {
+		return
+	}
+	latencyProfiles.Lock()
+	defer latencyProfiles.Unlock()
+	latencyHistogramFor(peerAddr, kind).add(int(delay.Milliseconds()), cfg.MaxSamples)
+}
+
+// latencyHistogramFor returns a peer's histogram, creating it; the caller
+// holds the lock
+func latencyHistogramFor(peerAddr, kind string) *latencyHistogram {
+	kinds := latencyProfiles.peers[peerAddr]
+	if kinds == nil {
+		kinds = make(map[string]*latencyHistogram)
+		latencyProfiles.peers[peerAddr] = kinds
+	}
+	h := kinds[kind]
+	if h == nil {
+		h = &latencyHistogram{buckets: make([]int64, len(latencyBoundsMs)+1)}
+		kinds[kind] = h
+	}
+	return h
+}
+
+// noteTxLatency measures a peer's tx announcement against the first
+// announcement of that tx from any peer. Excluded peers don't set the first
+// announcement either.
+func noteTxLatency(hash [32]byte, peerAddr string) {
+	if latencyConfig.Load() == nil || claimsExcluded(peerAddr) {
+		return
+	}
+	now := time.Now()
+	latencyProfiles.Lock()
+	first, ok := latencyProfiles.txFirst[hash]
+	if !ok {
+		latencyProfiles.txFirst[hash] = now
+		first = now
+	}
+	latencyProfiles.Unlock()
+	observeLatency(peerAddr, latencyTx, now.Sub(first))
+}
+
+// cleanupLatencyTxs forgets first announcements older than maxAge
+func cleanupLatencyTxs(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	latencyProfiles.Lock()
+	defer latencyProfiles.Unlock()
+	for hash, t := range latencyProfiles.txFirst {
+		if t.Before(cutoff) {
+			delete(latencyProfiles.txFirst, hash)
+		}
+	}
+	for addr, t := range latencyProfiles.rotatedOut {
+		if t.Before(time.Now()) {
+			delete(latencyProfiles.rotatedOut, addr)
+		}
+	}
+}
+
+// latencyAllows keeps the peer manager from redialing a peer rotated out
+// for being slow until its cooldown is over
+func latencyAllows(node *Node) bool {
+	if latencyConfig.Load() == nil {
+		return true
+	}
+	latencyProfiles.Lock()
+	defer latencyProfiles.Unlock()
+	until, ok := latencyProfiles.rotatedOut[node.Addr()]
+	return !ok || time.Now().After(until)
+}
+
+// StartLatencyProfiles loads the stored profiles, then saves changed ones
+// every FlushSeconds and, if enabled, rotates out slow peers. Changed
+// profiles are saved a last time when ctx is done, before marking wg done.
+func StartLatencyProfiles(ctx context.Context, cfg LatencyProfileConfig, pm *PeerManager, db database.Storage, wg *sync.WaitGroup) (int, error) {
+	cfg.applyDefaults()
+	stored, err := db.LatencyProfiles()
+	if err != nil {
+		return 0, err
+	}
+	latencyProfiles.Lock()
+	loaded := 0
+	for _, p := range stored {
+		if len(p.Buckets) != len(latencyBoundsMs)+1 {
+			continue
+		}
+		h := latencyHistogramFor(p.PeerAddr, p.Kind)
+		copy(h.buckets, p.Buckets)
+		h.total = p.Samples
+		loaded++
+	}
+	latencyProfiles.Unlock()
+	latencyConfig.Store(&cfg)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		flush := time.NewTicker(time.Duration(cfg.FlushSeconds) * time.Second)
+		defer flush.Stop()
+		var rotate <-chan time.Time
+		if cfg.RotateMinutes > 0 {
+			t := time.NewTicker(time.Duration(cfg.RotateMinutes) * time.Minute)
+			defer t.Stop()
+			rotate = t.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				flushLatencyProfiles(db)
+				return
+			case <-flush.C:
+				flushLatencyProfiles(db)
+			case <-rotate:
+				rotateSlowestPeer(&cfg, pm)
+			}
+		}
+	}()
+	return loaded, nil
+}
+
+// flushLatencyProfiles saves the profiles that changed since the last flush
+func flushLatencyProfiles(db database.Storage) {
+	latencyProfiles.Lock()
+	var changed []database.LatencyProfile
+	for addr, kinds := range latencyProfiles.peers {
+		for kind, h := range kinds {
+			if !h.dirty {
+				continue
+			}
+			h.dirty = false
+			changed = append(changed, database.LatencyProfile{
+				PeerAddr: addr,
+				Kind:     kind,
+				Samples:  h.total,
+				MedianMs: h.quantile(0.5),
+				P90Ms:    h.quantile(0.9),
+				Buckets:  append([]int64(nil), h.buckets...),
+			})
+		}
+	}
+	latencyProfiles.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	if err := db.SaveLatencyProfiles(changed); err != nil {
+		logger.Log.Error().Err(err).Int("profiles", len(changed)).Msg("Failed to save latency profiles")
+	}
+}
+
+// rotateSlowestPeer disconnects the connected discovered peer with the
+// slowest median tx announcement when it is RotateFactor times the median
+// of all connected peers, and another candidate can take its slot
+func rotateSlowestPeer(cfg *LatencyProfileConfig, pm *PeerManager) {
+	type peer struct {
+		conn     net.Conn
+		addr     string // as profiled
+		nodeAddr string // as dialed
+		country  string
+		medianMs int
+	}
+	var peers []peer
+	activeConns.Lock()
+	latencyProfiles.Lock()
+	for conn, stats := range activeConns.conns {
+		if stats.country == StaticRegion || stats.country == TorRegion {
+			continue
+		}
+		addr := conn.RemoteAddr().String()
+		h := latencyProfiles.peers[addr][latencyTx]
+		if h == nil || h.total < cfg.MinSamples {
+			continue
+		}
+		peers = append(peers, peer{conn: conn, addr: addr, nodeAddr: stats.node.Addr(), country: stats.country, medianMs: h.quantile(0.5)})
+	}
+	latencyProfiles.Unlock()
+	activeConns.Unlock()
+	if len(peers) < 3 {
+		return
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].medianMs < peers[j].medianMs })
+	typical := max(peers[len(peers)/2].medianMs, 1)
+	slowest := peers[len(peers)-1]
+	if float64(slowest.medianMs) < cfg.RotateFactor*float64(typical) {
+		return
+	}
+	if _, ok := pm.GetNextPeer(slowest.country, func(n *Node) bool { return CurrentPeerPolicy().allows(n) && latencyAllows(n) }); !ok {
+		return
+	}
+
+	latencyProfiles.Lock()
+	latencyProfiles.rotatedOut[slowest.nodeAddr] = time.Now().Add(time.Duration(cfg.CooldownHours) * time.Hour)
+	latencyProfiles.Unlock()
+	slowest.conn.Close()
+	metrics.PeerLatencyRotations.Inc()
+	logger.Log.Info().
+		Str("peer", slowest.addr).
+		Str("country", slowest.country).
+		Int("median_ms", slowest.medianMs).
+		Int("typical_median_ms", typical).
+		Int("cooldown_hours", cfg.CooldownHours).
+		Msg("Rotated out slow peer")
+}
@@ -0,0 +1,165 @@
+package observer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/chain"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Locktime kinds. nLockTime below lockTimeThreshold is a block height, at or
+// above it a unix time.
+const (
+	LockKindHeight = "height"
+	LockKindTime   = "time"
+
+	lockTimeThreshold = 500000000
+	sequenceFinal     = 0xffffffff
+)
+
+// LockTimeConfig configures tracking of transactions seen before their
+// locktime expired, typically pre-signed ones such as channel closes and
+// vault withdrawals, until they become valid and confirm
+type LockTimeConfig struct {
+	// MinBlocksAhead is how far past the tip a height locktime must be for
+	// the transaction to be tracked (default 1, so the anti-fee-sniping
+	// locktime of the current tip isn't)
+	MinBlocksAhead int32 `json:"min_blocks_ahead"`
+}
+
+func (c *LockTimeConfig) applyDefaults() {
+	if c.MinBlocksAhead <= 0 {
+		c.MinBlocksAhead = 1
+	}
+}
+
+// lockTimeTip is the highest block seen, which locktimes are compared with
+type lockTimeTip struct {
+	mu     sync.Mutex
+	height int32
+	mtp    time.Time // median time past including the tip
+}
+
+var (
+	lockTimes atomic.Pointer[LockTimeConfig]
+	lockTip   lockTimeTip
+)
+
+// SetLockTimeTracking enables future-locktime tracking, starting from the
+// highest block stored
+func SetLockTimeTracking(cfg LockTimeConfig, db database.Storage) error {
+	cfg.applyDefaults()
+	headers, err := db.RecentChain(chain.MedianTimeBlocks)
+	if err != nil {
+		return fmt.Errorf("loading chain tip: %w", err)
+	}
+	if len(headers) > 0 {
+		timestamps := make([]time.Time, len(headers))
+		for i, h := range headers {
+			timestamps[len(headers)-1-i] = h.Timestamp
+		}
+		lockTip.advance(headers[len(headers)-1].Height, chain.MedianTimePast(timestamps))
+	}
+	lockTimes.Store(&cfg)
+	return nil
+}
+
+// advance moves the tip forward; lower heights (stale or reorged blocks) are ignored
+func (t *lockTimeTip) advance(height int32, mtp time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if height > t.height {
+		t.height, t.mtp = height, mtp
+	}
+}
+
+func (t *lockTimeTip) get() (int32, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.height, t.mtp
+}
+
+// futureLockTime reports the kind and value of tx's locktime if it is
+// enforced and hasn't expired at the tip
+func futureLockTime(tx *protocol.Transaction, tipHeight int32, tipMTP time.Time, minBlocksAhead int32) (string, int64, bool) {
+	if tx.LockTime == 0 {
+		return "", 0, false
+	}
+	enforced := false
+	for _, in := range tx.Inputs {
+		if in.Sequence != sequenceFinal {
+			enforced = true
+			break
+		}
+	}
+	if !enforced {
+		return "", 0, false
+	}
+	if tx.LockTime < lockTimeThreshold {
+		return LockKindHeight, int64(tx.LockTime), int64(tx.LockTime)-int64(tipHeight) >= int64(minBlocksAhead)
+	}
+	// A time lock is valid in the next block once the tip's median time past exceeds it
+	return LockKindTime, int64(tx.LockTime), int64(tx.LockTime) >= tipMTP.Unix()
+}
+
+// checkLockTime starts tracking tx if its locktime hasn't expired yet
+func checkLockTime(tx *protocol.Transaction, plog zerolog.Logger, db database.Storage) {
+	cfg := lockTimes.Load()
+	if cfg == nil {
+		return
+	}
+	tipHeight, tipMTP := lockTip.get()
+	if tipHeight == 0 {
+		return // no block yet to compare with
+	}
+	kind, value, future := futureLockTime(tx, tipHeight, tipMTP, cfg.MinBlocksAhead)
+	if !future {
+		return
+	}
+	created, err := db.RecordLockTime(tx.TxID[:], kind, value, tipHeight)
+	if err != nil {
+		logger.Error(plog, err, "DB RecordLockTime error")
+		return
+	}
+	if !created {
+		return
+	}
+	metrics.LockTimeTxs.WithLabelValues(kind).Inc()
+	plog.Debug().
+		Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(tx.TxID[:]))).
+		Str("kind", kind).
+		Int64("locktime", value).
+		Int32("tip", tipHeight).
+		Msg("Future-locktime transaction")
+}
+
+// trackLockTimes advances the tip to block and applies it to the tracked
+// transactions: those it confirms and those whose lock it expires
+func trackLockTimes(block *protocol.Block, txHashes [][]byte, plog zerolog.Logger, db database.Storage) {
+	if lockTimes.Load() == nil || block.Height <= 0 {
+		return
+	}
+	ancestors, err := db.AncestorTimestamps(block.BlockHash[:], chain.MedianTimeBlocks)
+	if err != nil {
+		logger.Error(plog, err, "DB AncestorTimestamps error")
+		return
+	}
+	mtp := chain.MedianTimePast(ancestors)
+	lockTip.advance(block.Height, mtp)
+
+	confirmed, err := db.UpdateLockTimes(block.Height, mtp, txHashes)
+	if err != nil {
+		logger.Error(plog, err, "DB UpdateLockTimes error")
+		return
+	}
+	for _, c := range confirmed {
+		metrics.LockTimeConfirmDelay.WithLabelValues(c.Kind).Observe(float64(c.DelayBlocks))
+	}
+}
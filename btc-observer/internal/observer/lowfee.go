@@ -0,0 +1,95 @@
+package observer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Low-fee transaction kinds
+const (
+	LowFeeZero          = "zero_fee"
+	LowFeeBelowMinRelay = "below_min_relay"
+)
+
+// LowFeeConfig configures tracking of transactions relayed below the
+// minimum relay fee rate. Fees are only known for transactions whose inputs
+// are all stored.
+type LowFeeConfig struct {
+	// MinRelayFeeRate is the floor in sat/vB (default 1, Bitcoin Core's
+	// standard minimum relay fee)
+	MinRelayFeeRate float64 `json:"min_relay_fee_rate"`
+
+	// DelaySeconds waits for announcements from other peers before recording
+	// which peers relayed the transaction (default 30)
+	DelaySeconds int `json:"delay_seconds"`
+}
+
+func (c *LowFeeConfig) applyDefaults() {
+	if c.MinRelayFeeRate <= 0 {
+		c.MinRelayFeeRate = 1
+	}
+	if c.DelaySeconds <= 0 {
+		c.DelaySeconds = 30
+	}
+}
+
+var lowFee atomic.Pointer[LowFeeConfig]
+
+// SetLowFeeTracking enables tracking of below-minimum-fee transactions
+func SetLowFeeTracking(cfg LowFeeConfig) {
+	cfg.applyDefaults()
+	lowFee.Store(&cfg)
+}
+
+// checkLowFee schedules recording tx if it pays below the minimum relay fee rate
+func checkLowFee(tx *protocol.Transaction, fee *database.Fee, plog zerolog.Logger, db database.Storage) {
+	cfg := lowFee.Load()
+	if cfg == nil || fee == nil {
+		return
+	}
+	var kind string
+	switch {
+	case fee.Satoshis == 0:
+		kind = LowFeeZero
+	case fee.Rate() < cfg.MinRelayFeeRate:
+		kind = LowFeeBelowMinRelay
+	default:
+		return
+	}
+
+	txHash := tx.TxID
+	f := *fee
+	time.AfterFunc(time.Duration(cfg.DelaySeconds)*time.Second, func() {
+		recordLowFee(txHash, kind, f, plog, db)
+	})
+}
+
+func recordLowFee(txHash [32]byte, kind string, fee database.Fee, plog zerolog.Logger, db database.Storage) {
+	regions, created, err := db.RecordLowFeeTransaction(txHash[:], kind, fee)
+	if err != nil {
+		logger.Error(plog, err, "DB RecordLowFeeTransaction error")
+		return
+	}
+	if !created {
+		return // another peer's copy already recorded it
+	}
+
+	metrics.LowFeeTxs.WithLabelValues(kind).Inc()
+	for _, region := range regions {
+		metrics.LowFeeRelays.WithLabelValues(region).Inc()
+	}
+	logger.Log.Debug().
+		Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(txHash[:]))).
+		Str("kind", kind).
+		Int64("fee_sats", fee.Satoshis).
+		Float64("fee_rate", fee.Rate()).
+		Int("relays", len(regions)).
+		Msg("Low-fee transaction relayed")
+}
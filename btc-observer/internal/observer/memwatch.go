@@ -0,0 +1,74 @@
+package observer
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	memCheckInterval = 10 * time.Second
+	// memRecoverRatio is the fraction of the budget heap must drop below to stop shedding
+	memRecoverRatio = 0.8
+	// shedSeenMaxAge is how long dedup entries are kept while shedding
+	shedSeenMaxAge = time.Minute
+)
+
+// shedding is set while heap usage is over budget
+var shedding atomic.Bool
+
+// Shedding reports whether the observer is currently shedding load
+func Shedding() bool {
+	return shedding.Load()
+}
+
+// StartMemoryWatchdog checks heap usage against budgetBytes and sheds load
+// (skipping tx downloads, shrinking dedup caches) while it is exceeded. Inv
+// observations are always kept.
+func StartMemoryWatchdog(ctx context.Context, budgetBytes uint64) {
+	go func() {
+		ticker := time.NewTicker(memCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkMemory(budgetBytes)
+			}
+		}
+	}()
+}
+
+func checkMemory(budgetBytes uint64) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	metrics.HeapBytes.Set(float64(ms.HeapAlloc))
+
+	switch {
+	case ms.HeapAlloc > budgetBytes:
+		if !shedding.Swap(true) {
+			logger.Log.Warn().
+				Uint64("heap_mb", ms.HeapAlloc/1024/1024).
+				Uint64("budget_mb", budgetBytes/1024/1024).
+				Msg("Memory budget exceeded, shedding load")
+			metrics.MemoryShedding.Set(1)
+		}
+		ShrinkSeenMaps(shedSeenMaxAge)
+		mempool.shrink()
+		debug.FreeOSMemory()
+
+	case ms.HeapAlloc < uint64(float64(budgetBytes)*memRecoverRatio):
+		if shedding.Swap(false) {
+			logger.Log.Info().
+				Uint64("heap_mb", ms.HeapAlloc/1024/1024).
+				Msg("Memory back under budget, resuming normal operation")
+			metrics.MemoryShedding.Set(0)
+		}
+	}
+}
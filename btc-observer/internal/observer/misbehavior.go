@@ -0,0 +1,55 @@
+package observer
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// maxMisbehavior is how many corrupt messages a peer may send in one session
+// before it is disconnected; each also adds to its penalty score
+const maxMisbehavior = 5
+
+// framingErrorReason classifies a ReadMessage error the stream can recover
+// from, or returns "" for errors that end the connection
+func framingErrorReason(err error) string {
+	switch {
+	case errors.Is(err, protocol.ErrChecksumMismatch):
+		return "checksum"
+	case errors.Is(err, protocol.ErrBadMagic):
+		return "magic"
+	case errors.Is(err, protocol.ErrPayloadTooLarge):
+		return "oversized"
+	}
+	return ""
+}
+
+// recoverFraming records a misbehavior strike for a corrupt message and, if
+// the peer is still under the limit, realigns the stream on the next message.
+// It reports whether the connection should carry on.
+func recoverFraming(r *bufio.Reader, stats *connStats, reason string, err error, plog zerolog.Logger) bool {
+	metrics.CorruptMessages.WithLabelValues(reason).Inc()
+	penalize(stats.node.Addr(), PenaltyCorrupt)
+	stats.misbehavior++
+	if stats.misbehavior >= maxMisbehavior {
+		plog.Warn().Err(err).Int("strikes", stats.misbehavior).Msg("Disconnecting peer after repeated corrupt messages")
+		return false
+	}
+
+	// A bad checksum consumed exactly the advertised payload, so the stream
+	// is still aligned
+	if reason == "checksum" {
+		plog.Warn().Err(err).Int("strikes", stats.misbehavior).Msg("Dropped corrupt message")
+		return true
+	}
+	skipped, rerr := protocol.Resync(r)
+	if rerr != nil {
+		plog.Warn().Err(rerr).Int("skipped", skipped).Msg("Resync failed")
+		return false
+	}
+	plog.Warn().Err(err).Int("skipped", skipped).Int("strikes", stats.misbehavior).Msg("Resynchronized after corrupt message")
+	return true
+}
@@ -0,0 +1,65 @@
+package observer
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+// featureWindow is how long after the handshake a session's features are
+// first recorded; sendheaders and sendcmpct follow verack within seconds
+const featureWindow = 30 * time.Second
+
+// peerFeatures is a session's feature negotiation, owned by its message
+// loop. wtxidrelay and sendaddrv2 only count before verack, where BIP339 and
+// BIP155 require them; sendheaders and sendcmpct come after it.
+type peerFeatures struct {
+	database.SessionFeatures
+	since    time.Time
+	recorded bool
+	dirty    bool // changed since recorded
+}
+
+// observe notes a feature the peer negotiated after the handshake
+func (f *peerFeatures) observe(command string, payload []byte) {
+	switch command {
+	case "sendheaders":
+		if !f.SendHeaders {
+			f.SendHeaders = true
+			f.dirty = true
+		}
+	case "sendcmpct":
+		// Peers offer each version they support in its own sendcmpct
+		if len(payload) < 9 {
+			return
+		}
+		if v := binary.LittleEndian.Uint64(payload[1:9]); v > f.CmpctVersion {
+			f.CmpctVersion = v
+			f.dirty = true
+		}
+	}
+}
+
+// maybeRecord stores the features once the window after the handshake has
+// passed
+func (f *peerFeatures) maybeRecord(session int64, plog zerolog.Logger, db database.Storage) {
+	if f.recorded || time.Since(f.since) < featureWindow {
+		return
+	}
+	f.record(session, plog, db)
+}
+
+// record stores the features unless they are unchanged since last stored
+func (f *peerFeatures) record(session int64, plog zerolog.Logger, db database.Storage) {
+	if session == 0 || (f.recorded && !f.dirty) {
+		return
+	}
+	f.recorded = true
+	f.dirty = false
+	if err := db.RecordSessionFeatures(session, f.SessionFeatures); err != nil {
+		logger.Error(plog, err, "DB RecordSessionFeatures error")
+	}
+}
@@ -0,0 +1,110 @@
+package observer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// nonceRetention is how long sent version nonces are remembered. A peer
+// that logs the nonces it sees can replay one long after the connection it
+// came from, so they are kept in the database across restarts.
+const nonceRetention = 7 * 24 * time.Hour
+
+// errNonceReplay fails a handshake in which the peer sent one of our own
+// version nonces as its own
+var errNonceReplay = errors.New("peer sent back our version nonce")
+
+// sentNonce is where and when a version nonce went out; observer is set for
+// nonces another observer sharing the database sent
+type sentNonce struct {
+	peer     string
+	observer string
+	at       time.Time
+}
+
+var nonces = struct {
+	sync.Mutex
+	sent      map[uint64]sentNonce
+	lastPrune time.Time
+}{sent: make(map[uint64]sentNonce)}
+
+// LoadNonces restores the version nonces sent within the retention window,
+// by this observer before a restart or by others sharing its database, and
+// deletes older ones
+func LoadNonces(db database.Storage) (int, error) {
+	cutoff := time.Now().Add(-nonceRetention)
+	if _, err := db.PruneVersionNonces(cutoff); err != nil {
+		return 0, fmt.Errorf("prune version nonces: %w", err)
+	}
+	sent, err := db.VersionNonces(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	nonces.Lock()
+	defer nonces.Unlock()
+	for _, n := range sent {
+		nonces.sent[n.Nonce] = sentNonce{peer: n.PeerAddr, observer: n.ObserverID, at: n.SentAt}
+	}
+	nonces.lastPrune = time.Now()
+	return len(sent), nil
+}
+
+// rememberNonce records a version nonce about to be sent to peer
+func rememberNonce(nonce uint64, peer string, plog zerolog.Logger, db database.Storage) {
+	now := time.Now()
+	nonces.Lock()
+	nonces.sent[nonce] = sentNonce{peer: peer, at: now}
+	if now.Sub(nonces.lastPrune) > time.Hour {
+		for n, s := range nonces.sent {
+			if now.Sub(s.at) > nonceRetention {
+				delete(nonces.sent, n)
+			}
+		}
+		nonces.lastPrune = now
+	}
+	nonces.Unlock()
+
+	if err := db.RecordVersionNonce(nonce, peer); err != nil {
+		logger.Error(plog, err, "DB RecordVersionNonce error")
+	}
+}
+
+// checkNonce looks for the peer's version nonce among those we sent. Sending
+// back the one from this handshake reflects our version to us; sending an
+// older one shows the peer kept it, which links our connections and is a
+// way of fingerprinting the observer. Either is recorded as misbehavior and
+// fails the handshake with errNonceReplay.
+func checkNonce(nonce, ours uint64, peer string, plog zerolog.Logger, db database.Storage) error {
+	// Some clients don't pick a nonce
+	if nonce == 0 {
+		return nil
+	}
+	nonces.Lock()
+	sent, ok := nonces.sent[nonce]
+	nonces.Unlock()
+	if !ok {
+		return nil
+	}
+
+	reason, detail := "nonce_echo", "echoed the nonce sent in the same handshake"
+	if nonce != ours {
+		reason = "nonce_replay"
+		detail = fmt.Sprintf("replayed the nonce sent to %s at %s", sent.peer, sent.at.UTC().Format(time.RFC3339))
+		if sent.observer != "" {
+			detail += " by observer " + sent.observer
+		}
+	}
+	metrics.PeerMisbehavior.WithLabelValues(reason).Inc()
+	if err := db.RecordPeerMisbehavior(peer, reason, detail); err != nil {
+		logger.Error(plog, err, "DB RecordPeerMisbehavior error")
+	}
+	plog.Warn().Str("reason", reason).Str("sent_to", sent.peer).Time("sent_at", sent.at).Msg("Peer sent back one of our version nonces")
+	return fmt.Errorf("%w: %s", errNonceReplay, detail)
+}
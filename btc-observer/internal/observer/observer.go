@@ -1,31 +1,115 @@
 package observer
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/features"
 	"github.com/keato/btc-observer/internal/logger"
 	"github.com/keato/btc-observer/internal/metrics"
 	"github.com/keato/btc-observer/internal/protocol"
 	"github.com/rs/zerolog"
 )
 
+// connStats tracks per-connection activity used to pick peers to shed
+type connStats struct {
+	since    time.Time
+	invItems atomic.Int64
+
+	// node and the country slot it fills, for peer policy enforcement
+	node    *Node
+	country string
+
+	// source is the dial source the connection left from, nil for the
+	// system's choice
+	source *dialSource
+
+	// known is what the peer has announced to us, and misbehavior counts
+	// its corrupt messages; both are owned by its message loop
+	known       *knownInventory
+	misbehavior int
+
+	// compact is the peer's compact block state, nil when disabled
+	compact *compactPeer
+
+	// identity fingerprints the peer, set after the handshake
+	identity *peerIdentity
+
+	// wtxidRelay is set when both sides sent wtxidrelay (BIP339), so the
+	// peer announces transactions by wtxid
+	wtxidRelay bool
+
+	// features is the outcome of the session's feature negotiation, set
+	// after the handshake
+	features *peerFeatures
+
+	// traffic counts the connection's messages by type
+	traffic *peerTraffic
+
+	// fastest ping round trip and the GeoIP check's verdict on it; owned by
+	// the message loop
+	minRTTMs   int
+	rttSamples int
+	geoSuspect bool
+
+	// session is the connection's peer_sessions row, 0 if it wasn't
+	// recorded; tcp is the socket whose kernel statistics describe the path
+	// to the peer, nil when it runs through the Tor proxy
+	session int64
+	tcp     net.Conn
+
+	// live figures for the admin status view, written by the message loop:
+	// messages received, the last ping round trip, and the height and time
+	// (unix nanoseconds) of the last block the peer delivered
+	messages    atomic.Int64
+	latencyMs   atomic.Int64
+	blockHeight atomic.Int32
+	blockAt     atomic.Int64
+}
+
+func (s *connStats) noteBlock(height int32) {
+	s.blockHeight.Store(height)
+	s.blockAt.Store(time.Now().UnixNano())
+	highBandwidth.noteFirstDelivery(s.compact)
+}
+
+func (s *connStats) invRate() float64 {
+	elapsed := time.Since(s.since).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.invItems.Load()) / elapsed
+}
+
 // activeConns tracks all active connections for graceful shutdown
 var activeConns = struct {
 	sync.Mutex
-	conns map[net.Conn]struct{}
-}{conns: make(map[net.Conn]struct{})}
-
-func trackConn(conn net.Conn) {
+	conns map[net.Conn]*connStats
+}{conns: make(map[net.Conn]*connStats)}
+
+func trackConn(conn net.Conn, node *Node, country string, source *dialSource) *connStats {
+	stats := &connStats{
+		since:   time.Now(),
+		node:    node,
+		country: country,
+		source:  source,
+		known:   newKnownInventory(currentInvLimits().KnownInventory),
+		compact: newCompactPeer(),
+	}
 	activeConns.Lock()
-	activeConns.conns[conn] = struct{}{}
+	activeConns.conns[conn] = stats
 	activeConns.Unlock()
+	return stats
 }
 
 func untrackConn(conn net.Conn) {
@@ -43,63 +127,162 @@ func CloseAllConnections() {
 	}
 }
 
-// ObserveNode connects to a node and processes messages
-func ObserveNode(ctx context.Context, node *Node, country string, pm *PeerManager, db *database.DB, wg *sync.WaitGroup) {
+// RestartRegion closes the connections of every peer labelled with region,
+// leaving other regions' peers connected. The peer manager (or the static
+// peer loop) reconnects them, so the region restarts with fresh sessions.
+// It returns how many connections were closed.
+func RestartRegion(region string) int {
+	activeConns.Lock()
+	defer activeConns.Unlock()
+	closed := 0
+	for conn, stats := range activeConns.conns {
+		if peerRegion(stats.node, stats.country) != region {
+			continue
+		}
+		conn.Close()
+		closed++
+	}
+	if closed > 0 {
+		metrics.RegionRestarts.WithLabelValues(region).Inc()
+		logger.Log.Info().Str("region", region).Int("peers", closed).Msg("Region peers restarted")
+	}
+	return closed
+}
+
+// DisconnectPeer closes the connection to addr, if there is one. The peer
+// manager refills the slot, possibly with the same peer.
+func DisconnectPeer(addr string) bool {
+	activeConns.Lock()
+	defer activeConns.Unlock()
+	for conn, stats := range activeConns.conns {
+		if stats.node.Addr() == addr {
+			conn.Close()
+			logger.Log.Info().Str("peer", addr).Msg("Peer disconnected on request")
+			return true
+		}
+	}
+	return false
+}
+
+// ObserveNode connects to a node and processes messages. country is the
+// peer-selection slot the node fills; metrics and storage use its region,
+// which differs only when a region override matches.
+func ObserveNode(ctx context.Context, node *Node, country string, pm *PeerManager, db database.Storage, wg *sync.WaitGroup) {
 	if wg != nil {
 		defer wg.Done()
 	}
 
 	addr := node.Addr()
-	plog := logger.PeerLogger(country, addr)
-
+	region := peerRegion(node, country)
+	plog := logger.PeerLogger(region, addr)
+
+	src := dialSourceFor(region, country)
+	if src != nil {
+		plog = plog.With().Stringer("source", src).Logger()
+		// Don't record a peer under its region through an egress that may
+		// be exiting somewhere else
+		if !src.verified() {
+			plog.Debug().Msg("Egress not verified, not connecting")
+			metrics.EgressSkippedConnections.WithLabelValues(src.name).Inc()
+			return
+		}
+	}
 	plog.Info().Str("city", node.City).Str("country", node.CountryCode).Msg("Connecting")
 	metrics.PeerConnections.Inc()
 
-	conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+	conn, err := dialPeer(addr, src, 15*time.Second)
 	if err != nil {
 		plog.Warn().Err(err).Msg("Connection failed")
-		pm.MarkFailed(addr)
+		pm.MarkFailed(addr, PenaltyDial)
 		return
 	}
+	// Kernel statistics of a proxied connection describe the hop to the proxy
+	var tcp net.Conn
+	if protocol.AddressNetwork(addr) != protocol.NetTorV3 {
+		tcp = conn
+	}
+	traffic := newPeerTraffic(addr, region)
+	defer traffic.close()
+	conn = limitConn(meterConn(conn, traffic))
 	defer conn.Close()
 
-	trackConn(conn)
+	stats := trackConn(conn, node, country, src)
+	stats.traffic = traffic
 	defer untrackConn(conn)
+	defer highBandwidth.leave(stats.compact)
 
 	// Perform handshake
-	if err := doHandshake(conn, addr, plog, db); err != nil {
+	identity, features, err := doHandshake(conn, addr, traffic, plog, db)
+	if err != nil {
 		plog.Warn().Err(err).Msg("Handshake failed")
 		metrics.PeerHandshakeFailures.Inc()
-		pm.MarkFailed(addr)
+		pm.MarkFailed(addr, PenaltyHandshake)
+		if errors.Is(err, errNonceReplay) {
+			pm.Ban(addr, "sent back our version nonce")
+		}
 		return
 	}
 
+	// Peers joining while backpressure is active get the same feefilter
+	if rate := feeFilterRate.Load(); rate > 0 {
+		sendFeeFilter(conn, rate)
+	}
+
+	if stats.compact != nil {
+		stats.compact.announce(conn)
+	}
+	requestHeaderAnnouncements(conn, identity.version.Version)
+
+	// Ask for the peer's known addresses, for the crawler and source
+	// attribution; peers answer this once per connection
+	conn.Write(protocol.CreateMessagePacket("getaddr", []byte{}))
+
 	// Update geo info in database
 	geoInfo := &database.PeerGeoInfo{
 		CountryCode: node.CountryCode,
 		City:        node.City,
-		Region:      country, // Use country as region for backwards compatibility
+		Region:      region,
 		Latitude:    node.Latitude,
 		Longitude:   node.Longitude,
 		ASN:         node.ASN,
 		OrgName:     node.OrgName,
 	}
 	if err := db.UpdatePeerGeoInfo(addr, geoInfo); err != nil {
-		plog.Error().Err(err).Msg("DB UpdatePeerGeoInfo error")
+		logger.Error(plog, err, "DB UpdatePeerGeoInfo error")
+	}
+
+	identity.start(node.ASN)
+	stats.identity = identity
+	features.since = time.Now()
+	stats.features = features
+	stats.wtxidRelay = features.WTxIDRelay
+	if stats.wtxidRelay {
+		metrics.WTxIDRelayPeers.Inc()
+		defer metrics.WTxIDRelayPeers.Dec()
 	}
 
+	stats.tcp = tcp
+	startSession(stats, addr, region, plog, db)
+	noteVersionClaims(conn.RemoteAddr().String(), region, identity.version)
+
 	pm.SetActive(country, addr, node)
 	connectedAt := time.Now()
 	metrics.PeersActive.Inc()
-	metrics.PeersByRegion.WithLabelValues(country).Inc()
+	metrics.PeersByRegion.WithLabelValues(region).Inc()
 	plog.Info().Str("city", node.City).Str("country", node.CountryCode).Msg("Connected")
 
+	events.Publish(events.PeerConnected, events.PeerInfo{Peer: addr, Region: region})
+
 	// Run message loop
-	runMessageLoop(ctx, conn, addr, country, plog, db)
+	runMessageLoop(ctx, conn, stats, addr, region, plog, db)
+	endSession(stats, plog, db)
+	noteClaimsDisconnected(conn.RemoteAddr().String())
+
+	events.Publish(events.PeerDisconnected, events.PeerInfo{Peer: addr, Region: region})
 
 	pm.RemoveActive(country, addr)
 	metrics.PeersActive.Dec()
-	metrics.PeersByRegion.WithLabelValues(country).Dec()
+	metrics.PeersByRegion.WithLabelValues(region).Dec()
 	metrics.PeerDisconnections.Inc()
 
 	// Track disconnection - if connection lasted less than 1 minute, it's suspicious
@@ -111,54 +294,112 @@ func ObserveNode(ctx context.Context, node *Node, country string, pm *PeerManage
 	}
 }
 
-func doHandshake(conn net.Conn, address string, plog zerolog.Logger, db *database.DB) error {
+// maxPreVerackMessages bounds the messages accepted before a peer's verack
+const maxPreVerackMessages = 8
+
+// doHandshake exchanges version and verack, returning the peer's identity
+// seeded with its version and the messages it sent before verack, and the
+// features negotiated before verack
+func doHandshake(conn net.Conn, address string, traffic *peerTraffic, plog zerolog.Logger, db database.Storage) (*peerIdentity, *peerFeatures, error) {
 	conn.SetDeadline(time.Now().Add(30 * time.Second))
 	defer conn.SetDeadline(time.Time{})
 
 	// Create and send version message
 	versionMsg := protocol.CreateVersionMessage(conn.RemoteAddr().String())
+	rememberNonce(versionMsg.Nonce, address, plog, db)
 	versionBytes, err := protocol.EncodeVersionMessage(versionMsg)
 	if err != nil {
-		return fmt.Errorf("encode version: %w", err)
+		return nil, nil, fmt.Errorf("encode version: %w", err)
 	}
 
 	versionPacket := protocol.CreateMessagePacket("version", versionBytes)
 	if _, err := conn.Write(versionPacket); err != nil {
-		return fmt.Errorf("send version: %w", err)
+		return nil, nil, fmt.Errorf("send version: %w", err)
 	}
 
 	// Receive peer's version message
 	peerVersion, err := protocol.ReadMessage(conn)
 	if err != nil {
-		return fmt.Errorf("read version: %w", err)
+		return nil, nil, fmt.Errorf("read version: %w", err)
 	}
+	traffic.received(protocol.CommandString(peerVersion), peerVersion.Payload)
+	storeMessage(address, protocol.CommandString(peerVersion), peerVersion.Payload)
 
 	// Parse and record peer version info
 	peerVersionData, err := protocol.ParseVersionMessage(peerVersion.Payload)
 	if err != nil {
-		return fmt.Errorf("parse version: %w", err)
+		return nil, nil, fmt.Errorf("parse version: %w", err)
+	}
+	if err := checkNonce(peerVersionData.Nonce, versionMsg.Nonce, address, plog, db); err != nil {
+		return nil, nil, err
 	}
 
-	if err := db.RecordPeerConnection(address, peerVersionData); err != nil {
-		plog.Error().Err(err).Msg("DB RecordPeerConnection error")
+	if change, err := db.RecordPeerConnection(address, peerVersionData); err != nil {
+		logger.Error(plog, err, "DB RecordPeerConnection error")
+	} else if change != nil {
+		metrics.PeerVersionChanges.WithLabelValues(change.Kind).Inc()
+		plog.Info().
+			Str("kind", change.Kind).
+			Int32("old_version", change.OldVersion).
+			Int32("new_version", change.NewVersion).
+			Str("old_services", fmt.Sprintf("%#x", change.OldServices)).
+			Str("new_services", fmt.Sprintf("%#x", change.NewServices)).
+			Str("old_user_agent", change.OldUserAgent).
+			Str("new_user_agent", change.NewUserAgent).
+			Msg("Peer version changed")
+	}
+
+	// Offer wtxid relay (BIP339) to peers recent enough to understand it; like
+	// sendaddrv2 it must precede verack
+	offerWTxIDRelay := peerVersionData.Version >= protocol.WTxIDRelayVersion
+	if offerWTxIDRelay {
+		if _, err := conn.Write(protocol.CreateMessagePacket("wtxidrelay", []byte{})); err != nil {
+			return nil, nil, fmt.Errorf("send wtxidrelay: %w", err)
+		}
+	}
+
+	// Ask for addrv2 gossip (BIP155); it must precede verack
+	if _, err := conn.Write(protocol.CreateMessagePacket("sendaddrv2", []byte{})); err != nil {
+		return nil, nil, fmt.Errorf("send sendaddrv2: %w", err)
 	}
 
 	// Send verack
 	verackPacket := protocol.CreateMessagePacket("verack", []byte{})
 	if _, err := conn.Write(verackPacket); err != nil {
-		return fmt.Errorf("send verack: %w", err)
+		return nil, nil, fmt.Errorf("send verack: %w", err)
 	}
 
-	// Receive peer's verack
-	_, err = protocol.ReadMessage(conn)
-	if err != nil {
-		return fmt.Errorf("read verack: %w", err)
+	// Receive peer's verack, noting the feature negotiation (wtxidrelay,
+	// sendaddrv2) that modern peers send ahead of it
+	identity := newPeerIdentity(peerVersionData)
+	features := &peerFeatures{}
+	for i := 0; ; i++ {
+		msg, err := protocol.ReadMessage(conn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read verack: %w", err)
+		}
+		command := protocol.CommandString(msg)
+		traffic.received(command, msg.Payload)
+		storeMessage(address, command, msg.Payload)
+		if command == "verack" {
+			break
+		}
+		if i >= maxPreVerackMessages {
+			return nil, nil, fmt.Errorf("no verack after %d messages", i+1)
+		}
+		switch {
+		case command == "wtxidrelay" && offerWTxIDRelay:
+			features.WTxIDRelay = true
+		case command == "sendaddrv2":
+			features.AddrV2 = true
+		}
+		identity.observe(command, msg.Payload)
 	}
 
-	return nil
+	return identity, features, nil
 }
 
-func runMessageLoop(ctx context.Context, conn net.Conn, address, region string, plog zerolog.Logger, db *database.DB) {
+func runMessageLoop(ctx context.Context, conn net.Conn, stats *connStats, address, region string, plog zerolog.Logger, db database.Storage) {
 	peerAddr := conn.RemoteAddr().String()
 	var pendingPingTime time.Time
 
@@ -166,6 +407,18 @@ func runMessageLoop(ctx context.Context, conn net.Conn, address, region string,
 	blockCount := 0
 	lastSummary := time.Now()
 
+	trace := newPeerTrace(address, plog)
+	defer trace.Close()
+
+	// Buffered so a corrupt stream can be scanned for the next message
+	r := bufio.NewReaderSize(conn, 64*1024)
+
+	forks := newForkMonitor(address, plog, db)
+	if forks != nil {
+		defer forks.close()
+	}
+	headers := newHeaderSync(plog, db)
+
 	for {
 		// Check for shutdown signal
 		select {
@@ -177,12 +430,18 @@ func runMessageLoop(ctx context.Context, conn net.Conn, address, region string,
 
 		conn.SetReadDeadline(time.Now().Add(10 * time.Minute))
 
-		msg, err := protocol.ReadMessage(conn)
+		msg, err := protocol.ReadMessage(r)
 		if err != nil {
 			if ctx.Err() != nil {
 				plog.Info().Msg("Shutdown complete")
 				return
 			}
+			if reason := framingErrorReason(err); reason != "" {
+				if recoverFraming(r, stats, reason, err, plog) {
+					continue
+				}
+				return
+			}
 			if err == io.EOF {
 				plog.Info().Msg("Connection closed by peer")
 			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -193,52 +452,141 @@ func runMessageLoop(ctx context.Context, conn net.Conn, address, region string,
 			return
 		}
 
+		// A message read after shutdown began is dropped, so nothing more is
+		// queued once intake has stopped
+		if ctx.Err() != nil {
+			plog.Info().Msg("Shutting down")
+			return
+		}
+
+		stats.messages.Add(1)
 		command := protocol.CommandString(msg)
+		stats.traffic.received(command, msg.Payload)
+		trace.Message(command, msg.Payload)
+		storeMessage(address, command, msg.Payload)
+		stats.identity.observe(command, msg.Payload)
+		stats.identity.maybeResolve(address, plog, db)
+		stats.features.observe(command, msg.Payload)
+		stats.features.maybeRecord(stats.session, plog, db)
+
+		if forks != nil {
+			forks.maybeProbe(conn)
+		}
+		if headers != nil {
+			headers.maybeSync(conn)
+		}
 
 		switch command {
 		case "inv":
-			handleInv(conn, msg, address, peerAddr, plog, db)
+			handleInv(conn, stats, msg, address, peerAddr, region, plog, db)
 
 		case "tx":
 			tx, err := protocol.ParseTxMessage(msg.Payload)
 			if err != nil {
+				recordParseError(command, msg.Payload, err, peerAddr, plog, db)
 				continue
 			}
 			txCount++
 			metrics.TxReceived.Inc()
-			if err := db.RecordTransaction(tx); err != nil {
-				plog.Error().Err(err).Msg("DB RecordTransaction error")
+			metrics.TxVSize.Observe(float64(tx.VSize()))
+			metrics.TxInputCount.Observe(float64(len(tx.Inputs)))
+			metrics.TxOutputCount.Observe(float64(len(tx.Outputs)))
+			noteWTxID(tx, plog, db)
+			start := time.Now()
+			fee, err := db.RecordTransactionFee(tx)
+			recordWriteLatency(time.Since(start))
+			if err != nil {
+				logger.Error(plog, err, "DB RecordTransaction error")
 			} else {
 				metrics.TxRecordedDB.Inc()
+				if fee != nil {
+					metrics.TxFeeRate.Observe(fee.Rate())
+				}
+				checkFee(tx, fee, plog, db)
+				checkLowFee(tx, fee, plog, db)
+				noteTxRelayed(peerAddr, fee)
+			}
+			checkLockTime(tx, plog, db)
+			publishTx(tx, address, region)
+			if features.Enabled(features.ConflictDetection) {
+				if conflicts, err := db.DetectInputConflicts(tx); err != nil {
+					logger.Error(plog, err, "DB DetectInputConflicts error")
+				} else if len(conflicts) > 0 {
+					publishDoubleSpend(tx, conflicts, address, region)
+				}
+			}
+			recordSpendTypes(tx, plog, db)
+			tagProjects(tx, fee, tagScriptTemplates(tx, plog, db), plog, db)
+			if features.Enabled(features.MempoolTracking) {
+				mempool.add(tx)
 			}
-			db.DetectInputConflicts(tx)
 
 		case "block":
 			block, err := protocol.ParseBlockMessage(msg.Payload)
 			if err != nil {
+				recordParseError(command, msg.Payload, err, peerAddr, plog, db)
+				if !keepPartialBlock(block, err, plog, db) {
+					continue
+				}
+			}
+			if handleBlock(conn, block, address, peerAddr, region, plog, db) {
+				stats.noteBlock(block.Height)
+				noteBlockServed(peerAddr)
+				blockCount++
+			}
+
+		case "sendcmpct":
+			if stats.compact != nil {
+				stats.compact.handleSendCmpct(msg.Payload, address)
+			}
+
+		case "cmpctblock", "blocktxn":
+			if stats.compact == nil {
+				continue
+			}
+			var block *protocol.Block
+			if command == "cmpctblock" {
+				block, err = stats.compact.handleCmpctBlock(conn, msg.Payload, peerAddr, region, plog, db)
+			} else {
+				block, err = stats.compact.handleBlockTxn(conn, msg.Payload, plog)
+			}
+			if err != nil {
+				recordParseError(command, msg.Payload, err, peerAddr, plog, db)
 				continue
 			}
-			plog.Info().
-				Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
-				Int("height", int(block.Height)).
-				Int("txs", len(block.Transactions)).
-				Msg("BLOCK")
-			blockCount++
-			metrics.BlocksReceived.Inc()
-			metrics.BlockHeight.Set(float64(block.Height))
-			metrics.BlockTxCount.Observe(float64(len(block.Transactions)))
-
-			db.RecordBlock(block, peerAddr)
-			for _, tx := range block.Transactions {
-				db.RecordTransaction(tx)
+			if block != nil && handleBlock(conn, block, address, peerAddr, region, plog, db) {
+				stats.noteBlock(block.Height)
+				noteBlockServed(peerAddr)
+				blockCount++
 			}
 
-			txHashes := make([][]byte, len(block.Transactions))
-			for i, tx := range block.Transactions {
-				txHashes[i] = tx.TxID[:]
+		case "addr", "addrv2":
+			recordAddresses(command, msg.Payload, peerAddr, plog, db)
+
+		case "headers":
+			answered := 0
+			for _, awaiting := range []bool{forks.awaitingHeaders(), headers.awaitingHeaders()} {
+				if awaiting {
+					answered++
+				}
+			}
+			if answered > 0 {
+				noteHeadersAnswered(peerAddr, answered)
+			} else {
+				handleHeaderAnnouncement(conn, stats, msg.Payload, address, peerAddr, region, plog, db)
+			}
+			if forks != nil {
+				forks.handleHeaders(msg.Payload)
 			}
-			blockTime := time.Unix(int64(block.Header.Timestamp), 0)
-			db.ConfirmTransactions(block.BlockHash[:], int(block.Height), blockTime, txHashes)
+			if headers != nil {
+				headers.handleHeaders(conn, msg.Payload)
+			}
+
+		case "feefilter":
+			noteFeeFilter(peerAddr, msg.Payload)
+
+		case "notfound":
+			noteNotFound(peerAddr, msg.Payload)
 
 		case "ping":
 			pongPacket := protocol.CreateMessagePacket("pong", msg.Payload)
@@ -247,12 +595,19 @@ func runMessageLoop(ctx context.Context, conn net.Conn, address, region string,
 		case "pong":
 			if !pendingPingTime.IsZero() {
 				latencyMs := int(time.Since(pendingPingTime).Milliseconds())
+				stats.latencyMs.Store(int64(latencyMs))
 				db.UpdatePeerLatency(address, latencyMs)
 				metrics.PeerLatency.WithLabelValues(region).Observe(float64(latencyMs))
+				checkGeoRTT(stats, latencyMs, address, region, plog, db)
+				noteSlowPing(address, latencyMs)
+				highBandwidth.noteLatency(stats.compact, latencyMs)
+				recordSessionPing(stats, latencyMs, region, plog, db)
 				pendingPingTime = time.Time{}
 			}
 		}
 
+		stats.compact.syncMode(conn, plog)
+
 		if time.Since(lastSummary) >= 60*time.Second {
 			plog.Info().Int("txs", txCount).Int("blocks", blockCount).Msg("Status")
 			txCount = 0
@@ -271,29 +626,68 @@ func runMessageLoop(ctx context.Context, conn net.Conn, address, region string,
 	}
 }
 
-func handleInv(conn net.Conn, msg *protocol.Message, address, peerAddr string, plog zerolog.Logger, db *database.DB) {
+func handleInv(conn net.Conn, stats *connStats, msg *protocol.Message, address, peerAddr, region string, plog zerolog.Logger, db database.Storage) {
 	inv := protocol.ParseInvMessage(msg.Payload)
+	stats.invItems.Add(int64(inv.TxCount + inv.BlockCount))
 
-	// Record observations
+	// Peers using wtxid relay announce by wtxid; those are recorded under the
+	// txid once the transaction has arrived, and merged into it on arrival
+	for i, v := range inv.TxVectors {
+		if v.Type != protocol.InvTypeWTx {
+			continue
+		}
+		metrics.InvWTxAnnouncements.Inc()
+		if txid, ok := txidForWTxID(v.Hash); ok {
+			inv.TxVectors[i] = protocol.InvVector{Type: protocol.InvTypeTx, Hash: txid}
+		}
+	}
+
+	// Drop re-announcements of items this peer already sent us, so echoes
+	// neither count as observations nor trigger another request
+	var txEchoes, blockEchoes int
+	inv.TxVectors, txEchoes = stats.known.filter(inv.TxVectors)
+	inv.BlockVectors, blockEchoes = stats.known.filter(inv.BlockVectors)
+	if txEchoes+blockEchoes > 0 {
+		metrics.InvEchoes.Add(float64(txEchoes + blockEchoes))
+		inv.TxCount -= txEchoes
+		inv.BlockCount -= blockEchoes
+	}
+
+	// Record observations. These writes go on while tx bodies are paused,
+	// so they keep the write latency average current.
 	for _, v := range inv.TxVectors {
-		if err := db.RecordObservation(v.Hash[:], peerAddr); err != nil {
-			plog.Error().Err(err).Msg("DB RecordObservation error")
+		start := time.Now()
+		err := db.RecordObservation(v.Hash[:], peerAddr)
+		recordWriteLatency(time.Since(start))
+		if err != nil {
+			logger.Error(plog, err, "DB RecordObservation error")
 		}
+		noteTxLatency(v.Hash, peerAddr)
+		events.Publish(events.TxAnnounced, events.TxAnnouncement{Peer: address, Region: region, TxHash: v.Hash})
 	}
 
 	// Update announcement counts and metrics
 	if inv.TxCount > 0 {
 		metrics.InvTxAnnouncements.Add(float64(inv.TxCount))
+		noteRegionTxs(region, inv.TxCount)
 	}
 	if inv.BlockCount > 0 {
 		metrics.InvBlockAnnouncements.Add(float64(inv.BlockCount))
+		recordBlockAnnouncements(inv.BlockVectors, peerAddr, region, arrivalInv, plog, db)
 	}
 	if inv.TxCount > 0 || inv.BlockCount > 0 {
 		if err := db.IncrementPeerAnnouncements(address, inv.TxCount, inv.BlockCount); err != nil {
-			plog.Error().Err(err).Msg("DB IncrementPeerAnnouncements error")
+			logger.Error(plog, err, "DB IncrementPeerAnnouncements error")
 		}
 	}
 
+	// Under memory, pipeline or bandwidth pressure keep the observations but
+	// skip downloading tx bodies
+	if Shedding() || getdataPaused() || bandwidthSaturated(conn) {
+		metrics.TxShed.Add(float64(len(inv.TxVectors)))
+		inv.TxVectors = nil
+	}
+
 	// Request new transactions
 	var newTxVectors []protocol.InvVector
 	for _, v := range inv.TxVectors {
@@ -303,28 +697,144 @@ func handleInv(conn net.Conn, msg *protocol.Message, address, peerAddr string, p
 			metrics.TxDeduplicated.Inc()
 		}
 	}
-	if len(newTxVectors) > 0 {
-		getDataPayload := protocol.CreateGetDataPayload(newTxVectors)
-		getDataPacket := protocol.CreateMessagePacket("getdata", getDataPayload)
-		conn.Write(getDataPacket)
-	}
+	stats.compact.requestTypes(newTxVectors)
+	sendGetData(conn, newTxVectors)
 
-	// Request new blocks
+	requestBlocks(conn, stats, inv.BlockVectors, region)
+}
+
+// requestBlocks asks the peer for announced blocks not requested yet, or
+// leaves them to a full-download region
+func requestBlocks(conn net.Conn, stats *connStats, vectors []protocol.InvVector, region string) {
+	if !features.Enabled(features.BlockDownloads) {
+		return
+	}
 	var newBlockVectors []protocol.InvVector
-	for _, v := range inv.BlockVectors {
-		if MarkSeenBlock(v.Hash) {
+	full := downloadsBlocks(region)
+	for _, v := range vectors {
+		if !full {
+			deferBlock(conn, v.Hash)
+		} else if MarkSeenBlock(v.Hash) {
 			newBlockVectors = append(newBlockVectors, v)
 		}
 	}
-	if len(newBlockVectors) > 0 {
-		getDataPayload := protocol.CreateGetDataPayload(newBlockVectors)
-		getDataPacket := protocol.CreateMessagePacket("getdata", getDataPayload)
-		conn.Write(getDataPacket)
+	stats.compact.requestTypes(newBlockVectors)
+	sendGetData(conn, newBlockVectors)
+}
+
+// noteWTxID marks both of a received transaction's ids as seen, so it isn't
+// requested again by either, and moves observations recorded under its
+// wtxid to its txid
+func noteWTxID(tx *protocol.Transaction, plog zerolog.Logger, db database.Storage) {
+	MarkSeenTx(tx.TxID)
+	if tx.WTxID == tx.TxID {
+		return
+	}
+	MarkSeenTx(tx.WTxID)
+	if !linkWTxID(tx.WTxID, tx.TxID) {
+		return
+	}
+	if err := db.MergeWTxIDObservations(tx.WTxID[:], tx.TxID[:]); err != nil {
+		logger.Error(plog, err, "DB MergeWTxIDObservations error")
+	}
+}
+
+// handleBlock records a received or reconstructed block and reports whether
+// it passed the checkpoint
+func handleBlock(conn net.Conn, block *protocol.Block, address, peerAddr, region string, plog zerolog.Logger, db database.Storage) bool {
+	if !acceptBlock(block, peerAddr, plog, db) {
+		return false
+	}
+	plog.Info().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Int("height", int(block.Height)).
+		Int("txs", len(block.Transactions)).
+		Msg("BLOCK")
+	trackBlockHeader(conn, block, plog, db)
+	metrics.BlocksReceived.Inc()
+	noteBlockHeight(block)
+	metrics.BlockTxCount.Observe(float64(len(block.Transactions)))
+	events.Publish(events.BlockReceived, events.BlockArrival{
+		Peer:    address,
+		Region:  region,
+		Hash:    block.BlockHash,
+		Height:  block.Height,
+		TxCount: len(block.Transactions),
+	})
+
+	start := time.Now()
+	db.RecordBlock(block, peerAddr)
+	recordWriteLatency(time.Since(start))
+	validateBlock(block, plog, db)
+	checkMerkleRoot(block, plog, db)
+	trackSignaling(block, plog, db)
+	fees := make([]*database.Fee, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		fees[i], _ = db.RecordTransactionFee(tx)
+		recordSpendTypes(tx, plog, db)
+		tagProjects(tx, nil, tagScriptTemplates(tx, plog, db), plog, db)
+	}
+
+	txHashes := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txHashes[i] = tx.TxID[:]
+	}
+	blockTime := time.Unix(int64(block.Header.Timestamp), 0)
+	db.ConfirmTransactions(block.BlockHash[:], int(block.Height), blockTime, txHashes)
+	accountBlockFees(block, fees, plog, db)
+	trackLockTimes(block, txHashes, plog, db)
+	if features.Enabled(features.ConflictDetection) {
+		resolveConflicts(block, txHashes, plog, db)
 	}
+	return true
+}
+
+// resolveConflicts records which side of each open double-spend conflict the
+// block confirmed
+func resolveConflicts(block *protocol.Block, txHashes [][]byte, plog zerolog.Logger, db database.Storage) {
+	outcomes, err := db.ResolveConflicts(block.BlockHash[:], block.Height, txHashes)
+	if err != nil {
+		logger.Error(plog, err, "DB ResolveConflicts error")
+		return
+	}
+	for _, o := range outcomes {
+		metrics.ConflictOutcomes.WithLabelValues(o.Outcome).Inc()
+		metrics.ConflictResolveSeconds.Observe(float64(o.ResolveMs) / 1000)
+	}
+}
+
+func publishTx(tx *protocol.Transaction, address, region string) {
+	var value int64
+	for _, out := range tx.Outputs {
+		value += out.Value
+	}
+	events.Publish(events.TxReceived, events.TxReceipt{
+		Peer:        address,
+		Region:      region,
+		TxID:        tx.TxID,
+		Size:        tx.SizeBytes,
+		Inputs:      len(tx.Inputs),
+		Outputs:     len(tx.Outputs),
+		OutputValue: value,
+		Segwit:      tx.Segwit,
+	})
+}
+
+func publishDoubleSpend(tx *protocol.Transaction, conflicts [][]byte, address, region string) {
+	hashes := make([]events.Hash, len(conflicts))
+	for i, c := range conflicts {
+		copy(hashes[i][:], c)
+	}
+	events.Publish(events.DoubleSpendDetected, events.DoubleSpend{
+		Peer:      address,
+		Region:    region,
+		TxID:      tx.TxID,
+		Conflicts: hashes,
+	})
 }
 
 // StartPeerManager starts the peer manager loop that maintains connections
-func StartPeerManager(ctx context.Context, pm *PeerManager, db *database.DB, wg *sync.WaitGroup) {
+func StartPeerManager(ctx context.Context, pm *PeerManager, db database.Storage, wg *sync.WaitGroup) {
 	go func() {
 		for {
 			select {
@@ -333,10 +843,17 @@ func StartPeerManager(ctx context.Context, pm *PeerManager, db *database.DB, wg
 			default:
 			}
 
-			for _, country := range TargetCountries {
+			// Don't replace shed peers until pressure eases
+			if CurrentPressure() >= PressureShedPeers {
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			policy := CurrentPeerPolicy()
+			for _, country := range policy.targetCountries() {
 				active := pm.ActiveCountByCountry(country)
-				if active < PeersPerCountry {
-					if node, ok := pm.GetNextPeer(country); ok {
+				if active < policy.target(country) {
+					if node, ok := pm.GetNextPeer(country, func(n *Node) bool { return policy.allows(n) && latencyAllows(n) }); ok {
 						wg.Add(1)
 						go ObserveNode(ctx, node, country, pm, db, wg)
 					}
@@ -0,0 +1,256 @@
+package observer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// knownMinerTags maps coinbase tags, matched case-insensitively anywhere in
+// the coinbase scriptSig, to the pool that mines with them
+var knownMinerTags = map[string]string{
+	"Foundry USA":  "Foundry USA",
+	"AntPool":      "AntPool",
+	"F2Pool":       "F2Pool",
+	"ViaBTC":       "ViaBTC",
+	"Binance":      "Binance Pool",
+	"MARA Pool":    "MARA Pool",
+	"SpiderPool":   "SpiderPool",
+	"Luxor":        "Luxor",
+	"SBICrypto":    "SBI Crypto",
+	"/slush/":      "Braiins Pool",
+	"OCEAN.XYZ":    "OCEAN",
+	"SecPool":      "SECPOOL",
+	"poolin":       "Poolin",
+	"btcom":        "BTC.com",
+	"WhitePool":    "WhitePool",
+	"Ultimus":      "ULTIMUSPOOL",
+	"Carbon Negat": "Carbon Negative",
+}
+
+// UnknownMiner labels blocks whose coinbase matches no tag
+const UnknownMiner = "unknown"
+
+// maxPrioritizedTxs bounds the prioritized transactions listed per block;
+// all of them are counted
+const maxPrioritizedTxs = 100
+
+// BlockOrderingConfig tunes the comparison of block transaction order with
+// fee-rate order
+type BlockOrderingConfig struct {
+	// MinerTags adds coinbase tags to recognise miners by, or renames a
+	// built-in one
+	MinerTags map[string]string `json:"miner_tags"`
+	// PrioritizedFactor flags a transaction placed ahead of one paying this
+	// many times its fee rate (default 2)
+	PrioritizedFactor float64 `json:"prioritized_factor"`
+}
+
+func (c *BlockOrderingConfig) applyDefaults() {
+	tags := make(map[string]string, len(knownMinerTags)+len(c.MinerTags))
+	for tag, miner := range knownMinerTags {
+		tags[tag] = miner
+	}
+	for tag, miner := range c.MinerTags {
+		tags[tag] = miner
+	}
+	c.MinerTags = tags
+	if c.PrioritizedFactor <= 1 {
+		c.PrioritizedFactor = 2
+	}
+}
+
+var orderingConfig atomic.Pointer[BlockOrderingConfig]
+
+// SetBlockOrdering applies the ordering analysis config
+func SetBlockOrdering(cfg BlockOrderingConfig) {
+	cfg.applyDefaults()
+	orderingConfig.Store(&cfg)
+}
+
+func currentOrderingConfig() *BlockOrderingConfig {
+	if cfg := orderingConfig.Load(); cfg != nil {
+		return cfg
+	}
+	cfg := &BlockOrderingConfig{}
+	cfg.applyDefaults()
+	orderingConfig.CompareAndSwap(nil, cfg)
+	return orderingConfig.Load()
+}
+
+// identifyMiner names the pool that mined a block from its coinbase tag.
+// The longest matching tag wins, so a specific tag beats a generic one.
+func identifyMiner(coinbase *protocol.Transaction, tags map[string]string) string {
+	if len(coinbase.Inputs) == 0 {
+		return UnknownMiner
+	}
+	script := strings.ToLower(string(coinbase.Inputs[0].ScriptSig))
+	miner, longest := UnknownMiner, 0
+	for tag, name := range tags {
+		if len(tag) > longest && strings.Contains(script, strings.ToLower(tag)) {
+			miner, longest = name, len(tag)
+		}
+	}
+	return miner
+}
+
+// analyzeBlockOrdering compares a block's transaction order with fee-rate
+// order and records the result. fees holds each transaction's fee, nil where
+// unknown; those transactions are left out.
+//
+// Bitcoin Core's templates are ordered by ancestor fee rate, so a parent is
+// ranked at the best rate of the packages it is mined in. The ordering
+// entropy is the fraction of transaction pairs placed against that rate:
+// 0 for a block in strict fee order, around 0.5 for a shuffled one.
+func analyzeBlockOrdering(block *protocol.Block, fees []*database.Fee, plog zerolog.Logger, db database.Storage) {
+	cfg := currentOrderingConfig()
+	o := database.BlockOrdering{
+		Height: block.Height,
+		Miner:  identifyMiner(block.Transactions[0], cfg.MinerTags),
+		Txs:    len(block.Transactions) - 1,
+	}
+
+	rates := packageRates(block.Transactions, fees)
+	var known []int // positions with a known rate, in block order
+	for i := 1; i < len(rates); i++ {
+		if rates[i] >= 0 {
+			known = append(known, i)
+		}
+	}
+	o.FeeKnownTxs = len(known)
+	if len(known) < 2 {
+		return
+	}
+
+	ordered := make([]float64, len(known))
+	for j, i := range known {
+		ordered[j] = rates[i]
+	}
+	inversions := countInversions(append([]float64(nil), ordered...))
+	pairs := int64(len(known)) * int64(len(known)-1) / 2
+	o.Inversions = inversions
+	o.Entropy = float64(inversions) / float64(pairs)
+
+	// A transaction ahead of one paying PrioritizedFactor times its rate
+	// was likely placed there for something other than its fee
+	best := 0.0
+	for j := len(known) - 1; j >= 0; j-- {
+		if ordered[j]*cfg.PrioritizedFactor < best {
+			o.Prioritized++
+			o.PrioritizedTxs = append(o.PrioritizedTxs, block.Transactions[known[j]].TxID[:])
+		}
+		best = max(best, ordered[j])
+	}
+	slices.Reverse(o.PrioritizedTxs)
+	if len(o.PrioritizedTxs) > maxPrioritizedTxs {
+		o.PrioritizedTxs = o.PrioritizedTxs[:maxPrioritizedTxs]
+	}
+
+	metrics.BlockOrderingEntropy.WithLabelValues(o.Miner).Observe(o.Entropy)
+	metrics.PrioritizedTxs.WithLabelValues(o.Miner).Add(float64(o.Prioritized))
+	plog.Debug().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Str("miner", o.Miner).
+		Float64("entropy", o.Entropy).
+		Int("prioritized", o.Prioritized).
+		Msg("Block ordering")
+	if err := db.RecordBlockOrdering(block.BlockHash[:], o); err != nil {
+		logger.Error(plog, err, "DB RecordBlockOrdering error")
+	}
+}
+
+// packageRates returns each transaction's fee rate as a template would rank
+// it: the best ancestor fee rate among itself and its in-block descendants.
+// Rates are -1 where the transaction's fee, or an in-block ancestor's, is
+// unknown, and for the coinbase.
+func packageRates(txs []*protocol.Transaction, fees []*database.Fee) []float64 {
+	index := make(map[[32]byte]int, len(txs))
+	for i, tx := range txs {
+		index[tx.TxID] = i
+	}
+	parents := make([][]int, len(txs))
+	for i := 1; i < len(txs); i++ {
+		for _, in := range txs[i].Inputs {
+			if p, ok := index[in.PrevTxHash]; ok && p > 0 && p < i {
+				parents[i] = append(parents[i], p)
+			}
+		}
+	}
+
+	// Ancestor sets, in block order since parents come first
+	rates := make([]float64, len(txs))
+	rates[0] = -1
+	ancestors := make([]map[int]bool, len(txs))
+	for i := 1; i < len(txs); i++ {
+		set := map[int]bool{i: true}
+		for _, p := range parents[i] {
+			for a := range ancestors[p] {
+				set[a] = true
+			}
+		}
+		ancestors[i] = set
+		var fee, vsize int64
+		for a := range set {
+			if fees[a] == nil {
+				fee = -1
+				break
+			}
+			fee += fees[a].Satoshis
+			vsize += int64((fees[a].Weight + 3) / 4)
+		}
+		if fee < 0 || vsize == 0 {
+			rates[i] = -1
+			continue
+		}
+		rates[i] = float64(fee) / float64(vsize)
+	}
+
+	// A parent is mined at the best rate of any package that includes it,
+	// so children lift their parents; children come later in the block
+	for i := len(txs) - 1; i > 0; i-- {
+		if rates[i] < 0 {
+			continue
+		}
+		for _, p := range parents[i] {
+			if rates[p] >= 0 && rates[i] > rates[p] {
+				rates[p] = rates[i]
+			}
+		}
+	}
+	return rates
+}
+
+// countInversions counts the pairs placed lower rate first, sorting rates
+// descending as it goes
+func countInversions(rates []float64) int64 {
+	if len(rates) < 2 {
+		return 0
+	}
+	mid := len(rates) / 2
+	left := append([]float64(nil), rates[:mid]...)
+	right := append([]float64(nil), rates[mid:]...)
+	n := countInversions(left) + countInversions(right)
+	i, j, k := 0, 0, 0
+	for i < len(left) && j < len(right) {
+		if left[i] >= right[j] {
+			rates[k] = left[i]
+			i++
+		} else {
+			// right[j] pays more than everything left in left
+			rates[k] = right[j]
+			n += int64(len(left) - i)
+			j++
+		}
+		k++
+	}
+	k += copy(rates[k:], left[i:])
+	copy(rates[k:], right[j:])
+	return n
+}
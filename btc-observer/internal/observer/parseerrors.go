@@ -0,0 +1,95 @@
+package observer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// ParseErrorConfig controls how parse failures are recorded
+type ParseErrorConfig struct {
+	// SampleBytes stores up to this many leading payload bytes with each
+	// failure (default 0, no sample)
+	SampleBytes int `json:"sample_bytes"`
+
+	// MaxPerMinute caps the failures stored each minute across all peers;
+	// the metric still counts every one (default 60)
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+func (c *ParseErrorConfig) applyDefaults() {
+	if c.SampleBytes < 0 {
+		c.SampleBytes = 0
+	}
+	if c.MaxPerMinute <= 0 {
+		c.MaxPerMinute = 60
+	}
+}
+
+var parseErrorConfig atomic.Pointer[ParseErrorConfig]
+
+// SetParseErrors replaces the parse failure recording settings
+func SetParseErrors(cfg ParseErrorConfig) {
+	cfg.applyDefaults()
+	parseErrorConfig.Store(&cfg)
+}
+
+// parseErrorBudget limits stored failures per minute
+var parseErrorBudget = struct {
+	sync.Mutex
+	minute time.Time
+	used   int
+}{}
+
+func takeParseErrorBudget(limit int) bool {
+	parseErrorBudget.Lock()
+	defer parseErrorBudget.Unlock()
+	now := time.Now().Truncate(time.Minute)
+	if !now.Equal(parseErrorBudget.minute) {
+		parseErrorBudget.minute = now
+		parseErrorBudget.used = 0
+	}
+	if parseErrorBudget.used >= limit {
+		return false
+	}
+	parseErrorBudget.used++
+	return true
+}
+
+// recordParseError counts a message that failed to parse and stores it for
+// later inspection
+func recordParseError(command string, payload []byte, err error, peerAddr string, plog zerolog.Logger, db database.Storage) {
+	cfg := parseErrorConfig.Load()
+	if cfg == nil {
+		cfg = &ParseErrorConfig{}
+		cfg.applyDefaults()
+	}
+
+	class := protocol.ParseErrorClass(err)
+	metrics.ParseErrors.WithLabelValues(command, class).Inc()
+	plog.Debug().Err(err).Str("command", command).Str("class", class).Int("size", len(payload)).Msg("Parse error")
+
+	if !takeParseErrorBudget(cfg.MaxPerMinute) {
+		return
+	}
+	var sample []byte
+	if cfg.SampleBytes > 0 {
+		sample = payload[:min(len(payload), cfg.SampleBytes)]
+	}
+	if err := db.RecordParseFailure(database.ParseFailure{
+		Command:  command,
+		Class:    class,
+		Error:    err.Error(),
+		Size:     len(payload),
+		Sample:   sample,
+		PeerAddr: peerAddr,
+	}); err != nil {
+		logger.Error(plog, err, "DB RecordParseFailure error")
+	}
+}
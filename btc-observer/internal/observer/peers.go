@@ -2,19 +2,20 @@ package observer
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
 	"github.com/keato/btc-observer/internal/logger"
 )
 
 const (
-	PeersPerCountry  = 1
-	failBackoff      = 5 * time.Minute
-	disconnectWindow = 2 * time.Minute
-	maxStrikes       = 2
+	PeersPerCountry = 1
+	failBackoff     = 5 * time.Minute
 )
 
 // TargetCountries defines the countries we want to connect to
@@ -61,27 +62,24 @@ func (n *Node) Addr() string {
 	return fmt.Sprintf("%s:%d", n.Address, n.Port)
 }
 
-// PeerManager tracks active peers by country
+// PeerManager tracks active peers by country. Misbehaving and failing
+// peers are tracked in their score rather than here.
 type PeerManager struct {
 	sync.RWMutex
 	activeByCountry map[string]map[string]*Node // country -> addr -> node
 	available       map[string][]*Node          // country -> nodes
-	failed          map[string]time.Time
-	strikes         map[string]int
-	lastDisconnect  map[string]time.Time
-	blacklist       map[string]bool
 }
 
-// NewPeerManager creates a new peer manager
-func NewPeerManager() *PeerManager {
+// NewPeerManager creates a new peer manager, restoring the peer scores, bans
+// and failure backoffs saved before the last shutdown
+func NewPeerManager(db database.Storage) (*PeerManager, error) {
+	if err := loadPeerScores(db); err != nil {
+		return nil, err
+	}
 	return &PeerManager{
 		activeByCountry: make(map[string]map[string]*Node),
 		available:       make(map[string][]*Node),
-		failed:          make(map[string]time.Time),
-		strikes:         make(map[string]int),
-		lastDisconnect:  make(map[string]time.Time),
-		blacklist:       make(map[string]bool),
-	}
+	}, nil
 }
 
 // SetActive marks a peer as actively connected
@@ -128,58 +126,109 @@ func (pm *PeerManager) SetAvailable(country string, nodes []*Node) {
 	pm.available[country] = nodes
 }
 
-// GetNextPeer returns the next available peer for a country
-func (pm *PeerManager) GetNextPeer(country string) (*Node, bool) {
+// AddAvailable appends nodes to a country's candidates, skipping ones already
+// listed, and returns how many were added
+func (pm *PeerManager) AddAvailable(country string, nodes []*Node) int {
+	pm.Lock()
+	defer pm.Unlock()
+	listed := make(map[string]bool, len(pm.available[country]))
+	for _, node := range pm.available[country] {
+		listed[node.Addr()] = true
+	}
+	added := 0
+	for _, node := range nodes {
+		if !listed[node.Addr()] {
+			listed[node.Addr()] = true
+			pm.available[country] = append(pm.available[country], node)
+			added++
+		}
+	}
+	return added
+}
+
+// GetNextPeer returns the available peer for a country with the lowest
+// penalty that passes allow, skipping banned peers and ones in failure
+// backoff. Ties keep the discovery order.
+func (pm *PeerManager) GetNextPeer(country string, allow func(*Node) bool) (*Node, bool) {
 	pm.Lock()
 	defer pm.Unlock()
 
 	nodes := pm.available[country]
 	active := pm.activeByCountry[country]
-	if active == nil {
-		active = make(map[string]*Node)
-	}
 
+	cfg := currentScoreConfig()
 	now := time.Now()
+	peerScores.Lock()
+	defer peerScores.Unlock()
+
+	var best *Node
+	bestPenalty := math.Inf(1)
 	for _, node := range nodes {
 		addr := node.Addr()
-		if pm.blacklist[addr] {
-			continue
-		}
 		if _, isActive := active[addr]; isActive {
 			continue
 		}
-		if lastFail, failed := pm.failed[addr]; failed && now.Sub(lastFail) < failBackoff {
+		if peerBackingOff(addr, now) || peerBanned(addr, cfg, now) || !allow(node) {
 			continue
 		}
-		return node, true
+		if penalty := peerPenalty(addr, cfg, now); penalty < bestPenalty {
+			best, bestPenalty = node, penalty
+		}
 	}
-	return nil, false
+	return best, best != nil
 }
 
-// MarkFailed marks a peer as failed (connection or handshake failure)
-func (pm *PeerManager) MarkFailed(addr string) {
-	pm.Lock()
-	defer pm.Unlock()
-	pm.failed[addr] = time.Now()
+// MarkFailed puts a peer in failure backoff after a connection or handshake
+// failure, penalizing it for the kind of failure
+func (pm *PeerManager) MarkFailed(addr, kind string) {
+	failPeer(addr, kind)
 }
 
-// MarkDisconnect tracks rapid disconnections and blacklists problematic peers
+// MarkDisconnect penalizes a peer that disconnected soon after connecting
+// and puts it in failure backoff
 func (pm *PeerManager) MarkDisconnect(addr string) {
-	pm.Lock()
-	defer pm.Unlock()
+	failPeer(addr, PenaltyShortSession)
+}
+
+// Ban blacklists a misbehaving peer until the blacklist is cleared
+func (pm *PeerManager) Ban(addr, reason string) {
+	banPeer(addr, reason)
+	logger.Log.Warn().Str("peer", addr).Msg("Blacklisted peer (" + reason + ")")
+	events.Publish(events.PeerBanned, events.PeerBan{Peer: addr, Reason: reason})
+}
 
+// Blacklisted returns the banned peer addresses, sorted
+func (pm *PeerManager) Blacklisted() []string {
+	cfg := currentScoreConfig()
 	now := time.Now()
-	if lastDc, ok := pm.lastDisconnect[addr]; ok && now.Sub(lastDc) < disconnectWindow {
-		pm.strikes[addr]++
-		if pm.strikes[addr] >= maxStrikes {
-			pm.blacklist[addr] = true
-			logger.Log.Warn().Str("peer", addr).Msg("Blacklisted peer (repeated rapid disconnections)")
+	peerScores.Lock()
+	defer peerScores.Unlock()
+	var addrs []string
+	for addr := range peerScores.peers {
+		if peerBanned(addr, cfg, now) {
+			addrs = append(addrs, addr)
 		}
-	} else {
-		pm.strikes[addr] = 1
 	}
-	pm.lastDisconnect[addr] = now
-	pm.failed[addr] = now
+	sort.Strings(addrs)
+	return addrs
+}
+
+// ClearBlacklist unbans every peer and resets their penalties and failure
+// backoff, so they can be dialed again at once. It returns how many peers
+// were banned.
+func (pm *PeerManager) ClearBlacklist() int {
+	n := len(pm.Blacklisted())
+	peerScores.Lock()
+	for _, s := range peerScores.peers {
+		s.penalty = 0
+		s.banReason = ""
+		s.scoreBan = false
+		s.failed = time.Time{}
+		s.dirty = true
+	}
+	peerScores.Unlock()
+	logger.Log.Info().Int("peers", n).Msg("Blacklist cleared")
+	return n
 }
 
 // Status returns a string summarizing active peers by country
@@ -0,0 +1,171 @@
+package observer
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/logger"
+)
+
+// PeerPolicy controls which peers the peer manager connects to. The zero
+// value is the default policy: PeersPerCountry peers in each TargetCountries
+// country, from any AS.
+type PeerPolicy struct {
+	// Countries replaces the target country list when set
+	Countries []string `json:"countries,omitempty"`
+
+	// PeersPerCountry replaces the per-country peer target when set
+	PeersPerCountry int `json:"peers_per_country,omitempty"`
+
+	// CountryPeers sets the peer target for individual countries
+	CountryPeers map[string]int `json:"country_peers,omitempty"`
+
+	// ASNs restricts connections to peers in these ASes
+	ASNs []string `json:"asns,omitempty"`
+}
+
+func (p *PeerPolicy) targetCountries() []string {
+	if len(p.Countries) > 0 {
+		return p.Countries
+	}
+	countries := TargetCountries
+	for c := range p.CountryPeers {
+		if !IsTargetCountry(c) {
+			countries = append(countries[:len(countries):len(countries)], c)
+		}
+	}
+	return countries
+}
+
+func (p *PeerPolicy) target(country string) int {
+	if n, ok := p.CountryPeers[country]; ok {
+		return n
+	}
+	if p.PeersPerCountry > 0 {
+		return p.PeersPerCountry
+	}
+	return PeersPerCountry
+}
+
+// TargetFill returns how many of the peer policy's connection slots are
+// filled and how many there are. Peers above a country's target don't count.
+func (pm *PeerManager) TargetFill() (active, target int) {
+	policy := CurrentPeerPolicy()
+	for _, country := range policy.targetCountries() {
+		n := policy.target(country)
+		active += min(pm.ActiveCountByCountry(country), n)
+		target += n
+	}
+	return active, target
+}
+
+func (p *PeerPolicy) allows(node *Node) bool {
+	if len(p.ASNs) == 0 {
+		return true
+	}
+	asn := normalizeASN(node.ASN)
+	for _, a := range p.ASNs {
+		if normalizeASN(a) == asn {
+			return true
+		}
+	}
+	return false
+}
+
+var peerPolicy atomic.Pointer[PeerPolicy]
+
+// CurrentPeerPolicy returns the policy the peer manager is following
+func CurrentPeerPolicy() *PeerPolicy {
+	if p := peerPolicy.Load(); p != nil {
+		return p
+	}
+	return &PeerPolicy{}
+}
+
+// SetPeerPolicy switches peer selection to p (nil restores the default) and
+// disconnects discovered peers the new policy doesn't want, so the peer
+// manager refills their slots under it. Static peers are left alone.
+func SetPeerPolicy(p *PeerPolicy) {
+	peerPolicy.Store(p)
+	policy := CurrentPeerPolicy()
+
+	wanted := make(map[string]bool)
+	for _, c := range policy.targetCountries() {
+		wanted[c] = true
+	}
+
+	activeConns.Lock()
+	defer activeConns.Unlock()
+
+	// Keep the longest-lived peers when a country is over its target
+	type entry struct {
+		conn  net.Conn
+		stats *connStats
+	}
+	byCountry := make(map[string][]entry)
+	for conn, stats := range activeConns.conns {
+		if stats.country == StaticRegion || stats.country == TorRegion {
+			continue
+		}
+		if !wanted[stats.country] || !policy.allows(stats.node) {
+			logger.Log.Info().Str("peer", stats.node.Addr()).Msg("Disconnecting peer outside new peer policy")
+			conn.Close()
+			continue
+		}
+		byCountry[stats.country] = append(byCountry[stats.country], entry{conn, stats})
+	}
+	for country, entries := range byCountry {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].stats.since.Before(entries[j].stats.since) })
+		for _, e := range entries[min(len(entries), policy.target(country)):] {
+			logger.Log.Info().Str("peer", e.stats.node.Addr()).Msg("Disconnecting peer over new peer policy target")
+			e.conn.Close()
+		}
+	}
+}
+
+// SetPeersPerCountry changes the per-country peer target of the current
+// policy, keeping its countries, per-country overrides and ASNs. 0 restores
+// the default target.
+func SetPeersPerCountry(n int) {
+	policy := *CurrentPeerPolicy()
+	policy.PeersPerCountry = n
+	SetPeerPolicy(&policy)
+	logger.Log.Info().Int("peers_per_country", n).Msg("Peer target changed")
+}
+
+// discoveryWants lists extra countries and ASNs discovery keeps candidates
+// for, beyond the default target countries
+var discoveryWants = struct {
+	sync.RWMutex
+	countries map[string]bool
+	asns      map[string]bool
+}{countries: make(map[string]bool), asns: make(map[string]bool)}
+
+// WidenDiscovery makes discovery keep candidates for a policy that will be
+// applied later, so its countries and ASNs have peers to choose from
+func WidenDiscovery(p PeerPolicy) {
+	discoveryWants.Lock()
+	defer discoveryWants.Unlock()
+	for _, c := range p.targetCountries() {
+		discoveryWants.countries[c] = true
+	}
+	for _, a := range p.ASNs {
+		if asn := normalizeASN(a); asn != "" {
+			discoveryWants.asns[asn] = true
+		}
+	}
+}
+
+// wantedCandidate reports whether discovery should keep a node as a
+// candidate, and whether it should be kept regardless of the per-country cap
+// because its AS was asked for
+func wantedCandidate(node *Node) (keep, uncapped bool) {
+	discoveryWants.RLock()
+	defer discoveryWants.RUnlock()
+	if discoveryWants.asns[normalizeASN(node.ASN)] {
+		return true, true
+	}
+	return IsTargetCountry(node.CountryCode) || discoveryWants.countries[node.CountryCode], false
+}
@@ -0,0 +1,289 @@
+package observer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// probeNetworks are the networks relay probes may run on, where the coins
+// they spend have no value
+var probeNetworks = map[string]bool{"testnet3": true, "testnet4": true, "signet": true, "regtest": true}
+
+// probeOutputTypes are the address types a probe can pay to, as Bitcoin
+// Core's getnewaddress names them
+var probeOutputTypes = map[string]bool{"legacy": true, "p2sh-segwit": true, "bech32": true, "bech32m": true}
+
+// RelayProbeConfig sends crafted transactions on a test network to measure
+// relay policy: each is sent to one peer, and the other peers that announce
+// it back show who relayed it and how fast. Transactions are built and
+// signed by a Bitcoin Core wallet over RPC; it needs funds on the network.
+type RelayProbeConfig struct {
+	RPCURL      string `json:"rpc_url"`
+	RPCUser     string `json:"rpc_user"`
+	RPCPassword string `json:"rpc_password"`
+	Wallet      string `json:"wallet"` // default: the node's default wallet
+
+	IntervalSeconds int   `json:"interval_seconds"` // between rounds of one probe per variant (default 600)
+	MeasureSeconds  int   `json:"measure_seconds"`  // announcements counted after sending (default 120)
+	AmountSats      int64 `json:"amount_sats"`      // paid back to the wallet (default 10000)
+
+	Variants []ProbeVariant `json:"variants"` // default defaultProbeVariants
+
+	// Funding tops the wallet up automatically; without it the wallet has
+	// to be funded by hand
+	Funding *ProbeFundingConfig `json:"funding"`
+}
+
+// ProbeVariant is one kind of probe transaction
+type ProbeVariant struct {
+	Name       string  `json:"name"`
+	FeeRate    float64 `json:"fee_rate"`    // sat/vB
+	OutputType string  `json:"output_type"` // legacy, p2sh-segwit, bech32 (default) or bech32m
+	DataBytes  int     `json:"data_bytes"`  // adds an OP_RETURN output of this many bytes
+}
+
+// defaultProbeVariants cover the fee floor, output script types and the
+// OP_RETURN size limit
+var defaultProbeVariants = []ProbeVariant{
+	{Name: "bech32-1", FeeRate: 1, OutputType: "bech32"},
+	{Name: "bech32m-1", FeeRate: 1, OutputType: "bech32m"},
+	{Name: "legacy-1", FeeRate: 1, OutputType: "legacy"},
+	{Name: "bech32-0.1", FeeRate: 0.1, OutputType: "bech32"},
+	{Name: "data80-2", FeeRate: 2, OutputType: "bech32", DataBytes: 80},
+	{Name: "data200-2", FeeRate: 2, OutputType: "bech32", DataBytes: 200},
+}
+
+func (c *RelayProbeConfig) applyDefaults() {
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = 600
+	}
+	if c.MeasureSeconds <= 0 {
+		c.MeasureSeconds = 120
+	}
+	if c.AmountSats <= 0 {
+		c.AmountSats = 10000
+	}
+	if len(c.Variants) == 0 {
+		c.Variants = defaultProbeVariants
+	}
+	for i := range c.Variants {
+		if c.Variants[i].OutputType == "" {
+			c.Variants[i].OutputType = "bech32"
+		}
+	}
+	if c.Funding != nil {
+		c.Funding.applyDefaults(c.AmountSats * int64(len(c.Variants)))
+	}
+}
+
+// prober sends relay probes through the wallet
+type prober struct {
+	cfg    RelayProbeConfig
+	wallet *rpcClient
+	funder *funder // nil without automatic funding
+	db     *database.DB
+}
+
+// StartRelayProbes sends a probe per variant every interval until ctx is
+// done. It refuses to run on mainnet.
+func StartRelayProbes(ctx context.Context, db *database.DB, cfg RelayProbeConfig) error {
+	if network := protocol.ActiveNetwork().Name; !probeNetworks[network] {
+		return fmt.Errorf("relay probes only run on test networks, not %s", network)
+	}
+	cfg.applyDefaults()
+	names := make(map[string]bool)
+	for _, v := range cfg.Variants {
+		if v.Name == "" || names[v.Name] {
+			return fmt.Errorf("probe variant names must be unique and not empty (got %q)", v.Name)
+		}
+		names[v.Name] = true
+		if v.FeeRate <= 0 || v.DataBytes < 0 || !probeOutputTypes[v.OutputType] {
+			return fmt.Errorf("probe variant %q: need a positive fee_rate, data_bytes >= 0 and output_type legacy, p2sh-segwit, bech32 or bech32m", v.Name)
+		}
+	}
+	rpc, err := newRPCClient(cfg.RPCURL, cfg.RPCUser, cfg.RPCPassword, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("relay probes: %w", err)
+	}
+	p := &prober{cfg: cfg, wallet: rpc.wallet(cfg.Wallet), db: db}
+	if cfg.Funding != nil {
+		if p.funder, err = newFunder(*cfg.Funding); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			p.ensureFunds(ctx)
+			for _, v := range p.cfg.Variants {
+				if err := p.send(ctx, v); err != nil {
+					metrics.RelayProbes.WithLabelValues(v.Name, "failed").Inc()
+					logger.Log.Warn().Err(err).Str("variant", v.Name).Msg("Relay probe failed")
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// send builds a probe with the wallet, sends it to one peer and schedules
+// its measurement
+func (p *prober) send(ctx context.Context, v ProbeVariant) error {
+	conn, firstHop := probeFirstHop()
+	if conn == nil {
+		return fmt.Errorf("no connected peers")
+	}
+	raw, err := p.build(ctx, v)
+	if err != nil {
+		return err
+	}
+	tx, err := protocol.ParseTxMessage(raw)
+	if err != nil {
+		return fmt.Errorf("parse signed probe: %w", err)
+	}
+
+	sentAt := time.Now()
+	if _, err := conn.Write(protocol.CreateMessagePacket("tx", raw)); err != nil {
+		p.unlock(tx)
+		return fmt.Errorf("send to %s: %w", firstHop, err)
+	}
+	id, err := p.db.RecordRelayProbe(database.RelayProbe{
+		TxHash:     tx.TxID[:],
+		Variant:    v.Name,
+		FeeRate:    v.FeeRate,
+		VSize:      tx.VSize(),
+		OutputType: v.OutputType,
+		DataBytes:  v.DataBytes,
+		FirstHop:   firstHop,
+		SentAt:     sentAt,
+	})
+	if err != nil {
+		return err
+	}
+	metrics.RelayProbes.WithLabelValues(v.Name, "sent").Inc()
+	logger.Log.Info().
+		Str("variant", v.Name).
+		Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(tx.TxID[:]))).
+		Str("first_hop", firstHop).
+		Msg("Relay probe sent")
+
+	time.AfterFunc(time.Duration(p.cfg.MeasureSeconds)*time.Second, func() {
+		p.measure(id, v, tx)
+	})
+	return nil
+}
+
+// build has the wallet fund and sign a transaction paying AmountSats to a
+// new address of the variant's type, its inputs locked so the next probe
+// doesn't spend them again before this one is seen
+func (p *prober) build(ctx context.Context, v ProbeVariant) ([]byte, error) {
+	var addr string
+	if err := p.wallet.call(ctx, "getnewaddress", []interface{}{"", v.OutputType}, &addr); err != nil {
+		return nil, err
+	}
+	outputs := []interface{}{map[string]interface{}{addr: satsToBTC(p.cfg.AmountSats)}}
+	if v.DataBytes > 0 {
+		outputs = append(outputs, map[string]interface{}{"data": strings.Repeat("00", v.DataBytes)})
+	}
+	var unfunded string
+	if err := p.wallet.call(ctx, "createrawtransaction", []interface{}{[]interface{}{}, outputs}, &unfunded); err != nil {
+		return nil, err
+	}
+	var funded struct {
+		Hex string `json:"hex"`
+	}
+	opts := map[string]interface{}{"fee_rate": v.FeeRate, "lockUnspents": true}
+	if err := p.wallet.call(ctx, "fundrawtransaction", []interface{}{unfunded, opts}, &funded); err != nil {
+		return nil, err
+	}
+	var signed struct {
+		Hex      string `json:"hex"`
+		Complete bool   `json:"complete"`
+	}
+	if err := p.wallet.call(ctx, "signrawtransactionwithwallet", []interface{}{funded.Hex}, &signed); err != nil {
+		return nil, err
+	}
+	if !signed.Complete {
+		return nil, fmt.Errorf("signrawtransactionwithwallet: incomplete signature")
+	}
+	return hex.DecodeString(signed.Hex)
+}
+
+// measure records how far a probe got. A probe no other peer relayed will
+// likely never confirm, so its inputs are released.
+func (p *prober) measure(id int64, v ProbeVariant, tx *protocol.Transaction) {
+	r, err := p.db.MeasureRelayProbe(id)
+	if err != nil {
+		logger.Log.Warn().Err(err).Str("variant", v.Name).Msg("Relay probe measurement failed")
+		return
+	}
+	metrics.RelayProbePeers.WithLabelValues(v.Name).Observe(float64(r.RelayPeers))
+	if r.FirstRelayMs.Valid {
+		metrics.RelayProbeFirstRelay.WithLabelValues(v.Name).Observe(float64(r.FirstRelayMs.Int64) / 1000)
+	}
+	logger.Log.Info().
+		Str("variant", v.Name).
+		Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(tx.TxID[:]))).
+		Int("relay_peers", r.RelayPeers).
+		Int64("first_relay_ms", r.FirstRelayMs.Int64).
+		Msg("Relay probe measured")
+	if r.RelayPeers == 0 {
+		p.unlock(tx)
+	}
+}
+
+// unlock releases a probe's inputs for the wallet to spend again
+func (p *prober) unlock(tx *protocol.Transaction) {
+	outpoints := make([]interface{}, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		outpoints[i] = map[string]interface{}{
+			"txid": fmt.Sprintf("%x", protocol.ReverseBytes(in.PrevTxHash[:])),
+			"vout": in.PrevIndex,
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.wallet.call(ctx, "lockunspent", []interface{}{true, outpoints}, nil); err != nil {
+		logger.Log.Warn().Err(err).Msg("Failed to unlock relay probe inputs")
+	}
+}
+
+// probeFirstHop picks a random connected peer past its handshake
+func probeFirstHop() (net.Conn, string) {
+	activeConns.Lock()
+	defer activeConns.Unlock()
+	var conns []net.Conn
+	for conn, stats := range activeConns.conns {
+		if stats.messages.Load() > 0 {
+			conns = append(conns, conn)
+		}
+	}
+	if len(conns) == 0 {
+		return nil, ""
+	}
+	conn := conns[rand.IntN(len(conns))]
+	return conn, conn.RemoteAddr().String()
+}
+
+// satsToBTC formats an amount for RPC without float rounding
+func satsToBTC(sats int64) json.Number {
+	return json.Number(fmt.Sprintf("%d.%08d", sats/1e8, sats%1e8))
+}
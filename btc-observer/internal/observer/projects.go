@@ -0,0 +1,183 @@
+package observer
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync/atomic"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/rs/zerolog"
+)
+
+// ProjectConfig is a named study recorded next to the observer's other
+// data. A transaction joins the project when it pays to or spends from a
+// watched address, or matches one of its script templates; MinFeeRate
+// additionally requires that fee rate. A project with only MinFeeRate takes
+// every transaction paying at least that.
+type ProjectConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Addresses is the project's watchlist
+	Addresses []string `json:"addresses,omitempty"`
+
+	// ScriptTemplates are names from the script template registry
+	ScriptTemplates []string `json:"script_templates,omitempty"`
+
+	// MinFeeRate in sat/vB; fees are only known for transactions whose
+	// inputs are all stored
+	MinFeeRate float64 `json:"min_fee_rate,omitempty"`
+
+	// Peers limits the project's propagation data to announcements from
+	// these peers. It doesn't change which peers are connected; peer-set
+	// experiments do that.
+	Peers ProjectPeers `json:"peers,omitempty"`
+}
+
+// ProjectPeers selects peers by country, region label or AS; empty lists
+// allow any peer
+type ProjectPeers struct {
+	Countries []string `json:"countries,omitempty"`
+	Regions   []string `json:"regions,omitempty"`
+	ASNs      []string `json:"asns,omitempty"`
+}
+
+var projectName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+
+type project struct {
+	id        int
+	name      string
+	addresses map[string]bool
+	templates []string
+	minFee    float64
+}
+
+// projects are the registered projects; nil when none are configured
+var projects atomic.Pointer[[]*project]
+
+// SetProjects validates the projects, registers them in the database and
+// starts tagging ingested transactions for them. templates are the names in
+// the script template registry.
+func SetProjects(cfgs []ProjectConfig, templates []string, db database.Storage) error {
+	var ps []*project
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if !projectName.MatchString(cfg.Name) || names[cfg.Name] {
+			return fmt.Errorf("project names must be unique lowercase letters, digits, - and _ (got %q)", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if len(cfg.Addresses) == 0 && len(cfg.ScriptTemplates) == 0 && cfg.MinFeeRate <= 0 {
+			return fmt.Errorf("project %q: needs addresses, script_templates or min_fee_rate", cfg.Name)
+		}
+		for _, t := range cfg.ScriptTemplates {
+			if !slices.Contains(templates, t) {
+				return fmt.Errorf("project %q: unknown script template %q", cfg.Name, t)
+			}
+		}
+		var asns []string
+		for _, a := range cfg.Peers.ASNs {
+			n := normalizeASN(a)
+			if n == "" {
+				return fmt.Errorf("project %q: invalid ASN %q", cfg.Name, a)
+			}
+			asns = append(asns, n)
+		}
+
+		id, err := db.RegisterProject(database.Project{
+			Name:          cfg.Name,
+			Description:   cfg.Description,
+			Config:        cfg,
+			PeerCountries: cfg.Peers.Countries,
+			PeerRegions:   cfg.Peers.Regions,
+			PeerASNs:      asns,
+		})
+		if err != nil {
+			return fmt.Errorf("project %q: %w", cfg.Name, err)
+		}
+		p := &project{id: id, name: cfg.Name, templates: cfg.ScriptTemplates, minFee: cfg.MinFeeRate}
+		if len(cfg.Addresses) > 0 {
+			p.addresses = make(map[string]bool, len(cfg.Addresses))
+			for _, a := range cfg.Addresses {
+				p.addresses[a] = true
+			}
+		}
+		ps = append(ps, p)
+		logger.Log.Info().Str("project", cfg.Name).Int("id", id).Msg("Project registered")
+	}
+	projects.Store(&ps)
+	return nil
+}
+
+// tagProjects adds tx to every project it matches. fee is nil when unknown
+// and templates are the script templates it matched.
+func tagProjects(tx *protocol.Transaction, fee *database.Fee, templates []string, plog zerolog.Logger, db database.Storage) {
+	ps := projects.Load()
+	if ps == nil {
+		return
+	}
+	// Addresses the tx pays to or spends from, looked up once if needed
+	var addrs []string
+	addrsLoaded := false
+	for _, p := range *ps {
+		var watched []string
+		if p.minFee > 0 && (fee == nil || fee.Rate() < p.minFee) {
+			continue
+		}
+		reason := ""
+		switch {
+		case p.addresses == nil && len(p.templates) == 0:
+			reason = database.ProjectMatchFeeRate
+		case slices.ContainsFunc(templates, func(t string) bool { return slices.Contains(p.templates, t) }):
+			reason = database.ProjectMatchTemplate
+		case p.addresses != nil:
+			if !addrsLoaded {
+				addrs = txAddresses(tx, plog, db)
+				addrsLoaded = true
+			}
+			for _, a := range addrs {
+				if p.addresses[a] && !slices.Contains(watched, a) {
+					watched = append(watched, a)
+				}
+			}
+			if len(watched) > 0 {
+				reason = database.ProjectMatchAddress
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		added, err := db.TagProjectTransaction(p.id, tx.TxID[:], reason)
+		if err != nil {
+			logger.Error(plog, err, "DB TagProjectTransaction error")
+			continue
+		}
+		if !added {
+			continue
+		}
+		metrics.ProjectTransactions.WithLabelValues(p.name, reason).Inc()
+		if len(watched) > 0 {
+			events.Publish(events.AddressActivity, events.WatchedAddress{Project: p.name, TxID: tx.TxID, Addresses: watched})
+		}
+	}
+}
+
+// txAddresses returns the addresses tx pays to, then those its stored inputs
+// spend from
+func txAddresses(tx *protocol.Transaction, plog zerolog.Logger, db database.Storage) []string {
+	var addrs []string
+	for _, out := range tx.Outputs {
+		if a := protocol.ExtractAddress(out.ScriptPubKey); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	inputs, err := db.TxInputAddresses(tx.TxID[:])
+	if err != nil {
+		logger.Error(plog, err, "DB TxInputAddresses error")
+	}
+	return append(addrs, inputs...)
+}
@@ -0,0 +1,125 @@
+package observer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// RegionOverrides reassigns peers to custom region labels on top of GeoIP,
+// e.g. to treat a corporate AS as its own region. An exact IP wins over the
+// longest matching prefix, which wins over the ASN.
+type RegionOverrides struct {
+	IPs      map[string]string `json:"ips"`
+	Prefixes map[string]string `json:"prefixes"`
+	ASNs     map[string]string `json:"asns"`
+
+	ips      map[netip.Addr]string
+	prefixes []prefixRegion
+	asns     map[string]string
+}
+
+type prefixRegion struct {
+	prefix netip.Prefix
+	region string
+}
+
+// LoadRegionOverrides reads and validates a region mapping file
+func LoadRegionOverrides(path string) (*RegionOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading region overrides: %w", err)
+	}
+	var o RegionOverrides
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("parsing region overrides: %w", err)
+	}
+
+	o.ips = make(map[netip.Addr]string, len(o.IPs))
+	for s, region := range o.IPs {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("region override ip %q: %w", s, err)
+		}
+		o.ips[ip.Unmap()] = region
+	}
+	for s, region := range o.Prefixes {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("region override prefix %q: %w", s, err)
+		}
+		o.prefixes = append(o.prefixes, prefixRegion{p.Masked(), region})
+	}
+	sort.Slice(o.prefixes, func(i, j int) bool { return o.prefixes[i].prefix.Bits() > o.prefixes[j].prefix.Bits() })
+	o.asns = make(map[string]string, len(o.ASNs))
+	for s, region := range o.ASNs {
+		asn := normalizeASN(s)
+		if asn == "" {
+			return nil, fmt.Errorf("region override asn %q: expected AS<number>", s)
+		}
+		o.asns[asn] = region
+	}
+	return &o, nil
+}
+
+// Lookup returns the override region for a peer host and GeoIP AS string
+func (o *RegionOverrides) Lookup(host, asn string) (string, bool) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if region, ok := o.ips[ip]; ok {
+			return region, true
+		}
+		for _, p := range o.prefixes {
+			if p.prefix.Contains(ip) {
+				return p.region, true
+			}
+		}
+	}
+	if a := normalizeASN(asn); a != "" {
+		if region, ok := o.asns[a]; ok {
+			return region, true
+		}
+	}
+	return "", false
+}
+
+// normalizeASN reduces "AS16509 Amazon.com, Inc.", "as16509" or "16509" to
+// "AS16509", or "" if there is no AS number
+func normalizeASN(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	num := strings.TrimPrefix(strings.ToUpper(fields[0]), "AS")
+	if num == "" {
+		return ""
+	}
+	for _, c := range num {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return "AS" + num
+}
+
+var regionOverrides atomic.Pointer[RegionOverrides]
+
+// SetRegionOverrides applies a region mapping to peers connected from now on
+func SetRegionOverrides(o *RegionOverrides) {
+	regionOverrides.Store(o)
+}
+
+// peerRegion is the region a peer is labelled with in metrics and storage:
+// the override for its IP, prefix or ASN if any, else the GeoIP-derived one
+func peerRegion(node *Node, geoRegion string) string {
+	if o := regionOverrides.Load(); o != nil {
+		if region, ok := o.Lookup(node.Address, node.ASN); ok {
+			return region
+		}
+	}
+	return geoRegion
+}
@@ -0,0 +1,93 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rpcClient calls a Bitcoin Core node's JSON-RPC interface
+type rpcClient struct {
+	url, user, password string
+	client              *http.Client
+}
+
+func newRPCClient(url, user, password string, timeout time.Duration) (*rpcClient, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("rpc url %q must be http or https", url)
+	}
+	return &rpcClient{url: url, user: user, password: password, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// wallet returns a client for one of the node's wallets ("" is the default)
+func (c *rpcClient) wallet(name string) *rpcClient {
+	if name == "" {
+		return c
+	}
+	w := *c
+	w.url = strings.TrimSuffix(c.url, "/") + "/wallet/" + name
+	return &w
+}
+
+// call runs method and decodes its result into result, with numbers kept
+// as json.Number where result leaves room for them
+func (c *rpcClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      method,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %s: %w", method, resp.Status, err)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, reply.Error.Message, reply.Error.Code)
+	}
+	if len(reply.Result) == 0 || string(reply.Result) == "null" {
+		if result == nil {
+			return nil
+		}
+		return fmt.Errorf("%s: %s: empty result", method, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(reply.Result))
+	dec.UseNumber()
+	if err := dec.Decode(result); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package observer
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Kinds of peer misbehavior that add to its penalty
+const (
+	PenaltyDial         = "dial"          // connection failed
+	PenaltyHandshake    = "handshake"     // version handshake failed
+	PenaltyShortSession = "short_session" // disconnected within a minute of connecting
+	PenaltyStale        = "stale"         // announced a block long after other peers
+	PenaltyCorrupt      = "corrupt"       // sent a message with bad framing or checksum
+	PenaltySlowPing     = "slow_ping"     // ping round trip over the slow threshold
+)
+
+// defaultPenaltyWeights are what each kind adds to a peer's penalty. Five
+// corrupt messages or four short sessions in quick succession reach the
+// default ban score.
+var defaultPenaltyWeights = map[string]float64{
+	PenaltyDial:         2,
+	PenaltyHandshake:    10,
+	PenaltyShortSession: 25,
+	PenaltyStale:        2,
+	PenaltyCorrupt:      20,
+	PenaltySlowPing:     1,
+}
+
+// PeerScoreConfig weights peer misbehavior into a penalty that decays over
+// time. Candidates are dialed lowest penalty first, and not at all while
+// their penalty is at or above BanScore.
+type PeerScoreConfig struct {
+	BanScore      float64            `json:"ban_score"`       // default 100
+	HalfLifeHours float64            `json:"half_life_hours"` // penalties halve over this (default 24)
+	Weights       map[string]float64 `json:"weights"`         // by kind, overriding the defaults
+	StaleSeconds  int                `json:"stale_seconds"`   // block announcements this far behind the first are stale (default 60)
+	SlowPingMs    int                `json:"slow_ping_ms"`    // ping round trips above this are slow (default 1000)
+	FlushSeconds  int                `json:"flush_seconds"`   // between saving changed scores (default 60)
+}
+
+func (c *PeerScoreConfig) applyDefaults() {
+	if c.BanScore <= 0 {
+		c.BanScore = 100
+	}
+	if c.HalfLifeHours <= 0 {
+		c.HalfLifeHours = 24
+	}
+	weights := make(map[string]float64, len(defaultPenaltyWeights))
+	for kind, w := range defaultPenaltyWeights {
+		weights[kind] = w
+	}
+	for kind, w := range c.Weights {
+		weights[kind] = w
+	}
+	c.Weights = weights
+	if c.StaleSeconds <= 0 {
+		c.StaleSeconds = 60
+	}
+	if c.SlowPingMs <= 0 {
+		c.SlowPingMs = 1000
+	}
+	if c.FlushSeconds <= 0 {
+		c.FlushSeconds = 60
+	}
+}
+
+// peerScore is one peer's decaying penalty and what made it up
+type peerScore struct {
+	penalty   float64 // as of updated
+	updated   time.Time
+	counts    map[string]int
+	banReason string    // set while banned
+	scoreBan  bool      // banned for reaching the ban score, lifted as it decays
+	failed    time.Time // last connection failure, for the failure backoff
+	dirty     bool
+}
+
+// current returns the penalty decayed to now
+func (s *peerScore) current(cfg *PeerScoreConfig, now time.Time) float64 {
+	halfLives := now.Sub(s.updated).Hours() / cfg.HalfLifeHours
+	return s.penalty * math.Pow(0.5, halfLives)
+}
+
+// peerScores holds every scored peer's penalty by address
+var peerScores = struct {
+	sync.Mutex
+	peers map[string]*peerScore
+}{peers: make(map[string]*peerScore)}
+
+var scoreConfig atomic.Pointer[PeerScoreConfig]
+
+func currentScoreConfig() *PeerScoreConfig {
+	if cfg := scoreConfig.Load(); cfg != nil {
+		return cfg
+	}
+	cfg := &PeerScoreConfig{}
+	cfg.applyDefaults()
+	scoreConfig.CompareAndSwap(nil, cfg)
+	return scoreConfig.Load()
+}
+
+// scoreFor returns a peer's score, creating it; the caller holds the lock
+func scoreFor(addr string) *peerScore {
+	s := peerScores.peers[addr]
+	if s == nil {
+		s = &peerScore{updated: time.Now(), counts: make(map[string]int)}
+		peerScores.peers[addr] = s
+	}
+	return s
+}
+
+// penalize adds a kind's weight to a peer's penalty, banning it when the
+// penalty reaches the ban score
+func penalize(addr, kind string) {
+	cfg := currentScoreConfig()
+	now := time.Now()
+	peerScores.Lock()
+	s := scoreFor(addr)
+	s.penalty = s.current(cfg, now) + cfg.Weights[kind]
+	s.updated = now
+	s.counts[kind]++
+	s.dirty = true
+	banned := s.banReason == "" && s.penalty >= cfg.BanScore
+	if banned {
+		s.banReason = "penalty score (" + kind + ")"
+		s.scoreBan = true
+	}
+	peerScores.Unlock()
+
+	metrics.PeerPenalties.WithLabelValues(kind).Inc()
+	if banned {
+		logger.Log.Warn().Str("peer", addr).Str("last", kind).Msg("Blacklisted peer (penalty score)")
+		events.Publish(events.PeerBanned, events.PeerBan{Peer: addr, Reason: "penalty score"})
+	}
+}
+
+// failPeer penalizes a failed connection and starts the peer's failure
+// backoff
+func failPeer(addr, kind string) {
+	peerScores.Lock()
+	scoreFor(addr).failed = time.Now()
+	peerScores.Unlock()
+	penalize(addr, kind)
+}
+
+// banPeer bans a peer outright, whatever its penalty
+func banPeer(addr, reason string) {
+	peerScores.Lock()
+	defer peerScores.Unlock()
+	s := scoreFor(addr)
+	s.banReason = reason
+	s.scoreBan = false
+	s.dirty = true
+}
+
+// peerBanned reports whether a peer is banned. A ban from the penalty score
+// lifts once the penalty decays below it; an explicit ban doesn't.
+func peerBanned(addr string, cfg *PeerScoreConfig, now time.Time) bool {
+	s := peerScores.peers[addr]
+	if s == nil || s.banReason == "" {
+		return false
+	}
+	if s.scoreBan && s.current(cfg, now) < cfg.BanScore {
+		s.banReason = ""
+		s.scoreBan = false
+		s.dirty = true
+		return false
+	}
+	return true
+}
+
+// peerBackingOff reports whether a peer failed within the failure backoff
+func peerBackingOff(addr string, now time.Time) bool {
+	s := peerScores.peers[addr]
+	return s != nil && now.Sub(s.failed) < failBackoff
+}
+
+// peerPenalty returns a peer's current penalty, 0 for unscored peers
+func peerPenalty(addr string, cfg *PeerScoreConfig, now time.Time) float64 {
+	if s := peerScores.peers[addr]; s != nil {
+		return s.current(cfg, now)
+	}
+	return 0
+}
+
+// noteStaleBlock penalizes a peer announcing a block StaleSeconds or more
+// after it first arrived
+func noteStaleBlock(addr string, delay time.Duration) {
+	if delay >= time.Duration(currentScoreConfig().StaleSeconds)*time.Second {
+		penalize(addr, PenaltyStale)
+	}
+}
+
+// noteSlowPing penalizes a ping round trip over the slow threshold
+func noteSlowPing(addr string, latencyMs int) {
+	if latencyMs > currentScoreConfig().SlowPingMs {
+		penalize(addr, PenaltySlowPing)
+	}
+}
+
+// PeerScore is a peer's current penalty, for the admin API
+type PeerScore struct {
+	Peer      string         `json:"peer"`
+	Penalty   float64        `json:"penalty"`
+	Counts    map[string]int `json:"counts"`
+	BanReason string         `json:"ban_reason,omitempty"`
+}
+
+// PeerScores returns every scored peer, highest penalty first
+func PeerScores() []PeerScore {
+	cfg := currentScoreConfig()
+	now := time.Now()
+	peerScores.Lock()
+	scores := make([]PeerScore, 0, len(peerScores.peers))
+	for addr, s := range peerScores.peers {
+		counts := make(map[string]int, len(s.counts))
+		for kind, n := range s.counts {
+			counts[kind] = n
+		}
+		peerBanned(addr, cfg, now)
+		scores = append(scores, PeerScore{Peer: addr, Penalty: math.Round(s.current(cfg, now)*10) / 10, Counts: counts, BanReason: s.banReason})
+	}
+	peerScores.Unlock()
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Penalty > scores[j].Penalty })
+	return scores
+}
+
+// StartPeerScores applies the scoring config and saves changed scores
+// every FlushSeconds, and a last time when ctx is done before marking wg
+// done
+func StartPeerScores(ctx context.Context, cfg PeerScoreConfig, db database.Storage, wg *sync.WaitGroup) {
+	cfg.applyDefaults()
+	scoreConfig.Store(&cfg)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Duration(cfg.FlushSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				flushPeerScores(&cfg, db)
+				return
+			case <-ticker.C:
+				flushPeerScores(&cfg, db)
+			}
+		}
+	}()
+}
+
+// flushPeerScores saves the scores that changed since the last flush and
+// forgets unbanned peers whose penalty has decayed to nothing
+func flushPeerScores(cfg *PeerScoreConfig, db database.Storage) {
+	now := time.Now()
+	peerScores.Lock()
+	var changed []database.PeerScore
+	for addr, s := range peerScores.peers {
+		penalty := s.current(cfg, now)
+		if !s.dirty {
+			if s.banReason == "" && penalty < 0.1 && now.Sub(s.failed) >= failBackoff {
+				delete(peerScores.peers, addr)
+			}
+			continue
+		}
+		s.dirty = false
+		counts := make(map[string]int, len(s.counts))
+		for kind, n := range s.counts {
+			counts[kind] = n
+		}
+		changed = append(changed, database.PeerScore{
+			PeerAddr:  addr,
+			Penalty:   penalty,
+			Counts:    counts,
+			BanReason: s.banReason,
+			ScoreBan:  s.scoreBan,
+			FailedAt:  s.failed,
+		})
+	}
+	peerScores.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	if err := db.SavePeerScores(changed); err != nil {
+		logger.Log.Error().Err(err).Int("peers", len(changed)).Msg("Failed to save peer scores")
+	}
+}
+
+// loadPeerScores restores the saved scores, bans and failure backoffs, so a
+// restart doesn't redial peers known to be bad. Saved penalties keep
+// decaying from when they were saved.
+func loadPeerScores(db database.Storage) error {
+	stored, err := db.PeerScores()
+	if err != nil {
+		return err
+	}
+	peerScores.Lock()
+	defer peerScores.Unlock()
+	for _, p := range stored {
+		counts := p.Counts
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		peerScores.peers[p.PeerAddr] = &peerScore{
+			penalty:   p.Penalty,
+			updated:   p.UpdatedAt,
+			counts:    counts,
+			banReason: p.BanReason,
+			scoreBan:  p.ScoreBan,
+			failed:    p.FailedAt,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package observer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 (RFC 1928) constants used for CONNECT without authentication
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksConnect      = 1
+	socksAddrIPv4     = 1
+	socksAddrDomain   = 3
+	socksAddrIPv6     = 4
+	socksReplySuccess = 0
+)
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// dialSOCKS5 connects to addr through a SOCKS5 proxy. The host is passed to
+// the proxy unresolved, which is how Tor reaches .onion names.
+func dialSOCKS5(proxy, addr string, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("host name too long for SOCKS5")
+	}
+
+	conn, err := net.DialTimeout("tcp", proxy, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := socksConnectTo(conn, host, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socksConnectTo(conn net.Conn, host string, port int) error {
+	if _, err := conn.Write([]byte{socksVersion, 1, socksNoAuth}); err != nil {
+		return fmt.Errorf("socks greeting: %w", err)
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return fmt.Errorf("socks greeting reply: %w", err)
+	}
+	if choice[0] != socksVersion || choice[1] != socksNoAuth {
+		return errors.New("socks proxy requires authentication")
+	}
+
+	req := []byte{socksVersion, socksConnect, 0, socksAddrDomain, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks connect: %w", err)
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("socks connect reply: %w", err)
+	}
+	if reply[1] != socksReplySuccess {
+		if msg, ok := socksReplies[reply[1]]; ok {
+			return fmt.Errorf("socks connect: %s", msg)
+		}
+		return fmt.Errorf("socks connect: reply %d", reply[1])
+	}
+
+	// Skip the bound address and port
+	var skip int
+	switch reply[3] {
+	case socksAddrIPv4:
+		skip = 4
+	case socksAddrIPv6:
+		skip = 16
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return fmt.Errorf("socks bound address: %w", err)
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("socks bound address type %d", reply[3])
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+		return fmt.Errorf("socks bound address: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package observer
+
+import (
+	"sort"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/keato/btc-observer/internal/scripts"
+	"github.com/rs/zerolog"
+)
+
+// recordSpendTypes classifies tx's inputs from their scriptSigs and
+// witnesses, so spend types are counted even when the prevouts are unknown
+func recordSpendTypes(tx *protocol.Transaction, plog zerolog.Logger, db database.Storage) {
+	spends := scripts.ClassifySpends(tx)
+	counts := make([]database.SpendTypeCount, 0, len(spends))
+	for s, n := range spends {
+		counts = append(counts, database.SpendTypeCount{SpendType: s.Type, Multisig: s.Multisig(), Inputs: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].SpendType != counts[j].SpendType {
+			return counts[i].SpendType < counts[j].SpendType
+		}
+		return counts[i].Multisig < counts[j].Multisig
+	})
+
+	added, err := db.RecordSpendTypes(tx.TxID[:], counts)
+	if err != nil {
+		logger.Error(plog, err, "DB RecordSpendTypes error")
+		return
+	}
+	if !added {
+		return
+	}
+	for _, c := range counts {
+		metrics.InputSpendTypes.WithLabelValues(c.SpendType).Add(float64(c.Inputs))
+		if c.Multisig != "" {
+			metrics.InputMultisig.WithLabelValues(c.SpendType, c.Multisig).Add(float64(c.Inputs))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package observer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+const (
+	// StaticRegion labels static peers in metrics and peer status
+	StaticRegion       = "static"
+	staticRetryBackoff = 10 * time.Second
+)
+
+// StartStaticPeers keeps a connection open to each address regardless of
+// discovery, reconnecting after failures. Used for trusted nodes and for
+// pointing the observer at a simulated network.
+func StartStaticPeers(ctx context.Context, addrs []string, pm *PeerManager, db database.Storage, wg *sync.WaitGroup) error {
+	nodes := make([]*Node, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil && net.ParseIP(addr) != nil {
+			// A bare IP uses the network's default port
+			addr = net.JoinHostPort(addr, strconv.Itoa(protocol.ActiveNetwork().DefaultPort))
+		}
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("static peer %q: %w", addr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("static peer %q: invalid port", addr)
+		}
+		nodes = append(nodes, &Node{Address: host, Port: port, CountryCode: StaticRegion})
+	}
+
+	// Each peer's reconnect loop is counted in wg as a whole, added before
+	// it starts so shutdown can't begin waiting before the count is taken
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				ObserveNode(ctx, node, StaticRegion, pm, db, nil)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(staticRetryBackoff):
+				}
+			}
+		}(node)
+	}
+	logger.Log.Info().Int("count", len(nodes)).Msg("Static peers configured")
+	return nil
+}
@@ -0,0 +1,66 @@
+package observer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+)
+
+// PeerStatus is a connected peer's live statistics
+type PeerStatus struct {
+	Addr        string    `json:"addr"`
+	Region      string    `json:"region"`
+	Country     string    `json:"country"`
+	City        string    `json:"city,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Messages    int64     `json:"messages"`
+	InvItems    int64     `json:"inv_items"`
+	// LatencyMs is the last ping round trip, 0 before the first pong
+	LatencyMs       int64      `json:"latency_ms"`
+	LastBlockHeight int32      `json:"last_block_height,omitempty"`
+	LastBlockAt     *time.Time `json:"last_block_at,omitempty"`
+}
+
+// ActivePeers returns the statistics of every open peer connection, by address
+func ActivePeers() []PeerStatus {
+	activeConns.Lock()
+	peers := make([]PeerStatus, 0, len(activeConns.conns))
+	for _, stats := range activeConns.conns {
+		p := PeerStatus{
+			Addr:            stats.node.Addr(),
+			Region:          peerRegion(stats.node, stats.country),
+			Country:         stats.node.CountryCode,
+			City:            stats.node.City,
+			ConnectedAt:     stats.since,
+			Messages:        stats.messages.Load(),
+			InvItems:        stats.invItems.Load(),
+			LatencyMs:       stats.latencyMs.Load(),
+			LastBlockHeight: stats.blockHeight.Load(),
+		}
+		if at := stats.blockAt.Load(); at != 0 {
+			t := time.Unix(0, at)
+			p.LastBlockAt = &t
+		}
+		peers = append(peers, p)
+	}
+	activeConns.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+	return peers
+}
+
+// Queues returns the depth of the event bus subscribers' backlogs and of
+// the address crawler's queue
+func Queues() []events.Queue {
+	queues := events.Queues()
+	if c := activeCrawler.Load(); c != nil {
+		queues = append(queues, events.Queue{Name: "crawl", Depth: len(c.queue), Capacity: cap(c.queue)})
+	}
+	return queues
+}
+
+// DBWriteLatency is the moving average of database write latency that
+// drives backpressure
+func DBWriteLatency() time.Duration {
+	return currentWriteLatency()
+}
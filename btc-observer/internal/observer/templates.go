@@ -0,0 +1,51 @@
+package observer
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/keato/btc-observer/internal/scripts"
+	"github.com/rs/zerolog"
+)
+
+// scriptTemplates tags ingested txs whose scripts match a known template
+var scriptTemplates *scripts.Registry
+
+// SetScriptTemplates enables script template matching at ingest
+func SetScriptTemplates(r *scripts.Registry) {
+	scriptTemplates = r
+}
+
+// tagScriptTemplates records tx's template matches and returns the names of
+// the templates it matched
+func tagScriptTemplates(tx *protocol.Transaction, plog zerolog.Logger, db database.Storage) []string {
+	if scriptTemplates == nil {
+		return nil
+	}
+	var names []string
+	for _, m := range scriptTemplates.MatchTx(tx) {
+		if !slices.Contains(names, m.Template) {
+			names = append(names, m.Template)
+		}
+		added, err := db.RecordScriptTemplateMatch(tx.TxID[:], m.Template, m.Location, m.Index)
+		if err != nil {
+			logger.Error(plog, err, "DB RecordScriptTemplateMatch error")
+			continue
+		}
+		if !added {
+			continue
+		}
+		metrics.ScriptTemplateMatches.WithLabelValues(m.Template, m.Location).Inc()
+		plog.Debug().
+			Str("tx", fmt.Sprintf("%x", protocol.ReverseBytes(tx.TxID[:]))).
+			Str("template", m.Template).
+			Str("location", m.Location).
+			Int("index", m.Index).
+			Msg("Script template matched")
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+package observer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const (
+	// throughputWindow is how far back the per-region tx rate looks
+	throughputWindow = 60
+	// throughputInterval is how often the rate gauges are updated
+	throughputInterval = 5 * time.Second
+)
+
+// txWindow counts tx announcements in one-second slots over the last
+// throughputWindow seconds
+type txWindow struct {
+	counts [throughputWindow]int64
+	secs   [throughputWindow]int64 // unix second each slot was last counted for
+}
+
+func (w *txWindow) add(sec int64, n int) {
+	i := sec % throughputWindow
+	if w.secs[i] != sec {
+		w.secs[i] = sec
+		w.counts[i] = 0
+	}
+	w.counts[i] += int64(n)
+}
+
+// rate is the per-second average over the window ending at sec
+func (w *txWindow) rate(sec int64) float64 {
+	var total int64
+	for i := range w.counts {
+		if sec-w.secs[i] < throughputWindow {
+			total += w.counts[i]
+		}
+	}
+	return float64(total) / throughputWindow
+}
+
+// regionThroughput keeps a sliding window of tx announcements per region.
+// Regions stay once seen, so one that goes quiet drops to zero instead of
+// disappearing from the gauges.
+var regionThroughput = struct {
+	sync.Mutex
+	windows map[string]*txWindow
+}{windows: make(map[string]*txWindow)}
+
+// noteRegionTxs counts n tx announcements from a peer in region
+func noteRegionTxs(region string, n int) {
+	if n <= 0 {
+		return
+	}
+	sec := time.Now().Unix()
+	regionThroughput.Lock()
+	defer regionThroughput.Unlock()
+	w := regionThroughput.windows[region]
+	if w == nil {
+		w = new(txWindow)
+		regionThroughput.windows[region] = w
+	}
+	w.add(sec, n)
+}
+
+// StartThroughputGauges keeps btc_region_tx_per_second up to date
+func StartThroughputGauges(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(throughputInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				updateThroughputGauges()
+			}
+		}
+	}()
+}
+
+func updateThroughputGauges() {
+	sec := time.Now().Unix()
+	regionThroughput.Lock()
+	defer regionThroughput.Unlock()
+	for region, w := range regionThroughput.windows {
+		metrics.RegionTxRate.WithLabelValues(region).Set(w.rate(sec))
+	}
+}
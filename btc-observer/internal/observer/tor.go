@@ -0,0 +1,131 @@
+package observer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// TorRegion labels onion peers in metrics and peer status
+const TorRegion = "tor"
+
+const (
+	torRefreshInterval = 30 * time.Minute
+	torConnectInterval = 5 * time.Second
+	// torRetryRefresh is how soon an empty candidate list is refreshed again
+	torRetryRefresh = 2 * time.Minute
+	// torGossipCandidates caps the onion addresses taken from addr gossip
+	torGossipCandidates = 500
+)
+
+// TorConfig holds the SOCKS5 proxy used to reach .onion peers
+type TorConfig struct {
+	Proxy string `json:"proxy"`
+	Peers int    `json:"peers"`
+}
+
+func (c *TorConfig) applyDefaults() {
+	if c.Proxy == "" {
+		c.Proxy = "127.0.0.1:9050"
+	}
+	if c.Peers <= 0 {
+		c.Peers = 8
+	}
+}
+
+// torProxy is the SOCKS5 proxy address; nil when Tor is disabled
+var torProxy atomic.Pointer[string]
+
+// bitnodesOnions holds the onion nodes from the last bitnodes snapshot
+var bitnodesOnions atomic.Pointer[[]*Node]
+
+// StartTorPeers keeps cfg.Peers onion peers connected, picked from bitnodes
+// and from torv3 addresses gossiped by other peers. It also lets static
+// peers be .onion addresses.
+func StartTorPeers(ctx context.Context, cfg TorConfig, pm *PeerManager, db database.Storage, wg *sync.WaitGroup) {
+	cfg.applyDefaults()
+	torProxy.Store(&cfg.Proxy)
+	logger.Log.Info().Str("proxy", cfg.Proxy).Int("peers", cfg.Peers).Msg("Tor peers enabled")
+
+	// The loop holds a count in wg while it runs, so the counts it adds for
+	// connections can't race shutdown's wait
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		refresh := time.NewTicker(torRefreshInterval)
+		defer refresh.Stop()
+		connect := time.NewTicker(torConnectInterval)
+		defer connect.Stop()
+
+		refreshTorPeers(pm, db)
+		lastRefresh := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-refresh.C:
+				refreshTorPeers(pm, db)
+				lastRefresh = time.Now()
+			case <-connect.C:
+				if CurrentPressure() >= PressureShedPeers {
+					continue
+				}
+				if pm.ActiveCountByCountry(TorRegion) >= cfg.Peers {
+					continue
+				}
+				node, ok := pm.GetNextPeer(TorRegion, func(*Node) bool { return true })
+				if !ok {
+					// Bitnodes may not have been fetched at the first refresh
+					if time.Since(lastRefresh) > torRetryRefresh {
+						refreshTorPeers(pm, db)
+						lastRefresh = time.Now()
+					}
+					continue
+				}
+				wg.Add(1)
+				go ObserveNode(ctx, node, TorRegion, pm, db, wg)
+			}
+		}
+	}()
+}
+
+// refreshTorPeers replaces the onion candidates, bitnodes first
+func refreshTorPeers(pm *PeerManager, db database.Storage) {
+	seen := make(map[string]bool)
+	var nodes []*Node
+	if onions := bitnodesOnions.Load(); onions != nil {
+		for _, node := range *onions {
+			if !seen[node.Addr()] {
+				seen[node.Addr()] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	gossiped, err := db.GossipedAddresses(protocol.NetTorV3, torGossipCandidates)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("DB GossipedAddresses error")
+	}
+	for _, addr := range gossiped {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		nodes = append(nodes, &Node{Address: host, Port: port, CountryCode: TorRegion})
+	}
+
+	pm.SetAvailable(TorRegion, nodes)
+	logger.Log.Info().Int("count", len(nodes)).Msg("Tor peer candidates refreshed")
+}
@@ -0,0 +1,242 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TrafficConfig saves each peer's traffic by message type to the database
+type TrafficConfig struct {
+	FlushSeconds int `json:"flush_seconds"` // between saves (default 60)
+}
+
+func (c *TrafficConfig) applyDefaults() {
+	if c.FlushSeconds <= 0 {
+		c.FlushSeconds = 60
+	}
+}
+
+// trafficCommands are the message types traffic is counted under; anything
+// else a peer sends is counted as other, so peers can't grow the label set
+var trafficCommands = map[string]bool{
+	"version": true, "verack": true, "ping": true, "pong": true,
+	"addr": true, "addrv2": true, "getaddr": true, "sendaddrv2": true,
+	"inv": true, "getdata": true, "notfound": true, "tx": true,
+	"block": true, "headers": true, "getheaders": true, "getblocks": true,
+	"sendheaders": true, "sendcmpct": true, "cmpctblock": true,
+	"getblocktxn": true, "blocktxn": true, "feefilter": true,
+	"wtxidrelay": true, "sendtxrcncl": true, "mempool": true, "reject": true,
+}
+
+const trafficOther = "other"
+
+func trafficCommand(command string) string {
+	if trafficCommands[command] {
+		return command
+	}
+	return trafficOther
+}
+
+// commandTraffic is one message type's traffic since the last flush
+type commandTraffic struct {
+	bytesSent, bytesReceived       int64
+	messagesSent, messagesReceived int64
+}
+
+// peerTraffic counts a connection's messages by type. The counts are only
+// kept for the database while traffic recording is enabled; the Prometheus
+// counters are always updated.
+type peerTraffic struct {
+	addr, region string
+	recording    bool
+
+	mu       sync.Mutex
+	commands map[string]*commandTraffic
+	closed   bool
+}
+
+// trafficPeers holds the connections whose traffic is awaiting a flush,
+// including ones closed since the last
+var trafficPeers = struct {
+	sync.Mutex
+	peers map[*peerTraffic]bool
+}{peers: make(map[*peerTraffic]bool)}
+
+var trafficRecording atomic.Bool
+
+func newPeerTraffic(addr, region string) *peerTraffic {
+	t := &peerTraffic{addr: addr, region: region, recording: trafficRecording.Load()}
+	if t.recording {
+		t.commands = make(map[string]*commandTraffic)
+		trafficPeers.Lock()
+		trafficPeers.peers[t] = true
+		trafficPeers.Unlock()
+	}
+	return t
+}
+
+// sent counts an outgoing message
+func (t *peerTraffic) sent(command string, n int) {
+	command = trafficCommand(command)
+	metrics.MessageTrafficBytes.WithLabelValues("sent", command).Add(float64(n))
+	if !t.recording {
+		return
+	}
+	t.mu.Lock()
+	c := t.commandFor(command)
+	c.bytesSent += int64(n)
+	c.messagesSent++
+	t.mu.Unlock()
+}
+
+// received counts an incoming message as framed, header included
+func (t *peerTraffic) received(command string, payload []byte) {
+	command = trafficCommand(command)
+	n := messageHeaderSize + len(payload)
+	metrics.MessageTrafficBytes.WithLabelValues("received", command).Add(float64(n))
+	if !t.recording {
+		return
+	}
+	t.mu.Lock()
+	c := t.commandFor(command)
+	c.bytesReceived += int64(n)
+	c.messagesReceived++
+	t.mu.Unlock()
+}
+
+// commandFor returns a message type's counts, creating them; the caller
+// holds the lock
+func (t *peerTraffic) commandFor(command string) *commandTraffic {
+	c := t.commands[command]
+	if c == nil {
+		c = &commandTraffic{}
+		t.commands[command] = c
+	}
+	return c
+}
+
+// close marks the connection gone, so it is dropped after its next flush
+func (t *peerTraffic) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+}
+
+// take returns the counts since the last call and resets them
+func (t *peerTraffic) take() (counts map[string]*commandTraffic, closed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts = t.commands
+	t.commands = make(map[string]*commandTraffic)
+	return counts, t.closed
+}
+
+// messageHeaderSize is the framing every P2P message carries: magic,
+// command, length and checksum
+const messageHeaderSize = 24
+
+// meteredConn counts a peer connection's bytes on the wire by region. The
+// observer writes one whole message per Write, so sent bytes are also
+// counted by message type; received messages are counted by type as the
+// reader frames them.
+type meteredConn struct {
+	net.Conn
+	traffic *peerTraffic
+	in, out prometheus.Counter
+}
+
+// meterConn wraps the raw connection, below any bandwidth caps, so it counts
+// what actually crosses the wire
+func meterConn(conn net.Conn, traffic *peerTraffic) net.Conn {
+	return &meteredConn{
+		Conn:    conn,
+		traffic: traffic,
+		in:      metrics.PeerTrafficBytes.WithLabelValues(traffic.region, "received"),
+		out:     metrics.PeerTrafficBytes.WithLabelValues(traffic.region, "sent"),
+	}
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.in.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.out.Add(float64(n))
+		command := trafficOther
+		if len(p) >= messageHeaderSize {
+			command = string(bytes.TrimRight(p[4:16], "\x00"))
+		}
+		c.traffic.sent(command, n)
+	}
+	return n, err
+}
+
+// StartTraffic records each peer's traffic by message type every
+// FlushSeconds, and a last time when ctx is done before marking wg done.
+// Only connections made after it is called are recorded.
+func StartTraffic(ctx context.Context, cfg TrafficConfig, db database.Storage, wg *sync.WaitGroup) {
+	cfg.applyDefaults()
+	trafficRecording.Store(true)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Duration(cfg.FlushSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				flushTraffic(db)
+				return
+			case <-ticker.C:
+				flushTraffic(db)
+			}
+		}
+	}()
+}
+
+// flushTraffic saves the traffic counted since the last flush and forgets
+// connections that have closed
+func flushTraffic(db database.Storage) {
+	trafficPeers.Lock()
+	var rows []database.PeerTraffic
+	for t := range trafficPeers.peers {
+		counts, closed := t.take()
+		if closed {
+			delete(trafficPeers.peers, t)
+		}
+		for command, c := range counts {
+			rows = append(rows, database.PeerTraffic{
+				PeerAddr:         t.addr,
+				Region:           t.region,
+				Command:          command,
+				BytesSent:        c.bytesSent,
+				BytesReceived:    c.bytesReceived,
+				MessagesSent:     c.messagesSent,
+				MessagesReceived: c.messagesReceived,
+			})
+		}
+	}
+	trafficPeers.Unlock()
+	if len(rows) == 0 {
+		return
+	}
+	if err := db.RecordPeerTraffic(time.Now(), rows); err != nil {
+		logger.Log.Error().Err(err).Int("rows", len(rows)).Msg("Failed to record peer traffic")
+	}
+}
@@ -0,0 +1,49 @@
+package observer
+
+import (
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// startSession records the connection's transport, with the kernel's TCP
+// statistics right after the handshake
+func startSession(stats *connStats, address, region string, plog zerolog.Logger, db database.Storage) {
+	// No BIP324 support yet, so every session is plaintext v1
+	id, err := db.StartPeerSession(address, region, database.TransportV1, readTCPStats(stats.tcp))
+	if err != nil {
+		logger.Error(plog, err, "DB StartPeerSession error")
+		return
+	}
+	stats.session = id
+}
+
+// recordSessionPing stores a ping round trip next to the kernel's smoothed
+// RTT sampled at the same moment, so application and network latency can be
+// told apart
+func recordSessionPing(stats *connStats, pingMs int, region string, plog zerolog.Logger, db database.Storage) {
+	tcp := readTCPStats(stats.tcp)
+	if tcp != nil {
+		metrics.PeerTCPRTT.WithLabelValues(region).Observe(tcp.RTTMs)
+	}
+	if stats.session == 0 {
+		return
+	}
+	if err := db.UpdatePeerSession(stats.session, pingMs, tcp); err != nil {
+		logger.Error(plog, err, "DB UpdatePeerSession error")
+	}
+}
+
+// endSession closes the session with the final TCP statistics, including
+// the retransmit total, and its negotiated features if they changed since
+// they were recorded
+func endSession(stats *connStats, plog zerolog.Logger, db database.Storage) {
+	if stats.session == 0 {
+		return
+	}
+	stats.features.record(stats.session, plog, db)
+	if err := db.EndPeerSession(stats.session, readTCPStats(stats.tcp)); err != nil {
+		logger.Error(plog, err, "DB EndPeerSession error")
+	}
+}
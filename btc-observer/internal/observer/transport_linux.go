@@ -0,0 +1,41 @@
+//go:build linux
+
+package observer
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/keato/btc-observer/internal/database"
+	"golang.org/x/sys/unix"
+)
+
+// readTCPStats reads TCP_INFO for conn, or returns nil if it isn't a socket
+// or the call fails
+func readTCPStats(conn net.Conn) *database.TCPStats {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var info *unix.TCPInfo
+	var infoErr error
+	err = raw.Control(func(fd uintptr) {
+		info, infoErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || infoErr != nil {
+		return nil
+	}
+	// The kernel reports RTTs in microseconds
+	return &database.TCPStats{
+		RTTMs:       float64(info.Rtt) / 1000,
+		RTTVarMs:    float64(info.Rttvar) / 1000,
+		MinRTTMs:    float64(info.Min_rtt) / 1000,
+		MSS:         int(info.Snd_mss),
+		PMTU:        int(info.Pmtu),
+		Retransmits: int(info.Total_retrans),
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package observer
+
+import (
+	"net"
+
+	"github.com/keato/btc-observer/internal/database"
+)
+
+// readTCPStats returns nil: TCP_INFO is only read on Linux
+func readTCPStats(conn net.Conn) *database.TCPStats {
+	return nil
+}
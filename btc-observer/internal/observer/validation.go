@@ -0,0 +1,248 @@
+package observer
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/keato/btc-observer/internal/chain"
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	"github.com/keato/btc-observer/internal/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// checkpoint pins accepted blocks to a trusted chain when configured
+var checkpoint *chain.CheckpointGuard
+
+// SetCheckpoint enables checkpoint-pinned validation of received blocks
+func SetCheckpoint(g *chain.CheckpointGuard) {
+	checkpoint = g
+}
+
+// acceptBlock reports whether a block descends from the configured checkpoint.
+// Blocks that don't are quarantined (or just dropped in reject mode).
+func acceptBlock(block *protocol.Block, peerAddr string, plog zerolog.Logger, db database.Storage) bool {
+	if checkpoint == nil {
+		return true
+	}
+
+	reason := checkpointViolation(block, plog, db)
+	if reason == "" {
+		checkpoint.MarkVerified(block.BlockHash)
+		return true
+	}
+
+	metrics.BlocksRejected.WithLabelValues(reason).Inc()
+	plog.Warn().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Int("height", int(block.Height)).
+		Str("reason", reason).
+		Msg("Block rejected by checkpoint")
+
+	if checkpoint.Quarantine() {
+		if err := db.RecordQuarantinedBlock(block, peerAddr, reason); err != nil {
+			logger.Error(plog, err, "DB RecordQuarantinedBlock error")
+		}
+	}
+	return false
+}
+
+func checkpointViolation(block *protocol.Block, plog zerolog.Logger, db database.Storage) string {
+	cpHeight := checkpoint.Height()
+	cpHash := checkpoint.Hash()
+
+	if block.Height < cpHeight {
+		return chain.CheckpointBelow
+	}
+	if block.Height == cpHeight {
+		if block.BlockHash == cpHash {
+			return ""
+		}
+		return chain.CheckpointConflict
+	}
+
+	prev := block.Header.PrevBlockHash
+	if checkpoint.IsVerified(prev) {
+		return ""
+	}
+
+	// Parent isn't in the verified set yet (e.g. stored before a restart, or
+	// the header chain hasn't synced past it), so walk the stored chain back
+	// to the checkpoint height
+	ancestor, err := db.AncestorHashAtHeight(prev[:], cpHeight)
+	if err != nil {
+		logger.Error(plog, err, "DB AncestorHashAtHeight error")
+		return chain.CheckpointUnconnected
+	}
+	if ancestor == nil {
+		return chain.CheckpointUnconnected
+	}
+	if !bytes.Equal(ancestor, cpHash[:]) {
+		return chain.CheckpointConflict
+	}
+	checkpoint.MarkVerified(prev)
+	return ""
+}
+
+// verifyCheckpointHeaders marks headers that joined the header chain as
+// descending from the checkpoint when their parent does. Headers arrive in
+// chain order, so syncing through the checkpoint verifies everything above
+// it, including blocks that were never stored.
+func verifyCheckpointHeaders(headers []chain.Header) {
+	if checkpoint == nil {
+		return
+	}
+	for _, h := range headers {
+		if checkpoint.IsVerified(h.PrevHash) && headerChain.Contains(h.Hash) {
+			checkpoint.MarkVerified(h.Hash)
+		}
+	}
+}
+
+// keepPartialBlock decides whether a block that failed to parse part way is
+// still worth storing. It needs the header; a block whose coinbase didn't
+// parse takes its height from its stored parent. The block is also unmarked
+// as seen so another peer's announcement fetches a complete copy.
+func keepPartialBlock(block *protocol.Block, err error, plog zerolog.Logger, db database.Storage) bool {
+	if block == nil {
+		return false
+	}
+	ForgetSeenBlock(block.BlockHash)
+
+	if block.Height == 0 {
+		parent, derr := db.GetBlockHeader(block.Header.PrevBlockHash[:])
+		if derr != nil {
+			logger.Error(plog, derr, "DB GetBlockHeader error")
+			return false
+		}
+		if parent == nil {
+			plog.Warn().Err(err).Msg("Dropping unparseable block with unknown height")
+			return false
+		}
+		block.Height = parent.Height + 1
+	}
+
+	metrics.BlocksPartial.Inc()
+	plog.Warn().Err(err).
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Int("parsed", len(block.Transactions)).
+		Int("declared", block.DeclaredTxCount).
+		Msg("Keeping partially parsed block")
+	return true
+}
+
+// validateBlock runs sanity checks on a received block against the stored chain
+// and records any anomalies found
+func validateBlock(block *protocol.Block, plog zerolog.Logger, db database.Storage) {
+	ancestors, err := db.AncestorTimestamps(block.Header.PrevBlockHash[:], chain.MedianTimeBlocks)
+	if err != nil {
+		logger.Error(plog, err, "DB AncestorTimestamps error")
+		return
+	}
+
+	blockTime := time.Unix(int64(block.Header.Timestamp), 0)
+	for _, a := range chain.CheckTimestamp(blockTime, ancestors, time.Now()) {
+		recordAnomaly(block, a, plog, db)
+	}
+
+	checkDifficulty(block, plog, db)
+}
+
+// checkMerkleRoot recomputes a complete block's merkle root and, if it
+// matches the header, stores the block's txids in order so inclusion proofs
+// can be built for its transactions
+func checkMerkleRoot(block *protocol.Block, plog zerolog.Logger, db database.Storage) {
+	if block.ParseError != "" {
+		return
+	}
+	txids := make([][32]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txids[i] = tx.TxID
+	}
+	if root := protocol.MerkleRoot(txids); root != block.Header.MerkleRoot {
+		recordAnomaly(block, chain.Anomaly{
+			Kind:   chain.AnomalyBadMerkleRoot,
+			Detail: fmt.Sprintf("computed %x", protocol.ReverseBytes(root[:])),
+		}, plog, db)
+		return
+	}
+	if err := db.RecordBlockTxIDs(block.BlockHash[:], txids); err != nil {
+		logger.Error(plog, err, "DB RecordBlockTxIDs error")
+	}
+}
+
+// checkDifficulty verifies the block's bits against its stored parent. Blocks whose
+// parent (or retarget interval start) we never stored are skipped.
+func checkDifficulty(block *protocol.Block, plog zerolog.Logger, db database.Storage) {
+	parent, err := db.GetBlockHeader(block.Header.PrevBlockHash[:])
+	if err != nil {
+		logger.Error(plog, err, "DB GetBlockHeader error")
+		return
+	}
+	if parent == nil {
+		return
+	}
+
+	var intervalStart time.Time
+	if chain.IsRetargetHeight(block.Height) {
+		first, err := db.GetBlockHeaderAtHeight(block.Height - chain.RetargetInterval)
+		if err != nil {
+			logger.Error(plog, err, "DB GetBlockHeaderAtHeight error")
+			return
+		}
+		if first == nil {
+			return
+		}
+		intervalStart = first.Timestamp
+	}
+
+	if a := chain.CheckDifficulty(block.Height, block.Header.Bits, parent.Bits, intervalStart, parent.Timestamp); a != nil {
+		recordAnomaly(block, *a, plog, db)
+	}
+}
+
+func recordAnomaly(block *protocol.Block, a chain.Anomaly, plog zerolog.Logger, db database.Storage) {
+	metrics.BlockAnomalies.WithLabelValues(a.Kind).Inc()
+	plog.Warn().
+		Str("hash", fmt.Sprintf("%x", protocol.ReverseBytes(block.BlockHash[:]))).
+		Int("height", int(block.Height)).
+		Str("kind", a.Kind).
+		Str("detail", a.Detail).
+		Msg("Block anomaly")
+	if err := db.RecordBlockAnomaly(block.BlockHash[:], block.Height, a.Kind, a.Detail); err != nil {
+		logger.Error(plog, err, "DB RecordBlockAnomaly error")
+	}
+}
+
+// trackSignaling refreshes version-bits signaling counts for the block's
+// period and for the rolling window of one period's length ending at it
+func trackSignaling(block *protocol.Block, plog zerolog.Logger, db database.Storage) {
+	stats, err := db.UpdateVersionBitsPeriod(chain.PeriodStart(block.Height), chain.RetargetInterval)
+	if err != nil {
+		logger.Error(plog, err, "DB UpdateVersionBitsPeriod error")
+	} else {
+		setSignalingRatios(metrics.VersionBitsSignaling, stats)
+	}
+
+	stats, err = db.RecordVersionBitsWindow(block.Height, chain.RetargetInterval)
+	if err != nil {
+		logger.Error(plog, err, "DB RecordVersionBitsWindow error")
+	} else {
+		setSignalingRatios(metrics.VersionBitsWindowSignaling, stats)
+	}
+}
+
+func setSignalingRatios(gauge *prometheus.GaugeVec, stats []database.BitSignaling) {
+	gauge.Reset()
+	for _, s := range stats {
+		if s.Total == 0 {
+			continue
+		}
+		ratio := float64(s.Signaling) / float64(s.Total)
+		gauge.WithLabelValues(strconv.Itoa(s.Bit)).Set(ratio)
+	}
+}
@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Address networks, as named in BIP155
+const (
+	NetIPv4  = "ipv4"
+	NetIPv6  = "ipv6"
+	NetTorV3 = "torv3"
+	NetI2P   = "i2p"
+	NetCJDNS = "cjdns"
+)
+
+// BIP155 network IDs and their address lengths. TORV2 (3) is obsolete and
+// skipped like any unknown network.
+var addrV2Networks = map[byte]struct {
+	name string
+	size int
+}{
+	1: {NetIPv4, 4},
+	2: {NetIPv6, 16},
+	4: {NetTorV3, 32},
+	5: {NetI2P, 32},
+	6: {NetCJDNS, 16},
+}
+
+const (
+	// MaxAddrPerMessage is the most addresses an addr or addrv2 message may carry
+	MaxAddrPerMessage = 1000
+	maxAddrV2Size     = 512
+)
+
+var lowerBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NetAddress is a peer address gossiped in addr or addrv2
+type NetAddress struct {
+	Network   string
+	Host      string // IP, or .onion / .b32.i2p name
+	Port      uint16
+	Services  uint64
+	Timestamp uint32 // when the address was last seen, per the sender
+}
+
+// String returns host:port
+func (a NetAddress) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+}
+
+// ParseAddrEntries parses an addr message, including IPv6 entries. On a
+// truncated message it returns the entries read so far with the error.
+func ParseAddrEntries(payload []byte) ([]NetAddress, error) {
+	buf := bytes.NewReader(payload)
+	count, err := readCount(buf, 30)
+	if err != nil {
+		return nil, fmt.Errorf("reading addr count: %w", err)
+	}
+	count = min(count, MaxAddrPerMessage)
+
+	addrs := make([]NetAddress, 0, count)
+	var raw [30]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(buf, raw[:]); err != nil {
+			return addrs, fmt.Errorf("reading addr %d: %w", i, err)
+		}
+		ip := net.IP(append([]byte(nil), raw[12:28]...))
+		a := NetAddress{
+			Network:   NetIPv6,
+			Host:      ip.String(),
+			Port:      binary.BigEndian.Uint16(raw[28:30]),
+			Services:  binary.LittleEndian.Uint64(raw[4:12]),
+			Timestamp: binary.LittleEndian.Uint32(raw[0:4]),
+		}
+		if ip.To4() != nil {
+			a.Network = NetIPv4
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// ParseAddrV2Message parses an addrv2 message (BIP155). Entries on networks
+// we don't know, or with the wrong length for their network, are skipped.
+// On a malformed message it returns the entries read so far with the error.
+func ParseAddrV2Message(payload []byte) ([]NetAddress, error) {
+	buf := bytes.NewReader(payload)
+	count, err := readCount(buf, 9)
+	if err != nil {
+		return nil, fmt.Errorf("reading addrv2 count: %w", err)
+	}
+	if count > MaxAddrPerMessage {
+		return nil, fmt.Errorf("too many addresses: %d", count)
+	}
+
+	var addrs []NetAddress
+	for i := uint64(0); i < count; i++ {
+		var a NetAddress
+		if err := binary.Read(buf, binary.LittleEndian, &a.Timestamp); err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d: %w", i, err)
+		}
+		if a.Services, err = readVarInt(buf); err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d services: %w", i, err)
+		}
+		networkID, err := buf.ReadByte()
+		if err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d network: %w", i, err)
+		}
+		size, err := readVarInt(buf)
+		if err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d address size: %w", i, err)
+		}
+		if size > maxAddrV2Size {
+			return addrs, fmt.Errorf("addrv2 %d address of %d bytes", i, size)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(buf, raw); err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d address: %w", i, err)
+		}
+		if err := binary.Read(buf, binary.BigEndian, &a.Port); err != nil {
+			return addrs, fmt.Errorf("reading addrv2 %d port: %w", i, err)
+		}
+
+		network, ok := addrV2Networks[networkID]
+		if !ok || int(size) != network.size {
+			continue
+		}
+		a.Network = network.name
+		switch network.name {
+		case NetIPv4, NetIPv6, NetCJDNS:
+			a.Host = net.IP(raw).String()
+		case NetTorV3:
+			a.Host = onionV3Host(raw)
+		case NetI2P:
+			a.Host = lowerBase32.EncodeToString(raw) + ".b32.i2p"
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// onionV3Host encodes a Tor v3 public key as its .onion name
+func onionV3Host(pubkey []byte) string {
+	const version = 3
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{version})
+	checksum := h.Sum(nil)[:2]
+
+	name := make([]byte, 0, 35)
+	name = append(name, pubkey...)
+	name = append(name, checksum...)
+	name = append(name, version)
+	return lowerBase32.EncodeToString(name) + ".onion"
+}
+
+// AddressNetwork classifies a peer address (host or host:port)
+func AddressNetwork(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	switch {
+	case strings.HasSuffix(host, ".onion"):
+		return NetTorV3
+	case strings.HasSuffix(host, ".b32.i2p"):
+		return NetI2P
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return NetIPv4
+	case ip[0] == 0xfc:
+		return NetCJDNS
+	default:
+		return NetIPv6
+	}
+}
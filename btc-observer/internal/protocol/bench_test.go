@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+// Benchmarks run over the golden corpus so the fixtures are real mainnet
+// messages. Compare runs with benchstat:
+//
+//	go test ./internal/protocol -run XXX -bench . -count 10 > new.txt
+
+func BenchmarkParseTxMessage(b *testing.B) {
+	for _, f := range corpusFiles(b, "tx") {
+		payload := readPayload(b, f.path)
+		if _, err := ParseTxMessage(payload); err != nil {
+			continue
+		}
+		b.Run(f.name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ParseTxMessage(payload)
+			}
+		})
+	}
+}
+
+func BenchmarkParseBlockMessage(b *testing.B) {
+	for _, f := range corpusFiles(b, "block") {
+		payload := readPayload(b, f.path)
+		if _, err := ParseBlockMessage(payload); err != nil {
+			continue
+		}
+		b.Run(f.name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ParseBlockMessage(payload)
+			}
+		})
+	}
+}
+
+func BenchmarkParseVersionMessage(b *testing.B) {
+	payload := readPayload(b, corpusDir+"/version/bitcoin_core_27.bin")
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseVersionMessage(payload)
+	}
+}
+
+// invFixture builds an inv payload with the given number of tx announcements,
+// roughly what a busy peer sends per message
+func invFixture(n int) []byte {
+	vectors := make([]InvVector, n)
+	for i := range vectors {
+		vectors[i] = InvVector{Type: 1, Hash: sha256.Sum256([]byte{byte(i), byte(i >> 8)})}
+	}
+	return CreateGetDataPayload(vectors)
+}
+
+func BenchmarkParseInvMessage(b *testing.B) {
+	for _, n := range []int{1, 35, 500} {
+		payload := invFixture(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ParseInvMessage(payload)
+			}
+		})
+	}
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+	payload := readPayload(b, corpusDir+"/tx/segwit_multi_input.bin")
+	packet := CreateMessagePacket("tx", payload)
+	r := bytes.NewReader(packet)
+	b.SetBytes(int64(len(packet)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(packet)
+		if _, err := ReadMessage(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
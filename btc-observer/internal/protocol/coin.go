@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// CoinConfig defines a Bitcoin-like network by its parameters, for forks and
+// lab deployments that aren't one of the built-in networks. Anything not set
+// keeps Bitcoin's value.
+type CoinConfig struct {
+	Name string `json:"name"`
+
+	// Magic is the four message start bytes as hex, in wire order
+	// (pchMessageStart), e.g. "fbc0b6db"
+	Magic       string   `json:"magic"`
+	DefaultPort int      `json:"default_port"`
+	DNSSeeds    []string `json:"dns_seeds,omitempty"`
+
+	// Address encoding: base58 version bytes and the segwit bech32 prefix
+	PubKeyHashPrefix *byte  `json:"pubkey_hash_prefix,omitempty"`
+	ScriptHashPrefix *byte  `json:"script_hash_prefix,omitempty"`
+	Bech32HRP        string `json:"bech32_hrp,omitempty"`
+
+	// GenesisHeader is the serialized 80-byte genesis block header as hex
+	GenesisHeader string `json:"genesis_header"`
+
+	// PowLimitBits is the easiest allowed target in compact form, as hex
+	// (default: the genesis header's bits)
+	PowLimitBits string `json:"pow_limit_bits,omitempty"`
+	// TargetSpacingSec and TargetTimespanSec are the block interval and the
+	// retarget timespan (default 600 and 1209600)
+	TargetSpacingSec  int `json:"target_spacing_s,omitempty"`
+	TargetTimespanSec int `json:"target_timespan_s,omitempty"`
+	// NoRetargeting and MinDifficultyBlocks mark regtest- and testnet-style
+	// difficulty rules, which skip difficulty checks
+	NoRetargeting       bool `json:"no_retargeting,omitempty"`
+	MinDifficultyBlocks bool `json:"min_difficulty_blocks,omitempty"`
+	// SkipPoWCheck accepts headers without checking their hash against the
+	// target, for coins whose proof-of-work hash isn't sha256d
+	SkipPoWCheck bool `json:"skip_pow_check,omitempty"`
+
+	// InitialSubsidy is the block reward in base units before the first
+	// halving (default 5000000000), halved every HalvingInterval blocks
+	// (default 210000)
+	InitialSubsidy  int64 `json:"initial_subsidy,omitempty"`
+	HalvingInterval int32 `json:"halving_interval,omitempty"`
+}
+
+// Network builds the coin's network parameters, starting from Bitcoin
+// mainnet's and replacing what the definition sets
+func (c *CoinConfig) Network() (*NetworkParams, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("coin: name is required")
+	}
+	if _, ok := networks[c.Name]; ok {
+		return nil, fmt.Errorf("coin: name %q is a built-in network", c.Name)
+	}
+	magic, err := hex.DecodeString(strings.TrimPrefix(c.Magic, "0x"))
+	if err != nil || len(magic) != 4 {
+		return nil, fmt.Errorf("coin: magic must be 4 bytes of hex, got %q", c.Magic)
+	}
+	if c.DefaultPort <= 0 || c.DefaultPort > 65535 {
+		return nil, fmt.Errorf("coin: default_port must be 1-65535")
+	}
+	raw, err := hex.DecodeString(c.GenesisHeader)
+	if err != nil || len(raw) != 80 {
+		return nil, fmt.Errorf("coin: genesis_header must be 80 bytes of hex")
+	}
+	var genesis wire.MsgBlock
+	if err := genesis.Header.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("coin: genesis_header: %w", err)
+	}
+	genesisHash := genesis.Header.BlockHash()
+
+	powLimitBits := genesis.Header.Bits
+	if c.PowLimitBits != "" {
+		bits, err := strconv.ParseUint(strings.TrimPrefix(c.PowLimitBits, "0x"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("coin: pow_limit_bits: %w", err)
+		}
+		powLimitBits = uint32(bits)
+	}
+	if c.TargetSpacingSec < 0 || c.TargetTimespanSec < 0 || c.InitialSubsidy < 0 || c.HalvingInterval < 0 {
+		return nil, fmt.Errorf("coin: timing and subsidy parameters must not be negative")
+	}
+
+	params := chaincfg.MainNetParams
+	params.Name = c.Name
+	params.Net = wire.BitcoinNet(binary.LittleEndian.Uint32(magic))
+	params.DefaultPort = strconv.Itoa(c.DefaultPort)
+	params.DNSSeeds = make([]chaincfg.DNSSeed, len(c.DNSSeeds))
+	for i, host := range c.DNSSeeds {
+		params.DNSSeeds[i] = chaincfg.DNSSeed{Host: host}
+	}
+	params.GenesisBlock = &genesis
+	params.GenesisHash = &genesisHash
+	params.PowLimitBits = powLimitBits
+	params.PowLimit = compactToBig(powLimitBits)
+	params.Checkpoints = nil
+	if c.PubKeyHashPrefix != nil {
+		params.PubKeyHashAddrID = *c.PubKeyHashPrefix
+	}
+	if c.ScriptHashPrefix != nil {
+		params.ScriptHashAddrID = *c.ScriptHashPrefix
+	}
+	if c.Bech32HRP != "" {
+		params.Bech32HRPSegwit = c.Bech32HRP
+	}
+	if c.TargetSpacingSec > 0 {
+		params.TargetTimePerBlock = time.Duration(c.TargetSpacingSec) * time.Second
+	}
+	if c.TargetTimespanSec > 0 {
+		params.TargetTimespan = time.Duration(c.TargetTimespanSec) * time.Second
+	}
+	if c.HalvingInterval > 0 {
+		params.SubsidyReductionInterval = c.HalvingInterval
+	}
+	params.PoWNoRetargeting = c.NoRetargeting
+	params.ReduceMinDifficulty = c.MinDifficultyBlocks
+
+	p := newNetworkParams(c.Name, &params)
+	if c.InitialSubsidy > 0 {
+		p.InitialSubsidy = c.InitialSubsidy
+	}
+	p.SkipPoWCheck = c.SkipPoWCheck
+	return p, nil
+}
+
+// compactToBig decodes a positive target from its compact "bits" form
+func compactToBig(bits uint32) *big.Int {
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+	exponent := uint(bits >> 24)
+	if exponent <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-exponent))
+	}
+	return mantissa.Lsh(mantissa, 8*(exponent-3))
+}
@@ -0,0 +1,285 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Inventory types beyond MSG_TX and MSG_BLOCK
+const (
+	InvTypeTx           = 1
+	InvTypeBlock        = 2
+	InvTypeCmpctBlock   = 4          // BIP152
+	InvTypeWTx          = 5          // BIP339: a transaction announced by wtxid
+	InvWitnessFlag      = 0x40000000 // BIP144: ask for witness serialization
+	InvTypeWitnessTx    = InvTypeTx | InvWitnessFlag
+	InvTypeWitnessBlock = InvTypeBlock | InvWitnessFlag
+)
+
+// CompactBlockVersion is the BIP152 version spoken, with short IDs over wtxids
+const CompactBlockVersion = 2
+
+// WTxIDRelayVersion is the first protocol version that negotiates wtxid
+// relay (BIP339)
+const WTxIDRelayVersion = 70016
+
+// shortIDSize is the encoded size of a compact block short ID
+const shortIDSize = 6
+
+// CompactBlock is a parsed cmpctblock message (BIP152)
+type CompactBlock struct {
+	Header    BlockHeader
+	BlockHash [32]byte
+	Nonce     uint64
+	ShortIDs  []uint64
+	Prefilled []PrefilledTx
+
+	// siphash key derived from the header and nonce
+	k0, k1 uint64
+}
+
+// PrefilledTx is a transaction sent in full inside a compact block
+type PrefilledTx struct {
+	Index int
+	Tx    *Transaction
+}
+
+// TxCount is the number of transactions in the block
+func (cb *CompactBlock) TxCount() int {
+	return len(cb.ShortIDs) + len(cb.Prefilled)
+}
+
+// CreateSendCmpctPayload builds a sendcmpct payload. With announce false the
+// peer keeps announcing blocks with inv/headers (low-bandwidth mode) and
+// sends compact blocks only when asked.
+func CreateSendCmpctPayload(announce bool, version uint64) []byte {
+	buf := make([]byte, 9)
+	if announce {
+		buf[0] = 1
+	}
+	binary.LittleEndian.PutUint64(buf[1:], version)
+	return buf
+}
+
+// ParseCompactBlock parses a cmpctblock message payload
+func ParseCompactBlock(payload []byte) (*CompactBlock, error) {
+	if len(payload) < 88 {
+		return nil, fmt.Errorf("cmpctblock %w: %d bytes", ErrPayloadTooShort, len(payload))
+	}
+	header := payload[:80]
+	hash1 := sha256.Sum256(header)
+	cb := &CompactBlock{
+		Header:    decodeBlockHeader(header),
+		BlockHash: sha256.Sum256(hash1[:]),
+		Nonce:     binary.LittleEndian.Uint64(payload[80:88]),
+	}
+	cb.k0, cb.k1 = shortIDKeys(header, cb.Nonce)
+
+	buf := bytes.NewReader(payload[88:])
+	count, err := readCount(buf, shortIDSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading short id count: %w", err)
+	}
+	cb.ShortIDs = make([]uint64, count)
+	var raw [8]byte
+	for i := range cb.ShortIDs {
+		if _, err := io.ReadFull(buf, raw[:shortIDSize]); err != nil {
+			return nil, fmt.Errorf("reading short id %d: %w", i, err)
+		}
+		cb.ShortIDs[i] = binary.LittleEndian.Uint64(raw[:])
+	}
+
+	prefilled, err := readCount(buf, 1+minTxSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading prefilled count: %w", err)
+	}
+	cb.Prefilled = make([]PrefilledTx, prefilled)
+	index := -1
+	for i := range cb.Prefilled {
+		diff, err := readVarInt(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading prefilled %d index: %w", i, err)
+		}
+		if diff >= uint64(cb.TxCount()) {
+			return nil, fmt.Errorf("prefilled %d index out of range", i)
+		}
+		index += int(diff) + 1
+		if index >= cb.TxCount() {
+			return nil, fmt.Errorf("prefilled %d index %d out of range", i, index)
+		}
+		tx, err := parseTxFromReader(buf, payload[88:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing prefilled tx %d: %w", i, err)
+		}
+		cb.Prefilled[i] = PrefilledTx{Index: index, Tx: tx}
+	}
+	return cb, nil
+}
+
+// ShortID computes the short ID a compact block uses for a wtxid
+func (cb *CompactBlock) ShortID(wtxid [32]byte) uint64 {
+	return sipHash24(cb.k0, cb.k1, wtxid[:]) & (1<<(8*shortIDSize) - 1)
+}
+
+// Fill places the prefilled transactions and any candidates (typically the
+// mempool) whose short IDs match, returning the block's transactions with
+// nil gaps and the indexes still missing. Short IDs that collide within the
+// block are left missing.
+func (cb *CompactBlock) Fill(candidates []*Transaction) ([]*Transaction, []int) {
+	txs := make([]*Transaction, cb.TxCount())
+	for _, p := range cb.Prefilled {
+		txs[p.Index] = p.Tx
+	}
+
+	// Short IDs fill the slots the prefilled txs don't
+	slots := make(map[uint64]int, len(cb.ShortIDs))
+	slot := 0
+	for _, id := range cb.ShortIDs {
+		for txs[slot] != nil {
+			slot++
+		}
+		if _, dup := slots[id]; dup {
+			slots[id] = -1
+		} else {
+			slots[id] = slot
+		}
+		slot++
+	}
+	for _, tx := range candidates {
+		if i, ok := slots[cb.ShortID(tx.WTxID)]; ok && i >= 0 {
+			txs[i] = tx
+		}
+	}
+
+	var missing []int
+	for i, tx := range txs {
+		if tx == nil {
+			missing = append(missing, i)
+		}
+	}
+	return txs, missing
+}
+
+// Block assembles the reconstructed block once every transaction is present,
+// checking the merkle root so a short ID collision isn't accepted
+func (cb *CompactBlock) Block(txs []*Transaction) (*Block, error) {
+	txids := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		if tx == nil {
+			return nil, fmt.Errorf("transaction %d missing", i)
+		}
+		txids[i] = tx.TxID
+	}
+	if MerkleRoot(txids) != cb.Header.MerkleRoot {
+		return nil, fmt.Errorf("merkle root mismatch")
+	}
+	block := &Block{
+		Header:          cb.Header,
+		BlockHash:       cb.BlockHash,
+		Difficulty:      computeDifficulty(cb.Header.Bits),
+		Transactions:    txs,
+		DeclaredTxCount: len(txs),
+	}
+	if len(txs[0].Inputs) > 0 {
+		block.Height = extractBlockHeight(txs[0])
+	}
+	return block, nil
+}
+
+// CreateGetBlockTxnPayload builds a getblocktxn payload requesting the
+// transactions at indexes (ascending) of a block
+func CreateGetBlockTxnPayload(blockHash [32]byte, indexes []int) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(blockHash[:])
+	writeVarInt(buf, uint64(len(indexes)))
+	prev := -1
+	for _, i := range indexes {
+		writeVarInt(buf, uint64(i-prev-1))
+		prev = i
+	}
+	return buf.Bytes()
+}
+
+// ParseBlockTxn parses a blocktxn message payload
+func ParseBlockTxn(payload []byte) ([32]byte, []*Transaction, error) {
+	var hash [32]byte
+	if len(payload) < 32 {
+		return hash, nil, fmt.Errorf("blocktxn %w: %d bytes", ErrPayloadTooShort, len(payload))
+	}
+	copy(hash[:], payload[:32])
+	buf := bytes.NewReader(payload[32:])
+	count, err := readCount(buf, minTxSize)
+	if err != nil {
+		return hash, nil, fmt.Errorf("reading tx count: %w", err)
+	}
+	txs := make([]*Transaction, count)
+	for i := range txs {
+		if txs[i], err = parseTxFromReader(buf, payload[32:]); err != nil {
+			return hash, nil, fmt.Errorf("parsing tx %d: %w", i, err)
+		}
+	}
+	return hash, txs, nil
+}
+
+// shortIDKeys derives the siphash key from SHA256(header || nonce)
+func shortIDKeys(header []byte, nonce uint64) (uint64, uint64) {
+	var data [88]byte
+	copy(data[:], header)
+	binary.LittleEndian.PutUint64(data[80:], nonce)
+	sum := sha256.Sum256(data[:])
+	return binary.LittleEndian.Uint64(sum[0:8]), binary.LittleEndian.Uint64(sum[8:16])
+}
+
+// sipHash24 is SipHash-2-4 with a 128-bit key given as two little-endian words
+func sipHash24(k0, k1 uint64, msg []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(msg)
+	for len(msg) >= 8 {
+		m := binary.LittleEndian.Uint64(msg)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		msg = msg[8:]
+	}
+	var last [8]byte
+	copy(last[:], msg)
+	last[7] = byte(n)
+	m := binary.LittleEndian.Uint64(last[:])
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,54 @@
+//go:build gofuzz
+
+package protocol
+
+// Entry points for go-fuzz and libFuzzer (go-fuzz-build -libfuzzer). The
+// native fuzz targets in fuzz_test.go cover the same parsers for `go test -fuzz`.
+
+// FuzzTx is the go-fuzz entry point for ParseTxMessage
+func FuzzTx(data []byte) int {
+	if _, err := ParseTxMessage(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzBlock is the go-fuzz entry point for ParseBlockMessage
+func FuzzBlock(data []byte) int {
+	if _, err := ParseBlockMessage(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzVersion is the go-fuzz entry point for ParseVersionMessage
+func FuzzVersion(data []byte) int {
+	if _, err := ParseVersionMessage(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzHeaders is the go-fuzz entry point for ParseHeadersMessage
+func FuzzHeaders(data []byte) int {
+	if _, err := ParseHeadersMessage(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzCompactBlock is the go-fuzz entry point for ParseCompactBlock
+func FuzzCompactBlock(data []byte) int {
+	if _, err := ParseCompactBlock(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzAddrV2 is the go-fuzz entry point for ParseAddrV2Message
+func FuzzAddrV2(data []byte) int {
+	if _, err := ParseAddrV2Message(data); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+// The fuzz targets are seeded from the golden corpus, so every captured
+// message (including the malformed ones) is a starting point for mutation:
+//
+//	go test ./internal/protocol -fuzz FuzzParseTxMessage -fuzztime 5m
+//
+// Inputs that crash the parsers are written to testdata/fuzz and replayed by
+// plain `go test` from then on.
+
+func seedCorpus(f *testing.F, kind string) {
+	for _, file := range corpusFiles(f, kind) {
+		// Fuzzing a megabyte block is slow; its txs are seeded separately
+		if strings.HasSuffix(file.path, ".gz") {
+			continue
+		}
+		f.Add(readPayload(f, file.path))
+	}
+}
+
+func FuzzParseTxMessage(f *testing.F) {
+	seedCorpus(f, "tx")
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		tx, err := ParseTxMessage(payload)
+		if err != nil {
+			return
+		}
+		if tx.SizeBytes < 0 || tx.SizeBytes > len(payload) {
+			t.Fatalf("size %d outside payload of %d bytes", tx.SizeBytes, len(payload))
+		}
+		if tx.WitnessBytes < 0 || tx.WitnessBytes > tx.SizeBytes || (tx.WitnessBytes > 0) != tx.Segwit {
+			t.Fatalf("witness bytes %d inconsistent with size %d (segwit %v)", tx.WitnessBytes, tx.SizeBytes, tx.Segwit)
+		}
+		inputWitness := 0
+		for _, in := range tx.Inputs {
+			inputWitness += in.WitnessSize
+		}
+		if tx.Segwit && inputWitness+2 != tx.WitnessBytes {
+			t.Fatalf("input witness sizes sum to %d, want %d", inputWitness, tx.WitnessBytes-2)
+		}
+		if !tx.Segwit && tx.WTxID != tx.TxID {
+			t.Fatalf("wtxid differs from txid without witness data")
+		}
+	})
+}
+
+func FuzzParseBlockMessage(f *testing.F) {
+	seedCorpus(f, "block")
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		block, err := ParseBlockMessage(payload)
+		if err != nil {
+			if block != nil && (block.ParseError == "" || len(block.Transactions) > block.DeclaredTxCount) {
+				t.Fatalf("inconsistent partial block: %d of %d txs, error %q",
+					len(block.Transactions), block.DeclaredTxCount, block.ParseError)
+			}
+			return
+		}
+		for _, tx := range block.Transactions {
+			if tx == nil {
+				t.Fatal("nil transaction in parsed block")
+			}
+		}
+	})
+}
+
+func FuzzParseVersionMessage(f *testing.F) {
+	seedCorpus(f, "version")
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		v, err := ParseVersionMessage(payload)
+		if err != nil {
+			return
+		}
+		if len(v.UserAgent) > len(payload) {
+			t.Fatalf("user agent longer than payload")
+		}
+	})
+}
+
+func FuzzParseHeadersMessage(f *testing.F) {
+	// Seed with headers messages built from the block corpus headers
+	for _, file := range corpusFiles(f, "block") {
+		if strings.HasSuffix(file.path, ".gz") {
+			continue
+		}
+		payload := readPayload(f, file.path)
+		if len(payload) < 80 {
+			continue
+		}
+		f.Add(append(append([]byte{1}, payload[:80]...), 0))
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		headers, err := ParseHeadersMessage(payload)
+		if err != nil {
+			return
+		}
+		if len(headers)*81 > len(payload) {
+			t.Fatalf("%d headers from %d bytes", len(headers), len(payload))
+		}
+	})
+}
+
+func FuzzParseCompactBlock(f *testing.F) {
+	// Seed with compact blocks carrying the corpus blocks' coinbase prefilled
+	for _, file := range corpusFiles(f, "block") {
+		if strings.HasSuffix(file.path, ".gz") {
+			continue
+		}
+		payload := readPayload(f, file.path)
+		block, err := ParseBlockMessage(payload)
+		if err != nil || len(block.Transactions) == 0 {
+			continue
+		}
+		seed := append([]byte{}, payload[:80]...)
+		seed = append(seed, make([]byte, 8)...)
+		seed = append(seed, 0, 1, 0)
+		f.Add(append(seed, EncodeTxMessage(block.Transactions[0])...))
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		cb, err := ParseCompactBlock(payload)
+		if err != nil {
+			return
+		}
+		txs, missing := cb.Fill(nil)
+		if len(txs) != cb.TxCount() || len(missing) != len(cb.ShortIDs) {
+			t.Fatalf("%d txs, %d missing from %d short ids", len(txs), len(missing), len(cb.ShortIDs))
+		}
+	})
+}
+
+func FuzzParseAddrV2Message(f *testing.F) {
+	// One entry each for IPv4, Tor v3 and an unknown network
+	f.Add([]byte{3,
+		0, 0, 0, 0, 1, 1, 4, 10, 0, 0, 1, 0x20, 0x8d,
+		0, 0, 0, 0, 1, 4, 32, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 0x20, 0x8d,
+		0, 0, 0, 0, 0, 99, 2, 0xff, 0xff, 0, 0})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		addrs, _ := ParseAddrV2Message(payload)
+		for _, a := range addrs {
+			if AddressNetwork(a.Host) != a.Network && a.Network != NetCJDNS {
+				t.Fatalf("%s classified as %s, parsed as %s", a.Host, AddressNetwork(a.Host), a.Network)
+			}
+		}
+	})
+}
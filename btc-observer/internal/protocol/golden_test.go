@@ -0,0 +1,326 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run `go test ./internal/protocol -run Golden -update` after an intentional
+// parser change to rewrite the golden files, then review the diff.
+var update = flag.Bool("update", false, "rewrite golden files in testdata/corpus")
+
+const corpusDir = "testdata/corpus"
+
+// Golden views keep the files readable: hashes are displayed in RPC byte
+// order and scripts as hex.
+
+type goldenInput struct {
+	PrevTx      string   `json:"prev_tx"`
+	PrevIndex   uint32   `json:"prev_index"`
+	ScriptSig   string   `json:"script_sig"`
+	Sequence    uint32   `json:"sequence"`
+	Witness     []string `json:"witness,omitempty"`
+	WitnessSize int      `json:"witness_size,omitempty"`
+}
+
+type goldenOutput struct {
+	Value        int64  `json:"value"`
+	ScriptPubKey string `json:"script_pub_key"`
+	Address      string `json:"address"`
+}
+
+type goldenTx struct {
+	TxID      string         `json:"txid"`
+	WTxID     string         `json:"wtxid"`
+	Version   int32          `json:"version"`
+	Segwit    bool           `json:"segwit"`
+	SizeBytes int            `json:"size_bytes"`
+	Weight    int            `json:"weight"`
+	LockTime  uint32         `json:"lock_time"`
+	Inputs    []goldenInput  `json:"inputs"`
+	Outputs   []goldenOutput `json:"outputs"`
+}
+
+type goldenBlock struct {
+	Hash       string   `json:"hash"`
+	Version    int32    `json:"version"`
+	PrevBlock  string   `json:"prev_block"`
+	MerkleRoot string   `json:"merkle_root"`
+	Timestamp  uint32   `json:"timestamp"`
+	Bits       uint32   `json:"bits"`
+	Nonce      uint32   `json:"nonce"`
+	Height     int32    `json:"height"`
+	Difficulty float64  `json:"difficulty"`
+	TxCount    int      `json:"tx_count"`
+	Coinbase   goldenTx `json:"coinbase"`
+	TxIDs      []string `json:"txids"`
+}
+
+type goldenVersion struct {
+	Version     int32  `json:"version"`
+	Services    uint64 `json:"services"`
+	Timestamp   int64  `json:"timestamp"`
+	RecvIP      string `json:"recv_ip"`
+	RecvPort    uint16 `json:"recv_port"`
+	FromIP      string `json:"from_ip"`
+	FromPort    uint16 `json:"from_port"`
+	Nonce       uint64 `json:"nonce"`
+	UserAgent   string `json:"user_agent"`
+	StartHeight int32  `json:"start_height"`
+	Relay       bool   `json:"relay"`
+}
+
+type goldenResult struct {
+	Error   string         `json:"error,omitempty"`
+	Tx      *goldenTx      `json:"tx,omitempty"`
+	Block   *goldenBlock   `json:"block,omitempty"`
+	Version *goldenVersion `json:"version,omitempty"`
+}
+
+func rpcHash(h [32]byte) string {
+	return hex.EncodeToString(ReverseBytes(h[:]))
+}
+
+func txView(tx *Transaction) goldenTx {
+	v := goldenTx{
+		TxID:      rpcHash(tx.TxID),
+		WTxID:     rpcHash(tx.WTxID),
+		Version:   tx.Version,
+		Segwit:    tx.Segwit,
+		SizeBytes: tx.SizeBytes,
+		Weight:    tx.Weight(),
+		LockTime:  tx.LockTime,
+		Inputs:    []goldenInput{},
+		Outputs:   []goldenOutput{},
+	}
+	for _, in := range tx.Inputs {
+		gi := goldenInput{
+			PrevTx:      rpcHash(in.PrevTxHash),
+			PrevIndex:   in.PrevIndex,
+			ScriptSig:   hex.EncodeToString(in.ScriptSig),
+			Sequence:    in.Sequence,
+			WitnessSize: in.WitnessSize,
+		}
+		for _, item := range in.Witness {
+			gi.Witness = append(gi.Witness, hex.EncodeToString(item))
+		}
+		v.Inputs = append(v.Inputs, gi)
+	}
+	for _, out := range tx.Outputs {
+		v.Outputs = append(v.Outputs, goldenOutput{
+			Value:        out.Value,
+			ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+			Address:      ExtractAddress(out.ScriptPubKey),
+		})
+	}
+	return v
+}
+
+func blockView(b *Block) goldenBlock {
+	v := goldenBlock{
+		Hash:       rpcHash(b.BlockHash),
+		Version:    b.Header.Version,
+		PrevBlock:  rpcHash(b.Header.PrevBlockHash),
+		MerkleRoot: rpcHash(b.Header.MerkleRoot),
+		Timestamp:  b.Header.Timestamp,
+		Bits:       b.Header.Bits,
+		Nonce:      b.Header.Nonce,
+		Height:     b.Height,
+		Difficulty: b.Difficulty,
+		TxCount:    len(b.Transactions),
+		TxIDs:      []string{},
+	}
+	for i, tx := range b.Transactions {
+		if i == 0 {
+			v.Coinbase = txView(tx)
+		}
+		v.TxIDs = append(v.TxIDs, rpcHash(tx.TxID))
+	}
+	return v
+}
+
+func versionView(m *VersionMessage) goldenVersion {
+	return goldenVersion{
+		Version:     m.Version,
+		Services:    m.Services,
+		Timestamp:   m.Timestamp,
+		RecvIP:      hex.EncodeToString(m.AddrRecv.IP[:]),
+		RecvPort:    m.AddrRecv.Port,
+		FromIP:      hex.EncodeToString(m.AddrFrom.IP[:]),
+		FromPort:    m.AddrFrom.Port,
+		Nonce:       m.Nonce,
+		UserAgent:   m.UserAgent,
+		StartHeight: m.StartHeight,
+		Relay:       m.Relay,
+	}
+}
+
+// corpusParsers maps each corpus subdirectory to the parser it exercises
+var corpusParsers = map[string]func([]byte) goldenResult{
+	"tx": func(payload []byte) goldenResult {
+		tx, err := ParseTxMessage(payload)
+		if err != nil {
+			return goldenResult{Error: err.Error()}
+		}
+		v := txView(tx)
+		return goldenResult{Tx: &v}
+	},
+	"block": func(payload []byte) goldenResult {
+		b, err := ParseBlockMessage(payload)
+		if err != nil {
+			return goldenResult{Error: err.Error()}
+		}
+		v := blockView(b)
+		return goldenResult{Block: &v}
+	},
+	"version": func(payload []byte) goldenResult {
+		m, err := ParseVersionMessage(payload)
+		if err != nil {
+			return goldenResult{Error: err.Error()}
+		}
+		v := versionView(m)
+		return goldenResult{Version: &v}
+	},
+}
+
+// corpusFile is a captured message payload in the corpus
+type corpusFile struct {
+	kind string
+	name string
+	path string
+}
+
+func corpusFiles(t testing.TB, kind string) []corpusFile {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(corpusDir, kind, "*.bin*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("no corpus files for %s", kind)
+	}
+	var files []corpusFile
+	for _, path := range matches {
+		name := filepath.Base(path)
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".bin")
+		files = append(files, corpusFile{kind: kind, name: name, path: path})
+	}
+	return files
+}
+
+// readPayload reads a corpus payload, transparently decompressing .gz files
+func readPayload(t testing.TB, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return data
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestGoldenCorpus(t *testing.T) {
+	for kind, parse := range corpusParsers {
+		for _, f := range corpusFiles(t, kind) {
+			t.Run(kind+"/"+f.name, func(t *testing.T) {
+				got, err := json.MarshalIndent(parse(readPayload(t, f.path)), "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, '\n')
+
+				goldenPath := filepath.Join(corpusDir, kind, f.name+".golden.json")
+				if *update {
+					if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("missing golden file (run with -update): %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("parsed output differs from %s (run with -update if intended)\ngot:\n%s", goldenPath, truncate(got, 4096))
+				}
+			})
+		}
+	}
+}
+
+// TestCorpusRoundTrip checks that well-formed transactions consume exactly
+// their payload, which catches fields the parser skips or over-reads
+func TestCorpusRoundTrip(t *testing.T) {
+	for _, f := range corpusFiles(t, "tx") {
+		if strings.HasPrefix(f.name, "malformed_") {
+			continue
+		}
+		payload := readPayload(t, f.path)
+		tx, err := ParseTxMessage(payload)
+		if err != nil {
+			t.Errorf("%s: %v", f.name, err)
+			continue
+		}
+		if tx.SizeBytes != len(payload) {
+			t.Errorf("%s: parsed %d bytes of %d", f.name, tx.SizeBytes, len(payload))
+		}
+	}
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	return append(b[:n:n], "..."...)
+}
+
+// TestCorpusEncode checks the encoders against real messages: legacy txs
+// re-encode byte for byte and block merkle roots match their headers
+func TestCorpusEncode(t *testing.T) {
+	for _, f := range corpusFiles(t, "tx") {
+		payload := readPayload(t, f.path)
+		tx, err := ParseTxMessage(payload)
+		if err != nil || tx.Segwit {
+			continue
+		}
+		want := tx.TxID
+		if got := EncodeTxMessage(tx); !bytes.Equal(got, payload) {
+			t.Errorf("%s: re-encoded tx differs from capture", f.name)
+		}
+		if tx.TxID != want {
+			t.Errorf("%s: txid changed on encode", f.name)
+		}
+	}
+
+	for _, f := range corpusFiles(t, "block") {
+		block, err := ParseBlockMessage(readPayload(t, f.path))
+		if err != nil || strings.HasPrefix(f.name, "malformed_") {
+			continue
+		}
+		txids := make([][32]byte, len(block.Transactions))
+		for i, tx := range block.Transactions {
+			txids[i] = tx.TxID
+		}
+		if MerkleRoot(txids) != block.Header.MerkleRoot {
+			t.Errorf("%s: merkle root mismatch", f.name)
+		}
+	}
+}
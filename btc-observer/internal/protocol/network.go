@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// NetworkParams describes a Bitcoin network the observer can connect to
+type NetworkParams struct {
+	Name        string
+	Magic       uint32
+	DefaultPort int
+	DNSSeeds    []string
+
+	// InitialSubsidy is the block reward in base units before the first
+	// halving; Chain.SubsidyReductionInterval sets the halving schedule
+	InitialSubsidy int64
+
+	// SkipPoWCheck accepts headers without comparing their hash to the
+	// target, for coins whose proof-of-work hash isn't sha256d
+	SkipPoWCheck bool
+
+	// Chain holds consensus parameters, address encoding and genesis
+	Chain *chaincfg.Params
+}
+
+// bitcoinSubsidy is Bitcoin's block reward before the first halving
+const bitcoinSubsidy = 50 * 100_000_000
+
+func newNetworkParams(name string, chain *chaincfg.Params) *NetworkParams {
+	port, _ := strconv.Atoi(chain.DefaultPort)
+	seeds := make([]string, len(chain.DNSSeeds))
+	for i, s := range chain.DNSSeeds {
+		seeds[i] = s.Host
+	}
+	return &NetworkParams{
+		Name:        name,
+		Magic:       uint32(chain.Net),
+		DefaultPort: port,
+		DNSSeeds:    seeds,
+		Chain:       chain,
+
+		InitialSubsidy: bitcoinSubsidy,
+	}
+}
+
+// Subsidy returns the block reward at a height, in base units
+func (p *NetworkParams) Subsidy(height int32) int64 {
+	interval := p.Chain.SubsidyReductionInterval
+	if interval <= 0 {
+		return p.InitialSubsidy
+	}
+	halvings := height / interval
+	if halvings >= 64 {
+		return 0
+	}
+	return p.InitialSubsidy >> uint(halvings)
+}
+
+// Supported networks
+var (
+	MainNet  = newNetworkParams("mainnet", &chaincfg.MainNetParams)
+	TestNet3 = newNetworkParams("testnet3", &chaincfg.TestNet3Params)
+	TestNet4 = newNetworkParams("testnet4", &chaincfg.TestNet4Params)
+	SigNet   = newNetworkParams("signet", &chaincfg.SigNetParams)
+	RegTest  = newNetworkParams("regtest", &chaincfg.RegressionNetParams)
+)
+
+var networks = map[string]*NetworkParams{
+	MainNet.Name:  MainNet,
+	TestNet3.Name: TestNet3,
+	TestNet4.Name: TestNet4,
+	SigNet.Name:   SigNet,
+	RegTest.Name:  RegTest,
+}
+
+// LookupNetwork returns the network with the given name ("" is mainnet)
+func LookupNetwork(name string) (*NetworkParams, error) {
+	if name == "" {
+		return MainNet, nil
+	}
+	if p, ok := networks[name]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(networks))
+	for n := range networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown network %q (want one of %v)", name, names)
+}
+
+var activeNetwork atomic.Pointer[NetworkParams]
+
+// SetNetwork selects the network used for message framing and address
+// decoding. Call it before connecting to any peer.
+func SetNetwork(p *NetworkParams) {
+	activeNetwork.Store(p)
+}
+
+// ActiveNetwork returns the selected network, mainnet unless SetNetwork was
+// called
+func ActiveNetwork() *NetworkParams {
+	if p := activeNetwork.Load(); p != nil {
+		return p
+	}
+	return MainNet
+}
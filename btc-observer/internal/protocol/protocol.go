@@ -1,24 +1,25 @@
 package protocol
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"time"
 
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 )
 
 // Bitcoin Protocol Constants
 const (
 	MagicMainnet       = 0xD9B4BEF9
-	ProtocolVersion    = 70015
+	ProtocolVersion    = 70016
 	ServicesNone       = 0
 	ServicesNodeNetwork = 1
 )
@@ -72,6 +73,11 @@ type TxInput struct {
 	PrevIndex  uint32
 	ScriptSig  []byte
 	Sequence   uint32
+	Witness    [][]byte // nil for non-segwit inputs
+
+	// WitnessSize is the serialized size of this input's witness, its item
+	// count included; zero for non-segwit transactions
+	WitnessSize int
 }
 
 // TxOutput represents a parsed transaction output
@@ -89,6 +95,24 @@ type Transaction struct {
 	TxID      [32]byte
 	Segwit    bool
 	SizeBytes int
+
+	// WTxID hashes the serialization including witness data (BIP141); it
+	// equals TxID for transactions received without witnesses
+	WTxID [32]byte
+
+	// WitnessBytes counts the segwit marker, flag and witness data within
+	// SizeBytes
+	WitnessBytes int
+}
+
+// Weight is the BIP141 weight: non-witness bytes count four times
+func (tx *Transaction) Weight() int {
+	return (tx.SizeBytes-tx.WitnessBytes)*3 + tx.SizeBytes
+}
+
+// VSize is the virtual size in vbytes, the weight divided by four rounded up
+func (tx *Transaction) VSize() int {
+	return (tx.Weight() + 3) / 4
 }
 
 // BlockHeader represents a parsed Bitcoin block header
@@ -108,6 +132,12 @@ type Block struct {
 	Height       int32
 	Difficulty   float64
 	Transactions []*Transaction
+
+	// DeclaredTxCount is the transaction count from the message. For a
+	// partial block ParseError is set and Transactions holds only those
+	// parsed before the failure.
+	DeclaredTxCount int
+	ParseError      string
 }
 
 // CommandString extracts the command name from a message's null-padded 12-byte field.
@@ -119,7 +149,7 @@ func CommandString(msg *Message) string {
 func CreateMessagePacket(command string, payload []byte) []byte {
 	buf := new(bytes.Buffer)
 
-	binary.Write(buf, binary.LittleEndian, uint32(MagicMainnet))
+	binary.Write(buf, binary.LittleEndian, ActiveNetwork().Magic)
 
 	cmd := [12]byte{}
 	copy(cmd[:], command)
@@ -135,8 +165,23 @@ func CreateMessagePacket(command string, payload []byte) []byte {
 	return buf.Bytes()
 }
 
-// ReadMessage reads and parses a Bitcoin protocol message from a connection.
-func ReadMessage(conn net.Conn) (*Message, error) {
+// MaxMessagePayload is the largest payload ReadMessage accepts. Anything
+// longer is a corrupted header rather than a real message.
+const MaxMessagePayload = 32 * 1024 * 1024
+
+// Framing errors from ReadMessage. After ErrChecksumMismatch the whole
+// message has been consumed and the stream is still aligned; after
+// ErrBadMagic or ErrPayloadTooLarge it is not, and Resync can find the next
+// message.
+var (
+	ErrBadMagic         = errors.New("invalid magic bytes")
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// ReadMessage reads and parses a Bitcoin protocol message from a connection
+// or any other stream of wire messages, such as a capture file.
+func ReadMessage(conn io.Reader) (*Message, error) {
 	msg := &Message{}
 
 	header := make([]byte, 24)
@@ -151,8 +196,11 @@ func ReadMessage(conn net.Conn) (*Message, error) {
 	binary.Read(buf, binary.LittleEndian, &msg.Length)
 	io.ReadFull(buf, msg.Checksum[:])
 
-	if msg.Magic != MagicMainnet {
-		return nil, fmt.Errorf("invalid magic bytes: 0x%x (expected 0x%x)", msg.Magic, MagicMainnet)
+	if magic := ActiveNetwork().Magic; msg.Magic != magic {
+		return nil, fmt.Errorf("%w: 0x%x (expected 0x%x)", ErrBadMagic, msg.Magic, magic)
+	}
+	if msg.Length > MaxMessagePayload {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, msg.Length)
 	}
 
 	if msg.Length > 0 {
@@ -163,13 +211,40 @@ func ReadMessage(conn net.Conn) (*Message, error) {
 
 		expectedChecksum := calculateChecksum(msg.Payload)
 		if !bytes.Equal(msg.Checksum[:], expectedChecksum[:]) {
-			return nil, fmt.Errorf("checksum mismatch")
+			return msg, ErrChecksumMismatch
 		}
 	}
 
 	return msg, nil
 }
 
+// Resync discards bytes from r until the next network magic, so the following
+// ReadMessage starts on a message boundary. It returns the number of bytes
+// skipped and gives up after MaxMessagePayload bytes.
+func Resync(r *bufio.Reader) (int, error) {
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], ActiveNetwork().Magic)
+
+	skipped := 0
+	for skipped < MaxMessagePayload {
+		next, err := r.Peek(4)
+		if err != nil {
+			return skipped, err
+		}
+		if bytes.Equal(next, magic[:]) {
+			return skipped, nil
+		}
+		// Skip ahead to the next possible start of the magic
+		n := len(next)
+		if i := bytes.IndexByte(next[1:], magic[0]); i >= 0 {
+			n = i + 1
+		}
+		r.Discard(n)
+		skipped += n
+	}
+	return skipped, fmt.Errorf("no message boundary within %d bytes", skipped)
+}
+
 // CreateVersionMessage builds a version message for the handshake.
 func CreateVersionMessage(peerAddr string) *VersionMessage {
 	var nonce uint64
@@ -228,7 +303,7 @@ func EncodeVersionMessage(v *VersionMessage) ([]byte, error) {
 // ParseVersionMessage parses a version message payload from a peer.
 func ParseVersionMessage(payload []byte) (*VersionMessage, error) {
 	if len(payload) < 80 {
-		return nil, fmt.Errorf("version payload too short: %d bytes", len(payload))
+		return nil, fmt.Errorf("version %w: %d bytes", ErrPayloadTooShort, len(payload))
 	}
 
 	buf := bytes.NewReader(payload)
@@ -251,7 +326,7 @@ func ParseVersionMessage(payload []byte) (*VersionMessage, error) {
 	binary.Read(buf, binary.LittleEndian, &v.Nonce)
 
 	// UserAgent is a var_str
-	uaLen, err := readVarInt(buf)
+	uaLen, err := readCount(buf, 1)
 	if err != nil {
 		return nil, fmt.Errorf("reading user agent length: %w", err)
 	}
@@ -351,11 +426,13 @@ func ParseInvMessage(payload []byte) InvResult {
 			break
 		}
 
-		switch invType {
-		case 1: // MSG_TX
+		// Witness variants (BIP144) count as their base type, and wtxid
+		// announcements (BIP339) as transactions
+		switch invType &^ InvWitnessFlag {
+		case InvTypeTx, InvTypeWTx:
 			result.TxCount++
 			result.TxVectors = append(result.TxVectors, InvVector{Type: invType, Hash: hash})
-		case 2: // MSG_BLOCK
+		case InvTypeBlock:
 			result.BlockCount++
 			result.BlockVectors = append(result.BlockVectors, InvVector{Type: invType, Hash: hash})
 		}
@@ -367,12 +444,12 @@ func ParseInvMessage(payload []byte) InvResult {
 // ParseTxMessage parses a raw Bitcoin transaction from a tx message payload.
 func ParseTxMessage(payload []byte) (*Transaction, error) {
 	buf := bytes.NewReader(payload)
-	return parseTxFromReader(buf)
+	return parseTxFromReader(buf, payload)
 }
 
-// parseTxFromReader parses a single transaction from a reader.
+// parseTxFromReader parses a single transaction from a reader over src.
 // Used by both ParseTxMessage and ParseBlockMessage.
-func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
+func parseTxFromReader(buf *bytes.Reader, src []byte) (*Transaction, error) {
 	startLen := buf.Len()
 
 	var version int32
@@ -397,7 +474,7 @@ func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
 		buf.Seek(-1, io.SeekCurrent)
 	}
 
-	inputCount, err := readVarInt(buf)
+	inputCount, err := readCount(buf, minTxInputSize)
 	if err != nil {
 		return nil, fmt.Errorf("reading input count: %w", err)
 	}
@@ -411,7 +488,10 @@ func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
 		var prevIndex uint32
 		binary.Read(buf, binary.LittleEndian, &prevIndex)
 
-		scriptLen, _ := readVarInt(buf)
+		scriptLen, err := readCount(buf, 1)
+		if err != nil {
+			return nil, fmt.Errorf("reading input %d script: %w", i, err)
+		}
 		scriptSig := make([]byte, scriptLen)
 		io.ReadFull(buf, scriptSig)
 
@@ -426,7 +506,7 @@ func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
 		}
 	}
 
-	outputCount, err := readVarInt(buf)
+	outputCount, err := readCount(buf, minTxOutputSize)
 	if err != nil {
 		return nil, fmt.Errorf("reading output count: %w", err)
 	}
@@ -436,7 +516,10 @@ func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
 		var value int64
 		binary.Read(buf, binary.LittleEndian, &value)
 
-		scriptLen, _ := readVarInt(buf)
+		scriptLen, err := readCount(buf, 1)
+		if err != nil {
+			return nil, fmt.Errorf("reading output %d script: %w", i, err)
+		}
 		scriptPubKey := make([]byte, scriptLen)
 		io.ReadFull(buf, scriptPubKey)
 
@@ -446,37 +529,62 @@ func parseTxFromReader(buf *bytes.Reader) (*Transaction, error) {
 		}
 	}
 
+	witnessBytes := 0
 	if segwit {
+		witnessStart := buf.Len()
 		for i := uint64(0); i < inputCount; i++ {
-			witnessCount, _ := readVarInt(buf)
+			inputStart := buf.Len()
+			witnessCount, err := readCount(buf, 1)
+			if err != nil {
+				return nil, fmt.Errorf("reading witness %d: %w", i, err)
+			}
+			if witnessCount > 0 {
+				inputs[i].Witness = make([][]byte, witnessCount)
+			}
 			for j := uint64(0); j < witnessCount; j++ {
-				itemLen, _ := readVarInt(buf)
-				witness := make([]byte, itemLen)
-				io.ReadFull(buf, witness)
+				itemLen, err := readCount(buf, 1)
+				if err != nil {
+					return nil, fmt.Errorf("reading witness %d item %d: %w", i, j, err)
+				}
+				item := make([]byte, itemLen)
+				io.ReadFull(buf, item)
+				inputs[i].Witness[j] = item
 			}
+			inputs[i].WitnessSize = inputStart - buf.Len()
 		}
+		witnessBytes = 2 + witnessStart - buf.Len() // marker and flag too
 	}
 
 	var lockTime uint32
 	binary.Read(buf, binary.LittleEndian, &lockTime)
 
 	txID := computeTxID(version, inputs, outputs, lockTime)
+	wtxID := txID
+	if segwit {
+		start := len(src) - startLen
+		hash1 := sha256.Sum256(src[start : len(src)-buf.Len()])
+		wtxID = sha256.Sum256(hash1[:])
+	}
 
 	return &Transaction{
-		Version:   version,
-		Inputs:    inputs,
-		Outputs:   outputs,
-		LockTime:  lockTime,
-		TxID:      txID,
-		Segwit:    segwit,
-		SizeBytes: startLen - buf.Len(),
+		Version:      version,
+		Inputs:       inputs,
+		Outputs:      outputs,
+		LockTime:     lockTime,
+		TxID:         txID,
+		Segwit:       segwit,
+		SizeBytes:    startLen - buf.Len(),
+		WTxID:        wtxID,
+		WitnessBytes: witnessBytes,
 	}, nil
 }
 
-// ParseBlockMessage parses a raw Bitcoin block message payload.
+// ParseBlockMessage parses a raw Bitcoin block message payload. Once the
+// header has been read, a failure further on returns a partial block along
+// with the error, so one unparseable transaction doesn't lose the block.
 func ParseBlockMessage(payload []byte) (*Block, error) {
 	if len(payload) < 80 {
-		return nil, fmt.Errorf("block payload too short: %d bytes", len(payload))
+		return nil, fmt.Errorf("block %w: %d bytes", ErrPayloadTooShort, len(payload))
 	}
 
 	// Compute block hash from the 80-byte header
@@ -494,32 +602,41 @@ func ParseBlockMessage(payload []byte) (*Block, error) {
 	binary.Read(buf, binary.LittleEndian, &header.Bits)
 	binary.Read(buf, binary.LittleEndian, &header.Nonce)
 
-	txCount, err := readVarInt(buf)
+	block := &Block{
+		Header:     header,
+		BlockHash:  hash2,
+		Difficulty: computeDifficulty(header.Bits),
+	}
+
+	txCount, err := readCount(buf, minTxSize)
 	if err != nil {
-		return nil, fmt.Errorf("reading tx count: %w", err)
+		err = fmt.Errorf("reading tx count: %w", err)
+		block.ParseError = err.Error()
+		return block, err
 	}
+	block.DeclaredTxCount = int(txCount)
 
-	txs := make([]*Transaction, txCount)
+	var parseErr error
+	txs := make([]*Transaction, 0, txCount)
 	for i := uint64(0); i < txCount; i++ {
-		tx, err := parseTxFromReader(buf)
+		tx, err := parseTxFromReader(buf, payload)
 		if err != nil {
-			return nil, fmt.Errorf("parsing tx %d in block: %w", i, err)
+			parseErr = fmt.Errorf("parsing tx %d in block: %w", i, err)
+			break
 		}
-		txs[i] = tx
-	}
-
-	block := &Block{
-		Header:       header,
-		BlockHash:    hash2,
-		Difficulty:   computeDifficulty(header.Bits),
-		Transactions: txs,
+		txs = append(txs, tx)
 	}
+	block.Transactions = txs
 
 	// Extract height from coinbase transaction (BIP34)
-	if len(txs) > 0 {
+	if len(txs) > 0 && len(txs[0].Inputs) > 0 {
 		block.Height = extractBlockHeight(txs[0])
 	}
 
+	if parseErr != nil {
+		block.ParseError = parseErr.Error()
+		return block, parseErr
+	}
 	return block, nil
 }
 
@@ -540,6 +657,9 @@ func extractBlockHeight(coinbase *Transaction) int32 {
 	return height
 }
 
+// MaxInvVectors is the most entries allowed in one inv or getdata message
+const MaxInvVectors = 50000
+
 // CreateGetDataPayload builds a getdata message payload from inv vectors.
 func CreateGetDataPayload(vectors []InvVector) []byte {
 	buf := new(bytes.Buffer)
@@ -551,6 +671,150 @@ func CreateGetDataPayload(vectors []InvVector) []byte {
 	return buf.Bytes()
 }
 
+// CreateInvPayload builds an inv message payload. The encoding is the same
+// as getdata.
+func CreateInvPayload(vectors []InvVector) []byte {
+	return CreateGetDataPayload(vectors)
+}
+
+// MaxHeadersPerMessage is the most headers a peer sends in one headers message
+const MaxHeadersPerMessage = 2000
+
+// HeaderEntry is one header from a headers message, with its hash
+type HeaderEntry struct {
+	Header BlockHeader
+	Hash   [32]byte
+}
+
+// CreateGetHeadersPayload builds a getheaders payload asking for the headers
+// after the first locator hash the peer knows, up to stop (zero for as many as
+// the peer will send)
+func CreateGetHeadersPayload(locator [][32]byte, stop [32]byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(ProtocolVersion))
+	writeVarInt(buf, uint64(len(locator)))
+	for _, h := range locator {
+		buf.Write(h[:])
+	}
+	buf.Write(stop[:])
+	return buf.Bytes()
+}
+
+// ParseHeadersMessage parses a headers message. Each header is followed by a
+// transaction count that is always zero.
+func ParseHeadersMessage(payload []byte) ([]HeaderEntry, error) {
+	buf := bytes.NewReader(payload)
+	count, err := readCount(buf, 81)
+	if err != nil {
+		return nil, fmt.Errorf("reading header count: %w", err)
+	}
+	if count > MaxHeadersPerMessage {
+		return nil, fmt.Errorf("too many headers: %d", count)
+	}
+
+	entries := make([]HeaderEntry, count)
+	raw := make([]byte, 80)
+	for i := range entries {
+		if _, err := io.ReadFull(buf, raw); err != nil {
+			return nil, fmt.Errorf("reading header %d: %w", i, err)
+		}
+		hash1 := sha256.Sum256(raw)
+		entries[i].Hash = sha256.Sum256(hash1[:])
+
+		entries[i].Header = decodeBlockHeader(raw)
+
+		if _, err := readVarInt(buf); err != nil {
+			return nil, fmt.Errorf("reading header %d tx count: %w", i, err)
+		}
+	}
+	return entries, nil
+}
+
+// decodeBlockHeader reads an 80-byte block header
+func decodeBlockHeader(raw []byte) BlockHeader {
+	var h BlockHeader
+	h.Version = int32(binary.LittleEndian.Uint32(raw[0:4]))
+	copy(h.PrevBlockHash[:], raw[4:36])
+	copy(h.MerkleRoot[:], raw[36:68])
+	h.Timestamp = binary.LittleEndian.Uint32(raw[68:72])
+	h.Bits = binary.LittleEndian.Uint32(raw[72:76])
+	h.Nonce = binary.LittleEndian.Uint32(raw[76:80])
+	return h
+}
+
+// EncodeTxMessage serializes a transaction without witness data, filling in
+// its TxID and SizeBytes. Segwit transactions therefore don't re-encode byte
+// for byte.
+func EncodeTxMessage(tx *Transaction) []byte {
+	raw := encodeTxNoWitness(tx.Version, tx.Inputs, tx.Outputs, tx.LockTime)
+	hash1 := sha256.Sum256(raw)
+	tx.TxID = sha256.Sum256(hash1[:])
+	tx.WTxID = tx.TxID
+	tx.SizeBytes = len(raw)
+	return raw
+}
+
+// EncodeBlockHeader serializes the 80-byte block header
+func EncodeBlockHeader(h BlockHeader) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, h.Version)
+	buf.Write(h.PrevBlockHash[:])
+	buf.Write(h.MerkleRoot[:])
+	binary.Write(buf, binary.LittleEndian, h.Timestamp)
+	binary.Write(buf, binary.LittleEndian, h.Bits)
+	binary.Write(buf, binary.LittleEndian, h.Nonce)
+	return buf.Bytes()
+}
+
+// EncodeBlockMessage serializes a block from its header and transactions,
+// filling in the merkle root and block hash
+func EncodeBlockMessage(block *Block) []byte {
+	txids := make([][32]byte, len(block.Transactions))
+	var body bytes.Buffer
+	writeVarInt(&body, uint64(len(block.Transactions)))
+	for i, tx := range block.Transactions {
+		body.Write(EncodeTxMessage(tx))
+		txids[i] = tx.TxID
+	}
+	block.Header.MerkleRoot = MerkleRoot(txids)
+
+	header := EncodeBlockHeader(block.Header)
+	hash1 := sha256.Sum256(header)
+	block.BlockHash = sha256.Sum256(hash1[:])
+	return append(header, body.Bytes()...)
+}
+
+// MerkleRoot computes the merkle root of txids (internal byte order)
+func MerkleRoot(txids [][32]byte) [32]byte {
+	if len(txids) == 0 {
+		return [32]byte{}
+	}
+	level := append([][32]byte(nil), txids...)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			var pair [64]byte
+			copy(pair[:32], level[2*i][:])
+			copy(pair[32:], level[2*i+1][:])
+			hash1 := sha256.Sum256(pair[:])
+			next[i] = sha256.Sum256(hash1[:])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// CreateFeeFilterPayload builds a feefilter (BIP133) payload asking the peer
+// not to announce transactions below feeRate satoshis per kilobyte.
+func CreateFeeFilterPayload(feeRate int64) []byte {
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint64(payload, uint64(feeRate))
+	return payload
+}
+
 // CountAddresses counts addresses in an addr message.
 func CountAddresses(payload []byte) int {
 	buf := bytes.NewReader(payload)
@@ -573,7 +837,7 @@ func ReverseBytes(b []byte) []byte {
 // ExtractAddress decodes a scriptPubKey into a Bitcoin address string.
 // Returns "" for non-standard or unparseable scripts.
 func ExtractAddress(scriptPubKey []byte) string {
-	_, addrs, _, err := txscript.ExtractPkScriptAddrs(scriptPubKey, &chaincfg.MainNetParams)
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(scriptPubKey, ActiveNetwork().Chain)
 	if err != nil || len(addrs) == 0 {
 		return ""
 	}
@@ -603,6 +867,14 @@ func calculateChecksum(data []byte) [4]byte {
 }
 
 func computeTxID(version int32, inputs []TxInput, outputs []TxOutput, lockTime uint32) [32]byte {
+	raw := encodeTxNoWitness(version, inputs, outputs, lockTime)
+	hash1 := sha256.Sum256(raw)
+	hash2 := sha256.Sum256(hash1[:])
+	return hash2
+}
+
+// encodeTxNoWitness serializes a tx in the legacy (txid) format
+func encodeTxNoWitness(version int32, inputs []TxInput, outputs []TxOutput, lockTime uint32) []byte {
 	buf := new(bytes.Buffer)
 
 	binary.Write(buf, binary.LittleEndian, version)
@@ -625,9 +897,7 @@ func computeTxID(version int32, inputs []TxInput, outputs []TxOutput, lockTime u
 
 	binary.Write(buf, binary.LittleEndian, lockTime)
 
-	hash1 := sha256.Sum256(buf.Bytes())
-	hash2 := sha256.Sum256(hash1[:])
-	return hash2
+	return buf.Bytes()
 }
 
 func writeVarInt(buf *bytes.Buffer, value uint64) {
@@ -669,6 +939,55 @@ func readVarInt(r io.Reader) (uint64, error) {
 	}
 }
 
+// Minimum encoded sizes, used to reject counts that can't fit in a payload
+const (
+	minTxInputSize  = 41 // prev outpoint + empty script + sequence
+	minTxOutputSize = 9  // value + empty script
+	minTxSize       = 10 // version + no inputs + no outputs + locktime
+)
+
+// readCount reads a var_int item count and rejects it if that many items of
+// at least minItemSize bytes could not fit in what is left of the payload.
+// This keeps malformed or hostile messages from triggering huge allocations.
+func readCount(buf *bytes.Reader, minItemSize int) (uint64, error) {
+	count, err := readVarInt(buf)
+	if err != nil {
+		return 0, err
+	}
+	if count > uint64(buf.Len()/minItemSize) {
+		return 0, &CountError{Count: count, Remaining: buf.Len()}
+	}
+	return count, nil
+}
+
+// CountError reports a count or length prefix too large for the bytes left
+// in the payload
+type CountError struct {
+	Count     uint64
+	Remaining int
+}
+
+func (e *CountError) Error() string {
+	return fmt.Sprintf("count %d exceeds remaining %d bytes", e.Count, e.Remaining)
+}
+
+// ErrPayloadTooShort is returned for payloads shorter than their fixed part
+var ErrPayloadTooShort = errors.New("payload too short")
+
+// ParseErrorClass buckets a parse error for telemetry: "truncated" when the
+// payload ended early, "bad_count" when a count or length couldn't fit in it,
+// and "other" otherwise
+func ParseErrorClass(err error) string {
+	var countErr *CountError
+	switch {
+	case errors.As(err, &countErr):
+		return "bad_count"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrPayloadTooShort):
+		return "truncated"
+	}
+	return "other"
+}
+
 func writeVarString(buf *bytes.Buffer, s string) {
 	writeVarInt(buf, uint64(len(s)))
 	buf.WriteString(s)
@@ -0,0 +1,204 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// Config configures the ClickHouse writer
+type Config struct {
+	URL             string `json:"url"` // HTTP interface, e.g. http://clickhouse:8123
+	Database        string `json:"database"`
+	User            string `json:"user"`
+	Password        string `json:"password"`
+	BatchSize       int    `json:"batch_size"`
+	FlushIntervalMs int    `json:"flush_interval_ms"`
+	Buffer          int    `json:"buffer"` // events queued before some are dropped
+
+	// SkipPostgres stops writing propagation events to Postgres, leaving
+	// ClickHouse the only copy; first-seen observations are still written
+	SkipPostgres bool `json:"skip_postgres"`
+}
+
+func (c *Config) applyDefaults() {
+	if c.Database == "" {
+		c.Database = "btc_observer"
+	}
+	if c.User == "" {
+		c.User = "default"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 10000
+	}
+	if c.FlushIntervalMs <= 0 {
+		c.FlushIntervalMs = 1000
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = 100000
+	}
+}
+
+// identifier matches database names that are safe to use unquoted
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// schema creates the append-only tables. propagation_events is sorted by tx
+// so per-tx delays are cheap; transaction_observations keeps each tx's first
+// sighting and announcement count, merged in the background.
+var schema = []string{
+	`CREATE DATABASE IF NOT EXISTS %[1]s`,
+	`CREATE TABLE IF NOT EXISTS %[1]s.propagation_events (
+	     tx_hash      FixedString(64),
+	     peer_addr    String,
+	     region       LowCardinality(String),
+	     observer_id  LowCardinality(String),
+	     announced_at DateTime64(3, 'UTC')
+	 ) ENGINE = MergeTree
+	 PARTITION BY toYYYYMMDD(announced_at)
+	 ORDER BY (tx_hash, announced_at)`,
+	`CREATE TABLE IF NOT EXISTS %[1]s.transaction_observations (
+	     tx_hash       FixedString(64),
+	     first_seen_at SimpleAggregateFunction(min, DateTime64(3, 'UTC')),
+	     peer_count    SimpleAggregateFunction(sum, UInt64)
+	 ) ENGINE = AggregatingMergeTree
+	 ORDER BY tx_hash`,
+	`CREATE MATERIALIZED VIEW IF NOT EXISTS %[1]s.transaction_observations_mv
+	 TO %[1]s.transaction_observations AS
+	 SELECT tx_hash, min(announced_at) AS first_seen_at, toUInt64(count()) AS peer_count
+	 FROM %[1]s.propagation_events
+	 GROUP BY tx_hash`,
+}
+
+// propagationRow is a propagation_events row in JSONEachRow format
+type propagationRow struct {
+	TxHash      events.Hash `json:"tx_hash"`
+	PeerAddr    string      `json:"peer_addr"`
+	Region      string      `json:"region"`
+	ObserverID  string      `json:"observer_id"`
+	AnnouncedAt string      `json:"announced_at"`
+}
+
+type writer struct {
+	cfg    Config
+	client *http.Client
+}
+
+// Start creates the ClickHouse tables if needed and writes every tx
+// announcement to propagation_events until ctx is done, then inserts the
+// announcements still queued before marking wg done. Rows are inserted in
+// batches; a batch that fails is counted and dropped.
+func Start(ctx context.Context, cfg Config, observerID string, wg *sync.WaitGroup) error {
+	cfg.applyDefaults()
+	if cfg.URL == "" {
+		return fmt.Errorf("clickhouse writer requires a url")
+	}
+	if !identifier.MatchString(cfg.Database) {
+		return fmt.Errorf("invalid clickhouse database name %q", cfg.Database)
+	}
+	w := &writer{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+	for _, stmt := range schema {
+		if err := w.exec(ctx, fmt.Sprintf(stmt, cfg.Database), nil); err != nil {
+			return fmt.Errorf("create clickhouse schema: %w", err)
+		}
+	}
+
+	ch, unsubscribe := events.Subscribe("clickhouse", cfg.Buffer)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer unsubscribe()
+		ticker := time.NewTicker(time.Duration(cfg.FlushIntervalMs) * time.Millisecond)
+		defer ticker.Stop()
+		var batch bytes.Buffer
+		rows := 0
+		flush := func(ctx context.Context) {
+			if rows == 0 {
+				return
+			}
+			query := fmt.Sprintf("INSERT INTO %s.propagation_events FORMAT JSONEachRow", cfg.Database)
+			result := "ok"
+			if err := w.exec(ctx, query, batch.Bytes()); err != nil {
+				result = "error"
+				logger.Log.Warn().Err(err).Int("rows", rows).Msg("ClickHouse insert failed")
+			}
+			metrics.ClickHouseRows.WithLabelValues("propagation_events", result).Add(float64(rows))
+			batch.Reset()
+			rows = 0
+		}
+		enc := json.NewEncoder(&batch)
+		add := func(ctx context.Context, e events.Event) {
+			a, ok := e.Data.(events.TxAnnouncement)
+			if !ok {
+				return
+			}
+			enc.Encode(propagationRow{
+				TxHash:      a.TxHash,
+				PeerAddr:    a.Peer,
+				Region:      a.Region,
+				ObserverID:  observerID,
+				AnnouncedAt: e.Time.UTC().Format("2006-01-02 15:04:05.000"),
+			})
+			if rows++; rows >= cfg.BatchSize {
+				flush(ctx)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				for drained := false; !drained; {
+					select {
+					case e := <-ch:
+						add(flushCtx, e)
+					default:
+						drained = true
+					}
+				}
+				flush(flushCtx)
+				cancel()
+				return
+			case <-ticker.C:
+				flush(ctx)
+			case e := <-ch:
+				add(ctx, e)
+			}
+		}
+	}()
+	return nil
+}
+
+// exec runs a query over the HTTP interface, with body as its data
+func (w *writer) exec(ctx context.Context, query string, body []byte) error {
+	u := strings.TrimRight(w.cfg.URL, "/") + "/?query=" + url.QueryEscape(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", w.cfg.User)
+	if w.cfg.Password != "" {
+		req.Header.Set("X-ClickHouse-Key", w.cfg.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const avroNamespace = "io.blocklens.observer"
+
+// encodeAvro encodes the record in Avro binary encoding, without framing. Readers
+// use the schema named by the message's schema header; see AvroSchemas.
+func (r record) encodeAvro() ([]byte, error) {
+	var buf []byte
+	for _, f := range r.fields {
+		switch v := f.value.(type) {
+		case string:
+			buf = avroString(buf, v)
+		case int64:
+			buf = binary.AppendVarint(buf, v) // zigzag, as Avro longs are
+		case float64:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		case bool:
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case timestamp:
+			buf = binary.AppendVarint(buf, time.Time(v).UnixMicro())
+		case []string:
+			// One block holding every item, then the zero-length end block
+			if len(v) > 0 {
+				buf = binary.AppendVarint(buf, int64(len(v)))
+				for _, s := range v {
+					buf = avroString(buf, s)
+				}
+			}
+			buf = binary.AppendVarint(buf, 0)
+		default:
+			return nil, fmt.Errorf("field %s: no Avro encoding for %T", f.name, f.value)
+		}
+	}
+	return buf, nil
+}
+
+func avroString(buf []byte, s string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+// avroSchema returns the Avro schema (JSON) of the record
+func (r record) avroSchema() (string, error) {
+	fields := make([]map[string]interface{}, len(r.fields))
+	for i, f := range r.fields {
+		var t interface{}
+		switch f.value.(type) {
+		case string:
+			t = "string"
+		case int64:
+			t = "long"
+		case float64:
+			t = "double"
+		case bool:
+			t = "boolean"
+		case timestamp:
+			t = map[string]string{"type": "long", "logicalType": "timestamp-micros"}
+		case []string:
+			t = map[string]string{"type": "array", "items": "string"}
+		default:
+			return "", fmt.Errorf("field %s: no Avro type for %T", f.name, f.value)
+		}
+		fields[i] = map[string]interface{}{"name": f.name, "type": t}
+	}
+	schema, err := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      fmt.Sprintf("%s_v%d", r.schema, SchemaVersion),
+		"namespace": avroNamespace,
+		"fields":    fields,
+	})
+	return string(schema), err
+}
@@ -0,0 +1,195 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Topic kinds, each configured with its own Kafka topic
+const (
+	topicTransactions = "transactions" // tx_received, double_spend_detected, fee_outlier
+	topicBlocks       = "blocks"       // block_received, chain_reorg
+	topicPropagation  = "propagation"  // tx_announced, one per peer per tx
+)
+
+// Payload formats
+const (
+	FormatJSON = "json"
+	FormatAvro = "avro"
+)
+
+// Config configures the Kafka publisher
+type Config struct {
+	Brokers        []string `json:"brokers"`
+	Topics         Topics   `json:"topics"`
+	Format         string   `json:"format"` // json (default) or avro
+	BatchSize      int      `json:"batch_size"`
+	BatchTimeoutMs int      `json:"batch_timeout_ms"`
+	Buffer         int      `json:"buffer"` // events queued before some are dropped
+}
+
+// Topics names the Kafka topic for each kind of event; an empty name turns
+// that kind off
+type Topics struct {
+	Transactions string `json:"transactions"`
+	Blocks       string `json:"blocks"`
+	Propagation  string `json:"propagation"`
+}
+
+func (c *Config) applyDefaults() {
+	if c.Format == "" {
+		c.Format = FormatJSON
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 500
+	}
+	if c.BatchTimeoutMs <= 0 {
+		c.BatchTimeoutMs = 200
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = 10000
+	}
+}
+
+// Start publishes observation events to Kafka until ctx is done, then
+// publishes the events still queued and flushes the writer before marking
+// wg done. Messages are written asynchronously; delivery failures are
+// counted and logged, not retried beyond the client's own retries.
+func Start(ctx context.Context, cfg Config, observerID string, wg *sync.WaitGroup) error {
+	cfg.applyDefaults()
+	if len(cfg.Brokers) == 0 {
+		return fmt.Errorf("kafka publisher requires brokers")
+	}
+	if cfg.Format != FormatJSON && cfg.Format != FormatAvro {
+		return fmt.Errorf("unknown kafka format %q (want json or avro)", cfg.Format)
+	}
+	topics := map[string]string{
+		topicTransactions: cfg.Topics.Transactions,
+		topicBlocks:       cfg.Topics.Blocks,
+		topicPropagation:  cfg.Topics.Propagation,
+	}
+	enabled := false
+	for _, t := range topics {
+		enabled = enabled || t != ""
+	}
+	if !enabled {
+		return fmt.Errorf("kafka publisher requires at least one topic")
+	}
+
+	w := &kafkago.Writer{
+		Addr:         kafkago.TCP(cfg.Brokers...),
+		Balancer:     &kafkago.Hash{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: time.Duration(cfg.BatchTimeoutMs) * time.Millisecond,
+		RequiredAcks: kafkago.RequireOne,
+		Compression:  kafkago.Snappy,
+		Async:        true,
+		Completion: func(messages []kafkago.Message, err error) {
+			result := "ok"
+			if err != nil {
+				result = "error"
+				logger.Log.Warn().Err(err).Int("messages", len(messages)).Msg("Kafka publish failed")
+			}
+			for _, m := range messages {
+				metrics.KafkaMessages.WithLabelValues(m.Topic, result).Inc()
+			}
+		},
+	}
+
+	publish := func(ctx context.Context, e events.Event) {
+		kind, r, ok := toRecord(e, observerID)
+		if !ok || topics[kind] == "" {
+			return
+		}
+		msg, err := message(r, topics[kind], cfg.Format)
+		if err != nil {
+			logger.Log.Warn().Err(err).Str("schema", r.schema).Msg("Failed to encode Kafka message")
+			return
+		}
+		if err := w.WriteMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			logger.Log.Warn().Err(err).Msg("Kafka publish failed")
+		}
+	}
+
+	ch, unsubscribe := events.Subscribe("kafka", cfg.Buffer)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				for drained := false; !drained; {
+					select {
+					case e := <-ch:
+						publish(context.Background(), e)
+					default:
+						drained = true
+					}
+				}
+				if err := w.Close(); err != nil {
+					logger.Log.Warn().Err(err).Msg("Failed to flush Kafka publisher")
+				}
+				return
+			case e := <-ch:
+				publish(ctx, e)
+			}
+		}
+	}()
+	return nil
+}
+
+func message(r record, topic, format string) (kafkago.Message, error) {
+	var value []byte
+	var err error
+	contentType := "application/json"
+	if format == FormatAvro {
+		value, err = r.encodeAvro()
+		contentType = "avro/binary"
+	} else {
+		value, err = r.encodeJSON()
+	}
+	if err != nil {
+		return kafkago.Message{}, err
+	}
+	return kafkago.Message{
+		Topic: topic,
+		Key:   r.key,
+		Value: value,
+		Headers: []kafkago.Header{
+			{Key: "schema", Value: []byte(r.schema)},
+			{Key: "schema_version", Value: []byte(strconv.Itoa(SchemaVersion))},
+			{Key: "content_type", Value: []byte(contentType)},
+		},
+	}, nil
+}
+
+// AvroSchemas returns the Avro schema of each published record, by schema name
+func AvroSchemas() (map[string]string, error) {
+	samples := []events.Event{
+		{Type: events.TxReceived, Data: events.TxReceipt{}},
+		{Type: events.DoubleSpendDetected, Data: events.DoubleSpend{}},
+		{Type: events.FeeOutlier, Data: events.FeeAlert{}},
+		{Type: events.BlockReceived, Data: events.BlockArrival{}},
+		{Type: events.ChainReorg, Data: events.Reorg{}},
+		{Type: events.TxAnnounced, Data: events.TxAnnouncement{}},
+	}
+	schemas := make(map[string]string)
+	for _, e := range samples {
+		_, r, _ := toRecord(e, "")
+		s, err := r.avroSchema()
+		if err != nil {
+			return nil, err
+		}
+		schemas[r.schema] = s
+	}
+	return schemas, nil
+}
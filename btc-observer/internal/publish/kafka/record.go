@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+)
+
+// SchemaVersion is bumped whenever a record's fields change. Consumers find
+// it in every message's schema_version header and JSON payload.
+const SchemaVersion = 1
+
+// record is an event flattened for publishing. The same fields produce the
+// JSON payload and the Avro payload and schema.
+type record struct {
+	schema string
+	key    []byte // partition key, so a tx or block's messages stay ordered
+	fields []field
+}
+
+// field values are string, int64, float64, bool, []string or timestamp
+type field struct {
+	name  string
+	value interface{}
+}
+
+// timestamp is encoded as microseconds since the epoch
+type timestamp time.Time
+
+func hashString(h events.Hash) string {
+	b, _ := h.MarshalJSON()
+	return string(b[1 : len(b)-1])
+}
+
+// toRecord flattens the events that are published; ok is false for the rest
+func toRecord(e events.Event, observerID string) (topic string, r record, ok bool) {
+	common := []field{
+		{"observer", observerID},
+		{"time", timestamp(e.Time)},
+	}
+	switch d := e.Data.(type) {
+	case events.TxReceipt:
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"peer", d.Peer},
+			field{"region", d.Region},
+			field{"txid", hashString(d.TxID)},
+			field{"size", int64(d.Size)},
+			field{"inputs", int64(d.Inputs)},
+			field{"outputs", int64(d.Outputs)},
+			field{"output_value", d.OutputValue},
+			field{"segwit", d.Segwit},
+		)}
+		r.key = d.TxID[:]
+		return topicTransactions, r, true
+	case events.DoubleSpend:
+		conflicts := make([]string, len(d.Conflicts))
+		for i, c := range d.Conflicts {
+			conflicts[i] = hashString(c)
+		}
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"peer", d.Peer},
+			field{"region", d.Region},
+			field{"txid", hashString(d.TxID)},
+			field{"conflicts", conflicts},
+		)}
+		r.key = d.TxID[:]
+		return topicTransactions, r, true
+	case events.FeeAlert:
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"txid", hashString(d.TxID)},
+			field{"reason", d.Reason},
+			field{"fee_satoshis", d.FeeSatoshis},
+			field{"fee_rate", d.FeeRate},
+			field{"first_seen_at", timestamp(d.FirstSeenAt)},
+			field{"first_peer", d.FirstPeer},
+			field{"first_region", d.FirstRegion},
+			field{"peer_count", int64(d.PeerCount)},
+			field{"region_count", int64(d.RegionCount)},
+		)}
+		r.key = d.TxID[:]
+		return topicTransactions, r, true
+	case events.BlockArrival:
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"peer", d.Peer},
+			field{"region", d.Region},
+			field{"hash", hashString(d.Hash)},
+			field{"height", int64(d.Height)},
+			field{"tx_count", int64(d.TxCount)},
+		)}
+		r.key = d.Hash[:]
+		return topicBlocks, r, true
+	case events.Reorg:
+		orphaned := make([]string, len(d.Orphaned))
+		for i, h := range d.Orphaned {
+			orphaned[i] = hashString(h)
+		}
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"fork_height", int64(d.ForkHeight)},
+			field{"fork_hash", hashString(d.ForkHash)},
+			field{"old_tip_height", int64(d.OldTipHeight)},
+			field{"old_tip_hash", hashString(d.OldTipHash)},
+			field{"new_tip_height", int64(d.NewTipHeight)},
+			field{"new_tip_hash", hashString(d.NewTipHash)},
+			field{"orphaned", orphaned},
+		)}
+		r.key = d.ForkHash[:]
+		return topicBlocks, r, true
+	case events.TxAnnouncement:
+		r = record{schema: string(e.Type), fields: append(common,
+			field{"peer", d.Peer},
+			field{"region", d.Region},
+			field{"txid", hashString(d.TxHash)},
+		)}
+		r.key = d.TxHash[:]
+		return topicPropagation, r, true
+	}
+	return "", record{}, false
+}
+
+// encodeJSON encodes the record as an object, led by its schema name and version
+func (r record) encodeJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(r.fields)+2)
+	m["schema"] = r.schema
+	m["schema_version"] = SchemaVersion
+	for _, f := range r.fields {
+		if t, ok := f.value.(timestamp); ok {
+			m[f.name] = time.Time(t).UTC().Format(time.RFC3339Nano)
+			continue
+		}
+		m[f.name] = f.value
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,104 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const defaultInterval = time.Hour
+
+// Config sets how many days each kind of data is kept; 0 keeps it forever
+type Config struct {
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// PropagationEventsDays keeps raw propagation events this many days
+	PropagationEventsDays int `json:"propagation_events_days"`
+
+	// PeerObservationsDays keeps each peer's first sighting of a
+	// transaction this many days
+	PeerObservationsDays int `json:"peer_observations_days"`
+
+	// UnconfirmedTxDays keeps observations of transactions that never
+	// confirmed this many days after they were first seen
+	UnconfirmedTxDays int `json:"unconfirmed_tx_days"`
+
+	// ScriptDays keeps the raw input and output scripts of confirmed
+	// transactions this many days after their block
+	ScriptDays int `json:"script_days"`
+}
+
+// policy is one kind of data the job prunes
+type policy struct {
+	kind  string
+	days  int
+	prune func(before time.Time) (int64, error)
+}
+
+// Start prunes data past its retention on an interval
+func Start(ctx context.Context, db *database.DB, cfg Config) error {
+	if cfg.PropagationEventsDays < 0 || cfg.PeerObservationsDays < 0 || cfg.UnconfirmedTxDays < 0 || cfg.ScriptDays < 0 {
+		return fmt.Errorf("retention days must not be negative")
+	}
+	var policies []policy
+	for _, p := range []policy{
+		{"propagation_events", cfg.PropagationEventsDays, db.PrunePropagationEvents},
+		{"peer_observations", cfg.PeerObservationsDays, db.PrunePeerObservations},
+		{"unconfirmed_observations", cfg.UnconfirmedTxDays, db.PruneUnconfirmedObservations},
+		{"scripts", cfg.ScriptDays, db.PruneScripts},
+	} {
+		if p.days > 0 {
+			policies = append(policies, p)
+		}
+	}
+	if len(policies) == 0 {
+		return fmt.Errorf("retention has nothing to prune; set at least one of the day counts")
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			run(ctx, policies)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func run(ctx context.Context, policies []policy) {
+	now := time.Now().UTC()
+	for _, p := range policies {
+		if ctx.Err() != nil {
+			return
+		}
+		cutoff := now.AddDate(0, 0, -p.days)
+		start := time.Now()
+		n, err := p.prune(cutoff)
+		if err != nil {
+			logger.Log.Error().Err(err).Str("kind", p.kind).Msg("Retention pruning failed")
+			continue
+		}
+		metrics.RetentionRowsPruned.WithLabelValues(p.kind).Add(float64(n))
+		if n > 0 {
+			logger.Log.Info().
+				Str("kind", p.kind).
+				Int64("rows", n).
+				Time("before", cutoff).
+				Dur("took", time.Since(start)).
+				Msg("Pruned data past retention")
+		}
+	}
+}
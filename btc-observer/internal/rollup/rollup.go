@@ -0,0 +1,128 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+)
+
+const (
+	defaultInterval     = 5 * time.Minute
+	defaultBackfillDays = 7
+)
+
+// Config configures the observation rollup jobs
+type Config struct {
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// BackfillDays bounds how far back buckets are computed when no rollups
+	// exist yet (default 7)
+	BackfillDays int `json:"backfill_days"`
+
+	// PruneAfterDays deletes raw propagation events older than this many
+	// days once their day is rolled up (0 keeps them)
+	PruneAfterDays int `json:"prune_after_days"`
+}
+
+// granularity is a rollup bucket size
+type granularity struct {
+	name     string
+	truncate func(time.Time) time.Time
+	next     func(time.Time) time.Time
+}
+
+var granularities = []granularity{
+	{
+		name:     "hour",
+		truncate: func(t time.Time) time.Time { return t.Truncate(time.Hour) },
+		next:     func(t time.Time) time.Time { return t.Add(time.Hour) },
+	},
+	{
+		name: "day",
+		truncate: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+}
+
+// Start runs the rollup jobs on an interval. Each run recomputes every bucket
+// from the newest stored one (which may have been partial) up to the current
+// one, so rollups catch up after downtime and stay idempotent.
+func Start(ctx context.Context, db *database.DB, cfg Config) error {
+	if cfg.PruneAfterDays < 0 || cfg.BackfillDays < 0 {
+		return fmt.Errorf("rollup day counts must not be negative")
+	}
+	if cfg.BackfillDays == 0 {
+		cfg.BackfillDays = defaultBackfillDays
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			run(ctx, db, cfg)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func run(ctx context.Context, db *database.DB, cfg Config) {
+	now := time.Now().UTC()
+	for _, g := range granularities {
+		if err := rollup(ctx, db, g, now, cfg.BackfillDays); err != nil {
+			logger.Log.Error().Err(err).Str("granularity", g.name).Msg("Rollup failed")
+			return
+		}
+	}
+
+	// Day buckets before today were finalized by this run, so their raw
+	// events are safe to drop
+	if cfg.PruneAfterDays > 0 {
+		cutoff := granularities[1].truncate(now).AddDate(0, 0, -cfg.PruneAfterDays)
+		n, err := db.PrunePropagationEvents(cutoff)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Pruning propagation events failed")
+			return
+		}
+		if n > 0 {
+			logger.Log.Info().Int64("rows", n).Time("before", cutoff).Msg("Pruned rolled-up propagation events")
+		}
+	}
+}
+
+func rollup(ctx context.Context, db *database.DB, g granularity, now time.Time, backfillDays int) error {
+	from := g.truncate(now.AddDate(0, 0, -backfillDays))
+	latest, ok, err := db.LatestRollupBucket(g.name)
+	if err != nil {
+		return err
+	}
+	if ok && latest.After(from) {
+		from = g.truncate(latest.UTC())
+	}
+
+	buckets := 0
+	for start := from; !start.After(now); start = g.next(start) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := db.RollupBucket(g.name, start, g.next(start)); err != nil {
+			return err
+		}
+		buckets++
+	}
+	logger.Log.Debug().Str("granularity", g.name).Int("buckets", buckets).Time("from", from).Msg("Rollups updated")
+	return nil
+}
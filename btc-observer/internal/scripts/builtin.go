@@ -0,0 +1,78 @@
+package scripts
+
+// builtinTemplates are matched unless config overrides them by name
+var builtinTemplates = []Definition{
+	// Lightning (BOLT 3)
+	{
+		Name:      "ln_funding_2of2",
+		Pattern:   "OP_2 <pubkey> <pubkey> OP_2 OP_CHECKMULTISIG",
+		Locations: []string{LocationP2WSH},
+	},
+	{
+		Name:      "ln_to_local",
+		Pattern:   "OP_IF <pubkey> OP_ELSE <num> OP_CHECKSEQUENCEVERIFY OP_DROP <pubkey> OP_ENDIF OP_CHECKSIG",
+		Locations: []string{LocationP2WSH},
+	},
+	{
+		Name: "ln_offered_htlc",
+		Pattern: "OP_DUP OP_HASH160 <hash20> OP_EQUAL OP_IF OP_CHECKSIG OP_ELSE <pubkey> OP_SWAP OP_SIZE <num> OP_EQUAL " +
+			"OP_NOTIF OP_DROP OP_2 OP_SWAP <pubkey> OP_2 OP_CHECKMULTISIG OP_ELSE OP_HASH160 <hash20> OP_EQUALVERIFY OP_CHECKSIG OP_ENDIF ...",
+		Locations: []string{LocationP2WSH},
+	},
+	{
+		Name: "ln_received_htlc",
+		Pattern: "OP_DUP OP_HASH160 <hash20> OP_EQUAL OP_IF OP_CHECKSIG OP_ELSE <pubkey> OP_SWAP OP_SIZE <num> OP_EQUAL " +
+			"OP_IF OP_HASH160 <hash20> OP_EQUALVERIFY OP_2 OP_SWAP <pubkey> OP_2 OP_CHECKMULTISIG OP_ELSE OP_DROP <num> OP_CHECKLOCKTIMEVERIFY ...",
+		Locations: []string{LocationP2WSH},
+	},
+
+	// Atomic swap style HTLCs (hashlock to one key, timelock back to another)
+	{
+		Name:    "htlc_sha256_cltv",
+		Pattern: "OP_IF OP_SHA256 <hash32> OP_EQUALVERIFY <pubkey> OP_ELSE <num> OP_CHECKLOCKTIMEVERIFY OP_DROP <pubkey> OP_ENDIF OP_CHECKSIG",
+	},
+	{
+		Name:    "htlc_hash160_cltv",
+		Pattern: "OP_IF OP_HASH160 <hash20> OP_EQUALVERIFY <pubkey> OP_ELSE <num> OP_CHECKLOCKTIMEVERIFY OP_DROP <pubkey> OP_ENDIF OP_CHECKSIG",
+	},
+
+	// Timelocked single-key vaults
+	{
+		Name:    "cltv_vault",
+		Pattern: "<num> OP_CHECKLOCKTIMEVERIFY OP_DROP <pubkey> OP_CHECKSIG",
+	},
+	{
+		Name:    "csv_vault",
+		Pattern: "<num> OP_CHECKSEQUENCEVERIFY OP_DROP <pubkey> OP_CHECKSIG",
+	},
+
+	// Tapscript leaves used by Ark-style and covenant protocols: a
+	// collaborative 2-of-2 path and a unilateral exit after a relative delay
+	{
+		Name:      "tapscript_2of2",
+		Pattern:   "<xonly> OP_CHECKSIGVERIFY <xonly> OP_CHECKSIG",
+		Locations: []string{LocationTapscript},
+	},
+	{
+		Name:      "tapscript_csv_exit",
+		Pattern:   "<num> OP_CHECKSEQUENCEVERIFY OP_DROP <xonly> OP_CHECKSIG",
+		Locations: []string{LocationTapscript},
+	},
+	{
+		Name:      "tapscript_cltv",
+		Pattern:   "<num> OP_CHECKLOCKTIMEVERIFY OP_DROP <xonly> OP_CHECKSIG",
+		Locations: []string{LocationTapscript},
+	},
+
+	// OP_RETURN protocol markers
+	{
+		Name:      "runestone",
+		Pattern:   "OP_RETURN OP_13 ...",
+		Locations: []string{LocationOutput},
+	},
+	{
+		Name:      "omni",
+		Pattern:   "OP_RETURN <data:6f6d6e69>",
+		Locations: []string{LocationOutput},
+	},
+}
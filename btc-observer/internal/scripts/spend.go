@@ -0,0 +1,229 @@
+package scripts
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Spend types, told apart from an input's scriptSig and witness alone
+const (
+	SpendCoinbase     = "coinbase"
+	SpendP2PK         = "p2pk"
+	SpendP2PKH        = "p2pkh"
+	SpendBareMultisig = "bare_multisig"
+	SpendP2SH         = "p2sh"
+	SpendP2SHP2WPKH   = "p2sh_p2wpkh"
+	SpendP2SHP2WSH    = "p2sh_p2wsh"
+	SpendP2WPKH       = "p2wpkh"
+	SpendP2WSH        = "p2wsh"
+	SpendTaprootKey   = "p2tr_key"
+	SpendTaprootPath  = "p2tr_script"
+	SpendUnknown      = "unknown"
+)
+
+// Spend is how an input spends its previous output
+type Spend struct {
+	Type string
+	// M and N are the threshold and key count when the revealed script is
+	// an m-of-n multisig (CHECKMULTISIG, or CHECKSIGADD in tapscript); N is
+	// 0 for a bare multisig spend, whose keys are only in the prevout
+	M, N int
+}
+
+// Multisig formats the threshold as "m-of-n", or "" when it isn't one
+func (s Spend) Multisig() string {
+	switch {
+	case s.M == 0:
+		return ""
+	case s.N == 0:
+		return fmt.Sprintf("%d-of-?", s.M)
+	default:
+		return fmt.Sprintf("%d-of-%d", s.M, s.N)
+	}
+}
+
+// ClassifySpends counts a transaction's inputs by spend type
+func ClassifySpends(tx *protocol.Transaction) map[Spend]int {
+	counts := make(map[Spend]int)
+	for _, in := range tx.Inputs {
+		counts[ClassifySpend(in)]++
+	}
+	return counts
+}
+
+// ClassifySpend tells the spend type from what the input reveals, without
+// the previous output. Witness programs nested in P2SH are recognised by
+// their redeem script; a P2SH redeem script or witness script that is a
+// multisig also gives its threshold.
+func ClassifySpend(in protocol.TxInput) Spend {
+	if in.PrevIndex == 0xffffffff && in.PrevTxHash == [32]byte{} {
+		return Spend{Type: SpendCoinbase}
+	}
+	witness := in.Witness
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == 0x50 {
+		witness = witness[:len(witness)-1]
+	}
+
+	if len(in.ScriptSig) == 0 && len(witness) > 0 {
+		return classifyWitness(witness)
+	}
+	items, ok := pushes(in.ScriptSig)
+	if !ok || len(items) == 0 {
+		return Spend{Type: SpendUnknown}
+	}
+	last := items[len(items)-1]
+
+	if len(items) == 1 && len(witness) > 0 {
+		switch {
+		case len(last) == 22 && last[0] == 0x00 && last[1] == 0x14:
+			return Spend{Type: SpendP2SHP2WPKH}
+		case len(last) == 34 && last[0] == 0x00 && last[1] == 0x20:
+			s := Spend{Type: SpendP2SHP2WSH}
+			s.M, s.N, _ = multisig(witness[len(witness)-1])
+			return s
+		}
+	}
+	if len(witness) > 0 {
+		return Spend{Type: SpendUnknown}
+	}
+
+	switch {
+	case len(items) == 2 && isSig(items[0]) && isPubKey(last):
+		return Spend{Type: SpendP2PKH}
+	case len(items) == 1 && isSig(last):
+		return Spend{Type: SpendP2PK}
+	}
+	if m, n, ok := multisig(last); ok {
+		return Spend{Type: SpendP2SH, M: m, N: n}
+	}
+	// OP_0 then only signatures: CHECKMULTISIG's extra pop, with the keys
+	// in the output itself
+	if len(items) >= 2 && len(items[0]) == 0 && allSigs(items[1:]) {
+		return Spend{Type: SpendBareMultisig, M: len(items) - 1}
+	}
+	if !isSig(last) {
+		return Spend{Type: SpendP2SH}
+	}
+	return Spend{Type: SpendUnknown}
+}
+
+// classifyWitness tells native segwit spends apart
+func classifyWitness(witness [][]byte) Spend {
+	last := witness[len(witness)-1]
+	switch {
+	case len(witness) == 1 && (len(last) == 64 || len(last) == 65):
+		return Spend{Type: SpendTaprootKey}
+	case len(witness) == 2 && isSig(witness[0]) && len(last) == 33 && isPubKey(last):
+		return Spend{Type: SpendP2WPKH}
+	}
+	if script, location := witnessScript(witness); location == LocationTapscript {
+		s := Spend{Type: SpendTaprootPath}
+		s.M, s.N, _ = multisig(script)
+		return s
+	}
+	s := Spend{Type: SpendP2WSH}
+	s.M, s.N, _ = multisig(last)
+	return s
+}
+
+// pushes returns the data pushed by a push-only script, small integers as
+// their one-byte value
+func pushes(script []byte) ([][]byte, bool) {
+	var items [][]byte
+	tz := txscript.MakeScriptTokenizer(0, script)
+	for tz.Next() {
+		op := tz.Opcode()
+		switch {
+		case isPush(op):
+			items = append(items, tz.Data())
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			items = append(items, []byte{op - txscript.OP_1 + 1})
+		default:
+			return nil, false
+		}
+	}
+	return items, tz.Err() == nil
+}
+
+// multisig reads the threshold and key count of an m-of-n script: OP_m
+// <pubkey>... OP_n OP_CHECKMULTISIG, or the tapscript form <xonly>
+// OP_CHECKSIG <xonly> OP_CHECKSIGADD... <m> OP_NUMEQUAL
+func multisig(script []byte) (m, n int, ok bool) {
+	var ops []byte
+	var data [][]byte
+	tz := txscript.MakeScriptTokenizer(0, script)
+	for tz.Next() {
+		ops = append(ops, tz.Opcode())
+		data = append(data, tz.Data())
+	}
+	if tz.Err() != nil || len(ops) < 3 {
+		return 0, 0, false
+	}
+	end := len(ops) - 1
+
+	if ops[end] == txscript.OP_CHECKMULTISIG || ops[end] == txscript.OP_CHECKMULTISIGVERIFY {
+		m, n = smallInt(ops[0]), smallInt(ops[end-1])
+		if m == 0 || n != end-2 || m > n {
+			return 0, 0, false
+		}
+		for _, key := range data[1 : end-1] {
+			if !isPubKey(key) {
+				return 0, 0, false
+			}
+		}
+		return m, n, true
+	}
+
+	if ops[end] == txscript.OP_NUMEQUAL || ops[end] == txscript.OP_NUMEQUALVERIFY {
+		if (end-1)%2 != 0 {
+			return 0, 0, false
+		}
+		n = (end - 1) / 2
+		for i := 0; i < n; i++ {
+			want := byte(txscript.OP_CHECKSIGADD)
+			if i == 0 {
+				want = txscript.OP_CHECKSIG
+			}
+			if len(data[2*i]) != 32 || ops[2*i+1] != want {
+				return 0, 0, false
+			}
+		}
+		m = smallInt(ops[end-1])
+		if m == 0 && len(data[end-1]) == 1 {
+			m = int(data[end-1][0])
+		}
+		if m == 0 || m > n {
+			return 0, 0, false
+		}
+		return m, n, true
+	}
+	return 0, 0, false
+}
+
+// smallInt returns the value of OP_1..OP_16, or 0
+func smallInt(op byte) int {
+	if op >= txscript.OP_1 && op <= txscript.OP_16 {
+		return int(op - txscript.OP_1 + 1)
+	}
+	return 0
+}
+
+// isSig reports whether b looks like a DER signature with a sighash byte
+func isSig(b []byte) bool {
+	return len(b) >= 9 && len(b) <= 73 && b[0] == 0x30
+}
+
+func isPubKey(b []byte) bool {
+	return (len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03)) || (len(b) == 65 && b[0] == 0x04)
+}
+
+func allSigs(items [][]byte) bool {
+	for _, b := range items {
+		if !isSig(b) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,303 @@
+package scripts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/keato/btc-observer/internal/protocol"
+)
+
+// Locations a template can match in a transaction
+const (
+	LocationOutput    = "output"    // bare output scriptPubKey
+	LocationP2SH      = "p2sh"      // redeem script revealed in a P2SH scriptSig
+	LocationP2WSH     = "p2wsh"     // witness script revealed when spending P2WSH
+	LocationTapscript = "tapscript" // leaf script revealed in a taproot script-path spend
+)
+
+var allLocations = []string{LocationOutput, LocationP2SH, LocationP2WSH, LocationTapscript}
+
+// Definition describes a script template. Patterns are space-separated
+// tokens, one per script element:
+//
+//	OP_CHECKSIG    an exact opcode (any name txscript knows)
+//	<pubkey>       33 or 65 byte push
+//	<xonly>        32 byte push (taproot key)
+//	<hash20>       20 byte push
+//	<hash32>       32 byte push
+//	<num>          small integer: OP_0..OP_16 or a push of up to 5 bytes
+//	<data>         any push
+//	<data:6f6d>    a push starting with the given hex bytes
+//	<any>          any single element
+//	...            anything (only as the last token)
+type Definition struct {
+	Name      string   `json:"name"`
+	Pattern   string   `json:"pattern"`
+	Locations []string `json:"locations,omitempty"` // default: all
+}
+
+type tokenKind int
+
+const (
+	tokOpcode tokenKind = iota
+	tokPush
+	tokNum
+	tokAny
+)
+
+type token struct {
+	kind   tokenKind
+	opcode byte
+	sizes  []int  // allowed push sizes (nil = any)
+	prefix []byte // required push prefix
+}
+
+// Template is a compiled Definition
+type Template struct {
+	Name      string
+	tokens    []token
+	rest      bool
+	locations map[string]bool
+}
+
+// Compile parses a definition's pattern
+func Compile(def Definition) (*Template, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("template needs a name")
+	}
+	t := &Template{Name: def.Name, locations: make(map[string]bool)}
+
+	locations := def.Locations
+	if len(locations) == 0 {
+		locations = allLocations
+	}
+	for _, loc := range locations {
+		switch loc {
+		case LocationOutput, LocationP2SH, LocationP2WSH, LocationTapscript:
+			t.locations[loc] = true
+		default:
+			return nil, fmt.Errorf("template %s: unknown location %q", def.Name, loc)
+		}
+	}
+
+	fields := strings.Fields(def.Pattern)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("template %s: empty pattern", def.Name)
+	}
+	for i, f := range fields {
+		if f == "..." {
+			if i != len(fields)-1 {
+				return nil, fmt.Errorf("template %s: ... must be the last token", def.Name)
+			}
+			t.rest = true
+			continue
+		}
+		tok, err := parseToken(f)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", def.Name, err)
+		}
+		t.tokens = append(t.tokens, tok)
+	}
+	return t, nil
+}
+
+func parseToken(f string) (token, error) {
+	switch f {
+	case "<pubkey>":
+		return token{kind: tokPush, sizes: []int{33, 65}}, nil
+	case "<xonly>", "<hash32>":
+		return token{kind: tokPush, sizes: []int{32}}, nil
+	case "<hash20>":
+		return token{kind: tokPush, sizes: []int{20}}, nil
+	case "<num>":
+		return token{kind: tokNum}, nil
+	case "<data>":
+		return token{kind: tokPush}, nil
+	case "<any>":
+		return token{kind: tokAny}, nil
+	}
+	if strings.HasPrefix(f, "<data:") && strings.HasSuffix(f, ">") {
+		prefix, err := hex.DecodeString(f[len("<data:") : len(f)-1])
+		if err != nil {
+			return token{}, fmt.Errorf("bad data prefix %q", f)
+		}
+		return token{kind: tokPush, prefix: prefix}, nil
+	}
+	if op, ok := txscript.OpcodeByName[strings.ToUpper(f)]; ok {
+		return token{kind: tokOpcode, opcode: op}, nil
+	}
+	return token{}, fmt.Errorf("unknown token %q", f)
+}
+
+// isPush reports whether op pushes data (including OP_0)
+func isPush(op byte) bool {
+	return op <= txscript.OP_PUSHDATA4
+}
+
+func (tok token) matches(op byte, data []byte) bool {
+	switch tok.kind {
+	case tokAny:
+		return true
+	case tokOpcode:
+		return op == tok.opcode
+	case tokNum:
+		if op == txscript.OP_0 || (op >= txscript.OP_1 && op <= txscript.OP_16) || op == txscript.OP_1NEGATE {
+			return true
+		}
+		return isPush(op) && len(data) <= 5
+	case tokPush:
+		if !isPush(op) {
+			return false
+		}
+		if tok.sizes != nil {
+			ok := false
+			for _, n := range tok.sizes {
+				if len(data) == n {
+					ok = true
+				}
+			}
+			if !ok {
+				return false
+			}
+		}
+		return bytes.HasPrefix(data, tok.prefix)
+	}
+	return false
+}
+
+// Match reports whether script matches the template
+func (t *Template) Match(script []byte) bool {
+	tz := txscript.MakeScriptTokenizer(0, script)
+	for _, tok := range t.tokens {
+		if !tz.Next() || !tok.matches(tz.Opcode(), tz.Data()) {
+			return false
+		}
+	}
+	if t.rest {
+		return tz.Err() == nil
+	}
+	return !tz.Next() && tz.Err() == nil
+}
+
+// Match is a template hit in a transaction
+type Match struct {
+	Template string
+	Location string
+	Index    int // output index for outputs, input index otherwise
+}
+
+// Registry holds the templates matched at ingest
+type Registry struct {
+	templates []*Template
+}
+
+// NewRegistry compiles the built-in templates plus extra definitions. An
+// extra definition with a built-in's name replaces it.
+func NewRegistry(extra []Definition) (*Registry, error) {
+	defs := make([]Definition, 0, len(builtinTemplates)+len(extra))
+	overridden := make(map[string]bool)
+	for _, d := range extra {
+		overridden[d.Name] = true
+	}
+	for _, d := range builtinTemplates {
+		if !overridden[d.Name] {
+			defs = append(defs, d)
+		}
+	}
+	defs = append(defs, extra...)
+
+	r := &Registry{}
+	seen := make(map[string]bool)
+	for _, d := range defs {
+		if seen[d.Name] {
+			return nil, fmt.Errorf("duplicate template name %q", d.Name)
+		}
+		seen[d.Name] = true
+		t, err := Compile(d)
+		if err != nil {
+			return nil, err
+		}
+		r.templates = append(r.templates, t)
+	}
+	return r, nil
+}
+
+// Names returns the template names in registry order
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.templates))
+	for i, t := range r.templates {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// MatchTx checks every script a transaction reveals against the templates
+func (r *Registry) MatchTx(tx *protocol.Transaction) []Match {
+	var matches []Match
+	check := func(script []byte, location string, index int) {
+		if len(script) == 0 {
+			return
+		}
+		for _, t := range r.templates {
+			if t.locations[location] && t.Match(script) {
+				matches = append(matches, Match{Template: t.Name, Location: location, Index: index})
+			}
+		}
+	}
+
+	for i, out := range tx.Outputs {
+		check(out.ScriptPubKey, LocationOutput, i)
+	}
+	for i, in := range tx.Inputs {
+		if redeem := lastPush(in.ScriptSig); redeem != nil {
+			check(redeem, LocationP2SH, i)
+		}
+		if script, location := witnessScript(in.Witness); script != nil {
+			check(script, location, i)
+		}
+	}
+	return matches
+}
+
+// lastPush returns the final data push of a push-only scriptSig, which for
+// P2SH spends is the redeem script
+func lastPush(scriptSig []byte) []byte {
+	var last []byte
+	tz := txscript.MakeScriptTokenizer(0, scriptSig)
+	for tz.Next() {
+		if !isPush(tz.Opcode()) && !(tz.Opcode() >= txscript.OP_1 && tz.Opcode() <= txscript.OP_16) {
+			return nil
+		}
+		last = tz.Data()
+	}
+	if tz.Err() != nil {
+		return nil
+	}
+	return last
+}
+
+// witnessScript returns the script revealed by a witness: the tapscript leaf
+// for taproot script-path spends, otherwise the P2WSH witness script
+func witnessScript(witness [][]byte) ([]byte, string) {
+	items := witness
+	// Drop the BIP341 annex if present
+	if len(items) >= 2 && len(items[len(items)-1]) > 0 && items[len(items)-1][0] == 0x50 {
+		items = items[:len(items)-1]
+	}
+	if len(items) < 2 {
+		return nil, ""
+	}
+	last := items[len(items)-1]
+	// P2WPKH: signature and compressed pubkey, no script revealed
+	if len(items) == 2 && len(last) == 33 && (last[0] == 0x02 || last[0] == 0x03) {
+		return nil, ""
+	}
+	// Control block: leaf version byte then 32-byte key, plus 32 per path step
+	if len(last) >= 33 && (len(last)-33)%32 == 0 && last[0]&0xfe == 0xc0 {
+		return items[len(items)-2], LocationTapscript
+	}
+	return last, LocationP2WSH
+}
@@ -0,0 +1,164 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+)
+
+// Phases of the teardown, in the order they run
+const (
+	Intake = "intake" // stop discovery, dialing and message handling
+	Flush  = "flush"  // drain queued events to Kafka, ClickHouse, webhooks and the message store
+	Peers  = "peers"  // close peer connections and wait for their goroutines
+	Sync   = "sync"   // save peer scores and latency profiles to the database
+)
+
+var order = []string{Intake, Flush, Peers, Sync}
+
+// Config budgets the teardown
+type Config struct {
+	TimeoutSeconds int `json:"timeout_seconds"` // for the whole teardown (default 10)
+	// PhaseSeconds caps phases by name; an uncapped phase may use whatever
+	// is left of the budget
+	PhaseSeconds map[string]int `json:"phase_seconds"`
+}
+
+func (c *Config) applyDefaults() {
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = 10
+	}
+}
+
+// Validate checks the phase names
+func (c *Config) Validate() error {
+	for name := range c.PhaseSeconds {
+		known := false
+		for _, phase := range order {
+			known = known || name == phase
+		}
+		if !known {
+			return fmt.Errorf("unknown shutdown phase %q (want intake, flush, peers or sync)", name)
+		}
+	}
+	return nil
+}
+
+// Phase is one step of the teardown. Routines with work to finish in it run
+// under its context and add themselves to its WaitGroup.
+type Phase struct {
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	hooks  []func()
+}
+
+// Context is done when the phase starts
+func (p *Phase) Context() context.Context {
+	return p.ctx
+}
+
+// WaitGroup is waited on, within the budget, before the next phase starts
+func (p *Phase) WaitGroup() *sync.WaitGroup {
+	return &p.wg
+}
+
+// OnStart runs fn when the phase starts, after its context is cancelled
+func (p *Phase) OnStart(fn func()) {
+	p.hooks = append(p.hooks, fn)
+}
+
+// Timing is how long a phase took, and whether it was cut short
+type Timing struct {
+	Phase    string
+	Took     time.Duration
+	TimedOut bool
+}
+
+// Plan is the ordered teardown
+type Plan struct {
+	cfg    Config
+	phases map[string]*Phase
+}
+
+// New creates the teardown's phases
+func New(cfg Config) *Plan {
+	cfg.applyDefaults()
+	p := &Plan{cfg: cfg, phases: make(map[string]*Phase, len(order))}
+	for _, name := range order {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.phases[name] = &Phase{name: name, ctx: ctx, cancel: cancel}
+	}
+	return p
+}
+
+// Phase returns the named phase
+func (p *Plan) Phase(name string) *Phase {
+	return p.phases[name]
+}
+
+// Run tears down phase by phase. Each phase's context is cancelled and its
+// hooks run, then its routines are waited for until they finish, its cap
+// passes or the budget runs out. A phase cut short is abandoned and the next
+// starts anyway, so the database is still synced after a stuck flush.
+func (p *Plan) Run() []Timing {
+	started := time.Now()
+	deadline := started.Add(time.Duration(p.cfg.TimeoutSeconds) * time.Second)
+	timings := make([]Timing, 0, len(order))
+	for _, name := range order {
+		phase := p.phases[name]
+		start := time.Now()
+		phase.cancel()
+		for _, fn := range phase.hooks {
+			fn()
+		}
+
+		limit := time.Until(deadline)
+		if seconds := p.cfg.PhaseSeconds[name]; seconds > 0 {
+			limit = min(limit, time.Duration(seconds)*time.Second)
+		}
+		t := Timing{Phase: name, TimedOut: !wait(&phase.wg, limit)}
+		t.Took = time.Since(start)
+		timings = append(timings, t)
+
+		if t.TimedOut {
+			logger.Log.Warn().Str("phase", name).Dur("took", t.Took).Msg("Shutdown phase timed out")
+		} else {
+			logger.Log.Info().Str("phase", name).Dur("took", t.Took).Msg("Shutdown phase complete")
+		}
+	}
+	logger.Log.Info().
+		Dur("took", time.Since(started)).
+		Int("budget_seconds", p.cfg.TimeoutSeconds).
+		Msg("Teardown finished")
+	return timings
+}
+
+// wait reports whether wg finished within limit
+func wait(wg *sync.WaitGroup, limit time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	default:
+	}
+	if limit <= 0 {
+		return false
+	}
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/keato/btc-observer/internal/events"
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+const pingInterval = 30 * time.Second
+
+// defaultTypes are streamed unless a client asks for others. Per-peer tx
+// announcements are left out: they arrive once per tx from every peer.
+var defaultTypes = []events.Type{
+	events.TxReceived,
+	events.BlockReceived,
+	events.DoubleSpendDetected,
+	events.FeeOutlier,
+	events.ChainReorg,
+	events.PeerConnected,
+	events.PeerDisconnected,
+}
+
+// Config configures the WebSocket live stream
+type Config struct {
+	MaxClients int `json:"max_clients"`
+	Buffer     int `json:"buffer"` // events queued per client before it misses some
+}
+
+func (c *Config) applyDefaults() {
+	if c.MaxClients <= 0 {
+		c.MaxClients = 64
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = 1024
+	}
+}
+
+// Register serves the stream at /ws on the default mux, alongside /metrics
+func Register(cfg Config) {
+	http.Handle("/ws", handler(cfg))
+}
+
+// handler streams events from the default bus to WebSocket clients as JSON
+// text messages, one event per message. ?types= takes a comma-separated list
+// of event types to receive instead of the defaults.
+func handler(cfg Config) http.Handler {
+	cfg.applyDefaults()
+	var clients atomic.Int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		types, err := parseTypes(r.URL.Query().Get("types"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if clients.Add(1) > int64(cfg.MaxClients) {
+			clients.Add(-1)
+			http.Error(w, "too many stream clients", http.StatusServiceUnavailable)
+			return
+		}
+		defer clients.Add(-1)
+
+		ws, err := upgrade(w, r)
+		if err != nil {
+			return
+		}
+		metrics.StreamClients.Inc()
+		defer metrics.StreamClients.Dec()
+		logger.Log.Debug().Str("client", r.RemoteAddr).Msg("Stream client connected")
+
+		serve(ws, types, cfg.Buffer)
+		logger.Log.Debug().Str("client", r.RemoteAddr).Msg("Stream client disconnected")
+	})
+}
+
+func parseTypes(v string) (map[events.Type]bool, error) {
+	types := make(map[events.Type]bool)
+	if v == "" {
+		for _, t := range defaultTypes {
+			types[t] = true
+		}
+		return types, nil
+	}
+	known := map[events.Type]bool{
+		events.TxAnnounced:     true,
+		events.PressureChanged: true,
+		events.PeerBanned:      true,
+		events.AddressActivity: true,
+	}
+	for _, t := range defaultTypes {
+		known[t] = true
+	}
+	for _, name := range strings.Split(v, ",") {
+		t := events.Type(strings.TrimSpace(name))
+		if !known[t] {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
+// serve writes events to the client until it disconnects or falls behind
+// far enough that a write times out
+func serve(ws *wsConn, types map[events.Type]bool, buffer int) {
+	defer ws.Close()
+	ch, unsubscribe := events.Subscribe("stream", buffer)
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(closed)
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case e := <-ch:
+			if !types[e.Type] {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				logger.Log.Warn().Err(err).Str("type", string(e.Type)).Msg("Failed to encode stream event")
+				continue
+			}
+			if err := ws.writeFrame(opText, data); err != nil {
+				return
+			}
+			metrics.StreamEvents.WithLabelValues(string(e.Type)).Inc()
+		}
+	}
+}
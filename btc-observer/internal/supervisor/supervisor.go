@@ -0,0 +1,105 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keato/btc-observer/internal/logger"
+	"github.com/keato/btc-observer/internal/metrics"
+)
+
+// ErrUnknownSubsystem is returned when restarting a name never started
+var ErrUnknownSubsystem = errors.New("unknown subsystem")
+
+// StartFunc starts a subsystem's background routines, which must stop when
+// ctx is done. It shouldn't block.
+type StartFunc func(ctx context.Context) error
+
+// Status describes a running subsystem
+type Status struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Restarts  int       `json:"restarts"`
+}
+
+type subsystem struct {
+	start     StartFunc
+	cancel    context.CancelFunc
+	startedAt time.Time
+	restarts  int
+}
+
+// Supervisor runs each subsystem under its own context derived from the
+// process's, so one can be stopped and started again without the others
+type Supervisor struct {
+	sync.Mutex
+	ctx  context.Context
+	subs map[string]*subsystem
+}
+
+// New creates a supervisor whose subsystems stop when ctx is done
+func New(ctx context.Context) *Supervisor {
+	return &Supervisor{ctx: ctx, subs: make(map[string]*subsystem)}
+}
+
+// Start registers a subsystem under name and starts it
+func (s *Supervisor) Start(name string, start StartFunc) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.subs[name] != nil {
+		return fmt.Errorf("subsystem %q already started", name)
+	}
+	sub := &subsystem{start: start}
+	if err := s.run(sub); err != nil {
+		return err
+	}
+	s.subs[name] = sub
+	return nil
+}
+
+// Restart stops a subsystem by cancelling its context and starts it again
+// with a fresh one. Routines of the old run still finishing their current
+// iteration may briefly overlap the new one.
+func (s *Supervisor) Restart(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	sub := s.subs[name]
+	if sub == nil {
+		return fmt.Errorf("%w %q", ErrUnknownSubsystem, name)
+	}
+	sub.cancel()
+	if err := s.run(sub); err != nil {
+		return fmt.Errorf("restart %s: %w", name, err)
+	}
+	sub.restarts++
+	metrics.SubsystemRestarts.WithLabelValues(name).Inc()
+	logger.Log.Info().Str("subsystem", name).Int("restarts", sub.restarts).Msg("Subsystem restarted")
+	return nil
+}
+
+// List returns the running subsystems by name
+func (s *Supervisor) List() []Status {
+	s.Lock()
+	defer s.Unlock()
+	out := make([]Status, 0, len(s.subs))
+	for name, sub := range s.subs {
+		out = append(out, Status{Name: name, StartedAt: sub.startedAt, Restarts: sub.restarts})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *Supervisor) run(sub *subsystem) error {
+	ctx, cancel := context.WithCancel(s.ctx)
+	if err := sub.start(ctx); err != nil {
+		cancel()
+		return err
+	}
+	sub.cancel = cancel
+	sub.startedAt = time.Now().UTC()
+	return nil
+}
@@ -0,0 +1,52 @@
+package topology
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteDOT renders the graph as a Graphviz digraph. Gossip edges are dashed
+// and their width scales with the number of txs behind them.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph btc_topology {")
+	fmt.Fprintf(bw, "  // generated %s, window %s, max gap %dms, min count %d\n",
+		g.Generated.Format("2006-01-02T15:04:05Z"), g.Options.Window, g.Options.MaxGapMs, g.Options.MinGossipCount)
+	fmt.Fprintln(bw, "  node [shape=ellipse, fontsize=10];")
+
+	for _, n := range g.Nodes {
+		if n.ID == ObserverID {
+			fmt.Fprintf(bw, "  %s [label=%s, shape=doublecircle];\n", strconv.Quote(n.ID), strconv.Quote(n.Label))
+			continue
+		}
+		label := n.Label
+		if n.Region != "" {
+			label += "\n" + n.Region
+		}
+		fmt.Fprintf(bw, "  %s [label=%s, region=%s, country=%s, asn=%s, latency_ms=%d];\n",
+			strconv.Quote(n.ID), strconv.Quote(label), strconv.Quote(n.Region),
+			strconv.Quote(n.Country), strconv.Quote(n.ASN), n.LatencyMs)
+	}
+
+	var maxGossip float64
+	for _, e := range g.Edges {
+		if e.Kind == KindGossip && e.Weight > maxGossip {
+			maxGossip = e.Weight
+		}
+	}
+	for _, e := range g.Edges {
+		src, dst := strconv.Quote(e.Source), strconv.Quote(e.Target)
+		if e.Kind == KindConnection {
+			fmt.Fprintf(bw, "  %s -> %s [kind=connection, color=gray];\n", src, dst)
+			continue
+		}
+		width := 1 + 4*e.Weight/maxGossip
+		fmt.Fprintf(bw, "  %s -> %s [kind=gossip, style=dashed, weight=%g, penwidth=%.2f, label=\"%g\"];\n",
+			src, dst, e.Weight, width, e.Weight)
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
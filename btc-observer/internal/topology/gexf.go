@@ -0,0 +1,128 @@
+package topology
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// GEXF 1.3 document structure, as read by Gephi
+type gexfDoc struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	LastModified string `xml:"lastmodifieddate,attr"`
+	Creator      string `xml:"creator"`
+	Description  string `xml:"description"`
+}
+
+type gexfGraph struct {
+	Mode            string           `xml:"mode,attr"`
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class string          `xml:"class,attr"`
+	Attrs []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID     string          `xml:"id,attr"`
+	Label  string          `xml:"label,attr"`
+	Values []gexfAttrValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string          `xml:"id,attr"`
+	Source string          `xml:"source,attr"`
+	Target string          `xml:"target,attr"`
+	Weight float64         `xml:"weight,attr"`
+	Values []gexfAttrValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttrValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// WriteGEXF renders the graph as GEXF 1.3 for Gephi, with peer geo and
+// latency as node attributes and the edge kind as an edge attribute
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := gexfDoc{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta: gexfMeta{
+			LastModified: g.Generated.Format("2006-01-02"),
+			Creator:      "btc-observer",
+			Description:  "Observer connections and inferred tx gossip topology over the last " + g.Options.Window.String(),
+		},
+		Graph: gexfGraph{
+			Mode:            "static",
+			DefaultEdgeType: "directed",
+			Attributes: []gexfAttributes{
+				{Class: "node", Attrs: []gexfAttribute{
+					{ID: "region", Title: "region", Type: "string"},
+					{ID: "country", Title: "country", Type: "string"},
+					{ID: "asn", Title: "asn", Type: "string"},
+					{ID: "user_agent", Title: "user_agent", Type: "string"},
+					{ID: "latitude", Title: "latitude", Type: "double"},
+					{ID: "longitude", Title: "longitude", Type: "double"},
+					{ID: "latency_ms", Title: "latency_ms", Type: "integer"},
+				}},
+				{Class: "edge", Attrs: []gexfAttribute{
+					{ID: "kind", Title: "kind", Type: "string"},
+				}},
+			},
+		},
+	}
+
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID:    n.ID,
+			Label: n.Label,
+			Values: []gexfAttrValue{
+				{"region", n.Region},
+				{"country", n.Country},
+				{"asn", n.ASN},
+				{"user_agent", n.UserAgent},
+				{"latitude", strconv.FormatFloat(n.Latitude, 'f', -1, 64)},
+				{"longitude", strconv.FormatFloat(n.Longitude, 'f', -1, 64)},
+				{"latency_ms", strconv.Itoa(n.LatencyMs)},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Weight: e.Weight,
+			Values: []gexfAttrValue{{"kind", e.Kind}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,143 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+)
+
+// ObserverID is the node id of this observer in exported graphs
+const ObserverID = "observer"
+
+// Edge kinds
+const (
+	// KindConnection links the observer to a peer it was connected to
+	KindConnection = "connection"
+
+	// KindGossip is an inferred relay link between two peers
+	KindGossip = "gossip"
+)
+
+// ErrUnknownProject is returned by Build for a project that doesn't exist
+var ErrUnknownProject = errors.New("unknown project")
+
+// Options controls which data goes into a topology graph
+type Options struct {
+	// Window is how far back peers and announcements are considered
+	Window time.Duration
+
+	// MaxGapMs is the longest delay between consecutive announcements of a
+	// tx that still counts as one peer relaying to the next
+	MaxGapMs int
+
+	// MinGossipCount drops inferred links seen for fewer txs than this
+	MinGossipCount int
+
+	// Project limits the graph to a project's transactions and peers
+	Project string
+}
+
+// DefaultOptions covers the last hour with a 500ms relay gap
+var DefaultOptions = Options{Window: time.Hour, MaxGapMs: 500, MinGossipCount: 5}
+
+// Node is a peer (or the observer itself)
+type Node struct {
+	ID        string
+	Label     string
+	Region    string
+	Country   string
+	ASN       string
+	UserAgent string
+	Latitude  float64
+	Longitude float64
+	LatencyMs int
+}
+
+// Edge is a directed, weighted link between nodes
+type Edge struct {
+	Source string
+	Target string
+	Kind   string
+	Weight float64
+}
+
+// Graph is the observer's connection graph plus the inferred gossip topology
+type Graph struct {
+	Generated time.Time
+	Options   Options
+	Nodes     []Node
+	Edges     []Edge
+}
+
+// Build assembles the graph from stored peers and propagation events
+func Build(db *database.DB, opts Options) (*Graph, error) {
+	now := time.Now().UTC()
+	since := now.Add(-opts.Window)
+
+	var projectID int
+	if opts.Project != "" {
+		id, ok, err := db.ProjectID(opts.Project)
+		if err != nil {
+			return nil, fmt.Errorf("looking up project: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownProject, opts.Project)
+		}
+		projectID = id
+	}
+
+	peers, err := db.TopologyPeers(since, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("loading peers: %w", err)
+	}
+	gossip, err := db.GossipEdges(since, opts.MaxGapMs, opts.MinGossipCount, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("inferring gossip edges: %w", err)
+	}
+
+	g := &Graph{Generated: now, Options: opts}
+	g.Nodes = append(g.Nodes, Node{ID: ObserverID, Label: "btc-observer"})
+	known := map[string]bool{ObserverID: true}
+
+	for _, p := range peers {
+		g.Nodes = append(g.Nodes, Node{
+			ID:        p.Addr,
+			Label:     p.Addr,
+			Region:    p.Region,
+			Country:   p.CountryCode,
+			ASN:       p.ASN,
+			UserAgent: p.UserAgent,
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+			LatencyMs: p.LatencyMs,
+		})
+		known[p.Addr] = true
+		g.Edges = append(g.Edges, Edge{Source: ObserverID, Target: p.Addr, Kind: KindConnection, Weight: 1})
+	}
+
+	for _, e := range gossip {
+		for _, addr := range []string{e.From, e.To} {
+			if !known[addr] {
+				g.Nodes = append(g.Nodes, Node{ID: addr, Label: addr})
+				known[addr] = true
+			}
+		}
+		g.Edges = append(g.Edges, Edge{Source: e.From, Target: e.To, Kind: KindGossip, Weight: float64(e.Count)})
+	}
+	return g, nil
+}
+
+// Write renders the graph in the named format ("dot" or "gexf")
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "gexf":
+		return g.WriteGEXF(w)
+	default:
+		return fmt.Errorf("unknown topology format %q (want dot or gexf)", format)
+	}
+}
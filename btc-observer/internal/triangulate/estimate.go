@@ -0,0 +1,115 @@
+package triangulate
+
+import (
+	"math"
+
+	"github.com/keato/btc-observer/internal/database"
+)
+
+const (
+	earthRadiusKm = 6371.0
+
+	// coarse grid step and the refinement step around the best coarse cell
+	coarseStepDeg = 5.0
+	fineStepDeg   = 1.0
+)
+
+// model is the arrival-time model: a tx leaving its origin reaches observer i
+// at t0 + distance(origin, i) / speed, plus gaussian noise
+type model struct {
+	speedKmPerMs float64
+	noiseMs      float64
+	radiusKm     float64
+}
+
+// candidate is a scored grid point
+type candidate struct {
+	lat, lon float64
+	sse      float64 // sum of squared residuals, ms^2
+	weight   float64 // area weight of the grid cell
+}
+
+// estimate finds the origin that best explains the observers' arrival times.
+// Arrival times are in ms relative to any common epoch. Confidence is the
+// posterior probability (uniform prior over the globe) that the origin lies
+// within radiusKm of the estimate.
+func (m model) estimate(locs []database.Location, arrivalsMs []float64) database.OriginEstimate {
+	coarse := m.grid(locs, arrivalsMs, -90+coarseStepDeg/2, 90, -180+coarseStepDeg/2, 180, coarseStepDeg)
+	best := coarse[0]
+	for _, c := range coarse[1:] {
+		if c.sse < best.sse {
+			best = c
+		}
+	}
+
+	fine := m.grid(locs, arrivalsMs,
+		math.Max(best.lat-coarseStepDeg, -90), math.Min(best.lat+coarseStepDeg, 90),
+		best.lon-coarseStepDeg, best.lon+coarseStepDeg, fineStepDeg)
+	for _, c := range fine {
+		if c.sse < best.sse {
+			best = c
+		}
+	}
+
+	// Posterior mass near the best point, on the coarse grid so every cell
+	// of the globe is counted once
+	var total, near float64
+	for _, c := range coarse {
+		p := c.weight * math.Exp(-(c.sse-best.sse)/(2*m.noiseMs*m.noiseMs))
+		total += p
+		if HaversineKm(best.lat, best.lon, c.lat, c.lon) <= m.radiusKm {
+			near += p
+		}
+	}
+
+	return database.OriginEstimate{
+		Location:      database.Location{Latitude: best.lat, Longitude: wrapLon(best.lon)},
+		Confidence:    near / total,
+		ResidualMs:    math.Sqrt(best.sse / float64(len(locs))),
+		ObserverCount: len(locs),
+	}
+}
+
+// grid scores every point on a lat/lon grid. For each point the unknown send
+// time t0 is solved in closed form as the mean of arrival minus travel time.
+func (m model) grid(locs []database.Location, arrivalsMs []float64, latFrom, latTo, lonFrom, lonTo, step float64) []candidate {
+	var out []candidate
+	offsets := make([]float64, len(locs))
+	for lat := latFrom; lat < latTo; lat += step {
+		for lon := lonFrom; lon < lonTo; lon += step {
+			var mean float64
+			for i, l := range locs {
+				offsets[i] = arrivalsMs[i] - HaversineKm(lat, lon, l.Latitude, l.Longitude)/m.speedKmPerMs
+				mean += offsets[i]
+			}
+			mean /= float64(len(locs))
+			var sse float64
+			for _, o := range offsets {
+				sse += (o - mean) * (o - mean)
+			}
+			out = append(out, candidate{lat: lat, lon: lon, sse: sse, weight: math.Cos(lat * math.Pi / 180)})
+		}
+	}
+	return out
+}
+
+// HaversineKm is the great-circle distance between two points
+func HaversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// wrapLon folds a longitude from the refinement grid back into [-180, 180)
+func wrapLon(lon float64) float64 {
+	for lon >= 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
@@ -0,0 +1,119 @@
+package triangulate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/keato/btc-observer/internal/database"
+	"github.com/keato/btc-observer/internal/logger"
+)
+
+// Config enables the opt-in origin triangulation job. Run it on one instance
+// only; every instance feeding it must set observer_id and observer_location
+// and keep its clock NTP-synced.
+type Config struct {
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// SettleSeconds waits this long after a tx is first seen so every
+	// observer has reported it (default 120)
+	SettleSeconds int `json:"settle_seconds"`
+
+	// MinObservers is the fewest vantage points a tx needs (default 3)
+	MinObservers int `json:"min_observers"`
+
+	// SpeedKmPerMs is the assumed effective propagation speed (default 100)
+	SpeedKmPerMs float64 `json:"speed_km_per_ms"`
+
+	// NoiseMs is the assumed per-observer timing noise (default 200)
+	NoiseMs float64 `json:"noise_ms"`
+
+	// ConfidenceRadiusKm is the radius confidence is reported for (default 1500)
+	ConfidenceRadiusKm float64 `json:"confidence_radius_km"`
+}
+
+func (c *Config) applyDefaults() error {
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = 60
+	}
+	if c.SettleSeconds <= 0 {
+		c.SettleSeconds = 120
+	}
+	if c.MinObservers == 0 {
+		c.MinObservers = 3
+	}
+	if c.MinObservers < 3 {
+		return fmt.Errorf("min_observers must be at least 3")
+	}
+	if c.SpeedKmPerMs == 0 {
+		c.SpeedKmPerMs = 100
+	}
+	if c.NoiseMs == 0 {
+		c.NoiseMs = 200
+	}
+	if c.ConfidenceRadiusKm == 0 {
+		c.ConfidenceRadiusKm = 1500
+	}
+	if c.SpeedKmPerMs < 0 || c.NoiseMs < 0 || c.ConfidenceRadiusKm < 0 {
+		return fmt.Errorf("triangulation speed, noise and radius must be positive")
+	}
+	return nil
+}
+
+// Start estimates the origin of each tx seen by enough vantage points, once
+// it has settled. Txs first seen before startup are not revisited.
+func Start(ctx context.Context, db *database.DB, cfg Config) error {
+	if err := cfg.applyDefaults(); err != nil {
+		return err
+	}
+	m := model{speedKmPerMs: cfg.SpeedKmPerMs, noiseMs: cfg.NoiseMs, radiusKm: cfg.ConfidenceRadiusKm}
+	settle := time.Duration(cfg.SettleSeconds) * time.Second
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+
+	go func() {
+		cursor := time.Now().UTC().Add(-settle)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			to := time.Now().UTC().Add(-settle)
+			if err := run(db, m, cfg.MinObservers, cursor, to); err != nil {
+				logger.Log.Error().Err(err).Msg("Triangulation run failed")
+				continue
+			}
+			cursor = to
+		}
+	}()
+	return nil
+}
+
+func run(db *database.DB, m model, minObservers int, from, to time.Time) error {
+	arrivals, err := db.VantageArrivals(from, to)
+	if err != nil {
+		return err
+	}
+
+	estimated := 0
+	for hash, seen := range arrivals {
+		if len(seen) < minObservers {
+			continue
+		}
+		epoch := seen[0].FirstSeen
+		locs := make([]database.Location, len(seen))
+		times := make([]float64, len(seen))
+		for i, a := range seen {
+			locs[i] = a.Location
+			times[i] = float64(a.FirstSeen.Sub(epoch).Microseconds()) / 1000
+		}
+		if err := db.RecordOriginEstimate(hash[:], m.estimate(locs, times)); err != nil {
+			return err
+		}
+		estimated++
+	}
+	logger.Log.Debug().Int("candidates", len(arrivals)).Int("estimated", estimated).Msg("Triangulation run complete")
+	return nil
+}
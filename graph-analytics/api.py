@@ -52,7 +52,10 @@ class PathResponse(BaseModel):
 
 
 import asyncio
+import calendar
+import hashlib
 import logging
+import struct
 
 logging.basicConfig(level=logging.INFO)
 log = logging.getLogger("api")
@@ -255,7 +258,8 @@ def refresh_analytics_cache():
                 pc.country_code,
                 pc.region,
                 COUNT(DISTINCT obs.tx_hash) as first_seen_count,
-                COUNT(DISTINCT pc.peer_addr) as peer_count
+                COUNT(DISTINCT pc.peer_addr) as peer_count,
+                COUNT(DISTINCT pc.peer_addr) FILTER (WHERE pc.geo_suspect) as suspect_peer_count
             FROM peer_connections pc
             JOIN transaction_observations obs ON pc.peer_addr = obs.first_peer_addr
             WHERE pc.country_code IS NOT NULL
@@ -273,6 +277,7 @@ def refresh_analytics_cache():
                     "region": row["region"],
                     "first_seen_count": row["first_seen_count"],
                     "peer_count": row["peer_count"],
+                    "suspect_peer_count": row["suspect_peer_count"],
                 }
                 for row in rows
             ]
@@ -482,7 +487,8 @@ async def get_country_rankings():
                 pc.country_code,
                 pc.region,
                 COUNT(DISTINCT obs.tx_hash) as first_seen_count,
-                COUNT(DISTINCT pc.peer_addr) as peer_count
+                COUNT(DISTINCT pc.peer_addr) as peer_count,
+                COUNT(DISTINCT pc.peer_addr) FILTER (WHERE pc.geo_suspect) as suspect_peer_count
             FROM peer_connections pc
             JOIN transaction_observations obs ON pc.peer_addr = obs.first_peer_addr
             WHERE pc.country_code IS NOT NULL
@@ -501,7 +507,8 @@ async def get_country_rankings():
                     "country_code": row["country_code"],
                     "region": row["region"],
                     "first_seen_count": row["first_seen_count"],
-                    "peer_count": row["peer_count"]
+                    "peer_count": row["peer_count"],
+                    "suspect_peer_count": row["suspect_peer_count"]
                 }
                 for row in rows
             ]
@@ -581,28 +588,34 @@ async def get_high_risk_addresses(top_n: int = 10):
 
 
 @app.get("/propagation-stats")
-async def get_propagation_stats():
-    """Get transaction propagation statistics by region"""
-    if "propagation_stats" in analytics_cache:
+async def get_propagation_stats(project: Optional[str] = None):
+    """Get transaction propagation statistics by region, optionally for one
+    project's transactions and peers only"""
+    if project is None and "propagation_stats" in analytics_cache:
         return analytics_cache["propagation_stats"]
+    project_id = lookup_project(project) if project is not None else None
 
     try:
         conn = get_db_connection()
         cursor = conn.cursor()
 
-        cursor.execute("""
+        if project_id is None:
+            events, params = "propagation_events", ()
+        else:
+            events, params = "(SELECT * FROM project_propagation_events WHERE project_id = %s)", (project_id,)
+        cursor.execute(f"""
             SELECT
                 pc.region,
                 COUNT(*) as observation_count,
                 AVG(pe.delay_from_first_ms) as avg_delay_ms,
                 MIN(pe.delay_from_first_ms) as min_delay_ms,
                 MAX(pe.delay_from_first_ms) as max_delay_ms
-            FROM propagation_events pe
+            FROM {events} pe
             JOIN peer_connections pc ON pe.peer_addr = pc.peer_addr
             WHERE pc.region IS NOT NULL
             GROUP BY pc.region
             ORDER BY observation_count DESC
-        """)
+        """, params)
 
         rows = cursor.fetchall()
         cursor.close()
@@ -624,6 +637,250 @@ async def get_propagation_stats():
         return {"by_region": [], "error": str(e)}
 
 
+# Announcement tables for each kind of item, with the column naming the item
+PROPAGATION_TABLES = {
+    "tx": ("propagation_events", "tx_hash"),
+    "block": ("block_propagation", "block_hash"),
+}
+PROPAGATION_GROUPS = {"country": "pc.country_code", "region": "pc.region"}
+
+
+def propagation_table(kind: str):
+    if kind not in PROPAGATION_TABLES:
+        raise HTTPException(status_code=400, detail="kind must be 'tx' or 'block'")
+    return PROPAGATION_TABLES[kind]
+
+
+def delay_percentiles(row):
+    return {
+        "p50_delay_ms": row["p50"],
+        "p90_delay_ms": row["p90"],
+        "p99_delay_ms": row["p99"],
+    }
+
+
+@app.get("/propagation/{group_by}")
+async def get_propagation_by_group(group_by: str, kind: str = "tx", hours: int = 24,
+                                   observer: Optional[str] = None):
+    """Propagation delay distribution per country or region over recent
+    announcements: p50/p90/p99 delay from the item's first announcement, and
+    how often a peer there was the first to announce it"""
+    if group_by not in PROPAGATION_GROUPS:
+        raise HTTPException(status_code=400, detail="group must be 'country' or 'region'")
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    table, key = propagation_table(kind)
+    column = PROPAGATION_GROUPS[group_by]
+    where = "e.announcement_time > NOW() - %s * INTERVAL '1 hour'"
+    params = [hours]
+    if observer is not None:
+        where += " AND e.observer_id = %s"
+        params.append(observer)
+
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute(f"""
+            SELECT COALESCE({column}, 'unknown') AS grp, COUNT(*) AS announcements,
+                   COUNT(DISTINCT e.{key}) AS items, COUNT(DISTINCT e.peer_addr) AS peers,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p50,
+                   percentile_cont(0.9) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p90,
+                   percentile_cont(0.99) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p99
+            FROM {table} e
+            LEFT JOIN peer_connections pc ON pc.peer_addr = e.peer_addr
+            WHERE {where}
+            GROUP BY 1
+        """, params)
+        rows = cursor.fetchall()
+        # The first announcement of each item, by the group of its peer
+        cursor.execute(f"""
+            SELECT COALESCE({column}, 'unknown') AS grp, COUNT(*) AS first_seen
+            FROM (
+                SELECT DISTINCT ON (e.{key}) e.peer_addr
+                FROM {table} e
+                WHERE {where}
+                ORDER BY e.{key}, e.announcement_time
+            ) first
+            LEFT JOIN peer_connections pc ON pc.peer_addr = first.peer_addr
+            GROUP BY 1
+        """, params)
+        firsts = {row["grp"]: row["first_seen"] for row in cursor.fetchall()}
+        cursor.close()
+    finally:
+        conn.close()
+
+    total = sum(firsts.values())
+    groups = [
+        {
+            group_by: row["grp"],
+            "announcements": row["announcements"],
+            "items": row["items"],
+            "peers": row["peers"],
+            "first_seen": firsts.get(row["grp"], 0),
+            # Share of all items this group's peers announced first
+            "first_seen_share": firsts.get(row["grp"], 0) / total if total else None,
+            **delay_percentiles(row),
+        }
+        for row in rows
+    ]
+    groups.sort(key=lambda g: (g["p50_delay_ms"] is None, g["p50_delay_ms"] or 0))
+    return {"kind": kind, "hours": hours, "items": total, "groups": groups}
+
+
+@app.get("/propagation/{group_by}/deltas")
+async def get_propagation_deltas(group_by: str, hours: int = 24, observer: Optional[str] = None,
+                                 min_txs: int = 100):
+    """How much later transactions reach one country or region than another.
+    For each pair, every transaction seen by peers in both is compared by
+    the first sighting in each; a positive delay means it reached the second
+    group later."""
+    if group_by not in PROPAGATION_GROUPS:
+        raise HTTPException(status_code=400, detail="group must be 'country' or 'region'")
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    column = PROPAGATION_GROUPS[group_by]
+    where = "po.first_seen_at > NOW() - %s * INTERVAL '1 hour'"
+    params = [hours]
+    if observer is not None:
+        where += " AND po.observer_id = %s"
+        params.append(observer)
+    params.append(min_txs)
+
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute(f"""
+            WITH firsts AS (
+                SELECT po.tx_hash, {column} AS grp, MIN(po.first_seen_at) AS seen_at
+                FROM transaction_peer_observations po
+                JOIN peer_connections pc ON pc.peer_addr = po.peer_addr
+                WHERE {where} AND {column} IS NOT NULL
+                GROUP BY po.tx_hash, {column}
+            )
+            SELECT a.grp AS from_grp, b.grp AS to_grp, COUNT(*) AS txs,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM b.seen_at - a.seen_at) * 1000) AS p50,
+                   percentile_cont(0.9) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM b.seen_at - a.seen_at) * 1000) AS p90,
+                   percentile_cont(0.99) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM b.seen_at - a.seen_at) * 1000) AS p99,
+                   AVG(CASE WHEN a.seen_at < b.seen_at THEN 1.0 ELSE 0.0 END) AS from_first_share
+            FROM firsts a
+            JOIN firsts b ON b.tx_hash = a.tx_hash AND b.grp > a.grp
+            GROUP BY a.grp, b.grp
+            HAVING COUNT(*) >= %s
+            ORDER BY txs DESC
+        """, params)
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "hours": hours,
+        "pairs": [
+            {
+                "from": row["from_grp"],
+                "to": row["to_grp"],
+                "txs": row["txs"],
+                # Share of the pair's transactions the first group saw first
+                "from_first_share": float(row["from_first_share"]),
+                **delay_percentiles(row),
+            }
+            for row in rows
+        ],
+    }
+
+
+def item_propagation(table: str, key: str, item_hash: bytes):
+    """First announcer and per-country/region delays of one item"""
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute(f"""
+            SELECT e.peer_addr, e.announcement_time, pc.country_code, pc.region
+            FROM {table} e
+            LEFT JOIN peer_connections pc ON pc.peer_addr = e.peer_addr
+            WHERE e.{key} = %s
+            ORDER BY e.announcement_time
+            LIMIT 1
+        """, (item_hash,))
+        first = cursor.fetchone()
+        if first is None:
+            raise HTTPException(status_code=404, detail="No announcements recorded")
+        cursor.execute(f"""
+            SELECT COUNT(*) AS announcements, COUNT(DISTINCT e.peer_addr) AS peers,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p50,
+                   percentile_cont(0.9) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p90,
+                   percentile_cont(0.99) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p99
+            FROM {table} e
+            WHERE e.{key} = %s
+        """, (item_hash,))
+        overall = cursor.fetchone()
+        cursor.execute(f"""
+            SELECT COALESCE(pc.country_code, 'unknown') AS country, COALESCE(pc.region, 'unknown') AS region,
+                   COUNT(*) AS announcements, MIN(e.delay_from_first_ms) AS first_delay_ms,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p50,
+                   percentile_cont(0.9) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p90,
+                   percentile_cont(0.99) WITHIN GROUP (ORDER BY e.delay_from_first_ms) AS p99
+            FROM {table} e
+            LEFT JOIN peer_connections pc ON pc.peer_addr = e.peer_addr
+            WHERE e.{key} = %s
+            GROUP BY 1, 2
+            ORDER BY first_delay_ms
+        """, (item_hash,))
+        groups = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "first_seen_at": isoformat(first["announcement_time"]),
+        "first_peer": first["peer_addr"],
+        "first_country": first["country_code"],
+        "first_region": first["region"],
+        "announcements": overall["announcements"],
+        "peers": overall["peers"],
+        **delay_percentiles(overall),
+        "by_country": [
+            {
+                "country_code": row["country"],
+                "region": row["region"],
+                "announcements": row["announcements"],
+                "first_delay_ms": row["first_delay_ms"],
+                **delay_percentiles(row),
+            }
+            for row in groups
+        ],
+    }
+
+
+@app.get("/tx/{txid}/propagation")
+async def get_tx_propagation(txid: str):
+    """Which peer, country and region saw a transaction first, and how its
+    announcements spread across countries"""
+    return {"txid": txid, **item_propagation("propagation_events", "tx_hash", txid_to_bytes(txid))}
+
+
+@app.get("/block/{block_id}/propagation")
+async def get_block_propagation(block_id: str):
+    """Which peer, country and region announced a block first, and how its
+    announcements spread across countries"""
+    if block_id.isdigit():
+        conn = get_db_connection()
+        try:
+            cursor = conn.cursor()
+            cursor.execute("SELECT block_hash FROM blocks WHERE height = %s", (int(block_id),))
+            row = cursor.fetchone()
+            cursor.close()
+        finally:
+            conn.close()
+        if row is None:
+            raise HTTPException(status_code=404, detail="Block not found")
+        block_hash = bytes(row["block_hash"])
+    else:
+        block_hash = block_hash_to_bytes(block_id)
+    return {"hash": bytes_to_txid(block_hash),
+            **item_propagation(*PROPAGATION_TABLES["block"], block_hash)}
+
+
 @app.get("/geo-activity")
 async def get_geo_activity():
     """Get recent transaction activity by geographic location for world map"""
@@ -710,6 +967,2019 @@ async def get_peer_locations():
         return {"peers": [], "error": str(e)}
 
 
+MAX_GRAPH_DEPTH = 10
+MAX_GRAPH_NODES = 500
+COINBASE_PREV_HASH = bytes(32)
+
+
+def txid_to_bytes(txid: str) -> bytes:
+    """Convert a txid as shown by explorers/RPC to the stored (internal) byte order"""
+    try:
+        raw = bytes.fromhex(txid)
+    except ValueError:
+        raise HTTPException(status_code=400, detail="txid must be hex")
+    if len(raw) != 32:
+        raise HTTPException(status_code=400, detail="txid must be 32 bytes")
+    return raw[::-1]
+
+
+def bytes_to_txid(raw) -> str:
+    """Convert a stored tx hash to the txid shown by explorers/RPC"""
+    return bytes(raw)[::-1].hex()
+
+
+def walk_spend_graph(cursor, root: bytes, depth: int, ancestors: bool):
+    """Return {tx_hash: distance} for txs reachable from root via inputs
+    (ancestors) or spends (descendants), up to depth hops"""
+    if ancestors:
+        step = """
+            SELECT ti.prev_tx_hash, w.depth + 1
+            FROM walk w
+            JOIN transaction_inputs ti ON ti.tx_hash = w.tx_hash
+            WHERE w.depth < %(depth)s AND ti.prev_tx_hash <> %(coinbase)s
+        """
+    else:
+        step = """
+            SELECT ti.tx_hash, w.depth + 1
+            FROM walk w
+            JOIN transaction_inputs ti ON ti.prev_tx_hash = w.tx_hash
+            WHERE w.depth < %(depth)s
+        """
+    cursor.execute(f"""
+        WITH RECURSIVE walk(tx_hash, depth) AS (
+            SELECT %(root)s::bytea, 0
+            UNION
+            {step}
+        )
+        SELECT tx_hash, MIN(depth) AS depth
+        FROM walk
+        GROUP BY tx_hash
+        ORDER BY depth
+        LIMIT %(limit)s
+    """, {"root": root, "depth": depth, "coinbase": COINBASE_PREV_HASH, "limit": MAX_GRAPH_NODES + 1})
+    return {bytes(row["tx_hash"]): row["depth"] for row in cursor.fetchall()}
+
+
+@app.get("/tx/{txid}/graph")
+async def get_tx_graph(txid: str, depth: int = 3, direction: str = "both"):
+    """Spend graph around a transaction: ancestors (txs it spends from) and/or
+    descendants (txs spending its outputs) up to depth hops"""
+    if direction not in ("ancestors", "descendants", "both"):
+        raise HTTPException(status_code=400, detail="direction must be ancestors, descendants or both")
+    if depth < 1 or depth > MAX_GRAPH_DEPTH:
+        raise HTTPException(status_code=400, detail=f"depth must be between 1 and {MAX_GRAPH_DEPTH}")
+
+    root = txid_to_bytes(txid)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+
+        distance = {root: 0}
+        truncated = False
+        if direction in ("ancestors", "both"):
+            found = walk_spend_graph(cursor, root, depth, ancestors=True)
+            truncated |= len(found) > MAX_GRAPH_NODES
+            for h, d in list(found.items())[:MAX_GRAPH_NODES]:
+                distance.setdefault(h, -d)
+        if direction in ("descendants", "both"):
+            found = walk_spend_graph(cursor, root, depth, ancestors=False)
+            truncated |= len(found) > MAX_GRAPH_NODES
+            for h, d in list(found.items())[:MAX_GRAPH_NODES]:
+                distance.setdefault(h, d)
+
+        hashes = list(distance.keys())
+        cursor.execute("""
+            SELECT t.tx_hash, t.block_height, t.fee_satoshis, t.size_bytes,
+                   t.input_count, t.output_count, t.total_output, obs.first_seen_at
+            FROM transactions t
+            LEFT JOIN transaction_observations obs ON obs.tx_hash = t.tx_hash
+            WHERE t.tx_hash = ANY(%s)
+        """, (hashes,))
+        details = {bytes(row["tx_hash"]): row for row in cursor.fetchall()}
+
+        if root not in details and len(hashes) == 1:
+            raise HTTPException(status_code=404, detail="Transaction not found")
+
+        cursor.execute("""
+            SELECT prev_tx_hash, prev_output_idx, tx_hash, input_index, value_satoshis, address
+            FROM transaction_inputs
+            WHERE tx_hash = ANY(%s) AND prev_tx_hash = ANY(%s)
+        """, (hashes, hashes))
+        edge_rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    nodes = []
+    for h, d in sorted(distance.items(), key=lambda item: item[1]):
+        row = details.get(h)
+        nodes.append({
+            "txid": bytes_to_txid(h),
+            "distance": d,
+            "known": row is not None,
+            "block_height": row["block_height"] if row else None,
+            "fee_satoshis": row["fee_satoshis"] if row else None,
+            "size_bytes": row["size_bytes"] if row else None,
+            "input_count": row["input_count"] if row else None,
+            "output_count": row["output_count"] if row else None,
+            "total_output": row["total_output"] if row else None,
+            "first_seen_at": row["first_seen_at"].isoformat() if row and row["first_seen_at"] else None,
+        })
+
+    return {
+        "txid": txid.lower(),
+        "direction": direction,
+        "depth": depth,
+        "truncated": truncated,
+        "nodes": nodes,
+        "edges": [
+            {
+                "from": bytes_to_txid(row["prev_tx_hash"]),
+                "to": bytes_to_txid(row["tx_hash"]),
+                "output_index": row["prev_output_idx"],
+                "input_index": row["input_index"],
+                "value_satoshis": row["value_satoshis"],
+                "address": row["address"],
+            }
+            for row in edge_rows
+        ],
+    }
+
+
+
+@app.get("/tx/{txid}/origin")
+async def get_tx_origin(txid: str):
+    """Estimated geographic origin of a transaction, triangulated from the
+    first-seen times at several observer instances"""
+    tx_hash = txid_to_bytes(txid)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT latitude, longitude, confidence, residual_ms, observer_count, estimated_at
+            FROM tx_origin_estimates
+            WHERE tx_hash = %s
+        """, (tx_hash,))
+        estimate = cursor.fetchone()
+        if not estimate:
+            raise HTTPException(status_code=404, detail="No origin estimate for this transaction")
+
+        cursor.execute("""
+            SELECT pe.observer_id, ob.latitude, ob.longitude, MIN(pe.announcement_time) AS first_seen_at
+            FROM propagation_events pe
+            JOIN observers ob ON ob.observer_id = pe.observer_id
+            WHERE pe.tx_hash = %s
+            GROUP BY pe.observer_id, ob.latitude, ob.longitude
+            ORDER BY first_seen_at
+        """, (tx_hash,))
+        vantages = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "txid": txid.lower(),
+        "latitude": float(estimate["latitude"]),
+        "longitude": float(estimate["longitude"]),
+        "confidence": estimate["confidence"],
+        "residual_ms": estimate["residual_ms"],
+        "observer_count": estimate["observer_count"],
+        "estimated_at": isoformat(estimate["estimated_at"]),
+        "vantages": [
+            {
+                "observer_id": row["observer_id"],
+                "latitude": float(row["latitude"]),
+                "longitude": float(row["longitude"]),
+                "first_seen_at": isoformat(row["first_seen_at"]),
+            }
+            for row in vantages
+        ],
+    }
+
+MAX_JOURNEY_ANNOUNCEMENTS = 5000
+
+
+@app.get("/tx/{txid}/journey")
+async def get_tx_journey(txid: str, limit: int = 1000):
+    """Everything the observer saw of a transaction in one ordered timeline:
+    each peer announcement with its region and delay from first sight, the
+    conflicts it was part of, and its confirmation"""
+    if limit < 1 or limit > MAX_JOURNEY_ANNOUNCEMENTS:
+        raise HTTPException(status_code=400, detail=f"limit must be between 1 and {MAX_JOURNEY_ANNOUNCEMENTS}")
+    tx_hash = txid_to_bytes(txid)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT obs.first_seen_at, obs.first_peer_addr, obs.peer_count, obs.confirmed_at,
+                   obs.replaced_by_tx, obs.double_spend_flag,
+                   t.tx_hash IS NOT NULL AS stored, t.block_hash, t.block_height, t.fee_satoshis,
+                   t.fee_rate, t.vsize, t.size_bytes
+            FROM (SELECT %s::bytea AS tx_hash) q
+            LEFT JOIN transaction_observations obs ON obs.tx_hash = q.tx_hash
+            LEFT JOIN transactions t ON t.tx_hash = q.tx_hash
+        """, (tx_hash,))
+        tx = cursor.fetchone()
+        if tx["first_seen_at"] is None and not tx["stored"]:
+            raise HTTPException(status_code=404, detail="Transaction not found")
+
+        cursor.execute("""
+            SELECT pe.peer_addr, pe.announcement_time, pe.delay_from_first_ms, pe.observer_id,
+                   pc.region, pc.country_code, pc.user_agent
+            FROM propagation_events pe
+            LEFT JOIN peer_connections pc ON pc.peer_addr = pe.peer_addr
+            WHERE pe.tx_hash = %s
+            ORDER BY pe.announcement_time
+            LIMIT %s
+        """, (tx_hash, limit + 1))
+        announcements = cursor.fetchall()
+        cursor.execute("""
+            SELECT COALESCE(pc.region, 'unknown') AS region, COUNT(*) AS announcements,
+                   MIN(pe.announcement_time) AS first_seen_at, MIN(pe.delay_from_first_ms) AS delay_ms
+            FROM propagation_events pe
+            LEFT JOIN peer_connections pc ON pc.peer_addr = pe.peer_addr
+            WHERE pe.tx_hash = %s
+            GROUP BY 1
+            ORDER BY first_seen_at
+        """, (tx_hash,))
+        regions = cursor.fetchall()
+        cursor.execute("""
+            SELECT original_tx, replacement_tx, detected_at, outcome, winner_tx, resolved_at
+            FROM conflict_outcomes
+            WHERE original_tx = %s OR replacement_tx = %s
+            ORDER BY detected_at
+        """, (tx_hash, tx_hash))
+        conflicts = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    truncated = len(announcements) > limit
+    announcements = announcements[:limit]
+
+    timeline = []
+    for row in announcements:
+        timeline.append({
+            "at": isoformat(row["announcement_time"]),
+            "event": "announced",
+            "peer": row["peer_addr"],
+            "region": row["region"],
+            "country_code": row["country_code"],
+            "user_agent": row["user_agent"],
+            "observer_id": row["observer_id"],
+            "delay_ms": row["delay_from_first_ms"],
+        })
+    for row in conflicts:
+        replaced = bytes(row["original_tx"]) == tx_hash
+        other = row["replacement_tx"] if replaced else row["original_tx"]
+        timeline.append({
+            "at": isoformat(row["detected_at"]),
+            "event": "replaced_by" if replaced else "replaces",
+            "txid": bytes_to_txid(other),
+        })
+        if row["resolved_at"]:
+            won = row["winner_tx"] is not None and bytes(row["winner_tx"]) == tx_hash
+            timeline.append({
+                "at": isoformat(row["resolved_at"]),
+                "event": "conflict_won" if won else "conflict_lost",
+                "txid": bytes_to_txid(other),
+                "outcome": row["outcome"],
+            })
+    if tx["confirmed_at"]:
+        timeline.append({
+            "at": isoformat(tx["confirmed_at"]),
+            "event": "confirmed",
+            "block_hash": bytes_to_txid(tx["block_hash"]) if tx["block_hash"] else None,
+            "height": tx["block_height"],
+        })
+    timeline.sort(key=lambda e: e["at"] or "")
+
+    first_seen = tx["first_seen_at"]
+    confirm_ms = None
+    if first_seen and tx["confirmed_at"]:
+        confirm_ms = int((tx["confirmed_at"] - first_seen).total_seconds() * 1000)
+
+    return {
+        "txid": txid.lower(),
+        "first_seen_at": isoformat(first_seen),
+        "first_peer": tx["first_peer_addr"],
+        "peer_count": tx["peer_count"],
+        "fee_satoshis": tx["fee_satoshis"],
+        "fee_rate": tx["fee_rate"],
+        "vsize": tx["vsize"],
+        "size_bytes": tx["size_bytes"],
+        "double_spend": bool(tx["double_spend_flag"]),
+        "replaced_by_txid": bytes_to_txid(tx["replaced_by_tx"]) if tx["replaced_by_tx"] else None,
+        "confirmed": tx["block_hash"] is not None,
+        "block_height": tx["block_height"],
+        "confirmed_at": isoformat(tx["confirmed_at"]),
+        "time_to_confirm_ms": confirm_ms,
+        "regions": [
+            {
+                "region": row["region"],
+                "announcements": row["announcements"],
+                "first_seen_at": isoformat(row["first_seen_at"]),
+                "delay_ms": row["delay_ms"],
+            }
+            for row in regions
+        ],
+        "truncated": truncated,
+        "timeline": timeline,
+    }
+
+def sha256d(data: bytes) -> bytes:
+    return hashlib.sha256(hashlib.sha256(data).digest()).digest()
+
+
+def merkle_branch(txids: List[bytes], index: int):
+    """Return the merkle root of txids and the sibling hashes on the path from
+    txids[index] up to it"""
+    branch = []
+    level = txids
+    while len(level) > 1:
+        if len(level) % 2:
+            level = level + [level[-1]]
+        branch.append(level[index ^ 1])
+        level = [sha256d(level[i] + level[i + 1]) for i in range(0, len(level), 2)]
+        index //= 2
+    return level[0], branch
+
+
+def partial_merkle_tree(txids: List[bytes], index: int):
+    """Encode the BIP37 partial merkle tree matching only txids[index]: the
+    hashes and flag bytes of a merkleblock message"""
+    def width(height):
+        return (len(txids) + (1 << height) - 1) >> height
+
+    def subtree_hash(height, pos):
+        if height == 0:
+            return txids[pos]
+        left = subtree_hash(height - 1, pos * 2)
+        right = subtree_hash(height - 1, pos * 2 + 1) if pos * 2 + 1 < width(height - 1) else left
+        return sha256d(left + right)
+
+    hashes, bits = [], []
+
+    def traverse(height, pos):
+        matched = index >> height == pos
+        bits.append(matched)
+        if height == 0 or not matched:
+            hashes.append(subtree_hash(height, pos))
+            return
+        traverse(height - 1, pos * 2)
+        if pos * 2 + 1 < width(height - 1):
+            traverse(height - 1, pos * 2 + 1)
+
+    height = 0
+    while width(height) > 1:
+        height += 1
+    traverse(height, 0)
+
+    flags = bytearray((len(bits) + 7) // 8)
+    for i, bit in enumerate(bits):
+        if bit:
+            flags[i // 8] |= 1 << (i % 8)
+    return hashes, bytes(flags)
+
+
+def compact_size(n: int) -> bytes:
+    if n < 0xfd:
+        return bytes([n])
+    if n <= 0xffff:
+        return b"\xfd" + struct.pack("<H", n)
+    return b"\xfe" + struct.pack("<I", n)
+
+
+@app.get("/tx/{txid}/merkle-proof")
+async def get_tx_merkle_proof(txid: str):
+    """SPV proof that a confirmed transaction is in its block: the merkle
+    branch, and a merkleblock-encoded proof as returned by Bitcoin Core's
+    gettxoutproof, so inclusion can be checked against the block header
+    without trusting this database"""
+    tx_hash = txid_to_bytes(txid)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT b.block_hash, b.height, b.version, b.prev_block_hash, b.merkle_root,
+                   b.timestamp, b.bits, b.nonce, bt.txids
+            FROM transactions t
+            JOIN blocks b ON b.block_hash = t.block_hash
+            LEFT JOIN block_txids bt ON bt.block_hash = b.block_hash
+            WHERE t.tx_hash = %s
+        """, (tx_hash,))
+        block = cursor.fetchone()
+        cursor.close()
+    finally:
+        conn.close()
+
+    if not block:
+        raise HTTPException(status_code=404, detail="Transaction not found in a stored block")
+    if block["txids"] is None:
+        raise HTTPException(status_code=404, detail="Transaction list of its block is not stored")
+
+    packed = bytes(block["txids"])
+    txids = [packed[i:i + 32] for i in range(0, len(packed), 32)]
+    try:
+        index = txids.index(tx_hash)
+    except ValueError:
+        raise HTTPException(status_code=500, detail="Transaction missing from its block's transaction list")
+
+    root, branch = merkle_branch(txids, index)
+    if root != bytes(block["merkle_root"]):
+        raise HTTPException(status_code=500, detail="Stored transaction list does not match the merkle root")
+
+    # Rebuild the 80-byte header; blocks stored without every field (or whose
+    # timestamp didn't round-trip) get the branch only
+    proof = None
+    if None not in (block["version"], block["prev_block_hash"], block["timestamp"], block["bits"], block["nonce"]):
+        header = (
+            struct.pack("<i", block["version"])
+            + bytes(block["prev_block_hash"])
+            + root
+            + struct.pack("<III", calendar.timegm(block["timestamp"].utctimetuple()), block["bits"], block["nonce"])
+        )
+        if sha256d(header) == bytes(block["block_hash"]):
+            hashes, flags = partial_merkle_tree(txids, index)
+            proof = (
+                header
+                + struct.pack("<I", len(txids))
+                + compact_size(len(hashes)) + b"".join(hashes)
+                + compact_size(len(flags)) + flags
+            ).hex()
+
+    return {
+        "txid": txid.lower(),
+        "block_hash": bytes_to_txid(block["block_hash"]),
+        "block_height": block["height"],
+        "merkle_root": bytes_to_txid(root),
+        "position": index,
+        "tx_count": len(txids),
+        # Sibling hashes from the leaf up, in the same byte order as txids;
+        # at each level the sibling is on the right when position's bit is 0
+        "branch": [bytes_to_txid(h) for h in branch],
+        "proof": proof,
+    }
+
+
+MAX_PAGE_SIZE = 1000
+
+
+def block_hash_to_bytes(block_hash: str) -> bytes:
+    """Convert a block hash as shown by explorers/RPC to stored byte order"""
+    try:
+        raw = bytes.fromhex(block_hash)
+    except ValueError:
+        raise HTTPException(status_code=400, detail="block hash must be hex")
+    if len(raw) != 32:
+        raise HTTPException(status_code=400, detail="block hash must be 32 bytes")
+    return raw[::-1]
+
+
+def check_page(limit: int, offset: int):
+    if limit < 1 or limit > MAX_PAGE_SIZE:
+        raise HTTPException(status_code=400, detail=f"limit must be between 1 and {MAX_PAGE_SIZE}")
+    if offset < 0:
+        raise HTTPException(status_code=400, detail="offset must not be negative")
+
+
+def isoformat(ts):
+    return ts.isoformat() if ts else None
+
+
+@app.get("/block/{block_id}")
+async def get_block(block_id: str, limit: int = 100, offset: int = 0):
+    """Stored block details by height or hash, with its transactions and when
+    the observer first saw the block and each transaction"""
+    check_page(limit, offset)
+    if block_id.isdigit():
+        where, param = "b.height = %s", int(block_id)
+    else:
+        where, param = "b.block_hash = %s", block_hash_to_bytes(block_id)
+
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute(f"""
+            SELECT b.block_hash, b.height, b.version, b.prev_block_hash, b.merkle_root,
+                   b.timestamp, b.difficulty, b.bits, b.nonce, b.tx_count,
+                   b.first_seen_at, b.first_peer_addr, b.parse_error,
+                   pc.country_code AS first_peer_country
+            FROM blocks b
+            LEFT JOIN peer_connections pc ON pc.peer_addr = b.first_peer_addr
+            WHERE {where}
+        """, (param,))
+        block = cursor.fetchone()
+        if not block:
+            raise HTTPException(status_code=404, detail="Block not found")
+
+        cursor.execute("""
+            SELECT t.tx_hash, t.block_position, t.fee_satoshis, t.fee_rate, t.size_bytes, t.weight, t.vsize, t.input_count,
+                   t.output_count, t.total_output, obs.first_seen_at, obs.first_peer_addr,
+                   obs.peer_count, obs.confirmed_at
+            FROM transactions t
+            LEFT JOIN transaction_observations obs ON obs.tx_hash = t.tx_hash
+            WHERE t.block_hash = %s
+            ORDER BY obs.first_seen_at NULLS FIRST, t.tx_hash
+            LIMIT %s OFFSET %s
+        """, (block["block_hash"], limit, offset))
+        txs = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    seen_delay_ms = None
+    if block["first_seen_at"] and block["timestamp"]:
+        seen_delay_ms = int((block["first_seen_at"] - block["timestamp"]).total_seconds() * 1000)
+
+    return {
+        "hash": bytes_to_txid(block["block_hash"]),
+        "height": block["height"],
+        "version": block["version"],
+        "prev_block_hash": bytes_to_txid(block["prev_block_hash"]) if block["prev_block_hash"] else None,
+        "merkle_root": bytes_to_txid(block["merkle_root"]) if block["merkle_root"] else None,
+        "timestamp": isoformat(block["timestamp"]),
+        "difficulty": float(block["difficulty"]) if block["difficulty"] is not None else None,
+        "bits": block["bits"],
+        "nonce": block["nonce"],
+        "tx_count": block["tx_count"],
+        # Set when only the transactions before this parse error were stored
+        "parse_error": block["parse_error"],
+        "first_seen_at": isoformat(block["first_seen_at"]),
+        "first_peer_addr": block["first_peer_addr"],
+        "first_peer_country": block["first_peer_country"],
+        # Header timestamps can be off by up to two hours, so this can be negative
+        "seen_after_timestamp_ms": seen_delay_ms,
+        "transactions": [
+            {
+                "txid": bytes_to_txid(tx["tx_hash"]),
+                # Index within the block, the coinbase being 0
+                "position": tx["block_position"],
+                "fee_satoshis": tx["fee_satoshis"],
+                "fee_rate": tx["fee_rate"],
+                "size_bytes": tx["size_bytes"],
+                "weight": tx["weight"],
+                "vsize": tx["vsize"],
+                "input_count": tx["input_count"],
+                "output_count": tx["output_count"],
+                "total_output": tx["total_output"],
+                "first_seen_at": isoformat(tx["first_seen_at"]),
+                "first_peer_addr": tx["first_peer_addr"],
+                "peer_count": tx["peer_count"],
+                # Time from first announcement to the block's timestamp; None
+                # for txs that were never announced before the block
+                "mempool_ms": int((tx["confirmed_at"] - tx["first_seen_at"]).total_seconds() * 1000)
+                if tx["confirmed_at"] and tx["first_seen_at"] else None,
+            }
+            for tx in txs
+        ],
+        "limit": limit,
+        "offset": offset,
+    }
+
+
+@app.get("/address/{address}")
+async def get_address(address: str, limit: int = 100, offset: int = 0):
+    """Stored activity for an address: totals, unspent outputs and the
+    transactions that paid to or spent from it"""
+    check_page(limit, offset)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT COUNT(*) AS output_count,
+                   COALESCE(SUM(value_satoshis), 0) AS total_received,
+                   COALESCE(SUM(value_satoshis) FILTER (WHERE spent_in_tx IS NOT NULL), 0) AS total_spent,
+                   COUNT(*) FILTER (WHERE spent_in_tx IS NULL) AS unspent_count
+            FROM transaction_outputs
+            WHERE address = %s
+        """, (address,))
+        summary = cursor.fetchone()
+
+        cursor.execute("""
+            WITH activity AS (
+                SELECT tx_hash, value_satoshis AS received, 0::BIGINT AS sent
+                FROM transaction_outputs WHERE address = %(addr)s
+                UNION ALL
+                SELECT tx_hash, 0::BIGINT, COALESCE(value_satoshis, 0)
+                FROM transaction_inputs WHERE address = %(addr)s
+            )
+            SELECT a.tx_hash, SUM(a.received) AS received, SUM(a.sent) AS sent,
+                   t.block_height, obs.first_seen_at, obs.first_peer_addr
+            FROM activity a
+            LEFT JOIN transactions t ON t.tx_hash = a.tx_hash
+            LEFT JOIN transaction_observations obs ON obs.tx_hash = a.tx_hash
+            GROUP BY a.tx_hash, t.block_height, obs.first_seen_at, obs.first_peer_addr
+            ORDER BY obs.first_seen_at DESC NULLS LAST
+            LIMIT %(limit)s OFFSET %(offset)s
+        """, {"addr": address, "limit": limit, "offset": offset})
+        txs = cursor.fetchall()
+
+        cursor.execute("""
+            SELECT tx_hash, output_index, value_satoshis
+            FROM transaction_outputs
+            WHERE address = %s AND spent_in_tx IS NULL
+            ORDER BY value_satoshis DESC
+            LIMIT %s
+        """, (address, limit))
+        utxos = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    if summary["output_count"] == 0 and not txs:
+        raise HTTPException(status_code=404, detail="Address not found in stored data")
+
+    return {
+        "address": address,
+        "total_received": summary["total_received"],
+        "total_spent": summary["total_spent"],
+        # Only as complete as the stored window; older funding txs are not seen
+        "balance": summary["total_received"] - summary["total_spent"],
+        "output_count": summary["output_count"],
+        "unspent_count": summary["unspent_count"],
+        "unspent": [
+            {"txid": bytes_to_txid(u["tx_hash"]), "output_index": u["output_index"], "value_satoshis": u["value_satoshis"]}
+            for u in utxos
+        ],
+        "transactions": [
+            {
+                "txid": bytes_to_txid(tx["tx_hash"]),
+                "received": tx["received"],
+                "sent": tx["sent"],
+                "block_height": tx["block_height"],
+                "first_seen_at": isoformat(tx["first_seen_at"]),
+                "first_peer_addr": tx["first_peer_addr"],
+            }
+            for tx in txs
+        ],
+        "limit": limit,
+        "offset": offset,
+    }
+
+
+@app.get("/script-templates")
+async def get_script_templates():
+    """Tagged transaction counts per script template"""
+    try:
+        conn = get_db_connection()
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT template,
+                   COUNT(DISTINCT tx_hash) AS tx_count,
+                   COUNT(DISTINCT tx_hash) FILTER (WHERE matched_at > NOW() - INTERVAL '24 hours') AS tx_count_24h,
+                   MAX(matched_at) AS last_matched_at
+            FROM script_template_matches
+            GROUP BY template
+            ORDER BY tx_count DESC
+        """)
+        rows = cursor.fetchall()
+        cursor.close()
+        conn.close()
+
+        return {
+            "templates": [
+                {
+                    "template": row["template"],
+                    "tx_count": row["tx_count"],
+                    "tx_count_24h": row["tx_count_24h"],
+                    "last_matched_at": isoformat(row["last_matched_at"]),
+                }
+                for row in rows
+            ]
+        }
+    except Exception as e:
+        return {"templates": [], "error": str(e)}
+
+
+@app.get("/script-templates/{name}/txs")
+async def get_script_template_txs(name: str, limit: int = 100):
+    """Most recent transactions tagged with a script template"""
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT m.tx_hash, m.location, m.io_index, m.matched_at, t.block_height
+            FROM script_template_matches m
+            LEFT JOIN transactions t ON t.tx_hash = m.tx_hash
+            WHERE m.template = %s
+            ORDER BY m.matched_at DESC
+            LIMIT %s
+        """, (name, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "template": name,
+        "matches": [
+            {
+                "txid": bytes_to_txid(row["tx_hash"]),
+                "location": row["location"],
+                "index": row["io_index"],
+                "matched_at": isoformat(row["matched_at"]),
+                "block_height": row["block_height"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/spend-types")
+async def get_spend_types(hours: int = 24):
+    """Inputs by spend type, classified from their scriptSig and witness, and
+    the multisig thresholds they revealed"""
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT spend_type, multisig, SUM(inputs)::BIGINT AS inputs, COUNT(*) AS tx_count
+            FROM input_spend_types
+            WHERE recorded_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY spend_type, multisig
+            ORDER BY inputs DESC
+        """, (hours,))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    types = {}
+    multisig = []
+    for row in rows:
+        t = types.setdefault(row["spend_type"], {"spend_type": row["spend_type"], "inputs": 0, "tx_count": 0})
+        t["inputs"] += row["inputs"]
+        t["tx_count"] += row["tx_count"]
+        if row["multisig"]:
+            multisig.append({
+                "spend_type": row["spend_type"],
+                "multisig": row["multisig"],
+                "inputs": row["inputs"],
+                "tx_count": row["tx_count"],
+            })
+    total = sum(t["inputs"] for t in types.values())
+    for t in types.values():
+        t["share"] = t["inputs"] / total if total else 0
+
+    return {
+        "hours": hours,
+        "inputs": total,
+        "spend_types": sorted(types.values(), key=lambda t: t["inputs"], reverse=True),
+        "multisig": multisig,
+    }
+
+
+ROLLUP_GRANULARITIES = ("hour", "day")
+
+
+@app.get("/rollups")
+async def get_rollups(granularity: str = "hour", region: str = "all", limit: int = 48):
+    """Precomputed hourly or daily observation rollups for a region ("all" for
+    every region), newest bucket first"""
+    if granularity not in ROLLUP_GRANULARITIES:
+        raise HTTPException(status_code=400, detail="granularity must be 'hour' or 'day'")
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT bucket_start, tx_count, total_fees, median_fee_rate, announcement_count,
+                   median_propagation_ms, p90_propagation_ms, peer_count, updated_at
+            FROM observation_rollups
+            WHERE granularity = %s AND region = %s
+            ORDER BY bucket_start DESC
+            LIMIT %s
+        """, (granularity, region, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "granularity": granularity,
+        "region": region,
+        "buckets": [
+            {
+                "bucket_start": isoformat(row["bucket_start"]),
+                "tx_count": row["tx_count"],
+                "total_fees": row["total_fees"],
+                "median_fee_rate": row["median_fee_rate"],
+                "announcement_count": row["announcement_count"],
+                "median_propagation_ms": row["median_propagation_ms"],
+                "p90_propagation_ms": row["p90_propagation_ms"],
+                "peer_count": row["peer_count"],
+                "updated_at": isoformat(row["updated_at"]),
+            }
+            for row in rows
+        ],
+    }
+
+
+def signaling_row(row):
+    return {
+        "bit": row["bit"],
+        "signaling_blocks": row["signaling_blocks"],
+        "total_blocks": row["total_blocks"],
+        "signaling_pct": round(100.0 * row["signaling_blocks"] / row["total_blocks"], 2)
+        if row["total_blocks"] else None,
+    }
+
+
+@app.get("/versionbits")
+async def get_versionbits(bit: Optional[int] = None, limit: int = 144):
+    """BIP9/BIP8 version-bits signaling over the last 2016 blocks and in the
+    current retarget period, for bits with any signaling blocks. With bit set,
+    also that bit's rolling-window history, newest block first."""
+    if bit is not None and not 0 <= bit <= 28:
+        raise HTTPException(status_code=400, detail="bit must be between 0 and 28")
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT tip_height, bit, signaling_blocks, total_blocks, recorded_at
+            FROM version_bits_windows
+            WHERE tip_height = (SELECT MAX(tip_height) FROM version_bits_windows)
+            ORDER BY bit
+        """)
+        window = cursor.fetchall()
+        cursor.execute("""
+            SELECT period_start, bit, signaling_blocks, total_blocks, updated_at
+            FROM version_bits_signaling
+            WHERE period_start = (SELECT MAX(period_start) FROM version_bits_signaling)
+              AND signaling_blocks > 0
+            ORDER BY bit
+        """)
+        period = cursor.fetchall()
+        history = []
+        if bit is not None:
+            cursor.execute("""
+                SELECT tip_height, bit, signaling_blocks, total_blocks, recorded_at
+                FROM version_bits_windows
+                WHERE bit = %s
+                ORDER BY tip_height DESC
+                LIMIT %s
+            """, (bit, limit))
+            history = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    result = {
+        "window": {
+            "tip_height": window[0]["tip_height"] if window else None,
+            "recorded_at": isoformat(window[0]["recorded_at"]) if window else None,
+            "bits": [signaling_row(row) for row in window],
+        },
+        "period": {
+            "period_start": period[0]["period_start"] if period else None,
+            "updated_at": isoformat(period[0]["updated_at"]) if period else None,
+            "bits": [signaling_row(row) for row in period],
+        },
+    }
+    if bit is not None:
+        result["history"] = [
+            {"tip_height": row["tip_height"], **signaling_row(row)}
+            for row in history
+        ]
+    return result
+
+
+@app.get("/peer-identities")
+async def get_peer_identities(min_addresses: int = 2, limit: int = 100):
+    """Long-term peer identities with statistics summed over every address
+    they have used, most addresses first"""
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT s.identity_id, s.user_agent, s.asn, s.first_seen_at, s.last_seen_at,
+                   s.addresses, s.connection_count, s.tx_announcements, s.block_announcements,
+                   s.avg_latency_ms, pi.last_addr
+            FROM peer_identity_stats s
+            JOIN peer_identities pi ON pi.id = s.identity_id
+            WHERE s.addresses >= %s
+            ORDER BY s.addresses DESC, s.last_seen_at DESC
+            LIMIT %s
+        """, (min_addresses, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "identities": [
+            {
+                "identity_id": row["identity_id"],
+                "user_agent": row["user_agent"],
+                "asn": row["asn"],
+                "first_seen_at": isoformat(row["first_seen_at"]),
+                "last_seen_at": isoformat(row["last_seen_at"]),
+                "last_addr": row["last_addr"],
+                "addresses": row["addresses"],
+                "connection_count": row["connection_count"],
+                "tx_announcements": row["tx_announcements"],
+                "block_announcements": row["block_announcements"],
+                "avg_latency_ms": row["avg_latency_ms"],
+            }
+            for row in rows
+        ],
+    }
+
+
+
+
+def lookup_project(name: str) -> int:
+    """Project id by name; 404 if there is no such project"""
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("SELECT id FROM projects WHERE name = %s", (name,))
+        row = cursor.fetchone()
+        cursor.close()
+    finally:
+        conn.close()
+    if not row:
+        raise HTTPException(status_code=404, detail="Project not found")
+    return row["id"]
+
+
+@app.get("/projects")
+async def get_projects():
+    """Observation projects with their peer filters and tagged transaction
+    counts (all time and last 24h)"""
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT p.name, p.description, p.config, p.peer_countries, p.peer_regions, p.peer_asns,
+                   p.created_at, p.updated_at,
+                   COUNT(pt.tx_hash) AS tx_count,
+                   COUNT(pt.tx_hash) FILTER (WHERE pt.matched_at > NOW() - INTERVAL '24 hours') AS tx_count_24h
+            FROM projects p
+            LEFT JOIN project_transactions pt ON pt.project_id = p.id
+            GROUP BY p.id
+            ORDER BY p.name
+        """)
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "projects": [
+            {
+                "name": row["name"],
+                "description": row["description"],
+                "config": row["config"],
+                "peers": {
+                    "countries": row["peer_countries"],
+                    "regions": row["peer_regions"],
+                    "asns": row["peer_asns"],
+                },
+                "created_at": isoformat(row["created_at"]),
+                "updated_at": isoformat(row["updated_at"]),
+                "tx_count": row["tx_count"],
+                "tx_count_24h": row["tx_count_24h"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/projects/{name}/transactions")
+async def get_project_transactions(name: str, hours: int = 24, limit: int = 100, offset: int = 0):
+    """A project's tagged transactions, newest first, with first-seen time,
+    first peer and peer count counted over the project's peers only"""
+    check_page(limit, offset)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    project_id = lookup_project(name)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT pt.tx_hash, pt.reason, pt.matched_at,
+                   MIN(e.announcement_time) AS first_seen_at,
+                   (ARRAY_AGG(e.peer_addr ORDER BY e.announcement_time, e.id))[1] AS first_peer_addr,
+                   COUNT(DISTINCT e.peer_addr) AS peer_count,
+                   MAX(e.delay_from_first_ms) AS spread_ms
+            FROM project_transactions pt
+            LEFT JOIN project_propagation_events e
+                   ON e.project_id = pt.project_id AND e.tx_hash = pt.tx_hash
+            WHERE pt.project_id = %s
+              AND pt.matched_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY pt.tx_hash, pt.reason, pt.matched_at
+            ORDER BY pt.matched_at DESC
+            LIMIT %s OFFSET %s
+        """, (project_id, hours, limit, offset))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "project": name,
+        "hours": hours,
+        "transactions": [
+            {
+                "txid": bytes_to_txid(row["tx_hash"]),
+                "reason": row["reason"],
+                "matched_at": isoformat(row["matched_at"]),
+                "first_seen_at": isoformat(row["first_seen_at"]),
+                "first_peer": row["first_peer_addr"],
+                "peer_count": row["peer_count"],
+                "spread_ms": row["spread_ms"],
+            }
+            for row in rows
+        ],
+    }
+
+@app.get("/peer-sessions")
+async def get_peer_sessions(hours: int = 24, region: Optional[str] = None,
+                            peer: Optional[str] = None, limit: int = 100):
+    """Peer connections started in the window with their transport metadata:
+    ping round trips next to the kernel's TCP RTT, MSS and retransmits.
+    TCP fields are null off Linux and for Tor peers."""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT id, peer_addr, region, transport, started_at, ended_at,
+                   ping_count, avg_ping_ms, min_ping_ms, tcp_rtt_ms, tcp_rttvar_ms,
+                   tcp_min_rtt_ms, mss, pmtu, retransmits,
+                   sendheaders, cmpct_version, wtxidrelay, addrv2
+            FROM peer_sessions
+            WHERE started_at > NOW() - %s * INTERVAL '1 hour'
+              AND (%s::TEXT IS NULL OR region = %s)
+              AND (%s::TEXT IS NULL OR peer_addr = %s)
+            ORDER BY started_at DESC
+            LIMIT %s
+        """, (hours, region, region, peer, peer, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    def overhead(row):
+        # Time the peer's node adds on top of the network round trip
+        if row["avg_ping_ms"] is None or row["tcp_rtt_ms"] is None:
+            return None
+        return round(row["avg_ping_ms"] - row["tcp_rtt_ms"], 1)
+
+    return {
+        "hours": hours,
+        "sessions": [
+            {
+                "id": row["id"],
+                "peer": row["peer_addr"],
+                "region": row["region"],
+                "transport": row["transport"],
+                "started_at": isoformat(row["started_at"]),
+                "ended_at": isoformat(row["ended_at"]),
+                "ping_count": row["ping_count"],
+                "avg_ping_ms": row["avg_ping_ms"],
+                "min_ping_ms": row["min_ping_ms"],
+                "tcp_rtt_ms": row["tcp_rtt_ms"],
+                "tcp_rttvar_ms": row["tcp_rttvar_ms"],
+                "tcp_min_rtt_ms": row["tcp_min_rtt_ms"],
+                "ping_overhead_ms": overhead(row),
+                "mss": row["mss"],
+                "pmtu": row["pmtu"],
+                "retransmits": row["retransmits"],
+                # Null until recorded, 30 seconds into the session
+                "features": None if row["sendheaders"] is None else {
+                    "sendheaders": row["sendheaders"],
+                    "cmpct_version": row["cmpct_version"],
+                    "wtxidrelay": row["wtxidrelay"],
+                    "addrv2": row["addrv2"],
+                },
+            }
+            for row in rows
+        ],
+    }
+
+@app.get("/feature-adoption")
+async def get_feature_adoption(days: int = 30, region: Optional[str] = None):
+    """Per day, the share of distinct peers whose sessions negotiated each
+    feature, from our own handshakes. A peer counts once per day, with any
+    of its sessions that day."""
+    if days < 1:
+        raise HTTPException(status_code=400, detail="days must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT day, COUNT(*) AS peers,
+                   COUNT(*) FILTER (WHERE sendheaders) AS sendheaders,
+                   COUNT(*) FILTER (WHERE cmpct_version >= 2) AS cmpct_v2,
+                   COUNT(*) FILTER (WHERE wtxidrelay) AS wtxidrelay,
+                   COUNT(*) FILTER (WHERE addrv2) AS addrv2
+            FROM (
+                SELECT DATE(started_at) AS day, peer_addr,
+                       BOOL_OR(sendheaders) AS sendheaders,
+                       MAX(cmpct_version) AS cmpct_version,
+                       BOOL_OR(wtxidrelay) AS wtxidrelay,
+                       BOOL_OR(addrv2) AS addrv2
+                FROM peer_sessions
+                WHERE started_at > NOW() - %s * INTERVAL '1 day'
+                  AND sendheaders IS NOT NULL
+                  AND (%s::TEXT IS NULL OR region = %s)
+                GROUP BY DATE(started_at), peer_addr
+            ) p
+            GROUP BY day
+            ORDER BY day
+        """, (days, region, region))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    def share(row, feature):
+        return round(row[feature] / row["peers"], 4)
+
+    return {
+        "days": days,
+        "adoption": [
+            {
+                "day": row["day"].isoformat(),
+                "peers": row["peers"],
+                "sendheaders": share(row, "sendheaders"),
+                "cmpct_v2": share(row, "cmpct_v2"),
+                "wtxidrelay": share(row, "wtxidrelay"),
+                "addrv2": share(row, "addrv2"),
+            }
+            for row in rows
+        ],
+    }
+
+@app.get("/peer-latency")
+async def get_peer_latency(kind: str = "tx", observer: Optional[str] = None,
+                           min_samples: int = 100, limit: int = 100):
+    """Per-peer latency profiles: median and p90 delay behind the first
+    announcement of each transaction or block, slowest first"""
+    if kind not in ("tx", "block"):
+        raise HTTPException(status_code=400, detail="kind must be 'tx' or 'block'")
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT l.peer_addr, l.observer_id, l.samples, l.median_ms, l.p90_ms, l.updated_at,
+                   p.country_code, p.region, p.user_agent
+            FROM peer_latency_profiles l
+            LEFT JOIN peer_connections p ON p.peer_addr = l.peer_addr
+            WHERE l.kind = %s
+              AND l.samples >= %s
+              AND (%s::TEXT IS NULL OR l.observer_id = %s)
+            ORDER BY l.median_ms DESC, l.p90_ms DESC
+            LIMIT %s
+        """, (kind, min_samples, observer, observer, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "kind": kind,
+        "peers": [
+            {
+                "peer": row["peer_addr"],
+                "observer": row["observer_id"],
+                "country": row["country_code"],
+                "region": row["region"],
+                "user_agent": row["user_agent"],
+                "samples": row["samples"],
+                "median_ms": row["median_ms"],
+                "p90_ms": row["p90_ms"],
+                "updated_at": isoformat(row["updated_at"]),
+            }
+            for row in rows
+        ],
+    }
+
+@app.get("/block-ordering")
+async def get_block_ordering(miner: Optional[str] = None, hours: int = 24, limit: int = 100):
+    """Per-block comparison of transaction order with fee-rate order, newest
+    first. entropy is the fraction of transaction pairs placed against
+    fee-rate order."""
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be at least 1")
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT o.block_hash, o.height, o.miner, o.txs, o.fee_known_txs, o.inversions,
+                   o.entropy, o.prioritized, o.prioritized_txs
+            FROM block_ordering o
+            WHERE o.computed_at > NOW() - %s * INTERVAL '1 hour'
+              AND (%s::TEXT IS NULL OR o.miner = %s)
+            ORDER BY o.height DESC
+            LIMIT %s
+        """, (hours, miner, miner, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "blocks": [
+            {
+                "hash": bytes_to_txid(row["block_hash"]),
+                "height": row["height"],
+                "miner": row["miner"],
+                "txs": row["txs"],
+                "fee_known_txs": row["fee_known_txs"],
+                "inversions": row["inversions"],
+                "entropy": round(row["entropy"], 4),
+                "prioritized": row["prioritized"],
+                "prioritized_txs": [bytes_to_txid(bytes(h)) for h in row["prioritized_txs"]],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/miner-ordering")
+async def get_miner_ordering(hours: int = 168):
+    """Ordering entropy and prioritized transactions summarized per miner,
+    most fee-ordered first"""
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be at least 1")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT miner, COUNT(*) AS blocks,
+                   AVG(entropy) AS avg_entropy,
+                   PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY entropy) AS median_entropy,
+                   MAX(entropy) AS max_entropy,
+                   SUM(prioritized)::BIGINT AS prioritized
+            FROM block_ordering
+            WHERE computed_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY miner
+            ORDER BY avg_entropy
+        """, (hours,))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "hours": hours,
+        "miners": [
+            {
+                "miner": row["miner"],
+                "blocks": row["blocks"],
+                "avg_entropy": round(row["avg_entropy"], 4),
+                "median_entropy": round(row["median_entropy"], 4),
+                "max_entropy": round(row["max_entropy"], 4),
+                "prioritized": row["prioritized"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/peer-scores")
+async def get_peer_scores(banned: Optional[bool] = None, observer: Optional[str] = None, limit: int = 100):
+    """Peers' saved penalty scores, highest first. Penalties are as of
+    updated_at and decay afterwards."""
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT s.peer_addr, s.observer_id, s.penalty, s.counts, s.ban_reason, s.failed_at,
+                   s.updated_at, p.country_code, p.user_agent
+            FROM peer_scores s
+            LEFT JOIN peer_connections p ON p.peer_addr = s.peer_addr
+            WHERE (%s::BOOLEAN IS NULL OR (s.ban_reason IS NOT NULL) = %s)
+              AND (%s::TEXT IS NULL OR s.observer_id = %s)
+            ORDER BY s.penalty DESC
+            LIMIT %s
+        """, (banned, banned, observer, observer, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "peers": [
+            {
+                "peer": row["peer_addr"],
+                "observer": row["observer_id"],
+                "country": row["country_code"],
+                "user_agent": row["user_agent"],
+                "penalty": round(row["penalty"], 1),
+                "counts": row["counts"],
+                "ban_reason": row["ban_reason"],
+                "failed_at": isoformat(row["failed_at"]),
+                "updated_at": isoformat(row["updated_at"]),
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/peer-traffic")
+async def get_peer_traffic(hours: int = 24, group: str = "region", region: Optional[str] = None,
+                           limit: int = 100):
+    """Peer traffic over the last hours, summed by region, peer or message
+    type, heaviest first. Bytes count messages as framed, header included."""
+    columns = {"region": "region", "peer": "peer_addr", "command": "command"}
+    if group not in columns:
+        raise HTTPException(status_code=400, detail="group must be 'region', 'peer' or 'command'")
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    check_page(limit, 0)
+    column = columns[group]
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute(f"""
+            SELECT {column} AS key,
+                   SUM(bytes_sent) AS bytes_sent, SUM(bytes_received) AS bytes_received,
+                   SUM(messages_sent) AS messages_sent, SUM(messages_received) AS messages_received,
+                   COUNT(DISTINCT peer_addr) AS peers
+            FROM peer_traffic
+            WHERE hour > NOW() - %s * INTERVAL '1 hour'
+              AND (%s::TEXT IS NULL OR region = %s)
+            GROUP BY {column}
+            ORDER BY SUM(bytes_sent + bytes_received) DESC
+            LIMIT %s
+        """, (hours, region, region, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "hours": hours,
+        "group": group,
+        "traffic": [
+            {
+                group: row["key"],
+                "peers": row["peers"],
+                "bytes_sent": int(row["bytes_sent"]),
+                "bytes_received": int(row["bytes_received"]),
+                "messages_sent": int(row["messages_sent"]),
+                "messages_received": int(row["messages_received"]),
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/claim-reports")
+async def get_claim_reports(hours: int = 24, peer: Optional[str] = None, excluded: Optional[bool] = None,
+                            limit: int = 100):
+    """Peers' claim reports over the last hours, newest first: what each
+    claimed, how it behaved and where the two diverged."""
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT *
+            FROM peer_claim_reports
+            WHERE reported_at > NOW() - %s * INTERVAL '1 hour'
+              AND (%s::TEXT IS NULL OR peer_addr = %s)
+              AND (%s::BOOLEAN IS NULL OR excluded = %s)
+            ORDER BY reported_at DESC
+            LIMIT %s
+        """, (hours, peer, peer, excluded, excluded, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "hours": hours,
+        "reports": [
+            {
+                "peer": row["peer_addr"],
+                "observer": row["observer_id"],
+                "region": row["region"],
+                "reported_at": isoformat(row["reported_at"]),
+                "services": row["services"],
+                "start_height": row["start_height"],
+                "best_height": row["best_height"],
+                "fee_filter": row["fee_filter"],
+                "blocks_requested": row["blocks_requested"],
+                "blocks_served": row["blocks_served"],
+                "blocks_not_found": row["blocks_not_found"],
+                "headers_asked": row["headers_asked"],
+                "headers_answered": row["headers_answered"],
+                "txs_relayed": row["txs_relayed"],
+                "txs_below_filter": row["txs_below_filter"],
+                "divergences": row["divergences"],
+                "streak": row["streak"],
+                "clean_streak": row["clean_streak"],
+                "excluded": row["excluded"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/region-suggestions")
+async def get_region_suggestions(kind: Optional[str] = None, observer: Optional[str] = None):
+    """Countries and ASes where nodes are seen but the observer has no peer,
+    ranked as places to add a vantage point"""
+    if kind is not None and kind not in ("country", "asn"):
+        raise HTTPException(status_code=400, detail="kind must be 'country' or 'asn'")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT kind, key, observer_id, country_code, org_name, rank, nodes,
+                   reachable, heard, asns, auto_added, computed_at
+            FROM region_suggestions
+            WHERE (%s::TEXT IS NULL OR kind = %s)
+              AND (%s::TEXT IS NULL OR observer_id = %s)
+            ORDER BY kind DESC, observer_id, rank
+        """, (kind, kind, observer, observer))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "suggestions": [
+            {
+                "kind": row["kind"],
+                "key": row["key"],
+                "observer": row["observer_id"],
+                "country": row["country_code"],
+                "org": row["org_name"],
+                "rank": row["rank"],
+                "nodes": row["nodes"],
+                "reachable": row["reachable"],
+                "heard": row["heard"],
+                "asns": row["asns"] if row["kind"] == "country" else None,
+                "auto_added": row["auto_added"],
+                "computed_at": isoformat(row["computed_at"]),
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/gossip-sources")
+async def get_gossip_sources(hours: int = 24, limit: int = 100):
+    """Peers ranked by how many addresses they advertised in the window, with
+    how many of those no other peer has advertised"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT s.source_peer, COUNT(*) AS addresses,
+                   COUNT(*) FILTER (WHERE NOT EXISTS (
+                       SELECT 1 FROM peer_address_sources o
+                       WHERE o.address = s.address AND o.source_peer <> s.source_peer
+                   )) AS exclusive_addresses,
+                   MAX(s.last_heard_at) AS last_heard_at,
+                   pc.region, pc.country_code, pc.user_agent
+            FROM peer_address_sources s
+            LEFT JOIN peer_connections pc ON pc.peer_addr = s.source_peer
+            WHERE s.last_heard_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY s.source_peer, pc.region, pc.country_code, pc.user_agent
+            ORDER BY addresses DESC
+            LIMIT %s
+        """, (hours, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "hours": hours,
+        "sources": [
+            {
+                "peer": row["source_peer"],
+                "region": row["region"],
+                "country_code": row["country_code"],
+                "user_agent": row["user_agent"],
+                "addresses": row["addresses"],
+                "exclusive_addresses": row["exclusive_addresses"],
+                "last_heard_at": isoformat(row["last_heard_at"]),
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/gossip-sources/{address}")
+async def get_address_sources(address: str):
+    """Every peer that advertised a gossiped address (host:port), first
+    source first"""
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT network, services, first_heard_at, last_heard_at, heard_count
+            FROM peer_addresses
+            WHERE address = %s
+        """, (address,))
+        addr = cursor.fetchone()
+        if not addr:
+            raise HTTPException(status_code=404, detail="Address never gossiped to the observer")
+
+        cursor.execute("""
+            SELECT s.source_peer, s.first_heard_at, s.last_heard_at, s.heard_count,
+                   pc.region, pc.country_code
+            FROM peer_address_sources s
+            LEFT JOIN peer_connections pc ON pc.peer_addr = s.source_peer
+            WHERE s.address = %s
+            ORDER BY s.first_heard_at
+        """, (address,))
+        sources = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "address": address,
+        "network": addr["network"],
+        "services": addr["services"],
+        "first_heard_at": isoformat(addr["first_heard_at"]),
+        "last_heard_at": isoformat(addr["last_heard_at"]),
+        "heard_count": addr["heard_count"],
+        "sources": [
+            {
+                "peer": row["source_peer"],
+                "region": row["region"],
+                "country_code": row["country_code"],
+                "first_heard_at": isoformat(row["first_heard_at"]),
+                "last_heard_at": isoformat(row["last_heard_at"]),
+                "heard_count": row["heard_count"],
+            }
+            for row in sources
+        ],
+    }
+
+
+MAX_CENSUS_GROUPS = 30
+
+# Service bits reported in census summaries
+SERVICE_FLAGS = {
+    "network": 1 << 0,
+    "bloom": 1 << 2,
+    "witness": 1 << 3,
+    "compact_filters": 1 << 6,
+    "network_limited": 1 << 10,
+    "p2p_v2": 1 << 11,
+}
+
+
+@app.get("/census")
+async def get_census_snapshots(limit: int = 20):
+    """Network census snapshots from `lens crawl`, newest first"""
+    check_page(limit, 0)
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT id, network, started_at, finished_at, attempted, reachable
+            FROM census_snapshots
+            ORDER BY id DESC
+            LIMIT %s
+        """, (limit,))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "snapshots": [
+            {
+                "id": row["id"],
+                "network": row["network"],
+                "started_at": isoformat(row["started_at"]),
+                "finished_at": isoformat(row["finished_at"]),
+                "attempted": row["attempted"],
+                "reachable": row["reachable"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/census/{snapshot}")
+async def get_census_snapshot(snapshot: str):
+    """Breakdown of one census snapshot (by id, or "latest"): reachable nodes
+    by country, ASN, user agent, protocol version and service flag, plus the
+    height they reported"""
+    if snapshot != "latest" and not snapshot.isdigit():
+        raise HTTPException(status_code=400, detail="snapshot must be an id or latest")
+
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        if snapshot == "latest":
+            cursor.execute("""
+                SELECT id, network, started_at, finished_at, attempted, reachable
+                FROM census_snapshots
+                ORDER BY id DESC
+                LIMIT 1
+            """)
+        else:
+            cursor.execute("""
+                SELECT id, network, started_at, finished_at, attempted, reachable
+                FROM census_snapshots
+                WHERE id = %s
+            """, (int(snapshot),))
+        snap = cursor.fetchone()
+        if not snap:
+            raise HTTPException(status_code=404, detail="Census snapshot not found")
+
+        groups = {}
+        for name, column in (("countries", "country_code"), ("asns", "asn"),
+                             ("user_agents", "user_agent"), ("protocol_versions", "protocol_version")):
+            cursor.execute(f"""
+                SELECT {column} AS value, COUNT(*) AS nodes
+                FROM census_nodes
+                WHERE snapshot_id = %s
+                GROUP BY {column}
+                ORDER BY nodes DESC, {column}
+                LIMIT %s
+            """, (snap["id"], MAX_CENSUS_GROUPS))
+            groups[name] = [{"value": row["value"], "nodes": row["nodes"]} for row in cursor.fetchall()]
+
+        flag_columns = ", ".join(
+            f"COUNT(*) FILTER (WHERE services & {bit} <> 0) AS {name}" for name, bit in SERVICE_FLAGS.items()
+        )
+        cursor.execute(f"""
+            SELECT {flag_columns},
+                   MAX(start_height) AS max_height,
+                   PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY start_height) AS median_height,
+                   PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY handshake_ms) AS median_handshake_ms
+            FROM census_nodes
+            WHERE snapshot_id = %s
+        """, (snap["id"],))
+        stats = cursor.fetchone()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "id": snap["id"],
+        "network": snap["network"],
+        "started_at": isoformat(snap["started_at"]),
+        "finished_at": isoformat(snap["finished_at"]),
+        "attempted": snap["attempted"],
+        "reachable": snap["reachable"],
+        "services": {name: stats[name] for name in SERVICE_FLAGS},
+        # Heights are what nodes reported at handshake, so lagging or
+        # syncing nodes pull the median down
+        "max_height": stats["max_height"],
+        "median_height": stats["median_height"],
+        "median_handshake_ms": stats["median_handshake_ms"],
+        **groups,
+    }
+
+
+@app.get("/fee-alerts")
+async def get_fee_alerts(hours: int = 24, limit: int = 100):
+    """Transactions observed paying extreme fees, newest first, with how far
+    each had propagated when the alert was raised"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT fa.tx_hash, fa.reason, fa.fee_satoshis, fa.fee_rate, fa.first_seen_at,
+                   fa.first_peer_addr, fa.first_region, fa.peer_count, fa.region_count,
+                   fa.alerted_at, t.block_height
+            FROM fee_alerts fa
+            LEFT JOIN transactions t ON t.tx_hash = fa.tx_hash
+            WHERE fa.alerted_at > NOW() - %s * INTERVAL '1 hour'
+            ORDER BY fa.alerted_at DESC
+            LIMIT %s
+        """, (hours, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "alerts": [
+            {
+                "txid": bytes_to_txid(row["tx_hash"]),
+                "reason": row["reason"],
+                "fee_satoshis": row["fee_satoshis"],
+                "fee_rate": row["fee_rate"],
+                "first_seen_at": isoformat(row["first_seen_at"]),
+                "first_peer": row["first_peer_addr"],
+                "first_region": row["first_region"],
+                "peer_count": row["peer_count"],
+                "region_count": row["region_count"],
+                "alerted_at": isoformat(row["alerted_at"]),
+                "confirmed_height": row["block_height"],
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/low-fee-relays")
+async def get_low_fee_relays(hours: int = 24, limit: int = 100):
+    """Peers that relayed transactions paying below the minimum relay fee
+    rate, most such transactions first, with each peer's share of its
+    announcements"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT COUNT(*) AS txs,
+                   COUNT(*) FILTER (WHERE kind = 'zero_fee') AS zero_fee
+            FROM low_fee_transactions
+            WHERE detected_at > NOW() - %s * INTERVAL '1 hour'
+        """, (hours,))
+        totals = cursor.fetchone()
+        cursor.execute("""
+            SELECT r.peer_addr, pc.user_agent, pc.region,
+                   COUNT(*) AS txs,
+                   COUNT(*) FILTER (WHERE lt.kind = 'zero_fee') AS zero_fee,
+                   MIN(lt.fee_rate) AS min_fee_rate,
+                   MAX(r.announced_at) AS last_relayed_at,
+                   (SELECT COUNT(*) FROM propagation_events pe
+                    WHERE pe.peer_addr = r.peer_addr
+                      AND pe.announcement_time > NOW() - %s * INTERVAL '1 hour') AS announcements
+            FROM low_fee_relays r
+            JOIN low_fee_transactions lt ON lt.tx_hash = r.tx_hash
+            LEFT JOIN peer_connections pc ON pc.peer_addr = r.peer_addr
+            WHERE lt.detected_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY r.peer_addr, pc.user_agent, pc.region
+            ORDER BY txs DESC
+            LIMIT %s
+        """, (hours, hours, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "low_fee_txs": totals["txs"],
+        "zero_fee_txs": totals["zero_fee"],
+        "peers": [
+            {
+                "peer": row["peer_addr"],
+                "user_agent": row["user_agent"],
+                "region": row["region"],
+                "txs": row["txs"],
+                "zero_fee_txs": row["zero_fee"],
+                "min_fee_rate": row["min_fee_rate"],
+                "last_relayed_at": isoformat(row["last_relayed_at"]),
+                "share": row["txs"] / row["announcements"] if row["announcements"] else None,
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/locktimes")
+async def get_locktimes(hours: int = 168, kind: Optional[str] = None, limit: int = 100):
+    """Transactions seen before their locktime expired: how many became
+    valid and confirmed, blocks from validity to confirmation per lock kind,
+    and the most recently seen ones"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    if kind is not None and kind not in ("height", "time"):
+        raise HTTPException(status_code=400, detail="kind must be height or time")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT lock_kind,
+                   COUNT(*) AS txs,
+                   COUNT(valid_height) AS valid,
+                   COUNT(confirmed_height) AS confirmed,
+                   COUNT(*) FILTER (WHERE confirmed_height = valid_height) AS first_block,
+                   PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY confirmed_height - valid_height) AS p50_delay,
+                   PERCENTILE_CONT(0.9) WITHIN GROUP (ORDER BY confirmed_height - valid_height) AS p90_delay,
+                   PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY valid_height - seen_tip_height) AS p50_lead
+            FROM locktime_transactions
+            WHERE first_seen_at > NOW() - %s * INTERVAL '1 hour'
+              AND (%s IS NULL OR lock_kind = %s)
+            GROUP BY lock_kind
+            ORDER BY lock_kind
+        """, (hours, kind, kind))
+        summary = cursor.fetchall()
+        cursor.execute("""
+            SELECT tx_hash, lock_kind, lock_value, seen_tip_height, first_seen_at,
+                   valid_height, valid_at, confirmed_height, confirmed_at
+            FROM locktime_transactions
+            WHERE first_seen_at > NOW() - %s * INTERVAL '1 hour'
+              AND (%s IS NULL OR lock_kind = %s)
+            ORDER BY first_seen_at DESC
+            LIMIT %s
+        """, (hours, kind, kind, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "kinds": [
+            {
+                "kind": row["lock_kind"],
+                "txs": row["txs"],
+                "valid": row["valid"],
+                "confirmed": row["confirmed"],
+                "confirmed_first_block": row["first_block"],
+                "p50_delay_blocks": row["p50_delay"],
+                "p90_delay_blocks": row["p90_delay"],
+                "p50_lead_blocks": row["p50_lead"],
+            }
+            for row in summary
+        ],
+        "transactions": [
+            {
+                "txid": bytes_to_txid(row["tx_hash"]),
+                "kind": row["lock_kind"],
+                "locktime": row["lock_value"],
+                "seen_tip_height": row["seen_tip_height"],
+                "first_seen_at": isoformat(row["first_seen_at"]),
+                "valid_height": row["valid_height"],
+                "valid_at": isoformat(row["valid_at"]),
+                "confirmed_height": row["confirmed_height"],
+                "confirmed_at": isoformat(row["confirmed_at"]),
+                "delay_blocks": row["confirmed_height"] - row["valid_height"]
+                if row["confirmed_height"] is not None and row["valid_height"] is not None else None,
+            }
+            for row in rows
+        ],
+    }
+
+
+@app.get("/relay-probes")
+async def get_relay_probes(hours: int = 168, limit: int = 100):
+    """Relay policy measured with probe transactions on a test network: per
+    variant, how many probes other peers relayed and how fast, plus the most
+    recent probes"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT variant, output_type, MIN(fee_rate) AS fee_rate, MAX(data_bytes) AS data_bytes,
+                   COUNT(*) AS probes,
+                   COUNT(*) FILTER (WHERE relay_peers > 0) AS relayed,
+                   AVG(relay_peers) AS avg_relay_peers,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY first_relay_ms) AS p50_first_relay_ms,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY median_relay_ms) AS p50_median_relay_ms
+            FROM relay_probes
+            WHERE sent_at > NOW() - %s * INTERVAL '1 hour' AND measured_at IS NOT NULL
+            GROUP BY variant, output_type
+            ORDER BY variant
+        """, (hours,))
+        variants = cursor.fetchall()
+        cursor.execute("""
+            SELECT tx_hash, variant, fee_rate, vsize, output_type, data_bytes, first_hop,
+                   sent_at, observer_id, relay_peers, first_relay_ms, median_relay_ms
+            FROM relay_probes
+            WHERE sent_at > NOW() - %s * INTERVAL '1 hour'
+            ORDER BY sent_at DESC
+            LIMIT %s
+        """, (hours, limit))
+        probes = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    return {
+        "variants": [
+            {
+                "variant": row["variant"],
+                "output_type": row["output_type"],
+                "fee_rate": row["fee_rate"],
+                "data_bytes": row["data_bytes"],
+                "probes": row["probes"],
+                "relayed": row["relayed"],
+                "relayed_share": row["relayed"] / row["probes"],
+                "avg_relay_peers": float(row["avg_relay_peers"]) if row["avg_relay_peers"] is not None else None,
+                "p50_first_relay_ms": row["p50_first_relay_ms"],
+                "p50_median_relay_ms": row["p50_median_relay_ms"],
+            }
+            for row in variants
+        ],
+        "probes": [
+            {
+                "txid": bytes_to_txid(row["tx_hash"]),
+                "variant": row["variant"],
+                "fee_rate": row["fee_rate"],
+                "vsize": row["vsize"],
+                "output_type": row["output_type"],
+                "data_bytes": row["data_bytes"],
+                "first_hop": row["first_hop"],
+                "sent_at": isoformat(row["sent_at"]),
+                "observer": row["observer_id"],
+                "relay_peers": row["relay_peers"],
+                "first_relay_ms": row["first_relay_ms"],
+                "median_relay_ms": row["median_relay_ms"],
+            }
+            for row in probes
+        ],
+    }
+
+
+@app.get("/conflict-outcomes")
+async def get_conflict_outcomes(hours: int = 168, limit: int = 100):
+    """How double-spend and RBF conflicts resolved: per outcome counts, time
+    to settle and winning fee deltas, plus the most recently settled pairs"""
+    check_page(limit, 0)
+    if hours < 1:
+        raise HTTPException(status_code=400, detail="hours must be positive")
+    conn = get_db_connection()
+    try:
+        cursor = conn.cursor()
+        cursor.execute("""
+            SELECT outcome, COUNT(*) AS conflicts,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY resolve_ms) AS median_resolve_ms,
+                   percentile_cont(0.5) WITHIN GROUP (ORDER BY winner_fee_delta)
+                       FILTER (WHERE winner_fee_delta IS NOT NULL) AS median_winner_fee_delta
+            FROM conflict_outcomes
+            WHERE resolved_at > NOW() - %s * INTERVAL '1 hour'
+            GROUP BY outcome
+        """, (hours,))
+        summary = cursor.fetchall()
+        cursor.execute("""
+            SELECT COUNT(*) AS open FROM conflict_outcomes WHERE outcome IS NULL
+        """)
+        open_count = cursor.fetchone()["open"]
+        cursor.execute("""
+            SELECT original_tx, replacement_tx, outcome, winner_tx, original_fee, replacement_fee,
+                   original_fee_rate, replacement_fee_rate, winner_fee_delta, detected_at,
+                   resolved_height, resolved_at, resolve_ms
+            FROM conflict_outcomes
+            WHERE resolved_at > NOW() - %s * INTERVAL '1 hour'
+            ORDER BY resolved_at DESC
+            LIMIT %s
+        """, (hours, limit))
+        rows = cursor.fetchall()
+        cursor.close()
+    finally:
+        conn.close()
+
+    resolved = sum(row["conflicts"] for row in summary)
+    replaced = sum(row["conflicts"] for row in summary if row["outcome"] == "replacement")
+    return {
+        "open": open_count,
+        "resolved": resolved,
+        "replacement_win_rate": replaced / resolved if resolved else None,
+        "outcomes": {
+            row["outcome"]: {
+                "conflicts": row["conflicts"],
+                "median_resolve_ms": row["median_resolve_ms"],
+                "median_winner_fee_delta": row["median_winner_fee_delta"],
+            }
+            for row in summary
+        },
+        "recent": [
+            {
+                "original_txid": bytes_to_txid(row["original_tx"]),
+                "replacement_txid": bytes_to_txid(row["replacement_tx"]),
+                "outcome": row["outcome"],
+                "winner_txid": bytes_to_txid(row["winner_tx"]),
+                "original_fee": row["original_fee"],
+                "replacement_fee": row["replacement_fee"],
+                "original_fee_rate": row["original_fee_rate"],
+                "replacement_fee_rate": row["replacement_fee_rate"],
+                "winner_fee_delta": row["winner_fee_delta"],
+                "detected_at": isoformat(row["detected_at"]),
+                "resolved_height": row["resolved_height"],
+                "resolved_at": isoformat(row["resolved_at"]),
+                "resolve_ms": row["resolve_ms"],
+            }
+            for row in rows
+        ],
+    }
+
+
 @app.get("/observer-location")
 async def get_observer_location():
     """Get the observer's location based on public IP address"""
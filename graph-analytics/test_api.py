@@ -69,6 +69,102 @@ def main():
     test("Geo activity", "GET", "/geo-activity")
     test("Peer locations", "GET", "/peer-locations")
 
+    # Explorer lookups
+    r = test("Block by height (unknown)", "GET", "/block/0")
+    assert r.status_code in (200, 404)
+    r = test("Block by hash (bad)", "GET", "/block/not-a-hash")
+    assert r.status_code == 400
+    if top:
+        test("Address details", "GET", f"/address/{top[0]['address']}?limit=10")
+    r = test("Address details (unknown)", "GET", "/address/1unknownaddressxxxxxxxxxxxxxxxxx")
+    assert r.status_code == 404
+
+    # Script templates
+    r = test("Script template counts", "GET", "/script-templates")
+    assert r.status_code == 200
+    r = test("Script template txs", "GET", "/script-templates/ln_to_local/txs?limit=5")
+    assert r.status_code == 200
+
+    # Observation projects
+    r = test("Projects", "GET", "/projects")
+    assert r.status_code == 200
+    projects = r.json()["projects"]
+    r = test("Project txs (unknown project)", "GET", "/projects/no-such-project/transactions")
+    assert r.status_code == 404
+    r = test("Propagation stats (unknown project)", "GET", "/propagation-stats?project=no-such-project")
+    assert r.status_code == 404
+    if projects:
+        r = test("Project txs", "GET", f"/projects/{projects[0]['name']}/transactions?hours=24&limit=10")
+        assert r.status_code == 200
+        r = test("Project propagation stats", "GET", f"/propagation-stats?project={projects[0]['name']}")
+        assert r.status_code == 200
+
+    # Observation rollups
+    r = test("Hourly rollups", "GET", "/rollups?granularity=hour&limit=24")
+    assert r.status_code == 200
+    r = test("Daily rollups (bad granularity)", "GET", "/rollups?granularity=week")
+    assert r.status_code == 400
+
+    # Transaction spend graph
+    r = test("Tx graph (bad txid)", "GET", "/tx/not-a-txid/graph")
+    assert r.status_code == 400
+    r = test("Tx graph (unknown txid)", "GET", f"/tx/{'00' * 32}/graph?depth=2")
+    assert r.status_code == 404
+
+    # Origin triangulation
+    r = test("Tx origin (unknown txid)", "GET", f"/tx/{'00' * 32}/origin")
+    assert r.status_code == 404
+
+    # Transaction journey
+    r = test("Tx journey (unknown txid)", "GET", f"/tx/{'00' * 32}/journey")
+    assert r.status_code == 404
+    r = test("Tx journey (bad limit)", "GET", f"/tx/{'00' * 32}/journey?limit=0")
+    assert r.status_code == 400
+
+    # Merkle inclusion proof
+    r = test("Tx merkle proof (unknown txid)", "GET", f"/tx/{'00' * 32}/merkle-proof")
+    assert r.status_code == 404
+    r = test("Tx merkle proof (bad txid)", "GET", "/tx/not-a-txid/merkle-proof")
+    assert r.status_code == 400
+
+    # Peer identities
+    r = test("Peer identities", "GET", "/peer-identities?min_addresses=1&limit=10")
+    assert r.status_code == 200
+
+    # Peer sessions
+    r = test("Peer sessions", "GET", "/peer-sessions?hours=24&limit=10")
+    assert r.status_code == 200
+    r = test("Peer sessions (bad hours)", "GET", "/peer-sessions?hours=0")
+    assert r.status_code == 400
+
+    # Address gossip sources
+    r = test("Gossip sources", "GET", "/gossip-sources?hours=24&limit=10")
+    assert r.status_code == 200
+    r = test("Gossip sources (bad hours)", "GET", "/gossip-sources?hours=0")
+    assert r.status_code == 400
+    r = test("Gossip sources (unknown address)", "GET", "/gossip-sources/192.0.2.1:8333")
+    assert r.status_code == 404
+
+    # Network census
+    r = test("Census snapshots", "GET", "/census?limit=5")
+    assert r.status_code == 200
+    r = test("Census snapshot (unknown)", "GET", "/census/999999999")
+    assert r.status_code == 404
+    r = test("Census snapshot (bad id)", "GET", "/census/abc")
+    assert r.status_code == 400
+
+    # Fee outlier alerts
+    r = test("Fee alerts", "GET", "/fee-alerts?hours=24&limit=10")
+    assert r.status_code == 200
+
+    # Below-minimum-fee relays
+    r = test("Low-fee relays", "GET", "/low-fee-relays?hours=24&limit=10")
+    assert r.status_code == 200
+
+    # Double-spend conflict outcomes
+    r = test("Conflict outcomes", "GET", "/conflict-outcomes?hours=168&limit=10")
+    assert r.status_code == 200
+
     print("\n=== All tests passed ===")
 
 
@@ -108,6 +108,97 @@ const endpoints = [
       }
     ]
   },
+  {
+    category: 'Explorer',
+    items: [
+      {
+        method: 'GET',
+        path: '/block/{block}',
+        description: 'Stored block by height or hash, with its transactions and first-seen timing',
+        params: [
+          { name: 'block', type: 'string', description: 'Block height, or block hash as shown by explorers' },
+          { name: 'limit', type: 'int', description: 'Transactions per page, up to 1000 (default: 100)' },
+          { name: 'offset', type: 'int', description: 'Transactions to skip (default: 0)' }
+        ],
+        example: {
+          hash: '00000000000000000002a7c4...',
+          height: 840000,
+          timestamp: '2024-04-20T00:09:27',
+          tx_count: 3050,
+          first_seen_at: '2024-04-20T00:09:31.412',
+          first_peer_addr: '203.0.113.7:8333',
+          seen_after_timestamp_ms: 4412,
+          transactions: [
+            { txid: 'a1075db5...', fee_satoshis: 5000, size_bytes: 223, first_seen_at: '2024-04-20T00:02:10', peer_count: 6, mempool_ms: 437000 }
+          ]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/address/{address}',
+        description: 'Stored totals, unspent outputs and transactions for an address',
+        params: [
+          { name: 'address', type: 'string', description: 'Bitcoin address' },
+          { name: 'limit', type: 'int', description: 'Transactions per page, up to 1000 (default: 100)' },
+          { name: 'offset', type: 'int', description: 'Transactions to skip (default: 0)' }
+        ],
+        example: {
+          address: 'bc1qxy2k...',
+          total_received: 150000,
+          total_spent: 50000,
+          balance: 100000,
+          unspent: [{ txid: '9f2c...', output_index: 1, value_satoshis: 100000 }],
+          transactions: [{ txid: '9f2c...', received: 100000, sent: 0, block_height: 840001, first_seen_at: '2024-04-20T00:15:02' }]
+        }
+      }
+    ]
+  },
+  {
+    category: 'Transaction Tracing',
+    items: [
+      {
+        method: 'GET',
+        path: '/tx/{txid}/graph',
+        description: 'Spend graph around a transaction: the txs it spends from and the txs spending it',
+        params: [
+          { name: 'txid', type: 'string', description: 'Transaction id as shown by block explorers' },
+          { name: 'depth', type: 'int', description: 'Hops to follow in each direction, 1-10 (default: 3)' },
+          { name: 'direction', type: 'string', description: 'ancestors, descendants or both (default: both)' }
+        ],
+        example: {
+          txid: 'f4184fc5...',
+          direction: 'both',
+          depth: 3,
+          truncated: false,
+          nodes: [
+            { txid: '0437cd7f...', distance: -1, known: true, block_height: 9, fee_satoshis: null },
+            { txid: 'f4184fc5...', distance: 0, known: true, block_height: 170, fee_satoshis: 0 }
+          ],
+          edges: [
+            { from: '0437cd7f...', to: 'f4184fc5...', output_index: 0, input_index: 0, value_satoshis: 5000000000, address: '12cbQLTF...' }
+          ]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/tx/{txid}/origin',
+        description: 'Estimated origin triangulated from several observer instances\' first-seen times',
+        params: [{ name: 'txid', type: 'string', description: 'Transaction id as shown by block explorers' }],
+        example: {
+          txid: 'f4184fc5...',
+          latitude: 51.5,
+          longitude: -0.5,
+          confidence: 0.41,
+          residual_ms: 84.2,
+          observer_count: 4,
+          estimated_at: '2024-04-20T00:05:12',
+          vantages: [
+            { observer_id: 'fra-1', latitude: 50.11, longitude: 8.68, first_seen_at: '2024-04-20T00:02:10.114' }
+          ]
+        }
+      }
+    ]
+  },
   {
     category: 'Network Intelligence',
     items: [
@@ -130,6 +221,222 @@ const endpoints = [
             { region: 'europe', observation_count: 5000, avg_delay_ms: 150, min_delay_ms: 0, max_delay_ms: 2000 }
           ]
         }
+      },
+      {
+        method: 'GET',
+        path: '/propagation/{group}',
+        description: 'Delay percentiles from first announcement and first-seen share per country or region',
+        params: [
+          { name: 'group', type: 'string', description: 'country or region' },
+          { name: 'kind', type: 'string', description: 'tx or block (default: tx)' },
+          { name: 'hours', type: 'int', description: 'Announcements from the last hours (default: 24)' },
+          { name: 'observer', type: 'string', description: 'Only this observer instance\'s announcements' }
+        ],
+        example: {
+          kind: 'tx',
+          hours: 24,
+          items: 412000,
+          groups: [
+            { country: 'DE', announcements: 2210000, items: 398000, peers: 8, first_seen: 61200, first_seen_share: 0.149, p50_delay_ms: 412, p90_delay_ms: 2310, p99_delay_ms: 8120 }
+          ]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/propagation/{group}/deltas',
+        description: 'Delay percentiles between pairs of countries or regions, from each one\'s first sighting of the same transactions',
+        params: [
+          { name: 'group', type: 'string', description: 'country or region' },
+          { name: 'hours', type: 'int', description: 'Sightings from the last hours (default: 24)' },
+          { name: 'observer', type: 'string', description: 'Only this observer instance\'s sightings' },
+          { name: 'min_txs', type: 'int', description: 'Leave out pairs with fewer shared transactions (default: 100)' }
+        ],
+        example: {
+          hours: 24,
+          pairs: [
+            { from: 'DE', to: 'US', txs: 188000, from_first_share: 0.57, p50_delay_ms: 38, p90_delay_ms: 610, p99_delay_ms: 3900 }
+          ]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/rollups',
+        description: 'Precomputed hourly or daily tx, fee, propagation and peer rollups, newest first',
+        params: [
+          { name: 'granularity', type: 'string', description: 'hour or day (default: hour)' },
+          { name: 'region', type: 'string', description: 'Peer region, or all (default: all)' },
+          { name: 'limit', type: 'int', description: 'Buckets to return, up to 1000 (default: 48)' }
+        ],
+        example: {
+          granularity: 'hour',
+          region: 'all',
+          buckets: [
+            { bucket_start: '2024-04-20T00:00:00', tx_count: 14210, total_fees: 98213450, median_fee_rate: 12.4, announcement_count: 120331, median_propagation_ms: 840, p90_propagation_ms: 4120, peer_count: 31, updated_at: '2024-04-20T00:55:02' }
+          ]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/versionbits',
+        description: 'Version-bits signaling per bit over the last 2016 blocks and the current retarget period',
+        params: [
+          { name: 'bit', type: 'int', description: 'Also return this bit\'s rolling-window history' },
+          { name: 'limit', type: 'int', description: 'History entries, up to 1000 (default: 144)' }
+        ],
+        example: {
+          window: { tip_height: 840120, recorded_at: '2024-04-20T12:01:44', bits: [{ bit: 2, signaling_blocks: 1512, total_blocks: 2016, signaling_pct: 75.0 }] },
+          period: { period_start: 838656, updated_at: '2024-04-20T12:01:44', bits: [{ bit: 2, signaling_blocks: 1101, total_blocks: 1465, signaling_pct: 75.15 }] }
+        }
+      },
+      {
+        method: 'GET',
+        path: '/spend-types',
+        description: 'Inputs per spend type, classified from their scriptSig and witness, with the multisig thresholds revealed',
+        params: [
+          { name: 'hours', type: 'int', description: 'Transactions recorded in the last hours (default: 24)' }
+        ],
+        example: {
+          hours: 24,
+          inputs: 1184220,
+          spend_types: [{ spend_type: 'p2wpkh', inputs: 702113, tx_count: 301877, share: 0.593 }],
+          multisig: [{ spend_type: 'p2wsh', multisig: '2-of-3', inputs: 20417, tx_count: 9120 }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/block-ordering',
+        description: 'Per-block ordering entropy against package fee-rate order, with transactions placed ahead of much better paying ones',
+        params: [
+          { name: 'miner', type: 'string', description: 'Only this miner\'s blocks' },
+          { name: 'hours', type: 'int', description: 'Time window (default: 24)' },
+          { name: 'limit', type: 'int', description: 'Blocks listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          blocks: [{ hash: '00000000000000000001a2b3...', height: 840000, miner: 'Foundry USA', txs: 3049, fee_known_txs: 3011, inversions: 41210, entropy: 0.0091, prioritized: 3, prioritized_txs: ['9f1c...'] }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/miner-ordering',
+        description: 'Ordering entropy and prioritized transactions summarized per miner, most fee-ordered first',
+        params: [
+          { name: 'hours', type: 'int', description: 'Time window (default: 168)' }
+        ],
+        example: {
+          hours: 168,
+          miners: [{ miner: 'AntPool', blocks: 212, avg_entropy: 0.0113, median_entropy: 0.0087, max_entropy: 0.0921, prioritized: 64 }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/feature-adoption',
+        description: 'Daily share of distinct peers negotiating each feature with us: sendheaders, compact blocks v2, wtxidrelay and addrv2',
+        params: [
+          { name: 'days', type: 'int', description: 'Time window (default: 30)' },
+          { name: 'region', type: 'string', description: 'Only peers in this region' }
+        ],
+        example: {
+          days: 30,
+          adoption: [{ day: '2024-04-20', peers: 412, sendheaders: 0.9612, cmpct_v2: 0.9587, wtxidrelay: 0.9345, addrv2: 0.9102 }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/peer-traffic',
+        description: 'Bytes and messages sent to and received from peers, summed by region, peer or message type, heaviest first',
+        params: [
+          { name: 'hours', type: 'int', description: 'Time window (default: 24)' },
+          { name: 'group', type: 'string', description: 'region, peer or command (default: region)' },
+          { name: 'region', type: 'string', description: 'Only peers in this region' },
+          { name: 'limit', type: 'int', description: 'Rows listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          hours: 24,
+          group: 'region',
+          traffic: [{ region: 'DE', peers: 8, bytes_sent: 48211904, bytes_received: 2310457344, messages_sent: 412870, messages_received: 1893310 }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/claim-reports',
+        description: 'Peers\' claim reports: what each claimed, how it behaved and where the two diverged, newest first',
+        params: [
+          { name: 'hours', type: 'int', description: 'Time window (default: 24)' },
+          { name: 'peer', type: 'string', description: 'Only this peer\'s reports' },
+          { name: 'excluded', type: 'bool', description: 'Only reports with the peer excluded (true) or not (false)' },
+          { name: 'limit', type: 'int', description: 'Reports listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          hours: 24,
+          reports: [{ peer: '203.0.113.9:8333', observer: 'obs-eu-1', region: 'DE', reported_at: '2024-04-20T12:00:00', services: 1033, start_height: 841210, best_height: 841207, fee_filter: 1000, blocks_requested: 12, blocks_served: 2, blocks_not_found: 10, headers_asked: 6, headers_answered: 6, txs_relayed: 3120, txs_below_filter: 0, divergences: ['blocks_not_served'], streak: 3, clean_streak: 0, excluded: true }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/peer-scores',
+        description: 'Peers\' saved penalty scores, counts by kind and ban reasons, highest penalty first',
+        params: [
+          { name: 'banned', type: 'bool', description: 'Only banned (true) or unbanned (false) peers' },
+          { name: 'observer', type: 'string', description: 'Only this observer\'s scores' },
+          { name: 'limit', type: 'int', description: 'Peers listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          peers: [{ peer: '198.51.100.4:8333', observer: 'obs-eu-1', country: 'NL', user_agent: '/Satoshi:26.0.0/', penalty: 104.2, counts: { short_session: 4, dial: 2 }, ban_reason: 'penalty score (short_session)', failed_at: '2024-04-20T11:58:10', updated_at: '2024-04-20T12:00:00' }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/region-suggestions',
+        description: 'Countries and ASes with nodes seen but no connected peer, ranked as places to add a vantage point',
+        params: [
+          { name: 'kind', type: 'string', description: 'country or asn (default: both)' },
+          { name: 'observer', type: 'string', description: 'Only this observer\'s suggestions' }
+        ],
+        example: {
+          suggestions: [{ kind: 'country', key: 'CL', observer: 'obs-eu-1', country: 'CL', org: null, rank: 1, nodes: 14, reachable: 9, heard: 212, asns: 6, auto_added: false, computed_at: '2024-04-20T12:00:00' }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/peer-latency',
+        description: 'Per-peer latency profiles: median and p90 delay behind the first announcement, slowest first',
+        params: [
+          { name: 'kind', type: 'string', description: 'tx or block (default: tx)' },
+          { name: 'observer', type: 'string', description: 'Only this observer\'s profiles' },
+          { name: 'min_samples', type: 'int', description: 'Skip peers with fewer samples (default: 100)' },
+          { name: 'limit', type: 'int', description: 'Peers listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          kind: 'tx',
+          peers: [{ peer: '203.0.113.7:8333', observer: 'obs-eu-1', country: 'BR', region: 'BR', user_agent: '/Satoshi:27.0.0/', samples: 9412, median_ms: 2310, p90_ms: 6870, updated_at: '2024-04-20T12:00:00' }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/relay-probes',
+        description: 'Relay policy measured with probe transactions on a test network, per variant and per probe',
+        params: [
+          { name: 'hours', type: 'int', description: 'Probes sent in the last hours (default: 168)' },
+          { name: 'limit', type: 'int', description: 'Probes listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          variants: [{ variant: 'data200-2', output_type: 'bech32', fee_rate: 2, data_bytes: 200, probes: 36, relayed: 31, relayed_share: 0.861, avg_relay_peers: 5.2, p50_first_relay_ms: 2140, p50_median_relay_ms: 6020 }],
+          probes: [{ txid: '3c1e0f6a...', variant: 'data200-2', fee_rate: 2, vsize: 352, output_type: 'bech32', data_bytes: 200, first_hop: '203.0.113.7:38333', sent_at: '2024-04-20T12:00:00', observer: 'obs-eu-1', relay_peers: 6, first_relay_ms: 1980, median_relay_ms: 5110 }]
+        }
+      },
+      {
+        method: 'GET',
+        path: '/locktimes',
+        description: 'Transactions seen before their locktime expired, and how soon after expiring they confirmed',
+        params: [
+          { name: 'hours', type: 'int', description: 'Window by first seen time (default: 168)' },
+          { name: 'kind', type: 'string', description: 'height or time (default: both)' },
+          { name: 'limit', type: 'int', description: 'Transactions listed, up to 1000 (default: 100)' }
+        ],
+        example: {
+          kinds: [{ kind: 'height', txs: 42, valid: 40, confirmed: 37, confirmed_first_block: 21, p50_delay_blocks: 0, p90_delay_blocks: 4.4, p50_lead_blocks: 3 }],
+          transactions: [{ txid: 'b6f6991d...', kind: 'height', locktime: 840125, seen_tip_height: 840120, first_seen_at: '2024-04-20T11:20:03', valid_height: 840126, valid_at: '2024-04-20T12:01:44', confirmed_height: 840126, confirmed_at: '2024-04-20T12:09:12', delay_blocks: 0 }]
+        }
       }
     ]
   }
@@ -232,7 +539,7 @@ export default function ApiDocs() {
                         <code className="block bg-white border border-black/10 p-3 text-sm text-gray-700 overflow-x-auto font-mono">
                           curl {endpoint.method !== 'GET' ? `-X ${endpoint.method} ` : ''}
                           {endpoint.body ? `-H "Content-Type: application/json" -d '${JSON.stringify(endpoint.body)}' ` : ''}
-                          {API_BASE}{endpoint.path.replace('{address}', '1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa')}
+                          {API_BASE}{endpoint.path.replace('{address}', '1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa').replace('{txid}', 'f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16').replace('{block}', '840000')}
                         </code>
                       </div>
                     </div>